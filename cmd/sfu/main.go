@@ -23,11 +23,15 @@ import (
 	"os/signal"
 	"syscall"
 
+	"github.com/matrix-org/waterfall/pkg/admin"
+	"github.com/matrix-org/waterfall/pkg/conference"
 	"github.com/matrix-org/waterfall/pkg/config"
+	"github.com/matrix-org/waterfall/pkg/metrics"
 	"github.com/matrix-org/waterfall/pkg/profiling"
 	"github.com/matrix-org/waterfall/pkg/routing"
 	"github.com/matrix-org/waterfall/pkg/signaling"
 	"github.com/matrix-org/waterfall/pkg/telemetry"
+	"github.com/matrix-org/waterfall/pkg/webhook"
 	"github.com/matrix-org/waterfall/pkg/webrtc_ext"
 	"github.com/sirupsen/logrus"
 	"maunium.net/go/mautrix/event"
@@ -52,23 +56,8 @@ func main() {
 		return
 	}
 
-	switch config.LogLevel {
-	case "trace":
-		logrus.SetLevel(logrus.TraceLevel)
-	case "debug":
-		logrus.SetLevel(logrus.DebugLevel)
-	case "info", "": // default to info level if unset
-		logrus.SetLevel(logrus.InfoLevel)
-	case "warn":
-		logrus.SetLevel(logrus.WarnLevel)
-	case "error":
-		logrus.SetLevel(logrus.ErrorLevel)
-	case "fatal":
-		logrus.SetLevel(logrus.FatalLevel)
-	case "panic":
-		logrus.SetLevel(logrus.PanicLevel)
-	default:
-		logrus.Fatalf("unrecognised log level: %s", config.LogLevel)
+	if err := config.ApplyLogLevel(); err != nil {
+		logrus.WithError(err).Fatal("could not set log level")
 	}
 
 	// Define functions that are called before exiting.
@@ -81,6 +70,9 @@ func main() {
 		deferred_functions = append(deferred_functions, profiling.InitMemoryProfiling(memProfile))
 	}
 
+	// Serve the pprof endpoints for live profiling (if explicitly enabled).
+	profiling.ServePprof(config.Profiling)
+
 	// Set up telemetry (if any).
 	if telemetry, err := telemetry.SetupTelemetry(config.Telemetry); err != nil {
 		logrus.WithError(err).Warn("could not set up telemetry")
@@ -93,6 +85,9 @@ func main() {
 		deferred_functions = append(deferred_functions, telemetry_cleanup)
 	}
 
+	// Serve the metrics (if enabled).
+	metrics.Serve(config.Metrics)
+
 	// Handle signal interruptions.
 	c := make(chan os.Signal, 2)
 	signal.Notify(c, os.Interrupt, syscall.SIGTERM)
@@ -114,12 +109,61 @@ func main() {
 		return
 	}
 
+	// Make sure that the clients would be able to connect before accepting any calls (if enabled).
+	if config.WebRTC.SelfTest.Enabled {
+		if err := connectionFactory.SelfTest(config.WebRTC.SelfTest); err != nil {
+			logrus.WithError(err).Fatal("could not connect to itself")
+			return
+		}
+		logrus.Info("WebRTC self-test passed")
+	}
+
 	// Create a channel which we'll use to send events to the router.
 	matrixEvents := make(chan *event.Event)
 	defer close(matrixEvents)
 
+	// Create a notifier for the conference events (if configured).
+	webhooks := webhook.NewNotifier(config.Webhook)
+	defer webhooks.Stop()
+
+	// Create a channel which we'll use to inform the router about the config changes.
+	configUpdates := make(chan conference.Config)
+
+	// Create a channel which we'll use to pass the requests of the admin API to the router.
+	adminRequests := make(chan routing.AdminRequest)
+
 	// Start a router that will receive events from the matrix client and route them to the appropriate conference.
-	routing.StartRouter(matrixClient, connectionFactory, matrixEvents, config.Conference)
+	routing.StartRouter(
+		matrixClient,
+		connectionFactory,
+		matrixEvents,
+		configUpdates,
+		adminRequests,
+		webhooks,
+		config.Conference,
+	)
+
+	// Serve the admin API (if explicitly enabled).
+	admin.Serve(config.Admin, adminRequests)
+
+	// Reload the config on SIGHUP. The reloaded config is only owned by this goroutine, the router gets
+	// its own copy of the conference settings.
+	reload := make(chan os.Signal, 1)
+	signal.Notify(reload, syscall.SIGHUP)
+	current := config
+	go func() {
+		for range reload {
+			reloaded, err := current.Reload(*configFilePath)
+			if err != nil {
+				logrus.WithError(err).Error("could not reload config")
+				continue
+			}
+
+			current = reloaded
+			configUpdates <- reloaded.Conference
+			logrus.Info("config reloaded")
+		}
+	}()
 
 	// Start matrix client sync. This function will block until the sync fails.
 	if err := matrixClient.RunSync(func(e *event.Event) { matrixEvents <- e }); err != nil {
@@ -0,0 +1,320 @@
+package admin
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	conf "github.com/matrix-org/waterfall/pkg/conference"
+	"github.com/matrix-org/waterfall/pkg/conference/subscription"
+	"github.com/matrix-org/waterfall/pkg/routing"
+	"github.com/sirupsen/logrus"
+	"maunium.net/go/mautrix/id"
+)
+
+// Returned by the capture handler when the file for the packets could not be created.
+var errCaptureFile = errors.New("failed to create capture file")
+
+// Starts serving the admin API if the address is configured.
+func Serve(config Config, requests chan<- routing.AdminRequest) {
+	if config.Address == "" {
+		return
+	}
+
+	go func() {
+		logrus.WithField("address", config.Address).Warn("serving admin API")
+		if err := http.ListenAndServe(config.Address, newServeMux(config, requests)); err != nil { //nolint:gosec
+			logrus.WithError(err).Error("admin server stopped")
+		}
+	}()
+}
+
+// Creates the handler of all admin endpoints that forwards the requests to the router.
+func newServeMux(config Config, requests chan<- routing.AdminRequest) *http.ServeMux {
+	mux := http.NewServeMux()
+	mux.Handle("/admin/capture", newCaptureHandler(config, requests))
+	mux.Handle("/admin/keyframes", newKeyFramesHandler(requests))
+	mux.Handle("/admin/bitrates", newBitratesHandler(requests))
+	mux.Handle("/admin/drain", newDrainHandler(requests))
+	mux.Handle("/admin/mute", newMuteHandler(requests))
+	mux.Handle("/admin/events", newEventsHandler(requests))
+	mux.Handle("/admin/candidates", newCandidatesHandler(requests))
+	mux.Handle("/admin/ssrcs", newSSRCsHandler(requests))
+
+	return mux
+}
+
+// An admin endpoint that sends a request to a conference (or to the router) and responds with its outcome.
+type endpoint[Request conf.AdminRequest, Result any] struct {
+	// The accepted HTTP methods.
+	methods []string
+	// The query parameters that must be set. The request is sent to the conference from `conf_id`.
+	required []string
+	// Creates the request from the HTTP request. The errors are responded to as bad requests.
+	parse func(r *http.Request, result chan<- Result) (Request, error)
+	// Returns what to respond with once the request is handled: a string as is and anything else as JSON.
+	respond func(request Request, outcome Result) (any, error)
+}
+
+// Creates the handler of a given endpoint.
+func handle[Request conf.AdminRequest, Result any](
+	requests chan<- routing.AdminRequest,
+	e endpoint[Request, Result],
+) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !allowed(e.methods, r.Method) {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		query := r.URL.Query()
+		for _, name := range e.required {
+			if query.Get(name) == "" {
+				http.Error(w, requiredMessage(e.required), http.StatusBadRequest)
+				return
+			}
+		}
+
+		result := make(chan Result, 1)
+		request, err := e.parse(r, result)
+		if err != nil {
+			http.Error(w, err.Error(), statusOf(err, http.StatusBadRequest))
+			return
+		}
+
+		requests <- routing.AdminRequest{ConferenceID: query.Get("conf_id"), Request: request}
+
+		response, err := e.respond(request, <-result)
+		if err != nil {
+			http.Error(w, err.Error(), statusOf(err, http.StatusNotFound))
+			return
+		}
+
+		if text, ok := response.(string); ok {
+			fmt.Fprintln(w, text)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(response); err != nil {
+			logrus.WithError(err).Warnf("failed to respond to %s", r.URL.Path)
+		}
+	}
+}
+
+// Checks whether a given HTTP method is one of the allowed ones.
+func allowed(methods []string, method string) bool {
+	for _, allowed := range methods {
+		if method == allowed {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Lists the required query parameters, e.g. "conf_id, user_id and device_id are required".
+func requiredMessage(required []string) string {
+	if len(required) == 1 {
+		return required[0] + " is required"
+	}
+
+	last := len(required) - 1
+	return strings.Join(required[:last], ", ") + " and " + required[last] + " are required"
+}
+
+// Returns the status code of the response to a request that failed with a given error.
+func statusOf(err error, otherwise int) int {
+	switch {
+	case errors.Is(err, conf.ErrKeyFramesRateLimited):
+		return http.StatusTooManyRequests
+	case errors.Is(err, errCaptureFile):
+		return http.StatusInternalServerError
+	default:
+		return otherwise
+	}
+}
+
+// Starts a capture of the RTP packets of a track forwarded to a given subscriber, e.g.
+// `POST /admin/capture?conf_id=...&track_id=...&user_id=...&device_id=...&duration=10`.
+// Responds with the path of the pcap file once the capture has started.
+func newCaptureHandler(config Config, requests chan<- routing.AdminRequest) http.HandlerFunc {
+	return handle(requests, endpoint[conf.CaptureRequested, error]{
+		methods:  []string{http.MethodPost},
+		required: []string{"conf_id", "track_id", "user_id", "device_id"},
+		parse: func(r *http.Request, result chan<- error) (conf.CaptureRequested, error) {
+			query := r.URL.Query()
+
+			duration := config.maxCaptureDuration()
+			if rawDuration := query.Get("duration"); rawDuration != "" {
+				requested, err := strconv.Atoi(rawDuration)
+				if err != nil || requested <= 0 {
+					return conf.CaptureRequested{}, errors.New("invalid duration")
+				}
+
+				if requested < duration {
+					duration = requested
+				}
+			}
+
+			file, err := os.CreateTemp(config.CaptureDirectory, "capture-*.pcap")
+			if err != nil {
+				return conf.CaptureRequested{}, fmt.Errorf("%w: %v", errCaptureFile, err)
+			}
+
+			return conf.CaptureRequested{
+				TrackID:  query.Get("track_id"),
+				UserID:   id.UserID(query.Get("user_id")),
+				DeviceID: id.DeviceID(query.Get("device_id")),
+				Capture: subscription.CaptureConfig{
+					Output:   file,
+					Duration: time.Duration(duration) * time.Second,
+					MaxSize:  config.maxCaptureSize() << 20,
+				},
+				Result: result,
+			}, nil
+		},
+		respond: func(request conf.CaptureRequested, err error) (any, error) {
+			// The file is closed by whoever handled the request, even if it failed.
+			path := request.Capture.Output.(*os.File).Name() //nolint:forcetypeassert
+			if err != nil {
+				os.Remove(path)
+				return nil, err
+			}
+
+			logrus.WithField("path", path).Warnf("capturing %s for %s", request.TrackID, request.Capture.Duration)
+			return path, nil
+		},
+	})
+}
+
+// Requests a key frame from all publishers of a conference, e.g. `POST /admin/keyframes?conf_id=...`.
+// Responds with the amount of requested key frames. The requests are rate-limited per conference.
+func newKeyFramesHandler(requests chan<- routing.AdminRequest) http.HandlerFunc {
+	return handle(requests, endpoint[conf.KeyFramesRequested, conf.KeyFramesResult]{
+		methods:  []string{http.MethodPost},
+		required: []string{"conf_id"},
+		parse: func(_ *http.Request, result chan<- conf.KeyFramesResult) (conf.KeyFramesRequested, error) {
+			return conf.KeyFramesRequested{Result: result}, nil
+		},
+		respond: func(_ conf.KeyFramesRequested, outcome conf.KeyFramesResult) (any, error) {
+			return outcome.Requested, outcome.Err
+		},
+	})
+}
+
+// Returns the incoming bitrate of each layer of the published video tracks of a conference as JSON,
+// e.g. `GET /admin/bitrates?conf_id=...`.
+func newBitratesHandler(requests chan<- routing.AdminRequest) http.HandlerFunc {
+	return handle(requests, endpoint[conf.BitratesRequested, conf.BitratesResult]{
+		methods:  []string{http.MethodGet},
+		required: []string{"conf_id"},
+		parse: func(_ *http.Request, result chan<- conf.BitratesResult) (conf.BitratesRequested, error) {
+			return conf.BitratesRequested{Result: result}, nil
+		},
+		respond: func(_ conf.BitratesRequested, outcome conf.BitratesResult) (any, error) {
+			return outcome.Tracks, outcome.Err
+		},
+	})
+}
+
+// Mutes (or unmutes) the audio of a participant for everyone, e.g.
+// `POST /admin/mute?conf_id=...&user_id=...&device_id=...&muted=true`. The SFU stops forwarding the audio
+// regardless of whether the participant unmutes itself. Responds with the amount of affected audio tracks.
+func newMuteHandler(requests chan<- routing.AdminRequest) http.HandlerFunc {
+	return handle(requests, endpoint[conf.MuteRequested, conf.MuteResult]{
+		methods:  []string{http.MethodPost},
+		required: []string{"conf_id", "user_id", "device_id"},
+		parse: func(r *http.Request, result chan<- conf.MuteResult) (conf.MuteRequested, error) {
+			query := r.URL.Query()
+
+			muted, err := strconv.ParseBool(query.Get("muted"))
+			if err != nil {
+				return conf.MuteRequested{}, errors.New("muted must be true or false")
+			}
+
+			return conf.MuteRequested{
+				UserID:   id.UserID(query.Get("user_id")),
+				DeviceID: id.DeviceID(query.Get("device_id")),
+				Muted:    muted,
+				Result:   result,
+			}, nil
+		},
+		respond: func(_ conf.MuteRequested, outcome conf.MuteResult) (any, error) {
+			return outcome.Tracks, outcome.Err
+		},
+	})
+}
+
+// Returns the recent significant events of a conference (the oldest first) as JSON, e.g.
+// `GET /admin/events?conf_id=...`. Only the last few events are kept, see `eventLogSize` of the profile.
+func newEventsHandler(requests chan<- routing.AdminRequest) http.HandlerFunc {
+	return handle(requests, endpoint[conf.EventLogRequested, conf.EventLogResult]{
+		methods:  []string{http.MethodGet},
+		required: []string{"conf_id"},
+		parse: func(_ *http.Request, result chan<- conf.EventLogResult) (conf.EventLogRequested, error) {
+			return conf.EventLogRequested{Result: result}, nil
+		},
+		respond: func(_ conf.EventLogRequested, outcome conf.EventLogResult) (any, error) {
+			return outcome.Events, outcome.Err
+		},
+	})
+}
+
+// Returns the selected ICE candidate pair (`host`, `srflx`, `prflx` or `relay` candidates and their addresses)
+// of each connected participant of a conference as JSON, e.g. `GET /admin/candidates?conf_id=...`.
+func newCandidatesHandler(requests chan<- routing.AdminRequest) http.HandlerFunc {
+	return handle(requests, endpoint[conf.CandidatePairsRequested, conf.CandidatePairsResult]{
+		methods:  []string{http.MethodGet},
+		required: []string{"conf_id"},
+		parse: func(_ *http.Request, result chan<- conf.CandidatePairsResult) (conf.CandidatePairsRequested, error) {
+			return conf.CandidatePairsRequested{Result: result}, nil
+		},
+		respond: func(_ conf.CandidatePairsRequested, outcome conf.CandidatePairsResult) (any, error) {
+			return outcome.Participants, outcome.Err
+		},
+	})
+}
+
+// Returns the SSRCs of the packets that each subscriber receives along with the publisher, the SSRC and
+// the simulcast layer of the packets that they're forwarded from as JSON, e.g. `GET /admin/ssrcs?conf_id=...`.
+func newSSRCsHandler(requests chan<- routing.AdminRequest) http.HandlerFunc {
+	return handle(requests, endpoint[conf.SSRCMappingsRequested, conf.SSRCMappingsResult]{
+		methods:  []string{http.MethodGet},
+		required: []string{"conf_id"},
+		parse: func(_ *http.Request, result chan<- conf.SSRCMappingsResult) (conf.SSRCMappingsRequested, error) {
+			return conf.SSRCMappingsRequested{Result: result}, nil
+		},
+		respond: func(_ conf.SSRCMappingsRequested, outcome conf.SSRCMappingsResult) (any, error) {
+			return outcome.Mappings, outcome.Err
+		},
+	})
+}
+
+// Stops (or resumes) accepting new conferences, e.g. `POST /admin/drain?enabled=true` before a rolling deploy.
+// The running conferences are kept. Responds with whether the SFU is draining, which `GET /admin/drain` queries.
+func newDrainHandler(requests chan<- routing.AdminRequest) http.HandlerFunc {
+	return handle(requests, endpoint[routing.DrainRequested, routing.DrainResult]{
+		methods: []string{http.MethodGet, http.MethodPost},
+		parse: func(r *http.Request, result chan<- routing.DrainResult) (routing.DrainRequested, error) {
+			if r.Method == http.MethodGet {
+				return routing.DrainRequested{Result: result}, nil
+			}
+
+			enabled, err := strconv.ParseBool(r.URL.Query().Get("enabled"))
+			if err != nil {
+				return routing.DrainRequested{}, errors.New("enabled must be true or false")
+			}
+
+			return routing.DrainRequested{Drain: &enabled, Result: result}, nil
+		},
+		respond: func(_ routing.DrainRequested, outcome routing.DrainResult) (any, error) {
+			return outcome.Draining, outcome.Err
+		},
+	})
+}
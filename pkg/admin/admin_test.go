@@ -0,0 +1,262 @@
+package admin //nolint:testpackage
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	conf "github.com/matrix-org/waterfall/pkg/conference"
+	"github.com/matrix-org/waterfall/pkg/routing"
+)
+
+const conferenceID = "conference"
+
+// Serves the admin API with a fake router that only runs the conference with `conferenceID`. The requests
+// that reach that conference (or concern the router itself) are passed to `handle`.
+func newTestServer(t *testing.T, config Config, handle func(conf.AdminRequest)) *httptest.Server {
+	t.Helper()
+
+	requests := make(chan routing.AdminRequest)
+	go func() {
+		for request := range requests {
+			if _, drain := request.Request.(routing.DrainRequested); !drain && request.ConferenceID != conferenceID {
+				request.Request.Fail(fmt.Errorf("conference %s not found", request.ConferenceID))
+				continue
+			}
+
+			handle(request.Request)
+		}
+	}()
+
+	server := httptest.NewServer(newServeMux(config, requests))
+	t.Cleanup(func() {
+		server.Close()
+		close(requests)
+	})
+
+	return server
+}
+
+// Sends a request to the admin API and returns the status code and the body of the response.
+func send(t *testing.T, server *httptest.Server, method, path string) (int, string) {
+	t.Helper()
+
+	request, err := http.NewRequest(method, server.URL+path, nil) //nolint:noctx
+	if err != nil {
+		t.Fatalf("Failed to create request: %v", err)
+	}
+
+	response, err := server.Client().Do(request)
+	if err != nil {
+		t.Fatalf("Failed to send %s %s: %v", method, path, err)
+	}
+	defer response.Body.Close()
+
+	body := new(strings.Builder)
+	if _, err := body.ReadFrom(response.Body); err != nil {
+		t.Fatalf("Failed to read the response: %v", err)
+	}
+
+	return response.StatusCode, body.String()
+}
+
+func TestUnknownConference(t *testing.T) {
+	server := newTestServer(t, Config{}, func(request conf.AdminRequest) {
+		t.Errorf("Unexpected request: %+v", request)
+	})
+
+	for _, path := range []string{
+		"/admin/keyframes?conf_id=unknown",
+		"/admin/mute?conf_id=unknown&user_id=@alice:example.org&device_id=ALICE&muted=true",
+	} {
+		status, body := send(t, server, http.MethodPost, path)
+		if status != http.StatusNotFound || !strings.Contains(body, "conference unknown not found") {
+			t.Errorf("%s: expected the conference not to be found, got %d %q", path, status, body)
+		}
+	}
+
+	status, body := send(t, server, http.MethodGet, "/admin/ssrcs?conf_id=unknown")
+	if status != http.StatusNotFound || !strings.Contains(body, "conference unknown not found") {
+		t.Errorf("Expected the conference not to be found, got %d %q", status, body)
+	}
+}
+
+func TestInvalidRequests(t *testing.T) {
+	server := newTestServer(t, Config{}, func(request conf.AdminRequest) {
+		t.Errorf("Unexpected request: %+v", request)
+	})
+
+	cases := []struct {
+		method string
+		path   string
+		status int
+		body   string
+	}{
+		{http.MethodGet, "/admin/mute?conf_id=conference", http.StatusMethodNotAllowed, "method not allowed"},
+		{http.MethodPost, "/admin/events?conf_id=conference", http.StatusMethodNotAllowed, "method not allowed"},
+		{http.MethodDelete, "/admin/drain", http.StatusMethodNotAllowed, "method not allowed"},
+		{http.MethodGet, "/admin/bitrates", http.StatusBadRequest, "conf_id is required"},
+		{
+			http.MethodPost,
+			"/admin/mute?conf_id=conference&user_id=@alice:example.org",
+			http.StatusBadRequest,
+			"conf_id, user_id and device_id are required",
+		},
+		{
+			http.MethodPost,
+			"/admin/mute?conf_id=conference&user_id=@alice:example.org&device_id=ALICE&muted=maybe",
+			http.StatusBadRequest,
+			"muted must be true or false",
+		},
+		{http.MethodPost, "/admin/drain?enabled=maybe", http.StatusBadRequest, "enabled must be true or false"},
+	}
+
+	for _, c := range cases {
+		status, body := send(t, server, c.method, c.path)
+		if status != c.status || strings.TrimSpace(body) != c.body {
+			t.Errorf("%s %s: expected %d %q, got %d %q", c.method, c.path, c.status, c.body, status, body)
+		}
+	}
+}
+
+func TestMute(t *testing.T) {
+	server := newTestServer(t, Config{}, func(request conf.AdminRequest) {
+		mute, ok := request.(conf.MuteRequested)
+		if !ok || mute.UserID != "@alice:example.org" || mute.DeviceID != "ALICE" {
+			t.Errorf("Unexpected request: %+v", request)
+			request.Fail(errors.New("unexpected request"))
+			return
+		}
+
+		if mute.Muted {
+			mute.Result <- conf.MuteResult{Tracks: 2}
+		} else {
+			mute.Result <- conf.MuteResult{Tracks: 1}
+		}
+	})
+
+	path := "/admin/mute?conf_id=conference&user_id=@alice:example.org&device_id=ALICE&muted="
+	if status, body := send(t, server, http.MethodPost, path+"true"); status != http.StatusOK || body != "2\n" {
+		t.Errorf("Expected the 2 tracks to be muted, got %d %q", status, body)
+	}
+	if status, body := send(t, server, http.MethodPost, path+"false"); status != http.StatusOK || body != "1\n" {
+		t.Errorf("Expected the track to be unmuted, got %d %q", status, body)
+	}
+}
+
+func TestKeyFramesRateLimited(t *testing.T) {
+	server := newTestServer(t, Config{}, func(request conf.AdminRequest) {
+		request.Fail(fmt.Errorf("%w (1s ago)", conf.ErrKeyFramesRateLimited))
+	})
+
+	status, _ := send(t, server, http.MethodPost, "/admin/keyframes?conf_id=conference")
+	if status != http.StatusTooManyRequests {
+		t.Errorf("Expected the request to be rate-limited, got %d", status)
+	}
+}
+
+func TestDrain(t *testing.T) {
+	draining := false
+	server := newTestServer(t, Config{}, func(request conf.AdminRequest) {
+		drain, ok := request.(routing.DrainRequested)
+		if !ok {
+			t.Errorf("Unexpected request: %+v", request)
+			request.Fail(errors.New("unexpected request"))
+			return
+		}
+
+		if drain.Drain != nil {
+			draining = *drain.Drain
+		}
+		drain.Result <- routing.DrainResult{Draining: draining}
+	})
+
+	steps := []struct {
+		method string
+		path   string
+		body   string
+	}{
+		{http.MethodGet, "/admin/drain", "false\n"},
+		{http.MethodPost, "/admin/drain?enabled=true", "true\n"},
+		{http.MethodGet, "/admin/drain", "true\n"},
+		{http.MethodPost, "/admin/drain?enabled=false", "false\n"},
+	}
+
+	for _, step := range steps {
+		if status, body := send(t, server, step.method, step.path); status != http.StatusOK || body != step.body {
+			t.Errorf("%s %s: expected %q, got %d %q", step.method, step.path, step.body, status, body)
+		}
+	}
+}
+
+func TestCapture(t *testing.T) {
+	directory := t.TempDir()
+	config := Config{CaptureDirectory: directory, MaxCaptureDuration: 5}
+
+	server := newTestServer(t, config, func(request conf.AdminRequest) {
+		capture, ok := request.(conf.CaptureRequested)
+		if !ok {
+			t.Errorf("Unexpected request: %+v", request)
+			request.Fail(errors.New("unexpected request"))
+			return
+		}
+
+		if capture.UserID != "@bob:example.org" || capture.DeviceID != "BOB" {
+			t.Errorf("Unexpected capture request: %+v", capture)
+		}
+		if capture.Capture.Duration != 5*time.Second {
+			t.Errorf("Expected the duration to be limited to 5 s, got %s", capture.Capture.Duration)
+		}
+
+		if capture.TrackID != "camera" {
+			capture.Fail(fmt.Errorf("track %s not found", capture.TrackID))
+			return
+		}
+
+		capture.Capture.Output.Close()
+		capture.Result <- nil
+	})
+
+	captures := func() []os.DirEntry {
+		entries, err := os.ReadDir(directory)
+		if err != nil {
+			t.Fatalf("Failed to read the capture directory: %v", err)
+		}
+		return entries
+	}
+
+	capture := func(trackID, duration string) (int, string) {
+		path := "/admin/capture?conf_id=conference&user_id=@bob:example.org&device_id=BOB"
+		return send(t, server, http.MethodPost, path+"&track_id="+trackID+"&duration="+duration)
+	}
+
+	status, body := capture("camera", "10")
+	if status != http.StatusOK || !strings.HasPrefix(body, directory) {
+		t.Fatalf("Expected the capture to start, got %d %q", status, body)
+	}
+	if _, err := os.Stat(strings.TrimSpace(body)); err != nil {
+		t.Errorf("Expected the capture file to exist: %v", err)
+	}
+
+	// The file of a capture that could not start is removed.
+	status, body = capture("screen", "10")
+	if status != http.StatusNotFound || strings.TrimSpace(body) != "track screen not found" {
+		t.Errorf("Expected the capture to fail, got %d %q", status, body)
+	}
+	if entries := captures(); len(entries) != 1 {
+		t.Errorf("Expected only the file of the started capture to be kept, got %d files", len(entries))
+	}
+
+	// No file is created for an invalid request.
+	if status, _ := capture("camera", "-1"); status != http.StatusBadRequest {
+		t.Errorf("Expected an invalid duration to be rejected, got %d", status)
+	}
+	if entries := captures(); len(entries) != 1 {
+		t.Errorf("Expected no file to be created for an invalid request, got %d files", len(entries))
+	}
+}
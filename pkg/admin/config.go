@@ -0,0 +1,35 @@
+package admin
+
+type Config struct {
+	// The address (e.g. `localhost:6061`) to serve the admin API on. Disabled if empty.
+	// The API lets anyone who can reach it dump the media of the calls, so it must
+	// never be reachable from the outside.
+	Address string `yaml:"address"`
+	// Where the packet captures are written to (the temporary directory if empty).
+	CaptureDirectory string `yaml:"captureDirectory"`
+	// The longest capture that can be requested (in s).
+	MaxCaptureDuration int `yaml:"maxCaptureDuration"`
+	// The largest capture that can be requested (in MB).
+	MaxCaptureSize int `yaml:"maxCaptureSize"`
+}
+
+const (
+	defaultMaxCaptureDuration = 60
+	defaultMaxCaptureSize     = 100
+)
+
+func (c Config) maxCaptureDuration() int {
+	if c.MaxCaptureDuration <= 0 {
+		return defaultMaxCaptureDuration
+	}
+
+	return c.MaxCaptureDuration
+}
+
+func (c Config) maxCaptureSize() int {
+	if c.MaxCaptureSize <= 0 {
+		return defaultMaxCaptureSize
+	}
+
+	return c.MaxCaptureSize
+}
@@ -0,0 +1,174 @@
+package channel
+
+import (
+	"reflect"
+	"sync"
+)
+
+// FairQueue is a multiple-producer-single-consumer queue that gives each sender its own bounded
+// queue and drains the queues in a round-robin fashion, so that a sender that produces lots of
+// messages can't delay the messages of other senders. A sender that fills its own queue blocks,
+// but it does not affect other senders.
+type FairQueue[SenderType comparable, MessageType any] struct {
+	// Capacity of the queue of each sender.
+	capacity int
+	// The messages in the order in which the consumer must process them.
+	output chan Message[SenderType, MessageType]
+	// Informs the dispatcher that the set of senders has changed.
+	changed chan struct{}
+	// Closed when the queue is closed.
+	done chan struct{}
+
+	// We must protect the senders since they are added and removed from other goroutines.
+	mutex sync.Mutex
+	// The queues of the senders in the round-robin order.
+	queues []senderQueue[SenderType, MessageType]
+	// The index of the queue that is to be drained next.
+	next int
+}
+
+type senderQueue[SenderType comparable, MessageType any] struct {
+	sender   SenderType
+	messages chan Message[SenderType, MessageType]
+}
+
+// Creates a new queue where each sender can have up to `capacity` pending messages.
+func NewFairQueue[S comparable, M any](capacity int) *FairQueue[S, M] {
+	queue := &FairQueue[S, M]{
+		capacity: capacity,
+		output:   make(chan Message[S, M]),
+		changed:  make(chan struct{}, 1),
+		done:     make(chan struct{}),
+	}
+
+	go queue.dispatch()
+
+	return queue
+}
+
+// Creates a sink for a new sender. Any existing queue of the sender is replaced (see `Remove()`).
+func (q *FairQueue[S, M]) NewSink(sender S) *SinkWithSender[S, M] {
+	messages := make(chan Message[S, M], q.capacity)
+
+	q.mutex.Lock()
+	q.remove(sender)
+	q.queues = append(q.queues, senderQueue[S, M]{sender, messages})
+	q.mutex.Unlock()
+
+	q.notifyChanged()
+	return NewSink(sender, messages)
+}
+
+// Removes the queue of a given sender. The messages that the sender has sent,
+// but that have not been consumed yet, are discarded.
+func (q *FairQueue[S, M]) Remove(sender S) {
+	q.mutex.Lock()
+	q.remove(sender)
+	q.mutex.Unlock()
+
+	q.notifyChanged()
+}
+
+// Returns a channel with the messages of all senders.
+func (q *FairQueue[S, M]) Messages() <-chan Message[S, M] {
+	return q.output
+}
+
+// Stops the queue. No messages are delivered after this call.
+func (q *FairQueue[S, M]) Close() {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+
+	select {
+	case <-q.done:
+	default:
+		close(q.done)
+	}
+}
+
+func (q *FairQueue[S, M]) remove(sender S) {
+	for i, queue := range q.queues {
+		if queue.sender == sender {
+			q.queues = append(q.queues[:i], q.queues[i+1:]...)
+			if q.next > i {
+				q.next--
+			}
+			return
+		}
+	}
+}
+
+func (q *FairQueue[S, M]) notifyChanged() {
+	select {
+	case q.changed <- struct{}{}:
+	default:
+	}
+}
+
+// The main loop of the queue: takes a message from the queues one by one and passes it to the consumer.
+func (q *FairQueue[S, M]) dispatch() {
+	for {
+		message, found := q.poll()
+		if !found {
+			if message, found = q.wait(); !found {
+				select {
+				case <-q.done:
+					return
+				default:
+					continue
+				}
+			}
+		}
+
+		select {
+		case q.output <- message:
+		case <-q.done:
+			return
+		}
+	}
+}
+
+// Takes a message from the next non-empty queue (in the round-robin order) without blocking.
+func (q *FairQueue[S, M]) poll() (Message[S, M], bool) {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+
+	for range q.queues {
+		if q.next >= len(q.queues) {
+			q.next = 0
+		}
+
+		queue := q.queues[q.next]
+		q.next++
+
+		select {
+		case message := <-queue.messages:
+			return message, true
+		default:
+		}
+	}
+
+	return Message[S, M]{}, false
+}
+
+// Blocks until any of the senders sends a message, the set of senders changes or the queue is closed.
+func (q *FairQueue[S, M]) wait() (Message[S, M], bool) {
+	q.mutex.Lock()
+	cases := make([]reflect.SelectCase, 0, len(q.queues)+2)
+	cases = append(cases,
+		reflect.SelectCase{Dir: reflect.SelectRecv, Chan: reflect.ValueOf(q.done)},
+		reflect.SelectCase{Dir: reflect.SelectRecv, Chan: reflect.ValueOf(q.changed)},
+	)
+	for _, queue := range q.queues {
+		cases = append(cases, reflect.SelectCase{Dir: reflect.SelectRecv, Chan: reflect.ValueOf(queue.messages)})
+	}
+	q.mutex.Unlock()
+
+	chosen, value, _ := reflect.Select(cases)
+	if chosen < 2 {
+		return Message[S, M]{}, false
+	}
+
+	message, _ := value.Interface().(Message[S, M])
+	return message, true
+}
@@ -0,0 +1,146 @@
+package channel_test
+
+import (
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/matrix-org/waterfall/pkg/channel"
+)
+
+func TestFairQueueRoundRobin(t *testing.T) {
+	queue := channel.NewFairQueue[string, int](10)
+	defer queue.Close()
+
+	noisy, quiet := queue.NewSink("noisy"), queue.NewSink("quiet")
+
+	// The noisy sender fills its queue before the quiet one sends anything.
+	for i := 0; i < 10; i++ {
+		if err := noisy.Send(i); err != nil {
+			t.Fatalf("Failed to send: %v", err)
+		}
+	}
+	if err := quiet.Send(0); err != nil {
+		t.Fatalf("Failed to send: %v", err)
+	}
+
+	// The quiet sender must not wait until the noisy one is drained. The dispatcher may have
+	// already taken the first message of the noisy sender, so we allow 2 messages before.
+	for i := 0; i < 3; i++ {
+		select {
+		case msg := <-queue.Messages():
+			if msg.Sender == "quiet" {
+				return
+			}
+		case <-time.After(time.Second):
+			t.Fatal("Timed out waiting for messages")
+		}
+	}
+
+	t.Error("The quiet sender has been starved by the noisy one")
+}
+
+func TestFairQueueRemove(t *testing.T) {
+	queue := channel.NewFairQueue[string, int](10)
+	defer queue.Close()
+
+	removed, active := queue.NewSink("removed"), queue.NewSink("active")
+	queue.Remove("removed")
+
+	// The sink of a removed sender may still accept the messages, but they are never delivered.
+	removed.Send(1) //nolint:errcheck
+	active.Send(2)  //nolint:errcheck
+
+	select {
+	case msg := <-queue.Messages():
+		if msg.Sender != "active" || msg.Content != 2 {
+			t.Errorf("Unexpected message: %+v", msg)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Timed out waiting for a message")
+	}
+}
+
+// Measures how long a message of a quiet sender waits to be processed while another sender floods
+// the consumer with messages. The consumer takes some time to process each message.
+func benchmarkQuietSenderLatency(
+	b *testing.B,
+	newSinks func() (noisy, quiet *channel.SinkWithSender[string, time.Time], messages <-chan channel.Message[string, time.Time]),
+) {
+	b.Helper()
+
+	const processingTime = 20 * time.Microsecond
+
+	noisy, quiet, messages := newSinks()
+
+	// Flood the consumer.
+	stop := make(chan struct{})
+	defer close(stop)
+	defer noisy.Seal()
+	go func() {
+		for {
+			select {
+			case <-stop:
+				return
+			default:
+				noisy.Send(time.Now()) //nolint:errcheck
+			}
+		}
+	}()
+
+	// The consumer reports the latencies of the quiet sender's messages.
+	latencies := make(chan time.Duration)
+	go func() {
+		for {
+			select {
+			case <-stop:
+				return
+			case msg := <-messages:
+				time.Sleep(processingTime)
+				if msg.Sender == "quiet" {
+					latencies <- time.Since(msg.Content)
+				}
+			}
+		}
+	}()
+
+	measured := make([]time.Duration, 0, b.N)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		quiet.Send(time.Now()) //nolint:errcheck
+		measured = append(measured, <-latencies)
+	}
+	b.StopTimer()
+
+	sort.Slice(measured, func(i, j int) bool { return measured[i] < measured[j] })
+	b.ReportMetric(float64(measured[len(measured)/2].Microseconds()), "p50-µs")
+	b.ReportMetric(float64(measured[len(measured)*99/100].Microseconds()), "p99-µs")
+}
+
+func BenchmarkQuietSenderLatency(b *testing.B) {
+	const capacity = 100
+
+	b.Run("shared channel", func(b *testing.B) {
+		benchmarkQuietSenderLatency(b, func() (
+			*channel.SinkWithSender[string, time.Time],
+			*channel.SinkWithSender[string, time.Time],
+			<-chan channel.Message[string, time.Time],
+		) {
+			messages := make(chan channel.Message[string, time.Time], capacity)
+			return channel.NewSink("noisy", messages), channel.NewSink("quiet", messages), messages
+		})
+	})
+
+	b.Run("fair queue", func(b *testing.B) {
+		queue := channel.NewFairQueue[string, time.Time](capacity)
+		defer queue.Close()
+
+		benchmarkQuietSenderLatency(b, func() (
+			*channel.SinkWithSender[string, time.Time],
+			*channel.SinkWithSender[string, time.Time],
+			<-chan channel.Message[string, time.Time],
+		) {
+			return queue.NewSink("noisy"), queue.NewSink("quiet"), queue.Messages()
+		})
+	})
+}
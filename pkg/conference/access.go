@@ -0,0 +1,52 @@
+package conference
+
+import (
+	"fmt"
+	"path"
+
+	"maunium.net/go/mautrix/event"
+	"maunium.net/go/mautrix/id"
+)
+
+// Sent to the participants that are not allowed to join the conferences on this SFU.
+const HangupNotAllowed event.CallHangupReason = "not_allowed"
+
+// Restricts who can join the conferences. The patterns are globs matched against the whole user ID,
+// e.g. `@*:example.org` matches all users of a given homeserver.
+type AccessControl struct {
+	// Only the users that match at least one of the patterns may join (everyone if empty).
+	Allow []string `yaml:"allow"`
+	// The users that match any of the patterns may never join, even if they're allowed.
+	Deny []string `yaml:"deny"`
+}
+
+// Checks that all patterns are valid globs.
+func (a AccessControl) Validate() error {
+	for _, pattern := range append(append([]string{}, a.Allow...), a.Deny...) {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return fmt.Errorf("invalid pattern %q: %w", pattern, err)
+		}
+	}
+
+	return nil
+}
+
+// Checks if a given user may join the conferences.
+func (a AccessControl) Allows(userID id.UserID) bool {
+	if matchesAny(a.Deny, userID) {
+		return false
+	}
+
+	return len(a.Allow) == 0 || matchesAny(a.Allow, userID)
+}
+
+func matchesAny(patterns []string, userID id.UserID) bool {
+	for _, pattern := range patterns {
+		// The patterns are validated when the config is loaded.
+		if matched, _ := path.Match(pattern, userID.String()); matched {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,103 @@
+package conference //nolint:testpackage
+
+import (
+	"testing"
+	"time"
+
+	"github.com/matrix-org/waterfall/pkg/conference/participant"
+	"github.com/matrix-org/waterfall/pkg/signaling"
+	"github.com/pion/webrtc/v3"
+	"maunium.net/go/mautrix/event"
+	"maunium.net/go/mautrix/id"
+)
+
+func TestAccessControl(t *testing.T) {
+	access := AccessControl{
+		Allow: []string{"@*:example.org", "@guest:other.org"},
+		Deny:  []string{"@mallory:example.org"},
+	}
+
+	cases := []struct {
+		userID  id.UserID
+		allowed bool
+	}{
+		{"@alice:example.org", true},
+		{"@guest:other.org", true},
+		{"@mallory:example.org", false},
+		{"@bob:other.org", false},
+		{"@alice:example.org.evil", false},
+	}
+
+	for _, c := range cases {
+		if allowed := access.Allows(c.userID); allowed != c.allowed {
+			t.Errorf("%s: expected allowed to be %v, got %v", c.userID, c.allowed, allowed)
+		}
+	}
+
+	if !(AccessControl{}).Allows("@anyone:anywhere.org") {
+		t.Error("Expected everyone to be allowed without access control")
+	}
+
+	if err := (AccessControl{Deny: []string{"@[a-:example.org"}}).Validate(); err == nil {
+		t.Error("Expected an invalid pattern to be rejected")
+	}
+}
+
+func TestDeniedParticipantIsRejected(t *testing.T) {
+	signaler := &recordingSignaler{messages: make(chan signaling.MatrixMessage, 1)}
+	conference := newTestConference(t, withSignaler(signaler), withConfig(Config{
+		HeartbeatConfig: Heartbeat{Interval: 5, Timeout: 30},
+		AccessControl:   AccessControl{Deny: []string{"@mallory:*"}},
+	}))
+	tracker := conference.tracker
+
+	// Returns the message that the SFU sent in response to the invite of a given user.
+	invite := func(userID id.UserID) signaling.MatrixMessage {
+		remote, err := webrtc.NewPeerConnection(webrtc.Configuration{})
+		if err != nil {
+			t.Fatalf("Failed to create peer connection: %v", err)
+		}
+		t.Cleanup(func() { remote.Close() })
+
+		if _, err := remote.CreateDataChannel("data", nil); err != nil {
+			t.Fatalf("Failed to create data channel: %v", err)
+		}
+		offer, err := remote.CreateOffer(nil)
+		if err != nil {
+			t.Fatalf("Failed to create offer: %v", err)
+		}
+
+		inviteEvent := &event.CallInviteEventContent{Offer: event.CallData{Type: "offer", SDP: offer.SDP}}
+		conference.onNewParticipant(participant.ID{UserID: userID, DeviceID: "DEVICE", CallID: "call"}, inviteEvent)
+
+		select {
+		case msg := <-signaler.messages:
+			return msg
+		case <-time.After(5 * time.Second):
+			t.Fatalf("Expected a response to the invite of %s", userID)
+			return signaling.MatrixMessage{}
+		}
+	}
+
+	msg := invite("@mallory:example.org")
+	if hangup, ok := msg.Message.(signaling.Hangup); !ok || hangup.Reason != HangupNotAllowed {
+		t.Errorf("Expected the denied user to be hung up on, got %+v", msg.Message)
+	}
+	if count := tracker.ParticipantCount(); count != 0 {
+		t.Errorf("Expected the denied user not to join, got %d participants", count)
+	}
+
+	msg = invite("@alice:example.org")
+	if _, ok := msg.Message.(signaling.SdpAnswer); !ok {
+		t.Errorf("Expected the allowed user to get an answer, got %+v", msg.Message)
+	}
+	if count := tracker.ParticipantCount(); count != 1 {
+		t.Errorf("Expected the allowed user to join, got %d participants", count)
+	}
+
+	alice := participant.ID{UserID: "@alice:example.org", DeviceID: "DEVICE", CallID: "call"}
+	if p := tracker.GetParticipant(alice); p != nil {
+		p.Heartbeat.Stop()
+		p.Peer.Terminate()
+	}
+}
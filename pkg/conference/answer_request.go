@@ -0,0 +1,30 @@
+package conference
+
+import (
+	"github.com/matrix-org/waterfall/pkg/conference/participant"
+	"maunium.net/go/mautrix/event"
+)
+
+// Sent by the participant over Matrix if it has sent an invite, but has not received our SDP answer in time.
+// The to-device messages may get lost, in which case the call would otherwise only fail with a timeout.
+var ToDeviceCallAnswerRequest = event.Type{Type: "m.call.answer_request", Class: event.ToDeviceEventType}
+
+// Sent by the router when a participant asks for the SDP answer again.
+type AnswerRequested struct{}
+
+// Re-sends the last SDP answer to a participant that has missed it.
+func (c *Conference) onAnswerRequested(id participant.ID) {
+	p := c.getParticipant(id)
+	if p == nil {
+		return
+	}
+
+	if p.LastSDPAnswer == "" {
+		p.Logger.Warn("Received answer request, but no answer has been sent yet")
+		return
+	}
+
+	p.Logger.Info("Re-sending SDP answer on request")
+	p.Telemetry.AddEvent("Re-sending SDP answer on request")
+	c.sendSDPAnswer(p)
+}
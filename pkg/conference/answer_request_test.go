@@ -0,0 +1,53 @@
+package conference //nolint:testpackage
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/matrix-org/waterfall/pkg/conference/participant"
+	"github.com/matrix-org/waterfall/pkg/signaling"
+	"github.com/matrix-org/waterfall/pkg/telemetry"
+	"github.com/sirupsen/logrus"
+)
+
+func TestAnswerRequestResendsAnswer(t *testing.T) {
+	signaler := &recordingSignaler{messages: make(chan signaling.MatrixMessage, 1)}
+	conference := newTestConference(t, withSignaler(signaler))
+	tracker := conference.tracker
+	logger := logrus.NewEntry(logrus.New())
+	tel := telemetry.NewTelemetry(context.Background(), "Conference")
+
+	alice := participant.ID{UserID: "@alice:example.org", DeviceID: "ALICE", CallID: "call"}
+	tracker.AddParticipant(&participant.Participant{
+		ID:            alice,
+		Logger:        logger,
+		Telemetry:     tel,
+		LastSDPAnswer: "v=0\r\n",
+	})
+
+	// The request of a previous call of the same device is ignored.
+	conference.onAnswerRequested(participant.ID{UserID: alice.UserID, DeviceID: alice.DeviceID, CallID: "old"})
+	select {
+	case msg := <-signaler.messages:
+		t.Fatalf("Expected no answer for a previous call, got %+v", msg)
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	conference.onAnswerRequested(alice)
+	select {
+	case msg := <-signaler.messages:
+		answer, ok := msg.Message.(signaling.SdpAnswer)
+		if !ok {
+			t.Fatalf("Expected an SDP answer, got %T", msg.Message)
+		}
+		if answer.SDP != "v=0\r\n" {
+			t.Errorf("Expected the cached answer to be re-sent, got %q", answer.SDP)
+		}
+		if msg.Recipient.CallID != alice.CallID {
+			t.Errorf("Expected the answer to be sent to the call %s, got %s", alice.CallID, msg.Recipient.CallID)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Expected the answer to be re-sent")
+	}
+}
@@ -0,0 +1,226 @@
+package conference
+
+import (
+	"math"
+	"sort"
+	"time"
+
+	"github.com/matrix-org/waterfall/pkg/conference/participant"
+	"github.com/matrix-org/waterfall/pkg/conference/track"
+	"github.com/matrix-org/waterfall/pkg/webrtc_ext"
+	"go.opentelemetry.io/otel/attribute"
+)
+
+// How often the conference compares its outgoing bandwidth with the budget.
+const bandwidthCheckInterval = 2 * time.Second
+
+// The demoted subscriptions are only promoted again if the outgoing bandwidth stays below this
+// fraction of the budget afterwards, so that they don't flap between the layers near the ceiling.
+const bandwidthPromotionHeadroom = 0.8
+
+// A video subscription as seen by the bandwidth budget of the conference.
+type budgetedSubscription struct {
+	trackID      track.TrackID
+	subscriberID participant.ID
+	// Screen shares are demoted only once the camera tracks can't be demoted anymore.
+	screenshare bool
+	// The subscriptions that can't be switched between the layers only count towards the usage.
+	switchable bool
+	layers     track.SubscriptionLayers
+	// The incoming bitrate (bits per second) of each layer of the track.
+	bitrates map[webrtc_ext.SimulcastLayer]uint64
+}
+
+// A new limit of the layer of a subscription (`SimulcastLayerNone` lifts the limit).
+type layerLimit struct {
+	trackID      track.TrackID
+	subscriberID participant.ID
+	maxLayer     webrtc_ext.SimulcastLayer
+}
+
+// Estimates the outgoing bandwidth of the conference (the sum of the bitrates of the layers that the video
+// subscribers receive) and demotes or promotes the subscriptions to keep it within the configured budget.
+func (c *Conference) enforceBandwidthBudget() {
+	// Without a budget (e.g. after a config reload), the demoted subscriptions are promoted back.
+	budget := uint64(math.MaxUint64)
+	if c.profile.MaxConferenceBitrate > 0 {
+		budget = uint64(c.profile.MaxConferenceBitrate) * 1000
+	}
+
+	subscriptions := []*budgetedSubscription{}
+	tracks := make(map[track.TrackID]*track.PublishedTrack[participant.ID])
+	c.tracker.ForEachPublishedTrack(func(published *track.PublishedTrack[participant.ID]) {
+		info := published.Info()
+		bitrates := published.LayerBitrates()
+		if len(bitrates) == 0 {
+			return
+		}
+
+		tracks[info.TrackID] = published
+		switchable := published.CanSwitchLayers()
+		screenshare := published.Metadata().Screenshare
+		for subscriberID, layers := range published.SubscriptionLayers() {
+			subscriptions = append(subscriptions, &budgetedSubscription{
+				trackID:      info.TrackID,
+				subscriberID: subscriberID,
+				screenshare:  screenshare,
+				switchable:   switchable,
+				layers:       layers,
+				bitrates:     bitrates,
+			})
+		}
+	})
+
+	for _, limit := range planLayerLimits(subscriptions, budget) {
+		if err := tracks[limit.trackID].SwitchLayer(limit.subscriberID, limit.maxLayer); err != nil {
+			c.logger.WithError(err).Warnf("Failed to limit the layer of %s for %s", limit.trackID, limit.subscriberID)
+			continue
+		}
+
+		c.telemetry.AddEvent(
+			"layer limit changed",
+			attribute.String("track_id", limit.trackID),
+			attribute.String("subscriber", limit.subscriberID.String()),
+			attribute.String("max_layer", limit.maxLayer.String()),
+		)
+		c.events.record(EventLayerLimited, limit.subscriberID, limit.trackID, limit.maxLayer.String())
+	}
+}
+
+// Decides which subscriptions must be limited to keep the outgoing bandwidth within the budget (bits per
+// second). If the budget is exceeded, the switchable subscriptions are demoted one layer at a time (the
+// camera tracks before the screen shares, the most expensive ones first) until the usage fits into the
+// budget. Otherwise, the previously demoted subscriptions are promoted one layer at a time if they fit.
+func planLayerLimits(subscriptions []*budgetedSubscription, budget uint64) []layerLimit {
+	usage := uint64(0)
+	for _, sub := range subscriptions {
+		usage += sub.bitrates[sub.layers.Current]
+	}
+
+	candidates := []*budgetedSubscription{}
+	for _, sub := range subscriptions {
+		if sub.switchable {
+			candidates = append(candidates, sub)
+		}
+	}
+
+	// Lowest priority first, the ties are broken by the bitrate, so that fewer subscriptions get demoted.
+	sort.SliceStable(candidates, func(i, j int) bool {
+		a, b := candidates[i], candidates[j]
+		if a.screenshare != b.screenshare {
+			return !a.screenshare
+		}
+		if rateA, rateB := a.bitrates[a.layers.Current], b.bitrates[b.layers.Current]; rateA != rateB {
+			return rateA > rateB
+		}
+		if a.trackID != b.trackID {
+			return a.trackID < b.trackID
+		}
+
+		return a.subscriberID.String() < b.subscriberID.String()
+	})
+
+	if usage > budget {
+		return demoteSubscriptions(candidates, usage, budget)
+	}
+
+	// Promote the highest priority subscriptions first.
+	limits := []layerLimit{}
+	for i := len(candidates) - 1; i >= 0; i-- {
+		sub := candidates[i]
+		if sub.layers.Max == webrtc_ext.SimulcastLayerNone {
+			continue
+		}
+
+		next := nextHigherLayer(sub.layers.Max)
+		cost := uint64(0)
+		if rate, current := sub.bitrates[next], sub.bitrates[sub.layers.Current]; rate > current {
+			cost = rate - current
+		}
+
+		if float64(usage+cost) > float64(budget)*bandwidthPromotionHeadroom {
+			continue
+		}
+
+		usage += cost
+		limits = append(limits, layerLimit{sub.trackID, sub.subscriberID, liftedLimit(next)})
+	}
+
+	return limits
+}
+
+// Demotes the subscriptions (in the given order) until the usage fits into the budget. The subscriptions
+// of the same priority are demoted in rounds, so that each of them loses a single layer before any of
+// them loses another one.
+func demoteSubscriptions(candidates []*budgetedSubscription, usage, budget uint64) []layerLimit {
+	demoted := make(map[*budgetedSubscription]webrtc_ext.SimulcastLayer)
+	order := []*budgetedSubscription{}
+
+	for _, screenshare := range []bool{false, true} {
+		for changed := true; changed && usage > budget; {
+			changed = false
+			for _, sub := range candidates {
+				if sub.screenshare != screenshare || usage <= budget {
+					continue
+				}
+
+				current := sub.layers.Current
+				if layer, found := demoted[sub]; found {
+					current = layer
+				}
+
+				lower, found := lowerActiveLayer(sub.bitrates, current)
+				if !found {
+					continue
+				}
+
+				if _, found := demoted[sub]; !found {
+					order = append(order, sub)
+				}
+
+				usage -= sub.bitrates[current] - sub.bitrates[lower]
+				demoted[sub] = lower
+				changed = true
+			}
+		}
+	}
+
+	limits := make([]layerLimit, 0, len(order))
+	for _, sub := range order {
+		limits = append(limits, layerLimit{sub.trackID, sub.subscriberID, demoted[sub]})
+	}
+
+	return limits
+}
+
+// Returns the highest layer below `layer` that is being received (has a non-zero bitrate).
+func lowerActiveLayer(
+	bitrates map[webrtc_ext.SimulcastLayer]uint64,
+	layer webrtc_ext.SimulcastLayer,
+) (webrtc_ext.SimulcastLayer, bool) {
+	for lower := layer - 1; lower > webrtc_ext.SimulcastLayerNone; lower-- {
+		if bitrates[lower] > 0 {
+			return lower, true
+		}
+	}
+
+	return webrtc_ext.SimulcastLayerNone, false
+}
+
+// Returns the next higher layer (the high layer stays high).
+func nextHigherLayer(layer webrtc_ext.SimulcastLayer) webrtc_ext.SimulcastLayer {
+	if layer >= webrtc_ext.SimulcastLayerHigh {
+		return webrtc_ext.SimulcastLayerHigh
+	}
+
+	return layer + 1
+}
+
+// A limit to the highest layer is no limit at all.
+func liftedLimit(layer webrtc_ext.SimulcastLayer) webrtc_ext.SimulcastLayer {
+	if layer == webrtc_ext.SimulcastLayerHigh {
+		return webrtc_ext.SimulcastLayerNone
+	}
+
+	return layer
+}
@@ -0,0 +1,117 @@
+package conference //nolint:testpackage
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/matrix-org/waterfall/pkg/conference/participant"
+	"github.com/matrix-org/waterfall/pkg/conference/track"
+	"github.com/matrix-org/waterfall/pkg/webrtc_ext"
+)
+
+func TestPlanLayerLimits(t *testing.T) {
+	low, mid, high := webrtc_ext.SimulcastLayerLow, webrtc_ext.SimulcastLayerMedium, webrtc_ext.SimulcastLayerHigh
+	none := webrtc_ext.SimulcastLayerNone
+	bitrates := map[webrtc_ext.SimulcastLayer]uint64{low: 150_000, mid: 500_000, high: 1_500_000}
+
+	alice := participant.ID{UserID: "@alice:example.org", DeviceID: "ALICE"}
+	bob := participant.ID{UserID: "@bob:example.org", DeviceID: "BOB"}
+
+	newSubscription := func(
+		trackID track.TrackID,
+		subscriber participant.ID,
+		screenshare, switchable bool,
+		current, maxLayer webrtc_ext.SimulcastLayer,
+	) *budgetedSubscription {
+		return &budgetedSubscription{
+			trackID:      trackID,
+			subscriberID: subscriber,
+			screenshare:  screenshare,
+			switchable:   switchable,
+			layers:       track.SubscriptionLayers{Current: current, Max: maxLayer},
+			bitrates:     bitrates,
+		}
+	}
+
+	cases := []struct {
+		name          string
+		subscriptions []*budgetedSubscription
+		budget        uint64
+		expected      []layerLimit
+	}{
+		{
+			name: "within the budget",
+			subscriptions: []*budgetedSubscription{
+				newSubscription("camera", alice, false, true, high, none),
+			},
+			budget:   2_000_000,
+			expected: []layerLimit{},
+		},
+		{
+			name: "the camera is demoted before the screen share",
+			subscriptions: []*budgetedSubscription{
+				newSubscription("screen", alice, true, true, high, none),
+				newSubscription("camera", alice, false, true, high, none),
+			},
+			budget:   2_500_000,
+			expected: []layerLimit{{"camera", alice, mid}},
+		},
+		{
+			name: "the subscriptions of the same priority lose a layer in turns",
+			subscriptions: []*budgetedSubscription{
+				newSubscription("camera", alice, false, true, high, none),
+				newSubscription("camera", bob, false, true, high, none),
+			},
+			budget:   1_000_000,
+			expected: []layerLimit{{"camera", alice, mid}, {"camera", bob, mid}},
+		},
+		{
+			name: "the screen share is demoted once the cameras are at the lowest layer",
+			subscriptions: []*budgetedSubscription{
+				newSubscription("screen", bob, true, true, high, none),
+				newSubscription("camera", alice, false, true, high, none),
+			},
+			budget:   1_000_000,
+			expected: []layerLimit{{"camera", alice, low}, {"screen", bob, mid}},
+		},
+		{
+			name: "the fixed layers only count towards the usage",
+			subscriptions: []*budgetedSubscription{
+				newSubscription("pinned", alice, false, false, high, none),
+				newSubscription("camera", bob, false, true, mid, none),
+			},
+			budget:   1_700_000,
+			expected: []layerLimit{{"camera", bob, low}},
+		},
+		{
+			name: "the demoted subscription is promoted if there is enough room",
+			subscriptions: []*budgetedSubscription{
+				newSubscription("camera", alice, false, true, low, low),
+			},
+			budget:   1_000_000,
+			expected: []layerLimit{{"camera", alice, mid}},
+		},
+		{
+			name: "the limit is lifted once the subscription can get the highest layer",
+			subscriptions: []*budgetedSubscription{
+				newSubscription("camera", alice, false, true, mid, mid),
+			},
+			budget:   2_000_000,
+			expected: []layerLimit{{"camera", alice, none}},
+		},
+		{
+			name: "the demoted subscription is not promoted close to the ceiling",
+			subscriptions: []*budgetedSubscription{
+				newSubscription("camera", alice, false, true, mid, mid),
+			},
+			budget:   1_600_000,
+			expected: []layerLimit{},
+		},
+	}
+
+	for _, c := range cases {
+		if limits := planLayerLimits(c.subscriptions, c.budget); !reflect.DeepEqual(limits, c.expected) {
+			t.Errorf("%s: expected %v, got %v", c.name, c.expected, limits)
+		}
+	}
+}
@@ -0,0 +1,51 @@
+package conference
+
+import (
+	"github.com/matrix-org/waterfall/pkg/conference/participant"
+	"github.com/matrix-org/waterfall/pkg/webrtc_ext"
+	"maunium.net/go/mautrix/id"
+)
+
+// Sent by the router when an operator requested the incoming bitrates of the published video tracks.
+type BitratesRequested struct {
+	// Receives the bitrates, must be buffered.
+	Result chan<- BitratesResult
+}
+
+// The outcome of the `BitratesRequested`.
+type BitratesResult struct {
+	Tracks []TrackBitrates
+	Err    error
+}
+
+// The incoming bitrate of each simulcast layer of a published video track.
+type TrackBitrates struct {
+	TrackID  string      `json:"track_id"`
+	UserID   id.UserID   `json:"user_id"`
+	DeviceID id.DeviceID `json:"device_id"`
+	// Bits per second for each layer.
+	Layers map[string]uint64 `json:"layers"`
+}
+
+func (r BitratesRequested) Fail(err error) {
+	r.Result <- BitratesResult{Err: err}
+}
+
+func (c *Conference) onBitratesRequested(request BitratesRequested) {
+	tracks := []TrackBitrates{}
+	c.tracker.ForEachPublishedTrackInfo(func(owner participant.ID, info webrtc_ext.TrackInfo) {
+		bitrates := c.tracker.LayerBitrates(info.TrackID)
+		if len(bitrates) == 0 {
+			return
+		}
+
+		layers := make(map[string]uint64, len(bitrates))
+		for layer, bitrate := range bitrates {
+			layers[layer.String()] = bitrate
+		}
+
+		tracks = append(tracks, TrackBitrates{info.TrackID, owner.UserID, owner.DeviceID, layers})
+	})
+
+	request.Result <- BitratesResult{Tracks: tracks}
+}
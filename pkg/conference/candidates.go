@@ -0,0 +1,44 @@
+package conference
+
+import (
+	"github.com/matrix-org/waterfall/pkg/conference/participant"
+	"github.com/matrix-org/waterfall/pkg/peer"
+	"maunium.net/go/mautrix/id"
+)
+
+// Sent by the router when an operator requested the ICE candidates that the participants use, e.g. to see
+// which of them go through a TURN server.
+type CandidatePairsRequested struct {
+	// Receives the candidate pairs, must be buffered.
+	Result chan<- CandidatePairsResult
+}
+
+// The outcome of the `CandidatePairsRequested`.
+type CandidatePairsResult struct {
+	Participants []ParticipantCandidatePair
+	Err          error
+}
+
+// The selected ICE candidate pair of a participant.
+type ParticipantCandidatePair struct {
+	UserID   id.UserID   `json:"user_id"`
+	DeviceID id.DeviceID `json:"device_id"`
+	peer.CandidatePair
+	Relayed bool `json:"relayed"`
+}
+
+func (r CandidatePairsRequested) Fail(err error) {
+	r.Result <- CandidatePairsResult{Err: err}
+}
+
+// Responds with the candidate pairs of the participants that are connected.
+func (c *Conference) onCandidatePairsRequested(request CandidatePairsRequested) {
+	pairs := []ParticipantCandidatePair{}
+	c.tracker.ForEachParticipant(func(participantID participant.ID, p *participant.Participant) {
+		if pair := p.CandidatePair; pair != nil {
+			pairs = append(pairs, ParticipantCandidatePair{participantID.UserID, participantID.DeviceID, *pair, pair.Relayed()})
+		}
+	})
+
+	request.Result <- CandidatePairsResult{Participants: pairs}
+}
@@ -0,0 +1,68 @@
+package conference //nolint:testpackage
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/matrix-org/waterfall/pkg/conference/participant"
+	"github.com/matrix-org/waterfall/pkg/peer"
+	"github.com/matrix-org/waterfall/pkg/telemetry"
+	"github.com/sirupsen/logrus"
+)
+
+func TestCandidatePairsReported(t *testing.T) {
+	conference := newTestConference(t)
+	tracker := conference.tracker
+	logger := logrus.NewEntry(logrus.New())
+
+	alice := participant.ID{UserID: "@alice:example.org", DeviceID: "ALICE", CallID: "call"}
+	bob := participant.ID{UserID: "@bob:example.org", DeviceID: "BOB", CallID: "call"}
+	carol := participant.ID{UserID: "@carol:example.org", DeviceID: "CAROL", CallID: "call"}
+	for _, id := range []participant.ID{alice, bob, carol} {
+		tracker.AddParticipant(&participant.Participant{
+			ID:        id,
+			Peer:      newSubscriberPeer(t, id),
+			Logger:    logger,
+			Telemetry: telemetry.NewTelemetry(context.Background(), "Participant"),
+		})
+	}
+
+	// Alice goes through a TURN server, Bob connects directly and Carol is not connected yet.
+	conference.processJoinedTheCallMessage(alice, peer.JoinedTheCall{CandidatePair: &peer.CandidatePair{
+		Local:  peer.Candidate{Type: "host", Address: "10.0.0.1:50000"},
+		Remote: peer.Candidate{Type: "relay", Address: "203.0.113.1:3478"},
+	}})
+	conference.processJoinedTheCallMessage(bob, peer.JoinedTheCall{CandidatePair: &peer.CandidatePair{
+		Local:  peer.Candidate{Type: "host", Address: "10.0.0.1:50001"},
+		Remote: peer.Candidate{Type: "srflx", Address: "198.51.100.1:40000"},
+	}})
+
+	result := make(chan CandidatePairsResult, 1)
+	conference.onCandidatePairsRequested(CandidatePairsRequested{Result: result})
+
+	outcome := <-result
+	if outcome.Err != nil {
+		t.Fatalf("Failed to get the candidate pairs: %v", outcome.Err)
+	}
+
+	relayed := map[string]bool{}
+	for _, pair := range outcome.Participants {
+		relayed[pair.UserID.String()] = pair.Relayed
+	}
+
+	if len(relayed) != 2 || !relayed["@alice:example.org"] || relayed["@bob:example.org"] {
+		t.Errorf("Expected only Alice to be relayed and Carol not to be reported, got %v", relayed)
+	}
+
+	serialized, err := json.Marshal(outcome.Participants)
+	if err != nil {
+		t.Fatalf("Failed to marshal the candidate pairs: %v", err)
+	}
+
+	expected := `"remote":{"type":"relay","address":"203.0.113.1:3478"}`
+	if !strings.Contains(string(serialized), expected) {
+		t.Errorf("Expected the candidate types and addresses to be reported, got %s", serialized)
+	}
+}
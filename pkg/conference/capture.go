@@ -0,0 +1,52 @@
+package conference
+
+import (
+	"fmt"
+
+	"github.com/matrix-org/waterfall/pkg/conference/participant"
+	"github.com/matrix-org/waterfall/pkg/conference/subscription"
+	"github.com/matrix-org/waterfall/pkg/conference/track"
+	"maunium.net/go/mautrix/id"
+)
+
+// A request of an operator (see the admin API) that the router forwards to the conference.
+type AdminRequest interface {
+	// Informs the operator that the request could not be handled.
+	Fail(err error)
+}
+
+// Sent by the router when an operator requested a dump of the packets forwarded to a subscriber.
+type CaptureRequested struct {
+	TrackID  track.TrackID
+	UserID   id.UserID
+	DeviceID id.DeviceID
+	Capture  subscription.CaptureConfig
+	// Receives the outcome of the request, must be buffered.
+	Result chan<- error
+}
+
+func (r CaptureRequested) Fail(err error) {
+	r.Capture.Output.Close()
+	r.Result <- err
+}
+
+func (c *Conference) onCaptureRequested(request CaptureRequested) {
+	var subscriber *participant.ID
+	c.tracker.ForEachParticipant(func(participantID participant.ID, _ *participant.Participant) {
+		if participantID.UserID == request.UserID && participantID.DeviceID == request.DeviceID {
+			subscriber = &participantID
+		}
+	})
+
+	if subscriber == nil {
+		request.Fail(fmt.Errorf("participant %s (%s) not found", request.UserID, request.DeviceID))
+		return
+	}
+
+	err := c.tracker.StartCapture(*subscriber, request.TrackID, request.Capture)
+	if err == nil {
+		c.logger.WithField("track_id", request.TrackID).Warnf("capturing packets forwarded to %s", subscriber)
+	}
+
+	request.Result <- err
+}
@@ -0,0 +1,116 @@
+package conference //nolint:testpackage
+
+import (
+	"context"
+	"testing"
+
+	"github.com/matrix-org/waterfall/pkg/channel"
+	"github.com/matrix-org/waterfall/pkg/conference/participant"
+	"github.com/matrix-org/waterfall/pkg/conference/track"
+	"github.com/matrix-org/waterfall/pkg/peer"
+	"github.com/matrix-org/waterfall/pkg/signaling"
+	"github.com/matrix-org/waterfall/pkg/telemetry"
+	"github.com/matrix-org/waterfall/pkg/webrtc_ext"
+	"github.com/sirupsen/logrus"
+	"maunium.net/go/mautrix/event"
+	"maunium.net/go/mautrix/id"
+)
+
+// A signaler that hands the sent messages over to the test.
+type recordingSignaler struct {
+	messages chan signaling.MatrixMessage
+}
+
+func (s *recordingSignaler) SendMessage(msg signaling.MatrixMessage) error {
+	s.messages <- msg
+	return nil
+}
+
+func (s *recordingSignaler) DeviceID() id.DeviceID {
+	return "SFU"
+}
+
+// A signaler that drops the sent messages, used by the tests that don't check them.
+type discardingSignaler struct{}
+
+func (discardingSignaler) SendMessage(signaling.MatrixMessage) error {
+	return nil
+}
+
+func (discardingSignaler) DeviceID() id.DeviceID {
+	return "SFU"
+}
+
+// Customizes the conference created by `newTestConference()`.
+type testConferenceOption func(*Conference)
+
+// Uses a given config instead of the default one.
+func withConfig(config Config) testConferenceOption {
+	return func(c *Conference) { c.config = config }
+}
+
+// Uses a given profile instead of the default (empty) one.
+func withProfile(profile Profile) testConferenceOption {
+	return func(c *Conference) { c.profile = profile }
+}
+
+// Uses a given logger, e.g. to check what the conference logs.
+func withLogger(logger *logrus.Entry) testConferenceOption {
+	return func(c *Conference) { c.logger = logger }
+}
+
+// Sends the matrix messages of the conference to a given signaler instead of dropping them.
+func withSignaler(signaler signaling.MatrixSignaler) testConferenceOption {
+	return func(c *Conference) { c.matrixWorker = newMatrixWorker(signaler) }
+}
+
+// Starts the conference with the given streams metadata.
+func withStreamsMetadata(metadata event.CallSDPStreamMetadata) testConferenceOption {
+	return func(c *Conference) { c.streamsMetadata = metadata }
+}
+
+// Creates a conference with the same state as `StartConference()` does, but without the main loop and
+// without any participants. Everything that the conference started is stopped when the test ends.
+func newTestConference(t *testing.T, options ...testConferenceOption) *Conference {
+	t.Helper()
+
+	factory, err := webrtc_ext.NewPeerConnectionFactory(webrtc_ext.Config{})
+	if err != nil {
+		t.Fatalf("Failed to create peer connection factory: %v", err)
+	}
+
+	conference := &Conference{
+		config:            Config{HeartbeatConfig: Heartbeat{Interval: 5, Timeout: 30}},
+		connectionFactory: factory,
+		logger:            logrus.NewEntry(logrus.New()),
+		telemetry:         telemetry.NewTelemetry(context.Background(), "Conference"),
+		streamsMetadata:   make(event.CallSDPStreamMetadata),
+		encryptedTracks:   make(map[track.TrackID]bool),
+		peerMessages:      channel.NewFairQueue[participant.ID, peer.MessageContent](peerMessagesCapacity),
+	}
+
+	for _, option := range options {
+		option(conference)
+	}
+
+	if conference.matrixWorker == nil {
+		conference.matrixWorker = newMatrixWorker(discardingSignaler{})
+	}
+
+	// The state that depends on the config and the profile is created once the options are applied.
+	conferenceEnded := make(chan struct{})
+	conference.tracker, conference.publishedTrackStopped = participant.NewParticipantTracker(
+		conferenceEnded,
+		newTrackConfig(conference.config, conference.profile),
+	)
+	conference.events = newEventLog(conference.profile.EventLogSize)
+
+	t.Cleanup(func() {
+		close(conferenceEnded)
+		conference.matrixWorker.stop()
+		conference.peerMessages.Close()
+		conference.telemetry.End()
+	})
+
+	return conference
+}
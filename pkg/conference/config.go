@@ -1,5 +1,13 @@
 package conference
 
+import (
+	"github.com/matrix-org/waterfall/pkg/conference/publisher"
+	"github.com/matrix-org/waterfall/pkg/conference/subscription"
+	"github.com/matrix-org/waterfall/pkg/conference/track"
+	"github.com/matrix-org/waterfall/pkg/webrtc_ext"
+	"maunium.net/go/mautrix/id"
+)
+
 type Heartbeat struct {
 	// Timeout for WebRTC connections. If the client doesn't respond to an
 	// `m.call.ping` with an `m.call.pong` for this amount of time, the
@@ -13,4 +21,433 @@ type Heartbeat struct {
 // Configuration for the group conferences (calls).
 type Config struct {
 	HeartbeatConfig Heartbeat `yaml:"heartbeat"`
+	// Simulated network impairment of the incoming media. Only meant for
+	// load and chaos testing, never enable it in production.
+	Impairment publisher.Impairment `yaml:"impairment"`
+	// Warn about the publishers whose RTP timestamps don't advance at the
+	// clock rate of their codec, e.g. due to the bugs of their encoders.
+	ClockRateValidation publisher.ClockRateValidation `yaml:"clockRateValidation"`
+	// Named bundles of per-conference settings. The profile is selected by name
+	// when the conference is started. A profile named `default` (if present)
+	// overrides the built-in defaults for all other profiles.
+	Profiles map[string]Profile `yaml:"profiles"`
+	// Don't log the SDP offers and answers at all. Otherwise they are logged (with
+	// the ICE credentials and fingerprints redacted) at the debug and trace levels.
+	DisableSDPLogging bool `yaml:"disableSdpLogging"`
+	// Restricts which users may join the conferences (everyone may join if not set).
+	AccessControl AccessControl `yaml:"accessControl"`
+}
+
+// The name of the profile that is used when the invite does not specify any.
+const DefaultProfileName = "default"
+
+// A set of parameters that may differ between the conferences, e.g. a 1:1 HD
+// call and a large grid call. Zero values mean "use the default". The flags are
+// pointers, so that a profile could switch off a flag that the default enables.
+type Profile struct {
+	// Maximum amount of participants in a conference (0 means unlimited).
+	MaxParticipants int `yaml:"maxParticipants"`
+	// After which time (in milliseconds) a publisher that does not send any
+	// packets is considered stalled.
+	StallTimeout int `yaml:"stallTimeout"`
+	// Like `StallTimeout`, but for the screen shares whose content is mostly
+	// static, so that the encoders may send very few packets for a while.
+	ScreenshareStallTimeout int `yaml:"screenshareStallTimeout"`
+	// The minimal interval (in milliseconds) between the key frame requests of
+	// the subscribers that are forwarded to a publisher (0 means unlimited).
+	KeyFrameRequestInterval int `yaml:"keyFrameRequestInterval"`
+	// Like `KeyFrameRequestInterval`, but for the screen shares, whose viewers
+	// notice the missing key frames the most (the former is used if not set).
+	ScreenshareKeyFrameRequestInterval int `yaml:"screenshareKeyFrameRequestInterval"`
+	// After which time (in seconds) a stalled publisher that has been superseded
+	// by a newer one (e.g. after an SSRC change) is removed.
+	StalePublisherTimeout int `yaml:"stalePublisherTimeout"`
+	// Maximum bitrate (in kbps) that a participant is allowed to send to the
+	// SFU (0 means unlimited).
+	MaxPublisherBitrate int `yaml:"maxPublisherBitrate"`
+	// Maximum bitrate (in kbps) that the SFU sends to all participants of the
+	// conference together. Once exceeded, the subscriptions are demoted to the
+	// lower video layers, camera tracks first (0 means unlimited).
+	MaxConferenceBitrate int `yaml:"maxConferenceBitrate"`
+	// How many participants may subscribe to a single track (0 means unlimited).
+	MaxSubscribersPerTrack int `yaml:"maxSubscribersPerTrack"`
+	// Maximum amount of simulcast layers forwarded per video track, the lowest
+	// ones are kept (0 means unlimited).
+	MaxSimulcastLayers int `yaml:"maxSimulcastLayers"`
+	// The largest message (in KiB) sent over the data channel, larger ones (e.g.
+	// the metadata of a big conference) are split into `m.call.chunk` events.
+	MaxDataChannelMessageSize int `yaml:"maxDataChannelMessageSize"`
+	// How much (in KiB) may be buffered for a participant whose data channel can't
+	// keep up before the new messages are dropped (0 means unlimited).
+	MaxDataChannelBufferedAmount int `yaml:"maxDataChannelBufferedAmount"`
+	// Don't trickle ICE candidates, but wait for the gathering to complete and
+	// send all candidates as part of the SDP instead.
+	DisableTrickleICE *bool `yaml:"disableTrickleIce"`
+	// Don't renegotiate until the participant's peer connection is connected for
+	// the first time, the track changes made until then are renegotiated at once.
+	DeferInitialRenegotiation *bool `yaml:"deferInitialRenegotiation"`
+	// Answer the media that the participants publish as `recvonly` and send the
+	// forwarded tracks on separate `sendonly` transceivers instead of the Pion defaults.
+	ExplicitTransceiverDirections *bool `yaml:"explicitTransceiverDirections"`
+	// How many packets may be buffered for each video subscription before the
+	// new ones get dropped. Larger buffers absorb the bursts of high-bitrate tracks.
+	SubscriptionBufferSize int `yaml:"subscriptionBufferSize"`
+	// After which time (in milliseconds) without packets the worker of a video
+	// subscription times out.
+	SubscriptionTimeout int `yaml:"subscriptionTimeout"`
+	// For how long (in milliseconds) the out-of-order video packets wait for the
+	// missing ones before they are forwarded (0 means no reordering).
+	ReorderWindow int `yaml:"reorderWindow"`
+	// How many out-of-order video packets may be held per subscription.
+	ReorderBufferSize int `yaml:"reorderBufferSize"`
+	// Drop the padding-only video packets that the publishers send to probe the
+	// bandwidth instead of forwarding them to the subscribers.
+	DropPadding *bool `yaml:"dropPadding"`
+	// Either `keyframe` (default) to drop the video packets of a subscriber whose
+	// buffer is full and request a key frame once it catches up or `drop` to only
+	// drop them. The publisher is never blocked by a slow subscriber either way.
+	SubscriptionDropPolicy subscription.DropPolicy `yaml:"subscriptionDropPolicy"`
+	// Only 1 in N conferences is traced fully, the others only get the span of
+	// the conference itself without any child spans (0 or 1 means all of them).
+	TelemetrySampling int `yaml:"telemetrySampling"`
+	// Send only the changes of the metadata (`m.call.sdp_stream_metadata_delta`)
+	// instead of the complete metadata on every change, which saves a lot of
+	// traffic in big conferences. The clients must support it.
+	IncrementalMetadata *bool `yaml:"incrementalMetadata"`
+	// Hide the tracks whose streams the clients did not describe in the
+	// `SDPStreamMetadata` instead of describing them by the published tracks
+	// themselves (i.e. as the user media of their owners).
+	RequireStreamMetadata *bool `yaml:"requireStreamMetadata"`
+	// Tell the subscribers the resolution of each simulcast layer of the video
+	// tracks (as the `layers` of the tracks in the metadata), so that they could
+	// pick the layer to request. The resolutions are the ones observed in the key
+	// frames (VP8 only, unless end-to-end encrypted) or derived from the ones
+	// advertised by the publishers.
+	ReportLayerResolutions *bool `yaml:"reportLayerResolutions"`
+	// The frame rate of the camera video that the subscribers get at most (0
+	// means unlimited). Only the VP8 video with temporal layers can be limited,
+	// its resolution stays the same.
+	MaxFrameRate int `yaml:"maxFrameRate"`
+	// The same as `MaxFrameRate` for the screen shares, which usually don't
+	// need as many frames as the cameras.
+	ScreenshareMaxFrameRate int `yaml:"screenshareMaxFrameRate"`
+	// After which time (in seconds) a participant that neither publishes nor
+	// subscribes to anything and does not send any messages is evicted from
+	// the conference (0 means never).
+	IdleTimeout int `yaml:"idleTimeout"`
+	// Slow down the periodic checks of the conference (such as the bandwidth
+	// budget) while all published tracks are muted, there is nothing to check.
+	ThrottleWhenMuted *bool `yaml:"throttleWhenMuted"`
+	// For how long (in seconds) the conference is kept alive after the last
+	// participant leaves, so that they could rejoin (0 means it ends right away).
+	EmptyGracePeriod int `yaml:"emptyGracePeriod"`
+	// How often (in milliseconds) a participant may renegotiate over the data
+	// channel, the offers that come sooner are coalesced (0 means unlimited).
+	MinNegotiationInterval int `yaml:"minNegotiationInterval"`
+	// The simulcast layer (`low`, `medium` or `high`) that is forwarded to the
+	// subscribers that don't specify the desired resolution.
+	DefaultLayer string `yaml:"defaultLayer"`
+	// Either `auto` (default) to select the layers dynamically or `off` to always
+	// forward a single fixed layer that is never switched.
+	SimulcastMode track.SimulcastMode `yaml:"simulcastMode"`
+	// The layer (`low`, `medium` or `high`) that is forwarded when the simulcast
+	// mode is `off`. The highest available layer is used if not set.
+	FixedLayer string `yaml:"fixedLayer"`
+	// Playout delay (in milliseconds) that the subscribers are asked to use for
+	// their jitter buffers. Low values favour latency over smoothness. Not sent if
+	// not set.
+	PlayoutDelay *subscription.PlayoutDelay `yaml:"playoutDelay"`
+	// Maximum bitrates of the forwarded simulcast layers that are hinted to the
+	// subscribers in the SDP, so that their congestion control knows what to
+	// expect. The layers without a bitrate are not hinted.
+	MaxLayerBitrates *LayerBitrates `yaml:"maxLayerBitrates"`
+	// What to do when the participant's (default) data channel gets closed, see
+	// `DataChannelClosePolicy`.
+	DataChannelClosePolicy DataChannelClosePolicy `yaml:"dataChannelClosePolicy"`
+	// What to do with the data channel messages of the types that the SFU does
+	// not know and that have no registered handler, see
+	// `UnknownDataChannelMessagePolicy`.
+	UnknownDataChannelMessagePolicy UnknownDataChannelMessagePolicy `yaml:"unknownDataChannelMessagePolicy"`
+	// The types of the data channel messages that are forwarded when the policy
+	// is `forward`, a type ending with a dot (e.g. `org.example.`) allows all
+	// types with such a prefix. The types in the `m.` namespace are never forwarded.
+	ForwardedDataChannelMessageTypes []string `yaml:"forwardedDataChannelMessageTypes"`
+	// How many data channel messages per second a participant may have forwarded
+	// to others, the rest is dropped (10 if not set).
+	MaxForwardedDataChannelMessages int `yaml:"maxForwardedDataChannelMessages"`
+	// What to do with the tracks whose codecs a subscriber can't receive (the
+	// SFU does not transcode), see `IncompatibleCodecPolicy`.
+	IncompatibleCodecPolicy IncompatibleCodecPolicy `yaml:"incompatibleCodecPolicy"`
+	// Either `unsubscribe` (default) to remove the tracks of a publisher that left
+	// from its subscribers right away or `freeze` to keep them (with the last frame
+	// frozen) until the subscribers renegotiate for another reason.
+	PublisherLeftPolicy track.PublisherLeftPolicy `yaml:"publisherLeftPolicy"`
+	// How many of the recent significant events of the conference (e.g. joins,
+	// stalls or layer switches) are kept for the admin API (200 if not set).
+	EventLogSize int `yaml:"eventLogSize"`
+	// Treat the media of all participants as end-to-end encrypted (e.g. via the
+	// insertable streams), so that the SFU never inspects the payload. The tracks
+	// that are negotiated with SFrame in the SDP are detected automatically.
+	EncryptedMedia *bool `yaml:"encryptedMedia"`
+	// MIME types of the codecs (e.g. `video/VP9`) that the participants are asked to publish in, the most
+	// preferred first. The other codecs that the participants offer may still be used.
+	CodecPreferences []string `yaml:"codecPreferences"`
+	// Once more audio tracks than this are published, only the ones of this many most recent speakers are
+	// forwarded, the others are suppressed until they speak (0 means all audio is forwarded). The speakers
+	// are known from the audio level header extension, the tracks without it never count as speaking.
+	AudioMixingThreshold int `yaml:"audioMixingThreshold"`
+	// User IDs of the presenters. Their tracks are pinned, i.e. they're always
+	// forwarded in the highest available quality and never demoted.
+	Presenters []id.UserID `yaml:"presenters"`
+}
+
+// Bitrates (in kbps) of the simulcast layers, 0 means none.
+type LayerBitrates struct {
+	Low    int `yaml:"low"`
+	Medium int `yaml:"medium"`
+	High   int `yaml:"high"`
+}
+
+// Returns the bitrates (in bits per second) of the layers that have one, `nil` if none.
+func (b *LayerBitrates) bitsPerSecond() map[webrtc_ext.SimulcastLayer]uint64 {
+	if b == nil {
+		return nil
+	}
+
+	bitrates := make(map[webrtc_ext.SimulcastLayer]uint64)
+	for layer, kbps := range map[webrtc_ext.SimulcastLayer]int{
+		webrtc_ext.SimulcastLayerLow:    b.Low,
+		webrtc_ext.SimulcastLayerMedium: b.Medium,
+		webrtc_ext.SimulcastLayerHigh:   b.High,
+	} {
+		if kbps > 0 {
+			bitrates[layer] = uint64(kbps) * 1000
+		}
+	}
+
+	return bitrates
+}
+
+// Defines how the SFU reacts to a closed data channel. Since the subscriptions are
+// controlled over the data channel, the participant can't control them anymore.
+type DataChannelClosePolicy string
+
+const (
+	// Unsubscribe the participant from all tracks, but keep it in the conference (default).
+	DataChannelClosePolicyUnsubscribe DataChannelClosePolicy = "unsubscribe"
+	// Remove the participant from the conference.
+	DataChannelClosePolicyHangup DataChannelClosePolicy = "hangup"
+)
+
+// Defines what happens to the data channel messages of the types that the SFU does not know, e.g. the
+// ones that the newer clients send. The types with a registered `DataChannelHandler` are not affected.
+type UnknownDataChannelMessagePolicy string
+
+const (
+	// Drop the message with a warning (default).
+	UnknownDataChannelMessagePolicyWarn UnknownDataChannelMessagePolicy = "warn"
+	// Drop the message quietly.
+	UnknownDataChannelMessagePolicyIgnore UnknownDataChannelMessagePolicy = "ignore"
+	// Forward the message as is to all other participants (with the sender's user ID), so that the clients
+	// could experiment with the new signaling on their own. Only the types listed in
+	// `Profile.ForwardedDataChannelMessageTypes` are forwarded and only up to
+	// `Profile.MaxForwardedDataChannelMessages` per second. The message is dropped for the participants
+	// whose data channel is congested.
+	UnknownDataChannelMessagePolicyForward UnknownDataChannelMessagePolicy = "forward"
+)
+
+// Defines how the SFU treats the tracks that a subscriber can't receive, since the media is forwarded as is
+// and the subscriber has not declared the codec of the track. Either way, the attempts to subscribe to such
+// tracks are refused and counted, so that the operators know how often the transcoding would be needed.
+type IncompatibleCodecPolicy string
+
+const (
+	// Advertise the track to the subscriber, but refuse the subscription with an error (default).
+	IncompatibleCodecPolicyRefuse IncompatibleCodecPolicy = "refuse"
+	// Leave the track out of the metadata that the subscriber gets, so that it does not try to subscribe.
+	IncompatibleCodecPolicyHide IncompatibleCodecPolicy = "hide"
+)
+
+// Checks if a given user is a designated presenter.
+func (p Profile) IsPresenter(userID id.UserID) bool {
+	for _, presenter := range p.Presenters {
+		if presenter == userID {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Checks if an (optional) flag of the profile is set to `true`.
+func enabled(flag *bool) bool {
+	return flag != nil && *flag
+}
+
+// Built-in defaults that are used for all fields that are not set in the profile.
+var defaultProfile = Profile{
+	MaxParticipants:         0,
+	StallTimeout:            2000,
+	ScreenshareStallTimeout: 5000,
+}
+
+// Returns the effective profile for a given name. Unknown names fall back to the
+// default profile. Fields that are not set in the profile are taken from the
+// `default` profile from the config and then from the built-in defaults.
+func (c Config) Profile(name string) Profile {
+	fallback := defaultProfile.merge(c.Profiles[DefaultProfileName])
+
+	profile, found := c.Profiles[name]
+	if !found {
+		return fallback
+	}
+
+	return fallback.merge(profile)
+}
+
+// Returns a copy of the profile with the non-zero fields of `other` applied on top.
+func (p Profile) merge(other Profile) Profile {
+	if other.MaxParticipants != 0 {
+		p.MaxParticipants = other.MaxParticipants
+	}
+	if other.StallTimeout != 0 {
+		p.StallTimeout = other.StallTimeout
+	}
+	if other.ScreenshareStallTimeout != 0 {
+		p.ScreenshareStallTimeout = other.ScreenshareStallTimeout
+	}
+	if other.KeyFrameRequestInterval != 0 {
+		p.KeyFrameRequestInterval = other.KeyFrameRequestInterval
+	}
+	if other.ScreenshareKeyFrameRequestInterval != 0 {
+		p.ScreenshareKeyFrameRequestInterval = other.ScreenshareKeyFrameRequestInterval
+	}
+	if other.StalePublisherTimeout != 0 {
+		p.StalePublisherTimeout = other.StalePublisherTimeout
+	}
+	if other.MaxPublisherBitrate != 0 {
+		p.MaxPublisherBitrate = other.MaxPublisherBitrate
+	}
+	if other.MaxConferenceBitrate != 0 {
+		p.MaxConferenceBitrate = other.MaxConferenceBitrate
+	}
+	if other.MaxSubscribersPerTrack != 0 {
+		p.MaxSubscribersPerTrack = other.MaxSubscribersPerTrack
+	}
+	if other.EventLogSize != 0 {
+		p.EventLogSize = other.EventLogSize
+	}
+	if other.MaxSimulcastLayers != 0 {
+		p.MaxSimulcastLayers = other.MaxSimulcastLayers
+	}
+	if other.MaxDataChannelMessageSize != 0 {
+		p.MaxDataChannelMessageSize = other.MaxDataChannelMessageSize
+	}
+	if other.MaxDataChannelBufferedAmount != 0 {
+		p.MaxDataChannelBufferedAmount = other.MaxDataChannelBufferedAmount
+	}
+	if other.DisableTrickleICE != nil {
+		p.DisableTrickleICE = other.DisableTrickleICE
+	}
+	if other.DeferInitialRenegotiation != nil {
+		p.DeferInitialRenegotiation = other.DeferInitialRenegotiation
+	}
+	if other.ExplicitTransceiverDirections != nil {
+		p.ExplicitTransceiverDirections = other.ExplicitTransceiverDirections
+	}
+	if other.SubscriptionBufferSize != 0 {
+		p.SubscriptionBufferSize = other.SubscriptionBufferSize
+	}
+	if other.SubscriptionTimeout != 0 {
+		p.SubscriptionTimeout = other.SubscriptionTimeout
+	}
+	if other.ReorderWindow != 0 {
+		p.ReorderWindow = other.ReorderWindow
+	}
+	if other.ReorderBufferSize != 0 {
+		p.ReorderBufferSize = other.ReorderBufferSize
+	}
+	if other.DropPadding != nil {
+		p.DropPadding = other.DropPadding
+	}
+	if other.SubscriptionDropPolicy != "" {
+		p.SubscriptionDropPolicy = other.SubscriptionDropPolicy
+	}
+	if other.TelemetrySampling != 0 {
+		p.TelemetrySampling = other.TelemetrySampling
+	}
+	if other.IncrementalMetadata != nil {
+		p.IncrementalMetadata = other.IncrementalMetadata
+	}
+	if other.RequireStreamMetadata != nil {
+		p.RequireStreamMetadata = other.RequireStreamMetadata
+	}
+	if other.ReportLayerResolutions != nil {
+		p.ReportLayerResolutions = other.ReportLayerResolutions
+	}
+	if other.MaxFrameRate != 0 {
+		p.MaxFrameRate = other.MaxFrameRate
+	}
+	if other.ScreenshareMaxFrameRate != 0 {
+		p.ScreenshareMaxFrameRate = other.ScreenshareMaxFrameRate
+	}
+	if other.IdleTimeout != 0 {
+		p.IdleTimeout = other.IdleTimeout
+	}
+	if other.ThrottleWhenMuted != nil {
+		p.ThrottleWhenMuted = other.ThrottleWhenMuted
+	}
+	if other.EmptyGracePeriod != 0 {
+		p.EmptyGracePeriod = other.EmptyGracePeriod
+	}
+	if other.MinNegotiationInterval != 0 {
+		p.MinNegotiationInterval = other.MinNegotiationInterval
+	}
+	if other.DefaultLayer != "" {
+		p.DefaultLayer = other.DefaultLayer
+	}
+	if other.SimulcastMode != "" {
+		p.SimulcastMode = other.SimulcastMode
+	}
+	if other.FixedLayer != "" {
+		p.FixedLayer = other.FixedLayer
+	}
+	if other.PlayoutDelay != nil {
+		p.PlayoutDelay = other.PlayoutDelay
+	}
+	if other.MaxLayerBitrates != nil {
+		p.MaxLayerBitrates = other.MaxLayerBitrates
+	}
+	if other.EncryptedMedia != nil {
+		p.EncryptedMedia = other.EncryptedMedia
+	}
+	if other.DataChannelClosePolicy != "" {
+		p.DataChannelClosePolicy = other.DataChannelClosePolicy
+	}
+	if other.UnknownDataChannelMessagePolicy != "" {
+		p.UnknownDataChannelMessagePolicy = other.UnknownDataChannelMessagePolicy
+	}
+	if len(other.ForwardedDataChannelMessageTypes) != 0 {
+		p.ForwardedDataChannelMessageTypes = other.ForwardedDataChannelMessageTypes
+	}
+	if other.MaxForwardedDataChannelMessages != 0 {
+		p.MaxForwardedDataChannelMessages = other.MaxForwardedDataChannelMessages
+	}
+	if other.IncompatibleCodecPolicy != "" {
+		p.IncompatibleCodecPolicy = other.IncompatibleCodecPolicy
+	}
+	if other.PublisherLeftPolicy != "" {
+		p.PublisherLeftPolicy = other.PublisherLeftPolicy
+	}
+	if len(other.CodecPreferences) != 0 {
+		p.CodecPreferences = other.CodecPreferences
+	}
+	if other.AudioMixingThreshold != 0 {
+		p.AudioMixingThreshold = other.AudioMixingThreshold
+	}
+	if len(other.Presenters) != 0 {
+		p.Presenters = other.Presenters
+	}
+
+	return p
 }
@@ -0,0 +1,59 @@
+package conference_test
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/matrix-org/waterfall/pkg/conference"
+)
+
+func TestProfileSelection(t *testing.T) {
+	config := conference.Config{
+		Profiles: map[string]conference.Profile{
+			"default": {StallTimeout: 3000},
+			"hd":      {MaxParticipants: 2},
+			"grid":    {MaxParticipants: 50, StallTimeout: 5000},
+		},
+	}
+
+	cases := []struct {
+		name     string
+		expected conference.Profile
+	}{
+		{"hd", conference.Profile{MaxParticipants: 2, StallTimeout: 3000, ScreenshareStallTimeout: 5000}},
+		{"grid", conference.Profile{MaxParticipants: 50, StallTimeout: 5000, ScreenshareStallTimeout: 5000}},
+		{"default", conference.Profile{MaxParticipants: 0, StallTimeout: 3000, ScreenshareStallTimeout: 5000}},
+		{"unknown", conference.Profile{MaxParticipants: 0, StallTimeout: 3000, ScreenshareStallTimeout: 5000}},
+	}
+
+	for _, c := range cases {
+		if profile := config.Profile(c.name); !reflect.DeepEqual(profile, c.expected) {
+			t.Errorf("Profile %s: expected %+v, got %+v", c.name, c.expected, profile)
+		}
+	}
+}
+
+func TestProfileBuiltInDefaults(t *testing.T) {
+	profile := conference.Config{}.Profile(conference.DefaultProfileName)
+	if profile.StallTimeout != 2000 || profile.ScreenshareStallTimeout != 5000 || profile.MaxParticipants != 0 {
+		t.Fatalf("Unexpected built-in default profile: %+v", profile)
+	}
+}
+
+func TestProfileDisablesDefaultFlag(t *testing.T) {
+	enabled, disabled := true, false
+	config := conference.Config{
+		Profiles: map[string]conference.Profile{
+			"default": {IncrementalMetadata: &enabled, DropPadding: &enabled},
+			"legacy":  {IncrementalMetadata: &disabled},
+		},
+	}
+
+	profile := config.Profile("legacy")
+	if profile.IncrementalMetadata == nil || *profile.IncrementalMetadata {
+		t.Errorf("Expected the profile to switch off the incremental metadata, got %v", profile.IncrementalMetadata)
+	}
+	if profile.DropPadding == nil || !*profile.DropPadding {
+		t.Errorf("Expected the profile to inherit the padding dropping, got %v", profile.DropPadding)
+	}
+}
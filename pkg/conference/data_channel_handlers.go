@@ -0,0 +1,130 @@
+package conference
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/matrix-org/waterfall/pkg/conference/participant"
+	"maunium.net/go/mautrix/event"
+)
+
+// Handles the data channel messages of a custom type, see `RegisterDataChannelHandler()`. It's called from
+// the main loop of the conference, so it must not block. The content is the raw JSON content of the event.
+type DataChannelHandler func(sender *participant.Participant, content json.RawMessage)
+
+var (
+	dataChannelHandlers      = make(map[string]DataChannelHandler)
+	dataChannelHandlersMutex sync.RWMutex
+)
+
+// Registers the handler of the data channel messages of a given type, so that the experimental signaling could
+// be added without changing the conference. The built-in types (such as `m.call.negotiate`) are always handled
+// by the conference itself. The handlers are expected to be registered on startup, so it panics if a given type
+// has a handler already.
+func RegisterDataChannelHandler(eventType event.Type, handler DataChannelHandler) {
+	dataChannelHandlersMutex.Lock()
+	defer dataChannelHandlersMutex.Unlock()
+
+	if _, found := dataChannelHandlers[eventType.Type]; found {
+		panic(fmt.Sprintf("data channel handler for %s is already registered", eventType.Type))
+	}
+
+	dataChannelHandlers[eventType.Type] = handler
+}
+
+// Returns the registered handler of the data channel messages of a given type (if any).
+func dataChannelHandler(eventType string) (DataChannelHandler, bool) {
+	dataChannelHandlersMutex.RLock()
+	defer dataChannelHandlersMutex.RUnlock()
+
+	handler, found := dataChannelHandlers[eventType]
+	return handler, found
+}
+
+// Handles the data channel message of a type that the conference does not know itself.
+func (c *Conference) processCustomDataChannelMessage(p *participant.Participant, focusEvent event.Event) {
+	if handler, found := dataChannelHandler(focusEvent.Type.Type); found {
+		handler(p, focusEvent.Content.VeryRaw)
+		return
+	}
+
+	logger := p.Logger.WithField("type", focusEvent.Type.Type)
+	switch c.profile.UnknownDataChannelMessagePolicy {
+	case UnknownDataChannelMessagePolicyIgnore:
+		logger.Debug("Ignoring data channel message of unknown type")
+	case UnknownDataChannelMessagePolicyForward:
+		if !isForwardedType(c.profile.ForwardedDataChannelMessageTypes, focusEvent.Type.Type) {
+			logger.Warn("Not forwarding data channel message of a type that is not allowed")
+			return
+		}
+
+		if !allowForwarding(p, time.Now(), c.profile.MaxForwardedDataChannelMessages) {
+			logger.Debug("Dropping data channel message, the participant forwards too many of them")
+			return
+		}
+
+		forwarded := event.Event{
+			Type:    focusEvent.Type,
+			Sender:  p.ID.UserID,
+			Content: event.Content{VeryRaw: focusEvent.Content.VeryRaw},
+		}
+
+		c.tracker.ForEachParticipant(func(id participant.ID, receiver *participant.Participant) {
+			if id == p.ID {
+				return
+			}
+
+			if err := receiver.SendLowPriorityOverDataChannel(forwarded); err != nil {
+				receiver.Logger.WithField("type", focusEvent.Type.Type).Debugf("Failed to forward message: %v", err)
+			}
+		})
+	default:
+		logger.Warn("Received data channel message of unknown type")
+	}
+}
+
+// The namespace of the types defined by the Matrix spec, including the ones that the SFU sends itself. The
+// participants must never be able to impersonate the SFU, so such types are never forwarded.
+const matrixNamespace = "m."
+
+// How many data channel messages per second a participant may have forwarded by default.
+const defaultMaxForwardedDataChannelMessages = 10
+
+// Checks if the data channel messages of a given type may be forwarded to others. The allowed types either
+// match exactly or, if they end with a dot, by the prefix.
+func isForwardedType(allowed []string, eventType string) bool {
+	if strings.HasPrefix(eventType, matrixNamespace) {
+		return false
+	}
+
+	for _, allowedType := range allowed {
+		if eventType == allowedType || (strings.HasSuffix(allowedType, ".") && strings.HasPrefix(eventType, allowedType)) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Counts a message that a participant wants to have forwarded. Returns `false` if it has already forwarded
+// `limit` messages (or the default amount if not set) within the current second.
+func allowForwarding(p *participant.Participant, now time.Time, limit int) bool {
+	if limit <= 0 {
+		limit = defaultMaxForwardedDataChannelMessages
+	}
+
+	if now.Sub(p.ForwardingStart) >= time.Second {
+		p.ForwardingStart = now
+		p.ForwardedMessages = 0
+	}
+
+	if p.ForwardedMessages >= limit {
+		return false
+	}
+
+	p.ForwardedMessages++
+	return true
+}
@@ -0,0 +1,102 @@
+package conference //nolint:testpackage
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/matrix-org/waterfall/pkg/conference/participant"
+	"github.com/matrix-org/waterfall/pkg/peer"
+	"github.com/matrix-org/waterfall/pkg/telemetry"
+	"github.com/sirupsen/logrus"
+	"maunium.net/go/mautrix/event"
+)
+
+func TestCustomDataChannelHandler(t *testing.T) {
+	conference := newTestConference(t)
+	tracker := conference.tracker
+	logger := logrus.NewEntry(logrus.New())
+	tel := telemetry.NewTelemetry(context.Background(), "Conference")
+
+	alice := participant.ID{UserID: "@alice:example.org", DeviceID: "ALICE", CallID: "call"}
+	tracker.AddParticipant(&participant.Participant{ID: alice, Logger: logger, Telemetry: tel})
+
+	experiment := event.Type{Type: "org.example.experiment", Class: event.FocusEventType}
+	var received []string
+	RegisterDataChannelHandler(experiment, func(sender *participant.Participant, content json.RawMessage) {
+		var parsed struct {
+			Value string `json:"value"`
+		}
+		if err := json.Unmarshal(content, &parsed); err != nil {
+			t.Errorf("Failed to unmarshal content: %v", err)
+		}
+		received = append(received, sender.ID.DeviceID.String()+":"+parsed.Value)
+	})
+
+	conference.processDataChannelMessage(alice, peer.DataChannelMessage{
+		Message: `{"type": "org.example.experiment", "content": {"value": "hello"}}`,
+	})
+	if len(received) != 1 || received[0] != "ALICE:hello" {
+		t.Errorf("Expected the message to be handled by the registered handler, got %v", received)
+	}
+
+	// The messages of other unknown types don't reach the handler, whatever the policy.
+	for _, policy := range []UnknownDataChannelMessagePolicy{"", UnknownDataChannelMessagePolicyIgnore} {
+		conference.profile.UnknownDataChannelMessagePolicy = policy
+		conference.processDataChannelMessage(alice, peer.DataChannelMessage{
+			Message: `{"type": "org.example.unknown", "content": {"value": "hello"}}`,
+		})
+	}
+	if len(received) != 1 {
+		t.Errorf("Expected the messages of other types not to be handled, got %v", received)
+	}
+
+	// A type may only have a single handler.
+	defer func() {
+		if recover() == nil {
+			t.Error("Expected the second registration of the same type to panic")
+		}
+	}()
+	RegisterDataChannelHandler(experiment, func(*participant.Participant, json.RawMessage) {})
+}
+
+func TestForwardedDataChannelMessageTypes(t *testing.T) {
+	allowed := []string{"org.example.experiment", "com.example.", "m.call.", "m.call.chunk"}
+
+	for eventType, expected := range map[string]bool{
+		"org.example.experiment":           true,
+		"org.example.experiment.other":     false,
+		"org.example.unknown":              false,
+		"com.example.reactions":            true,
+		"com.example":                      false,
+		"m.call.chunk":                     false,
+		"m.call.sdp_stream_metadata_delta": false,
+		"m.call.track_subscription_failed": false,
+		"m.call.presence":                  false,
+	} {
+		if forwarded := isForwardedType(allowed, eventType); forwarded != expected {
+			t.Errorf("Expected %s to be forwarded: %v, got %v", eventType, expected, forwarded)
+		}
+	}
+}
+
+func TestForwardingIsRateLimited(t *testing.T) {
+	p := &participant.Participant{}
+	start := time.Now()
+
+	forwarded := 0
+	for i := 0; i < 5; i++ {
+		if allowForwarding(p, start.Add(time.Duration(i)*10*time.Millisecond), 3) {
+			forwarded++
+		}
+	}
+	if forwarded != 3 {
+		t.Errorf("Expected 3 messages to be forwarded within a second, got %d", forwarded)
+	}
+
+	// The participant may forward again once the second is over.
+	if !allowForwarding(p, start.Add(time.Second), 3) {
+		t.Error("Expected the message to be forwarded in the next second")
+	}
+}
@@ -0,0 +1,203 @@
+package conference //nolint:testpackage
+
+import (
+	"context"
+	"encoding/json"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/matrix-org/waterfall/pkg/conference/participant"
+	"github.com/matrix-org/waterfall/pkg/conference/track"
+	"github.com/matrix-org/waterfall/pkg/peer"
+	"github.com/matrix-org/waterfall/pkg/signaling"
+	"github.com/matrix-org/waterfall/pkg/telemetry"
+	"github.com/sirupsen/logrus"
+)
+
+// Creates a conference with a given policy in which Bob is subscribed to the microphone of Alice.
+func newSubscribedConference(
+	t *testing.T,
+	policy DataChannelClosePolicy,
+) (*Conference, *recordingSignaler, participant.ID, participant.ID) {
+	t.Helper()
+
+	signaler := &recordingSignaler{messages: make(chan signaling.MatrixMessage, 1)}
+	conference := newTestConference(t, withSignaler(signaler), withProfile(Profile{DataChannelClosePolicy: policy}))
+	tracker := conference.tracker
+	logger := logrus.NewEntry(logrus.New())
+	tel := telemetry.NewTelemetry(context.Background(), "Conference")
+
+	alice := participant.ID{UserID: "@alice:example.org", DeviceID: "ALICE", CallID: "secret"}
+	bob := participant.ID{UserID: "@bob:example.org", DeviceID: "BOB", CallID: "secret"}
+	for _, id := range []participant.ID{alice, bob} {
+		tracker.AddParticipant(&participant.Participant{ID: id, Peer: newSubscriberPeer(t, id), Logger: logger, Telemetry: tel})
+	}
+
+	for _, remoteTrack := range publishAudioTracks(t, "stream", "mic") {
+		if err := tracker.AddPublishedTrack(alice, remoteTrack, track.TrackMetadata{}); err != nil {
+			t.Fatalf("Failed to publish %s: %v", remoteTrack.ID(), err)
+		}
+	}
+
+	if err := tracker.Subscribe(bob, "mic", 0, 0); err != nil {
+		t.Fatalf("Failed to subscribe: %v", err)
+	}
+
+	return conference, signaler, alice, bob
+}
+
+func TestDataChannelClosedKeepsParticipant(t *testing.T) {
+	conference, signaler, _, bob := newSubscribedConference(t, DataChannelClosePolicyUnsubscribe)
+	tracker := conference.tracker
+
+	// Other data channels don't control the subscriptions.
+	conference.processDataChannelClosedMessage(bob, peer.DataChannelClosed{Label: "custom", Default: false})
+	if mappings := tracker.SSRCMappings(); len(mappings) != 1 {
+		t.Fatalf("Expected the subscription to be kept when a custom data channel is closed, got %+v", mappings)
+	}
+
+	conference.processDataChannelClosedMessage(bob, peer.DataChannelClosed{Label: "default", Default: true})
+	if tracker.GetParticipant(bob) == nil {
+		t.Fatal("Participant must not be removed when its default data channel is closed")
+	}
+
+	if mappings := tracker.SSRCMappings(); len(mappings) != 0 {
+		t.Errorf("Expected the participant to be unsubscribed, got %+v", mappings)
+	}
+
+	if senders := tracker.GetParticipant(bob).Peer.ActiveSenders(); senders != 0 {
+		t.Errorf("Expected no tracks to be sent to the participant, got %d", senders)
+	}
+
+	select {
+	case msg := <-signaler.messages:
+		t.Errorf("Expected the participant not to be hung up, got %+v", msg)
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	// Messages for participants that have already been removed are ignored.
+	conference.processDataChannelClosedMessage(participant.ID{UserID: "@carol:example.org"}, peer.DataChannelClosed{})
+}
+
+func TestDataChannelClosedHangsUp(t *testing.T) {
+	conference, signaler, alice, bob := newSubscribedConference(t, DataChannelClosePolicyHangup)
+	tracker := conference.tracker
+
+	conference.processDataChannelClosedMessage(bob, peer.DataChannelClosed{Label: "default", Default: true})
+	if tracker.GetParticipant(bob) != nil {
+		t.Fatal("Expected the participant to be removed once its default data channel is closed")
+	}
+
+	if tracker.GetParticipant(alice) == nil {
+		t.Error("Expected the other participants to be kept")
+	}
+
+	if mappings := tracker.SSRCMappings(); len(mappings) != 0 {
+		t.Errorf("Expected the subscription of the removed participant to be gone, got %+v", mappings)
+	}
+
+	select {
+	case msg := <-signaler.messages:
+		if hangup, ok := msg.Message.(signaling.Hangup); !ok || hangup.Reason != hangupDataChannelClosed {
+			t.Errorf("Expected a hangup with %s, got %+v", hangupDataChannelClosed, msg.Message)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Expected the participant to be hung up")
+	}
+}
+
+func TestReplacedDataChannelKeepsControl(t *testing.T) {
+	conference, _, _, bob := newSubscribedConference(t, DataChannelClosePolicyHangup)
+
+	// Another data channel took over, so the participant still controls its subscriptions.
+	conference.processDataChannelClosedMessage(bob, peer.DataChannelClosed{Label: "default", Default: true, Replaced: true})
+	if conference.tracker.GetParticipant(bob) == nil {
+		t.Fatal("Participant must not be removed when another data channel took over")
+	}
+
+	if mappings := conference.tracker.SSRCMappings(); len(mappings) != 1 {
+		t.Errorf("Expected the subscription to be kept, got %+v", mappings)
+	}
+}
+
+// Creates a conference that forwards the data channel messages of the allowed types, at most `limit` per second
+// from each participant. Returns it along with Bob, who sends the messages, and what Alice receives.
+func newForwardingConference(t *testing.T, allowed []string, limit int) (*Conference, participant.ID, <-chan string) {
+	t.Helper()
+
+	conference := newTestConference(t, withProfile(Profile{
+		UnknownDataChannelMessagePolicy:  UnknownDataChannelMessagePolicyForward,
+		ForwardedDataChannelMessageTypes: allowed,
+		MaxForwardedDataChannelMessages:  limit,
+	}))
+
+	alice := participant.ID{UserID: "@alice:example.org", DeviceID: "ALICE", CallID: "call"}
+	bob := participant.ID{UserID: "@bob:example.org", DeviceID: "BOB", CallID: "call"}
+
+	alicePeer, received := newConnectedParticipant(t, alice)
+	conference.tracker.AddParticipant(alicePeer)
+	conference.tracker.AddParticipant(&participant.Participant{
+		ID:        bob,
+		Peer:      newSubscriberPeer(t, bob),
+		Logger:    logrus.NewEntry(logrus.New()),
+		Telemetry: telemetry.NewTelemetry(context.Background(), "Participant"),
+	})
+
+	return conference, bob, received
+}
+
+// Returns the types and the values of the messages that arrive until none arrives for a while.
+func receiveForwarded(t *testing.T, received <-chan string) []string {
+	t.Helper()
+
+	forwarded := []string{}
+	for {
+		select {
+		case msg := <-received:
+			var parsed struct {
+				Type    string `json:"type"`
+				Content struct {
+					Value string `json:"value"`
+				} `json:"content"`
+			}
+			if err := json.Unmarshal([]byte(msg), &parsed); err != nil {
+				t.Fatalf("Failed to unmarshal the forwarded message: %v", err)
+			}
+			forwarded = append(forwarded, parsed.Type+":"+parsed.Content.Value)
+		case <-time.After(500 * time.Millisecond):
+			return forwarded
+		}
+	}
+}
+
+func TestDisallowedDataChannelMessageIsDropped(t *testing.T) {
+	conference, bob, received := newForwardingConference(t, []string{"org.example.reactions"}, 0)
+
+	// The messages arrive in order, so the dropped ones would arrive before the allowed one.
+	for _, eventType := range []string{"org.example.unknown", "m.call.presence", "org.example.reactions"} {
+		conference.processDataChannelMessage(bob, peer.DataChannelMessage{
+			Message: `{"type": "` + eventType + `", "content": {"value": "hello"}}`,
+		})
+	}
+
+	expected := []string{"org.example.reactions:hello"}
+	if forwarded := receiveForwarded(t, received); !reflect.DeepEqual(forwarded, expected) {
+		t.Errorf("Expected only %v to be forwarded, got %v", expected, forwarded)
+	}
+}
+
+func TestDataChannelMessagesOverLimitAreDropped(t *testing.T) {
+	conference, bob, received := newForwardingConference(t, []string{"org.example.reactions"}, 2)
+
+	for _, value := range []string{"first", "second", "third", "fourth"} {
+		conference.processDataChannelMessage(bob, peer.DataChannelMessage{
+			Message: `{"type": "org.example.reactions", "content": {"value": "` + value + `"}}`,
+		})
+	}
+
+	expected := []string{"org.example.reactions:first", "org.example.reactions:second"}
+	if forwarded := receiveForwarded(t, received); !reflect.DeepEqual(forwarded, expected) {
+		t.Errorf("Expected only %v to be forwarded within a second, got %v", expected, forwarded)
+	}
+}
@@ -0,0 +1,42 @@
+package conference
+
+import "time"
+
+// Keeps an empty conference alive for a while, so that the last participant could
+// reconnect (e.g. after a network hiccup) without losing the state of the conference.
+type emptyGracePeriod struct {
+	// Running while the conference is empty (nil otherwise).
+	timer *time.Timer
+}
+
+// Informs about the end of the grace period (never fires if the conference is not empty).
+func (g *emptyGracePeriod) expired() <-chan time.Time {
+	if g.timer == nil {
+		return nil
+	}
+
+	return g.timer.C
+}
+
+// Starts the grace period once the conference gets empty and cancels it once someone joins.
+// Returns true if the conference must end right away, i.e. if there is no grace period.
+func (g *emptyGracePeriod) update(empty bool, duration time.Duration) bool {
+	switch {
+	case !empty:
+		if g.timer != nil {
+			g.timer.Stop()
+			g.timer = nil
+		}
+	case duration <= 0:
+		return true
+	case g.timer == nil:
+		g.timer = time.NewTimer(duration)
+	}
+
+	return false
+}
+
+// Stops the grace period (if any).
+func (g *emptyGracePeriod) stop() {
+	g.update(false, 0)
+}
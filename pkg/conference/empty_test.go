@@ -0,0 +1,53 @@
+package conference //nolint:testpackage
+
+import (
+	"testing"
+	"time"
+)
+
+func TestEmptyConferenceEndsWithoutGracePeriod(t *testing.T) {
+	var gracePeriod emptyGracePeriod
+
+	if !gracePeriod.update(true, 0) {
+		t.Error("Expected the empty conference to end right away")
+	}
+}
+
+func TestEmptyConferenceRejoinWithinGracePeriod(t *testing.T) {
+	var gracePeriod emptyGracePeriod
+
+	if gracePeriod.update(true, 50*time.Millisecond) {
+		t.Fatal("Expected the empty conference to wait for the grace period")
+	}
+
+	// The participant reconnects before the grace period is over.
+	if gracePeriod.update(false, 50*time.Millisecond) {
+		t.Fatal("Expected the conference to go on")
+	}
+
+	select {
+	case <-gracePeriod.expired():
+		t.Error("Expected the grace period to be cancelled")
+	case <-time.After(100 * time.Millisecond):
+	}
+}
+
+func TestEmptyConferenceStaysEmpty(t *testing.T) {
+	var gracePeriod emptyGracePeriod
+	defer gracePeriod.stop()
+
+	gracePeriod.update(true, 50*time.Millisecond)
+	timer := gracePeriod.timer
+
+	// More messages are processed while the conference is empty, the grace period must not be restarted.
+	gracePeriod.update(true, 50*time.Millisecond)
+	if gracePeriod.timer != timer {
+		t.Error("Expected the grace period not to be restarted")
+	}
+
+	select {
+	case <-gracePeriod.expired():
+	case <-time.After(time.Second):
+		t.Error("Expected the grace period to expire")
+	}
+}
@@ -0,0 +1,143 @@
+package conference
+
+import (
+	"time"
+
+	"github.com/matrix-org/waterfall/pkg/conference/participant"
+	"github.com/matrix-org/waterfall/pkg/webrtc_ext"
+	"maunium.net/go/mautrix/id"
+)
+
+// How many events the conference keeps if the profile does not say otherwise.
+const defaultEventLogSize = 200
+
+// Kinds of the events that are recorded in the event log of the conference.
+const (
+	EventParticipantJoined = "participant_joined"
+	EventParticipantLeft   = "participant_left"
+	EventTrackPublished    = "track_published"
+	EventTrackFailed       = "track_failed"
+	EventLayerLimited      = "layer_limited"
+	EventLayersPaused      = "layers_paused"
+	EventLayerStalled      = "layer_stalled"
+	EventLayerRecovered    = "layer_recovered"
+)
+
+// A significant event in the life of the conference, kept for the post-mortem debugging.
+type LoggedEvent struct {
+	Time     time.Time   `json:"time"`
+	Kind     string      `json:"kind"`
+	UserID   id.UserID   `json:"user_id,omitempty"`
+	DeviceID id.DeviceID `json:"device_id,omitempty"`
+	TrackID  string      `json:"track_id,omitempty"`
+	// Human-readable details, e.g. the layer that has stalled.
+	Details string `json:"details,omitempty"`
+}
+
+// A bounded log of the recent events of the conference. Once it's full, the oldest events are overwritten.
+// It's only accessed from the main loop of the conference, so it does not need any locking.
+type eventLog struct {
+	events []LoggedEvent
+	// Where the next event is written.
+	next int
+	// Whether the log has wrapped around, i.e. all `events` are in use.
+	full bool
+}
+
+func newEventLog(size int) *eventLog {
+	if size <= 0 {
+		size = defaultEventLogSize
+	}
+
+	return &eventLog{events: make([]LoggedEvent, size)}
+}
+
+// Records an event of a given participant (if any), evicting the oldest event if the log is full.
+func (l *eventLog) record(kind string, sender participant.ID, trackID string, details string) {
+	if l == nil {
+		return
+	}
+
+	l.events[l.next] = LoggedEvent{
+		Time:     time.Now(),
+		Kind:     kind,
+		UserID:   sender.UserID,
+		DeviceID: sender.DeviceID,
+		TrackID:  trackID,
+		Details:  details,
+	}
+
+	l.next = (l.next + 1) % len(l.events)
+	if l.next == 0 {
+		l.full = true
+	}
+}
+
+// Returns a copy of the recorded events, the oldest first.
+func (l *eventLog) list() []LoggedEvent {
+	if l == nil {
+		return []LoggedEvent{}
+	}
+
+	if !l.full {
+		return append([]LoggedEvent{}, l.events[:l.next]...)
+	}
+
+	return append(append([]LoggedEvent{}, l.events[l.next:]...), l.events[:l.next]...)
+}
+
+// A simulcast layer of a published track that has stalled.
+type stalledLayer struct {
+	trackID string
+	layer   webrtc_ext.SimulcastLayer
+}
+
+// Records the layers that have stalled or recovered since the last time it has been called.
+func (c *Conference) recordStalledLayers() {
+	stalled := make(map[stalledLayer]participant.ID)
+	tracks := make(map[string]struct{})
+	c.tracker.ForEachPublishedTrackInfo(func(owner participant.ID, info webrtc_ext.TrackInfo) {
+		tracks[info.TrackID] = struct{}{}
+		for _, layer := range c.tracker.StalledLayers(info.TrackID) {
+			stalled[stalledLayer{info.TrackID, layer}] = owner
+		}
+	})
+
+	for key, owner := range stalled {
+		if _, found := c.stalledLayers[key]; !found {
+			c.events.record(EventLayerStalled, owner, key.trackID, key.layer.String())
+		}
+	}
+
+	// The layers of the tracks that are gone are not worth a record, their removal has been recorded.
+	for key, owner := range c.stalledLayers {
+		_, stillStalled := stalled[key]
+		_, published := tracks[key.trackID]
+		if published && !stillStalled {
+			c.events.record(EventLayerRecovered, owner, key.trackID, key.layer.String())
+		}
+	}
+
+	c.stalledLayers = stalled
+}
+
+// Sent by the router when an operator requested the recent events of the conference.
+type EventLogRequested struct {
+	// Receives the events, must be buffered.
+	Result chan<- EventLogResult
+}
+
+// The outcome of the `EventLogRequested`.
+type EventLogResult struct {
+	// The oldest events first.
+	Events []LoggedEvent
+	Err    error
+}
+
+func (r EventLogRequested) Fail(err error) {
+	r.Result <- EventLogResult{Err: err}
+}
+
+func (c *Conference) onEventLogRequested(request EventLogRequested) {
+	request.Result <- EventLogResult{Events: c.events.list()}
+}
@@ -0,0 +1,59 @@
+package conference //nolint:testpackage
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/matrix-org/waterfall/pkg/conference/participant"
+)
+
+func TestEventLog(t *testing.T) {
+	conference := newTestConference(t, withProfile(Profile{EventLogSize: 3}))
+
+	// Returns the track IDs of the logged events.
+	loggedTracks := func() []string {
+		result := make(chan EventLogResult, 1)
+		conference.onEventLogRequested(EventLogRequested{Result: result})
+
+		outcome := <-result
+		if outcome.Err != nil {
+			t.Fatalf("Failed to get the events: %v", outcome.Err)
+		}
+
+		trackIDs := []string{}
+		for _, event := range outcome.Events {
+			if event.Kind != EventTrackFailed || event.UserID != "@alice:example.org" || event.Time.IsZero() {
+				t.Errorf("Unexpected event: %+v", event)
+			}
+			trackIDs = append(trackIDs, event.TrackID)
+		}
+
+		return trackIDs
+	}
+
+	alice := participant.ID{UserID: "@alice:example.org", DeviceID: "ALICE", CallID: "call"}
+	fail := func(from, to int) {
+		for i := from; i < to; i++ {
+			conference.processPublishedTrackFailedMessage(alice, fmt.Sprintf("track-%d", i))
+		}
+	}
+
+	fail(0, 2)
+	if tracks := loggedTracks(); fmt.Sprint(tracks) != "[track-0 track-1]" {
+		t.Errorf("Expected the events to be recorded, got %v", tracks)
+	}
+
+	// The oldest events are evicted once the log is full.
+	fail(2, 7)
+	if tracks := loggedTracks(); fmt.Sprint(tracks) != "[track-4 track-5 track-6]" {
+		t.Errorf("Expected only the latest events to be kept, oldest first, got %v", tracks)
+	}
+
+	// The log is not shared with the callers.
+	result := make(chan EventLogResult, 1)
+	conference.onEventLogRequested(EventLogRequested{Result: result})
+	(<-result).Events[0].TrackID = "changed"
+	if tracks := loggedTracks(); tracks[0] != "track-4" {
+		t.Errorf("Expected the log to stay intact, got %v", tracks)
+	}
+}
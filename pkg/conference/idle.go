@@ -0,0 +1,44 @@
+package conference
+
+import (
+	"time"
+
+	"github.com/matrix-org/waterfall/pkg/conference/participant"
+	"github.com/matrix-org/waterfall/pkg/signaling"
+	"maunium.net/go/mautrix/event"
+)
+
+// Hangup reason that is sent when the participant has been idle for too long.
+const hangupIdle event.CallHangupReason = "idle"
+
+// How often the conference checks for idle participants.
+const idleCheckInterval = 10 * time.Second
+
+// Removes the participants that have been idle for longer than the configured idle timeout (if enabled).
+func (c *Conference) evictIdleParticipants(now time.Time) {
+	timeout := time.Duration(c.profile.IdleTimeout) * time.Second
+	if timeout <= 0 {
+		return
+	}
+
+	for _, id := range idleParticipants(c.tracker, now, timeout) {
+		p := c.tracker.GetParticipant(id)
+		p.Logger.Info("Evicting idle participant")
+		p.Telemetry.AddEvent("evicted idle participant")
+
+		c.removeParticipant(id)
+		c.matrixWorker.sendSignalingMessage(p.AsMatrixRecipient(), signaling.Hangup{Reason: hangupIdle})
+	}
+}
+
+// Returns the participants that neither publish nor subscribe to anything and had no activity for `timeout`.
+func idleParticipants(tracker *participant.Tracker, now time.Time, timeout time.Duration) []participant.ID {
+	idle := []participant.ID{}
+	tracker.ForEachParticipant(func(id participant.ID, p *participant.Participant) {
+		if now.Sub(p.LastActivity) > timeout && !tracker.IsPublishingOrSubscribing(id) {
+			idle = append(idle, id)
+		}
+	})
+
+	return idle
+}
@@ -0,0 +1,40 @@
+package conference //nolint:testpackage
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/matrix-org/waterfall/pkg/conference/participant"
+	"github.com/matrix-org/waterfall/pkg/conference/track"
+)
+
+func TestIdleParticipants(t *testing.T) {
+	tracker, _ := participant.NewParticipantTracker(make(chan struct{}), track.Config{})
+	now := time.Now()
+
+	idle := participant.ID{UserID: "@idle:example.org", DeviceID: "IDLE"}
+	active := participant.ID{UserID: "@active:example.org", DeviceID: "ACTIVE"}
+
+	tracker.AddParticipant(&participant.Participant{ID: idle, LastActivity: now.Add(-10 * time.Minute)})
+	tracker.AddParticipant(&participant.Participant{ID: active, LastActivity: now.Add(-time.Second)})
+
+	expected := []participant.ID{idle}
+	if evicted := idleParticipants(tracker, now, 5*time.Minute); !reflect.DeepEqual(evicted, expected) {
+		t.Errorf("Expected %v to be evicted, got %v", expected, evicted)
+	}
+}
+
+func TestNoEvictionWithoutIdleTimeout(t *testing.T) {
+	conference := newTestConference(t)
+	tracker := conference.tracker
+
+	idle := participant.ID{UserID: "@idle:example.org", DeviceID: "IDLE"}
+	tracker.AddParticipant(&participant.Participant{ID: idle, LastActivity: time.Now().Add(-time.Hour)})
+
+	// The check runs regardless of the config since the timeout may be enabled by a config reload.
+	conference.evictIdleParticipants(time.Now())
+	if tracker.GetParticipant(idle) == nil {
+		t.Error("Expected no participants to be evicted while the idle timeout is disabled")
+	}
+}
@@ -0,0 +1,42 @@
+package conference
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+// How often the operator may request the key frames from all publishers of a conference. Every request
+// makes each publisher send a (large) key frame at once, so a burst of them would flood the subscribers.
+const minKeyFrameRefreshInterval = 5 * time.Second
+
+var ErrKeyFramesRateLimited = errors.New("key frames were requested too recently")
+
+// Sent by the router when an operator requested a key frame from all publishers of the conference.
+type KeyFramesRequested struct {
+	// Receives the amount of requested key frames, must be buffered.
+	Result chan<- KeyFramesResult
+}
+
+// The outcome of the `KeyFramesRequested`.
+type KeyFramesResult struct {
+	Requested int
+	Err       error
+}
+
+func (r KeyFramesRequested) Fail(err error) {
+	r.Result <- KeyFramesResult{Err: err}
+}
+
+func (c *Conference) onKeyFramesRequested(request KeyFramesRequested, now time.Time) {
+	if elapsed := now.Sub(c.lastKeyFrameRefresh); elapsed < minKeyFrameRefreshInterval {
+		request.Fail(fmt.Errorf("%w (%s ago)", ErrKeyFramesRateLimited, elapsed.Round(time.Millisecond)))
+		return
+	}
+
+	c.lastKeyFrameRefresh = now
+	requested := c.tracker.RequestKeyFrames()
+	c.logger.Infof("Requested %d key frames", requested)
+
+	request.Result <- KeyFramesResult{Requested: requested}
+}
@@ -0,0 +1,181 @@
+package conference //nolint:testpackage
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/matrix-org/waterfall/pkg/channel"
+	"github.com/matrix-org/waterfall/pkg/conference/participant"
+	"github.com/matrix-org/waterfall/pkg/conference/track"
+	"github.com/matrix-org/waterfall/pkg/peer"
+	"github.com/matrix-org/waterfall/pkg/telemetry"
+	"github.com/matrix-org/waterfall/pkg/webrtc_ext"
+	"github.com/pion/rtcp"
+	"github.com/pion/rtp"
+	"github.com/pion/webrtc/v3"
+	"github.com/sirupsen/logrus"
+)
+
+// Publishes video tracks with given IDs to the SFU peer of a participant. Returns the peer along with
+// the tracks as they are seen by the SFU and counts the PLIs that the publisher receives for each track.
+func publishVideoTracks(
+	t *testing.T,
+	id participant.ID,
+	trackIDs ...string,
+) (*peer.Peer[participant.ID], []*webrtc.TrackRemote, func(trackID string) int) {
+	t.Helper()
+
+	sender, err := webrtc.NewPeerConnection(webrtc.Configuration{})
+	if err != nil {
+		t.Fatalf("Failed to create sender: %v", err)
+	}
+	t.Cleanup(func() { sender.Close() })
+
+	var mutex sync.Mutex
+	plis := make(map[string]int)
+
+	localTracks := []*webrtc.TrackLocalStaticRTP{}
+	for _, trackID := range trackIDs {
+		localTrack, err := webrtc.NewTrackLocalStaticRTP(
+			webrtc.RTPCodecCapability{MimeType: webrtc.MimeTypeVP8},
+			trackID,
+			"stream",
+		)
+		if err != nil {
+			t.Fatalf("Failed to create track: %v", err)
+		}
+
+		rtpSender, err := sender.AddTrack(localTrack)
+		if err != nil {
+			t.Fatalf("Failed to add track: %v", err)
+		}
+		localTracks = append(localTracks, localTrack)
+
+		go func(trackID string) {
+			for {
+				packets, _, err := rtpSender.ReadRTCP()
+				if err != nil {
+					return
+				}
+
+				for _, packet := range packets {
+					if _, ok := packet.(*rtcp.PictureLossIndication); ok {
+						mutex.Lock()
+						plis[trackID]++
+						mutex.Unlock()
+					}
+				}
+			}
+		}(trackID)
+	}
+
+	offer, err := sender.CreateOffer(nil)
+	if err != nil {
+		t.Fatalf("Failed to create offer: %v", err)
+	}
+	gathered := webrtc.GatheringCompletePromise(sender)
+	if err := sender.SetLocalDescription(offer); err != nil {
+		t.Fatalf("Failed to set local description: %v", err)
+	}
+	<-gathered
+
+	factory, err := webrtc_ext.NewPeerConnectionFactory(webrtc_ext.Config{})
+	if err != nil {
+		t.Fatalf("Failed to create peer connection factory: %v", err)
+	}
+
+	messages := make(chan channel.Message[participant.ID, peer.MessageContent], 100)
+	sfuPeer, answer, err := peer.NewPeer(
+		factory,
+		sender.LocalDescription().SDP,
+		channel.NewSink(id, messages),
+		peer.Config{DisableTrickleICE: true},
+		logrus.NewEntry(logrus.New()),
+	)
+	if err != nil {
+		t.Fatalf("Failed to create peer: %v", err)
+	}
+	t.Cleanup(sfuPeer.Terminate)
+
+	if err := sender.SetRemoteDescription(*answer); err != nil {
+		t.Fatalf("Failed to set remote description: %v", err)
+	}
+
+	// The tracks are published once the first packets arrive.
+	ticker := time.NewTicker(20 * time.Millisecond)
+	defer ticker.Stop()
+	timeout := time.After(10 * time.Second)
+
+	remoteTracks := []*webrtc.TrackRemote{}
+	for sequenceNumber := uint16(0); len(remoteTracks) < len(trackIDs); sequenceNumber++ {
+		select {
+		case message := <-messages:
+			if published, ok := message.Content.(peer.NewTrackPublished); ok {
+				remoteTracks = append(remoteTracks, published.RemoteTrack)
+			}
+		case <-ticker.C:
+			for _, localTrack := range localTracks {
+				localTrack.WriteRTP(&rtp.Packet{ //nolint:errcheck
+					Header:  rtp.Header{Version: 2, SequenceNumber: sequenceNumber, Marker: true},
+					Payload: []byte{0x10, 0x00},
+				})
+			}
+		case <-timeout:
+			t.Fatal("Timed out waiting for the published tracks")
+		}
+	}
+
+	countPLIs := func(trackID string) int {
+		mutex.Lock()
+		defer mutex.Unlock()
+		return plis[trackID]
+	}
+
+	return sfuPeer, remoteTracks, countPLIs
+}
+
+func TestKeyFrameRefresh(t *testing.T) {
+	conference := newTestConference(t)
+	tracker := conference.tracker
+	logger := logrus.NewEntry(logrus.New())
+	tel := telemetry.NewTelemetry(context.Background(), "Conference")
+
+	alice := participant.ID{UserID: "@alice:example.org", DeviceID: "ALICE", CallID: "call"}
+	alicePeer, remoteTracks, countPLIs := publishVideoTracks(t, alice, "camera", "screen")
+	tracker.AddParticipant(&participant.Participant{ID: alice, Peer: alicePeer, Logger: logger, Telemetry: tel})
+
+	for _, remoteTrack := range remoteTracks {
+		if err := tracker.AddPublishedTrack(alice, remoteTrack, track.TrackMetadata{}); err != nil {
+			t.Fatalf("Failed to publish %s: %v", remoteTrack.ID(), err)
+		}
+	}
+
+	now := time.Now()
+	result := make(chan KeyFramesResult, 1)
+	conference.onKeyFramesRequested(KeyFramesRequested{Result: result}, now)
+	if outcome := <-result; outcome.Err != nil || outcome.Requested != 2 {
+		t.Fatalf("Expected 2 key frames to be requested, got %+v", outcome)
+	}
+
+	// Another request right away is rate-limited.
+	conference.onKeyFramesRequested(KeyFramesRequested{Result: result}, now.Add(time.Second))
+	if outcome := <-result; !errors.Is(outcome.Err, ErrKeyFramesRateLimited) {
+		t.Errorf("Expected the second request to be rate-limited, got %+v", outcome)
+	}
+
+	// Give the PLIs some time to arrive.
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) && (countPLIs("camera") < 1 || countPLIs("screen") < 1) {
+		time.Sleep(20 * time.Millisecond)
+	}
+	time.Sleep(200 * time.Millisecond)
+
+	for _, trackID := range []string{"camera", "screen"} {
+		if plis := countPLIs(trackID); plis != 1 {
+			t.Errorf("Expected a single PLI for %s, got %d", trackID, plis)
+		}
+	}
+}
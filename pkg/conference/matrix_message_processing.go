@@ -1,12 +1,13 @@
 package conference
 
 import (
+	"fmt"
 	"time"
 
-	"github.com/matrix-org/waterfall/pkg/channel"
 	"github.com/matrix-org/waterfall/pkg/conference/participant"
 	"github.com/matrix-org/waterfall/pkg/peer"
 	"github.com/matrix-org/waterfall/pkg/signaling"
+	"github.com/matrix-org/waterfall/pkg/webhook"
 	"github.com/pion/webrtc/v3"
 	"github.com/sirupsen/logrus"
 	"go.opentelemetry.io/otel/attribute"
@@ -20,28 +21,68 @@ type MatrixMessage struct {
 	Content MessageContent
 }
 
+// Hangup reason that is sent when the conference has reached the maximum amount of participants.
+const hangupConferenceFull event.CallHangupReason = "conference_full"
+
 // New participant tries to join the conference.
 func (c *Conference) onNewParticipant(id participant.ID, inviteEvent *event.CallInviteEventContent) error {
 	logger := c.newLogger(id)
 	logger.Info("Incoming participant")
 	c.telemetry.AddEvent(
 		"incoming participant",
-		attribute.String("user_id", id.UserID.String()),
-		attribute.String("device_id", id.DeviceID.String()),
-		attribute.String("sdp_offer", inviteEvent.Offer.SDP),
+		append(
+			c.sdpAttributes("sdp_offer", inviteEvent.Offer.SDP),
+			attribute.String("user_id", id.UserID.String()),
+			attribute.String("device_id", id.DeviceID.String()),
+		)...,
 	)
 
+	// Reject the users that are not allowed to join before we even look at their offer.
+	if !c.config.AccessControl.Allows(id.UserID) {
+		err := fmt.Errorf("%s is not allowed to join", id.UserID)
+		logger.WithError(err).Warn("Rejecting participant")
+		c.telemetry.AddError(err)
+
+		recipient := signaling.MatrixRecipient{
+			UserID:          id.UserID,
+			DeviceID:        id.DeviceID,
+			CallID:          id.CallID,
+			RemoteSessionID: inviteEvent.SenderSessionID,
+		}
+		c.matrixWorker.sendSignalingMessage(recipient, signaling.Hangup{Reason: HangupNotAllowed})
+		return err
+	}
+
 	// As per MSC3401, when the `session_id` field changes from an incoming `m.call.member` event,
-	// any existing calls from this device in this call should be terminated.
+	// any existing calls from this device in this call should be terminated. The same applies to
+	// a new call (i.e. a new `call_id`) from the same device, e.g. when the client reconnects.
 	if participant := c.tracker.GetParticipant(id); participant != nil {
-		if participant.RemoteSessionID == inviteEvent.SenderSessionID {
-			c.logger.Errorf("Found existing participant with equal DeviceID and SessionID")
+		if participant.RemoteSessionID == inviteEvent.SenderSessionID && participant.ID.SameCall(id) {
+			c.logger.Errorf("Found existing participant with equal DeviceID, SessionID and CallID")
 		} else {
 			c.removeParticipant(id)
 		}
 	}
 
 	p := c.tracker.GetParticipant(id)
+
+	// Reject new participants if the conference is full.
+	if maxParticipants := c.profile.MaxParticipants; p == nil && maxParticipants > 0 &&
+		c.tracker.ParticipantCount() >= maxParticipants {
+		err := fmt.Errorf("conference is full (%d participants)", maxParticipants)
+		logger.WithError(err).Warn("Rejecting participant")
+		c.telemetry.AddError(err)
+
+		recipient := signaling.MatrixRecipient{
+			UserID:          id.UserID,
+			DeviceID:        id.DeviceID,
+			CallID:          id.CallID,
+			RemoteSessionID: inviteEvent.SenderSessionID,
+		}
+		c.matrixWorker.sendSignalingMessage(recipient, signaling.Hangup{Reason: hangupConferenceFull})
+		return err
+	}
+
 	var sdpAnswer *webrtc.SessionDescription
 
 	// If participant exists still exists, then it means that the client does not behave properly.
@@ -54,9 +95,29 @@ func (c *Conference) onNewParticipant(id participant.ID, inviteEvent *event.Call
 		}
 		sdpAnswer = answer
 	} else {
-		messageSink := channel.NewSink(id, c.peerMessages)
+		// Everything that the new participant does is logged along with its trace ID.
+		traceID := participant.NewTraceID()
+		logger = logger.WithField("trace_id", traceID)
+
+		messageSink := c.peerMessages.NewSink(id)
+
+		peerConfig := peer.Config{
+			MaxIncomingBitrate:               uint64(c.profile.MaxPublisherBitrate) * 1000,
+			DisableTrickleICE:                enabled(c.profile.DisableTrickleICE),
+			MaxDataChannelMessageSize:        c.profile.MaxDataChannelMessageSize * 1024,
+			MaxDataChannelBufferedAmount:     uint64(c.profile.MaxDataChannelBufferedAmount) * 1024,
+			DeferRenegotiationUntilConnected: enabled(c.profile.DeferInitialRenegotiation),
+			ExplicitTransceiverDirections:    enabled(c.profile.ExplicitTransceiverDirections),
+			CodecPreferences:                 c.profile.CodecPreferences,
+		}
 
-		peerConnection, answer, err := peer.NewPeer(c.connectionFactory, inviteEvent.Offer.SDP, messageSink, logger)
+		peerConnection, answer, err := peer.NewPeer(
+			c.connectionFactory,
+			inviteEvent.Offer.SDP,
+			messageSink,
+			peerConfig,
+			logger,
+		)
 		if err != nil {
 			logger.WithError(err).Errorf("Failed to process SDP offer")
 			c.telemetry.AddError(err)
@@ -79,35 +140,55 @@ func (c *Conference) onNewParticipant(id participant.ID, inviteEvent *event.Call
 			"Participant",
 			attribute.String("user_id", id.UserID.String()),
 			attribute.String("device_id", id.DeviceID.String()),
+			attribute.String("trace_id", traceID),
 		)
 
 		p = &participant.Participant{
 			ID:              id,
+			TraceID:         traceID,
 			Peer:            peerConnection,
 			Logger:          logger,
 			RemoteSessionID: inviteEvent.SenderSessionID,
-			Pong:            heartbeat.Start(),
+			Heartbeat:       heartbeat.Start(),
 			Telemetry:       participantTelemetry,
+			LastActivity:    time.Now(),
+			// The newcomers get the complete metadata once their data channel is open anyway.
+			IncrementalMetadata: enabled(c.profile.IncrementalMetadata),
 		}
 
 		c.tracker.AddParticipant(p)
+		c.notify(webhook.ParticipantJoined, &id)
+
+		// The moderator's mute outlives the previous calls of the device.
+		if c.forceMuted[id.Key()] {
+			logger.Info("Participant remains force muted")
+			c.tracker.SetForceMuted(id, true)
+		}
+		c.events.record(EventParticipantJoined, id, "", "")
 		sdpAnswer = answer
 	}
 
 	// Update streams metadata.
+	c.updateEncryptedTracks(inviteEvent.Offer.SDP)
 	c.updateMetadata(inviteEvent.SDPStreamMetadata)
 
 	// Send the answer back to the remote peer.
-	p.Logger.WithField("sdpAnswer", sdpAnswer.SDP).Debug("Sending SDP answer")
+	p.LastSDPAnswer = sdpAnswer.SDP
+	c.sendSDPAnswer(p)
+
+	return nil
+}
+
+// Sends the last SDP answer of a participant along with the streams that are currently available to it.
+func (c *Conference) sendSDPAnswer(p *participant.Participant) {
+	c.logSDP(p.Logger, logrus.DebugLevel, p.LastSDPAnswer, "Sending SDP answer")
 	c.matrixWorker.sendSignalingMessage(
 		p.AsMatrixRecipient(),
 		signaling.SdpAnswer{
-			StreamMetadata: c.getAvailableStreamsFor(id),
-			SDP:            sdpAnswer.SDP,
+			StreamMetadata: c.getAvailableStreamsFor(p.ID).Metadata,
+			SDP:            p.LastSDPAnswer,
 		},
 	)
-
-	return nil
 }
 
 // Process new ICE candidates received from Matrix signaling (from the remote peer) and forward them to
@@ -0,0 +1,48 @@
+package conference
+
+import (
+	"encoding/json"
+
+	"github.com/matrix-org/waterfall/pkg/conference/participant"
+	"github.com/matrix-org/waterfall/pkg/webrtc_ext"
+	"github.com/pion/webrtc/v3"
+	"maunium.net/go/mautrix/event"
+)
+
+// Sent by the participant over the data channel to tell which media it wants to receive.
+var FocusCallMediaPreference = event.Type{Type: "m.call.media_preference", Class: event.FocusEventType}
+
+// Content of the `m.call.media_preference` event.
+type MediaPreferenceEventContent struct {
+	// Only receive audio: the video tracks are not announced and the subscriptions to them are refused.
+	AudioOnly bool `json:"audio_only"`
+}
+
+func (c *Conference) processMediaPreferenceMessage(p *participant.Participant, raw json.RawMessage) {
+	var content MediaPreferenceEventContent
+	if err := json.Unmarshal(raw, &content); err != nil {
+		p.Logger.Errorf("Failed to unmarshal media preference: %v", err)
+		return
+	}
+
+	if p.AudioOnly == content.AudioOnly {
+		return
+	}
+
+	p.AudioOnly = content.AudioOnly
+	p.Logger.Infof("Audio only: %v", p.AudioOnly)
+
+	// Drop the video that the participant has subscribed to before switching to audio only.
+	if p.AudioOnly {
+		c.tracker.ForEachPublishedTrackInfo(func(_ participant.ID, info webrtc_ext.TrackInfo) {
+			if info.Kind == webrtc.RTPCodecTypeVideo {
+				c.tracker.Unsubscribe(p.ID, info.TrackID)
+			}
+		})
+	}
+
+	// The set of the tracks available to the participant has changed.
+	if err := p.SendMetadata(c.getAvailableStreamsFor(p.ID)); err != nil {
+		p.Logger.Errorf("Failed to send SDP stream metadata: %v", err)
+	}
+}
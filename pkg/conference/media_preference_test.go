@@ -0,0 +1,86 @@
+package conference //nolint:testpackage
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/matrix-org/waterfall/pkg/conference/participant"
+	"github.com/matrix-org/waterfall/pkg/conference/track"
+	"github.com/matrix-org/waterfall/pkg/telemetry"
+	"github.com/sirupsen/logrus"
+	"maunium.net/go/mautrix/event"
+)
+
+func TestAudioOnlyParticipant(t *testing.T) {
+	logger := logrus.NewEntry(logrus.New())
+	tel := telemetry.NewTelemetry(context.Background(), "Conference")
+
+	alice := participant.ID{UserID: "@alice:example.org", DeviceID: "ALICE", CallID: "call"}
+	bob := participant.ID{UserID: "@bob:example.org", DeviceID: "BOB", CallID: "call"}
+	carol := participant.ID{UserID: "@carol:example.org", DeviceID: "CAROL", CallID: "call"}
+
+	conference := newTestConference(t, withStreamsMetadata(event.CallSDPStreamMetadata{
+		"stream": {
+			UserID:   alice.UserID,
+			DeviceID: alice.DeviceID,
+			Tracks: event.CallSDPStreamMetadataTracks{
+				"mic":    {Kind: "audio"},
+				"camera": {Kind: "video"},
+			},
+		},
+	}))
+	tracker := conference.tracker
+
+	alicePeer, videoTracks, _ := publishVideoTracks(t, alice, "camera")
+	tracker.AddParticipant(&participant.Participant{ID: alice, Peer: alicePeer, Logger: logger, Telemetry: tel})
+	tracker.AddParticipant(&participant.Participant{
+		ID:        bob,
+		Peer:      newSubscriberPeer(t, bob),
+		AudioOnly: true,
+		Logger:    logger,
+		Telemetry: tel,
+	})
+	tracker.AddParticipant(&participant.Participant{ID: carol, Peer: newSubscriberPeer(t, carol), Logger: logger, Telemetry: tel})
+
+	remoteTracks := append(publishAudioTracks(t, "stream", "mic"), videoTracks...)
+	for _, remoteTrack := range remoteTracks {
+		if err := tracker.AddPublishedTrack(alice, remoteTrack, track.TrackMetadata{}); err != nil {
+			t.Fatalf("Failed to publish %s: %v", remoteTrack.ID(), err)
+		}
+	}
+
+	// The video is hidden from the audio-only participant, but not from the others.
+	if available := conference.getAvailableStreamsFor(bob).Metadata["stream"].Tracks; len(available) != 1 {
+		t.Errorf("Expected only the microphone to be available to bob, got %+v", available)
+	} else if _, found := available["mic"]; !found {
+		t.Errorf("Expected the microphone to be available to bob, got %+v", available)
+	}
+
+	if available := conference.getAvailableStreamsFor(carol).Metadata["stream"].Tracks; len(available) != 2 {
+		t.Errorf("Expected both tracks to be available to carol, got %+v", available)
+	}
+
+	// The audio-only participant can't subscribe to the video.
+	if err := tracker.Subscribe(bob, "camera", 0, 0); !errors.Is(err, participant.ErrAudioOnly) {
+		t.Errorf("Expected the video subscription to be refused, got %v", err)
+	}
+
+	if err := tracker.Subscribe(bob, "mic", 0, 0); err != nil {
+		t.Errorf("Failed to subscribe to the microphone: %v", err)
+	}
+
+	// Switching to audio only drops the video subscriptions.
+	if err := tracker.Subscribe(carol, "camera", 0, 0); err != nil {
+		t.Fatalf("Failed to subscribe to the camera: %v", err)
+	}
+
+	conference.processMediaPreferenceMessage(tracker.GetParticipant(carol), []byte(`{"audio_only": true}`))
+	if tracker.IsPublishingOrSubscribing(carol) {
+		t.Error("Expected carol to be unsubscribed from the camera")
+	}
+
+	if available := conference.getAvailableStreamsFor(carol).Metadata["stream"].Tracks; len(available) != 1 {
+		t.Errorf("Expected only the microphone to be available to carol, got %+v", available)
+	}
+}
@@ -0,0 +1,55 @@
+package conference
+
+import (
+	"fmt"
+
+	"github.com/matrix-org/waterfall/pkg/conference/participant"
+	"maunium.net/go/mautrix/id"
+)
+
+// Sent by the router when a moderator muted (or unmuted) the audio of a participant for everyone. Unlike
+// the participant's own mute, it's enforced by the SFU: the audio is not forwarded to anyone while muted.
+type MuteRequested struct {
+	UserID   id.UserID
+	DeviceID id.DeviceID
+	Muted    bool
+	// Receives the outcome of the request, must be buffered.
+	Result chan<- MuteResult
+}
+
+// The outcome of the `MuteRequested`.
+type MuteResult struct {
+	// The amount of the audio tracks that the participant currently publishes.
+	Tracks int
+	Err    error
+}
+
+func (r MuteRequested) Fail(err error) {
+	r.Result <- MuteResult{Err: err}
+}
+
+func (c *Conference) onMuteRequested(request MuteRequested) {
+	p := c.tracker.GetParticipant(participant.ID{UserID: request.UserID, DeviceID: request.DeviceID})
+	if p == nil {
+		request.Fail(fmt.Errorf("participant %s (%s) not found", request.UserID, request.DeviceID))
+		return
+	}
+
+	if request.Muted {
+		if c.forceMuted == nil {
+			c.forceMuted = make(map[participant.Key]bool)
+		}
+		c.forceMuted[p.ID.Key()] = true
+	} else {
+		delete(c.forceMuted, p.ID.Key())
+	}
+
+	tracks := c.tracker.SetForceMuted(p.ID, request.Muted)
+	p.Logger.Warnf("Force muted: %v (%d audio tracks)", request.Muted, tracks)
+
+	// Let others know that the participant is muted. The participant itself is not told about its own
+	// streams in the metadata, so it's up to the moderator to inform it.
+	c.resendMetadataToAllExcept(p.ID)
+
+	request.Result <- MuteResult{Tracks: tracks}
+}
@@ -0,0 +1,66 @@
+package conference
+
+import (
+	"time"
+
+	"github.com/matrix-org/waterfall/pkg/conference/participant"
+	"maunium.net/go/mautrix/event"
+)
+
+// How often the conference checks for the deferred offers that may be applied.
+const negotiationCheckInterval = 100 * time.Millisecond
+
+// Checks if an offer of a participant comes too soon after the previous one. If so, it's kept until the
+// renegotiation is allowed again, replacing the offer that has been waiting so far (if any), so that a
+// client that spams the offers does not make us renegotiate continuously. Returns `true` if deferred.
+func (c *Conference) deferOffer(
+	p *participant.Participant,
+	msg event.FocusCallNegotiateEventContent,
+	now time.Time,
+) bool {
+	interval := time.Duration(c.profile.MinNegotiationInterval) * time.Millisecond
+	if interval <= 0 {
+		return false
+	}
+
+	if p.PendingOffer == nil && now.Sub(p.LastNegotiation) >= interval {
+		p.LastNegotiation = now
+		return false
+	}
+
+	if p.PendingOffer != nil {
+		p.Logger.Info("Superseding the deferred offer with a newer one")
+	} else {
+		p.Logger.Info("Deferring the offer that came too soon after the previous one")
+	}
+
+	p.Telemetry.AddEvent("offer deferred")
+	p.PendingOffer = &msg
+	return true
+}
+
+// Returns the deferred offer of a participant once the renegotiation is allowed again.
+func (c *Conference) takeDeferredOffer(
+	p *participant.Participant,
+	now time.Time,
+) (event.FocusCallNegotiateEventContent, bool) {
+	interval := time.Duration(c.profile.MinNegotiationInterval) * time.Millisecond
+	if p.PendingOffer == nil || now.Sub(p.LastNegotiation) < interval {
+		return event.FocusCallNegotiateEventContent{}, false
+	}
+
+	offer := *p.PendingOffer
+	p.PendingOffer = nil
+	p.LastNegotiation = now
+	return offer, true
+}
+
+// Applies the deferred offers of the participants that may renegotiate again.
+func (c *Conference) applyDeferredOffers(now time.Time) {
+	c.tracker.ForEachParticipant(func(_ participant.ID, p *participant.Participant) {
+		if offer, ok := c.takeDeferredOffer(p, now); ok {
+			p.Logger.Info("Applying the deferred offer")
+			c.applyNegotiateMessage(p, offer)
+		}
+	})
+}
@@ -0,0 +1,77 @@
+package conference //nolint:testpackage
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/matrix-org/waterfall/pkg/conference/participant"
+	"github.com/matrix-org/waterfall/pkg/telemetry"
+	"github.com/sirupsen/logrus"
+	"maunium.net/go/mautrix/event"
+)
+
+func TestRapidOffersAreCoalesced(t *testing.T) {
+	conference := newTestConference(t, withProfile(Profile{MinNegotiationInterval: 100}))
+	p := &participant.Participant{
+		Logger:    logrus.NewEntry(logrus.New()),
+		Telemetry: telemetry.NewTelemetry(context.Background(), "Participant"),
+	}
+
+	newOffer := func(sdp string) event.FocusCallNegotiateEventContent {
+		return event.FocusCallNegotiateEventContent{
+			Description: event.CallData{Type: event.CallDataTypeOffer, SDP: sdp},
+		}
+	}
+
+	start := time.Now()
+
+	// The first offer is applied right away.
+	if conference.deferOffer(p, newOffer("first"), start) {
+		t.Fatal("Expected the first offer to be applied right away")
+	}
+
+	// The offers that come within the interval are deferred.
+	for i, sdp := range []string{"second", "third", "fourth"} {
+		if !conference.deferOffer(p, newOffer(sdp), start.Add(time.Duration(i+1)*10*time.Millisecond)) {
+			t.Fatalf("Expected the %s offer to be deferred", sdp)
+		}
+	}
+
+	if _, ok := conference.takeDeferredOffer(p, start.Add(50*time.Millisecond)); ok {
+		t.Fatal("Expected no offer to be applied within the interval")
+	}
+
+	// Only the latest of them gets applied once the interval is over.
+	applied := []string{}
+	for _, elapsed := range []time.Duration{100 * time.Millisecond, 150 * time.Millisecond, time.Second} {
+		if offer, ok := conference.takeDeferredOffer(p, start.Add(elapsed)); ok {
+			applied = append(applied, offer.Description.SDP)
+		}
+	}
+
+	if len(applied) != 1 || applied[0] != "fourth" {
+		t.Errorf("Expected a single negotiation with the latest offer, got %v", applied)
+	}
+
+	// An offer deferred before the limit is disabled by a config reload is still applied.
+	later := start.Add(time.Second)
+	if conference.deferOffer(p, newOffer("fifth"), later) {
+		t.Fatal("Expected the fifth offer to be applied right away")
+	}
+	if !conference.deferOffer(p, newOffer("sixth"), later.Add(10*time.Millisecond)) {
+		t.Fatal("Expected the sixth offer to be deferred")
+	}
+
+	conference.profile.MinNegotiationInterval = 0
+	if offer, ok := conference.takeDeferredOffer(p, later.Add(20*time.Millisecond)); !ok {
+		t.Error("Expected the deferred offer to be applied once the limit is disabled")
+	} else if offer.Description.SDP != "sixth" {
+		t.Errorf("Expected the sixth offer to be applied, got %s", offer.Description.SDP)
+	}
+
+	// Without the limit, all offers are applied right away.
+	if conference.deferOffer(p, newOffer("seventh"), later.Add(30*time.Millisecond)) {
+		t.Error("Expected the offer to be applied without the limit")
+	}
+}
@@ -0,0 +1,72 @@
+package participant
+
+import (
+	"encoding/base64"
+	"fmt"
+	"strconv"
+	"sync/atomic"
+
+	"maunium.net/go/mautrix/event"
+)
+
+// Sent over the data channel instead of an event that exceeds the maximum message size. The receiver
+// concatenates the decoded data of all chunks with the same message ID in the order of their indices
+// and gets the JSON of the original event.
+var FocusCallChunk = event.Type{Type: "m.call.chunk", Class: event.FocusEventType}
+
+// Content of the `m.call.chunk` event.
+type ChunkEventContent struct {
+	// Identifies the chunks of the same event.
+	MessageID string `json:"message_id"`
+	Index     int    `json:"index"`
+	Count     int    `json:"count"`
+	// Base64-encoded part of the original event.
+	Data string `json:"data"`
+}
+
+// The space reserved for everything but the data in a serialized chunk event.
+const chunkOverhead = 256
+
+// The ID of the last chunked event, unique within the SFU.
+var lastChunkedMessageID atomic.Uint64
+
+// Splits a serialized event into serialized chunk events that don't exceed a given size.
+func chunkMessage(json string, maxSize int) ([]string, error) {
+	// The data is base64-encoded, i.e. every 3 bytes take 4 bytes.
+	chunkSize := (maxSize - chunkOverhead) / 4 * 3
+	if chunkSize <= 0 {
+		return nil, fmt.Errorf("maximum message size %d is too small to send %d bytes in chunks", maxSize, len(json))
+	}
+
+	messageID := strconv.FormatUint(lastChunkedMessageID.Add(1), 10)
+	count := (len(json) + chunkSize - 1) / chunkSize
+
+	chunks := make([]string, 0, count)
+	for index := 0; index < count; index++ {
+		end := (index + 1) * chunkSize
+		if end > len(json) {
+			end = len(json)
+		}
+
+		chunk := event.Event{
+			Type: FocusCallChunk,
+			Content: event.Content{
+				Parsed: ChunkEventContent{
+					MessageID: messageID,
+					Index:     index,
+					Count:     count,
+					Data:      base64.StdEncoding.EncodeToString([]byte(json[index*chunkSize : end])),
+				},
+			},
+		}
+
+		serialized, err := chunk.MarshalJSON()
+		if err != nil {
+			return nil, err
+		}
+
+		chunks = append(chunks, string(serialized))
+	}
+
+	return chunks, nil
+}
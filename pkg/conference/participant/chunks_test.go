@@ -0,0 +1,127 @@
+package participant //nolint:testpackage
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"testing"
+
+	"maunium.net/go/mautrix/event"
+)
+
+// Reassembles the chunks the way a client would.
+func reassembleChunks(t *testing.T, chunks []string) string {
+	t.Helper()
+
+	parts := map[int]string{}
+	var messageID string
+	var count int
+
+	for _, chunk := range chunks {
+		var received struct {
+			Type    string            `json:"type"`
+			Content ChunkEventContent `json:"content"`
+		}
+
+		if err := json.Unmarshal([]byte(chunk), &received); err != nil {
+			t.Fatalf("Failed to unmarshal chunk: %v", err)
+		}
+
+		if received.Type != FocusCallChunk.Type {
+			t.Fatalf("Unexpected event type: %s", received.Type)
+		}
+
+		if messageID == "" {
+			messageID, count = received.Content.MessageID, received.Content.Count
+		} else if received.Content.MessageID != messageID || received.Content.Count != count {
+			t.Fatalf("Chunk of another message: %+v", received.Content)
+		}
+
+		data, err := base64.StdEncoding.DecodeString(received.Content.Data)
+		if err != nil {
+			t.Fatalf("Failed to decode chunk data: %v", err)
+		}
+
+		parts[received.Content.Index] = string(data)
+	}
+
+	if len(parts) != count {
+		t.Fatalf("Expected %d chunks, got %d", count, len(parts))
+	}
+
+	var message strings.Builder
+	for index := 0; index < count; index++ {
+		message.WriteString(parts[index])
+	}
+
+	return message.String()
+}
+
+func TestChunkOversizedMetadata(t *testing.T) {
+	// Metadata of a big conference.
+	metadata := event.CallSDPStreamMetadata{}
+	for i := 0; i < 1000; i++ {
+		metadata[fmt.Sprintf("stream-%d", i)] = event.CallSDPStreamMetadataObject{
+			UserID:   "@user:example.org",
+			DeviceID: "DEVICE",
+			Purpose:  event.Usermedia,
+			Tracks: event.CallSDPStreamMetadataTracks{
+				fmt.Sprintf("audio-%d", i): {Kind: "audio"},
+				fmt.Sprintf("video-%d", i): {Kind: "video", Width: 1280, Height: 720},
+			},
+		}
+	}
+
+	metadataEvent := event.Event{
+		Type: event.FocusCallSDPStreamMetadataChanged,
+		Content: event.Content{
+			Parsed: event.FocusCallSDPStreamMetadataChangedEventContent{SDPStreamMetadata: metadata},
+		},
+	}
+
+	serialized, err := metadataEvent.MarshalJSON()
+	if err != nil {
+		t.Fatalf("Failed to marshal metadata: %v", err)
+	}
+
+	const maxSize = 16 * 1024
+	if len(serialized) <= maxSize {
+		t.Fatalf("Expected the metadata to exceed %d bytes, got %d", maxSize, len(serialized))
+	}
+
+	chunks, err := chunkMessage(string(serialized), maxSize)
+	if err != nil {
+		t.Fatalf("Failed to chunk the message: %v", err)
+	}
+
+	if len(chunks) < 2 {
+		t.Fatalf("Expected multiple chunks, got %d", len(chunks))
+	}
+
+	for i, chunk := range chunks {
+		if len(chunk) > maxSize {
+			t.Errorf("Chunk %d exceeds the maximum size: %d bytes", i, len(chunk))
+		}
+	}
+
+	if reassembled := reassembleChunks(t, chunks); reassembled != string(serialized) {
+		t.Error("The reassembled message differs from the original one")
+	}
+
+	// The chunks of different messages can be told apart.
+	otherChunks, err := chunkMessage(string(serialized), maxSize)
+	if err != nil {
+		t.Fatalf("Failed to chunk the message: %v", err)
+	}
+
+	if otherChunks[0] == chunks[0] {
+		t.Error("Expected the chunks of another message to have a different message ID")
+	}
+}
+
+func TestChunkTooSmallMessageSize(t *testing.T) {
+	if _, err := chunkMessage(strings.Repeat("x", 1000), 100); err == nil {
+		t.Error("Expected chunking to fail if not even the envelope fits")
+	}
+}
@@ -1,6 +1,7 @@
 package participant
 
 import (
+	"sync"
 	"time"
 )
 
@@ -19,50 +20,110 @@ type HeartbeatConfig struct {
 	OnTimeout func()
 }
 
+// A running heartbeat of a participant.
+//
+// The pong channel is never closed: the heartbeat is stopped by a separate signal instead, so that
+// reporting a pong for a participant that is being removed could never panic.
+type Heartbeat struct {
+	pong chan Pong
+	// Closed by `Stop()` to tell the goroutine to stop.
+	stop     chan struct{}
+	stopOnce sync.Once
+	// Closed once the goroutine has stopped.
+	done chan struct{}
+}
+
 // Starts a goroutine that will send ping messages (using `SendPing`) every `interval` and wait for a response
-// on `PongChannel` for `Timeout`. If no response is received within `Timeout`, `OnTimeout` is called.
-// The goroutine stops once the channel is closed or upon handling the `OnTimeout`. The returned channel
-// is what the caller should use to inform about the reception of a pong.
-func (h *HeartbeatConfig) Start() chan<- Pong {
-	pong := make(chan Pong, 1)
+// (see `Heartbeat.Pong`) for `Timeout`. If no response is received within `Timeout`, `OnTimeout` is called.
+// The goroutine stops once the heartbeat is stopped or upon handling the `OnTimeout`.
+func (h *HeartbeatConfig) Start() *Heartbeat {
+	heartbeat := &Heartbeat{
+		pong: make(chan Pong, 1),
+		stop: make(chan struct{}),
+		done: make(chan struct{}),
+	}
 
 	go func() {
+		defer close(heartbeat.done)
+
 		ticker := time.NewTicker(h.Interval)
 		defer ticker.Stop()
 
-		for range ticker.C {
-			if !h.sendWithRetry() {
+		for {
+			select {
+			case <-heartbeat.stop:
 				return
+			case <-ticker.C:
 			}
 
+			if !h.sendWithRetry(heartbeat.stop) {
+				return
+			}
+
+			timeout := time.NewTimer(h.Timeout)
 			select {
-			case <-time.After(h.Timeout):
+			case <-heartbeat.stop:
+				timeout.Stop()
+				return
+			case <-timeout.C:
 				h.OnTimeout()
 				return
-			case _, ok := <-pong:
-				if !ok {
-					return
-				}
+			case <-heartbeat.pong:
+				timeout.Stop()
 			}
 		}
 	}()
 
-	return pong
+	return heartbeat
 }
 
 // Tries to send a ping message using `SendPing` and retry it if it fails.
-// Returns `true` if the ping was sent successfully.
-func (h *HeartbeatConfig) sendWithRetry() bool {
+// Returns `true` if the ping was sent successfully and `false` if it failed or the heartbeat got stopped.
+func (h *HeartbeatConfig) sendWithRetry(stop <-chan struct{}) bool {
 	const retries = 3
 	retryInterval := h.Timeout / retries
 
 	for i := 0; i < retries; i++ {
-		if !h.SendPing() {
-			time.Sleep(retryInterval)
-			continue
+		if h.SendPing() {
+			return true
+		}
+
+		select {
+		case <-stop:
+			return false
+		case <-time.After(retryInterval):
 		}
-		return true
 	}
 
 	return false
 }
+
+// Informs the heartbeat that a pong has been received. Never blocks and may be called
+// at any time, even after the heartbeat has been stopped.
+func (h *Heartbeat) Pong() {
+	if h == nil {
+		return
+	}
+
+	select {
+	case <-h.stop:
+	case h.pong <- Pong{}:
+	default:
+	}
+}
+
+// Stops the heartbeat, so that no more pings are sent and the timeout is not reported anymore (unless
+// it's being reported right now). Does not wait for the goroutine to stop, see `Done()` for that.
+// Safe to call multiple times.
+func (h *Heartbeat) Stop() {
+	if h == nil {
+		return
+	}
+
+	h.stopOnce.Do(func() { close(h.stop) })
+}
+
+// Returns a channel that is closed once the goroutine of the heartbeat has stopped.
+func (h *Heartbeat) Done() <-chan struct{} {
+	return h.done
+}
@@ -0,0 +1,81 @@
+package participant_test
+
+import (
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/matrix-org/waterfall/pkg/conference/participant"
+)
+
+func TestHeartbeatTimeout(t *testing.T) {
+	timedOut := make(chan struct{})
+	heartbeat := (&participant.HeartbeatConfig{
+		Interval:  time.Millisecond,
+		Timeout:   10 * time.Millisecond,
+		SendPing:  func() bool { return true },
+		OnTimeout: func() { close(timedOut) },
+	}).Start()
+	defer heartbeat.Stop()
+
+	select {
+	case <-timedOut:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Expected the heartbeat to time out without pongs")
+	}
+}
+
+// Participants join and leave all the time while their pongs keep arriving, which must never panic
+// and must stop the pings of the participants that left.
+func TestHeartbeatStopUnderLoad(t *testing.T) {
+	const rounds, participants = 20, 50
+
+	for round := 0; round < rounds; round++ {
+		var pongs sync.WaitGroup
+		var pings atomic.Int64
+		heartbeats := make([]*participant.Heartbeat, 0, participants)
+
+		for i := 0; i < participants; i++ {
+			heartbeat := (&participant.HeartbeatConfig{
+				Interval:  time.Millisecond,
+				Timeout:   5 * time.Millisecond,
+				SendPing:  func() bool { pings.Add(1); return true },
+				OnTimeout: func() {},
+			}).Start()
+			heartbeats = append(heartbeats, heartbeat)
+
+			pongs.Add(1)
+			go func() {
+				defer pongs.Done()
+				for j := 0; j < 100; j++ {
+					heartbeat.Pong()
+				}
+			}()
+		}
+
+		// Remove the participants while the pongs are still arriving.
+		for _, heartbeat := range heartbeats {
+			heartbeat.Stop()
+			heartbeat.Stop()
+			heartbeat.Pong()
+		}
+
+		pongs.Wait()
+
+		for _, heartbeat := range heartbeats {
+			select {
+			case <-heartbeat.Done():
+			case <-time.After(5 * time.Second):
+				t.Fatal("Expected the heartbeat to stop")
+			}
+		}
+
+		// No pings are sent once all heartbeats have stopped.
+		sent := pings.Load()
+		time.Sleep(5 * time.Millisecond)
+		if after := pings.Load(); after != sent {
+			t.Fatalf("Expected no pings after the heartbeats stopped, got %d more", after-sent)
+		}
+	}
+}
@@ -0,0 +1,158 @@
+package participant
+
+import (
+	"reflect"
+	"sort"
+
+	"maunium.net/go/mautrix/event"
+)
+
+// The key of the sequence number in the content of the metadata events. The number grows with every
+// metadata event sent to a participant, so that the client could drop the events that arrive out of order
+// (e.g. if the data channel has been negotiated as unordered) instead of applying the stale metadata.
+const MetadataSequenceKey = "seq"
+
+// Sent instead of the complete metadata to the participants with `IncrementalMetadata`. The receiver applies
+// it on top of the metadata it has got so far, starting with the complete one sent when the data channel opens.
+var FocusCallSDPStreamMetadataDelta = event.Type{Type: "m.call.sdp_stream_metadata_delta", Class: event.FocusEventType}
+
+// Content of the `m.call.sdp_stream_metadata_delta` event.
+type MetadataDeltaEventContent struct {
+	// The streams that are new or have changed (e.g. got a new track), with all of their tracks.
+	Updated event.CallSDPStreamMetadata `json:"sdp_stream_metadata"`
+	// The IDs of the streams that are not available anymore.
+	Removed  []string `json:"removed_streams,omitempty"`
+	Sequence uint64   `json:"seq"`
+}
+
+// The streams available to a participant: their metadata along with the resolutions of the simulcast layers
+// of their video tracks (if reported).
+type AvailableStreams struct {
+	Metadata event.CallSDPStreamMetadata
+	Layers   LayerResolutions
+}
+
+// The resolution of a simulcast layer of a video track as the clients get it.
+type LayerResolution struct {
+	Layer  string `json:"layer"`
+	Width  int    `json:"width"`
+	Height int    `json:"height"`
+}
+
+// The resolutions of the simulcast layers of the video tracks by the stream and the track ID. The clients get
+// them as the `layers` of the tracks in the metadata, so that they could tell which layer to ask for.
+type LayerResolutions map[string]map[string][]LayerResolution
+
+// Sends the metadata of the tracks available to the participant over the default data channel. Only the changes
+// since the last metadata that has been sent are sent to the participants with `IncrementalMetadata` (if any).
+func (p *Participant) SendMetadata(metadata AvailableStreams) error {
+	if !p.IncrementalMetadata || p.sentMetadata.Metadata == nil {
+		return p.SendMetadataSnapshot(metadata)
+	}
+
+	delta, changed := p.nextMetadataDeltaEvent(metadata)
+	if !changed {
+		return nil
+	}
+
+	if err := p.SendOverDataChannel(delta); err != nil {
+		return err
+	}
+
+	p.sentMetadata = metadata
+	return nil
+}
+
+// Sends the complete metadata of the tracks available to the participant over the default data channel,
+// e.g. when it opens, so that the following changes could be sent incrementally.
+func (p *Participant) SendMetadataSnapshot(metadata AvailableStreams) error {
+	if err := p.SendOverDataChannel(p.nextMetadataEvent(metadata)); err != nil {
+		return err
+	}
+
+	p.sentMetadata = metadata
+	return nil
+}
+
+// Creates the metadata event with the next sequence number. The number is incremented even if the event
+// is not delivered, the clients only need it to grow.
+func (p *Participant) nextMetadataEvent(metadata AvailableStreams) event.Event {
+	p.metadataSequence++
+
+	raw := metadata.Layers.content(metadata.Metadata)
+	raw[MetadataSequenceKey] = p.metadataSequence
+
+	return event.Event{
+		Type: event.FocusCallSDPStreamMetadataChanged,
+		Content: event.Content{
+			Raw: raw,
+			Parsed: event.FocusCallSDPStreamMetadataChangedEventContent{
+				SDPStreamMetadata: metadata.Metadata,
+			},
+		},
+	}
+}
+
+// Creates the event with the changes since the last metadata that has been sent, unless nothing has changed.
+func (p *Participant) nextMetadataDeltaEvent(metadata AvailableStreams) (event.Event, bool) {
+	updated, removed := diffMetadata(p.sentMetadata, metadata)
+	if len(updated) == 0 && len(removed) == 0 {
+		return event.Event{}, false
+	}
+
+	p.metadataSequence++
+
+	return event.Event{
+		Type: FocusCallSDPStreamMetadataDelta,
+		Content: event.Content{
+			Raw:    metadata.Layers.content(updated),
+			Parsed: MetadataDeltaEventContent{Updated: updated, Removed: removed, Sequence: p.metadataSequence},
+		},
+	}, true
+}
+
+// Returns the streams that are new or differ from the previous metadata (including the resolutions of the
+// layers of their tracks) and the IDs of the removed ones.
+func diffMetadata(previous, current AvailableStreams) (event.CallSDPStreamMetadata, []string) {
+	updated := event.CallSDPStreamMetadata{}
+	for streamID, stream := range current.Metadata {
+		old, ok := previous.Metadata[streamID]
+		layersChanged := !reflect.DeepEqual(previous.Layers[streamID], current.Layers[streamID])
+		if !ok || layersChanged || !reflect.DeepEqual(old, stream) {
+			updated[streamID] = stream
+		}
+	}
+
+	removed := []string{}
+	for streamID := range previous.Metadata {
+		if _, ok := current.Metadata[streamID]; !ok {
+			removed = append(removed, streamID)
+		}
+	}
+	sort.Strings(removed)
+
+	return updated, removed
+}
+
+// Returns the resolutions of the layers of the tracks of given streams in the shape of the event content. The
+// metadata of the tracks can't be extended, but the content gets merged with them when the event is marshalled.
+func (l LayerResolutions) content(streams event.CallSDPStreamMetadata) map[string]interface{} {
+	metadata := make(map[string]interface{})
+	for streamID := range streams {
+		tracks := make(map[string]interface{})
+		for trackID, layers := range l[streamID] {
+			tracks[trackID] = map[string]interface{}{"layers": layers}
+		}
+
+		if len(tracks) != 0 {
+			metadata[streamID] = map[string]interface{}{"tracks": tracks}
+		}
+	}
+
+	content := make(map[string]interface{})
+	if len(metadata) != 0 {
+		content["sdp_stream_metadata"] = metadata
+	}
+
+	return content
+}
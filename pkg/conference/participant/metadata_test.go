@@ -0,0 +1,230 @@
+package participant //nolint:testpackage
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"testing"
+
+	"maunium.net/go/mautrix/event"
+)
+
+func TestMetadataSequence(t *testing.T) {
+	// Returns the sequence number and the metadata of the event the way a client would see them.
+	receive := func(ev event.Event) (uint64, event.CallSDPStreamMetadata) {
+		serialized, err := ev.MarshalJSON()
+		if err != nil {
+			t.Fatalf("Failed to marshal metadata: %v", err)
+		}
+
+		var received struct {
+			Type    string `json:"type"`
+			Content struct {
+				Sequence uint64                      `json:"seq"`
+				Metadata event.CallSDPStreamMetadata `json:"sdp_stream_metadata"`
+			} `json:"content"`
+		}
+
+		if err := json.Unmarshal(serialized, &received); err != nil {
+			t.Fatalf("Failed to unmarshal metadata: %v", err)
+		}
+
+		if received.Type != event.FocusCallSDPStreamMetadataChanged.Type {
+			t.Fatalf("Unexpected event type: %s", received.Type)
+		}
+
+		return received.Content.Sequence, received.Content.Metadata
+	}
+
+	metadata := event.CallSDPStreamMetadata{
+		"stream": {UserID: "@alice:example.org", DeviceID: "ALICE", Purpose: event.Usermedia},
+	}
+
+	alice, bob := &Participant{}, &Participant{}
+
+	for expected := uint64(1); expected <= 3; expected++ {
+		sequence, received := receive(alice.nextMetadataEvent(AvailableStreams{Metadata: metadata}))
+		if sequence != expected {
+			t.Errorf("Expected the sequence number %d, got %d", expected, sequence)
+		}
+
+		if _, ok := received["stream"]; !ok || len(received) != 1 {
+			t.Errorf("Expected the metadata to be sent along with the sequence number, got %+v", received)
+		}
+	}
+
+	// Every participant has its own sequence.
+	if sequence, _ := receive(bob.nextMetadataEvent(AvailableStreams{Metadata: metadata})); sequence != 1 {
+		t.Errorf("Expected the sequence of another participant to start at 1, got %d", sequence)
+	}
+}
+
+func TestMetadataDelta(t *testing.T) {
+	// Metadata of a big conference.
+	metadata := event.CallSDPStreamMetadata{}
+	for i := 0; i < 100; i++ {
+		metadata[fmt.Sprintf("stream-%d", i)] = event.CallSDPStreamMetadataObject{
+			UserID:   "@user:example.org",
+			DeviceID: "DEVICE",
+			Purpose:  event.Usermedia,
+			Tracks: event.CallSDPStreamMetadataTracks{
+				fmt.Sprintf("audio-%d", i): {Kind: "audio"},
+			},
+		}
+	}
+
+	complete := (&Participant{}).nextMetadataEvent(AvailableStreams{Metadata: metadata})
+	snapshot, err := complete.MarshalJSON()
+	if err != nil {
+		t.Fatalf("Failed to marshal metadata: %v", err)
+	}
+
+	// A new participant publishes its camera.
+	updated := event.CallSDPStreamMetadata{}
+	for streamID, stream := range metadata {
+		updated[streamID] = stream
+	}
+	updated["new-stream"] = event.CallSDPStreamMetadataObject{
+		UserID:   "@newcomer:example.org",
+		DeviceID: "NEWCOMER",
+		Purpose:  event.Usermedia,
+		Tracks:   event.CallSDPStreamMetadataTracks{"camera": {Kind: "video", Width: 1280, Height: 720}},
+	}
+
+	for i := 0; i < 3; i++ {
+		p := &Participant{IncrementalMetadata: true, sentMetadata: AvailableStreams{Metadata: metadata}, metadataSequence: 1}
+
+		delta, changed := p.nextMetadataDeltaEvent(AvailableStreams{Metadata: updated})
+		if !changed {
+			t.Fatal("Expected the new track to be sent")
+		}
+
+		serialized, err := delta.MarshalJSON()
+		if err != nil {
+			t.Fatalf("Failed to marshal delta: %v", err)
+		}
+
+		if len(serialized)*10 > len(snapshot) {
+			t.Errorf("Expected the delta (%d bytes) to be way smaller than the metadata (%d bytes)",
+				len(serialized), len(snapshot))
+		}
+
+		var received struct {
+			Type    string                    `json:"type"`
+			Content MetadataDeltaEventContent `json:"content"`
+		}
+		if err := json.Unmarshal(serialized, &received); err != nil {
+			t.Fatalf("Failed to unmarshal delta: %v", err)
+		}
+
+		if received.Type != FocusCallSDPStreamMetadataDelta.Type {
+			t.Errorf("Unexpected event type: %s", received.Type)
+		}
+		if _, ok := received.Content.Updated["new-stream"]; !ok || len(received.Content.Updated) != 1 {
+			t.Errorf("Expected only the new stream to be sent, got %+v", received.Content.Updated)
+		}
+		if len(received.Content.Removed) != 0 || received.Content.Sequence != 2 {
+			t.Errorf("Unexpected delta: %+v", received.Content)
+		}
+	}
+
+	// Nothing to send if nothing has changed.
+	p := &Participant{IncrementalMetadata: true, sentMetadata: AvailableStreams{Metadata: updated}}
+	if _, changed := p.nextMetadataDeltaEvent(AvailableStreams{Metadata: updated}); changed {
+		t.Error("Expected no delta for the same metadata")
+	}
+
+	// The participant has left.
+	delta, changed := p.nextMetadataDeltaEvent(AvailableStreams{Metadata: metadata})
+	content, ok := delta.Content.Parsed.(MetadataDeltaEventContent)
+	if !changed || !ok || len(content.Updated) != 0 || !reflect.DeepEqual(content.Removed, []string{"new-stream"}) {
+		t.Errorf("Expected the stream to be removed, got %+v", delta.Content.Parsed)
+	}
+}
+
+func TestMetadataLayerResolutions(t *testing.T) {
+	type receivedTrack struct {
+		Kind   string            `json:"kind"`
+		Layers []LayerResolution `json:"layers"`
+	}
+
+	// Returns the tracks of the streams in the event the way a client would see them.
+	receive := func(ev event.Event) map[string]map[string]receivedTrack {
+		serialized, err := ev.MarshalJSON()
+		if err != nil {
+			t.Fatalf("Failed to marshal metadata: %v", err)
+		}
+
+		var received struct {
+			Content struct {
+				Metadata map[string]struct {
+					UserID string                   `json:"user_id"`
+					Tracks map[string]receivedTrack `json:"tracks"`
+				} `json:"sdp_stream_metadata"`
+			} `json:"content"`
+		}
+		if err := json.Unmarshal(serialized, &received); err != nil {
+			t.Fatalf("Failed to unmarshal metadata: %v", err)
+		}
+
+		tracks := make(map[string]map[string]receivedTrack)
+		for streamID, stream := range received.Content.Metadata {
+			if stream.UserID == "" {
+				t.Errorf("Expected the metadata of %s to be sent along with the layers", streamID)
+			}
+			tracks[streamID] = stream.Tracks
+		}
+
+		return tracks
+	}
+
+	metadata := event.CallSDPStreamMetadata{
+		"camera": {
+			UserID:   "@alice:example.org",
+			DeviceID: "ALICE",
+			Purpose:  event.Usermedia,
+			Tracks:   event.CallSDPStreamMetadataTracks{"audio": {Kind: "audio"}, "video": {Kind: "video"}},
+		},
+		"screen": {
+			UserID:   "@bob:example.org",
+			DeviceID: "BOB",
+			Purpose:  event.Screenshare,
+			Tracks:   event.CallSDPStreamMetadataTracks{"screen": {Kind: "video"}},
+		},
+	}
+	layers := LayerResolutions{"camera": {"video": {
+		{Layer: "low", Width: 320, Height: 180},
+		{Layer: "high", Width: 1280, Height: 720},
+	}}}
+
+	p := &Participant{IncrementalMetadata: true}
+	received := receive(p.nextMetadataEvent(AvailableStreams{Metadata: metadata, Layers: layers}))
+	video := received["camera"]["video"]
+	if video.Kind != "video" || !reflect.DeepEqual(video.Layers, layers["camera"]["video"]) {
+		t.Errorf("Expected the resolutions of the layers in the metadata of the track, got %+v", video)
+	}
+	if audio := received["camera"]["audio"]; audio.Kind != "audio" || audio.Layers != nil {
+		t.Errorf("Expected no layers for the audio track, got %+v", audio)
+	}
+	if screen := received["screen"]["screen"]; screen.Kind != "video" || screen.Layers != nil {
+		t.Errorf("Expected no layers for the track whose resolution is not known, got %+v", screen)
+	}
+	p.sentMetadata = AvailableStreams{Metadata: metadata, Layers: layers}
+
+	// The resolution of the screen share gets known, only its stream changes.
+	updated := LayerResolutions{"camera": layers["camera"], "screen": {"screen": {
+		{Layer: "medium", Width: 960, Height: 540},
+	}}}
+	delta, changed := p.nextMetadataDeltaEvent(AvailableStreams{Metadata: metadata, Layers: updated})
+	if !changed {
+		t.Fatal("Expected the new resolution to be sent")
+	}
+
+	received = receive(delta)
+	if _, ok := received["camera"]; ok || len(received) != 1 {
+		t.Errorf("Expected only the stream with the new resolution to be sent, got %+v", received)
+	}
+	if screen := received["screen"]["screen"]; !reflect.DeepEqual(screen.Layers, updated["screen"]["screen"]) {
+		t.Errorf("Expected the new resolution of the screen share, got %+v", screen)
+	}
+}
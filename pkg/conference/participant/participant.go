@@ -1,6 +1,13 @@
 package participant
 
 import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/matrix-org/waterfall/pkg/metrics"
 	"github.com/matrix-org/waterfall/pkg/peer"
 	"github.com/matrix-org/waterfall/pkg/signaling"
 	"github.com/matrix-org/waterfall/pkg/telemetry"
@@ -10,28 +17,100 @@ import (
 )
 
 // Things that we assume as identifiers for the participants in the call.
-// There could be no 2 participants in the room with identical IDs.
+//
+// A participant is identified by its user and device (see `Key`), i.e. there could be no 2 participants
+// in the conference with the same user and device. The `CallID` is not a part of the identity: it's an
+// attribute of the current call of the participant that we need to address the signaling messages. When a
+// device joins with a new call ID (or a new session ID), the new call replaces the old one.
 type ID struct {
 	UserID   id.UserID
 	DeviceID id.DeviceID
 	CallID   string
 }
 
+// The part of the `ID` that identifies a participant in the conference.
+type Key struct {
+	UserID   id.UserID
+	DeviceID id.DeviceID
+}
+
 func (id ID) String() string {
 	return string(id.UserID) + "/" + string(id.DeviceID)
 }
 
+// Returns the key that identifies the participant regardless of its current call.
+func (id ID) Key() Key {
+	return Key{UserID: id.UserID, DeviceID: id.DeviceID}
+}
+
+// Checks if both IDs belong to the same participant (possibly in different calls).
+func (id ID) SameParticipant(other ID) bool {
+	return id.Key() == other.Key()
+}
+
+// Checks if both IDs belong to the same participant in the same call.
+func (id ID) SameCall(other ID) bool {
+	return id == other
+}
+
+// The desired resolution of the subscribed video (0 means the default).
+type Resolution struct {
+	Width  int
+	Height int
+}
+
 // Participant represents a participant in the conference.
 type Participant struct {
-	ID              ID
+	ID ID
+	// Correlates the log entries and the telemetry of the participant (its peer, publishers and
+	// subscriptions) throughout its stay in the conference, see `NewTraceID()`.
+	TraceID         string
 	Peer            *peer.Peer[ID]
 	RemoteSessionID id.SessionID
-	Pong            chan<- Pong
+	Heartbeat       *Heartbeat
+	// Time of the last meaningful activity (publishing, subscribing, data channel messages).
+	LastActivity time.Time
+	// Audio-only participants are neither told about the video tracks nor allowed to subscribe to them.
+	AudioOnly bool
+	// The participants all of whose tracks the participant subscribes to, including the ones that they publish
+	// later on, along with the desired resolution of their video.
+	SubscribedParticipants map[Key]Resolution
+	// The ICE candidates that the peer connection of the participant uses (`nil` until it's connected).
+	CandidatePair *peer.CandidatePair
+	// The last SDP answer that has been sent over Matrix, so that it could be re-sent if the
+	// participant has missed it (the to-device messages are not guaranteed to be delivered).
+	LastSDPAnswer string
+	// When the last offer of the participant (sent over the data channel) has been applied and the
+	// latest offer that came too soon after it, if any (see `Profile.MinNegotiationInterval`).
+	LastNegotiation time.Time
+	PendingOffer    *event.FocusCallNegotiateEventContent
+	// When the current second of forwarding the data channel messages of the participant to others started
+	// and how many of them have been forwarded since then (see `Profile.MaxForwardedDataChannelMessages`).
+	ForwardingStart   time.Time
+	ForwardedMessages int
+	// Only the changes of the metadata are sent to the participant once the complete one has been sent,
+	// see `SendMetadata()`.
+	IncrementalMetadata bool
+	// The sequence number of the last metadata event sent to the participant, see `MetadataSequenceKey`.
+	metadataSequence uint64
+	// The metadata that the participant has got so far (`nil` if none).
+	sentMetadata AvailableStreams
 
 	Logger    *logrus.Entry
 	Telemetry *telemetry.Telemetry
 }
 
+// Generates a random trace ID for a new participant.
+func NewTraceID() string {
+	bytes := make([]byte, 8)
+	if _, err := rand.Read(bytes); err != nil {
+		// Should never happen, and the ID is only used to make the debugging easier anyway.
+		return fmt.Sprintf("%016x", time.Now().UnixNano())
+	}
+
+	return hex.EncodeToString(bytes)
+}
+
 func (p *Participant) AsMatrixRecipient() signaling.MatrixRecipient {
 	return signaling.MatrixRecipient{
 		UserID:          p.ID.UserID,
@@ -41,15 +120,61 @@ func (p *Participant) AsMatrixRecipient() signaling.MatrixRecipient {
 	}
 }
 
+// Sends an event over the default data channel.
 func (p *Participant) SendOverDataChannel(ev event.Event) error {
+	label, found := p.Peer.DefaultDataChannelLabel()
+	if !found {
+		return peer.ErrDataChannelNotAvailable
+	}
+
+	return p.SendOverLabeledDataChannel(label, ev)
+}
+
+// Sends an event that may be dropped if the default data channel can't keep up, see
+// `peer.Peer.SendLowPriorityOverDataChannel()`.
+func (p *Participant) SendLowPriorityOverDataChannel(ev event.Event) error {
+	label, found := p.Peer.DefaultDataChannelLabel()
+	if !found {
+		return peer.ErrDataChannelNotAvailable
+	}
+
+	return p.sendOverDataChannel(label, ev, p.Peer.SendLowPriorityOverDataChannel)
+}
+
+// Sends an event over the data channel with a given label.
+func (p *Participant) SendOverLabeledDataChannel(label string, ev event.Event) error {
+	return p.sendOverDataChannel(label, ev, p.Peer.SendOverDataChannel)
+}
+
+// Sends an event over the data channel with a given label using a given function of the peer. The events
+// that are refused since the data channel is congested are counted.
+func (p *Participant) sendOverDataChannel(label string, ev event.Event, send func(string, ...string) error) error {
+	err := p.sendChunked(label, ev, send)
+	if errors.Is(err, peer.ErrDataChannelCongested) {
+		metrics.DataChannelDropped.Add(ev.Type.Type, 1)
+		p.Logger.Debugf("Dropped %s, the data channel is congested", ev.Type.Type)
+	}
+
+	return err
+}
+
+func (p *Participant) sendChunked(label string, ev event.Event, send func(string, ...string) error) error {
 	json, err := ev.MarshalJSON()
 	if err != nil {
 		return err
 	}
 
-	if err := p.Peer.SendOverDataChannel(string(json)); err != nil {
-		return err
+	// Large events (e.g. the metadata of a big conference) don't fit into a single message.
+	if maxSize := p.Peer.MaxDataChannelMessageSize(); len(json) > maxSize {
+		chunks, err := chunkMessage(string(json), maxSize)
+		if err != nil {
+			return err
+		}
+
+		// The chunks are sent all at once, so that the congestion never leaves the event incomplete.
+		p.Logger.Debugf("Sending %s (%d bytes) in %d chunks", ev.Type.Type, len(json), len(chunks))
+		return send(label, chunks...)
 	}
 
-	return nil
+	return send(label, string(json))
 }
@@ -1,13 +1,22 @@
 package participant
 
 import (
+	"errors"
 	"fmt"
+	"sort"
 
+	"github.com/matrix-org/waterfall/pkg/conference/subscription"
 	"github.com/matrix-org/waterfall/pkg/conference/track"
 	"github.com/matrix-org/waterfall/pkg/webrtc_ext"
 	"github.com/pion/webrtc/v3"
 )
 
+var (
+	ErrParticipantNotFound = errors.New("participant does not exist")
+	ErrTrackNotFound       = errors.New("track does not exist")
+	ErrAudioOnly           = errors.New("participant only receives audio")
+)
+
 type TrackStoppedMessage struct {
 	TrackID track.TrackID
 	OwnerID ID
@@ -16,41 +25,89 @@ type TrackStoppedMessage struct {
 // Tracks participants and their corresponding tracks.
 // These are grouped together as the field in this structure must be kept synchronized.
 type Tracker struct {
-	participants    map[ID]*Participant
+	participants    map[Key]*Participant
 	publishedTracks map[track.TrackID]*track.PublishedTrack[ID]
+	trackConfig     track.Config
+	// The join indices of all participants that have ever joined the conference (see `JoinIndex`).
+	joinIndices   map[Key]int
+	nextJoinIndex int
 
 	publishedTrackStopped chan<- TrackStoppedMessage
 	conferenceEnded       <-chan struct{}
 }
 
-func NewParticipantTracker(conferenceEnded <-chan struct{}) (*Tracker, <-chan TrackStoppedMessage) {
+func NewParticipantTracker(
+	conferenceEnded <-chan struct{},
+	trackConfig track.Config,
+) (*Tracker, <-chan TrackStoppedMessage) {
 	publishedTrackStopped := make(chan TrackStoppedMessage)
 	return &Tracker{
-		participants:          make(map[ID]*Participant),
+		participants:          make(map[Key]*Participant),
 		publishedTracks:       make(map[track.TrackID]*track.PublishedTrack[ID]),
+		trackConfig:           trackConfig,
+		joinIndices:           make(map[Key]int),
 		publishedTrackStopped: publishedTrackStopped,
 		conferenceEnded:       conferenceEnded,
 	}, publishedTrackStopped
 }
 
+// Updates the configuration that is used for the tracks published from now on.
+func (t *Tracker) SetTrackConfig(trackConfig track.Config) {
+	t.trackConfig = trackConfig
+}
+
 // Adds a new participant in the list.
 func (t *Tracker) AddParticipant(participant *Participant) {
-	t.participants[participant.ID] = participant
+	key := participant.ID.Key()
+	t.participants[key] = participant
+
+	if _, found := t.joinIndices[key]; !found {
+		t.joinIndices[key] = t.nextJoinIndex
+		t.nextJoinIndex++
+	}
+}
+
+// Returns the index of the participant in the order in which the participants joined the conference. The
+// indices don't change when others leave and a participant that reconnects (or rejoins the conference with
+// the same device) gets its previous index back, so that the clients can build stable layouts. Returns -1
+// for the participants that never joined.
+func (t *Tracker) JoinIndex(participantID ID) int {
+	if index, found := t.joinIndices[participantID.Key()]; found {
+		return index
+	}
+
+	return -1
 }
 
-// Gets an existing participant if any.
+// Gets an existing participant if any. The call ID of `participantID` is ignored, use
+// `ID.SameCall()` on the result if the lookup is specific to a particular call.
 func (t *Tracker) GetParticipant(participantID ID) *Participant {
-	return t.participants[participantID]
+	return t.participants[participantID.Key()]
+}
+
+// Returns the ID under which the participant is known to the tracker (i.e. with the call ID of its current
+// call), so that the IDs that came from different calls of the same participant could be used as the keys.
+func (t *Tracker) canonicalID(participantID ID) ID {
+	if participant := t.GetParticipant(participantID); participant != nil {
+		return participant.ID
+	}
+
+	return participantID
 }
 
 func (t *Tracker) HasParticipants() bool {
 	return len(t.participants) != 0
 }
 
+// Returns the amount of participants in the conference.
+func (t *Tracker) ParticipantCount() int {
+	return len(t.participants)
+}
+
 // Iterates over participants and calls a closure on each of the participants.
 func (t *Tracker) ForEachParticipant(fn func(ID, *Participant)) {
-	for id, participant := range t.participants {
-		fn(id, participant)
+	for _, participant := range t.participants {
+		fn(participant.ID, participant)
 	}
 }
 
@@ -64,10 +121,12 @@ func (t *Tracker) RemoveParticipant(participantID ID) map[string]bool {
 
 	defer participant.Telemetry.End()
 
-	// Terminate the participant and remove it from the list.
+	// Stop the heartbeat first, so that it does not ping (or time out) the participant that is gone,
+	// then terminate the participant and remove it from the list.
+	participantID = participant.ID
+	participant.Heartbeat.Stop()
 	participant.Peer.Terminate()
-	close(participant.Pong)
-	delete(t.participants, participantID)
+	delete(t.participants, participantID.Key())
 
 	// Remove the participant's tracks from all participants who might have subscribed to them.
 	streamIdentifiers := make(map[string]bool)
@@ -81,11 +140,17 @@ func (t *Tracker) RemoveParticipant(participantID ID) map[string]bool {
 
 	// Go over all subscriptions and remove the participant from them.
 	// TODO: Perhaps we could simply react to the subscrpitions dying and remove them from the list.
+	t.UnsubscribeAll(participantID)
+
+	return streamIdentifiers
+}
+
+// Unsubscribes the participant from all tracks it is subscribed to.
+func (t *Tracker) UnsubscribeAll(participantID ID) {
+	participantID = t.canonicalID(participantID)
 	for _, publishedTrack := range t.publishedTracks {
 		publishedTrack.Unsubscribe(participantID)
 	}
-
-	return streamIdentifiers
 }
 
 // Adds a new track to the list of published tracks, i.e. by calling it we inform the tracker that there is new track
@@ -95,10 +160,11 @@ func (t *Tracker) AddPublishedTrack(
 	remoteTrack *webrtc.TrackRemote,
 	metadata track.TrackMetadata,
 ) error {
-	participant := t.participants[participantID]
+	participant := t.GetParticipant(participantID)
 	if participant == nil {
 		return fmt.Errorf("participant %s does not exist", participantID)
 	}
+	participantID = participant.ID
 
 	// If this is a new track, let's add it to the list of published and inform participants.
 	if published, found := t.publishedTracks[remoteTrack.ID()]; found {
@@ -112,8 +178,10 @@ func (t *Tracker) AddPublishedTrack(
 	published, err := track.NewPublishedTrack(
 		participantID,
 		participant.Peer.RequestKeyFrame,
+		participant.Peer.WriteRTCP,
 		remoteTrack,
 		metadata,
+		t.trackConfig,
 		participant.Logger,
 		participant.Telemetry.ChildBuilder(),
 	)
@@ -144,6 +212,24 @@ func (t *Tracker) ForEachPublishedTrackInfo(fn func(ID, webrtc_ext.TrackInfo)) {
 	}
 }
 
+// Iterates over the published tracks, e.g. to adjust all of them at once.
+func (t *Tracker) ForEachPublishedTrack(fn func(*track.PublishedTrack[ID])) {
+	for _, published := range t.publishedTracks {
+		fn(published)
+	}
+}
+
+// Checks if none of the published tracks is live, i.e. all of them are muted (or nothing is published).
+func (t *Tracker) AllTracksMuted() bool {
+	for _, published := range t.publishedTracks {
+		if !published.Metadata().Muted {
+			return false
+		}
+	}
+
+	return true
+}
+
 // Updates metadata associated with a given track.
 func (t *Tracker) UpdatePublishedTrackMetadata(id track.TrackID, metadata track.TrackMetadata) {
 	if track, found := t.publishedTracks[id]; found {
@@ -152,9 +238,149 @@ func (t *Tracker) UpdatePublishedTrackMetadata(id track.TrackID, metadata track.
 	}
 }
 
+// Pins (or unpins) a given track, so that it's always forwarded in the highest available quality.
+func (t *Tracker) PinTrack(id track.TrackID, pinned bool) {
+	if track, found := t.publishedTracks[id]; found {
+		track.SetPinned(pinned)
+	}
+}
+
+// Mutes (or unmutes) the published audio tracks of a participant for everyone, see
+// `track.PublishedTrack.SetForceMuted()`. Returns the amount of affected tracks.
+func (t *Tracker) SetForceMuted(participantID ID, muted bool) int {
+	affected := 0
+	for _, published := range t.publishedTracks {
+		if published.Owner().SameParticipant(participantID) && published.SetForceMuted(muted) {
+			affected++
+		}
+	}
+
+	return affected
+}
+
+// Tells a given audio track which header extension carries its audio level, see
+// `track.PublishedTrack.SetAudioLevelExtension()`.
+func (t *Tracker) SetAudioLevelExtension(id track.TrackID, extension uint8) {
+	if published, found := t.publishedTracks[id]; found {
+		published.SetAudioLevelExtension(extension)
+	}
+}
+
+// Forwards only the audio tracks of the `speakers` most recent speakers and suppresses the other ones, so that the
+// subscribers of a big conference don't get (and mix) dozens of audio streams. All audio tracks are forwarded
+// if `speakers` is 0 or if there are not more of them. Returns the amount of suppressed tracks.
+func (t *Tracker) SelectSpeakers(speakers int) int {
+	audio := []*track.PublishedTrack[ID]{}
+	for _, published := range t.publishedTracks {
+		if published.Info().Kind == webrtc.RTPCodecTypeAudio {
+			audio = append(audio, published)
+		}
+	}
+
+	// The tracks that spoke at the same time (or never) are ordered by their IDs, so that they don't flap.
+	sort.Slice(audio, func(i, j int) bool {
+		if lastSpoke, other := audio[i].LastSpoke(), audio[j].LastSpoke(); !lastSpoke.Equal(other) {
+			return lastSpoke.After(other)
+		}
+
+		return audio[i].Info().TrackID < audio[j].Info().TrackID
+	})
+
+	suppressed := 0
+	for index, published := range audio {
+		suppress := speakers > 0 && index >= speakers
+		if published.SetSuppressed(suppress) && suppress {
+			suppressed++
+		}
+	}
+
+	return suppressed
+}
+
+// Sets the simulcast layers of a track that its owner has paused.
+func (t *Tracker) SetPausedLayers(participantID ID, trackID track.TrackID, layers []webrtc_ext.SimulcastLayer) error {
+	published := t.publishedTracks[trackID]
+	if published == nil {
+		return fmt.Errorf("%w: %s", ErrTrackNotFound, trackID)
+	}
+
+	// Only the publisher knows which layers it sends.
+	if published.Owner() != t.canonicalID(participantID) {
+		return fmt.Errorf("track %s is not published by %s", trackID, participantID)
+	}
+
+	published.SetPausedLayers(layers)
+	return nil
+}
+
+// Starts capturing the packets of a given track that are forwarded to a given participant.
+func (t *Tracker) StartCapture(participantID ID, trackID track.TrackID, config subscription.CaptureConfig) error {
+	published := t.publishedTracks[trackID]
+	if published == nil {
+		config.Output.Close()
+		return fmt.Errorf("%w: %s", ErrTrackNotFound, trackID)
+	}
+
+	return published.StartCapture(t.canonicalID(participantID), config)
+}
+
+// Requests a key frame from the publishers of all video tracks. Returns how many key frames were requested.
+func (t *Tracker) RequestKeyFrames() int {
+	requested := 0
+	for _, published := range t.publishedTracks {
+		requested += published.RequestKeyFrames()
+	}
+
+	return requested
+}
+
+// Returns the incoming bitrate (in bits per second) of each simulcast layer of a given track.
+func (t *Tracker) LayerBitrates(id track.TrackID) map[webrtc_ext.SimulcastLayer]uint64 {
+	if publishedTrack, found := t.publishedTracks[id]; found {
+		return publishedTrack.LayerBitrates()
+	}
+
+	return nil
+}
+
+// Returns the resolutions of the active simulcast layers of a given track, see
+// `track.PublishedTrack.LayerResolutions()`.
+func (t *Tracker) LayerResolutions(id track.TrackID) map[webrtc_ext.SimulcastLayer]track.Resolution {
+	if publishedTrack, found := t.publishedTracks[id]; found {
+		return publishedTrack.LayerResolutions()
+	}
+
+	return nil
+}
+
+// Returns the simulcast layers of a given track that have stalled, see `track.PublishedTrack.StalledLayers()`.
+func (t *Tracker) StalledLayers(id track.TrackID) []webrtc_ext.SimulcastLayer {
+	if publishedTrack, found := t.publishedTracks[id]; found {
+		return publishedTrack.StalledLayers()
+	}
+
+	return nil
+}
+
+// Returns how a given participant receives the tracks that it's subscribed to (the ones it has reported about).
+func (t *Tracker) ReceptionStats(participantID ID) []track.ReceptionStats {
+	participantID = t.canonicalID(participantID)
+
+	stats := []track.ReceptionStats{}
+	for _, published := range t.publishedTracks {
+		if trackStats, ok := published.ReceptionStats(participantID); ok {
+			stats = append(stats, trackStats)
+		}
+	}
+
+	return stats
+}
+
 // Informs the tracker that one of the previously published tracks is gone.
 func (t *Tracker) RemovePublishedTrack(id track.TrackID) {
 	if publishedTrack, found := t.publishedTracks[id]; found {
+		// Don't let the subscribers wait for the publishers to notice that they're stopped.
+		publishedTrack.UnsubscribeAll()
 		publishedTrack.Stop()
 		delete(t.publishedTracks, id)
 	}
@@ -167,15 +393,20 @@ func (t *Tracker) Subscribe(
 	desiredWidth, desiredHeight int,
 ) error {
 	// Check if the participant exists that wants to subscribe exists.
-	participant := t.participants[participantID]
+	participant := t.GetParticipant(participantID)
 	if participant == nil {
-		return fmt.Errorf("participant %s does not exist", participantID)
+		return fmt.Errorf("%w: %s", ErrParticipantNotFound, participantID)
 	}
+	participantID = participant.ID
 
 	// Check if the track that we want to subscribe exists.
 	published := t.publishedTracks[trackID]
 	if published == nil {
-		return fmt.Errorf("track %s does not exist", trackID)
+		return fmt.Errorf("%w: %s", ErrTrackNotFound, trackID)
+	}
+
+	if participant.AudioOnly && published.Info().Kind == webrtc.RTPCodecTypeVideo {
+		return fmt.Errorf("%w, can't subscribe to %s", ErrAudioOnly, trackID)
 	}
 
 	// Subscribe to the track.
@@ -195,6 +426,28 @@ func (t *Tracker) Subscribe(
 // Unsubscribes a given `participantID` from the track.
 func (t *Tracker) Unsubscribe(participantID ID, trackID track.TrackID) {
 	if published := t.publishedTracks[trackID]; published != nil {
-		published.Unsubscribe(participantID)
+		published.Unsubscribe(t.canonicalID(participantID))
 	}
 }
+
+// Checks if the participant publishes any track or is subscribed to any track.
+func (t *Tracker) IsPublishingOrSubscribing(participantID ID) bool {
+	participantID = t.canonicalID(participantID)
+	for _, published := range t.publishedTracks {
+		if published.Owner() == participantID || published.IsSubscribed(participantID) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Returns the SSRC mappings of all subscriptions in the conference.
+func (t *Tracker) SSRCMappings() []track.SSRCMapping {
+	mappings := []track.SSRCMapping{}
+	for _, published := range t.publishedTracks {
+		mappings = append(mappings, published.SSRCMappings()...)
+	}
+
+	return mappings
+}
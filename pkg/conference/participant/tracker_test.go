@@ -0,0 +1,52 @@
+package participant_test
+
+import (
+	"testing"
+
+	"github.com/matrix-org/waterfall/pkg/conference/participant"
+	"github.com/matrix-org/waterfall/pkg/conference/track"
+)
+
+func TestParticipantIdentity(t *testing.T) {
+	first := participant.ID{UserID: "@alice:example.org", DeviceID: "ALICE", CallID: "first"}
+	second := participant.ID{UserID: "@alice:example.org", DeviceID: "ALICE", CallID: "second"}
+	other := participant.ID{UserID: "@alice:example.org", DeviceID: "PHONE", CallID: "first"}
+
+	if !first.SameParticipant(second) || first.SameCall(second) {
+		t.Errorf("Expected %v and %v to be the same participant in different calls", first, second)
+	}
+
+	if first.SameParticipant(other) {
+		t.Errorf("Expected %v and %v to be different participants", first, other)
+	}
+}
+
+func TestGetParticipantWithNewCallID(t *testing.T) {
+	tracker, _ := participant.NewParticipantTracker(make(chan struct{}), track.Config{})
+
+	// The participant joined with one call ID and reconnected with another one.
+	joined := participant.ID{UserID: "@alice:example.org", DeviceID: "ALICE", CallID: "first"}
+	reconnected := participant.ID{UserID: "@alice:example.org", DeviceID: "ALICE", CallID: "second"}
+
+	tracker.AddParticipant(&participant.Participant{ID: joined})
+
+	found := tracker.GetParticipant(reconnected)
+	if found == nil {
+		t.Fatal("Expected the participant to be found regardless of the call ID")
+	}
+
+	// The tracker keeps the call ID of the call that the participant joined with.
+	if found.ID != joined {
+		t.Errorf("Expected %+v, got %+v", joined, found.ID)
+	}
+
+	if tracker.ParticipantCount() != 1 {
+		t.Errorf("Expected a single participant, got %d", tracker.ParticipantCount())
+	}
+
+	// Replacing the participant with the new call must not create a duplicate.
+	tracker.AddParticipant(&participant.Participant{ID: reconnected})
+	if tracker.ParticipantCount() != 1 || tracker.GetParticipant(joined).ID != reconnected {
+		t.Errorf("Expected the participant to be replaced by the new call")
+	}
+}
@@ -0,0 +1,108 @@
+package conference
+
+import (
+	"encoding/json"
+
+	"github.com/matrix-org/waterfall/pkg/conference/participant"
+	"github.com/matrix-org/waterfall/pkg/webrtc_ext"
+	"github.com/pion/webrtc/v3"
+	"maunium.net/go/mautrix/id"
+)
+
+// The extension of the `m.call.track_subscription` event that subscribes to all tracks of the given participants
+// at once, so that the clients don't have to list them. The subscriptions are also extended to the tracks that
+// these participants publish later on.
+type ParticipantSubscriptionEventContent struct {
+	// Subscribe to all current and future tracks of these participants.
+	SubscribeParticipants []ParticipantSubscription `json:"subscribe_participants,omitempty"`
+	// Unsubscribe from all current tracks of these participants and stop subscribing to their future ones.
+	UnsubscribeParticipants []ParticipantSubscription `json:"unsubscribe_participants,omitempty"`
+}
+
+// A participant (i.e. a user and device) whose tracks are subscribed to as a whole.
+type ParticipantSubscription struct {
+	UserID   id.UserID   `json:"user_id"`
+	DeviceID id.DeviceID `json:"device_id"`
+	// The desired resolution of the video of the participant (the default if not set).
+	Width  int `json:"width,omitempty"`
+	Height int `json:"height,omitempty"`
+}
+
+func (c *Conference) processParticipantSubscriptionMessage(p *participant.Participant, raw json.RawMessage) {
+	var content ParticipantSubscriptionEventContent
+	if err := json.Unmarshal(raw, &content); err != nil {
+		p.Logger.Errorf("Failed to unmarshal participant subscriptions: %v", err)
+		return
+	}
+
+	for _, unsubscribe := range content.UnsubscribeParticipants {
+		key := participant.Key{UserID: unsubscribe.UserID, DeviceID: unsubscribe.DeviceID}
+		p.Logger.Infof("Unsubscribing from all tracks of %s/%s", key.UserID, key.DeviceID)
+		delete(p.SubscribedParticipants, key)
+
+		for _, info := range c.publishedTracksOf(key) {
+			c.tracker.Unsubscribe(p.ID, info.TrackID)
+		}
+	}
+
+	for _, subscribe := range content.SubscribeParticipants {
+		key := participant.Key{UserID: subscribe.UserID, DeviceID: subscribe.DeviceID}
+		if key == p.ID.Key() {
+			continue
+		}
+
+		p.Logger.Infof("Subscribing to all tracks of %s/%s", key.UserID, key.DeviceID)
+		if p.SubscribedParticipants == nil {
+			p.SubscribedParticipants = make(map[participant.Key]participant.Resolution)
+		}
+		resolution := participant.Resolution{Width: subscribe.Width, Height: subscribe.Height}
+		p.SubscribedParticipants[key] = resolution
+
+		for _, info := range c.publishedTracksOf(key) {
+			c.subscribeToParticipantTrack(p, info, resolution)
+		}
+	}
+}
+
+// Subscribes the participants that subscribe to everything of the owner of a newly published track to it.
+func (c *Conference) subscribeToNewTrack(owner participant.ID, remoteTrack *webrtc.TrackRemote) {
+	info := webrtc_ext.TrackInfoFromTrack(remoteTrack)
+	c.tracker.ForEachParticipant(func(_ participant.ID, p *participant.Participant) {
+		if resolution, ok := p.SubscribedParticipants[owner.Key()]; ok {
+			c.subscribeToParticipantTrack(p, info, resolution)
+		}
+	})
+}
+
+// Subscribes a participant to a track of a participant that it subscribes to as a whole. The client has not
+// asked for the track explicitly, so it's only informed if the subscription fails for another reason than
+// being audio-only, in which case the video is skipped silently.
+func (c *Conference) subscribeToParticipantTrack(
+	p *participant.Participant,
+	info webrtc_ext.TrackInfo,
+	resolution participant.Resolution,
+) {
+	if p.AudioOnly && info.Kind == webrtc.RTPCodecTypeVideo {
+		return
+	}
+
+	if err := c.tracker.Subscribe(p.ID, info.TrackID, resolution.Width, resolution.Height); err != nil {
+		p.Logger.Errorf("Failed to subscribe to track %s: %v", info.TrackID, err)
+
+		if err := p.SendOverDataChannel(newSubscriptionFailedEvent(info.TrackID, err)); err != nil {
+			p.Logger.Errorf("Failed to send subscription failure: %v", err)
+		}
+	}
+}
+
+// Returns the tracks that a given participant publishes.
+func (c *Conference) publishedTracksOf(key participant.Key) []webrtc_ext.TrackInfo {
+	tracks := []webrtc_ext.TrackInfo{}
+	c.tracker.ForEachPublishedTrackInfo(func(owner participant.ID, info webrtc_ext.TrackInfo) {
+		if owner.Key() == key {
+			tracks = append(tracks, info)
+		}
+	})
+
+	return tracks
+}
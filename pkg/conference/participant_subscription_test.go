@@ -0,0 +1,80 @@
+package conference //nolint:testpackage
+
+import (
+	"context"
+	"testing"
+
+	"github.com/matrix-org/waterfall/pkg/conference/participant"
+	"github.com/matrix-org/waterfall/pkg/conference/track"
+	"github.com/matrix-org/waterfall/pkg/peer"
+	"github.com/matrix-org/waterfall/pkg/telemetry"
+	"github.com/sirupsen/logrus"
+)
+
+func TestSubscribeToAllTracksOfParticipant(t *testing.T) {
+	conference := newTestConference(t)
+	tracker := conference.tracker
+	logger := logrus.NewEntry(logrus.New())
+	tel := telemetry.NewTelemetry(context.Background(), "Conference")
+
+	alice := participant.ID{UserID: "@alice:example.org", DeviceID: "ALICE", CallID: "call"}
+	bob := participant.ID{UserID: "@bob:example.org", DeviceID: "BOB", CallID: "call"}
+	carol := participant.ID{UserID: "@carol:example.org", DeviceID: "CAROL", CallID: "call"}
+
+	tracker.AddParticipant(&participant.Participant{ID: alice, Logger: logger, Telemetry: tel})
+	tracker.AddParticipant(&participant.Participant{ID: bob, Peer: newSubscriberPeer(t, bob), Logger: logger, Telemetry: tel})
+	carolPeer := newSubscriberPeer(t, carol)
+	tracker.AddParticipant(&participant.Participant{ID: carol, Peer: carolPeer, Logger: logger, Telemetry: tel})
+
+	// Alice publishes a track before and after Bob subscribes to all of her tracks.
+	publish := func(streamID, trackID string) {
+		remoteTrack := publishAudioTracks(t, streamID, trackID)[0]
+		conference.processNewTrackPublishedMessage(alice, peer.NewTrackPublished{RemoteTrack: remoteTrack})
+	}
+	// Returns the subscribers of Alice's track.
+	subscribers := func(trackID string) map[participant.ID]bool {
+		subscribed := make(map[participant.ID]bool)
+		tracker.ForEachPublishedTrack(func(published *track.PublishedTrack[participant.ID]) {
+			if published.Info().TrackID == trackID {
+				for _, id := range []participant.ID{bob, carol} {
+					subscribed[id] = published.IsSubscribed(id)
+				}
+			}
+		})
+		return subscribed
+	}
+
+	publish("stream", "mic")
+
+	conference.processDataChannelMessage(bob, peer.DataChannelMessage{
+		Message: `{"type": "m.call.track_subscription", "content": {
+			"subscribe_participants": [{"user_id": "@alice:example.org", "device_id": "ALICE"}]
+		}}`,
+	})
+	if subscribed := subscribers("mic"); !subscribed[bob] || subscribed[carol] {
+		t.Errorf("Expected only bob to be subscribed to the current track, got %v", subscribed)
+	}
+
+	// The track that Alice publishes later on is subscribed to automatically.
+	publish("screen", "screen-audio")
+	if subscribed := subscribers("screen-audio"); !subscribed[bob] || subscribed[carol] {
+		t.Errorf("Expected only bob to be subscribed to the new track, got %v", subscribed)
+	}
+
+	// Until Bob unsubscribes from Alice.
+	conference.processDataChannelMessage(bob, peer.DataChannelMessage{
+		Message: `{"type": "m.call.track_subscription", "content": {
+			"unsubscribe_participants": [{"user_id": "@alice:example.org", "device_id": "ALICE"}]
+		}}`,
+	})
+	for _, trackID := range []string{"mic", "screen-audio"} {
+		if subscribed := subscribers(trackID); subscribed[bob] {
+			t.Errorf("Expected bob to be unsubscribed from %s", trackID)
+		}
+	}
+
+	publish("other", "other-audio")
+	if subscribed := subscribers("other-audio"); subscribed[bob] {
+		t.Error("Expected bob not to be subscribed to the tracks published after unsubscribing")
+	}
+}
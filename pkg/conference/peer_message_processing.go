@@ -1,10 +1,15 @@
 package conference
 
 import (
+	"errors"
+	"fmt"
+	"time"
+
 	"github.com/matrix-org/waterfall/pkg/conference/participant"
 	published "github.com/matrix-org/waterfall/pkg/conference/track"
 	"github.com/matrix-org/waterfall/pkg/peer"
 	"github.com/matrix-org/waterfall/pkg/signaling"
+	"github.com/sirupsen/logrus"
 	"go.opentelemetry.io/otel/attribute"
 	"maunium.net/go/mautrix/event"
 )
@@ -13,8 +18,24 @@ func (c *Conference) processJoinedTheCallMessage(sender participant.ID, message
 	c.newLogger(sender).Info("Joined the call")
 
 	if p := c.getParticipant(sender); p != nil {
-		p.Telemetry.AddEvent("joined the call")
-		return
+		p.CandidatePair = message.CandidatePair
+
+		attributes := []attribute.KeyValue{}
+		if pair := message.CandidatePair; pair != nil {
+			attributes = append(attributes,
+				attribute.String("local_candidate_type", pair.Local.Type),
+				attribute.String("local_candidate_address", pair.Local.Address),
+				attribute.String("remote_candidate_type", pair.Remote.Type),
+				attribute.String("remote_candidate_address", pair.Remote.Address),
+			)
+			p.Logger.WithField("relayed", pair.Relayed()).Infof(
+				"Selected candidate pair: %s (%s) <-> %s (%s)",
+				pair.Local.Address, pair.Local.Type, pair.Remote.Address, pair.Remote.Type,
+			)
+		}
+
+		p.Telemetry.AddEvent("joined the call", attributes...)
+		c.broadcastPresence()
 	}
 }
 
@@ -30,20 +51,48 @@ func (c *Conference) processLeftTheCallMessage(sender participant.ID, msg peer.L
 }
 
 func (c *Conference) processNewTrackPublishedMessage(sender participant.ID, msg peer.NewTrackPublished) {
+	p := c.tracker.GetParticipant(sender)
+	if p != nil && !p.ID.SameCall(sender) {
+		p.Logger.WithField("call_id", sender.CallID).Debug("Ignoring a track of another call")
+		return
+	}
+
 	id := msg.RemoteTrack.ID()
 	c.newLogger(sender).Infof("Published new track: %s (%v)", id, msg.RemoteTrack.RID())
 
 	// Find metadata for a given track.
 	trackMetadata := streamIntoTrackMetadata(c.streamsMetadata)[id]
+	trackMetadata.Encrypted = c.isEncryptedTrack(id)
+	trackMetadata.MaxFrameRate = c.maxFrameRate(trackMetadata)
+
+	if p != nil {
+		p.LastActivity = time.Now()
+	}
 
 	// If a new track has been published, we inform everyone about new track available.
 	c.tracker.AddPublishedTrack(sender, msg.RemoteTrack, trackMetadata)
+	c.tracker.SetAudioLevelExtension(id, msg.AudioLevelExtension)
+	c.events.record(EventTrackPublished, sender, id, msg.RemoteTrack.RID())
+
+	// Tracks of the presenters are always forwarded in the highest available quality.
+	if c.profile.IsPresenter(sender.UserID) {
+		c.tracker.PinTrack(id, true)
+	}
+
+	// The participant can't get around the moderator's mute by publishing a new track.
+	if c.forceMuted[sender.Key()] {
+		c.tracker.SetForceMuted(sender, true)
+	}
+
+	c.subscribeToNewTrack(sender, msg.RemoteTrack)
+
 	c.resendMetadataToAllExcept(sender)
 }
 
 func (c *Conference) processPublishedTrackFailedMessage(sender participant.ID, trackID published.TrackID) {
 	c.newLogger(sender).Infof("Failed published track: %s", trackID)
 	c.tracker.RemovePublishedTrack(trackID)
+	c.events.record(EventTrackFailed, sender, trackID, "")
 	c.resendMetadataToAllExcept(sender)
 }
 
@@ -85,12 +134,11 @@ func (c *Conference) processRenegotiationRequiredMessage(sender participant.ID,
 		return
 	}
 
-	streamsMetadata := c.getAvailableStreamsFor(p.ID)
+	streamsMetadata := c.getAvailableStreamsFor(p.ID).Metadata
 	p.Logger.Infof("Renegotiating, sending SDP offer (%d streams)", len(streamsMetadata))
 	p.Telemetry.AddEvent(
 		"renegotiating, sending SDP offer",
-		attribute.Int("streams_count", len(streamsMetadata)),
-		attribute.String("sdp_offer", msg.Offer.SDP),
+		append(c.sdpAttributes("sdp_offer", msg.Offer.SDP), attribute.Int("streams_count", len(streamsMetadata)))...,
 	)
 
 	offerEvent := event.Event{
@@ -111,6 +159,24 @@ func (c *Conference) processRenegotiationRequiredMessage(sender participant.ID,
 	}
 }
 
+func (c *Conference) processRenegotiationFailedMessage(sender participant.ID, msg peer.RenegotiationFailed) {
+	p := c.getParticipant(sender)
+	if p == nil {
+		return
+	}
+
+	// The subscriptions can't work if the participant never gets their tracks, so roll them back.
+	for _, trackID := range msg.TrackIDs {
+		err := fmt.Errorf("failed to negotiate track %s: %w", trackID, msg.Err)
+		p.Logger.Warnf("Rolling back the subscription: %v", err)
+		c.tracker.Unsubscribe(p.ID, trackID)
+
+		if err := p.SendOverDataChannel(newSubscriptionFailedEvent(trackID, err)); err != nil {
+			p.Logger.Errorf("Failed to send subscription failure: %v", err)
+		}
+	}
+}
+
 func (c *Conference) processDataChannelMessage(sender participant.ID, msg peer.DataChannelMessage) {
 	p := c.getParticipant(sender)
 	if p == nil {
@@ -123,7 +189,12 @@ func (c *Conference) processDataChannelMessage(sender participant.ID, msg peer.D
 		return
 	}
 
-	p.Logger.Debugf("Received data channel message: %v", focusEvent.Type.Type)
+	p.Logger.WithField("label", msg.Label).Debugf("Received data channel message: %v", focusEvent.Type.Type)
+
+	// Pongs are sent automatically by the clients, so they don't count as an activity.
+	if focusEvent.Type.Type != event.FocusCallPong.Type {
+		p.LastActivity = time.Now()
+	}
 
 	// FIXME: We should be able to do
 	// focusEvent.Content.ParseRaw(focusEvent.Type) but it throws an error.
@@ -131,6 +202,7 @@ func (c *Conference) processDataChannelMessage(sender participant.ID, msg peer.D
 	case event.FocusCallTrackSubscription.Type:
 		focusEvent.Content.ParseRaw(event.FocusCallTrackSubscription)
 		c.processTrackSubscriptionMessage(p, *focusEvent.Content.AsFocusCallTrackSubscription())
+		c.processParticipantSubscriptionMessage(p, focusEvent.Content.VeryRaw)
 	case event.FocusCallNegotiate.Type:
 		focusEvent.Content.ParseRaw(event.FocusCallNegotiate)
 		c.processNegotiateMessage(p, *focusEvent.Content.AsFocusCallNegotiate())
@@ -140,8 +212,14 @@ func (c *Conference) processDataChannelMessage(sender participant.ID, msg peer.D
 	case event.FocusCallSDPStreamMetadataChanged.Type:
 		focusEvent.Content.ParseRaw(event.FocusCallSDPStreamMetadataChanged)
 		c.processMetadataMessage(p.ID, *focusEvent.Content.AsFocusCallSDPStreamMetadataChanged())
+	case FocusCallSimulcastLayers.Type:
+		c.processSimulcastLayersMessage(p, focusEvent.Content.VeryRaw)
+	case FocusCallMediaPreference.Type:
+		c.processMediaPreferenceMessage(p, focusEvent.Content.VeryRaw)
+	case FocusCallUnpublish.Type:
+		c.processUnpublishMessage(p, focusEvent.Content.VeryRaw)
 	default:
-		p.Logger.WithField("type", focusEvent.Type.Type).Warn("Received data channel message of unknown type")
+		c.processCustomDataChannelMessage(p, focusEvent)
 	}
 }
 
@@ -151,19 +229,55 @@ func (c *Conference) processDataChannelAvailableMessage(sender participant.ID, m
 		return
 	}
 
-	p.Logger.Info("Connected data channel")
-	metadataEvent := event.Event{
-		Type: event.FocusCallSDPStreamMetadataChanged,
-		Content: event.Content{
-			Parsed: event.FocusCallSDPStreamMetadataChangedEventContent{
-				SDPStreamMetadata: c.getAvailableStreamsFor(p.ID),
-			},
-		},
+	p.Logger.WithField("label", msg.Label).Info("Connected data channel")
+
+	// Only the default data channel carries the metadata, other channels are used for control messages.
+	if !msg.Default {
+		return
 	}
 
-	if err := p.SendOverDataChannel(metadataEvent); err != nil {
+	if err := p.SendMetadataSnapshot(c.getAvailableStreamsFor(p.ID)); err != nil {
 		p.Logger.Errorf("Failed to send SDP stream metadata: %v", err)
 	}
+
+	if err := p.SendOverDataChannel(newPresenceEvent(c.tracker)); err != nil {
+		p.Logger.Errorf("Failed to send presence: %v", err)
+	}
+}
+
+// Hangup reason that is sent when the participant is removed since its data channel has been closed.
+const hangupDataChannelClosed event.CallHangupReason = "data_channel_closed"
+
+func (c *Conference) processDataChannelClosedMessage(sender participant.ID, msg peer.DataChannelClosed) {
+	// The data channels are also closed when the participant is removed, so it's fine if it's gone.
+	p := c.tracker.GetParticipant(sender)
+	if p == nil || !p.ID.SameCall(sender) {
+		return
+	}
+
+	p.Logger.WithField("label", msg.Label).Info("Data channel closed")
+
+	// Other data channels are not used to control the subscriptions.
+	if !msg.Default {
+		return
+	}
+
+	// Another data channel has taken over, so the participant keeps the control over its subscriptions.
+	if msg.Replaced {
+		p.Logger.Info("Another data channel became the default one")
+		return
+	}
+
+	// We lost the control over the participant's subscriptions.
+	switch c.profile.DataChannelClosePolicy {
+	case DataChannelClosePolicyHangup:
+		p.Logger.Info("Removing participant since its data channel has been closed")
+		c.removeParticipant(p.ID)
+		c.matrixWorker.sendSignalingMessage(p.AsMatrixRecipient(), signaling.Hangup{Reason: hangupDataChannelClosed})
+	default:
+		p.Logger.Info("Unsubscribing participant from all tracks since its data channel has been closed")
+		c.tracker.UnsubscribeAll(p.ID)
+	}
 }
 
 // Handle the `FocusEvent` from the DataChannel message.
@@ -172,6 +286,7 @@ func (c *Conference) processTrackSubscriptionMessage(
 	msg event.FocusCallTrackSubscriptionEventContent,
 ) {
 	p.Logger.Debug("Received track subscription request over DC")
+	p.LastActivity = time.Now()
 
 	// Let's first handle the unsubscribe commands.
 	for _, track := range msg.Unsubscribe {
@@ -182,29 +297,50 @@ func (c *Conference) processTrackSubscriptionMessage(
 	for _, track := range msg.Subscribe {
 		if err := c.tracker.Subscribe(p.ID, track.TrackID, track.Width, track.Height); err != nil {
 			p.Logger.Errorf("Failed to subscribe to track %s: %v", track.TrackID, err)
+
+			// Let the client know that the subscription did not take.
+			if err := p.SendOverDataChannel(newSubscriptionFailedEvent(track.TrackID, err)); err != nil {
+				p.Logger.Errorf("Failed to send subscription failure: %v", err)
+			}
 			continue
 		}
 	}
 }
 
 func (c *Conference) processNegotiateMessage(p *participant.Participant, msg event.FocusCallNegotiateEventContent) {
+	// The offers that come too often are coalesced, only the latest one is applied later on.
+	if msg.Description.Type == event.CallDataTypeOffer && c.deferOffer(p, msg, time.Now()) {
+		return
+	}
+
+	c.applyNegotiateMessage(p, msg)
+}
+
+func (c *Conference) applyNegotiateMessage(p *participant.Participant, msg event.FocusCallNegotiateEventContent) {
+	if msg.Description.Type == event.CallDataTypeOffer {
+		c.updateEncryptedTracks(msg.Description.SDP)
+	}
 	c.updateMetadata(msg.SDPStreamMetadata)
 
 	switch msg.Description.Type {
 	case event.CallDataTypeOffer:
 		p.Logger.Info("New offer from peer received")
-		p.Logger.WithField("SDP", msg.Description.SDP).Trace("Received SDP offer over DC")
-		p.Telemetry.AddEvent(
-			"new offer from peer received",
-			attribute.String("sdp_offer", msg.Description.SDP),
-		)
+		c.logSDP(p.Logger, logrus.TraceLevel, msg.Description.SDP, "Received SDP offer over DC")
+		p.Telemetry.AddEvent("new offer from peer received", c.sdpAttributes("sdp_offer", msg.Description.SDP)...)
 
 		answer, err := p.Peer.ProcessSDPOffer(msg.Description.SDP)
-		if err != nil {
+		if errors.Is(err, peer.ErrOfferIgnored) {
+			// Our offer is pending, the participant is expected to answer it instead.
+			p.Telemetry.AddEvent("offer ignored due to glare")
+			return
+		} else if err != nil {
 			p.Logger.Errorf("Failed to set SDP offer: %v", err)
 			return
 		}
 
+		// The participant may have stopped publishing some of its tracks with this offer.
+		c.removeUnpublishedTracks(p.ID, msg.Description.SDP)
+
 		answerEvent := event.Event{
 			Type: event.FocusCallNegotiate,
 			Content: event.Content{
@@ -213,7 +349,7 @@ func (c *Conference) processNegotiateMessage(p *participant.Participant, msg eve
 						Type: event.CallDataType(answer.Type.String()),
 						SDP:  answer.SDP,
 					},
-					SDPStreamMetadata: c.getAvailableStreamsFor(p.ID),
+					SDPStreamMetadata: c.getAvailableStreamsFor(p.ID).Metadata,
 				},
 			},
 		}
@@ -224,11 +360,8 @@ func (c *Conference) processNegotiateMessage(p *participant.Participant, msg eve
 		}
 	case event.CallDataTypeAnswer:
 		p.Logger.Info("Renegotiation answer received")
-		p.Logger.WithField("SDP", msg.Description.SDP).Trace("Received SDP answer over DC")
-		p.Telemetry.AddEvent(
-			"renegotiation answer received",
-			attribute.String("sdp_answer", msg.Description.SDP),
-		)
+		c.logSDP(p.Logger, logrus.TraceLevel, msg.Description.SDP, "Received SDP answer over DC")
+		p.Telemetry.AddEvent("renegotiation answer received", c.sdpAttributes("sdp_answer", msg.Description.SDP)...)
 
 		if err := p.Peer.ProcessSDPAnswer(msg.Description.SDP); err != nil {
 			p.Logger.Errorf("Failed to set SDP answer: %v", err)
@@ -240,10 +373,7 @@ func (c *Conference) processNegotiateMessage(p *participant.Participant, msg eve
 }
 
 func (c *Conference) processPongMessage(p *participant.Participant) {
-	select {
-	case p.Pong <- participant.Pong{}:
-	default:
-	}
+	p.Heartbeat.Pong()
 }
 
 func (c *Conference) processMetadataMessage(
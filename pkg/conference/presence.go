@@ -0,0 +1,62 @@
+package conference
+
+import (
+	"sort"
+
+	"github.com/matrix-org/waterfall/pkg/conference/participant"
+	"maunium.net/go/mautrix/event"
+	"maunium.net/go/mautrix/id"
+)
+
+// Sent by the SFU over the data channel each time the set of participants in the conference changes.
+var FocusCallPresence = event.Type{Type: "m.call.presence", Class: event.FocusEventType}
+
+// Content of the `m.call.presence` event.
+type PresenceEventContent struct {
+	Participants []PresenceParticipant `json:"participants"`
+}
+
+// A participant as seen by other participants. Note that we only expose the identifiers that
+// are already visible to everyone in the room via the Matrix call membership events, i.e. the
+// call IDs and session IDs are deliberately left out.
+type PresenceParticipant struct {
+	UserID   id.UserID   `json:"user_id"`
+	DeviceID id.DeviceID `json:"device_id"`
+	// Stable position of the participant in the order of joining (see `Tracker.JoinIndex`).
+	JoinIndex int `json:"join_index"`
+}
+
+// Creates a presence event listing all participants that are currently in the conference.
+func newPresenceEvent(tracker *participant.Tracker) event.Event {
+	participants := []PresenceParticipant{}
+	tracker.ForEachParticipant(func(id participant.ID, _ *participant.Participant) {
+		participants = append(participants, PresenceParticipant{
+			UserID:    id.UserID,
+			DeviceID:  id.DeviceID,
+			JoinIndex: tracker.JoinIndex(id),
+		})
+	})
+
+	// Keep the order stable so that the clients don't have to sort the list themselves.
+	sort.Slice(participants, func(i, j int) bool {
+		if participants[i].UserID != participants[j].UserID {
+			return participants[i].UserID < participants[j].UserID
+		}
+		return participants[i].DeviceID < participants[j].DeviceID
+	})
+
+	return event.Event{
+		Type:    FocusCallPresence,
+		Content: event.Content{Parsed: PresenceEventContent{Participants: participants}},
+	}
+}
+
+// Helper that sends the current list of participants to all participants.
+func (c *Conference) broadcastPresence() {
+	presenceEvent := newPresenceEvent(c.tracker)
+	c.tracker.ForEachParticipant(func(id participant.ID, participant *participant.Participant) {
+		if err := participant.SendOverDataChannel(presenceEvent); err != nil {
+			c.logger.WithError(err).Debugf("Failed to send presence to %s", id)
+		}
+	})
+}
@@ -0,0 +1,230 @@
+package conference //nolint:testpackage
+
+import (
+	"context"
+	"encoding/json"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/matrix-org/waterfall/pkg/channel"
+	"github.com/matrix-org/waterfall/pkg/conference/participant"
+	"github.com/matrix-org/waterfall/pkg/conference/track"
+	"github.com/matrix-org/waterfall/pkg/peer"
+	"github.com/matrix-org/waterfall/pkg/telemetry"
+	"github.com/matrix-org/waterfall/pkg/webrtc_ext"
+	"github.com/pion/webrtc/v3"
+	"github.com/sirupsen/logrus"
+)
+
+func TestPresenceAfterJoin(t *testing.T) {
+	tracker, _ := participant.NewParticipantTracker(make(chan struct{}), track.Config{})
+
+	alice := participant.ID{UserID: "@alice:example.org", DeviceID: "ALICE", CallID: "secret"}
+	bob := participant.ID{UserID: "@bob:example.org", DeviceID: "BOB", CallID: "secret"}
+
+	tracker.AddParticipant(&participant.Participant{ID: bob})
+	tracker.AddParticipant(&participant.Participant{ID: alice})
+
+	presence := newPresenceEvent(tracker)
+	if presence.Type != FocusCallPresence {
+		t.Fatalf("Expected %s, got %s", FocusCallPresence.Type, presence.Type.Type)
+	}
+
+	raw, err := json.Marshal(presence.Content.Parsed)
+	if err != nil {
+		t.Fatalf("Failed to marshal presence: %v", err)
+	}
+
+	// Call IDs must never be exposed to other participants.
+	var content map[string][]map[string]interface{}
+	if err := json.Unmarshal(raw, &content); err != nil {
+		t.Fatalf("Failed to unmarshal presence: %v", err)
+	}
+
+	// Bob joined first.
+	expected := []map[string]interface{}{
+		{"user_id": "@alice:example.org", "device_id": "ALICE", "join_index": 1.0},
+		{"user_id": "@bob:example.org", "device_id": "BOB", "join_index": 0.0},
+	}
+
+	if !reflect.DeepEqual(content["participants"], expected) {
+		t.Errorf("Expected %v, got %v", expected, content["participants"])
+	}
+}
+
+func TestJoinIndicesAreStable(t *testing.T) {
+	tracker, _ := participant.NewParticipantTracker(make(chan struct{}), track.Config{})
+	logger := logrus.NewEntry(logrus.New())
+
+	join := func(id participant.ID) {
+		tracker.AddParticipant(&participant.Participant{
+			ID:        id,
+			Peer:      newSubscriberPeer(t, id),
+			Logger:    logger,
+			Telemetry: telemetry.NewTelemetry(context.Background(), "Participant"),
+		})
+	}
+
+	alice := participant.ID{UserID: "@alice:example.org", DeviceID: "ALICE", CallID: "call"}
+	bob := participant.ID{UserID: "@bob:example.org", DeviceID: "BOB", CallID: "call"}
+	carol := participant.ID{UserID: "@carol:example.org", DeviceID: "CAROL", CallID: "call"}
+	dave := participant.ID{UserID: "@dave:example.org", DeviceID: "DAVE", CallID: "call"}
+
+	for _, id := range []participant.ID{alice, bob, carol} {
+		join(id)
+	}
+
+	expectIndex := func(id participant.ID, expected int) {
+		t.Helper()
+		if index := tracker.JoinIndex(id); index != expected {
+			t.Errorf("Expected %s to have index %d, got %d", id, expected, index)
+		}
+	}
+
+	expectIndex(alice, 0)
+	expectIndex(bob, 1)
+	expectIndex(carol, 2)
+
+	// Others keep their indices when someone leaves.
+	tracker.RemoveParticipant(bob)
+	expectIndex(alice, 0)
+	expectIndex(carol, 2)
+
+	// A newcomer does not take the place of the one that left.
+	join(dave)
+	expectIndex(dave, 3)
+
+	// A participant that reconnects with a new call keeps its index.
+	join(participant.ID{UserID: alice.UserID, DeviceID: alice.DeviceID, CallID: "reconnected"})
+	expectIndex(alice, 0)
+
+	// So does the one that rejoins with the same device after leaving.
+	join(bob)
+	expectIndex(bob, 1)
+
+	// Participants that never joined don't have any index.
+	expectIndex(participant.ID{UserID: "@eve:example.org", DeviceID: "EVE"}, -1)
+}
+
+// Creates a participant whose data channel is connected to a remote peer connection. Returns the participant
+// along with the messages that the remote peer receives over the data channel.
+func newConnectedParticipant(t *testing.T, id participant.ID) (*participant.Participant, <-chan string) {
+	t.Helper()
+
+	remote, err := webrtc.NewPeerConnection(webrtc.Configuration{})
+	if err != nil {
+		t.Fatalf("Failed to create remote peer connection: %v", err)
+	}
+	t.Cleanup(func() { remote.Close() })
+
+	dataChannel, err := remote.CreateDataChannel("data", nil)
+	if err != nil {
+		t.Fatalf("Failed to create data channel: %v", err)
+	}
+
+	received := make(chan string, 100)
+	dataChannel.OnMessage(func(msg webrtc.DataChannelMessage) { received <- string(msg.Data) })
+
+	offer, err := remote.CreateOffer(nil)
+	if err != nil {
+		t.Fatalf("Failed to create offer: %v", err)
+	}
+	gathered := webrtc.GatheringCompletePromise(remote)
+	if err := remote.SetLocalDescription(offer); err != nil {
+		t.Fatalf("Failed to set local description: %v", err)
+	}
+	<-gathered
+
+	factory, err := webrtc_ext.NewPeerConnectionFactory(webrtc_ext.Config{})
+	if err != nil {
+		t.Fatalf("Failed to create peer connection factory: %v", err)
+	}
+
+	messages := make(chan channel.Message[participant.ID, peer.MessageContent], 100)
+	sfuPeer, answer, err := peer.NewPeer(
+		factory,
+		remote.LocalDescription().SDP,
+		channel.NewSink(id, messages),
+		peer.Config{DisableTrickleICE: true},
+		logrus.NewEntry(logrus.New()),
+	)
+	if err != nil {
+		t.Fatalf("Failed to create peer: %v", err)
+	}
+	t.Cleanup(sfuPeer.Terminate)
+
+	if err := remote.SetRemoteDescription(*answer); err != nil {
+		t.Fatalf("Failed to set remote description: %v", err)
+	}
+
+	// Wait until the data channel can be used by the SFU.
+	for available := false; !available; {
+		select {
+		case msg := <-messages:
+			_, available = msg.Content.(peer.DataChannelAvailable)
+		case <-time.After(10 * time.Second):
+			t.Fatal("Expected the data channel to open")
+		}
+	}
+
+	return &participant.Participant{
+		ID:        id,
+		Peer:      sfuPeer,
+		Logger:    logrus.NewEntry(logrus.New()),
+		Telemetry: telemetry.NewTelemetry(context.Background(), "Participant"),
+	}, received
+}
+
+func TestJoinBroadcastsPresence(t *testing.T) {
+	conference := newTestConference(t)
+	tracker := conference.tracker
+
+	alice := participant.ID{UserID: "@alice:example.org", DeviceID: "ALICE", CallID: "call"}
+	bob := participant.ID{UserID: "@bob:example.org", DeviceID: "BOB", CallID: "call"}
+	carol := participant.ID{UserID: "@carol:example.org", DeviceID: "CAROL", CallID: "call"}
+
+	received := map[participant.ID]<-chan string{}
+	for _, id := range []participant.ID{alice, bob} {
+		p, messages := newConnectedParticipant(t, id)
+		tracker.AddParticipant(p)
+		received[id] = messages
+	}
+
+	// Carol's data channel is not open yet, which must not prevent the others from being informed.
+	tracker.AddParticipant(&participant.Participant{
+		ID:        carol,
+		Peer:      newSubscriberPeer(t, carol),
+		Logger:    logrus.NewEntry(logrus.New()),
+		Telemetry: telemetry.NewTelemetry(context.Background(), "Participant"),
+	})
+	conference.processJoinedTheCallMessage(carol, peer.JoinedTheCall{})
+
+	for id, messages := range received {
+		var presence struct {
+			Type    string               `json:"type"`
+			Content PresenceEventContent `json:"content"`
+		}
+
+		select {
+		case msg := <-messages:
+			if err := json.Unmarshal([]byte(msg), &presence); err != nil {
+				t.Fatalf("Failed to unmarshal the message of %s: %v", id.UserID, err)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("Expected %s to be informed about the join", id.UserID)
+		}
+
+		if presence.Type != FocusCallPresence.Type {
+			t.Errorf("Expected %s to get %s, got %s", id.UserID, FocusCallPresence.Type, presence.Type)
+		}
+
+		joined := false
+		for _, p := range presence.Content.Participants {
+			joined = joined || p.UserID == carol.UserID
+		}
+		if !joined || len(presence.Content.Participants) != 3 {
+			t.Errorf("Expected %s to see all 3 participants, got %+v", id.UserID, presence.Content.Participants)
+		}
+	}
+}
@@ -1,9 +1,12 @@
 package conference
 
 import (
+	"time"
+
 	"github.com/matrix-org/waterfall/pkg/channel"
 	"github.com/matrix-org/waterfall/pkg/conference/participant"
 	"github.com/matrix-org/waterfall/pkg/peer"
+	"github.com/matrix-org/waterfall/pkg/webhook"
 	"maunium.net/go/mautrix/event"
 )
 
@@ -13,21 +16,68 @@ import (
 func (c *Conference) processMessages(signalDone chan struct{}) {
 	// When the main loop of the conference ends, clean up the resources.
 	defer close(signalDone)
+	defer c.notify(webhook.ConferenceEnded, nil)
 	defer c.matrixWorker.stop()
 	defer c.telemetry.End()
+	defer c.peerMessages.Close()
+
+	// Periodically check for idle participants. The idle timeout may be enabled by a config reload.
+	idleCheck := time.NewTicker(idleCheckInterval)
+	defer idleCheck.Stop()
+
+	// Periodically apply the offers that have been deferred since they came too often. The minimum
+	// negotiation interval may be enabled by a config reload.
+	negotiationCheck := time.NewTicker(negotiationCheckInterval)
+	defer negotiationCheck.Stop()
+
+	// Periodically review the outgoing bandwidth. The budget may be enabled by a config reload.
+	bandwidthCheck := newThrottledTicker(bandwidthCheckInterval)
+	defer bandwidthCheck.Stop()
+
+	// Periodically inform the participants about the quality of their connection.
+	qualityUpdate := newThrottledTicker(connectionQualityInterval)
+	defer qualityUpdate.Stop()
+
+	// Periodically select the speakers whose audio is forwarded. The threshold may be enabled by a config reload.
+	speakerSelection := time.NewTicker(speakerSelectionInterval)
+	defer speakerSelection.Stop()
+
+	// The conference ends once it stays empty for the configured grace period.
+	var gracePeriod emptyGracePeriod
+	defer gracePeriod.stop()
 
 	for {
 		select {
-		case msg := <-c.peerMessages:
+		case msg := <-c.peerMessages.Messages():
 			c.processPeerMessage(msg)
 		case msg := <-c.matrixEvents:
 			c.processMatrixMessage(msg)
 		case msg := <-c.publishedTrackStopped:
 			c.processPublishedTrackFailedMessage(msg.OwnerID, msg.TrackID)
+		case now := <-idleCheck.C:
+			c.evictIdleParticipants(now)
+		case now := <-negotiationCheck.C:
+			c.applyDeferredOffers(now)
+		case <-bandwidthCheck.C:
+			c.enforceBandwidthBudget()
+		case <-speakerSelection.C:
+			c.selectSpeakers()
+		case <-qualityUpdate.C:
+			c.sendConnectionQuality()
+			c.recordStalledLayers()
+		case <-gracePeriod.expired():
+			c.logger.Info("No participants rejoined, stopping the conference")
+			return
 		}
 
-		// If there are no more participants, stop the conference.
-		if !c.tracker.HasParticipants() {
+		// While all tracks are muted, nothing is forwarded, so there is not much to check (if enabled).
+		muted := enabled(c.profile.ThrottleWhenMuted) && c.tracker.AllTracksMuted()
+		bandwidthCheck.throttle(muted)
+		qualityUpdate.throttle(muted)
+
+		// If there are no more participants, stop the conference (possibly after a grace period).
+		empty := !c.tracker.HasParticipants()
+		if gracePeriod.update(empty, time.Duration(c.profile.EmptyGracePeriod)*time.Second) {
 			c.logger.Info("No more participants, stopping the conference")
 			return
 		}
@@ -51,10 +101,14 @@ func (c *Conference) processPeerMessage(message channel.Message[participant.ID,
 		c.processICEGatheringCompleteMessage(message.Sender, msg)
 	case peer.RenegotiationRequired:
 		c.processRenegotiationRequiredMessage(message.Sender, msg)
+	case peer.RenegotiationFailed:
+		c.processRenegotiationFailedMessage(message.Sender, msg)
 	case peer.DataChannelMessage:
 		c.processDataChannelMessage(message.Sender, msg)
 	case peer.DataChannelAvailable:
 		c.processDataChannelAvailableMessage(message.Sender, msg)
+	case peer.DataChannelClosed:
+		c.processDataChannelClosedMessage(message.Sender, msg)
 	default:
 		c.logger.Errorf("Unknown message type: %T", msg)
 	}
@@ -68,8 +122,26 @@ func (c *Conference) processMatrixMessage(msg MatrixMessage) {
 		c.onCandidates(msg.Sender, ev)
 	case *event.CallSelectAnswerEventContent:
 		c.onSelectAnswer(msg.Sender, ev)
+	case AnswerRequested:
+		c.onAnswerRequested(msg.Sender)
 	case *event.CallHangupEventContent:
 		c.onHangup(msg.Sender, ev)
+	case ConfigUpdated:
+		c.onConfigUpdated(ev.Config)
+	case CaptureRequested:
+		c.onCaptureRequested(ev)
+	case KeyFramesRequested:
+		c.onKeyFramesRequested(ev, time.Now())
+	case BitratesRequested:
+		c.onBitratesRequested(ev)
+	case MuteRequested:
+		c.onMuteRequested(ev)
+	case EventLogRequested:
+		c.onEventLogRequested(ev)
+	case CandidatePairsRequested:
+		c.onCandidatePairsRequested(ev)
+	case SSRCMappingsRequested:
+		c.onSSRCMappingsRequested(ev)
 	default:
 		c.logger.Errorf("Unexpected event type: %T", ev)
 	}
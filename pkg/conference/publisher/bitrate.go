@@ -0,0 +1,58 @@
+package publisher
+
+import (
+	"sync"
+	"time"
+)
+
+// The window over which the incoming bitrate is averaged.
+const bitrateWindow = 2 * time.Second
+
+// Estimates the bitrate of the incoming packets over a sliding window.
+type bitrateEstimator struct {
+	window time.Duration
+
+	mutex   sync.Mutex
+	samples []bitrateSample
+	// Sum of the sizes of all samples (in bytes).
+	total int
+}
+
+type bitrateSample struct {
+	arrived time.Time
+	size    int
+}
+
+func newBitrateEstimator(window time.Duration) *bitrateEstimator {
+	return &bitrateEstimator{window: window}
+}
+
+// Accounts a packet of a given size (in bytes) that has arrived at a given time.
+func (e *bitrateEstimator) add(size int, now time.Time) {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+
+	e.samples = append(e.samples, bitrateSample{now, size})
+	e.total += size
+	e.expire(now)
+}
+
+// Returns the estimated bitrate (in bits per second) at a given time.
+func (e *bitrateEstimator) bitrate(now time.Time) uint64 {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+
+	e.expire(now)
+	return uint64(float64(e.total*8) / e.window.Seconds())
+}
+
+// Drops the samples that are not within the window anymore.
+func (e *bitrateEstimator) expire(now time.Time) {
+	expired := 0
+	for expired < len(e.samples) && now.Sub(e.samples[expired].arrived) >= e.window {
+		e.total -= e.samples[expired].size
+		expired++
+	}
+
+	e.samples = e.samples[expired:]
+}
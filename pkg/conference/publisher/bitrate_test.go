@@ -0,0 +1,33 @@
+package publisher //nolint:testpackage
+
+import (
+	"testing"
+	"time"
+)
+
+func TestBitrateEstimator(t *testing.T) {
+	estimator := newBitrateEstimator(2 * time.Second)
+	start := time.Now()
+
+	// 1200-byte packets at 100 packets per second, i.e. 960 kbps.
+	const expected = 1200 * 8 * 100
+	now := start
+	for i := 0; i < 500; i++ {
+		now = start.Add(time.Duration(i) * 10 * time.Millisecond)
+		estimator.add(1200, now)
+	}
+
+	if bitrate := estimator.bitrate(now); bitrate < expected*95/100 || bitrate > expected*105/100 {
+		t.Errorf("Expected a bitrate of about %d bps, got %d", expected, bitrate)
+	}
+
+	// Half of the window without any packets halves the estimate.
+	if bitrate := estimator.bitrate(now.Add(time.Second)); bitrate < expected*45/100 || bitrate > expected*55/100 {
+		t.Errorf("Expected a bitrate of about %d bps, got %d", expected/2, bitrate)
+	}
+
+	// Once the whole window passes without any packets, the bitrate drops to zero.
+	if bitrate := estimator.bitrate(now.Add(2 * time.Second)); bitrate != 0 {
+		t.Errorf("Expected no bitrate, got %d", bitrate)
+	}
+}
@@ -0,0 +1,116 @@
+package publisher
+
+import (
+	"math"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// Validation of the RTP timestamps of the publishers against the clock rate of their codec. The encoders
+// that advance the timestamps faster or slower than they declare make the subscribers drift out of sync.
+// This is meant for diagnostics only, it's a no-op unless explicitly configured.
+type ClockRateValidation struct {
+	// Whether to warn about the publishers whose timestamps don't match the clock rate.
+	Enabled bool `yaml:"enabled"`
+	// How much the observed clock rate may deviate from the declared one (as a fraction, 0.1 if not set).
+	Tolerance float64 `yaml:"tolerance"`
+}
+
+// Enough for the few percent of a broken encoder to stand out of the jitter of the arrival times.
+const defaultClockRateTolerance = 0.1
+
+// The time over which the clock rate is measured, long enough for the arrival jitter not to matter.
+const clockRateWindow = 5 * time.Second
+
+func (c ClockRateValidation) tolerance() float64 {
+	if c.Tolerance <= 0 {
+		return defaultClockRateTolerance
+	}
+
+	return c.Tolerance
+}
+
+// Measures how fast the timestamps of the packets advance compared to the time of their arrival.
+type clockRateValidator struct {
+	// The clock rate of the codec that the publisher has declared.
+	declared  uint32
+	tolerance float64
+	// The arrival time and the timestamp of the first packet of the current measurement (if `started`).
+	start          time.Time
+	startTimestamp uint32
+	started        bool
+	// Whether the last measurement did not match the declared clock rate.
+	mismatch bool
+	// Called with the observed clock rate once it stops matching the declared one.
+	onMismatch func(observed uint32)
+	logger     *logrus.Entry
+}
+
+// Creates a validator or returns `nil` if the validation is disabled or the clock rate is not known.
+func newClockRateValidator(
+	declared uint32,
+	config ClockRateValidation,
+	onMismatch func(observed uint32),
+	logger *logrus.Entry,
+) *clockRateValidator {
+	if !config.Enabled || declared == 0 {
+		return nil
+	}
+
+	return &clockRateValidator{
+		declared:   declared,
+		tolerance:  config.tolerance(),
+		onMismatch: onMismatch,
+		logger:     logger,
+	}
+}
+
+// Accounts a packet with a given timestamp that has arrived at a given time. Warns once the observed
+// clock rate stops matching the declared one, informs once it matches again.
+func (v *clockRateValidator) validate(timestamp uint32, now time.Time) {
+	if v == nil {
+		return
+	}
+
+	if !v.started {
+		v.start, v.startTimestamp, v.started = now, timestamp, true
+		return
+	}
+
+	elapsed := now.Sub(v.start)
+	if elapsed < clockRateWindow {
+		return
+	}
+
+	advanced := int32(timestamp - v.startTimestamp)
+	v.start, v.startTimestamp = now, timestamp
+
+	// The timestamps have jumped back (e.g. the encoder has been restarted), nothing to measure.
+	if advanced <= 0 {
+		return
+	}
+
+	observed := uint32(float64(advanced) / elapsed.Seconds())
+	mismatch := math.Abs(float64(observed)/float64(v.declared)-1) > v.tolerance
+
+	switch {
+	case mismatch && !v.mismatch:
+		v.logger.WithField("declared", v.declared).WithField("observed", observed).
+			Warn("RTP timestamps don't match the clock rate of the codec")
+		if v.onMismatch != nil {
+			v.onMismatch(observed)
+		}
+	case !mismatch && v.mismatch:
+		v.logger.WithField("observed", observed).Info("RTP timestamps match the clock rate of the codec again")
+	}
+
+	v.mismatch = mismatch
+}
+
+// Starts the measurement anew, e.g. once the packets come from another track.
+func (v *clockRateValidator) reset() {
+	if v != nil {
+		v.started = false
+	}
+}
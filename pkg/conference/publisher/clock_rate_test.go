@@ -0,0 +1,57 @@
+package publisher //nolint:testpackage
+
+import (
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/sirupsen/logrus/hooks/test"
+)
+
+func TestClockRateValidator(t *testing.T) {
+	logger, hook := test.NewNullLogger()
+	mismatches := []uint32{}
+	validator := newClockRateValidator(90000, ClockRateValidation{Enabled: true}, func(observed uint32) {
+		mismatches = append(mismatches, observed)
+	}, logrus.NewEntry(logger))
+
+	now := time.Now()
+	timestamp := uint32(0xffff0000)
+	// Sends 30 frames per second for a given time, with the timestamps advancing by a given amount per frame.
+	send := func(duration time.Duration, increment uint32) {
+		for end := now.Add(duration); now.Before(end); now = now.Add(time.Second / 30) {
+			validator.validate(timestamp, now)
+			timestamp += increment
+		}
+	}
+
+	// The timestamps that match the declared clock rate are fine (across the wraparound too).
+	send(11*time.Second, 90000/30)
+	if len(hook.AllEntries()) != 0 || len(mismatches) != 0 {
+		t.Fatalf("Expected no warnings for the matching clock rate, got %v", hook.AllEntries())
+	}
+
+	// The encoder advances the timestamps at half the declared clock rate.
+	send(11*time.Second, 45000/30)
+	if entry := hook.LastEntry(); entry == nil || entry.Level != logrus.WarnLevel {
+		t.Fatalf("Expected a warning about the mismatching clock rate, got %v", entry)
+	}
+	// The first measurement after the change may still include some of the matching timestamps.
+	if len(mismatches) != 1 || mismatches[0] < 44000 || mismatches[0] > 81000 {
+		t.Errorf("Expected a single mismatch between 45 and 81 kHz, got %v", mismatches)
+	}
+	if len(hook.AllEntries()) != 1 {
+		t.Errorf("Expected to be warned once, got %d log entries", len(hook.AllEntries()))
+	}
+
+	// The validator informs once the clock rate matches again.
+	send(11*time.Second, 90000/30)
+	if entry := hook.LastEntry(); entry == nil || entry.Level != logrus.InfoLevel || len(hook.AllEntries()) != 2 {
+		t.Errorf("Expected to be informed about the matching clock rate, got %v", hook.AllEntries())
+	}
+
+	// The validation is disabled unless configured.
+	if newClockRateValidator(90000, ClockRateValidation{}, nil, logrus.NewEntry(logger)) != nil {
+		t.Error("Expected no validator if the validation is disabled")
+	}
+}
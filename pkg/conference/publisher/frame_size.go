@@ -0,0 +1,68 @@
+package publisher
+
+import (
+	"strings"
+
+	"github.com/pion/rtp"
+	"github.com/pion/rtp/codecs"
+	"github.com/pion/webrtc/v3"
+)
+
+// Detects the resolution of the video from the key frames of the publisher. Only VP8 is supported: its
+// key frames start with the dimensions, other codecs would need their bitstream to be parsed.
+type frameSizeDetector struct {
+	width, height int
+}
+
+// Creates a detector or returns `nil` if the resolution can't be detected for a given codec.
+func newFrameSizeDetector(mimeType string) *frameSizeDetector {
+	if !strings.EqualFold(mimeType, webrtc.MimeTypeVP8) {
+		return nil
+	}
+
+	return &frameSizeDetector{}
+}
+
+// Remembers the resolution if the packet starts a key frame.
+func (d *frameSizeDetector) detect(packet *rtp.Packet) {
+	if d == nil {
+		return
+	}
+
+	if width, height, ok := vp8KeyFrameSize(packet.Payload); ok {
+		d.width, d.height = width, height
+	}
+}
+
+// Returns the last detected resolution, zeros if no key frame has arrived yet.
+func (d *frameSizeDetector) size() (int, int) {
+	if d == nil {
+		return 0, 0
+	}
+
+	return d.width, d.height
+}
+
+// Parses the resolution out of the first packet of a VP8 key frame (see RFC 6386, section 9.1).
+func vp8KeyFrameSize(payload []byte) (int, int, bool) {
+	vp8Packet := codecs.VP8Packet{}
+	if _, err := vp8Packet.Unmarshal(payload); err != nil {
+		return 0, 0, false
+	}
+
+	// The 3 bytes of the frame tag (the lowest bit is 0 for the key frames), the start code and the dimensions.
+	header := vp8Packet.Payload
+	if vp8Packet.S != 1 || vp8Packet.PID != 0 || len(header) < 10 || header[0]&0x01 != 0 {
+		return 0, 0, false
+	}
+
+	if header[3] != 0x9d || header[4] != 0x01 || header[5] != 0x2a {
+		return 0, 0, false
+	}
+
+	// The upper 2 bits of the dimensions are the scaling that the decoder may apply, not the resolution.
+	width := int(header[6]) | int(header[7]&0x3f)<<8
+	height := int(header[8]) | int(header[9]&0x3f)<<8
+
+	return width, height, true
+}
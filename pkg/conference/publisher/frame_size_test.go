@@ -0,0 +1,78 @@
+package publisher //nolint:testpackage
+
+import (
+	"testing"
+
+	"github.com/pion/rtp"
+	"github.com/pion/webrtc/v3"
+)
+
+// Returns the payload of the first packet of a VP8 frame of a given resolution.
+func vp8Frame(keyFrame bool, width, height int) []byte {
+	frameTag := byte(0x50)
+	if !keyFrame {
+		frameTag |= 0x01
+	}
+
+	return []byte{
+		0x10, // The payload descriptor: the start of the partition 0.
+		frameTag, 0x2a, 0x00,
+		0x9d, 0x01, 0x2a,
+		byte(width), byte(width >> 8),
+		byte(height), byte(height >> 8),
+	}
+}
+
+func TestVP8KeyFrameSize(t *testing.T) {
+	wrongStartCode := vp8Frame(true, 1280, 720)
+	wrongStartCode[4] = 0x00
+
+	cases := []struct {
+		name          string
+		payload       []byte
+		width, height int
+		ok            bool
+	}{
+		{"key frame", vp8Frame(true, 1280, 720), 1280, 720, true},
+		{"scaling bits", vp8Frame(true, 640|0x4000, 360|0x8000), 640, 360, true},
+		{"inter frame", vp8Frame(false, 1280, 720), 0, 0, false},
+		{"continuation", append([]byte{0x00}, vp8Frame(true, 1280, 720)[1:]...), 0, 0, false},
+		{"wrong start code", wrongStartCode, 0, 0, false},
+		{"truncated", vp8Frame(true, 1280, 720)[:8], 0, 0, false},
+		{"empty", []byte{}, 0, 0, false},
+	}
+
+	for _, c := range cases {
+		width, height, ok := vp8KeyFrameSize(c.payload)
+		if width != c.width || height != c.height || ok != c.ok {
+			t.Errorf("%s: expected %dx%d (%v), got %dx%d (%v)", c.name, c.width, c.height, c.ok, width, height, ok)
+		}
+	}
+}
+
+func TestFrameSizeDetector(t *testing.T) {
+	detector := newFrameSizeDetector("video/vp8")
+
+	detector.detect(&rtp.Packet{Payload: vp8Frame(true, 640, 360)})
+	detector.detect(&rtp.Packet{Payload: vp8Frame(false, 1280, 720)})
+	if width, height := detector.size(); width != 640 || height != 360 {
+		t.Errorf("Expected the resolution of the key frame, got %dx%d", width, height)
+	}
+
+	// The encoder changes the resolution with a new key frame.
+	detector.detect(&rtp.Packet{Payload: vp8Frame(true, 320, 180)})
+	if width, height := detector.size(); width != 320 || height != 180 {
+		t.Errorf("Expected the resolution of the latest key frame, got %dx%d", width, height)
+	}
+
+	// The other codecs are not inspected at all.
+	if newFrameSizeDetector(webrtc.MimeTypeH264) != nil {
+		t.Error("Expected no detector for H.264")
+	}
+
+	var unsupported *frameSizeDetector
+	unsupported.detect(&rtp.Packet{Payload: vp8Frame(true, 640, 360)})
+	if width, height := unsupported.size(); width != 0 || height != 0 {
+		t.Errorf("Expected an unknown resolution, got %dx%d", width, height)
+	}
+}
@@ -0,0 +1,167 @@
+package publisher
+
+import (
+	"fmt"
+	"math/rand"
+	"time"
+
+	"github.com/pion/rtp"
+)
+
+// Simulated network impairment applied to the packets that the publisher reads from the track.
+// This is meant for load and chaos testing only, it's a no-op unless explicitly configured.
+type Impairment struct {
+	// Fraction of packets to drop (0.0 - 1.0).
+	DropRate float64 `yaml:"dropRate"`
+	// Fraction of packets to reorder (swapped with the next packet, 0.0 - 1.0).
+	ReorderRate float64 `yaml:"reorderRate"`
+	// Delay added to each packet (in milliseconds).
+	Delay int `yaml:"delay"`
+	// Seed for the random number generator, so that the impairment is deterministic.
+	Seed int64 `yaml:"seed"`
+}
+
+// Whether any impairment is configured.
+func (i Impairment) Enabled() bool {
+	return i.DropRate > 0 || i.ReorderRate > 0 || i.Delay > 0
+}
+
+// Checks that the rates are fractions and that the delay is not negative.
+func (i Impairment) Validate() error {
+	if i.DropRate < 0 || i.DropRate > 1 {
+		return fmt.Errorf("dropRate must be between 0 and 1, got %v", i.DropRate)
+	}
+	if i.ReorderRate < 0 || i.ReorderRate > 1 {
+		return fmt.Errorf("reorderRate must be between 0 and 1, got %v", i.ReorderRate)
+	}
+	if i.Delay < 0 {
+		return fmt.Errorf("delay must not be negative, got %d", i.Delay)
+	}
+
+	return nil
+}
+
+// How many packets may wait for their delay to pass, the ones beyond are dropped as if the network was congested.
+const impairmentQueueSize = 4096
+
+// A packet that is delivered once its delay has passed.
+type delayedPackets struct {
+	packets []*rtp.Packet
+	due     time.Time
+}
+
+// The state of the impairment for a single publisher.
+type Impairer struct {
+	config Impairment
+	random *rand.Rand
+	// Gets the packets that survived the impairment.
+	deliver func(packets []*rtp.Packet)
+	// A packet that is held back to be sent after the next one.
+	held *rtp.Packet
+	// The packets that wait for their delay to pass, in the order of their arrival (`nil` without a delay).
+	delayed chan delayedPackets
+	// Closed once the impairer is stopped, the delayed packets are dropped then.
+	stopped chan struct{}
+}
+
+// Creates an impairer that passes the packets that survive the impairment to `deliver`. Without a delay,
+// they're delivered right away, otherwise they're delivered by a separate goroutine once their delay has
+// passed, so that the reading of the track is never slowed down. The impairer must be stopped with `Stop()`.
+func NewImpairer(config Impairment, deliver func(packets []*rtp.Packet)) *Impairer {
+	impairer := &Impairer{
+		config:  config,
+		random:  rand.New(rand.NewSource(config.Seed)), //nolint:gosec
+		deliver: deliver,
+		stopped: make(chan struct{}),
+	}
+
+	if config.Delay > 0 {
+		impairer.delayed = make(chan delayedPackets, impairmentQueueSize)
+		go impairer.deliverDelayed()
+	}
+
+	return impairer
+}
+
+// Applies the impairment to a packet and delivers the packets that survive it (now or later).
+func (i *Impairer) Process(packet *rtp.Packet) {
+	if !i.config.Enabled() {
+		i.deliver([]*rtp.Packet{packet})
+		return
+	}
+
+	packets := i.impair(packet)
+	if len(packets) == 0 {
+		return
+	}
+
+	if i.delayed == nil {
+		i.deliver(packets)
+		return
+	}
+
+	// All packets are delayed by the same amount, so they're due in the order of their arrival.
+	select {
+	case i.delayed <- delayedPackets{packets, time.Now().Add(time.Duration(i.config.Delay) * time.Millisecond)}:
+	default:
+	}
+}
+
+// Stops the delivery of the delayed packets.
+func (i *Impairer) Stop() {
+	select {
+	case <-i.stopped:
+	default:
+		close(i.stopped)
+	}
+}
+
+// Drops or reorders a packet. Returns the packets that must be forwarded (if any).
+func (i *Impairer) impair(packet *rtp.Packet) []*rtp.Packet {
+	if i.random.Float64() < i.config.DropRate {
+		return nil
+	}
+
+	// If a packet was held back, send it after the current one.
+	if held := i.held; held != nil {
+		i.held = nil
+		return []*rtp.Packet{packet, held}
+	}
+
+	if i.random.Float64() < i.config.ReorderRate {
+		i.held = packet
+		return nil
+	}
+
+	return []*rtp.Packet{packet}
+}
+
+// Delivers the delayed packets once they're due until the impairer is stopped.
+func (i *Impairer) deliverDelayed() {
+	timer := time.NewTimer(0)
+	defer timer.Stop()
+
+	for {
+		var next delayedPackets
+		select {
+		case next = <-i.delayed:
+		case <-i.stopped:
+			return
+		}
+
+		if !timer.Stop() {
+			select {
+			case <-timer.C:
+			default:
+			}
+		}
+		timer.Reset(time.Until(next.due))
+
+		select {
+		case <-timer.C:
+			i.deliver(next.packets)
+		case <-i.stopped:
+			return
+		}
+	}
+}
@@ -6,6 +6,7 @@ import (
 	"sync"
 	"time"
 
+	"github.com/matrix-org/waterfall/pkg/worker"
 	"github.com/pion/rtp"
 	"github.com/sirupsen/logrus"
 )
@@ -31,6 +32,12 @@ type Publisher struct {
 	subscriptions map[Subscription]struct{}
 
 	observer *statusObserver
+	impairer *Impairer
+	bitrate  *bitrateEstimator
+	// Validates the timestamps against the clock rate of the codec (nil if disabled).
+	clockRate *clockRateValidator
+	// Detects the resolution of the video from the key frames (nil if not supported for the codec).
+	frameSize *frameSizeDetector
 }
 
 // Starts a new publisher, returns a publisher along with the channel that informs the caller
@@ -40,6 +47,7 @@ func NewPublisher(
 	track Track,
 	stop <-chan struct{},
 	considerStalledAfter time.Duration,
+	impairment Impairment,
 	log *logrus.Entry,
 ) (*Publisher, <-chan Status) {
 	// Start an observer that expects us to inform it every time we receive a packet.
@@ -51,13 +59,15 @@ func NewPublisher(
 		track:         track,
 		subscriptions: make(map[Subscription]struct{}),
 		observer:      observer,
+		bitrate:       newBitrateEstimator(bitrateWindow),
 	}
+	publisher.impairer = NewImpairer(impairment, publisher.deliver)
 
 	// Start a goroutine that will read RTP packets from the remote track.
 	// We run the publisher until we receive a stop signal or an error occurs.
 	go func() {
 		defer observer.stop()
-		reportFrameReceived := func() { observer.packetArrived() }
+		defer publisher.impairer.Stop()
 
 		for {
 			// Check if we were signaled to stop.
@@ -65,7 +75,7 @@ func NewPublisher(
 			case <-stop:
 				return
 			default:
-				if err := publisher.forwardPacket(reportFrameReceived); err != nil {
+				if err := publisher.forwardPacket(); err != nil {
 					logStoppedFn := log.Infof
 					if err != io.EOF {
 						logStoppedFn = log.Errorf
@@ -120,16 +130,44 @@ func (p *Publisher) ReplaceTrack(track Track) {
 	p.mu.Lock()
 	defer p.mu.Unlock()
 	p.track = track
+	// The timestamps of the new track are not related to the ones of the old track.
+	p.clockRate.reset()
+}
+
+// Starts validating the timestamps of the packets against a given clock rate of the codec, see
+// `ClockRateValidation`. The callback is called with the observed clock rate if it does not match.
+func (p *Publisher) ValidateClockRate(declared uint32, config ClockRateValidation, onMismatch func(observed uint32)) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.clockRate = newClockRateValidator(declared, config, onMismatch, p.logger)
+}
+
+// Starts detecting the resolution of the video from the key frames of a given codec (VP8 only), see `FrameSize()`.
+func (p *Publisher) DetectFrameSize(mimeType string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.frameSize = newFrameSizeDetector(mimeType)
+}
+
+// Returns the resolution of the video as of the last key frame, zeros if it's not known (yet).
+func (p *Publisher) FrameSize() (int, int) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.frameSize.size()
 }
 
 func (p *Publisher) IsStalled() bool {
 	return p.observer.stalled.Load()
 }
 
+// Returns the bitrate (in bits per second) at which the packets arrive from the track.
+func (p *Publisher) Bitrate() uint64 {
+	return p.bitrate.bitrate(time.Now())
+}
+
 // Reads a single packet from the remote track and forwards it to all subscribers.
 // The function stops when the remote track is closed or an error occurs when reading.
-// Each time new packet is received, the provided callback is called.
-func (p *Publisher) forwardPacket(reportFrameReceived func()) error {
+func (p *Publisher) forwardPacket() error {
 	track := p.GetTrack()
 
 	packet, err := track.ReadPacket()
@@ -137,18 +175,44 @@ func (p *Publisher) forwardPacket(reportFrameReceived func()) error {
 		return err
 	}
 
-	// Inform the observer that we received a packet.
-	reportFrameReceived()
+	// The ingress is accounted before the simulated impairment, it's what the publisher actually sends.
+	now := time.Now()
+	p.bitrate.add(packet.MarshalSize(), now)
+	p.inspect(packet, now)
+
+	// Apply the simulated network impairment (if any), the packets may be delivered later.
+	p.impairer.Process(packet)
+	return nil
+}
+
+// Forwards the packets that survived the impairment to all subscribers. Each time new packets are
+// delivered, the observer is informed.
+func (p *Publisher) deliver(packets []*rtp.Packet) {
+	p.observer.packetArrived()
 
 	p.mu.Lock()
 	defer p.mu.Unlock()
 
-	// Write the packet to all subscribers.
-	for subscription := range p.subscriptions {
-		if err := subscription.WriteRTP(*packet); err != nil {
-			p.logger.Warnf("failed to forward packet to: %v", err)
+	// Write the packets to all subscribers. A subscriber that fails affects neither the others nor the publisher.
+	for _, packet := range packets {
+		for subscription := range p.subscriptions {
+			err := subscription.WriteRTP(*packet)
+			switch {
+			case errors.Is(err, worker.ErrWorkerClosed):
+				// The subscription has been stopped and won't take any packets anymore.
+				p.logger.Info("Removing the stopped subscription")
+				delete(p.subscriptions, subscription)
+			case err != nil:
+				p.logger.Warnf("failed to forward packet to: %v", err)
+			}
 		}
 	}
+}
 
-	return nil
+// Validates the clock rate and detects the resolution of the video, if enabled.
+func (p *Publisher) inspect(packet *rtp.Packet, now time.Time) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.clockRate.validate(packet.Timestamp, now)
+	p.frameSize.detect(packet)
 }
@@ -0,0 +1,253 @@
+package publisher_test
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"runtime"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/matrix-org/waterfall/pkg/conference/publisher"
+	"github.com/matrix-org/waterfall/pkg/worker"
+	"github.com/pion/rtp"
+	"github.com/sirupsen/logrus"
+)
+
+// A track that returns a given amount of packets and then ends with `io.EOF`.
+type fakeTrack struct {
+	packets int
+}
+
+func (t *fakeTrack) ReadPacket() (*rtp.Packet, error) {
+	if t.packets == 0 {
+		return nil, io.EOF
+	}
+
+	t.packets--
+	return &rtp.Packet{}, nil
+}
+
+func TestPublisherStopsWhenTrackEnds(t *testing.T) {
+	stop := make(chan struct{})
+	defer close(stop)
+
+	_, status := publisher.NewPublisher(
+		&fakeTrack{packets: 10},
+		stop,
+		time.Hour,
+		publisher.Impairment{},
+		logrus.NewEntry(logrus.New()),
+	)
+
+	// Once the track ends, the publisher and its status observer must stop which closes the channel.
+	select {
+	case _, ok := <-status:
+		if ok {
+			t.Fatal("Expected the status channel to be closed")
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Publisher did not stop after the track ended")
+	}
+}
+
+// A track that produces packets until the test is over.
+type endlessTrack struct{}
+
+func (t *endlessTrack) ReadPacket() (*rtp.Packet, error) {
+	time.Sleep(time.Millisecond)
+	return &rtp.Packet{}, nil
+}
+
+// A track that returns the packets sent to it until the publishers are stopped.
+type channelTrack struct {
+	packets chan *rtp.Packet
+	stop    <-chan struct{}
+}
+
+func (t *channelTrack) ReadPacket() (*rtp.Packet, error) {
+	select {
+	case packet := <-t.packets:
+		return packet, nil
+	case <-t.stop:
+		return nil, io.EOF
+	}
+}
+
+// A subscription that counts the forwarded packets.
+type countingSubscription struct {
+	forwarded *atomic.Int64
+}
+
+func (s countingSubscription) WriteRTP(packet rtp.Packet) error {
+	s.forwarded.Add(1)
+	return nil
+}
+
+// Measures the goroutines that each publisher needs and how fast the packets are forwarded
+// when they arrive on many publishers at once, like in a big conference.
+func BenchmarkPublishers(b *testing.B) {
+	logger := logrus.New()
+	logger.SetOutput(io.Discard)
+
+	for _, count := range []int{10, 1000} {
+		b.Run(fmt.Sprintf("%d publishers", count), func(b *testing.B) {
+			stop := make(chan struct{})
+			defer close(stop)
+
+			goroutines := runtime.NumGoroutine()
+			forwarded := &atomic.Int64{}
+			tracks := make([]*channelTrack, count)
+			for i := range tracks {
+				tracks[i] = &channelTrack{packets: make(chan *rtp.Packet, 1), stop: stop}
+				pub, _ := publisher.NewPublisher(tracks[i], stop, time.Hour, publisher.Impairment{}, logrus.NewEntry(logger))
+				pub.AddSubscription(countingSubscription{forwarded})
+			}
+
+			perPublisher := float64(runtime.NumGoroutine()-goroutines) / float64(count)
+			b.ResetTimer()
+
+			for i := 0; i < b.N; i++ {
+				tracks[i%count].packets <- &rtp.Packet{}
+			}
+
+			for forwarded.Load() < int64(b.N) {
+				runtime.Gosched()
+			}
+
+			b.ReportMetric(perPublisher, "goroutines/publisher")
+		})
+	}
+}
+
+func TestPublisherStallsUnderFullDrop(t *testing.T) {
+	stop := make(chan struct{})
+	defer close(stop)
+
+	impairment := publisher.Impairment{DropRate: 1}
+	_, status := publisher.NewPublisher(
+		&endlessTrack{},
+		stop,
+		50*time.Millisecond,
+		impairment,
+		logrus.NewEntry(logrus.New()),
+	)
+
+	select {
+	case s := <-status:
+		if s != publisher.StatusStalled {
+			t.Fatalf("Expected the publisher to stall, got %v", s)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Publisher did not stall while all packets are dropped")
+	}
+}
+
+// A subscription that fails to take any packets with a given error.
+type failingSubscription struct {
+	err      error
+	attempts *atomic.Int64
+}
+
+func (s failingSubscription) WriteRTP(packet rtp.Packet) error {
+	s.attempts.Add(1)
+	return s.err
+}
+
+func TestPublisherSurvivesFailingSubscriptions(t *testing.T) {
+	stop := make(chan struct{})
+	defer close(stop)
+
+	track := &channelTrack{packets: make(chan *rtp.Packet), stop: stop}
+	pub, _ := publisher.NewPublisher(track, stop, time.Hour, publisher.Impairment{}, logrus.NewEntry(logrus.New()))
+
+	forwarded, stoppedAttempts, failingAttempts := &atomic.Int64{}, &atomic.Int64{}, &atomic.Int64{}
+	pub.AddSubscription(countingSubscription{forwarded})
+	pub.AddSubscription(failingSubscription{worker.ErrWorkerClosed, stoppedAttempts})
+	pub.AddSubscription(failingSubscription{errors.New("subscriber failed"), failingAttempts})
+
+	for i := 0; i < 10; i++ {
+		select {
+		case track.packets <- &rtp.Packet{Header: rtp.Header{SequenceNumber: uint16(i)}}:
+		case <-time.After(time.Second):
+			t.Fatal("The publisher stopped reading the track")
+		}
+	}
+
+	for deadline := time.Now().Add(time.Second); forwarded.Load() < 10; time.Sleep(time.Millisecond) {
+		if time.Now().After(deadline) {
+			t.Fatalf("Expected 10 packets to be forwarded, got %d", forwarded.Load())
+		}
+	}
+
+	// The stopped subscription is dropped right away, the one that fails keeps being tried.
+	if attempts := stoppedAttempts.Load(); attempts != 1 {
+		t.Errorf("Expected a single packet to be sent to the stopped subscription, got %d", attempts)
+	}
+	if attempts := failingAttempts.Load(); attempts != 10 {
+		t.Errorf("Expected all packets to be sent to the failing subscription, got %d", attempts)
+	}
+}
+
+func TestDelayDoesNotThrottleTrack(t *testing.T) {
+	const delay = 200 * time.Millisecond
+
+	stop := make(chan struct{})
+	defer close(stop)
+
+	track := &channelTrack{packets: make(chan *rtp.Packet), stop: stop}
+	impairment := publisher.Impairment{Delay: int(delay / time.Millisecond)}
+	pub, _ := publisher.NewPublisher(track, stop, time.Hour, impairment, logrus.NewEntry(logrus.New()))
+
+	forwarded := &atomic.Int64{}
+	pub.AddSubscription(countingSubscription{forwarded})
+
+	// The packets are read as fast as they arrive, the delay must not pile up.
+	start := time.Now()
+	for i := 0; i < 10; i++ {
+		select {
+		case track.packets <- &rtp.Packet{Header: rtp.Header{SequenceNumber: uint16(i)}}:
+		case <-time.After(delay):
+			t.Fatal("The delay slowed down the reading of the track")
+		}
+	}
+
+	if elapsed := time.Since(start); elapsed >= delay {
+		t.Errorf("Expected the packets to be read right away, it took %v", elapsed)
+	}
+	if forwarded.Load() != 0 {
+		t.Errorf("Expected no packets to be forwarded before the delay, got %d", forwarded.Load())
+	}
+
+	// All packets are forwarded after the delay.
+	for deadline := start.Add(5 * delay); forwarded.Load() < 10; time.Sleep(time.Millisecond) {
+		if time.Now().After(deadline) {
+			t.Fatalf("Expected 10 delayed packets to be forwarded, got %d", forwarded.Load())
+		}
+	}
+
+	if elapsed := time.Since(start); elapsed < delay {
+		t.Errorf("Expected the packets to be delayed by %v, got %v", delay, elapsed)
+	}
+}
+
+func TestImpairmentValidation(t *testing.T) {
+	cases := []struct {
+		impairment publisher.Impairment
+		valid      bool
+	}{
+		{publisher.Impairment{}, true},
+		{publisher.Impairment{DropRate: 1, ReorderRate: 0.5, Delay: 100}, true},
+		{publisher.Impairment{DropRate: 1.5}, false},
+		{publisher.Impairment{DropRate: -0.1}, false},
+		{publisher.Impairment{ReorderRate: 2}, false},
+		{publisher.Impairment{Delay: -1}, false},
+	}
+
+	for _, c := range cases {
+		if err := c.impairment.Validate(); (err == nil) != c.valid {
+			t.Errorf("%+v: expected valid to be %v, got %v", c.impairment, c.valid, err)
+		}
+	}
+}
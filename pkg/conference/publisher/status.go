@@ -1,6 +1,7 @@
 package publisher
 
 import (
+	"sync"
 	"sync/atomic"
 	"time"
 
@@ -23,35 +24,54 @@ type statusObserver struct {
 	worker   *worker.Worker[struct{}]
 	statusCh chan Status
 	stalled  atomic.Bool
+	// Guards the status channel, so that the status is never reported after the channel is closed
+	// (the worker may still be handling a task when it's stopped).
+	mutex   sync.Mutex
+	stopped bool
 }
 
 func newStatusObserver(timeout time.Duration) *statusObserver {
-	statusCh := make(chan Status, 1)
-	stalled := atomic.Bool{}
+	// The worker must update the flag of the observer itself (not a copy of it), since the
+	// publisher reads it to tell whether it's stalled.
+	observer := &statusObserver{statusCh: make(chan Status, 1)}
 
-	worker := worker.StartWorker(worker.Config[struct{}]{
+	observer.worker = worker.StartWorker(worker.Config[struct{}]{
 		ChannelSize: 1,
 		Timeout:     timeout,
 		OnTimeout: func() {
-			if stalled.CompareAndSwap(false, true) {
-				statusCh <- StatusStalled
+			if observer.stalled.CompareAndSwap(false, true) {
+				observer.report(StatusStalled)
 			}
 		},
 		OnTask: func(struct{}) {
-			if stalled.CompareAndSwap(true, false) {
-				statusCh <- StatusRecovered
+			if observer.stalled.CompareAndSwap(true, false) {
+				observer.report(StatusRecovered)
 			}
 		},
 	})
 
-	return &statusObserver{worker, statusCh, stalled}
+	return observer
 }
 
 func (o *statusObserver) packetArrived() {
 	o.worker.Send(struct{}{})
 }
 
+func (o *statusObserver) report(status Status) {
+	o.mutex.Lock()
+	defer o.mutex.Unlock()
+
+	if !o.stopped {
+		o.statusCh <- status
+	}
+}
+
 func (o *statusObserver) stop() {
 	o.worker.Stop()
+
+	o.mutex.Lock()
+	defer o.mutex.Unlock()
+
+	o.stopped = true
 	close(o.statusCh)
 }
@@ -1,6 +1,9 @@
 package publisher
 
 import (
+	"strings"
+
+	"github.com/pion/interceptor"
 	"github.com/pion/rtp"
 	"github.com/pion/webrtc/v3"
 )
@@ -13,6 +16,41 @@ type RemoteTrack struct {
 
 // Implement the `Track` interface for the `webrtc.TrackRemote`.
 func (t *RemoteTrack) ReadPacket() (*rtp.Packet, error) {
-	packet, _, err := t.Track.ReadRTP()
-	return packet, err
+	return readMediaPacket(t.Track)
+}
+
+// The part of the `webrtc.TrackRemote` that we read the packets from.
+type rtpReader interface {
+	ReadRTP() (*rtp.Packet, interceptor.Attributes, error)
+	// The codec of the last packet that has been read.
+	Codec() webrtc.RTPCodecParameters
+}
+
+// The codecs of the forward error correction (FEC) that the publishers may send along with the media.
+var fecMimeTypes = []string{"video/ulpfec", "video/flexfec", "video/flexfec-03"}
+
+// Reads the next media packet, the FEC packets are stripped. We can't forward them: they protect the
+// original sequence numbers that the subscriptions rewrite, and the outgoing tracks would send them with
+// the payload type of the media codec. So the subscribers rely on the NACKs and PLIs to recover instead.
+func readMediaPacket(track rtpReader) (*rtp.Packet, error) {
+	for {
+		packet, _, err := track.ReadRTP()
+		if err != nil {
+			return nil, err
+		}
+
+		if !isFEC(track.Codec().MimeType) {
+			return packet, nil
+		}
+	}
+}
+
+func isFEC(mimeType string) bool {
+	for _, fecMimeType := range fecMimeTypes {
+		if strings.EqualFold(mimeType, fecMimeType) {
+			return true
+		}
+	}
+
+	return false
 }
@@ -0,0 +1,61 @@
+package publisher //nolint:testpackage
+
+import (
+	"io"
+	"testing"
+
+	"github.com/pion/interceptor"
+	"github.com/pion/rtp"
+	"github.com/pion/webrtc/v3"
+)
+
+// A track that returns the packets of the given codecs (one packet per codec) and then ends with `io.EOF`.
+type fakeRemoteTrack struct {
+	mimeTypes []string
+	read      int
+}
+
+func (t *fakeRemoteTrack) ReadRTP() (*rtp.Packet, interceptor.Attributes, error) {
+	if t.read == len(t.mimeTypes) {
+		return nil, nil, io.EOF
+	}
+
+	t.read++
+	return &rtp.Packet{Header: rtp.Header{SequenceNumber: uint16(t.read)}}, nil, nil
+}
+
+func (t *fakeRemoteTrack) Codec() webrtc.RTPCodecParameters {
+	return webrtc.RTPCodecParameters{RTPCodecCapability: webrtc.RTPCodecCapability{MimeType: t.mimeTypes[t.read-1]}}
+}
+
+func TestFECIsStripped(t *testing.T) {
+	track := &fakeRemoteTrack{mimeTypes: []string{
+		webrtc.MimeTypeVP8,
+		"video/ulpfec",
+		webrtc.MimeTypeVP8,
+		"video/flexfec-03",
+		"video/ULPFEC",
+		webrtc.MimeTypeVP8,
+	}}
+
+	forwarded := []uint16{}
+	for {
+		packet, err := readMediaPacket(track)
+		if err != nil {
+			break
+		}
+
+		forwarded = append(forwarded, packet.SequenceNumber)
+	}
+
+	expected := []uint16{1, 3, 6}
+	if len(forwarded) != len(expected) {
+		t.Fatalf("Expected packets %v to be forwarded, got %v", expected, forwarded)
+	}
+
+	for i := range expected {
+		if forwarded[i] != expected[i] {
+			t.Errorf("Expected packets %v to be forwarded, got %v", expected, forwarded)
+		}
+	}
+}
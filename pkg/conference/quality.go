@@ -0,0 +1,84 @@
+package conference
+
+import (
+	"time"
+
+	"github.com/matrix-org/waterfall/pkg/conference/participant"
+	"github.com/matrix-org/waterfall/pkg/conference/track"
+	"maunium.net/go/mautrix/event"
+)
+
+// Sent by the SFU over the data channel to tell the participant how well it receives the media.
+var FocusCallConnectionQuality = event.Type{Type: "m.call.connection_quality", Class: event.FocusEventType}
+
+// How often the participants are informed about the quality of their connection.
+const connectionQualityInterval = 5 * time.Second
+
+type ConnectionQuality string
+
+const (
+	ConnectionQualityGood   ConnectionQuality = "good"
+	ConnectionQualityMedium ConnectionQuality = "medium"
+	ConnectionQualityPoor   ConnectionQuality = "poor"
+)
+
+// Thresholds (inclusive) of the worst packet loss and jitter for the connection to be considered medium or poor.
+const (
+	mediumQualityPacketLoss = 0.02
+	mediumQualityJitter     = 30 * time.Millisecond
+	poorQualityPacketLoss   = 0.1
+	poorQualityJitter       = 100 * time.Millisecond
+)
+
+// Content of the `m.call.connection_quality` event.
+type ConnectionQualityEventContent struct {
+	Quality ConnectionQuality `json:"quality"`
+	// The worst fraction (from 0 to 1) of the lost packets among the subscribed tracks.
+	PacketLoss float64 `json:"packet_loss"`
+	// The worst jitter (in milliseconds) among the subscribed tracks.
+	Jitter int64 `json:"jitter"`
+}
+
+// Rates the connection of a participant by the worst reception stats of the tracks it's subscribed to.
+func connectionQuality(stats []track.ReceptionStats) ConnectionQualityEventContent {
+	var worst track.ReceptionStats
+	for _, s := range stats {
+		if s.FractionLost > worst.FractionLost {
+			worst.FractionLost = s.FractionLost
+		}
+		if s.Jitter > worst.Jitter {
+			worst.Jitter = s.Jitter
+		}
+	}
+
+	quality := ConnectionQualityGood
+	switch {
+	case worst.FractionLost >= poorQualityPacketLoss || worst.Jitter >= poorQualityJitter:
+		quality = ConnectionQualityPoor
+	case worst.FractionLost >= mediumQualityPacketLoss || worst.Jitter >= mediumQualityJitter:
+		quality = ConnectionQualityMedium
+	}
+
+	return ConnectionQualityEventContent{
+		Quality:    quality,
+		PacketLoss: worst.FractionLost,
+		Jitter:     worst.Jitter.Milliseconds(),
+	}
+}
+
+// Informs each participant that has reported about its subscriptions about the quality of its connection.
+func (c *Conference) sendConnectionQuality() {
+	c.tracker.ForEachParticipant(func(id participant.ID, p *participant.Participant) {
+		stats := c.tracker.ReceptionStats(id)
+		if len(stats) == 0 {
+			return
+		}
+
+		content := connectionQuality(stats)
+		// The next update supersedes this one, so it's not worth congesting the data channel.
+		p.SendLowPriorityOverDataChannel(event.Event{
+			Type:    FocusCallConnectionQuality,
+			Content: event.Content{Parsed: content},
+		})
+	})
+}
@@ -0,0 +1,34 @@
+package conference //nolint:testpackage
+
+import (
+	"testing"
+	"time"
+
+	"github.com/matrix-org/waterfall/pkg/conference/track"
+)
+
+func TestConnectionQuality(t *testing.T) {
+	cases := []struct {
+		name     string
+		stats    []track.ReceptionStats
+		expected ConnectionQuality
+	}{
+		{"no loss", []track.ReceptionStats{{FractionLost: 0, Jitter: 5 * time.Millisecond}}, ConnectionQualityGood},
+		{"little loss", []track.ReceptionStats{{FractionLost: 0.01}}, ConnectionQualityGood},
+		{"medium loss", []track.ReceptionStats{{FractionLost: 0.05}}, ConnectionQualityMedium},
+		{"medium jitter", []track.ReceptionStats{{Jitter: 50 * time.Millisecond}}, ConnectionQualityMedium},
+		{"high loss", []track.ReceptionStats{{FractionLost: 0.25}}, ConnectionQualityPoor},
+		{"high jitter", []track.ReceptionStats{{Jitter: 150 * time.Millisecond}}, ConnectionQualityPoor},
+		{
+			"the worst track wins",
+			[]track.ReceptionStats{{FractionLost: 0}, {FractionLost: 0.03}, {Jitter: 10 * time.Millisecond}},
+			ConnectionQualityMedium,
+		},
+	}
+
+	for _, c := range cases {
+		if quality := connectionQuality(c.stats).Quality; quality != c.expected {
+			t.Errorf("%s: expected %s, got %s", c.name, c.expected, quality)
+		}
+	}
+}
@@ -0,0 +1,150 @@
+package conference //nolint:testpackage
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/matrix-org/waterfall/pkg/conference/participant"
+	"github.com/matrix-org/waterfall/pkg/peer"
+	"github.com/matrix-org/waterfall/pkg/signaling"
+	"github.com/matrix-org/waterfall/pkg/telemetry"
+	"github.com/pion/webrtc/v3"
+	"github.com/sirupsen/logrus"
+	"maunium.net/go/mautrix/event"
+)
+
+func TestPreviousCallDoesNotAffectReconnectedParticipant(t *testing.T) {
+	signaler := &recordingSignaler{messages: make(chan signaling.MatrixMessage, 10)}
+	conference := newTestConference(
+		t,
+		withSignaler(signaler),
+		withProfile(Profile{DataChannelClosePolicy: DataChannelClosePolicyHangup}),
+	)
+	tracker := conference.tracker
+
+	invite := func(id participant.ID) {
+		remote, err := webrtc.NewPeerConnection(webrtc.Configuration{})
+		if err != nil {
+			t.Fatalf("Failed to create peer connection: %v", err)
+		}
+		t.Cleanup(func() { remote.Close() })
+
+		if _, err := remote.CreateDataChannel("data", nil); err != nil {
+			t.Fatalf("Failed to create data channel: %v", err)
+		}
+		offer, err := remote.CreateOffer(nil)
+		if err != nil {
+			t.Fatalf("Failed to create offer: %v", err)
+		}
+
+		inviteEvent := &event.CallInviteEventContent{Offer: event.CallData{Type: "offer", SDP: offer.SDP}}
+		if err := conference.onNewParticipant(id, inviteEvent); err != nil {
+			t.Fatalf("Failed to process the invite of %s: %v", id.CallID, err)
+		}
+	}
+
+	// Alice reconnects, i.e. sends an invite with a new call ID.
+	previous := participant.ID{UserID: "@alice:example.org", DeviceID: "ALICE", CallID: "previous"}
+	current := participant.ID{UserID: "@alice:example.org", DeviceID: "ALICE", CallID: "current"}
+	invite(previous)
+	invite(current)
+
+	reconnected := tracker.GetParticipant(current)
+	if reconnected == nil || reconnected.ID != current {
+		t.Fatalf("Expected the participant of the current call, got %+v", reconnected)
+	}
+	t.Cleanup(func() {
+		reconnected.Heartbeat.Stop()
+		reconnected.Peer.Terminate()
+	})
+
+	// Drop the messages that have been sent so far (the answers and the hangup of the previous call).
+	for drained := false; !drained; {
+		select {
+		case <-signaler.messages:
+		case <-time.After(100 * time.Millisecond):
+			drained = true
+		}
+	}
+
+	// The messages of the previous call that arrive late must not affect the current call.
+	conference.onCandidates(previous, &event.CallCandidatesEventContent{
+		Candidates: []event.CallCandidate{{Candidate: "candidate:1 1 udp 1 192.0.2.1 5000 typ host", SDPMID: "0"}},
+	})
+	conference.onSelectAnswer(previous, &event.CallSelectAnswerEventContent{SelectedPartyID: "OTHER"})
+	conference.onHangup(previous, &event.CallHangupEventContent{Reason: event.CallHangupUserHangup})
+	conference.onAnswerRequested(previous)
+	conference.processJoinedTheCallMessage(previous, peer.JoinedTheCall{CandidatePair: &peer.CandidatePair{}})
+	conference.processDataChannelClosedMessage(previous, peer.DataChannelClosed{Label: "data", Default: true})
+	conference.processLeftTheCallMessage(previous, peer.LeftTheCall{Reason: event.CallHangupKeepAliveTimeout})
+
+	if p := tracker.GetParticipant(current); p != reconnected {
+		t.Fatalf("Expected the participant of the current call to be kept, got %+v", p)
+	}
+
+	if reconnected.CandidatePair != nil {
+		t.Errorf("Expected the candidate pair of the previous call to be ignored, got %+v", reconnected.CandidatePair)
+	}
+
+	select {
+	case msg := <-signaler.messages:
+		t.Errorf("Expected nothing to be sent to the participant, got %+v", msg)
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	// The messages of the current call are still handled.
+	conference.onHangup(current, &event.CallHangupEventContent{Reason: event.CallHangupUserHangup})
+	if tracker.GetParticipant(current) != nil {
+		t.Error("Expected the hangup of the current call to remove the participant")
+	}
+}
+
+func TestForceMuteOutlivesReconnection(t *testing.T) {
+	logger := logrus.NewEntry(logrus.New())
+	tel := telemetry.NewTelemetry(context.Background(), "Participant")
+
+	previous := participant.ID{UserID: "@alice:example.org", DeviceID: "ALICE", CallID: "previous"}
+	current := participant.ID{UserID: "@alice:example.org", DeviceID: "ALICE", CallID: "current"}
+	bob := participant.ID{UserID: "@bob:example.org", DeviceID: "BOB", CallID: "call"}
+
+	conference := newTestConference(t, withStreamsMetadata(event.CallSDPStreamMetadata{
+		"stream": {
+			UserID:   current.UserID,
+			DeviceID: current.DeviceID,
+			Tracks:   event.CallSDPStreamMetadataTracks{"mic": {Kind: "audio"}},
+		},
+	}))
+	tracker := conference.tracker
+
+	join := func(id participant.ID) {
+		tracker.AddParticipant(&participant.Participant{ID: id, Peer: newSubscriberPeer(t, id), Logger: logger, Telemetry: tel})
+	}
+	join(previous)
+	join(bob)
+
+	result := make(chan MuteResult, 1)
+	conference.onMuteRequested(MuteRequested{
+		UserID:   previous.UserID,
+		DeviceID: previous.DeviceID,
+		Muted:    true,
+		Result:   result,
+	})
+	if outcome := <-result; outcome.Err != nil {
+		t.Fatalf("Failed to mute alice: %v", outcome.Err)
+	}
+
+	// Alice reconnects with a new call and publishes her microphone again.
+	tracker.RemoveParticipant(previous)
+	join(current)
+	microphone := publishAudioTracks(t, "stream", "mic")[0]
+	conference.processNewTrackPublishedMessage(current, peer.NewTrackPublished{RemoteTrack: microphone})
+
+	if tracks := tracker.SetForceMuted(current, true); tracks != 0 {
+		t.Errorf("Expected the new microphone to be muted already, %d tracks were not", tracks)
+	}
+
+	if stream := conference.getAvailableStreamsFor(bob).Metadata["stream"]; !stream.AudioMuted {
+		t.Errorf("Expected the others to see alice muted, got %+v", stream)
+	}
+}
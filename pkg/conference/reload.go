@@ -0,0 +1,21 @@
+package conference
+
+// Sent by the router to the running conferences when the configuration has been reloaded.
+type ConfigUpdated struct {
+	Config Config
+}
+
+// Applies the reloaded configuration. The conference reads its config and profile whenever it needs them,
+// so most of the settings (e.g. the access control, the limits, the idle timeout and the policies) take
+// effect right away. The heartbeat and peer settings only apply to the participants that join after the
+// reload and the track settings to the tracks that are published after the reload, since they are fixed
+// once the participant or the track is created. The telemetry sampling and the event log size are kept
+// for the lifetime of the conference.
+func (c *Conference) onConfigUpdated(config Config) {
+	c.logger.Info("Applying reloaded config")
+	c.telemetry.AddEvent("config reloaded")
+
+	c.config = config
+	c.profile = config.Profile(c.profileName)
+	c.tracker.SetTrackConfig(newTrackConfig(config, c.profile))
+}
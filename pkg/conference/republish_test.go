@@ -0,0 +1,169 @@
+package conference //nolint:testpackage
+
+import (
+	"context"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/matrix-org/waterfall/pkg/conference/participant"
+	"github.com/matrix-org/waterfall/pkg/conference/track"
+	"github.com/matrix-org/waterfall/pkg/peer"
+	"github.com/matrix-org/waterfall/pkg/telemetry"
+	"github.com/matrix-org/waterfall/pkg/webrtc_ext"
+	"github.com/sirupsen/logrus"
+	"maunium.net/go/mautrix/event"
+)
+
+func TestRepublishRemovesTrack(t *testing.T) {
+	logger := logrus.NewEntry(logrus.New())
+	tel := telemetry.NewTelemetry(context.Background(), "Conference")
+
+	alice := participant.ID{UserID: "@alice:example.org", DeviceID: "ALICE", CallID: "call"}
+	bob := participant.ID{UserID: "@bob:example.org", DeviceID: "BOB", CallID: "call"}
+	carol := participant.ID{UserID: "@carol:example.org", DeviceID: "CAROL", CallID: "call"}
+
+	conference := newTestConference(t, withStreamsMetadata(event.CallSDPStreamMetadata{
+		"stream": {
+			UserID:   alice.UserID,
+			DeviceID: alice.DeviceID,
+			Tracks: event.CallSDPStreamMetadataTracks{
+				"mic":          {Kind: "audio"},
+				"screen-audio": {Kind: "audio"},
+			},
+		},
+	}))
+	tracker := conference.tracker
+
+	tracker.AddParticipant(&participant.Participant{ID: alice, Logger: logger, Telemetry: tel})
+	for _, id := range []participant.ID{bob, carol} {
+		tracker.AddParticipant(&participant.Participant{ID: id, Peer: newSubscriberPeer(t, id), Logger: logger, Telemetry: tel})
+	}
+
+	for _, remoteTrack := range publishAudioTracks(t, "stream", "mic", "screen-audio") {
+		if err := tracker.AddPublishedTrack(alice, remoteTrack, track.TrackMetadata{}); err != nil {
+			t.Fatalf("Failed to publish %s: %v", remoteTrack.ID(), err)
+		}
+	}
+
+	for _, id := range []participant.ID{bob, carol} {
+		for _, trackID := range []string{"mic", "screen-audio"} {
+			if err := tracker.Subscribe(id, trackID, 0, 0); err != nil {
+				t.Fatalf("Failed to subscribe %s to %s: %v", id, trackID, err)
+			}
+		}
+	}
+
+	// Alice stops sharing the screen, so the new offer only publishes the microphone.
+	offer := strings.Join([]string{
+		"v=0",
+		"s=-",
+		"m=audio 9 UDP/TLS/RTP/SAVPF 111",
+		"a=mid:0",
+		"a=msid:stream mic",
+		"a=sendrecv",
+		"m=audio 9 UDP/TLS/RTP/SAVPF 111",
+		"a=mid:1",
+		"a=inactive",
+		"",
+	}, "\r\n")
+	conference.removeUnpublishedTracks(alice, offer)
+
+	for _, id := range []participant.ID{bob, carol} {
+		if senders := tracker.GetParticipant(id).Peer.ActiveSenders(); senders != 1 {
+			t.Errorf("Expected %s to only receive the microphone, got %d tracks", id, senders)
+		}
+
+		available := conference.getAvailableStreamsFor(id).Metadata["stream"].Tracks
+		if _, found := available["screen-audio"]; found || len(available) != 1 {
+			t.Errorf("Expected the removed track not to be announced to %s, got %+v", id, available)
+		}
+	}
+
+	for _, mapping := range tracker.SSRCMappings() {
+		if mapping.TrackID != "mic" {
+			t.Errorf("Unexpected subscription to %s", mapping.TrackID)
+		}
+	}
+
+	// The offer that publishes all the remaining tracks does not change anything.
+	conference.removeUnpublishedTracks(alice, offer)
+	if mappings := tracker.SSRCMappings(); len(mappings) != 2 {
+		t.Errorf("Expected both subscriptions to the microphone to stay, got %+v", mappings)
+	}
+}
+
+func TestUnpublishRemovesTrack(t *testing.T) {
+	logger := logrus.NewEntry(logrus.New())
+	tel := telemetry.NewTelemetry(context.Background(), "Conference")
+
+	alice := participant.ID{UserID: "@alice:example.org", DeviceID: "ALICE", CallID: "call"}
+	bob := participant.ID{UserID: "@bob:example.org", DeviceID: "BOB", CallID: "call"}
+	carol := participant.ID{UserID: "@carol:example.org", DeviceID: "CAROL", CallID: "call"}
+
+	conference := newTestConference(t)
+	tracker := conference.tracker
+
+	tracker.AddParticipant(&participant.Participant{ID: alice, Logger: logger, Telemetry: tel})
+	for _, id := range []participant.ID{bob, carol} {
+		tracker.AddParticipant(&participant.Participant{ID: id, Peer: newSubscriberPeer(t, id), Logger: logger, Telemetry: tel})
+	}
+
+	for _, remoteTrack := range publishAudioTracks(t, "stream", "mic", "screen-audio") {
+		if err := tracker.AddPublishedTrack(alice, remoteTrack, track.TrackMetadata{}); err != nil {
+			t.Fatalf("Failed to publish %s: %v", remoteTrack.ID(), err)
+		}
+	}
+
+	for _, id := range []participant.ID{bob, carol} {
+		for _, trackID := range []string{"mic", "screen-audio"} {
+			if err := tracker.Subscribe(id, trackID, 0, 0); err != nil {
+				t.Fatalf("Failed to subscribe %s to %s: %v", id, trackID, err)
+			}
+		}
+	}
+
+	unpublish := func(sender participant.ID, trackID string) {
+		conference.processDataChannelMessage(sender, peer.DataChannelMessage{
+			Message: `{"type": "m.call.unpublish", "content": {"track_ids": ["` + trackID + `"]}}`,
+		})
+	}
+
+	publishedTracks := func() []string {
+		trackIDs := []string{}
+		tracker.ForEachPublishedTrackInfo(func(_ participant.ID, info webrtc_ext.TrackInfo) {
+			trackIDs = append(trackIDs, info.TrackID)
+		})
+		sort.Strings(trackIDs)
+		return trackIDs
+	}
+
+	// Only the owner may unpublish its tracks.
+	unpublish(bob, "screen-audio")
+	if tracks := publishedTracks(); len(tracks) != 2 {
+		t.Errorf("Expected the tracks of alice to stay, got %v", tracks)
+	}
+
+	// Alice stops sharing the screen.
+	unpublish(alice, "screen-audio")
+	if tracks := publishedTracks(); len(tracks) != 1 || tracks[0] != "mic" {
+		t.Errorf("Expected the screen audio to be removed, got %v", tracks)
+	}
+
+	for _, id := range []participant.ID{bob, carol} {
+		if senders := tracker.GetParticipant(id).Peer.ActiveSenders(); senders != 1 {
+			t.Errorf("Expected %s to only receive the microphone, got %d tracks", id, senders)
+		}
+
+		available := conference.getAvailableStreamsFor(id).Metadata["stream"].Tracks
+		if _, found := available["screen-audio"]; found || len(available) != 1 {
+			t.Errorf("Expected the unpublished track not to be announced to %s, got %+v", id, available)
+		}
+	}
+
+	for _, mapping := range tracker.SSRCMappings() {
+		if mapping.TrackID != "mic" {
+			t.Errorf("Unexpected subscription to %s", mapping.TrackID)
+		}
+	}
+}
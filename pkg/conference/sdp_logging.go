@@ -0,0 +1,25 @@
+package conference
+
+import (
+	"github.com/matrix-org/waterfall/pkg/webrtc_ext"
+	"github.com/sirupsen/logrus"
+	"go.opentelemetry.io/otel/attribute"
+)
+
+// Logs the SDP (with the credentials redacted) at a given level, unless the SDP logging is disabled.
+func (c *Conference) logSDP(logger *logrus.Entry, level logrus.Level, sdp string, message string) {
+	if c.config.DisableSDPLogging {
+		return
+	}
+
+	logger.WithField("SDP", webrtc_ext.RedactSDP(sdp)).Log(level, message)
+}
+
+// Returns the telemetry attributes with the (redacted) SDP, none if the SDP logging is disabled.
+func (c *Conference) sdpAttributes(key string, sdp string) []attribute.KeyValue {
+	if c.config.DisableSDPLogging {
+		return nil
+	}
+
+	return []attribute.KeyValue{attribute.String(key, webrtc_ext.RedactSDP(sdp))}
+}
@@ -0,0 +1,47 @@
+package conference
+
+import (
+	"encoding/json"
+	"strings"
+
+	"github.com/matrix-org/waterfall/pkg/conference/participant"
+	"github.com/matrix-org/waterfall/pkg/webrtc_ext"
+	"maunium.net/go/mautrix/event"
+)
+
+// Sent by the publisher over the data channel when it pauses or resumes the simulcast layers of a track.
+var FocusCallSimulcastLayers = event.Type{Type: "m.call.simulcast_layers", Class: event.FocusEventType}
+
+// Content of the `m.call.simulcast_layers` event.
+type SimulcastLayersEventContent struct {
+	TrackID string `json:"track_id"`
+	// RIDs of the layers that are paused. The layers that are not listed are active.
+	Paused []string `json:"paused"`
+}
+
+func (c *Conference) processSimulcastLayersMessage(p *participant.Participant, raw json.RawMessage) {
+	var content SimulcastLayersEventContent
+	if err := json.Unmarshal(raw, &content); err != nil {
+		p.Logger.Errorf("Failed to unmarshal simulcast layers: %v", err)
+		return
+	}
+
+	layers := make([]webrtc_ext.SimulcastLayer, 0, len(content.Paused))
+	for _, rid := range content.Paused {
+		layer := webrtc_ext.RIDToSimulcastLayer(rid)
+		if layer == webrtc_ext.SimulcastLayerNone {
+			p.Logger.Warnf("Ignoring unknown RID of a paused layer: %s", rid)
+			continue
+		}
+
+		layers = append(layers, layer)
+	}
+
+	if err := c.tracker.SetPausedLayers(p.ID, content.TrackID, layers); err != nil {
+		p.Logger.Errorf("Failed to set paused layers: %v", err)
+		return
+	}
+
+	p.Logger.WithField("track", content.TrackID).Infof("Paused simulcast layers: %v", content.Paused)
+	c.events.record(EventLayersPaused, p.ID, content.TrackID, strings.Join(content.Paused, ","))
+}
@@ -0,0 +1,14 @@
+package conference
+
+import "time"
+
+// How often the conference selects the speakers whose audio is forwarded, see `Profile.AudioMixingThreshold`.
+const speakerSelectionInterval = 500 * time.Millisecond
+
+// Forwards only the audio of the most recent speakers once more audio tracks than the audio mixing threshold
+// are published. All audio is forwarded again once the threshold is not exceeded (or disabled).
+func (c *Conference) selectSpeakers() {
+	if suppressed := c.tracker.SelectSpeakers(c.profile.AudioMixingThreshold); suppressed > 0 {
+		c.logger.Tracef("Suppressed the audio of %d tracks that are not among the recent speakers", suppressed)
+	}
+}
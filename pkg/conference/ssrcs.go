@@ -0,0 +1,50 @@
+package conference
+
+import (
+	"github.com/pion/webrtc/v3"
+)
+
+// Sent by the router when an operator requested the SSRC mappings of the subscriptions, e.g. to correlate
+// the packets captured on the network with the publishers and layers that they come from.
+type SSRCMappingsRequested struct {
+	// Receives the mappings, must be buffered.
+	Result chan<- SSRCMappingsResult
+}
+
+// The outcome of the `SSRCMappingsRequested`.
+type SSRCMappingsResult struct {
+	Mappings []SubscriptionSSRCs
+	Err      error
+}
+
+// The SSRCs of the packets that a subscriber receives and of the packets that they come from.
+type SubscriptionSSRCs struct {
+	TrackID      string      `json:"track_id"`
+	Publisher    string      `json:"publisher"`
+	Subscriber   string      `json:"subscriber"`
+	OutgoingSSRC webrtc.SSRC `json:"outgoing_ssrc"`
+	// 0 for the audio tracks.
+	IncomingSSRC webrtc.SSRC `json:"incoming_ssrc"`
+	// Empty for the audio tracks.
+	Layer string `json:"layer"`
+}
+
+func (r SSRCMappingsRequested) Fail(err error) {
+	r.Result <- SSRCMappingsResult{Err: err}
+}
+
+func (c *Conference) onSSRCMappingsRequested(request SSRCMappingsRequested) {
+	mappings := []SubscriptionSSRCs{}
+	for _, mapping := range c.tracker.SSRCMappings() {
+		mappings = append(mappings, SubscriptionSSRCs{
+			TrackID:      mapping.TrackID,
+			Publisher:    mapping.Publisher,
+			Subscriber:   mapping.Subscriber,
+			OutgoingSSRC: mapping.OutgoingSSRC,
+			IncomingSSRC: mapping.IncomingSSRC,
+			Layer:        mapping.Layer.String(),
+		})
+	}
+
+	request.Result <- SSRCMappingsResult{Mappings: mappings}
+}
@@ -0,0 +1,72 @@
+package conference //nolint:testpackage
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/matrix-org/waterfall/pkg/conference/participant"
+	"github.com/matrix-org/waterfall/pkg/conference/track"
+	"github.com/matrix-org/waterfall/pkg/telemetry"
+	"github.com/sirupsen/logrus"
+)
+
+func TestSSRCMappingsReported(t *testing.T) {
+	conference := newTestConference(t)
+	tracker := conference.tracker
+	logger := logrus.NewEntry(logrus.New())
+	tel := telemetry.NewTelemetry(context.Background(), "Conference")
+
+	alice := participant.ID{UserID: "@alice:example.org", DeviceID: "ALICE", CallID: "call"}
+	bob := participant.ID{UserID: "@bob:example.org", DeviceID: "BOB", CallID: "call"}
+
+	tracker.AddParticipant(&participant.Participant{ID: alice, Logger: logger, Telemetry: tel})
+	tracker.AddParticipant(&participant.Participant{ID: bob, Peer: newSubscriberPeer(t, bob), Logger: logger, Telemetry: tel})
+
+	for _, remoteTrack := range publishAudioTracks(t, "stream", "mic", "screen-audio") {
+		if err := tracker.AddPublishedTrack(alice, remoteTrack, track.TrackMetadata{}); err != nil {
+			t.Fatalf("Failed to publish %s: %v", remoteTrack.ID(), err)
+		}
+	}
+
+	// Nobody is subscribed yet.
+	result := make(chan SSRCMappingsResult, 1)
+	conference.onSSRCMappingsRequested(SSRCMappingsRequested{Result: result})
+	if outcome := <-result; outcome.Err != nil || len(outcome.Mappings) != 0 {
+		t.Fatalf("Expected no mappings, got %+v", outcome)
+	}
+
+	if err := tracker.Subscribe(bob, "mic", 0, 0); err != nil {
+		t.Fatalf("Failed to subscribe: %v", err)
+	}
+
+	conference.onSSRCMappingsRequested(SSRCMappingsRequested{Result: result})
+	outcome := <-result
+	if outcome.Err != nil {
+		t.Fatalf("Failed to get the SSRC mappings: %v", outcome.Err)
+	}
+
+	if len(outcome.Mappings) != 1 {
+		t.Fatalf("Expected a single mapping, got %+v", outcome.Mappings)
+	}
+
+	mapping := outcome.Mappings[0]
+	if mapping.TrackID != "mic" || mapping.Publisher != alice.String() || mapping.Subscriber != bob.String() {
+		t.Errorf("Expected Bob to receive the microphone of Alice, got %+v", mapping)
+	}
+
+	// Audio tracks are forwarded as they are, without any layers.
+	if mapping.OutgoingSSRC == 0 || mapping.IncomingSSRC != 0 || mapping.Layer != "" {
+		t.Errorf("Expected only the outgoing SSRC of an audio track, got %+v", mapping)
+	}
+
+	serialized, err := json.Marshal(outcome.Mappings)
+	if err != nil {
+		t.Fatalf("Failed to marshal the SSRC mappings: %v", err)
+	}
+
+	if !strings.Contains(string(serialized), `"outgoing_ssrc":`) {
+		t.Errorf("Expected the outgoing SSRC to be reported, got %s", serialized)
+	}
+}
@@ -18,12 +18,16 @@ package conference
 
 import (
 	"context"
+	"time"
 
 	"github.com/matrix-org/waterfall/pkg/channel"
 	"github.com/matrix-org/waterfall/pkg/conference/participant"
+	"github.com/matrix-org/waterfall/pkg/conference/subscription"
+	"github.com/matrix-org/waterfall/pkg/conference/track"
 	"github.com/matrix-org/waterfall/pkg/peer"
 	"github.com/matrix-org/waterfall/pkg/signaling"
 	"github.com/matrix-org/waterfall/pkg/telemetry"
+	"github.com/matrix-org/waterfall/pkg/webhook"
 	"github.com/matrix-org/waterfall/pkg/webrtc_ext"
 	"github.com/sirupsen/logrus"
 	"go.opentelemetry.io/otel/attribute"
@@ -31,43 +35,67 @@ import (
 	"maunium.net/go/mautrix/id"
 )
 
+// How many messages each participant's peer can send to the conference before it blocks. The messages
+// of different participants are processed in a round-robin order, so one chatty peer can't delay others.
+const peerMessagesCapacity = 100
+
 // Starts a new conference or fails and returns an error.
 // The conference ends when the last participant leaves.
+// The `profileName` selects the conference profile from the config.
 func StartConference(
 	confID string,
 	config Config,
+	profileName string,
 	peerConnectionFactory *webrtc_ext.PeerConnectionFactory,
 	signaling signaling.MatrixSignaler,
+	webhooks *webhook.Notifier,
 	matrixEvents <-chan MatrixMessage,
 	userID id.UserID,
 	inviteEvent *event.CallInviteEventContent,
 ) (<-chan struct{}, error) {
+	profile := config.Profile(profileName)
+
 	signalDone := make(chan struct{})
-	tracker, publishedTrackStopped := participant.NewParticipantTracker(signalDone)
+	tracker, publishedTrackStopped := participant.NewParticipantTracker(signalDone, newTrackConfig(config, profile))
 
-	telemetry := telemetry.NewTelemetry(
+	telemetry := telemetry.NewSampledTelemetry(
 		context.Background(),
 		"Conference",
+		telemetry.Sample(profile.TelemetrySampling),
 		attribute.String("conference_id", confID),
+		attribute.String("profile", profileName),
 	)
 
 	conference := &Conference{
 		id:                    confID,
 		config:                config,
+		profile:               profile,
+		profileName:           profileName,
 		connectionFactory:     peerConnectionFactory,
 		logger:                logrus.WithFields(logrus.Fields{"conf_id": confID}),
 		telemetry:             telemetry,
 		matrixWorker:          newMatrixWorker(signaling),
+		webhooks:              webhooks,
 		tracker:               tracker,
 		streamsMetadata:       make(event.CallSDPStreamMetadata),
-		peerMessages:          make(chan channel.Message[participant.ID, peer.MessageContent], 100),
+		encryptedTracks:       make(map[track.TrackID]bool),
+		events:                newEventLog(profile.EventLogSize),
+		peerMessages:          channel.NewFairQueue[participant.ID, peer.MessageContent](peerMessagesCapacity),
 		matrixEvents:          matrixEvents,
 		publishedTrackStopped: publishedTrackStopped,
 	}
 
+	conference.notify(webhook.ConferenceStarted, nil)
+
 	participantID := participant.ID{UserID: userID, DeviceID: inviteEvent.DeviceID, CallID: inviteEvent.CallID}
 	if err := conference.onNewParticipant(participantID, inviteEvent); err != nil {
-		return nil, nil
+		// The main loop never starts, so nothing else would clean up after the conference.
+		conference.notify(webhook.ConferenceEnded, nil)
+		conference.matrixWorker.stop()
+		conference.telemetry.End()
+		conference.peerMessages.Close()
+		close(signalDone)
+		return nil, err
 	}
 
 	// Start conference "main loop".
@@ -75,3 +103,34 @@ func StartConference(
 
 	return signalDone, nil
 }
+
+// Creates the configuration of the published tracks for a given conference config and profile.
+func newTrackConfig(config Config, profile Profile) track.Config {
+	return track.Config{
+		StallTimeout:            time.Duration(profile.StallTimeout) * time.Millisecond,
+		ScreenshareStallTimeout: time.Duration(profile.ScreenshareStallTimeout) * time.Millisecond,
+		KeyFrameRequestInterval: time.Duration(profile.KeyFrameRequestInterval) * time.Millisecond,
+		ScreenshareKeyFrameRequestInterval: time.Duration(profile.ScreenshareKeyFrameRequestInterval) *
+			time.Millisecond,
+		Impairment:            config.Impairment,
+		ClockRateValidation:   config.ClockRateValidation,
+		DetectFrameSize:       enabled(profile.ReportLayerResolutions),
+		DefaultLayer:          webrtc_ext.SimulcastLayerFromString(profile.DefaultLayer),
+		SimulcastMode:         profile.SimulcastMode,
+		FixedLayer:            webrtc_ext.SimulcastLayerFromString(profile.FixedLayer),
+		StalePublisherTimeout: time.Duration(profile.StalePublisherTimeout) * time.Second,
+		MaxSubscribers:        profile.MaxSubscribersPerTrack,
+		MaxLayers:             profile.MaxSimulcastLayers,
+		MaxLayerBitrates:      profile.MaxLayerBitrates.bitsPerSecond(),
+		PublisherLeftPolicy:   profile.PublisherLeftPolicy,
+		Subscription: subscription.Config{
+			ChannelSize:       profile.SubscriptionBufferSize,
+			Timeout:           time.Duration(profile.SubscriptionTimeout) * time.Millisecond,
+			PlayoutDelay:      profile.PlayoutDelay,
+			ReorderWindow:     time.Duration(profile.ReorderWindow) * time.Millisecond,
+			ReorderBufferSize: profile.ReorderBufferSize,
+			DropPadding:       enabled(profile.DropPadding),
+			DropPolicy:        profile.SubscriptionDropPolicy,
+		},
+	}
+}
@@ -1,46 +1,114 @@
 package conference
 
 import (
+	"time"
+
 	"github.com/matrix-org/waterfall/pkg/channel"
 	"github.com/matrix-org/waterfall/pkg/conference/participant"
 	published "github.com/matrix-org/waterfall/pkg/conference/track"
+	"github.com/matrix-org/waterfall/pkg/metrics"
 	"github.com/matrix-org/waterfall/pkg/peer"
 	"github.com/matrix-org/waterfall/pkg/telemetry"
+	"github.com/matrix-org/waterfall/pkg/webhook"
 	"github.com/matrix-org/waterfall/pkg/webrtc_ext"
+	"github.com/pion/webrtc/v3"
 	"github.com/sirupsen/logrus"
 	"maunium.net/go/mautrix/event"
 )
 
 // A single conference. Call and conference mean the same in context of Matrix.
 type Conference struct {
-	id     string
-	config Config
+	id          string
+	config      Config
+	profile     Profile
+	profileName string
 
 	logger    *logrus.Entry
 	telemetry *telemetry.Telemetry
 
 	connectionFactory *webrtc_ext.PeerConnectionFactory
 	matrixWorker      *matrixWorker
+	webhooks          *webhook.Notifier
 
 	tracker         *participant.Tracker
 	streamsMetadata event.CallSDPStreamMetadata
+	// Tracks that are end-to-end encrypted according to the SDP offers.
+	encryptedTracks map[published.TrackID]bool
+	// When the key frames were requested from all publishers for the last time.
+	lastKeyFrameRefresh time.Time
+	// When the participants that left the conference recently were removed, see `recentRemovalWindow`.
+	recentlyRemoved map[participant.Key]time.Time
+	// Recent significant events of the conference, see `/admin/events`.
+	events *eventLog
+	// The layers that were stalled when the events were recorded for the last time.
+	stalledLayers map[stalledLayer]participant.ID
+	// The participants whose audio is muted for everyone by a moderator, see `MuteRequested`. It's kept
+	// for the device rather than for the participant, so that reconnecting does not lift the mute.
+	forceMuted map[participant.Key]bool
 
-	peerMessages          chan channel.Message[participant.ID, peer.MessageContent]
+	peerMessages          *channel.FairQueue[participant.ID, peer.MessageContent]
 	matrixEvents          <-chan MatrixMessage
 	publishedTrackStopped <-chan participant.TrackStoppedMessage
 }
 
+// For how long the messages for a removed participant are expected, i.e. those that were sent (or queued)
+// before the participant got removed. Such messages are not worth an error.
+const recentRemovalWindow = 10 * time.Second
+
+// Returns the participant that a message of a given sender concerns. The messages of a different call of the
+// same device (e.g. the ones of a previous call that arrive after the participant reconnected) are ignored.
 func (c *Conference) getParticipant(id participant.ID) *participant.Participant {
 	if participant := c.tracker.GetParticipant(id); participant != nil {
+		if !participant.ID.SameCall(id) {
+			participant.Logger.WithField("call_id", id.CallID).Debug("Ignoring a message of another call")
+			return nil
+		}
+
 		return participant
 	}
 
+	if c.wasRecentlyRemoved(id, time.Now()) {
+		metrics.ParticipantNotFound.Add("recently_removed", 1)
+		c.logger.Debugf("Participant has been removed: %s (%s)", id.UserID, id.DeviceID)
+		return nil
+	}
+
+	metrics.ParticipantNotFound.Add("unknown", 1)
 	c.logger.Errorf("Participant not found: %s (%s)", id.UserID, id.DeviceID)
 	return nil
 }
 
+// Checks if the participant has been removed within the `recentRemovalWindow`.
+func (c *Conference) wasRecentlyRemoved(id participant.ID, now time.Time) bool {
+	removedAt, found := c.recentlyRemoved[id.Key()]
+	return found && now.Sub(removedAt) < recentRemovalWindow
+}
+
+// Remembers that the participant has been removed, forgetting those that were removed long ago.
+func (c *Conference) rememberRemoval(id participant.ID, now time.Time) {
+	if c.recentlyRemoved == nil {
+		c.recentlyRemoved = make(map[participant.Key]time.Time)
+	}
+
+	for key, removedAt := range c.recentlyRemoved {
+		if now.Sub(removedAt) >= recentRemovalWindow {
+			delete(c.recentlyRemoved, key)
+		}
+	}
+
+	c.recentlyRemoved[id.Key()] = now
+}
+
 // Helper to terminate and remove a participant from the conference.
 func (c *Conference) removeParticipant(id participant.ID) {
+	if p := c.tracker.GetParticipant(id); p != nil {
+		c.notify(webhook.ParticipantLeft, &id)
+		c.events.record(EventParticipantLeft, id, "", "")
+		// The messages that the participant has sent are not relevant anymore.
+		defer c.peerMessages.Remove(p.ID)
+		c.rememberRemoval(p.ID, time.Now())
+	}
+
 	// Remove the participant and then remove its streams from the map.
 	for streamID := range c.tracker.RemoveParticipant(id) {
 		delete(c.streamsMetadata, streamID)
@@ -50,14 +118,29 @@ func (c *Conference) removeParticipant(id participant.ID) {
 	// the corresponding streams of the participant are no longer available, so we're informing
 	// others about it).
 	c.resendMetadataToAllExcept(id)
+
+	// The set of participants has changed, so let everyone know.
+	c.broadcastPresence()
 }
 
 // Helper to get the list of available streams for a given participant, i.e. the list of streams
 // that a given participant **can subscribe to**. Each stream may have multiple tracks.
-func (c *Conference) getAvailableStreamsFor(forParticipant participant.ID) event.CallSDPStreamMetadata {
+func (c *Conference) getAvailableStreamsFor(forParticipant participant.ID) participant.AvailableStreams {
 	streamsMetadata := make(event.CallSDPStreamMetadata)
+	subscriber := c.tracker.GetParticipant(forParticipant)
 
 	c.tracker.ForEachPublishedTrackInfo(func(owner participant.ID, info webrtc_ext.TrackInfo) {
+		// The audio-only participants can't subscribe to the video, so they don't need to know about it.
+		if subscriber != nil && subscriber.AudioOnly && info.Kind == webrtc.RTPCodecTypeVideo {
+			return
+		}
+
+		// Nor to the tracks in the codecs that they can't receive, if the profile says so.
+		if c.profile.IncompatibleCodecPolicy == IncompatibleCodecPolicyHide && subscriber != nil &&
+			subscriber.Peer != nil && !subscriber.Peer.SupportsCodec(info.Codec) {
+			return
+		}
+
 		// Skip us. As we know about our own tracks.
 		if owner != forParticipant {
 			streamID := info.StreamID
@@ -75,35 +158,121 @@ func (c *Conference) getAvailableStreamsFor(forParticipant participant.ID) event
 					},
 				}
 				streamsMetadata[streamID] = metadata
+			} else if !enabled(c.profile.RequireStreamMetadata) {
+				// Some clients don't send the metadata at all, so we describe the stream by its tracks.
+				c.logger.Debugf("Don't have metadata for %s, describing it by the track", info.TrackID)
+				streamsMetadata[streamID] = event.CallSDPStreamMetadataObject{
+					UserID:   owner.UserID,
+					DeviceID: owner.DeviceID,
+					Purpose:  event.Usermedia,
+					Tracks: event.CallSDPStreamMetadataTracks{
+						info.TrackID: event.CallSDPStreamMetadataTrack{
+							Kind: kind,
+						},
+					},
+				}
 			} else {
 				c.logger.Warnf("Don't have metadata for %s", info.TrackID)
 			}
 		}
 	})
 
-	return streamsMetadata
+	// The audio muted by a moderator is muted regardless of what the participant says.
+	for streamID, metadata := range streamsMetadata {
+		if c.forceMuted[participant.Key{UserID: metadata.UserID, DeviceID: metadata.DeviceID}] {
+			metadata.AudioMuted = true
+			streamsMetadata[streamID] = metadata
+		}
+	}
+
+	available := participant.AvailableStreams{Metadata: streamsMetadata}
+	if enabled(c.profile.ReportLayerResolutions) {
+		available.Layers = c.getLayerResolutions(streamsMetadata)
+	}
+
+	return available
+}
+
+// Returns the resolutions of the simulcast layers of the tracks of given streams, from the lowest layer to the
+// highest one. The tracks without simulcast (and the audio ones) have no layers to choose from and are left out.
+func (c *Conference) getLayerResolutions(streams event.CallSDPStreamMetadata) participant.LayerResolutions {
+	resolutions := make(participant.LayerResolutions)
+	for streamID, stream := range streams {
+		for trackID := range stream.Tracks {
+			known := c.tracker.LayerResolutions(trackID)
+
+			layers := []participant.LayerResolution{}
+			for _, layer := range []webrtc_ext.SimulcastLayer{
+				webrtc_ext.SimulcastLayerLow,
+				webrtc_ext.SimulcastLayerMedium,
+				webrtc_ext.SimulcastLayerHigh,
+			} {
+				if resolution, found := known[layer]; found {
+					layers = append(layers, participant.LayerResolution{
+						Layer:  layer.String(),
+						Width:  resolution.Width,
+						Height: resolution.Height,
+					})
+				}
+			}
+
+			if len(layers) == 0 {
+				continue
+			}
+
+			if resolutions[streamID] == nil {
+				resolutions[streamID] = make(map[string][]participant.LayerResolution)
+			}
+			resolutions[streamID][trackID] = layers
+		}
+	}
+
+	return resolutions
 }
 
 // Helper that sends current metadata about all available tracks to all participants except a given one.
 func (c *Conference) resendMetadataToAllExcept(exceptMe participant.ID) {
 	c.tracker.ForEachParticipant(func(id participant.ID, participant *participant.Participant) {
-		metadataEvent := event.Event{
-			Type: event.FocusCallSDPStreamMetadataChanged,
-			Content: event.Content{
-				Parsed: event.FocusCallSDPStreamMetadataChangedEventContent{
-					SDPStreamMetadata: c.getAvailableStreamsFor(id),
-				},
-			},
-		}
-
 		if id != exceptMe {
-			if err := participant.SendOverDataChannel(metadataEvent); err != nil {
+			if err := participant.SendMetadata(c.getAvailableStreamsFor(id)); err != nil {
 				c.logger.WithError(err).Errorf("Failed to send metadata to %s", id)
 			}
 		}
 	})
 }
 
+// Removes the tracks of a participant that are not published by its new SDP offer anymore, so that
+// the subscribers don't keep referencing the tracks that are gone. Informs others if anything changed.
+func (c *Conference) removeUnpublishedTracks(owner participant.ID, sdpOffer string) {
+	stillPublished := webrtc_ext.PublishedTrackIDs(sdpOffer)
+	c.removePublishedTracks(owner, func(trackID published.TrackID) bool {
+		return !stillPublished[trackID]
+	})
+}
+
+// Removes the tracks of a participant that match a given predicate along with their subscriptions (the
+// subscribers renegotiate to get rid of them). Informs others about the new metadata if anything changed.
+func (c *Conference) removePublishedTracks(owner participant.ID, unpublished func(published.TrackID) bool) {
+	removed := []published.TrackID{}
+	c.tracker.ForEachPublishedTrackInfo(func(id participant.ID, info webrtc_ext.TrackInfo) {
+		if id == owner && unpublished(info.TrackID) {
+			removed = append(removed, info.TrackID)
+		}
+	})
+
+	if len(removed) == 0 {
+		return
+	}
+
+	// Removing the track ends all of its subscriptions.
+	for _, trackID := range removed {
+		c.logger.Infof("Track %s is not published by %s anymore", trackID, owner)
+		c.tracker.RemovePublishedTrack(trackID)
+	}
+
+	c.resendMetadataToAllExcept(owner)
+}
+
 // Helper that updates the metadata each time the metadata is received.
 func (c *Conference) updateMetadata(metadata event.CallSDPStreamMetadata) {
 	// Note that this assumes that the stream IDs are unique, which is not always so!
@@ -116,10 +285,33 @@ func (c *Conference) updateMetadata(metadata event.CallSDPStreamMetadata) {
 	}
 
 	for trackID, metadata := range streamIntoTrackMetadata(metadata) {
+		metadata.Encrypted = c.isEncryptedTrack(trackID)
+		metadata.MaxFrameRate = c.maxFrameRate(metadata)
 		c.tracker.UpdatePublishedTrackMetadata(trackID, metadata)
 	}
 }
 
+// Remembers the end-to-end encrypted tracks from an SDP offer of a participant.
+func (c *Conference) updateEncryptedTracks(sdpOffer string) {
+	for trackID := range webrtc_ext.EncryptedTrackIDs(sdpOffer) {
+		c.encryptedTracks[trackID] = true
+	}
+}
+
+// Returns the frame rate that the subscribers of a track with given metadata get at most (0 means unlimited).
+func (c *Conference) maxFrameRate(metadata published.TrackMetadata) int {
+	if metadata.Screenshare {
+		return c.profile.ScreenshareMaxFrameRate
+	}
+
+	return c.profile.MaxFrameRate
+}
+
+// Checks if the payload of a given track is end-to-end encrypted, i.e. must not be inspected.
+func (c *Conference) isEncryptedTrack(trackID published.TrackID) bool {
+	return enabled(c.profile.EncryptedMedia) || c.encryptedTracks[trackID]
+}
+
 func streamIntoTrackMetadata(
 	streamMetadata event.CallSDPStreamMetadata,
 ) map[published.TrackID]published.TrackMetadata {
@@ -136,9 +328,10 @@ func streamIntoTrackMetadata(
 			}
 
 			tracksMetadata[id] = published.TrackMetadata{
-				MaxWidth:  track.Width,
-				MaxHeight: track.Height,
-				Muted:     muted,
+				MaxWidth:    track.Width,
+				MaxHeight:   track.Height,
+				Muted:       muted,
+				Screenshare: metadata.Purpose == event.Screenshare,
 			}
 		}
 	}
@@ -146,6 +339,17 @@ func streamIntoTrackMetadata(
 	return tracksMetadata
 }
 
+// Helper that notifies the external systems about the conference events.
+func (c *Conference) notify(eventType webhook.EventType, id *participant.ID) {
+	webhookEvent := webhook.Event{Type: eventType, ConferenceID: c.id}
+	if id != nil {
+		webhookEvent.UserID = id.UserID.String()
+		webhookEvent.DeviceID = id.DeviceID.String()
+	}
+
+	c.webhooks.Notify(webhookEvent)
+}
+
 func (c *Conference) newLogger(id participant.ID) *logrus.Entry {
 	return c.logger.WithFields(logrus.Fields{
 		"user_id":   id.UserID,
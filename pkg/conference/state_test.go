@@ -0,0 +1,62 @@
+package conference //nolint:testpackage
+
+import (
+	"expvar"
+	"testing"
+	"time"
+
+	"github.com/matrix-org/waterfall/pkg/conference/participant"
+	"github.com/matrix-org/waterfall/pkg/metrics"
+	"github.com/sirupsen/logrus"
+	"github.com/sirupsen/logrus/hooks/test"
+)
+
+func participantNotFound(reason string) int64 {
+	if counter, ok := metrics.ParticipantNotFound.Get(reason).(*expvar.Int); ok {
+		return counter.Value()
+	}
+
+	return 0
+}
+
+func TestParticipantNotFound(t *testing.T) {
+	logger, hook := test.NewNullLogger()
+	logger.SetLevel(logrus.DebugLevel)
+
+	conference := newTestConference(t, withLogger(logrus.NewEntry(logger)))
+
+	alice := participant.ID{UserID: "@alice:example.org", DeviceID: "ALICE", CallID: "call"}
+	bob := participant.ID{UserID: "@bob:example.org", DeviceID: "BOB", CallID: "call"}
+	carol := participant.ID{UserID: "@carol:example.org", DeviceID: "CAROL", CallID: "call"}
+
+	conference.rememberRemoval(alice, time.Now())
+	conference.rememberRemoval(carol, time.Now().Add(-recentRemovalWindow))
+
+	cases := []struct {
+		name   string
+		id     participant.ID
+		level  logrus.Level
+		reason string
+	}{
+		{"a message right after the removal", alice, logrus.DebugLevel, "recently_removed"},
+		{"a message for an unknown participant", bob, logrus.ErrorLevel, "unknown"},
+		{"a message long after the removal", carol, logrus.ErrorLevel, "unknown"},
+	}
+
+	for _, c := range cases {
+		hook.Reset()
+		before := participantNotFound(c.reason)
+
+		if p := conference.getParticipant(c.id); p != nil {
+			t.Errorf("%s: expected no participant, got %s", c.name, p.ID)
+		}
+
+		if entry := hook.LastEntry(); entry == nil || entry.Level != c.level {
+			t.Errorf("%s: expected a log entry on the %s level, got %v", c.name, c.level, entry)
+		}
+
+		if count := participantNotFound(c.reason) - before; count != 1 {
+			t.Errorf("%s: expected the %s counter to be incremented once, got %d", c.name, c.reason, count)
+		}
+	}
+}
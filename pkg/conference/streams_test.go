@@ -0,0 +1,322 @@
+package conference //nolint:testpackage
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/matrix-org/waterfall/pkg/channel"
+	"github.com/matrix-org/waterfall/pkg/conference/participant"
+	"github.com/matrix-org/waterfall/pkg/conference/track"
+	"github.com/matrix-org/waterfall/pkg/peer"
+	"github.com/matrix-org/waterfall/pkg/telemetry"
+	"github.com/matrix-org/waterfall/pkg/webrtc_ext"
+	"github.com/pion/rtp"
+	"github.com/pion/webrtc/v3"
+	"github.com/sirupsen/logrus"
+	"maunium.net/go/mautrix/event"
+)
+
+// Negotiates a connection between two peer connections without trickling the candidates.
+func negotiate(t *testing.T, offerer, answerer *webrtc.PeerConnection) {
+	t.Helper()
+
+	offer, err := offerer.CreateOffer(nil)
+	if err != nil {
+		t.Fatalf("Failed to create offer: %v", err)
+	}
+	gathered := webrtc.GatheringCompletePromise(offerer)
+	if err := offerer.SetLocalDescription(offer); err != nil {
+		t.Fatalf("Failed to set local description: %v", err)
+	}
+	<-gathered
+
+	if err := answerer.SetRemoteDescription(*offerer.LocalDescription()); err != nil {
+		t.Fatalf("Failed to set remote description: %v", err)
+	}
+	answer, err := answerer.CreateAnswer(nil)
+	if err != nil {
+		t.Fatalf("Failed to create answer: %v", err)
+	}
+	gathered = webrtc.GatheringCompletePromise(answerer)
+	if err := answerer.SetLocalDescription(answer); err != nil {
+		t.Fatalf("Failed to set local description: %v", err)
+	}
+	<-gathered
+
+	if err := offerer.SetRemoteDescription(*answerer.LocalDescription()); err != nil {
+		t.Fatalf("Failed to set remote description: %v", err)
+	}
+}
+
+// Publishes audio tracks with given IDs in a single stream and returns them as they are seen by the SFU.
+func publishAudioTracks(t *testing.T, streamID string, trackIDs ...string) []*webrtc.TrackRemote {
+	t.Helper()
+
+	sender, err := webrtc.NewPeerConnection(webrtc.Configuration{})
+	if err != nil {
+		t.Fatalf("Failed to create sender: %v", err)
+	}
+	t.Cleanup(func() { sender.Close() })
+
+	receiver, err := webrtc.NewPeerConnection(webrtc.Configuration{})
+	if err != nil {
+		t.Fatalf("Failed to create receiver: %v", err)
+	}
+	t.Cleanup(func() { receiver.Close() })
+
+	localTracks := []*webrtc.TrackLocalStaticRTP{}
+	for _, trackID := range trackIDs {
+		localTrack, err := webrtc.NewTrackLocalStaticRTP(
+			webrtc.RTPCodecCapability{MimeType: webrtc.MimeTypeOpus},
+			trackID,
+			streamID,
+		)
+		if err != nil {
+			t.Fatalf("Failed to create track: %v", err)
+		}
+
+		if _, err := sender.AddTrack(localTrack); err != nil {
+			t.Fatalf("Failed to add track: %v", err)
+		}
+		localTracks = append(localTracks, localTrack)
+	}
+
+	received := make(chan *webrtc.TrackRemote, len(trackIDs))
+	receiver.OnTrack(func(remote *webrtc.TrackRemote, _ *webrtc.RTPReceiver) {
+		received <- remote
+	})
+
+	negotiate(t, sender, receiver)
+
+	// Pion fires the tracks once the first packets arrive.
+	ticker := time.NewTicker(20 * time.Millisecond)
+	defer ticker.Stop()
+	timeout := time.After(10 * time.Second)
+
+	remoteTracks := []*webrtc.TrackRemote{}
+	for sequenceNumber := uint16(0); len(remoteTracks) < len(trackIDs); sequenceNumber++ {
+		select {
+		case remote := <-received:
+			remoteTracks = append(remoteTracks, remote)
+		case <-ticker.C:
+			for _, localTrack := range localTracks {
+				localTrack.WriteRTP(&rtp.Packet{ //nolint:errcheck
+					Header:  rtp.Header{Version: 2, SequenceNumber: sequenceNumber},
+					Payload: []byte{0x00},
+				})
+			}
+		case <-timeout:
+			t.Fatal("Timed out waiting for the published tracks")
+		}
+	}
+
+	return remoteTracks
+}
+
+// Creates a peer of a participant that does not publish anything.
+func newSubscriberPeer(t *testing.T, id participant.ID) *peer.Peer[participant.ID] {
+	t.Helper()
+
+	remote, err := webrtc.NewPeerConnection(webrtc.Configuration{})
+	if err != nil {
+		t.Fatalf("Failed to create remote peer connection: %v", err)
+	}
+	t.Cleanup(func() { remote.Close() })
+
+	return newSubscriberPeerFor(t, id, remote)
+}
+
+// Creates a peer of a participant that does not publish anything, based on the offer of its peer connection.
+func newSubscriberPeerFor(t *testing.T, id participant.ID, remote *webrtc.PeerConnection) *peer.Peer[participant.ID] {
+	t.Helper()
+
+	if _, err := remote.CreateDataChannel("data", nil); err != nil {
+		t.Fatalf("Failed to create data channel: %v", err)
+	}
+
+	offer, err := remote.CreateOffer(nil)
+	if err != nil {
+		t.Fatalf("Failed to create offer: %v", err)
+	}
+
+	factory, err := webrtc_ext.NewPeerConnectionFactory(webrtc_ext.Config{})
+	if err != nil {
+		t.Fatalf("Failed to create peer connection factory: %v", err)
+	}
+
+	messages := make(chan channel.Message[participant.ID, peer.MessageContent], 100)
+	p, _, err := peer.NewPeer(factory, offer.SDP, channel.NewSink(id, messages), peer.Config{}, logrus.NewEntry(logrus.New()))
+	if err != nil {
+		t.Fatalf("Failed to create peer: %v", err)
+	}
+	t.Cleanup(p.Terminate)
+
+	return p
+}
+
+func TestMultipleAudioTracksInStream(t *testing.T) {
+	logger := logrus.NewEntry(logrus.New())
+	tel := telemetry.NewTelemetry(context.Background(), "Conference")
+
+	alice := participant.ID{UserID: "@alice:example.org", DeviceID: "ALICE", CallID: "call"}
+	bob := participant.ID{UserID: "@bob:example.org", DeviceID: "BOB", CallID: "call"}
+
+	conference := newTestConference(t, withStreamsMetadata(event.CallSDPStreamMetadata{
+		"stream": {
+			UserID:   alice.UserID,
+			DeviceID: alice.DeviceID,
+			Purpose:  event.Usermedia,
+			Tracks: event.CallSDPStreamMetadataTracks{
+				"mic":          {Kind: "audio"},
+				"screen-audio": {Kind: "audio"},
+			},
+		},
+	}))
+	tracker := conference.tracker
+
+	tracker.AddParticipant(&participant.Participant{ID: alice, Logger: logger, Telemetry: tel})
+	tracker.AddParticipant(&participant.Participant{ID: bob, Peer: newSubscriberPeer(t, bob), Logger: logger, Telemetry: tel})
+
+	// Alice shares the screen with audio, so she publishes the microphone and the screen audio.
+	for _, remoteTrack := range publishAudioTracks(t, "stream", "mic", "screen-audio") {
+		if err := tracker.AddPublishedTrack(alice, remoteTrack, track.TrackMetadata{}); err != nil {
+			t.Fatalf("Failed to publish %s: %v", remoteTrack.ID(), err)
+		}
+	}
+
+	// Both audio tracks are offered to Bob as separate tracks of the same stream.
+	available := conference.getAvailableStreamsFor(bob).Metadata["stream"].Tracks
+	for _, trackID := range []string{"mic", "screen-audio"} {
+		if available[trackID].Kind != "audio" {
+			t.Errorf("Expected %s to be available as an audio track, got %+v", trackID, available)
+		}
+	}
+
+	// Bob only wants to hear the microphone.
+	if err := tracker.Subscribe(bob, "mic", 0, 0); err != nil {
+		t.Fatalf("Failed to subscribe: %v", err)
+	}
+
+	mappings := tracker.SSRCMappings()
+	if len(mappings) != 1 || mappings[0].TrackID != "mic" || mappings[0].Subscriber != bob.String() {
+		t.Errorf("Expected Bob to be subscribed to the microphone only, got %+v", mappings)
+	}
+
+	if senders := tracker.GetParticipant(bob).Peer.ActiveSenders(); senders != 1 {
+		t.Errorf("Expected a single track to be sent to Bob, got %d", senders)
+	}
+}
+
+func TestStreamsWithoutMetadata(t *testing.T) {
+	logger := logrus.NewEntry(logrus.New())
+	tel := telemetry.NewTelemetry(context.Background(), "Conference")
+
+	alice := participant.ID{UserID: "@alice:example.org", DeviceID: "ALICE", CallID: "call"}
+	bob := participant.ID{UserID: "@bob:example.org", DeviceID: "BOB", CallID: "call"}
+
+	conference := newTestConference(t)
+	tracker := conference.tracker
+
+	tracker.AddParticipant(&participant.Participant{ID: alice, Logger: logger, Telemetry: tel})
+	tracker.AddParticipant(&participant.Participant{ID: bob, Peer: newSubscriberPeer(t, bob), Logger: logger, Telemetry: tel})
+
+	for _, remoteTrack := range publishAudioTracks(t, "stream", "mic", "screen-audio") {
+		if err := tracker.AddPublishedTrack(alice, remoteTrack, track.TrackMetadata{}); err != nil {
+			t.Fatalf("Failed to publish %s: %v", remoteTrack.ID(), err)
+		}
+	}
+
+	// Alice's invite had no metadata.
+	conference.updateMetadata(nil)
+
+	// The stream is described by the tracks that Alice actually publishes.
+	stream, found := conference.getAvailableStreamsFor(bob).Metadata["stream"]
+	if !found || stream.UserID != alice.UserID || stream.DeviceID != alice.DeviceID || stream.Purpose != event.Usermedia {
+		t.Fatalf("Expected the stream of alice to be available to bob, got %+v", stream)
+	}
+	for _, trackID := range []string{"mic", "screen-audio"} {
+		if stream.Tracks[trackID].Kind != "audio" {
+			t.Errorf("Expected %s to be available as an audio track, got %+v", trackID, stream.Tracks)
+		}
+	}
+
+	if err := tracker.Subscribe(bob, "mic", 0, 0); err != nil {
+		t.Errorf("Failed to subscribe to the track without metadata: %v", err)
+	}
+
+	// Unless the profile requires the clients to describe their streams.
+	required := true
+	conference.profile.RequireStreamMetadata = &required
+	if available := conference.getAvailableStreamsFor(bob).Metadata; len(available) != 0 {
+		t.Errorf("Expected the streams without metadata to be hidden, got %+v", available)
+	}
+}
+
+func TestIncompatibleCodecs(t *testing.T) {
+	logger := logrus.NewEntry(logrus.New())
+	tel := telemetry.NewTelemetry(context.Background(), "Conference")
+
+	// Bob's client can only receive PCMU, while Alice publishes Opus.
+	mediaEngine := &webrtc.MediaEngine{}
+	if err := mediaEngine.RegisterCodec(webrtc.RTPCodecParameters{
+		RTPCodecCapability: webrtc.RTPCodecCapability{MimeType: webrtc.MimeTypePCMU, ClockRate: 8000},
+		PayloadType:        0,
+	}, webrtc.RTPCodecTypeAudio); err != nil {
+		t.Fatalf("Failed to register codec: %v", err)
+	}
+	bobRemote, err := webrtc.NewAPI(webrtc.WithMediaEngine(mediaEngine)).NewPeerConnection(webrtc.Configuration{})
+	if err != nil {
+		t.Fatalf("Failed to create remote peer connection: %v", err)
+	}
+	t.Cleanup(func() { bobRemote.Close() })
+
+	if _, err := bobRemote.AddTransceiverFromKind(
+		webrtc.RTPCodecTypeAudio,
+		webrtc.RTPTransceiverInit{Direction: webrtc.RTPTransceiverDirectionRecvonly},
+	); err != nil {
+		t.Fatalf("Failed to add transceiver: %v", err)
+	}
+
+	alice := participant.ID{UserID: "@alice:example.org", DeviceID: "ALICE", CallID: "call"}
+	bob := participant.ID{UserID: "@bob:example.org", DeviceID: "BOB", CallID: "call"}
+	carol := participant.ID{UserID: "@carol:example.org", DeviceID: "CAROL", CallID: "call"}
+
+	conference := newTestConference(t)
+	tracker := conference.tracker
+
+	tracker.AddParticipant(&participant.Participant{ID: alice, Logger: logger, Telemetry: tel})
+	bobPeer := newSubscriberPeerFor(t, bob, bobRemote)
+	tracker.AddParticipant(&participant.Participant{ID: bob, Peer: bobPeer, Logger: logger, Telemetry: tel})
+	carolPeer := newSubscriberPeer(t, carol)
+	tracker.AddParticipant(&participant.Participant{ID: carol, Peer: carolPeer, Logger: logger, Telemetry: tel})
+
+	for _, remoteTrack := range publishAudioTracks(t, "stream", "mic") {
+		if err := tracker.AddPublishedTrack(alice, remoteTrack, track.TrackMetadata{}); err != nil {
+			t.Fatalf("Failed to publish %s: %v", remoteTrack.ID(), err)
+		}
+	}
+
+	conference.updateMetadata(nil)
+
+	// By default, Bob learns about the track, but can't subscribe to it.
+	if _, found := conference.getAvailableStreamsFor(bob).Metadata["stream"]; !found {
+		t.Error("Expected the stream to be available to bob")
+	}
+	if err := tracker.Subscribe(bob, "mic", 0, 0); !errors.Is(err, track.ErrIncompatibleCodec) {
+		t.Errorf("Expected %v, got %v", track.ErrIncompatibleCodec, err)
+	}
+	if err := tracker.Subscribe(carol, "mic", 0, 0); err != nil {
+		t.Errorf("Failed to subscribe the compatible subscriber: %v", err)
+	}
+
+	// Unless the profile keeps Bob out of that track.
+	conference.profile.IncompatibleCodecPolicy = IncompatibleCodecPolicyHide
+	if available := conference.getAvailableStreamsFor(bob).Metadata; len(available) != 0 {
+		t.Errorf("Expected the incompatible track to be hidden from bob, got %+v", available)
+	}
+	if _, found := conference.getAvailableStreamsFor(carol).Metadata["stream"]; !found {
+		t.Error("Expected the stream to be available to carol")
+	}
+}
@@ -4,7 +4,9 @@ import (
 	"errors"
 	"fmt"
 	"io"
+	"sync/atomic"
 
+	"github.com/pion/rtcp"
 	"github.com/pion/rtp"
 	"github.com/pion/webrtc/v3"
 )
@@ -12,6 +14,8 @@ import (
 type AudioSubscription struct {
 	sender     *webrtc.RTPSender
 	controller SubscriptionController
+	// The latest reception report of the subscriber about the forwarded packets.
+	receptionReport atomic.Pointer[rtcp.ReceptionReport]
 }
 
 func NewAudioSubscription(
@@ -27,7 +31,7 @@ func NewAudioSubscription(
 		return nil, fmt.Errorf("Failed to add track: %s", err)
 	}
 
-	subscription := &AudioSubscription{sender, controller}
+	subscription := &AudioSubscription{sender: sender, controller: controller}
 	go subscription.readRTCP()
 
 	return subscription, nil
@@ -37,18 +41,43 @@ func (s *AudioSubscription) Unsubscribe() error {
 	return s.controller.RemoveTrack(s.sender)
 }
 
+func (s *AudioSubscription) Detach() error {
+	return s.controller.DetachTrack(s.sender)
+}
+
 func (s *AudioSubscription) WriteRTP(packet rtp.Packet) error {
 	return fmt.Errorf("Bug: no write RTP logic for an audio subscription!")
 }
 
+func (s *AudioSubscription) OutgoingSSRC() webrtc.SSRC {
+	return senderSSRC(s.sender)
+}
+
+// Returns the latest reception report that the subscriber sent about the packets we forward (if any).
+func (s *AudioSubscription) ReceptionReport() (rtcp.ReceptionReport, bool) {
+	if report := s.receptionReport.Load(); report != nil {
+		return *report, true
+	}
+
+	return rtcp.ReceptionReport{}, false
+}
+
 func (s *AudioSubscription) readRTCP() {
 	// Read incoming RTCP packets. Before these packets are returned they are processed by interceptors.
 	// For things like NACK this needs to be called.
 	for {
-		if _, _, err := s.sender.ReadRTCP(); err != nil {
+		packets, _, err := s.sender.ReadRTCP()
+		if err != nil {
 			if errors.Is(err, io.ErrClosedPipe) || errors.Is(err, io.EOF) {
 				return
 			}
 		}
+
+		// Remember how the subscriber receives our packets, so that we could tell it about its connection.
+		for _, packet := range packets {
+			if report, ok := packet.(*rtcp.ReceiverReport); ok {
+				storeReceptionReport(&s.receptionReport, s.OutgoingSSRC(), report.Reports)
+			}
+		}
 	}
 }
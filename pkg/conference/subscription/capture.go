@@ -0,0 +1,171 @@
+package subscription
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/pion/rtp"
+)
+
+// Configuration of a debug capture of the packets that are forwarded to the subscriber.
+type CaptureConfig struct {
+	// Where the packets are written to (in the pcap format). Closed once the capture stops.
+	Output io.WriteCloser
+	// For how long the packets are captured.
+	Duration time.Duration
+	// How many bytes may be written at most.
+	MaxSize int
+}
+
+// A capture that is bounded both by time and size. It's safe to stop it from any goroutine.
+type capture struct {
+	mutex sync.Mutex
+	// Nil once the capture is stopped.
+	output io.WriteCloser
+	// Stops the capture once the time is up.
+	timer   *time.Timer
+	written int
+	maxSize int
+}
+
+// Starts a new capture by writing the pcap header.
+func startCapture(config CaptureConfig) (*capture, error) {
+	if config.Duration <= 0 || config.MaxSize <= 0 {
+		config.Output.Close()
+		return nil, fmt.Errorf("capture must be bounded by time and size")
+	}
+
+	header := pcapHeader()
+	if _, err := config.Output.Write(header); err != nil {
+		config.Output.Close()
+		return nil, fmt.Errorf("failed to write pcap header: %w", err)
+	}
+
+	c := &capture{output: config.Output, written: len(header), maxSize: config.MaxSize}
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.timer = time.AfterFunc(config.Duration, c.stop)
+
+	return c, nil
+}
+
+// Writes a packet to the capture. Returns false once the capture is stopped.
+func (c *capture) write(packet *rtp.Packet, now time.Time) bool {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if c.output == nil {
+		return false
+	}
+
+	payload, err := packet.Marshal()
+	if err != nil {
+		c.stopLocked()
+		return false
+	}
+
+	record := pcapRecord(payload, now)
+	if c.written+len(record) > c.maxSize {
+		c.stopLocked()
+		return false
+	}
+
+	if _, err := c.output.Write(record); err != nil {
+		c.stopLocked()
+		return false
+	}
+
+	c.written += len(record)
+	return true
+}
+
+// Stops the capture and closes the output (does nothing if already stopped).
+func (c *capture) stop() {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	c.stopLocked()
+}
+
+func (c *capture) stopLocked() {
+	if c.output == nil {
+		return
+	}
+
+	c.timer.Stop()
+	c.output.Close()
+	c.output = nil
+}
+
+// The packets are captured as UDP datagrams inside of raw IPv4 packets, so that Wireshark could decode them
+// as RTP. The addresses and ports are fake, they only make the captures look like regular network traffic.
+const (
+	pcapLinkTypeRaw = 101
+	pcapSnapLength  = 65535
+	ipv4HeaderSize  = 20
+	udpHeaderSize   = 8
+	captureUDPPort  = 5004
+)
+
+var (
+	captureSourceIP      = [4]byte{10, 0, 0, 1}
+	captureDestinationIP = [4]byte{10, 0, 0, 2}
+)
+
+// Returns the global header of the pcap file.
+func pcapHeader() []byte {
+	header := make([]byte, 24)
+	binary.LittleEndian.PutUint32(header[0:], 0xa1b2c3d4)
+	binary.LittleEndian.PutUint16(header[4:], 2)
+	binary.LittleEndian.PutUint16(header[6:], 4)
+	binary.LittleEndian.PutUint32(header[16:], pcapSnapLength)
+	binary.LittleEndian.PutUint32(header[20:], pcapLinkTypeRaw)
+
+	return header
+}
+
+// Returns a pcap record with a given RTP packet.
+func pcapRecord(payload []byte, now time.Time) []byte {
+	length := ipv4HeaderSize + udpHeaderSize + len(payload)
+
+	record := make([]byte, 16+length)
+	binary.LittleEndian.PutUint32(record[0:], uint32(now.Unix()))
+	binary.LittleEndian.PutUint32(record[4:], uint32(now.Nanosecond()/1000))
+	binary.LittleEndian.PutUint32(record[8:], uint32(length))
+	binary.LittleEndian.PutUint32(record[12:], uint32(length))
+
+	ip := record[16 : 16+ipv4HeaderSize]
+	ip[0] = 0x45 // IPv4, 5 words of header.
+	binary.BigEndian.PutUint16(ip[2:], uint16(length))
+	ip[8] = 64 // TTL.
+	ip[9] = 17 // UDP.
+	copy(ip[12:], captureSourceIP[:])
+	copy(ip[16:], captureDestinationIP[:])
+	binary.BigEndian.PutUint16(ip[10:], ipv4Checksum(ip))
+
+	// The UDP checksum is optional for IPv4, so we leave it empty.
+	udp := record[16+ipv4HeaderSize : 16+ipv4HeaderSize+udpHeaderSize]
+	binary.BigEndian.PutUint16(udp[0:], captureUDPPort)
+	binary.BigEndian.PutUint16(udp[2:], captureUDPPort)
+	binary.BigEndian.PutUint16(udp[4:], uint16(udpHeaderSize+len(payload)))
+
+	copy(record[16+ipv4HeaderSize+udpHeaderSize:], payload)
+	return record
+}
+
+func ipv4Checksum(header []byte) uint16 {
+	var sum uint32
+	for i := 0; i < len(header); i += 2 {
+		sum += uint32(binary.BigEndian.Uint16(header[i:]))
+	}
+
+	for sum > 0xffff {
+		sum = (sum & 0xffff) + (sum >> 16)
+	}
+
+	return ^uint16(sum)
+}
@@ -0,0 +1,164 @@
+package subscription //nolint:testpackage
+
+import (
+	"encoding/binary"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/pion/rtp"
+)
+
+// Parses a pcap file written by the capture and returns the RTP packets.
+func readCapture(t *testing.T, data []byte) []rtp.Packet {
+	t.Helper()
+
+	if len(data) < 24 {
+		t.Fatalf("The capture is too short: %d bytes", len(data))
+	}
+
+	if magic := binary.LittleEndian.Uint32(data[0:]); magic != 0xa1b2c3d4 {
+		t.Fatalf("Unexpected magic number: %x", magic)
+	}
+
+	if linkType := binary.LittleEndian.Uint32(data[20:]); linkType != pcapLinkTypeRaw {
+		t.Fatalf("Unexpected link type: %d", linkType)
+	}
+
+	packets := []rtp.Packet{}
+	for data = data[24:]; len(data) > 0; {
+		if len(data) < 16 {
+			t.Fatalf("Truncated record header")
+		}
+
+		length := int(binary.LittleEndian.Uint32(data[8:]))
+		if length != int(binary.LittleEndian.Uint32(data[12:])) || len(data) < 16+length {
+			t.Fatalf("Truncated record")
+		}
+
+		ip := data[16 : 16+length]
+		if ip[0] != 0x45 || ip[9] != 17 || int(binary.BigEndian.Uint16(ip[2:])) != length {
+			t.Fatalf("Malformed IPv4 header: %x", ip[:ipv4HeaderSize])
+		}
+
+		if ipv4Checksum(ip[:ipv4HeaderSize]) != 0 {
+			t.Errorf("Invalid IPv4 header checksum")
+		}
+
+		udp := ip[ipv4HeaderSize:]
+		if int(binary.BigEndian.Uint16(udp[4:])) != len(udp) {
+			t.Fatalf("Malformed UDP header: %x", udp[:udpHeaderSize])
+		}
+
+		var packet rtp.Packet
+		if err := packet.Unmarshal(udp[udpHeaderSize:]); err != nil {
+			t.Fatalf("Failed to unmarshal RTP packet: %v", err)
+		}
+
+		packets = append(packets, packet)
+		data = data[16+length:]
+	}
+
+	return packets
+}
+
+func TestCapture(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "capture.pcap")
+	output, err := os.Create(path)
+	if err != nil {
+		t.Fatalf("Failed to create capture file: %v", err)
+	}
+
+	capture, err := startCapture(CaptureConfig{Output: output, Duration: time.Minute, MaxSize: 1 << 20})
+	if err != nil {
+		t.Fatalf("Failed to start capture: %v", err)
+	}
+
+	for i := uint16(0); i < 3; i++ {
+		packet := &rtp.Packet{
+			Header:  rtp.Header{Version: 2, SSRC: 42, SequenceNumber: 100 + i, Timestamp: 3000 * uint32(i)},
+			Payload: []byte{0x10, 0x01, 0x02},
+		}
+
+		if !capture.write(packet, time.Now()) {
+			t.Fatalf("Expected packet %d to be captured", i)
+		}
+	}
+
+	capture.stop()
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("Failed to read capture: %v", err)
+	}
+
+	packets := readCapture(t, data)
+	if len(packets) != 3 {
+		t.Fatalf("Expected 3 packets, got %d", len(packets))
+	}
+
+	for i, packet := range packets {
+		if packet.SSRC != 42 || packet.SequenceNumber != 100+uint16(i) || len(packet.Payload) != 3 {
+			t.Errorf("Unexpected packet %d: %v", i, packet.Header)
+		}
+	}
+
+	// Nothing is written once the capture is stopped.
+	if capture.write(&rtp.Packet{Header: rtp.Header{Version: 2}}, time.Now()) {
+		t.Error("Expected the stopped capture to reject packets")
+	}
+}
+
+func TestCaptureLimits(t *testing.T) {
+	packet := &rtp.Packet{Header: rtp.Header{Version: 2}, Payload: make([]byte, 100)}
+
+	t.Run("size", func(t *testing.T) {
+		output, err := os.Create(filepath.Join(t.TempDir(), "capture.pcap"))
+		if err != nil {
+			t.Fatalf("Failed to create capture file: %v", err)
+		}
+
+		// Enough for the header and a single packet.
+		capture, err := startCapture(CaptureConfig{Output: output, Duration: time.Minute, MaxSize: 200})
+		if err != nil {
+			t.Fatalf("Failed to start capture: %v", err)
+		}
+
+		if !capture.write(packet, time.Now()) {
+			t.Fatal("Expected the first packet to be captured")
+		}
+
+		if capture.write(packet, time.Now()) {
+			t.Error("Expected the capture to stop once the size limit is reached")
+		}
+	})
+
+	t.Run("time", func(t *testing.T) {
+		output, err := os.Create(filepath.Join(t.TempDir(), "capture.pcap"))
+		if err != nil {
+			t.Fatalf("Failed to create capture file: %v", err)
+		}
+
+		capture, err := startCapture(CaptureConfig{Output: output, Duration: 10 * time.Millisecond, MaxSize: 1 << 20})
+		if err != nil {
+			t.Fatalf("Failed to start capture: %v", err)
+		}
+
+		time.Sleep(50 * time.Millisecond)
+		if capture.write(packet, time.Now()) {
+			t.Error("Expected the capture to stop once the time is up")
+		}
+	})
+
+	t.Run("unbounded", func(t *testing.T) {
+		output, err := os.Create(filepath.Join(t.TempDir(), "capture.pcap"))
+		if err != nil {
+			t.Fatalf("Failed to create capture file: %v", err)
+		}
+
+		if _, err := startCapture(CaptureConfig{Output: output}); err == nil {
+			t.Error("Expected an unbounded capture to be rejected")
+		}
+	})
+}
@@ -0,0 +1,69 @@
+package subscription
+
+import (
+	"time"
+
+	"github.com/matrix-org/waterfall/pkg/worker"
+	"github.com/pion/rtp"
+)
+
+// Configuration of the video subscriptions. Zero values mean "use the default".
+type Config struct {
+	// How many packets may wait to be forwarded to the subscriber before the new ones get dropped.
+	ChannelSize int
+	// After which time without packets the worker of the subscription times out.
+	Timeout time.Duration
+	// The playout delay to add to the forwarded packets (disabled if nil).
+	PlayoutDelay *PlayoutDelay
+	// The payload is end-to-end encrypted, so we must not inspect it (no key frame
+	// detection, no frame boundaries). Only the RTP headers are rewritten.
+	Encrypted bool
+	// For how long the out-of-order packets wait for the missing ones before
+	// the gap is skipped (the packets are not reordered if not set).
+	ReorderWindow time.Duration
+	// How many out-of-order packets may wait for the missing ones at most.
+	ReorderBufferSize int
+	// Don't forward the padding-only packets (that the publishers send to probe the bandwidth).
+	// Some subscribers rely on them to estimate their own bandwidth though.
+	DropPadding bool
+	// What happens to the packets of a subscriber that can't keep up (request a key frame if not set).
+	DropPolicy DropPolicy
+}
+
+// Defines how a subscriber that can't keep up with the publisher is treated. Its packets are dropped
+// once its queue (`ChannelSize`) is full either way: the publisher is never blocked, so that a slow
+// subscriber does not hold up the other subscribers of the track.
+type DropPolicy string
+
+const (
+	// Drop the packets and request a key frame once the subscriber catches up, since it
+	// can't decode the video after the gap until the next key frame anyway (default).
+	DropPolicyKeyFrame DropPolicy = "keyframe"
+	// Only drop the packets and leave it up to the subscriber to recover (e.g. by sending a PLI).
+	DropPolicyDrop DropPolicy = "drop"
+)
+
+const (
+	// We really don't need a large buffer by default, just to account for spikes.
+	defaultChannelSize = 16
+	defaultTimeout     = 1 * time.Hour
+	// Enough for a couple of frames of a high-bitrate track.
+	defaultReorderBufferSize = 32
+)
+
+// Creates the configuration for the worker of the video subscription.
+func newWorkerConfig(config Config, onTask func(rtp.Packet)) worker.Config[rtp.Packet] {
+	if config.ChannelSize <= 0 {
+		config.ChannelSize = defaultChannelSize
+	}
+	if config.Timeout <= 0 {
+		config.Timeout = defaultTimeout
+	}
+
+	return worker.Config[rtp.Packet]{
+		ChannelSize: config.ChannelSize,
+		Timeout:     config.Timeout,
+		OnTimeout:   func() {},
+		OnTask:      onTask,
+	}
+}
@@ -0,0 +1,55 @@
+package subscription //nolint:testpackage
+
+import (
+	"testing"
+
+	"github.com/matrix-org/waterfall/pkg/worker"
+	"github.com/pion/rtp"
+)
+
+// Sends a burst of packets to a worker that is busy and returns the amount of dropped packets.
+func dropsUnderBurst(t *testing.T, config Config, burst int) int {
+	t.Helper()
+
+	unblock := make(chan struct{})
+	defer close(unblock)
+
+	started := make(chan struct{})
+	w := worker.StartWorker(newWorkerConfig(config, func(rtp.Packet) {
+		select {
+		case started <- struct{}{}:
+		default:
+		}
+		<-unblock
+	}))
+	defer w.Stop()
+
+	// Make sure that the worker is busy processing the first packet.
+	if err := w.Send(rtp.Packet{}); err != nil {
+		t.Fatalf("Failed to send the first packet: %v", err)
+	}
+	<-started
+
+	drops := 0
+	for i := 0; i < burst; i++ {
+		if err := w.Send(rtp.Packet{}); err != nil {
+			drops++
+		}
+	}
+
+	return drops
+}
+
+func TestLargerBufferReducesDrops(t *testing.T) {
+	const burst = 64
+
+	defaultDrops := dropsUnderBurst(t, Config{}, burst)
+	if defaultDrops != burst-defaultChannelSize {
+		t.Errorf("Expected %d drops with the default buffer, got %d", burst-defaultChannelSize, defaultDrops)
+	}
+
+	largerDrops := dropsUnderBurst(t, Config{ChannelSize: burst}, burst)
+	if largerDrops != 0 {
+		t.Errorf("Expected no drops with a buffer of %d, got %d", burst, largerDrops)
+	}
+}
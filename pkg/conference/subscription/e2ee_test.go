@@ -0,0 +1,46 @@
+package subscription //nolint:testpackage
+
+import (
+	"context"
+	"testing"
+
+	"github.com/matrix-org/waterfall/pkg/conference/subscription/rewriter"
+	"github.com/matrix-org/waterfall/pkg/telemetry"
+	"github.com/pion/rtp"
+	"github.com/pion/webrtc/v3"
+)
+
+func TestNoPayloadInspectionForEncryptedTracks(t *testing.T) {
+	for _, encrypted := range []bool{true, false} {
+		rtpTrack, err := webrtc.NewTrackLocalStaticRTP(
+			webrtc.RTPCodecCapability{MimeType: webrtc.MimeTypeVP8, ClockRate: 90000},
+			"track",
+			"stream",
+		)
+		if err != nil {
+			t.Fatalf("Failed to create track: %v", err)
+		}
+
+		worker := workerState{
+			packetRewriter:  rewriter.NewPacketRewriter(),
+			rtpTrack:        rtpTrack,
+			mimeType:        webrtc.MimeTypeVP8,
+			frameGate:       newFrameGate(webrtc.MimeTypeVP8),
+			encrypted:       encrypted,
+			keyFrameLatency: newKeyFrameLatency(),
+			telemetry:       telemetry.NewTelemetry(context.Background(), "VideoSubscription"),
+		}
+		worker.keyFrameLatency.requested()
+
+		// The (encrypted) payload looks like a VP8 key frame.
+		worker.handlePacket(rtp.Packet{
+			Header:  rtp.Header{SSRC: 1111, SequenceNumber: 1},
+			Payload: []byte{0x10, 0x00, 0x00, 0x00},
+		})
+
+		inspected := worker.frameGate.started || worker.keyFrameLatency.requestedAt.Load() == 0
+		if inspected == encrypted {
+			t.Errorf("Encrypted: %v, expected payload inspection: %v, got %v", encrypted, !encrypted, inspected)
+		}
+	}
+}
@@ -0,0 +1,59 @@
+package subscription
+
+import (
+	"strings"
+
+	"github.com/matrix-org/waterfall/pkg/conference/subscription/rewriter"
+	"github.com/pion/rtp"
+	"github.com/pion/webrtc/v3"
+)
+
+// Makes sure that we only start forwarding VP8 packets on the frame boundaries. Forwarding a frame
+// that misses its beginning (e.g. after a layer switch) or some packets in the middle (e.g. when
+// the packets are dropped since the subscriber can't keep up) corrupts the frame on the subscriber's
+// side, so once that happens, we drop the rest of the frame and resume with the next one.
+type vp8FrameGate struct {
+	// Whether we're dropping the packets until the start of the next frame.
+	dropping bool
+	// The SSRC and the sequence number of the last packet that we have seen.
+	ssrc           uint32
+	sequenceNumber uint16
+	// Whether we have seen any packet yet.
+	started bool
+}
+
+// Returns a gate for the codecs that we can handle or nil if we don't understand the codec.
+func newFrameGate(mimeType string) *vp8FrameGate {
+	if strings.EqualFold(mimeType, webrtc.MimeTypeVP8) {
+		return &vp8FrameGate{}
+	}
+
+	return nil
+}
+
+// Checks if the packet can be forwarded. A nil gate forwards all packets.
+func (g *vp8FrameGate) forward(packet rtp.Packet) bool {
+	if g == nil {
+		return true
+	}
+
+	switch {
+	case !g.started || packet.SSRC != g.ssrc:
+		// The very first packet or a layer switch: we could be in the middle of a frame.
+		g.dropping = true
+	case packet.SequenceNumber-g.sequenceNumber > 1 && packet.SequenceNumber-g.sequenceNumber < 0x8000:
+		// Some packets are missing, so the current frame is incomplete.
+		g.dropping = true
+	case packet.SequenceNumber-g.sequenceNumber >= 0x8000:
+		// A late (reordered or retransmitted) packet, forward it unless we're dropping the frame.
+		return !g.dropping
+	}
+
+	g.started, g.ssrc, g.sequenceNumber = true, packet.SSRC, packet.SequenceNumber
+
+	if g.dropping && rewriter.IsVP8FrameStart(packet) {
+		g.dropping = false
+	}
+
+	return !g.dropping
+}
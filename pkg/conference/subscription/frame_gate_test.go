@@ -0,0 +1,60 @@
+package subscription //nolint:testpackage
+
+import (
+	"testing"
+
+	"github.com/pion/rtp"
+	"github.com/pion/webrtc/v3"
+)
+
+// Creates a VP8 packet that either starts a frame (S bit set, partition 0) or continues it.
+func vp8Packet(ssrc uint32, sequenceNumber uint16, frameStart bool) rtp.Packet {
+	descriptor := byte(0x00)
+	if frameStart {
+		descriptor = 0x10
+	}
+
+	return rtp.Packet{
+		Header:  rtp.Header{SSRC: ssrc, SequenceNumber: sequenceNumber},
+		Payload: []byte{descriptor, 0x01, 0x02, 0x03, 0x04},
+	}
+}
+
+func TestVP8FrameGate(t *testing.T) {
+	cases := []struct {
+		name     string
+		packet   rtp.Packet
+		expected bool
+	}{
+		{"first packet in the middle of a frame", vp8Packet(1111, 10, false), false},
+		{"rest of the frame", vp8Packet(1111, 11, false), false},
+		{"start of a 3-packet frame", vp8Packet(1111, 12, true), true},
+		{"middle of a 3-packet frame", vp8Packet(1111, 13, false), true},
+		{"end of a 3-packet frame", vp8Packet(1111, 14, false), true},
+		{"start of a 3-packet frame", vp8Packet(1111, 15, true), true},
+		{"packet after a gap", vp8Packet(1111, 17, false), false},
+		{"late packet of a dropped frame", vp8Packet(1111, 16, false), false},
+		{"start of the next frame", vp8Packet(1111, 18, true), true},
+		{"middle of the next frame", vp8Packet(1111, 19, false), true},
+		{"layer switch in the middle of a frame", vp8Packet(2222, 500, false), false},
+		{"start of a frame on the new layer", vp8Packet(2222, 501, true), true},
+		{"continuation on the new layer", vp8Packet(2222, 502, false), true},
+		{"layer switch on a frame boundary", vp8Packet(3333, 65535, true), true},
+		{"sequence number wrap around", vp8Packet(3333, 0, false), true},
+	}
+
+	gate := newFrameGate(webrtc.MimeTypeVP8)
+	for _, c := range cases {
+		if forwarded := gate.forward(c.packet); forwarded != c.expected {
+			t.Errorf("%s (seq %d): expected forwarded=%v, got %v",
+				c.name, c.packet.SequenceNumber, c.expected, forwarded)
+		}
+	}
+}
+
+func TestFrameGateUnknownCodec(t *testing.T) {
+	gate := newFrameGate(webrtc.MimeTypeH264)
+	if !gate.forward(vp8Packet(1111, 10, false)) {
+		t.Error("Expected all packets of unsupported codecs to be forwarded")
+	}
+}
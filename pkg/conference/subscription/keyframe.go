@@ -0,0 +1,139 @@
+package subscription
+
+import (
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/matrix-org/waterfall/pkg/conference/subscription/rewriter"
+	"github.com/pion/rtp"
+	"github.com/pion/rtp/codecs"
+	"github.com/pion/webrtc/v3"
+)
+
+// Measures the time between a key frame request from the subscriber and
+// the moment the next key frame is forwarded to the subscriber.
+type keyFrameLatency struct {
+	// Time (in Unix nanoseconds) of the pending key frame request or 0 if there is none.
+	requestedAt atomic.Int64
+	// Source of the current time.
+	now func() time.Time
+}
+
+func newKeyFrameLatency() *keyFrameLatency {
+	return &keyFrameLatency{now: time.Now}
+}
+
+// Records the key frame request. Subsequent requests don't restart the measurement
+// until the key frame arrives, since it's the first request that the user waits for.
+func (k *keyFrameLatency) requested() {
+	k.requestedAt.CompareAndSwap(0, k.now().UnixNano())
+}
+
+// Informs about a forwarded key frame. Returns the latency if there was a pending request.
+func (k *keyFrameLatency) received() (time.Duration, bool) {
+	requestedAt := k.requestedAt.Swap(0)
+	if requestedAt == 0 {
+		return 0, false
+	}
+
+	return k.now().Sub(time.Unix(0, requestedAt)), true
+}
+
+// Withholds the packets until the first key frame of a layer arrives, i.e. after the subscription starts or
+// switches to another layer (the publisher is asked for a key frame on both occasions), so that the subscriber
+// never starts decoding in the middle of a group of pictures.
+type keyFrameGate struct {
+	mimeType string
+	// Whether a key frame of the layer that we forward has been seen.
+	open bool
+	// The SSRC of the layer that we forward.
+	ssrc uint32
+}
+
+// Returns a gate for the codecs whose key frames we can detect or nil if we don't understand the codec.
+func newKeyFrameGate(mimeType string) *keyFrameGate {
+	switch strings.ToLower(mimeType) {
+	case strings.ToLower(webrtc.MimeTypeVP8), strings.ToLower(webrtc.MimeTypeVP9), strings.ToLower(webrtc.MimeTypeH264):
+		return &keyFrameGate{mimeType: mimeType}
+	default:
+		return nil
+	}
+}
+
+// Checks if the packet can be forwarded. A nil gate forwards all packets.
+func (g *keyFrameGate) forward(packet rtp.Packet) bool {
+	if g == nil {
+		return true
+	}
+
+	if g.open && packet.SSRC == g.ssrc {
+		return true
+	}
+
+	g.open, g.ssrc = isKeyFrame(g.mimeType, packet), packet.SSRC
+	return g.open
+}
+
+// Determines if a given packet starts a key frame. Unknown codecs are never treated as key frames.
+func isKeyFrame(mimeType string, packet rtp.Packet) bool {
+	switch strings.ToLower(mimeType) {
+	case strings.ToLower(webrtc.MimeTypeVP8):
+		return rewriter.IsVP8Keyframe(packet)
+	case strings.ToLower(webrtc.MimeTypeVP9):
+		return isVP9KeyFrame(packet)
+	case strings.ToLower(webrtc.MimeTypeH264):
+		return isH264KeyFrame(packet)
+	default:
+		return false
+	}
+}
+
+// A VP9 key frame starts with a packet that begins a frame (B bit) which is not inter-picture predicted (P bit).
+func isVP9KeyFrame(packet rtp.Packet) bool {
+	vp9Packet := codecs.VP9Packet{}
+	if _, err := vp9Packet.Unmarshal(packet.Payload); err != nil {
+		return false
+	}
+
+	return vp9Packet.B && !vp9Packet.P
+}
+
+// An H.264 key frame is an IDR picture, possibly preceded by the SPS, both of which may be
+// aggregated (STAP-A) or fragmented (FU-A).
+func isH264KeyFrame(packet rtp.Packet) bool {
+	const (
+		naluTypeIDR  = 5
+		naluTypeSPS  = 7
+		naluTypeSTAP = 24
+		naluTypeFU   = 28
+	)
+
+	payload := packet.Payload
+	if len(payload) < 2 {
+		return false
+	}
+
+	isKeyFrameNALU := func(naluType byte) bool {
+		return naluType == naluTypeIDR || naluType == naluTypeSPS
+	}
+
+	switch naluType := payload[0] & 0x1F; naluType {
+	case naluTypeSTAP:
+		// Each aggregated NALU is prefixed by its 16-bit size.
+		for offset := 1; offset+2 < len(payload); {
+			size := int(payload[offset])<<8 | int(payload[offset+1])
+			if isKeyFrameNALU(payload[offset+2] & 0x1F) {
+				return true
+			}
+			offset += 2 + size
+		}
+		return false
+	case naluTypeFU:
+		// Only the first fragment (S bit) starts the key frame.
+		isStart := payload[1]&0x80 != 0
+		return isStart && isKeyFrameNALU(payload[1]&0x1F)
+	default:
+		return isKeyFrameNALU(naluType)
+	}
+}
@@ -0,0 +1,121 @@
+package subscription //nolint:testpackage
+
+import (
+	"testing"
+	"time"
+
+	"github.com/matrix-org/waterfall/pkg/metrics"
+	"github.com/pion/rtp"
+	"github.com/pion/webrtc/v3"
+)
+
+func TestKeyFrameDetection(t *testing.T) {
+	cases := []struct {
+		name     string
+		mimeType string
+		payload  []byte
+		keyFrame bool
+	}{
+		// VP8 descriptor with the S bit set followed by a payload header with P bit = 0.
+		{"VP8 key frame", webrtc.MimeTypeVP8, []byte{0x10, 0x00, 0x00, 0x00}, true},
+		{"VP8 inter frame", webrtc.MimeTypeVP8, []byte{0x10, 0x01, 0x00, 0x00}, false},
+		{"H264 IDR", webrtc.MimeTypeH264, []byte{0x65, 0x88}, true},
+		{"H264 non-IDR", webrtc.MimeTypeH264, []byte{0x41, 0x9A}, false},
+		{"H264 STAP-A with SPS", webrtc.MimeTypeH264, []byte{0x78, 0x00, 0x02, 0x67, 0x42}, true},
+		{"H264 FU-A IDR start", webrtc.MimeTypeH264, []byte{0x7C, 0x85, 0x88}, true},
+		{"H264 FU-A IDR middle", webrtc.MimeTypeH264, []byte{0x7C, 0x05, 0x88}, false},
+		{"Unknown codec", webrtc.MimeTypeOpus, []byte{0x10, 0x00, 0x00}, false},
+	}
+
+	for _, c := range cases {
+		if got := isKeyFrame(c.mimeType, rtp.Packet{Payload: c.payload}); got != c.keyFrame {
+			t.Errorf("%s: expected %v, got %v", c.name, c.keyFrame, got)
+		}
+	}
+}
+
+func TestKeyFrameLatency(t *testing.T) {
+	now := time.Unix(1000, 0)
+	latency := newKeyFrameLatency()
+	latency.now = func() time.Time { return now }
+
+	state := workerState{mimeType: webrtc.MimeTypeVP8, keyFrameLatency: latency}
+	histogram := metrics.NewHistogram("")
+
+	process := func(payload []byte) {
+		if elapsed, ok := state.keyFrameReceived(rtp.Packet{Payload: payload}); ok {
+			histogram.Observe(float64(elapsed.Milliseconds()))
+		}
+	}
+
+	// A key frame without a request is not measured.
+	process([]byte{0x10, 0x00, 0x00, 0x00})
+	if histogram.Count() != 0 {
+		t.Fatalf("Expected no measurements without a PLI, got %d", histogram.Count())
+	}
+
+	// PLI, then an inter frame and a repeated PLI, then a key frame.
+	latency.requested()
+	now = now.Add(100 * time.Millisecond)
+	process([]byte{0x10, 0x01, 0x00, 0x00})
+	latency.requested()
+	now = now.Add(150 * time.Millisecond)
+	process([]byte{0x10, 0x00, 0x00, 0x00})
+
+	if histogram.Count() != 1 {
+		t.Fatalf("Expected a single measurement, got %d", histogram.Count())
+	}
+
+	if p50 := histogram.Quantile(0.5); p50 != 250 {
+		t.Errorf("Expected latency of 250ms, got %v", p50)
+	}
+}
+
+func TestKeyFrameGate(t *testing.T) {
+	// VP8 descriptor with the S bit set followed by a payload header with a given P bit.
+	packet := func(ssrc uint32, sequenceNumber uint16, keyFrame bool) rtp.Packet {
+		payload := []byte{0x10, 0x01, 0x00, 0x00}
+		if keyFrame {
+			payload[1] = 0x00
+		}
+
+		return rtp.Packet{Header: rtp.Header{SSRC: ssrc, SequenceNumber: sequenceNumber}, Payload: payload}
+	}
+
+	cases := []struct {
+		name     string
+		packet   rtp.Packet
+		expected bool
+	}{
+		{"inter frame before the first key frame", packet(1111, 10, false), false},
+		{"another inter frame before the first key frame", packet(1111, 11, false), false},
+		{"first key frame", packet(1111, 12, true), true},
+		{"inter frame after the key frame", packet(1111, 13, false), true},
+		{"layer switch to an inter frame", packet(2222, 500, false), false},
+		{"key frame on the new layer", packet(2222, 501, true), true},
+		{"inter frame on the new layer", packet(2222, 502, false), true},
+		{"switch back to the previous layer", packet(1111, 14, false), false},
+	}
+
+	gate := newKeyFrameGate(webrtc.MimeTypeVP8)
+	for _, c := range cases {
+		if forwarded := gate.forward(c.packet); forwarded != c.expected {
+			t.Errorf("%s (seq %d): expected forwarded=%v, got %v",
+				c.name, c.packet.SequenceNumber, c.expected, forwarded)
+		}
+	}
+}
+
+func TestKeyFrameGateCodecs(t *testing.T) {
+	nonIDR := rtp.Packet{Header: rtp.Header{SSRC: 1111}, Payload: []byte{0x41, 0x9A}}
+	idr := rtp.Packet{Header: rtp.Header{SSRC: 1111}, Payload: []byte{0x65, 0x88}}
+
+	gate := newKeyFrameGate(webrtc.MimeTypeH264)
+	if gate.forward(nonIDR) || !gate.forward(idr) || !gate.forward(nonIDR) {
+		t.Error("Expected the H264 packets to be withheld until the first IDR")
+	}
+
+	if gate := newKeyFrameGate(webrtc.MimeTypeAV1); !gate.forward(nonIDR) {
+		t.Error("Expected all packets of unsupported codecs to be forwarded")
+	}
+}
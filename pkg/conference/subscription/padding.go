@@ -0,0 +1,9 @@
+package subscription
+
+import "github.com/pion/rtp"
+
+// Checks if the packet carries nothing but padding, i.e. it's been sent to probe the bandwidth.
+// The padding is stripped from the payload when the packet is parsed, so such packets have no payload.
+func isPaddingOnly(packet rtp.Packet) bool {
+	return packet.Padding && len(packet.Payload) == 0
+}
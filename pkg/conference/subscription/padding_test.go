@@ -0,0 +1,49 @@
+package subscription //nolint:testpackage
+
+import (
+	"context"
+	"testing"
+
+	"github.com/matrix-org/waterfall/pkg/conference/subscription/rewriter"
+	"github.com/matrix-org/waterfall/pkg/telemetry"
+	"github.com/pion/rtp"
+	"github.com/pion/webrtc/v3"
+)
+
+func TestPaddingOnlyPacketsAreDropped(t *testing.T) {
+	for _, dropPadding := range []bool{true, false} {
+		rtpTrack, err := webrtc.NewTrackLocalStaticRTP(
+			webrtc.RTPCodecCapability{MimeType: webrtc.MimeTypeVP8, ClockRate: 90000},
+			"track",
+			"stream",
+		)
+		if err != nil {
+			t.Fatalf("Failed to create track: %v", err)
+		}
+
+		worker := workerState{
+			packetRewriter:  rewriter.NewPacketRewriter(),
+			rtpTrack:        rtpTrack,
+			mimeType:        webrtc.MimeTypeVP8,
+			encrypted:       true,
+			dropPadding:     dropPadding,
+			keyFrameLatency: newKeyFrameLatency(),
+			telemetry:       telemetry.NewTelemetry(context.Background(), "VideoSubscription"),
+		}
+
+		worker.handlePacket(rtp.Packet{Header: rtp.Header{SSRC: 1111, SequenceNumber: 1}, Payload: []byte{1}})
+		worker.handlePacket(rtp.Packet{Header: rtp.Header{SSRC: 1111, SequenceNumber: 2, Padding: true}})
+		worker.handlePacket(rtp.Packet{Header: rtp.Header{SSRC: 1111, SequenceNumber: 3}, Payload: []byte{1}})
+
+		// The dropped padding must not leave a gap in the sequence numbers that the subscriber gets.
+		expected := uint16(3)
+		if dropPadding {
+			expected = 2
+		}
+
+		next := worker.packetRewriter.ProcessIncoming(rtp.Packet{Header: rtp.Header{SSRC: 1111, SequenceNumber: 4}})
+		if next.SequenceNumber != expected {
+			t.Errorf("Drop padding: %v, expected the next packet to be %d, got %d", dropPadding, expected, next.SequenceNumber)
+		}
+	}
+}
@@ -0,0 +1,60 @@
+package subscription
+
+import (
+	"github.com/matrix-org/waterfall/pkg/webrtc_ext"
+	"github.com/pion/rtp"
+	"github.com/pion/webrtc/v3"
+)
+
+// The limits of the receiver's jitter buffer (in milliseconds) that the SFU asks the subscribers to use.
+// Lower values reduce the latency at the cost of the smoothness.
+type PlayoutDelay struct {
+	Min int `yaml:"min"`
+	Max int `yaml:"max"`
+}
+
+// The playout delay is transmitted in the units of 10 ms using 12 bits per value.
+const (
+	playoutDelayGranularity = 10
+	playoutDelayMaxValue    = 0xFFF
+)
+
+// Encodes the playout delay into the payload of the header extension.
+func (d PlayoutDelay) marshal() []byte {
+	encode := func(ms int) int {
+		value := ms / playoutDelayGranularity
+		if value < 0 {
+			return 0
+		}
+		if value > playoutDelayMaxValue {
+			return playoutDelayMaxValue
+		}
+		return value
+	}
+
+	minDelay, maxDelay := encode(d.Min), encode(d.Max)
+	return []byte{
+		byte(minDelay >> 4),
+		byte(minDelay<<4) | byte(maxDelay>>8),
+		byte(maxDelay),
+	}
+}
+
+// Returns the ID of the playout delay extension negotiated for the sender or 0 if it's not negotiated (yet).
+func playoutDelayExtensionID(sender *webrtc.RTPSender) uint8 {
+	for _, extension := range sender.GetParameters().HeaderExtensions {
+		if extension.URI == webrtc_ext.PlayoutDelayURI {
+			return uint8(extension.ID)
+		}
+	}
+
+	return 0
+}
+
+// Adds the playout delay extension to the packet.
+func setPlayoutDelay(packet *rtp.Packet, extensionID uint8, delay PlayoutDelay) error {
+	// The extensions of the packet are shared with other subscriptions of the same track,
+	// so we must not modify them in place.
+	packet.Header.Extensions = append([]rtp.Extension(nil), packet.Header.Extensions...)
+	return packet.Header.SetExtension(extensionID, delay.marshal())
+}
@@ -0,0 +1,44 @@
+package subscription //nolint:testpackage
+
+import (
+	"testing"
+
+	"github.com/pion/rtp"
+)
+
+func TestPlayoutDelayExtension(t *testing.T) {
+	const extensionID = 5
+
+	original := rtp.Packet{Header: rtp.Header{Version: 2, SequenceNumber: 1}, Payload: []byte{0x01}}
+	forwarded := original
+
+	if err := setPlayoutDelay(&forwarded, extensionID, PlayoutDelay{Min: 100, Max: 400}); err != nil {
+		t.Fatalf("Failed to set playout delay: %v", err)
+	}
+
+	// Make sure that the extension survives the serialization.
+	raw, err := forwarded.Marshal()
+	if err != nil {
+		t.Fatalf("Failed to marshal packet: %v", err)
+	}
+
+	var received rtp.Packet
+	if err := received.Unmarshal(raw); err != nil {
+		t.Fatalf("Failed to unmarshal packet: %v", err)
+	}
+
+	payload := received.GetExtension(extensionID)
+	if len(payload) != 3 {
+		t.Fatalf("Expected a 3 byte playout delay extension, got %v", payload)
+	}
+
+	minDelay := int(payload[0])<<4 | int(payload[1])>>4
+	maxDelay := int(payload[1]&0x0F)<<8 | int(payload[2])
+	if minDelay != 10 || maxDelay != 40 {
+		t.Errorf("Expected min 10 and max 40 (in 10ms units), got %d and %d", minDelay, maxDelay)
+	}
+
+	if len(original.Extensions) != 0 {
+		t.Errorf("The original packet must not be modified")
+	}
+}
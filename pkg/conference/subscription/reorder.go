@@ -0,0 +1,132 @@
+package subscription
+
+import (
+	"sort"
+	"time"
+
+	"github.com/pion/rtp"
+)
+
+// Holds the packets that arrive out of order for a short while, so that they could be forwarded in the
+// order of their sequence numbers. A missing packet is waited for until the oldest buffered packet has
+// spent `window` in the buffer or until the buffer is full, then the gap is skipped. The buffer is checked
+// when a new packet arrives and, for the streams that pause, by `expire()` once no packets came for a while.
+type reorderBuffer struct {
+	// For how long we wait for a missing packet.
+	window time.Duration
+	// How many packets we buffer at most.
+	size int
+
+	// Whether we're forwarding any SSRC yet.
+	started bool
+	// The SSRC of the packets that we're forwarding.
+	ssrc uint32
+	// The sequence number of the next packet to forward.
+	next uint16
+	// The packets that came before their predecessors (sorted by sequence numbers).
+	buffered []bufferedPacket
+}
+
+type bufferedPacket struct {
+	packet  rtp.Packet
+	arrived time.Time
+}
+
+// Creates a new reorder buffer or returns nil if the reordering is disabled.
+func newReorderBuffer(window time.Duration, size int) *reorderBuffer {
+	if window <= 0 {
+		return nil
+	}
+	if size <= 0 {
+		size = defaultReorderBufferSize
+	}
+
+	return &reorderBuffer{window: window, size: size}
+}
+
+// Adds a packet to the buffer and returns the packets that are ready to be forwarded (in order).
+// A nil buffer returns the packet right away.
+func (b *reorderBuffer) push(packet rtp.Packet, now time.Time) []rtp.Packet {
+	if b == nil {
+		return []rtp.Packet{packet}
+	}
+
+	// The first packet or a layer switch: forget about the previous stream.
+	if !b.started || packet.SSRC != b.ssrc {
+		ready := b.flush()
+		b.started, b.ssrc, b.next = true, packet.SSRC, packet.SequenceNumber
+		return append(ready, b.release(packet)...)
+	}
+
+	switch delta := packet.SequenceNumber - b.next; {
+	case delta == 0:
+		return b.release(packet)
+	case delta >= 0x8000:
+		// The packet is late (we have already skipped it or it's a duplicate), nothing to wait for.
+		return []rtp.Packet{packet}
+	default:
+		b.insert(bufferedPacket{packet, now})
+	}
+
+	return b.expire(now)
+}
+
+// Gives up on the missing packets if we waited for too long or have no space left. Returns the packets
+// that are ready to be forwarded (in order). A nil buffer holds no packets.
+func (b *reorderBuffer) expire(now time.Time) []rtp.Packet {
+	if b == nil {
+		return nil
+	}
+
+	ready := []rtp.Packet{}
+	for len(b.buffered) > 0 && (len(b.buffered) > b.size || now.Sub(b.buffered[0].arrived) >= b.window) {
+		b.next = b.buffered[0].packet.SequenceNumber
+		ready = append(ready, b.drain()...)
+	}
+
+	return ready
+}
+
+// Forwards a packet that is next in order along with the subsequent buffered packets.
+func (b *reorderBuffer) release(packet rtp.Packet) []rtp.Packet {
+	b.next = packet.SequenceNumber + 1
+	return append([]rtp.Packet{packet}, b.drain()...)
+}
+
+// Returns the buffered packets that are next in order.
+func (b *reorderBuffer) drain() []rtp.Packet {
+	ready := []rtp.Packet{}
+	for len(b.buffered) > 0 && b.buffered[0].packet.SequenceNumber == b.next {
+		ready = append(ready, b.buffered[0].packet)
+		b.buffered = b.buffered[1:]
+		b.next++
+	}
+
+	return ready
+}
+
+// Returns all buffered packets (in order) and empties the buffer.
+func (b *reorderBuffer) flush() []rtp.Packet {
+	ready := make([]rtp.Packet, 0, len(b.buffered))
+	for _, buffered := range b.buffered {
+		ready = append(ready, buffered.packet)
+	}
+
+	b.buffered = nil
+	return ready
+}
+
+// Inserts a packet into the buffer keeping it sorted by the distance from the next expected packet.
+func (b *reorderBuffer) insert(packet bufferedPacket) {
+	distance := func(p bufferedPacket) uint16 { return p.packet.SequenceNumber - b.next }
+
+	i := sort.Search(len(b.buffered), func(i int) bool { return distance(b.buffered[i]) >= distance(packet) })
+	if i < len(b.buffered) && distance(b.buffered[i]) == distance(packet) {
+		// A duplicate.
+		return
+	}
+
+	b.buffered = append(b.buffered, bufferedPacket{})
+	copy(b.buffered[i+1:], b.buffered[i:])
+	b.buffered[i] = packet
+}
@@ -0,0 +1,153 @@
+package subscription //nolint:testpackage
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/pion/rtp"
+)
+
+func sequenceNumbers(packets []rtp.Packet) []uint16 {
+	numbers := []uint16{}
+	for _, packet := range packets {
+		numbers = append(numbers, packet.SequenceNumber)
+	}
+
+	return numbers
+}
+
+func TestReorderBuffer(t *testing.T) {
+	const window = 50 * time.Millisecond
+
+	type arrival struct {
+		sequenceNumber uint16
+		// Since the start of the stream.
+		at time.Duration
+	}
+
+	cases := []struct {
+		name     string
+		size     int
+		arrivals []arrival
+		expected []uint16
+	}{
+		{
+			name:     "in order",
+			size:     8,
+			arrivals: []arrival{{1, 0}, {2, 0}, {3, 0}},
+			expected: []uint16{1, 2, 3},
+		},
+		{
+			name:     "swapped packets",
+			size:     8,
+			arrivals: []arrival{{1, 0}, {3, 0}, {4, 0}, {2, 0}, {5, 0}},
+			expected: []uint16{1, 2, 3, 4, 5},
+		},
+		{
+			name:     "sequence number wraps around",
+			size:     8,
+			arrivals: []arrival{{65534, 0}, {0, 0}, {65535, 0}, {1, 0}},
+			expected: []uint16{65534, 65535, 0, 1},
+		},
+		{
+			name:     "lost packet is skipped after the window",
+			size:     8,
+			arrivals: []arrival{{1, 0}, {3, 0}, {4, 10 * time.Millisecond}, {5, window}},
+			expected: []uint16{1, 3, 4, 5},
+		},
+		{
+			name:     "lost packet is skipped when the buffer is full",
+			size:     2,
+			arrivals: []arrival{{1, 0}, {3, 0}, {4, 0}, {5, 0}},
+			expected: []uint16{1, 3, 4, 5},
+		},
+		{
+			name:     "late packet is forwarded right away",
+			size:     2,
+			arrivals: []arrival{{1, 0}, {3, 0}, {4, 0}, {5, 0}, {2, 0}, {6, 0}},
+			expected: []uint16{1, 3, 4, 5, 2, 6},
+		},
+		{
+			name:     "duplicates are dropped from the buffer",
+			size:     8,
+			arrivals: []arrival{{1, 0}, {3, 0}, {3, 0}, {2, 0}},
+			expected: []uint16{1, 2, 3},
+		},
+	}
+
+	for _, c := range cases {
+		c := c
+		t.Run(c.name, func(t *testing.T) {
+			buffer := newReorderBuffer(window, c.size)
+			start := time.Now()
+
+			forwarded := []rtp.Packet{}
+			for _, arrival := range c.arrivals {
+				packet := rtp.Packet{Header: rtp.Header{SSRC: 1, SequenceNumber: arrival.sequenceNumber}}
+				forwarded = append(forwarded, buffer.push(packet, start.Add(arrival.at))...)
+			}
+
+			if actual := sequenceNumbers(forwarded); !reflect.DeepEqual(actual, c.expected) {
+				t.Errorf("Expected %v to be forwarded, got %v", c.expected, actual)
+			}
+		})
+	}
+}
+
+func TestReorderBufferFlushesOnSSRCChange(t *testing.T) {
+	buffer := newReorderBuffer(time.Second, 8)
+	now := time.Now()
+
+	forwarded := buffer.push(rtp.Packet{Header: rtp.Header{SSRC: 1, SequenceNumber: 1}}, now)
+	forwarded = append(forwarded, buffer.push(rtp.Packet{Header: rtp.Header{SSRC: 1, SequenceNumber: 3}}, now)...)
+	forwarded = append(forwarded, buffer.push(rtp.Packet{Header: rtp.Header{SSRC: 2, SequenceNumber: 100}}, now)...)
+
+	if actual, expected := sequenceNumbers(forwarded), []uint16{1, 3, 100}; !reflect.DeepEqual(actual, expected) {
+		t.Errorf("Expected %v to be forwarded, got %v", expected, actual)
+	}
+}
+
+func TestReorderBufferExpiresWithoutNewPackets(t *testing.T) {
+	const window = 50 * time.Millisecond
+	buffer := newReorderBuffer(window, 8)
+	start := time.Now()
+
+	// The stream pauses right after a packet got lost, so no packet arrives to release the held ones.
+	forwarded := buffer.push(rtp.Packet{Header: rtp.Header{SSRC: 1, SequenceNumber: 1}}, start)
+	forwarded = append(forwarded, buffer.push(rtp.Packet{Header: rtp.Header{SSRC: 1, SequenceNumber: 3}}, start)...)
+	forwarded = append(forwarded, buffer.push(rtp.Packet{Header: rtp.Header{SSRC: 1, SequenceNumber: 4}}, start)...)
+
+	if expired := buffer.expire(start.Add(window / 2)); len(expired) != 0 {
+		t.Errorf("Expected the packets to be held within the window, got %v", sequenceNumbers(expired))
+	}
+
+	forwarded = append(forwarded, buffer.expire(start.Add(window))...)
+	if actual, expected := sequenceNumbers(forwarded), []uint16{1, 3, 4}; !reflect.DeepEqual(actual, expected) {
+		t.Errorf("Expected %v to be forwarded, got %v", expected, actual)
+	}
+
+	// The stream resumes where it stopped.
+	resumed := buffer.push(rtp.Packet{Header: rtp.Header{SSRC: 1, SequenceNumber: 5}}, start.Add(time.Second))
+	if actual, expected := sequenceNumbers(resumed), []uint16{5}; !reflect.DeepEqual(actual, expected) {
+		t.Errorf("Expected %v to be forwarded, got %v", expected, actual)
+	}
+
+	if expired := (*reorderBuffer)(nil).expire(time.Now()); len(expired) != 0 {
+		t.Errorf("Expected a disabled buffer to hold no packets, got %v", sequenceNumbers(expired))
+	}
+}
+
+func TestReorderBufferDisabled(t *testing.T) {
+	buffer := newReorderBuffer(0, 8)
+	if buffer != nil {
+		t.Fatal("Expected no buffer when the window is not set")
+	}
+
+	forwarded := buffer.push(rtp.Packet{Header: rtp.Header{SequenceNumber: 5}}, time.Now())
+	forwarded = append(forwarded, buffer.push(rtp.Packet{Header: rtp.Header{SequenceNumber: 4}}, time.Now())...)
+
+	if actual, expected := sequenceNumbers(forwarded), []uint16{5, 4}; !reflect.DeepEqual(actual, expected) {
+		t.Errorf("Expected %v to be forwarded, got %v", expected, actual)
+	}
+}
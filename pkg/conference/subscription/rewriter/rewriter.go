@@ -38,6 +38,14 @@ func (p *PacketRewriter) ProcessIncoming(packet rtp.Packet) RewrittenRTPPacket {
 	return &packet
 }
 
+// Skips the incoming packet that is not forwarded (e.g. padding), so that the following packets are
+// numbered as if it has never been received, i.e. the subscriber does not see a gap in the sequence
+// numbers and does not consider the packet lost. Only the next packet in order can be skipped, the
+// out-of-order ones leave a gap, the same way as the lost packets do.
+func (p *PacketRewriter) SkipIncoming(packet rtp.Packet) {
+	p.state.skip(packet.SSRC, packet.SequenceNumber)
+}
+
 // The state of the forwarding/rewriting process for a single SSRC, i.e. a
 // single simulcast layer after a switch. This changes each time the simulcast
 // layer is switched and/or the incoming SSRC changes.
@@ -87,6 +95,22 @@ func (s *forwardingState) process(
 	return s.firstOutgoing.Add(delta)
 }
 
+// Skips the next packet (by its sequence number) by moving the base of the calculation, so that the
+// following packets are numbered one less.
+func (s *forwardingState) skip(ssrc uint32, sequenceNumber uint16) {
+	// A packet of another layer (or before the first one) does not affect the numbering.
+	if s.ssrc != ssrc {
+		return
+	}
+
+	if sequenceNumber != uint16(s.latestIncoming.sequenceNumber+1) {
+		return
+	}
+
+	s.latestIncoming.sequenceNumber++
+	s.firstIncoming.sequenceNumber++
+}
+
 // Resets the state of the rewriter for a new SSRC (switching layers).
 // Returns new outgoing identifiers.
 func (s *forwardingState) reset(
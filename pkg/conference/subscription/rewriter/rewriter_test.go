@@ -57,3 +57,24 @@ func TestRewriter(t *testing.T) {
 		}
 	}
 }
+
+func TestRewriterSkipsPackets(t *testing.T) {
+	rewriter := rewriter.NewPacketRewriter()
+
+	rewriter.ProcessIncoming(rtp.Packet{Header: rtp.Header{SSRC: 1111, SequenceNumber: 100}})
+	rewriter.SkipIncoming(rtp.Packet{Header: rtp.Header{SSRC: 1111, SequenceNumber: 101}})
+	rewriter.SkipIncoming(rtp.Packet{Header: rtp.Header{SSRC: 1111, SequenceNumber: 102}})
+
+	rewritten := rewriter.ProcessIncoming(rtp.Packet{Header: rtp.Header{SSRC: 1111, SequenceNumber: 103}})
+	if rewritten.SequenceNumber != 1 {
+		t.Fatalf("expected the skipped packets not to leave a gap, got seqNum %d", rewritten.SequenceNumber)
+	}
+
+	// The packets that are skipped out of order leave a gap as the lost packets do.
+	rewriter.SkipIncoming(rtp.Packet{Header: rtp.Header{SSRC: 1111, SequenceNumber: 105}})
+
+	rewritten = rewriter.ProcessIncoming(rtp.Packet{Header: rtp.Header{SSRC: 1111, SequenceNumber: 106}})
+	if rewritten.SequenceNumber != 4 {
+		t.Fatalf("expected seqNum 4, got %d", rewritten.SequenceNumber)
+	}
+}
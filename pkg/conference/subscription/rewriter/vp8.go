@@ -27,3 +27,14 @@ func IsVP8Keyframe(packet rtp.Packet) bool {
 	// key frames have it set to 1.
 	return vp8Packet.S == 1 && Pbit == 0
 }
+
+// Determines if a given packet is the first packet of a VP8 frame, i.e. the
+// start (S bit) of the first partition (partition index 0) of the frame.
+func IsVP8FrameStart(packet rtp.Packet) bool {
+	vp8Packet := codecs.VP8Packet{}
+	if _, err := vp8Packet.Unmarshal(packet.Payload); err != nil {
+		return false
+	}
+
+	return vp8Packet.S == 1 && vp8Packet.PID == 0
+}
@@ -1,16 +1,53 @@
 package subscription
 
 import (
+	"sync/atomic"
+
+	"github.com/pion/rtcp"
 	"github.com/pion/rtp"
 	"github.com/pion/webrtc/v3"
 )
 
 type Subscription interface {
 	Unsubscribe() error
+	// Ends the subscription, but leaves the sender in place until the next renegotiation, so that
+	// the subscriber sees a frozen (muted) track instead of the track being removed right away.
+	Detach() error
 	WriteRTP(packet rtp.Packet) error
+	// The SSRC of the packets that the subscriber receives.
+	OutgoingSSRC() webrtc.SSRC
 }
 
 type SubscriptionController interface {
 	AddTrack(track *webrtc.TrackLocalStaticRTP) (*webrtc.RTPSender, error)
 	RemoveTrack(sender *webrtc.RTPSender) error
+	// Like `RemoveTrack`, but the sender is only removed on the next renegotiation.
+	DetachTrack(sender *webrtc.RTPSender) error
+	// Checks if the remote peer is able to receive the media encoded with a given codec.
+	SupportsCodec(codec webrtc.RTPCodecCapability) bool
+	// Hints the remote peer the maximum bitrate (in bits per second) of the media of a given sender, 0 means none.
+	SetMaxBitrate(sender *webrtc.RTPSender, bitrate uint64)
+}
+
+// Returns the SSRC that the sender uses for the outgoing packets (0 if not known yet).
+func senderSSRC(sender *webrtc.RTPSender) webrtc.SSRC {
+	if encodings := sender.GetParameters().Encodings; len(encodings) > 0 {
+		return encodings[0].SSRC
+	}
+
+	return 0
+}
+
+// Remembers the report about the packets with a given SSRC (if the subscriber reported about them).
+func storeReceptionReport(
+	store *atomic.Pointer[rtcp.ReceptionReport],
+	ssrc webrtc.SSRC,
+	reports []rtcp.ReceptionReport,
+) {
+	for i := range reports {
+		if reports[i].SSRC == uint32(ssrc) {
+			report := reports[i]
+			store.Store(&report)
+		}
+	}
 }
@@ -0,0 +1,107 @@
+package subscription
+
+import (
+	"strings"
+	"sync/atomic"
+
+	"github.com/pion/rtp"
+	"github.com/pion/rtp/codecs"
+	"github.com/pion/webrtc/v3"
+)
+
+// The highest temporal layer that the VP8 payload descriptor can carry (the TID has 2 bits).
+const maxTemporalLayer = 3
+
+// The measured frame rates jitter a bit, so a layer may exceed the maximum frame rate by that much.
+const frameRateTolerance = 1.1
+
+// Forwards only the VP8 temporal layers that fit into the maximum frame rate of the subscription, so that the
+// subscriber gets fewer frames of the same resolution instead of a lower resolution. The frame rate of a layer
+// is derived from the one of the base layer (TID 0), assuming the usual structure in which each temporal layer
+// doubles the frame rate of the layers below it (e.g. L1T2 or L1T3).
+type temporalLayerFilter struct {
+	// The frame rate that the subscriber gets at most (0 means unlimited), shared with the subscription.
+	maxFrameRate *atomic.Int32
+	// The clock rate of the codec, used to convert the timestamps to the frame rate.
+	clockRate uint32
+	// The timestamp of the last frame of the base layer (if `started`) and the interval between
+	// the frames of the base layer (0 if not known yet).
+	lastBaseTimestamp uint32
+	baseInterval      uint32
+	started           bool
+	// The highest temporal layer that is forwarded.
+	currentLayer uint8
+}
+
+// Returns a filter for the codecs that we can handle or nil if we don't understand the codec.
+func newTemporalLayerFilter(codec webrtc.RTPCodecCapability, maxFrameRate *atomic.Int32) *temporalLayerFilter {
+	if !strings.EqualFold(codec.MimeType, webrtc.MimeTypeVP8) {
+		return nil
+	}
+
+	return &temporalLayerFilter{
+		maxFrameRate: maxFrameRate,
+		clockRate:    codec.ClockRate,
+		currentLayer: maxTemporalLayer,
+	}
+}
+
+// Checks if the packet can be forwarded. A nil filter forwards all packets. The layers are only switched
+// at the frame boundaries, since all packets of a frame belong to the same temporal layer.
+func (f *temporalLayerFilter) forward(packet rtp.Packet) bool {
+	if f == nil {
+		return true
+	}
+
+	vp8Packet := codecs.VP8Packet{}
+	if _, err := vp8Packet.Unmarshal(packet.Payload); err != nil || vp8Packet.T == 0 {
+		// The video has no temporal layers.
+		return true
+	}
+
+	if vp8Packet.S == 1 && vp8Packet.PID == 0 {
+		f.frameStarted(packet.Timestamp, vp8Packet)
+	}
+
+	return vp8Packet.TID <= f.currentLayer
+}
+
+// Measures the frame rate and switches the layers (if needed) at the start of a frame.
+func (f *temporalLayerFilter) frameStarted(timestamp uint32, vp8Packet codecs.VP8Packet) {
+	if vp8Packet.TID == 0 {
+		// The late (reordered) frames and the gaps of more than a second don't tell anything about the frame rate.
+		if interval := timestamp - f.lastBaseTimestamp; f.started && interval > 0 && interval < f.clockRate {
+			f.baseInterval = interval
+		}
+
+		f.lastBaseTimestamp, f.started = timestamp, true
+	}
+
+	target := f.targetLayer()
+	switch {
+	case target < f.currentLayer:
+		// The lower layers never reference the higher ones, so they can be dropped any time.
+		f.currentLayer = target
+	case vp8Packet.TID > f.currentLayer && vp8Packet.TID <= target && vp8Packet.Y == 1:
+		// The higher layer can only be decoded starting with a frame that is only based on the base layer.
+		f.currentLayer = vp8Packet.TID
+	}
+}
+
+// Returns the highest temporal layer whose frame rate does not exceed the maximum frame rate.
+func (f *temporalLayerFilter) targetLayer() uint8 {
+	maxFrameRate := float64(f.maxFrameRate.Load()) * frameRateTolerance
+	if maxFrameRate <= 0 || f.baseInterval == 0 {
+		return maxTemporalLayer
+	}
+
+	// The base layer is forwarded even if it exceeds the maximum frame rate.
+	frameRate := float64(f.clockRate) / float64(f.baseInterval)
+	layer := uint8(0)
+	for layer < maxTemporalLayer && frameRate*2 <= maxFrameRate {
+		frameRate *= 2
+		layer++
+	}
+
+	return layer
+}
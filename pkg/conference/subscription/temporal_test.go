@@ -0,0 +1,85 @@
+package subscription //nolint:testpackage
+
+import (
+	"sync/atomic"
+	"testing"
+
+	"github.com/pion/rtp"
+	"github.com/pion/webrtc/v3"
+)
+
+// Returns a packet of a single-packet VP8 frame of a given temporal layer (with the layer sync bit if `sync`).
+func temporalLayerPacket(sequenceNumber uint16, timestamp uint32, tid uint8, sync bool) rtp.Packet {
+	tidByte := tid << 6
+	if sync {
+		tidByte |= 0x20
+	}
+
+	return rtp.Packet{
+		Header: rtp.Header{SSRC: 1111, SequenceNumber: sequenceNumber, Timestamp: timestamp},
+		// Extended control bits and the start of the first partition, the TID is present.
+		Payload: []byte{0x90, 0x20, tidByte, 0x01, 0x00, 0x00},
+	}
+}
+
+func TestTemporalLayerFilter(t *testing.T) {
+	// The usual L1T3 structure at 30 frames per second, i.e. the base layer at 7.5 frames per second.
+	pattern := []uint8{0, 2, 1, 2}
+	const frameInterval = 3000
+
+	var maxFrameRate atomic.Int32
+	codec := webrtc.RTPCodecCapability{MimeType: webrtc.MimeTypeVP8, ClockRate: 90000}
+	filter := newTemporalLayerFilter(codec, &maxFrameRate)
+
+	frame := 0
+	// Sends a second worth of frames, returns the number of forwarded frames of each temporal layer.
+	sendSecond := func() [maxTemporalLayer + 1]int {
+		forwarded := [maxTemporalLayer + 1]int{}
+		for end := frame + 30; frame < end; frame++ {
+			tid := pattern[frame%len(pattern)]
+			// The frames right after the base layer frame are the sync frames of their layers.
+			sync := frame%len(pattern) == 1 || frame%len(pattern) == 2
+			if filter.forward(temporalLayerPacket(uint16(frame), uint32(frame*frameInterval), tid, sync)) {
+				forwarded[tid]++
+			}
+		}
+		return forwarded
+	}
+
+	if forwarded := sendSecond(); forwarded != [4]int{8, 7, 15, 0} {
+		t.Errorf("Expected all frames to be forwarded without a limit, got %v", forwarded)
+	}
+
+	// The frames of the highest temporal layer are dropped to halve the frame rate.
+	maxFrameRate.Store(15)
+	if forwarded := sendSecond(); forwarded[2] != 0 || forwarded[0]+forwarded[1] != 30/2 {
+		t.Errorf("Expected only the two lowest layers at 15 fps, got %v", forwarded)
+	}
+
+	// The base layer is forwarded even if it exceeds the maximum frame rate.
+	maxFrameRate.Store(5)
+	if forwarded := sendSecond(); forwarded[1] != 0 || forwarded[2] != 0 || forwarded[0] == 0 {
+		t.Errorf("Expected only the base layer, got %v", forwarded)
+	}
+
+	// The higher layers are forwarded again starting with their sync frames.
+	maxFrameRate.Store(0)
+	if forwarded := sendSecond(); forwarded[1]+forwarded[2] < 20 {
+		t.Errorf("Expected the higher layers to be forwarded again, got %v", forwarded)
+	}
+
+	if !filter.forward(temporalLayerPacket(uint16(frame), uint32(frame*frameInterval), 2, false)) {
+		t.Error("Expected the highest layer to be forwarded without a limit")
+	}
+
+	// The video without temporal layers is not affected.
+	maxFrameRate.Store(1)
+	packet := rtp.Packet{Header: rtp.Header{SSRC: 1111}, Payload: []byte{0x10, 0x01, 0x00, 0x00}}
+	if !filter.forward(packet) {
+		t.Error("Expected the video without temporal layers to be forwarded")
+	}
+
+	if newTemporalLayerFilter(webrtc.RTPCodecCapability{MimeType: webrtc.MimeTypeH264}, &maxFrameRate) != nil {
+		t.Error("Expected no filter for the codecs without temporal layer support")
+	}
+}
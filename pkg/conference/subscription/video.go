@@ -4,10 +4,12 @@ import (
 	"errors"
 	"fmt"
 	"io"
+	"sync"
 	"sync/atomic"
 	"time"
 
 	"github.com/matrix-org/waterfall/pkg/conference/subscription/rewriter"
+	"github.com/matrix-org/waterfall/pkg/metrics"
 	"github.com/matrix-org/waterfall/pkg/telemetry"
 	"github.com/matrix-org/waterfall/pkg/webrtc_ext"
 	"github.com/matrix-org/waterfall/pkg/worker"
@@ -15,6 +17,7 @@ import (
 	"github.com/pion/rtp"
 	"github.com/pion/webrtc/v3"
 	"github.com/sirupsen/logrus"
+	"go.opentelemetry.io/otel/attribute"
 )
 
 type VideoSubscription struct {
@@ -25,6 +28,22 @@ type VideoSubscription struct {
 	controller SubscriptionController
 	worker     *worker.Worker[rtp.Packet]
 	stopped    atomic.Bool
+	// Closed once the subscription is stopped, so that the RTCP reader exits.
+	done chan struct{}
+	// Time to the key frame after the subscriber requested it.
+	keyFrameLatency *keyFrameLatency
+	// The latest reception report of the subscriber about the forwarded packets.
+	receptionReport atomic.Pointer[rtcp.ReceptionReport]
+	// The running debug capture of the forwarded packets (if any).
+	activeCapture atomic.Pointer[capture]
+	// The frame rate that the subscriber gets at most (0 means unlimited).
+	maxFrameRate atomic.Int32
+	// What happens to the packets once the subscriber can't keep up.
+	dropPolicy DropPolicy
+	// How many packets have been dropped since the subscriber fell behind (0 if it keeps up).
+	dropped atomic.Uint64
+	// Informs the parent about the key frame requests.
+	keyFrameRequests *keyFrameRequests
 
 	logger    *logrus.Entry
 	telemetry *telemetry.Telemetry
@@ -38,6 +57,7 @@ type KeyFrameRequest struct{}
 func NewVideoSubscription(
 	info webrtc_ext.TrackInfo,
 	controller SubscriptionController,
+	config Config,
 	logger *logrus.Entry,
 	telemetryBuilder *telemetry.ChildBuilder,
 ) (*VideoSubscription, <-chan KeyFrameRequest, error) {
@@ -49,7 +69,7 @@ func NewVideoSubscription(
 
 	rtpSender, err := controller.AddTrack(rtpTrack)
 	if err != nil {
-		return nil, nil, fmt.Errorf("Failed to add track: %v", err)
+		return nil, nil, fmt.Errorf("Failed to add track: %w", err)
 	}
 
 	// Create a subscription.
@@ -59,25 +79,46 @@ func NewVideoSubscription(
 		controller,
 		nil,
 		atomic.Bool{},
+		make(chan struct{}),
+		newKeyFrameLatency(),
+		atomic.Pointer[rtcp.ReceptionReport]{},
+		atomic.Pointer[capture]{},
+		atomic.Int32{},
+		config.DropPolicy,
+		atomic.Uint64{},
+		newKeyFrameRequests(),
 		logger,
 		telemetryBuilder.Create("VideoSubscription"),
 	}
 
 	// Create a worker state.
 	workerState := workerState{
-		packetRewriter: rewriter.NewPacketRewriter(),
-		rtpTrack:       rtpTrack,
+		packetRewriter:  rewriter.NewPacketRewriter(),
+		rtpTrack:        rtpTrack,
+		rtpSender:       rtpSender,
+		playoutDelay:    config.PlayoutDelay,
+		mimeType:        info.Codec.MimeType,
+		reorderBuffer:   newReorderBuffer(config.ReorderWindow, config.ReorderBufferSize),
+		encrypted:       config.Encrypted,
+		dropPadding:     config.DropPadding,
+		keyFrameLatency: subscription.keyFrameLatency,
+		activeCapture:   &subscription.activeCapture,
+		telemetry:       subscription.telemetry,
 	}
 
-	// Configure the worker for the subscription.
-	workerConfig := worker.Config[rtp.Packet]{
-		ChannelSize: 16, // We really don't need a large buffer here, just to account for spikes.
-		Timeout:     1 * time.Hour,
-		OnTimeout:   func() {},
-		OnTask:      workerState.handlePacket,
+	if !config.Encrypted {
+		workerState.frameGate = newFrameGate(info.Codec.MimeType)
+		workerState.keyFrameGate = newKeyFrameGate(info.Codec.MimeType)
+		workerState.temporalFilter = newTemporalLayerFilter(info.Codec, &subscription.maxFrameRate)
 	}
 
 	// Start a worker for the subscription and create a subsription.
+	workerConfig := newWorkerConfig(config, workerState.handlePacket)
+	if workerState.reorderBuffer != nil && config.ReorderWindow < workerConfig.Timeout {
+		// The held packets must be released even if no more packets arrive, e.g. when the publisher pauses.
+		workerConfig.Timeout = config.ReorderWindow
+		workerConfig.OnTimeout = workerState.releaseExpiredPackets
+	}
 	subscription.worker = worker.StartWorker(workerConfig)
 
 	// Start reading and forwarding RTCP packets goroutine.
@@ -87,33 +128,133 @@ func NewVideoSubscription(
 }
 
 func (s *VideoSubscription) Unsubscribe() error {
+	return s.stop(s.controller.RemoveTrack)
+}
+
+func (s *VideoSubscription) Detach() error {
+	return s.stop(s.controller.DetachTrack)
+}
+
+// Stops the subscription and gets rid of its sender with a given function.
+func (s *VideoSubscription) stop(removeSender func(*webrtc.RTPSender) error) error {
 	if !s.stopped.CompareAndSwap(false, true) {
 		return fmt.Errorf("Already stopped")
 	}
 
 	s.worker.Stop()
+	if capture := s.activeCapture.Swap(nil); capture != nil {
+		capture.stop()
+	}
+
 	s.logger.Info("Unsubscribed")
 	s.telemetry.End()
-	return s.controller.RemoveTrack(s.rtpSender)
+
+	// The removed sender interrupts the pending read of the RTCP reader on its own, but the detached one stays
+	// in place until the next renegotiation, so we interrupt the read ourselves. The read of the sender that has
+	// not been negotiated yet can't be interrupted though, such a reader exits once the sender is pruned.
+	close(s.done)
+	err := removeSender(s.rtpSender)
+	s.rtpSender.SetReadDeadline(time.Now())
+
+	return err
 }
 
 func (s *VideoSubscription) WriteRTP(packet rtp.Packet) error {
-	// Send the packet to the worker.
-	return s.worker.Send(packet)
+	// Send the packet to the worker. It never blocks, so a slow subscriber can't stall the publisher.
+	err := s.worker.Send(packet)
+	if errors.Is(err, worker.ErrWorkerTooBusy) {
+		// The subscriber can't keep up, so we drop the packet. It's not an error for the publisher.
+		if s.dropped.Add(1) == 1 {
+			s.logger.Warn("Subscriber can't keep up, dropping packets")
+			s.telemetry.AddEvent("dropping packets")
+		}
+		return nil
+	}
+
+	if err == nil {
+		if dropped := s.dropped.Swap(0); dropped > 0 {
+			s.caughtUp(dropped)
+		}
+	}
+
+	return err
+}
+
+// Called once the subscriber that has been dropping packets keeps up again.
+func (s *VideoSubscription) caughtUp(dropped uint64) {
+	s.logger.WithField("dropped", dropped).Info("Subscriber caught up")
+	s.telemetry.AddEvent("caught up", attribute.Int64("dropped", int64(dropped)))
+
+	// The subscriber has missed a part of a frame (at least), so it needs a key frame to carry on.
+	if s.dropPolicy != DropPolicyDrop {
+		s.keyFrameLatency.requested()
+		s.keyFrameRequests.trySend()
+	}
+}
+
+func (s *VideoSubscription) OutgoingSSRC() webrtc.SSRC {
+	return senderSSRC(s.rtpSender)
+}
+
+// Hints the subscriber the maximum bitrate of the forwarded video (e.g. of the current layer), see
+// `SubscriptionController.SetMaxBitrate()`. It takes effect on the next negotiation.
+func (s *VideoSubscription) SetMaxBitrate(bitrate uint64) {
+	s.controller.SetMaxBitrate(s.rtpSender, bitrate)
+}
+
+// Limits the frame rate of the forwarded video (0 means unlimited). Only the unencrypted VP8 video with temporal
+// layers can be limited: the frames of the higher temporal layers are dropped, the resolution stays the same.
+func (s *VideoSubscription) SetMaxFrameRate(frameRate int) {
+	s.maxFrameRate.Store(int32(frameRate))
+}
+
+// Starts a debug capture of the packets that are forwarded to the subscriber. The running capture (if any) is
+// stopped. The capture stops on its own once the time or size limit is reached or the subscription ends.
+func (s *VideoSubscription) StartCapture(config CaptureConfig) error {
+	if s.stopped.Load() {
+		config.Output.Close()
+		return fmt.Errorf("subscription is stopped")
+	}
+
+	capture, err := startCapture(config)
+	if err != nil {
+		return err
+	}
+
+	if previous := s.activeCapture.Swap(capture); previous != nil {
+		previous.stop()
+	}
+
+	s.logger.WithField("duration", config.Duration).Info("Started capturing forwarded packets")
+	return nil
+}
+
+// Returns the latest reception report that the subscriber sent about the packets we forward (if any).
+func (s *VideoSubscription) ReceptionReport() (rtcp.ReceptionReport, bool) {
+	if report := s.receptionReport.Load(); report != nil {
+		return *report, true
+	}
+
+	return rtcp.ReceptionReport{}, false
 }
 
 // Read incoming RTCP packets. Before these packets are returned they are processed by interceptors.
 func (s *VideoSubscription) startReadRTCP() <-chan KeyFrameRequest {
-	ch := make(chan KeyFrameRequest)
-
 	go func() {
-		defer close(ch)
+		defer s.keyFrameRequests.close()
 		defer s.Unsubscribe()
 		defer s.telemetry.AddEvent("Stopped")
 		defer s.logger.Info("Stopped")
 
 		for {
 			packets, _, err := s.rtpSender.ReadRTCP()
+
+			select {
+			case <-s.done:
+				return
+			default:
+			}
+
 			if err != nil {
 				s.logger.Infof("Failed to read RTCP: %v", err)
 
@@ -124,16 +265,70 @@ func (s *VideoSubscription) startReadRTCP() <-chan KeyFrameRequest {
 
 			// We only want to inform others about PLIs and FIRs. We skip the rest of the packets for now.
 			for _, packet := range packets {
-				switch packet.(type) {
+				switch packet := packet.(type) {
 				// For simplicity we assume that any of the key frame requests is just a key frame request.
 				case *rtcp.PictureLossIndication, *rtcp.FullIntraRequest:
-					ch <- KeyFrameRequest{}
+					s.keyFrameLatency.requested()
+					s.keyFrameRequests.send(s.done)
+				// Remember how the subscriber receives our packets, so that the publisher could learn about it.
+				case *rtcp.ReceiverReport:
+					s.storeReceptionReport(packet.Reports)
 				}
 			}
 		}
 	}()
 
-	return ch
+	return s.keyFrameRequests.channel
+}
+
+// The key frame requests of the subscription. Apart from the subscriber's PLIs and FIRs (that the RTCP
+// reader sends), we request the key frames ourselves once a slow subscriber catches up. Such requests
+// come from the publisher, so they must neither block nor be sent after the channel is closed.
+type keyFrameRequests struct {
+	channel chan KeyFrameRequest
+	mutex   sync.Mutex
+	closed  bool
+}
+
+func newKeyFrameRequests() *keyFrameRequests {
+	// A single pending request is enough, the key frame satisfies all of them.
+	return &keyFrameRequests{channel: make(chan KeyFrameRequest, 1)}
+}
+
+// Sends a request, blocking until the parent takes it or until `done` is closed. Must only be called by
+// the goroutine that closes the channel.
+func (k *keyFrameRequests) send(done <-chan struct{}) {
+	select {
+	case k.channel <- KeyFrameRequest{}:
+	case <-done:
+	}
+}
+
+// Sends a request unless there is one pending already or the channel is closed.
+func (k *keyFrameRequests) trySend() {
+	k.mutex.Lock()
+	defer k.mutex.Unlock()
+
+	if k.closed {
+		return
+	}
+
+	select {
+	case k.channel <- KeyFrameRequest{}:
+	default:
+	}
+}
+
+func (k *keyFrameRequests) close() {
+	k.mutex.Lock()
+	defer k.mutex.Unlock()
+
+	k.closed = true
+	close(k.channel)
+}
+
+func (s *VideoSubscription) storeReceptionReport(reports []rtcp.ReceptionReport) {
+	storeReceptionReport(&s.receptionReport, s.OutgoingSSRC(), reports)
 }
 
 // Internal state of a worker that runs in its own goroutine.
@@ -142,8 +337,108 @@ type workerState struct {
 	packetRewriter *rewriter.PacketRewriter
 	// Undelying output track.
 	rtpTrack *webrtc.TrackLocalStaticRTP
+	// Sender of the output track.
+	rtpSender *webrtc.RTPSender
+	// The playout delay to add to the packets (if any) and the ID of the negotiated extension.
+	playoutDelay            *PlayoutDelay
+	playoutDelayExtensionID uint8
+	// Codec of the track, used to detect the key frames.
+	mimeType string
+	// Puts the out-of-order packets back in order (nil if disabled).
+	reorderBuffer *reorderBuffer
+	// Drops the incomplete frames (nil if the codec is not supported).
+	frameGate *vp8FrameGate
+	// Withholds the packets until the first key frame (nil if the codec is not supported).
+	keyFrameGate *keyFrameGate
+	// Drops the temporal layers that exceed the maximum frame rate (nil if the codec is not supported).
+	temporalFilter *temporalLayerFilter
+	// Whether the payload is end-to-end encrypted. We don't look into the payload of
+	// such packets, so the subscriber relies on the PLIs to get the key frames.
+	encrypted bool
+	// Whether the padding-only packets are dropped instead of being forwarded.
+	dropPadding bool
+	// Time to the key frame after the subscriber requested it.
+	keyFrameLatency *keyFrameLatency
+	// The running debug capture of the forwarded packets (shared with the subscription).
+	activeCapture *atomic.Pointer[capture]
+	// Telemetry of the subscription.
+	telemetry *telemetry.Telemetry
 }
 
 func (w *workerState) handlePacket(packet rtp.Packet) {
-	w.rtpTrack.WriteRTP(w.packetRewriter.ProcessIncoming(packet))
+	for _, packet := range w.reorderBuffer.push(packet, time.Now()) {
+		w.forwardPacket(packet)
+	}
+}
+
+// Forwards the packets that have waited for their missing predecessors for too long.
+func (w *workerState) releaseExpiredPackets() {
+	for _, packet := range w.reorderBuffer.expire(time.Now()) {
+		w.forwardPacket(packet)
+	}
+}
+
+func (w *workerState) forwardPacket(packet rtp.Packet) {
+	if w.dropPadding && isPaddingOnly(packet) {
+		w.packetRewriter.SkipIncoming(packet)
+		return
+	}
+
+	// Only the unencrypted payload can be inspected.
+	if !w.encrypted {
+		if !w.frameGate.forward(packet) || !w.keyFrameGate.forward(packet) {
+			return
+		}
+
+		// The subscriber must not see a gap in the sequence numbers, since the dropped frames are not lost.
+		if !w.temporalFilter.forward(packet) {
+			w.packetRewriter.SkipIncoming(packet)
+			return
+		}
+
+		if latency, ok := w.keyFrameReceived(packet); ok {
+			metrics.KeyFrameLatency.Observe(float64(latency.Milliseconds()))
+			w.telemetry.AddEvent("key frame received", attribute.Int64("latency_ms", latency.Milliseconds()))
+		}
+	}
+
+	if w.playoutDelay != nil {
+		// The extension ID is only known once the subscriber accepted our offer.
+		if w.playoutDelayExtensionID == 0 {
+			w.playoutDelayExtensionID = playoutDelayExtensionID(w.rtpSender)
+		}
+
+		if w.playoutDelayExtensionID != 0 {
+			if err := setPlayoutDelay(&packet, w.playoutDelayExtensionID, *w.playoutDelay); err != nil {
+				w.telemetry.AddError(err)
+			}
+		}
+	}
+
+	// The sender reports of the outgoing SSRC are generated by the interceptors of the peer connection from the
+	// packets written here, so they map the NTP time to the rewritten timestamps that the subscriber gets.
+	rewritten := w.packetRewriter.ProcessIncoming(packet)
+	w.rtpTrack.WriteRTP(rewritten)
+
+	if w.activeCapture == nil {
+		return
+	}
+
+	if capture := w.activeCapture.Load(); capture != nil {
+		// Capture the packet the way the subscriber gets it, i.e. with the outgoing SSRC.
+		captured := *rewritten
+		captured.SSRC = uint32(senderSSRC(w.rtpSender))
+		if !capture.write(&captured, time.Now()) {
+			w.activeCapture.CompareAndSwap(capture, nil)
+		}
+	}
+}
+
+// Returns the key frame latency if the packet starts a key frame that the subscriber has been waiting for.
+func (w *workerState) keyFrameReceived(packet rtp.Packet) (time.Duration, bool) {
+	if !isKeyFrame(w.mimeType, packet) {
+		return 0, false
+	}
+
+	return w.keyFrameLatency.received()
 }
@@ -0,0 +1,360 @@
+package subscription //nolint:testpackage
+
+import (
+	"context"
+	"runtime"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/matrix-org/waterfall/pkg/telemetry"
+	"github.com/matrix-org/waterfall/pkg/webrtc_ext"
+	"github.com/matrix-org/waterfall/pkg/worker"
+	"github.com/pion/rtcp"
+	"github.com/pion/rtp"
+	"github.com/pion/webrtc/v3"
+	"github.com/sirupsen/logrus"
+)
+
+// Creates a subscription that forwards the packets with a given function instead of a track.
+func newQueuedSubscription(forward func(rtp.Packet), queueSize int, policy DropPolicy) *VideoSubscription {
+	return &VideoSubscription{
+		worker:           worker.StartWorker(newWorkerConfig(Config{ChannelSize: queueSize}, forward)),
+		keyFrameLatency:  newKeyFrameLatency(),
+		dropPolicy:       policy,
+		keyFrameRequests: newKeyFrameRequests(),
+		logger:           logrus.NewEntry(logrus.New()),
+		telemetry:        telemetry.NewTelemetry(context.Background(), "VideoSubscription"),
+	}
+}
+
+func TestSlowSubscriberDoesNotStallOthers(t *testing.T) {
+	for _, policy := range []DropPolicy{"", DropPolicyKeyFrame, DropPolicyDrop} {
+		var fastForwarded, slowForwarded atomic.Int64
+		release := make(chan struct{})
+
+		// The queue of the fast subscriber absorbs the whole burst, the slow one can't keep up at all.
+		fast := newQueuedSubscription(func(rtp.Packet) { fastForwarded.Add(1) }, 128, policy)
+		slow := newQueuedSubscription(func(rtp.Packet) {
+			<-release
+			slowForwarded.Add(1)
+		}, 4, policy)
+
+		// The publisher forwards its packets to the subscribers one by one.
+		written := make(chan struct{})
+		go func() {
+			defer close(written)
+			for i := 0; i < 100; i++ {
+				for _, subscription := range []*VideoSubscription{slow, fast} {
+					if err := subscription.WriteRTP(rtp.Packet{Header: rtp.Header{SequenceNumber: uint16(i)}}); err != nil {
+						t.Errorf("Expected the packets of the slow subscriber to be dropped silently, got %v", err)
+					}
+				}
+			}
+		}()
+
+		select {
+		case <-written:
+		case <-time.After(time.Second):
+			t.Fatalf("Policy %q: the slow subscriber has stalled the publisher", policy)
+		}
+
+		waitFor(t, func() bool { return fastForwarded.Load() == 100 })
+
+		// The slow subscriber catches up, the packets that it gets from now on are forwarded again.
+		close(release)
+		forwardedBefore := slowForwarded.Load()
+		waitFor(t, func() bool {
+			slow.WriteRTP(rtp.Packet{})
+			return slowForwarded.Load() > forwardedBefore+5
+		})
+
+		select {
+		case <-slow.keyFrameRequests.channel:
+			if policy == DropPolicyDrop {
+				t.Errorf("Policy %q: expected no key frame request", policy)
+			}
+		default:
+			if policy != DropPolicyDrop {
+				t.Errorf("Policy %q: expected a key frame request once the subscriber caught up", policy)
+			}
+		}
+
+		select {
+		case <-fast.keyFrameRequests.channel:
+			t.Errorf("Policy %q: expected no key frame request for the subscriber that keeps up", policy)
+		default:
+		}
+
+		fast.worker.Stop()
+		slow.worker.Stop()
+	}
+}
+
+// Waits until the condition is met, fails the test if it takes too long.
+func waitFor(t *testing.T, condition func() bool) {
+	t.Helper()
+
+	for deadline := time.Now().Add(time.Second); !condition(); time.Sleep(time.Millisecond) {
+		if time.Now().After(deadline) {
+			t.Fatal("Timed out waiting for the condition")
+		}
+	}
+}
+
+// A subscription controller that adds the tracks to a given peer connection.
+type peerConnectionController struct {
+	peerConnection *webrtc.PeerConnection
+}
+
+func (c peerConnectionController) AddTrack(track *webrtc.TrackLocalStaticRTP) (*webrtc.RTPSender, error) {
+	return c.peerConnection.AddTrack(track)
+}
+
+func (c peerConnectionController) RemoveTrack(sender *webrtc.RTPSender) error {
+	return c.peerConnection.RemoveTrack(sender)
+}
+
+func (c peerConnectionController) DetachTrack(sender *webrtc.RTPSender) error {
+	return nil
+}
+
+func (c peerConnectionController) SupportsCodec(webrtc.RTPCodecCapability) bool {
+	return true
+}
+
+func (c peerConnectionController) SetMaxBitrate(*webrtc.RTPSender, uint64) {}
+
+// Negotiates (or renegotiates) a connection between two peer connections without trickling the candidates.
+func negotiate(t *testing.T, offerer, answerer *webrtc.PeerConnection) {
+	t.Helper()
+
+	offer, err := offerer.CreateOffer(nil)
+	if err != nil {
+		t.Fatalf("Failed to create offer: %v", err)
+	}
+	gathered := webrtc.GatheringCompletePromise(offerer)
+	if err := offerer.SetLocalDescription(offer); err != nil {
+		t.Fatalf("Failed to set local description: %v", err)
+	}
+	<-gathered
+
+	if err := answerer.SetRemoteDescription(*offerer.LocalDescription()); err != nil {
+		t.Fatalf("Failed to set remote description: %v", err)
+	}
+	answer, err := answerer.CreateAnswer(nil)
+	if err != nil {
+		t.Fatalf("Failed to create answer: %v", err)
+	}
+	gathered = webrtc.GatheringCompletePromise(answerer)
+	if err := answerer.SetLocalDescription(answer); err != nil {
+		t.Fatalf("Failed to set local description: %v", err)
+	}
+	<-gathered
+
+	if err := offerer.SetRemoteDescription(*answerer.LocalDescription()); err != nil {
+		t.Fatalf("Failed to set remote description: %v", err)
+	}
+}
+
+// Returns the number of the running RTCP readers of the video subscriptions.
+func rtcpReaders() int {
+	buffer := make([]byte, 1<<20)
+	for {
+		if n := runtime.Stack(buffer, true); n < len(buffer) {
+			return strings.Count(string(buffer[:n]), "(*VideoSubscription).startReadRTCP.func")
+		}
+		buffer = make([]byte, 2*len(buffer))
+	}
+}
+
+func TestRTCPReadersStopWithSubscriptions(t *testing.T) {
+	local, err := webrtc.NewPeerConnection(webrtc.Configuration{})
+	if err != nil {
+		t.Fatalf("Failed to create peer connection: %v", err)
+	}
+	defer local.Close()
+
+	remote, err := webrtc.NewPeerConnection(webrtc.Configuration{})
+	if err != nil {
+		t.Fatalf("Failed to create peer connection: %v", err)
+	}
+	defer remote.Close()
+
+	connected := make(chan struct{})
+	local.OnConnectionStateChange(func(state webrtc.PeerConnectionState) {
+		if state == webrtc.PeerConnectionStateConnected {
+			close(connected)
+		}
+	})
+
+	if _, err := local.CreateDataChannel("data", nil); err != nil {
+		t.Fatalf("Failed to create data channel: %v", err)
+	}
+	negotiate(t, local, remote)
+
+	select {
+	case <-connected:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Timed out connecting")
+	}
+
+	info := webrtc_ext.TrackInfo{
+		TrackID:  "track",
+		StreamID: "stream",
+		Kind:     webrtc.RTPCodecTypeVideo,
+		Codec:    webrtc.RTPCodecCapability{MimeType: webrtc.MimeTypeVP8, ClockRate: 90000},
+	}
+	telemetryBuilder := telemetry.NewTelemetry(context.Background(), "PublishedTrack").ChildBuilder()
+
+	// A long call in which the subscriber keeps switching the tracks on and off.
+	for i := 0; i < 20; i++ {
+		sub, keyFrameRequests, err := NewVideoSubscription(
+			info,
+			peerConnectionController{local},
+			Config{},
+			logrus.NewEntry(logrus.New()),
+			telemetryBuilder,
+		)
+		if err != nil {
+			t.Fatalf("Failed to subscribe: %v", err)
+		}
+
+		// The track does not take the key frame requests once the subscription is gone.
+		go func() {
+			<-keyFrameRequests
+		}()
+
+		// The track is negotiated, so that the RTCP reader actually waits for the packets.
+		negotiate(t, local, remote)
+
+		// The detached senders stay in place, so their readers must be stopped by the subscription.
+		if i%2 == 0 {
+			err = sub.Detach()
+		} else {
+			err = sub.Unsubscribe()
+		}
+		if err != nil {
+			t.Fatalf("Failed to stop the subscription: %v", err)
+		}
+	}
+
+	waitFor(t, func() bool { return rtcpReaders() == 0 })
+}
+
+// Converts the NTP time of a sender report to the wall clock time.
+func ntpToTime(ntpTime uint64) time.Time {
+	const ntpEpochOffset = 2208988800 // Seconds between 1900 (NTP) and 1970 (Unix).
+	seconds := int64(ntpTime>>32) - ntpEpochOffset
+	nanoseconds := int64((ntpTime & 0xffffffff) * uint64(time.Second) >> 32)
+	return time.Unix(seconds, nanoseconds)
+}
+
+func TestSenderReportsUseRewrittenTimestamps(t *testing.T) {
+	// The factory registers the same interceptors as the ones that the subscribers get.
+	factory, err := webrtc_ext.NewPeerConnectionFactory(webrtc_ext.Config{})
+	if err != nil {
+		t.Fatalf("Failed to create peer connection factory: %v", err)
+	}
+
+	sender, err := factory.CreatePeerConnection()
+	if err != nil {
+		t.Fatalf("Failed to create peer connection: %v", err)
+	}
+	defer sender.Close()
+
+	receiver, err := factory.CreatePeerConnection()
+	if err != nil {
+		t.Fatalf("Failed to create peer connection: %v", err)
+	}
+	defer receiver.Close()
+
+	info := webrtc_ext.TrackInfo{
+		TrackID:  "track",
+		StreamID: "stream",
+		Kind:     webrtc.RTPCodecTypeVideo,
+		Codec:    webrtc.RTPCodecCapability{MimeType: webrtc.MimeTypeVP8, ClockRate: 90000},
+	}
+
+	// The payload is not inspected, so that any packet is forwarded.
+	sub, _, err := NewVideoSubscription(
+		info,
+		peerConnectionController{sender},
+		Config{Encrypted: true},
+		logrus.NewEntry(logrus.New()),
+		telemetry.NewTelemetry(context.Background(), "PublishedTrack").ChildBuilder(),
+	)
+	if err != nil {
+		t.Fatalf("Failed to subscribe: %v", err)
+	}
+	defer sub.Unsubscribe()
+
+	reports := make(chan *rtcp.SenderReport, 1)
+	receiver.OnTrack(func(_ *webrtc.TrackRemote, rtpReceiver *webrtc.RTPReceiver) {
+		for {
+			packets, _, err := rtpReceiver.ReadRTCP()
+			if err != nil {
+				return
+			}
+
+			for _, packet := range packets {
+				if report, ok := packet.(*rtcp.SenderReport); ok {
+					select {
+					case reports <- report:
+					default:
+					}
+				}
+			}
+		}
+	})
+
+	negotiate(t, sender, receiver)
+
+	// The publisher's timestamps start at an arbitrary point (here right before they wrap around), while
+	// the rewritten ones start at 0. The packets are sent every 10 ms, i.e. 900 ticks of the clock apart.
+	const publisherBase, interval = 0xffffff00, 900
+	var writes []time.Time
+
+	timeout := time.After(5 * time.Second)
+	ticker := time.NewTicker(10 * time.Millisecond)
+	defer ticker.Stop()
+
+	for i := 0; ; i++ {
+		select {
+		case report := <-reports:
+			if report.SSRC != uint32(sub.OutgoingSSRC()) {
+				t.Fatalf("Expected the sender report of the outgoing SSRC %d, got %d", sub.OutgoingSSRC(), report.SSRC)
+			}
+
+			// The rewritten timestamp that corresponds to the NTP time of the report, based on the last
+			// packet written before it.
+			reportTime := ntpToTime(report.NTPTime)
+			last := len(writes) - 1
+			for last > 0 && writes[last].After(reportTime) {
+				last--
+			}
+			expected := uint32(last*interval) + uint32(reportTime.Sub(writes[last]).Seconds()*90000)
+
+			// The packets are written asynchronously, so the mapping is only accurate to a few milliseconds.
+			if difference := int32(report.RTPTime - expected); difference < -900 || difference > 900 {
+				t.Errorf("Expected the RTP time of the sender report to be around %d, got %d", expected, report.RTPTime)
+			}
+
+			return
+		case <-timeout:
+			t.Fatal("Timed out waiting for the sender report")
+		case <-ticker.C:
+			writes = append(writes, time.Now())
+			packet := rtp.Packet{Header: rtp.Header{
+				Version:        2,
+				SSRC:           1111,
+				SequenceNumber: uint16(i),
+				Timestamp:      publisherBase + uint32(i*interval),
+			}}
+			if err := sub.WriteRTP(packet); err != nil {
+				t.Fatalf("Failed to write packet: %v", err)
+			}
+		}
+	}
+}
@@ -0,0 +1,58 @@
+package conference
+
+import (
+	"errors"
+
+	"github.com/matrix-org/waterfall/pkg/conference/participant"
+	"github.com/matrix-org/waterfall/pkg/conference/track"
+	"maunium.net/go/mautrix/event"
+)
+
+// Sent by the SFU over the data channel when a subscription requested by the participant failed.
+var FocusCallTrackSubscriptionFailed = event.Type{Type: "m.call.track_subscription_failed", Class: event.FocusEventType}
+
+// Machine-readable reason of the failure.
+type ErrorCode string
+
+const (
+	// The requested track does not exist (anymore).
+	ErrorCodeTrackNotFound ErrorCode = "track_not_found"
+	// The track exists, but none of its layers is available at the moment.
+	ErrorCodeLayerUnavailable ErrorCode = "layer_unavailable"
+	// The track already has as many subscribers as it's allowed to have.
+	ErrorCodeTooManySubscribers ErrorCode = "too_many_subscribers"
+	// The subscriber does not support the codec of the track.
+	ErrorCodeIncompatibleCodec ErrorCode = "incompatible_codec"
+	// Subscribing to the track failed for some other reason.
+	ErrorCodeSubscriptionFailed ErrorCode = "subscription_failed"
+)
+
+// Content of the `m.call.track_subscription_failed` event.
+type TrackSubscriptionFailedEventContent struct {
+	TrackID string `json:"track_id"`
+	// Human-readable description of the failure.
+	Reason string    `json:"reason"`
+	Code   ErrorCode `json:"code"`
+}
+
+// Creates an event that informs the participant about a failed subscription to a given track.
+func newSubscriptionFailedEvent(trackID track.TrackID, err error) event.Event {
+	code := ErrorCodeSubscriptionFailed
+	switch {
+	case errors.Is(err, participant.ErrTrackNotFound):
+		code = ErrorCodeTrackNotFound
+	case errors.Is(err, track.ErrNoPublisher):
+		code = ErrorCodeLayerUnavailable
+	case errors.Is(err, track.ErrTooManySubscribers):
+		code = ErrorCodeTooManySubscribers
+	case errors.Is(err, track.ErrIncompatibleCodec):
+		code = ErrorCodeIncompatibleCodec
+	}
+
+	return event.Event{
+		Type: FocusCallTrackSubscriptionFailed,
+		Content: event.Content{
+			Parsed: TrackSubscriptionFailedEventContent{TrackID: trackID, Reason: err.Error(), Code: code},
+		},
+	}
+}
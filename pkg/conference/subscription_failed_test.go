@@ -0,0 +1,128 @@
+package conference //nolint:testpackage
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"testing"
+
+	"github.com/matrix-org/waterfall/pkg/conference/participant"
+	"github.com/matrix-org/waterfall/pkg/conference/track"
+	"github.com/matrix-org/waterfall/pkg/peer"
+	"github.com/matrix-org/waterfall/pkg/telemetry"
+	"github.com/sirupsen/logrus"
+)
+
+func TestSubscribeToNonexistentTrack(t *testing.T) {
+	tracker, _ := participant.NewParticipantTracker(make(chan struct{}), track.Config{})
+
+	alice := participant.ID{UserID: "@alice:example.org", DeviceID: "ALICE"}
+	tracker.AddParticipant(&participant.Participant{ID: alice})
+
+	err := tracker.Subscribe(alice, "missing", 640, 480)
+	if err == nil {
+		t.Fatal("Expected subscription to a nonexistent track to fail")
+	}
+
+	failedEvent := newSubscriptionFailedEvent("missing", err)
+	if failedEvent.Type != FocusCallTrackSubscriptionFailed {
+		t.Fatalf("Expected %s, got %s", FocusCallTrackSubscriptionFailed.Type, failedEvent.Type.Type)
+	}
+
+	raw, err := json.Marshal(failedEvent.Content.Parsed)
+	if err != nil {
+		t.Fatalf("Failed to marshal the event: %v", err)
+	}
+
+	var content map[string]string
+	if err := json.Unmarshal(raw, &content); err != nil {
+		t.Fatalf("Failed to unmarshal the event: %v", err)
+	}
+
+	if content["code"] != string(ErrorCodeTrackNotFound) || content["track_id"] != "missing" || content["reason"] == "" {
+		t.Errorf("Unexpected event content: %v", content)
+	}
+}
+
+func TestSubscriberLimit(t *testing.T) {
+	conferenceEnded := make(chan struct{})
+	defer close(conferenceEnded)
+
+	tracker, _ := participant.NewParticipantTracker(conferenceEnded, track.Config{MaxSubscribers: 1})
+	logger := logrus.NewEntry(logrus.New())
+	tel := telemetry.NewTelemetry(context.Background(), "Conference")
+
+	alice := participant.ID{UserID: "@alice:example.org", DeviceID: "ALICE", CallID: "call"}
+	bob := participant.ID{UserID: "@bob:example.org", DeviceID: "BOB", CallID: "call"}
+	carol := participant.ID{UserID: "@carol:example.org", DeviceID: "CAROL", CallID: "call"}
+
+	tracker.AddParticipant(&participant.Participant{ID: alice, Logger: logger, Telemetry: tel})
+	for _, id := range []participant.ID{bob, carol} {
+		tracker.AddParticipant(&participant.Participant{ID: id, Peer: newSubscriberPeer(t, id), Logger: logger, Telemetry: tel})
+	}
+
+	for _, remoteTrack := range publishAudioTracks(t, "stream", "mic") {
+		if err := tracker.AddPublishedTrack(alice, remoteTrack, track.TrackMetadata{}); err != nil {
+			t.Fatalf("Failed to publish %s: %v", remoteTrack.ID(), err)
+		}
+	}
+
+	if err := tracker.Subscribe(bob, "mic", 0, 0); err != nil {
+		t.Fatalf("Expected the first subscriber to be accepted: %v", err)
+	}
+
+	// Updating the existing subscription does not count as a new subscriber.
+	if err := tracker.Subscribe(bob, "mic", 0, 0); err != nil {
+		t.Errorf("Expected the existing subscriber to be accepted: %v", err)
+	}
+
+	err := tracker.Subscribe(carol, "mic", 0, 0)
+	if !errors.Is(err, track.ErrTooManySubscribers) {
+		t.Fatalf("Expected the second subscriber to be rejected, got %v", err)
+	}
+
+	content, ok := newSubscriptionFailedEvent("mic", err).Content.Parsed.(TrackSubscriptionFailedEventContent)
+	if !ok || content.Code != ErrorCodeTooManySubscribers || content.TrackID != "mic" {
+		t.Errorf("Unexpected event content: %+v", content)
+	}
+}
+
+func TestUnnegotiatedSubscriptionIsRolledBack(t *testing.T) {
+	conference := newTestConference(t)
+	tracker := conference.tracker
+	logger := logrus.NewEntry(logrus.New())
+	tel := telemetry.NewTelemetry(context.Background(), "Conference")
+
+	alice := participant.ID{UserID: "@alice:example.org", DeviceID: "ALICE", CallID: "call"}
+	bob := participant.ID{UserID: "@bob:example.org", DeviceID: "BOB", CallID: "call"}
+
+	tracker.AddParticipant(&participant.Participant{ID: alice, Logger: logger, Telemetry: tel})
+	tracker.AddParticipant(&participant.Participant{ID: bob, Peer: newSubscriberPeer(t, bob), Logger: logger, Telemetry: tel})
+
+	for _, remoteTrack := range publishAudioTracks(t, "stream", "mic", "screen-audio") {
+		if err := tracker.AddPublishedTrack(alice, remoteTrack, track.TrackMetadata{}); err != nil {
+			t.Fatalf("Failed to publish %s: %v", remoteTrack.ID(), err)
+		}
+	}
+
+	for _, trackID := range []string{"mic", "screen-audio"} {
+		if err := tracker.Subscribe(bob, trackID, 0, 0); err != nil {
+			t.Fatalf("Failed to subscribe to %s: %v", trackID, err)
+		}
+	}
+
+	// The offer carrying the microphone could not be created, so Bob would never get it.
+	conference.processRenegotiationFailedMessage(bob, peer.RenegotiationFailed{
+		TrackIDs: []string{"mic"},
+		Err:      errors.New("failed to create offer"),
+	})
+
+	mappings := tracker.SSRCMappings()
+	if len(mappings) != 1 || mappings[0].TrackID != "screen-audio" {
+		t.Errorf("Expected only the negotiated subscription to be kept, got %+v", mappings)
+	}
+
+	if senders := tracker.GetParticipant(bob).Peer.ActiveSenders(); senders != 1 {
+		t.Errorf("Expected the sender of the rolled back subscription to be removed, got %d senders", senders)
+	}
+}
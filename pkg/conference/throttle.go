@@ -0,0 +1,31 @@
+package conference
+
+import "time"
+
+// How many times less often the periodic checks run while the conference is throttled.
+const mutedThrottleFactor = 5
+
+// A ticker of a periodic check of the conference that can be slowed down while the conference is muted.
+type throttledTicker struct {
+	*time.Ticker
+	interval  time.Duration
+	throttled bool
+}
+
+func newThrottledTicker(interval time.Duration) *throttledTicker {
+	return &throttledTicker{time.NewTicker(interval), interval, false}
+}
+
+// Slows the ticker down or restores its original interval (unless it's already the case).
+func (t *throttledTicker) throttle(throttled bool) {
+	if t.throttled == throttled {
+		return
+	}
+
+	t.throttled = throttled
+	if throttled {
+		t.Reset(t.interval * mutedThrottleFactor)
+	} else {
+		t.Reset(t.interval)
+	}
+}
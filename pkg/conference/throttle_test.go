@@ -0,0 +1,33 @@
+package conference //nolint:testpackage
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+func BenchmarkThrottledTicker(b *testing.B) {
+	for _, muted := range []bool{false, true} {
+		b.Run(fmt.Sprintf("muted=%v", muted), func(b *testing.B) {
+			wakeups := 0
+			for i := 0; i < b.N; i++ {
+				ticker := newThrottledTicker(time.Millisecond)
+				ticker.throttle(muted)
+
+				deadline := time.After(20 * time.Millisecond)
+				for waiting := true; waiting; {
+					select {
+					case <-ticker.C:
+						wakeups++
+					case <-deadline:
+						waiting = false
+					}
+				}
+
+				ticker.Stop()
+			}
+
+			b.ReportMetric(float64(wakeups)/float64(b.N), "wakeups/op")
+		})
+	}
+}
@@ -0,0 +1,64 @@
+package conference //nolint:testpackage
+
+import (
+	"context"
+	"testing"
+
+	"github.com/matrix-org/waterfall/pkg/conference/participant"
+	"github.com/matrix-org/waterfall/pkg/conference/track"
+	"github.com/matrix-org/waterfall/pkg/telemetry"
+	"github.com/sirupsen/logrus"
+	"github.com/sirupsen/logrus/hooks/test"
+)
+
+func TestTraceIDInSubscriptionLogs(t *testing.T) {
+	conferenceEnded := make(chan struct{})
+	defer close(conferenceEnded)
+
+	tracker, _ := participant.NewParticipantTracker(conferenceEnded, track.Config{})
+	tel := telemetry.NewTelemetry(context.Background(), "Conference")
+
+	alice := participant.ID{UserID: "@alice:example.org", DeviceID: "ALICE", CallID: "call"}
+	bob := participant.ID{UserID: "@bob:example.org", DeviceID: "BOB", CallID: "call"}
+
+	alicePeer, remoteTracks, _ := publishVideoTracks(t, alice, "camera")
+	tracker.AddParticipant(&participant.Participant{
+		ID:        alice,
+		Peer:      alicePeer,
+		Logger:    logrus.NewEntry(logrus.New()),
+		Telemetry: tel,
+	})
+
+	if err := tracker.AddPublishedTrack(alice, remoteTracks[0], track.TrackMetadata{}); err != nil {
+		t.Fatalf("Failed to publish the track: %v", err)
+	}
+
+	logger, hook := test.NewNullLogger()
+	traceID := participant.NewTraceID()
+	tracker.AddParticipant(&participant.Participant{
+		ID:        bob,
+		TraceID:   traceID,
+		Peer:      newSubscriberPeer(t, bob),
+		Logger:    logger.WithField("trace_id", traceID),
+		Telemetry: tel,
+	})
+
+	if err := tracker.Subscribe(bob, "camera", 0, 0); err != nil {
+		t.Fatalf("Failed to subscribe: %v", err)
+	}
+	tracker.Unsubscribe(bob, "camera")
+
+	for _, entry := range hook.AllEntries() {
+		if entry.Message != "Unsubscribed" {
+			continue
+		}
+
+		if entry.Data["trace_id"] != traceID || entry.Data["track"] != "camera" {
+			t.Errorf("Expected the subscription to be logged with the trace ID %s, got %v", traceID, entry.Data)
+		}
+
+		return
+	}
+
+	t.Errorf("Expected the subscription to log its removal, got %d entries", len(hook.AllEntries()))
+}
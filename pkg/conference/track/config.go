@@ -0,0 +1,100 @@
+package track
+
+import (
+	"time"
+
+	"github.com/matrix-org/waterfall/pkg/conference/publisher"
+	"github.com/matrix-org/waterfall/pkg/conference/subscription"
+	"github.com/matrix-org/waterfall/pkg/webrtc_ext"
+)
+
+// Configuration of the published tracks.
+type Config struct {
+	// After which time the publisher is considered stalled if there are no packets.
+	StallTimeout time.Duration
+	// Like `StallTimeout`, but for the screen shares. Their content is mostly static, so the encoders
+	// may send very few packets for a while (`StallTimeout` if not set).
+	ScreenshareStallTimeout time.Duration
+	// The minimal interval between the key frame requests of the subscribers that are forwarded to a
+	// publisher, the ones that come sooner are dropped (unlimited if not set).
+	KeyFrameRequestInterval time.Duration
+	// Like `KeyFrameRequestInterval`, but for the screen shares (`KeyFrameRequestInterval` if not set).
+	ScreenshareKeyFrameRequestInterval time.Duration
+	// Simulated network impairment for testing (disabled by default).
+	Impairment publisher.Impairment
+	// Diagnostic validation of the publishers' timestamps against the clock rate (disabled by default).
+	ClockRateValidation publisher.ClockRateValidation
+	// Detect the resolution of the layers from their key frames, see `PublishedTrack.LayerResolutions()`.
+	// The end-to-end encrypted tracks are never inspected.
+	DetectFrameSize bool
+	// The layer for the subscribers that don't specify the desired resolution (low if not set).
+	DefaultLayer webrtc_ext.SimulcastLayer
+	// Configuration of the video subscriptions to the track.
+	Subscription subscription.Config
+	// How the simulcast layers are selected for the subscribers (auto if not set).
+	SimulcastMode SimulcastMode
+	// The layer that all subscribers get when the simulcast mode is off (highest available if not set).
+	FixedLayer webrtc_ext.SimulcastLayer
+	// After which time a stalled publisher that has been superseded by a newer one is removed
+	// (`defaultStalePublisherTimeout` if not set).
+	StalePublisherTimeout time.Duration
+	// How many subscribers a single track may have at most (unlimited if not set).
+	MaxSubscribers int
+	// What happens to the subscriptions when the track's publisher leaves (unsubscribe if not set).
+	PublisherLeftPolicy PublisherLeftPolicy
+	// How many layers of a video track are forwarded at most (unlimited if not set). Only the lowest
+	// simulcast layers are accepted, the publishers of the other ones are ignored.
+	MaxLayers int
+	// The maximum bitrates (in bits per second) of the simulcast layers that are hinted to the subscribers
+	// in the SDP (none if not set). The video without simulcast is hinted the bitrate of the high layer.
+	MaxLayerBitrates map[webrtc_ext.SimulcastLayer]uint64
+}
+
+// Normally the stalled publishers recover quickly (e.g. after a network hiccup), so we give
+// them quite some time before we consider them dead.
+const defaultStalePublisherTimeout = 30 * time.Second
+
+func (c Config) stallTimeout(metadata TrackMetadata) time.Duration {
+	if metadata.Screenshare && c.ScreenshareStallTimeout > 0 {
+		return c.ScreenshareStallTimeout
+	}
+
+	return c.StallTimeout
+}
+
+func (c Config) keyFrameRequestInterval(metadata TrackMetadata) time.Duration {
+	if metadata.Screenshare && c.ScreenshareKeyFrameRequestInterval > 0 {
+		return c.ScreenshareKeyFrameRequestInterval
+	}
+
+	return c.KeyFrameRequestInterval
+}
+
+func (c Config) stalePublisherTimeout() time.Duration {
+	if c.StalePublisherTimeout <= 0 {
+		return defaultStalePublisherTimeout
+	}
+
+	return c.StalePublisherTimeout
+}
+
+// Defines how the simulcast layers are selected for the subscribers.
+type SimulcastMode string
+
+const (
+	// The layer is selected based on the requested resolution and switched when the publishers stall.
+	SimulcastModeAuto SimulcastMode = "auto"
+	// All subscribers get a single fixed layer that is never switched, even if its publisher stalls.
+	SimulcastModeOff SimulcastMode = "off"
+)
+
+// Defines what happens to the subscriptions of a track once its publisher is gone.
+type PublisherLeftPolicy string
+
+const (
+	// Remove the track from the subscribers and renegotiate right away (default).
+	PublisherLeftPolicyUnsubscribe PublisherLeftPolicy = "unsubscribe"
+	// Stop forwarding, but keep the track (and its transceiver) until the subscriber's next
+	// renegotiation, so that the last frame stays frozen and the m-lines don't churn.
+	PublisherLeftPolicyFreeze PublisherLeftPolicy = "freeze"
+)
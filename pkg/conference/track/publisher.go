@@ -1,6 +1,7 @@
 package track
 
 import (
+	"fmt"
 	"time"
 
 	"github.com/matrix-org/waterfall/pkg/conference/publisher"
@@ -8,6 +9,7 @@ import (
 	"github.com/matrix-org/waterfall/pkg/webrtc_ext"
 	"github.com/pion/webrtc/v3"
 	"github.com/sirupsen/logrus"
+	"go.opentelemetry.io/otel/attribute"
 )
 
 // Represents a single publisher (i.e. a single `RemoteTrack`), in most cases it's a single simulcast layer.
@@ -25,6 +27,12 @@ type trackPublisher struct {
 	logger *logrus.Entry
 	// Scoped telemetry.
 	telemetry *telemetry.Telemetry
+	// When the publisher has been created.
+	created time.Time
+	// When the publisher has stalled (zero if it's active). Protected by the mutex of the track.
+	stalledSince time.Time
+	// When a key frame has been requested on behalf of the subscribers. Protected by the mutex of the track.
+	lastKeyFrameRequest time.Time
 }
 
 func newTrackPublisher(
@@ -32,6 +40,9 @@ func newTrackPublisher(
 	reqKeyFrameFn func(track *webrtc.TrackRemote) error,
 	stopPublishers <-chan struct{},
 	stallTimeout time.Duration,
+	impairment publisher.Impairment,
+	clockRateValidation publisher.ClockRateValidation,
+	detectFrameSize bool,
 	layer webrtc_ext.SimulcastLayer,
 	logger *logrus.Entry,
 	telemetry *telemetry.Telemetry,
@@ -40,10 +51,30 @@ func newTrackPublisher(
 		&publisher.RemoteTrack{track},
 		stopPublishers,
 		stallTimeout,
+		impairment,
 		logger,
 	)
 
-	return &trackPublisher{pub, pubCh, reqKeyFrameFn, layer, logger, telemetry}
+	declared := track.Codec().ClockRate
+	pub.ValidateClockRate(declared, clockRateValidation, func(observed uint32) {
+		telemetry.AddEvent("clock rate mismatch",
+			attribute.Int64("declared", int64(declared)),
+			attribute.Int64("observed", int64(observed)),
+		)
+	})
+	if detectFrameSize {
+		pub.DetectFrameSize(track.Codec().MimeType)
+	}
+
+	return &trackPublisher{
+		publisher:         pub,
+		eventsChannel:     pubCh,
+		requestKeyFrameFn: reqKeyFrameFn,
+		layer:             layer,
+		logger:            logger,
+		telemetry:         telemetry,
+		created:           time.Now(),
+	}
 }
 
 func (p *trackPublisher) addSubscription(subscription publisher.Subscription) {
@@ -68,6 +99,29 @@ func (p *trackPublisher) isStalled() bool {
 }
 
 func (p *trackPublisher) requestKeyFrame() error {
-	track := p.publisher.GetTrack().(*publisher.RemoteTrack) //nolint:forcetypeassert
+	// Only the remote tracks can be asked for the key frames (the fake ones in tests can't).
+	track, ok := p.publisher.GetTrack().(*publisher.RemoteTrack)
+	if !ok {
+		return fmt.Errorf("can't request a key frame from %T", p.publisher.GetTrack())
+	}
+
 	return p.requestKeyFrameFn(track.Track)
 }
+
+// Returns the incoming bitrate (in bits per second) of the layer.
+func (p *trackPublisher) bitrate() uint64 {
+	return p.publisher.Bitrate()
+}
+
+// Returns the resolution of the layer as of its last key frame, zeros if not known.
+func (p *trackPublisher) frameSize() (int, int) {
+	return p.publisher.FrameSize()
+}
+
+func (p *trackPublisher) ssrc() webrtc.SSRC {
+	if track, ok := p.publisher.GetTrack().(*publisher.RemoteTrack); ok {
+		return track.Track.SSRC()
+	}
+
+	return 0
+}
@@ -0,0 +1,147 @@
+package track
+
+import (
+	"time"
+
+	"github.com/matrix-org/waterfall/pkg/webrtc_ext"
+	"github.com/pion/rtcp"
+	"go.opentelemetry.io/otel/attribute"
+)
+
+// How often the aggregated receiver reports are sent to the publisher.
+const receiverReportInterval = 1 * time.Second
+
+// A subscription that knows how the subscriber receives the forwarded packets.
+type receptionReporter interface {
+	ReceptionReport() (rtcp.ReceptionReport, bool)
+}
+
+// Periodically sends the receiver reports to the publisher, so that its bandwidth estimation could
+// react to the conditions of the subscribers. The reports of all subscribers of a layer are merged
+// into a single one that represents the worst-case subscriber.
+func (p *PublishedTrack[SubscriberID]) sendReceiverReports() {
+	ticker := time.NewTicker(receiverReportInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-p.done:
+			return
+		case <-ticker.C:
+			if reports := p.receptionReports(); len(reports) > 0 {
+				if err := p.owner.writeRTCP([]rtcp.Packet{&rtcp.ReceiverReport{Reports: reports}}); err != nil {
+					p.logger.WithError(err).Debug("Failed to send receiver report")
+				}
+			}
+		}
+	}
+}
+
+// Returns the aggregated reception report for each layer that has subscribers that reported about it.
+func (p *PublishedTrack[SubscriberID]) receptionReports() []rtcp.ReceptionReport {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	layers := make(map[webrtc_ext.SimulcastLayer][]rtcp.ReceptionReport)
+	for _, sub := range p.subscriptions {
+		if reporter, ok := sub.subscription.(receptionReporter); ok {
+			if report, ok := reporter.ReceptionReport(); ok {
+				layers[sub.currentLayer] = append(layers[sub.currentLayer], report)
+			}
+		}
+	}
+
+	reports := []rtcp.ReceptionReport{}
+	for layer, subscriberReports := range layers {
+		if publisher := p.video.publishers[layer]; publisher != nil {
+			reports = append(reports, aggregateReceptionReports(uint32(publisher.ssrc()), subscriberReports))
+		}
+	}
+
+	return reports
+}
+
+// Merges the reports of the subscribers into a report about the publisher's stream with a given SSRC.
+// The loss and jitter are the worst ones among the subscribers. The sequence numbers and the sender
+// report timestamps of the subscribers refer to our outgoing streams, so they're meaningless for
+// the publisher and are left empty.
+func aggregateReceptionReports(ssrc uint32, reports []rtcp.ReceptionReport) rtcp.ReceptionReport {
+	aggregated := rtcp.ReceptionReport{SSRC: ssrc}
+	for _, report := range reports {
+		if report.FractionLost > aggregated.FractionLost {
+			aggregated.FractionLost = report.FractionLost
+		}
+		if report.TotalLost > aggregated.TotalLost {
+			aggregated.TotalLost = report.TotalLost
+		}
+		if report.Jitter > aggregated.Jitter {
+			aggregated.Jitter = report.Jitter
+		}
+	}
+
+	return aggregated
+}
+
+// How often the incoming bitrate of the layers is added to the telemetry.
+const bitrateReportInterval = 10 * time.Second
+
+// Periodically adds the incoming bitrate of each layer to the telemetry of the layer.
+func (p *PublishedTrack[SubscriberID]) reportBitrates() {
+	ticker := time.NewTicker(bitrateReportInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-p.done:
+			return
+		case <-ticker.C:
+			p.mutex.Lock()
+			for _, publisher := range p.video.publishers {
+				publisher.telemetry.AddEvent("bitrate", attribute.Int64("bps", int64(publisher.bitrate())))
+			}
+			p.mutex.Unlock()
+		}
+	}
+}
+
+// How a subscriber receives the track according to its latest reception report.
+type ReceptionStats struct {
+	// Fraction (from 0 to 1) of the packets lost since the previous report.
+	FractionLost float64
+	// Interarrival jitter.
+	Jitter time.Duration
+}
+
+// Returns how a given subscriber receives the track (if it has reported about it).
+func (p *PublishedTrack[SubscriberID]) ReceptionStats(subscriberID SubscriberID) (ReceptionStats, bool) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	sub := p.subscriptions[subscriberID]
+	if sub == nil {
+		return ReceptionStats{}, false
+	}
+
+	reporter, ok := sub.subscription.(receptionReporter)
+	if !ok {
+		return ReceptionStats{}, false
+	}
+
+	report, ok := reporter.ReceptionReport()
+	if !ok {
+		return ReceptionStats{}, false
+	}
+
+	return receptionStats(report, p.info.Codec.ClockRate), true
+}
+
+// Converts the reception report to the stats. The jitter of the report is measured in
+// the timestamp units, so the clock rate of the codec is needed to get the time out of it.
+func receptionStats(report rtcp.ReceptionReport, clockRate uint32) ReceptionStats {
+	stats := ReceptionStats{FractionLost: float64(report.FractionLost) / 256}
+	if clockRate > 0 {
+		stats.Jitter = time.Duration(report.Jitter) * time.Second / time.Duration(clockRate)
+	}
+
+	return stats
+}
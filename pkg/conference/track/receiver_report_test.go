@@ -0,0 +1,205 @@
+package track //nolint:testpackage
+
+import (
+	"context"
+	"reflect"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/matrix-org/waterfall/pkg/telemetry"
+	"github.com/pion/rtcp"
+	"github.com/pion/rtp"
+	"github.com/pion/webrtc/v3"
+	"github.com/sirupsen/logrus"
+)
+
+func TestAggregateReceptionReports(t *testing.T) {
+	subscribers := []rtcp.ReceptionReport{
+		{SSRC: 1111, FractionLost: 10, TotalLost: 500, LastSequenceNumber: 100, Jitter: 30, LastSenderReport: 1},
+		{SSRC: 2222, FractionLost: 64, TotalLost: 20, LastSequenceNumber: 200, Jitter: 10, Delay: 5},
+		{SSRC: 3333, FractionLost: 0, TotalLost: 0, LastSequenceNumber: 300, Jitter: 90},
+	}
+
+	expected := rtcp.ReceptionReport{SSRC: 4444, FractionLost: 64, TotalLost: 500, Jitter: 90}
+	if report := aggregateReceptionReports(4444, subscribers); !reflect.DeepEqual(report, expected) {
+		t.Errorf("Expected %+v, got %+v", expected, report)
+	}
+}
+
+func TestAggregateReceptionReportsMarshal(t *testing.T) {
+	report := aggregateReceptionReports(4444, []rtcp.ReceptionReport{{SSRC: 1111, FractionLost: 25, Jitter: 7}})
+
+	raw, err := (&rtcp.ReceiverReport{Reports: []rtcp.ReceptionReport{report}}).Marshal()
+	if err != nil {
+		t.Fatalf("Failed to marshal receiver report: %v", err)
+	}
+
+	var parsed rtcp.ReceiverReport
+	if err := parsed.Unmarshal(raw); err != nil {
+		t.Fatalf("Failed to unmarshal receiver report: %v", err)
+	}
+
+	if len(parsed.Reports) != 1 || parsed.Reports[0] != report {
+		t.Errorf("Expected %+v, got %+v", report, parsed.Reports)
+	}
+}
+
+func TestReceptionStats(t *testing.T) {
+	stats := receptionStats(rtcp.ReceptionReport{FractionLost: 64, Jitter: 4800}, 48000)
+
+	if stats.FractionLost != 0.25 {
+		t.Errorf("Expected the fraction lost of 0.25, got %v", stats.FractionLost)
+	}
+
+	if stats.Jitter != 100*time.Millisecond {
+		t.Errorf("Expected the jitter of 100ms, got %v", stats.Jitter)
+	}
+}
+
+// Publishes a video track over a pair of peer connections and returns it as it is seen by the receiver along
+// with the receiver itself.
+func publishVideoTrack(t *testing.T) (*webrtc.TrackRemote, *webrtc.PeerConnection) {
+	t.Helper()
+
+	sender, err := webrtc.NewPeerConnection(webrtc.Configuration{})
+	if err != nil {
+		t.Fatalf("Failed to create sender: %v", err)
+	}
+	t.Cleanup(func() { sender.Close() })
+
+	receiver, err := webrtc.NewPeerConnection(webrtc.Configuration{})
+	if err != nil {
+		t.Fatalf("Failed to create receiver: %v", err)
+	}
+	t.Cleanup(func() { receiver.Close() })
+
+	codec := webrtc.RTPCodecCapability{MimeType: webrtc.MimeTypeVP8}
+	localTrack, err := webrtc.NewTrackLocalStaticRTP(codec, "video", "stream")
+	if err != nil {
+		t.Fatalf("Failed to create track: %v", err)
+	}
+	if _, err := sender.AddTrack(localTrack); err != nil {
+		t.Fatalf("Failed to add track: %v", err)
+	}
+
+	received := make(chan *webrtc.TrackRemote, 1)
+	receiver.OnTrack(func(remote *webrtc.TrackRemote, _ *webrtc.RTPReceiver) { received <- remote })
+
+	offer, err := sender.CreateOffer(nil)
+	if err != nil {
+		t.Fatalf("Failed to create offer: %v", err)
+	}
+	gathered := webrtc.GatheringCompletePromise(sender)
+	if err := sender.SetLocalDescription(offer); err != nil {
+		t.Fatalf("Failed to set local description: %v", err)
+	}
+	<-gathered
+
+	if err := receiver.SetRemoteDescription(*sender.LocalDescription()); err != nil {
+		t.Fatalf("Failed to set remote description: %v", err)
+	}
+	answer, err := receiver.CreateAnswer(nil)
+	if err != nil {
+		t.Fatalf("Failed to create answer: %v", err)
+	}
+	gathered = webrtc.GatheringCompletePromise(receiver)
+	if err := receiver.SetLocalDescription(answer); err != nil {
+		t.Fatalf("Failed to set local description: %v", err)
+	}
+	<-gathered
+
+	if err := sender.SetRemoteDescription(*receiver.LocalDescription()); err != nil {
+		t.Fatalf("Failed to set remote description: %v", err)
+	}
+
+	// Pion fires the track once the first packets arrive.
+	ticker := time.NewTicker(20 * time.Millisecond)
+	defer ticker.Stop()
+	timeout := time.After(10 * time.Second)
+
+	for sequenceNumber := uint16(0); ; sequenceNumber++ {
+		select {
+		case remote := <-received:
+			return remote, receiver
+		case <-ticker.C:
+			localTrack.WriteRTP(&rtp.Packet{ //nolint:errcheck
+				Header:  rtp.Header{Version: 2, SequenceNumber: sequenceNumber},
+				Payload: []byte{0x00},
+			})
+		case <-timeout:
+			t.Fatal("Timed out waiting for the published track")
+		}
+	}
+}
+
+// Counts the goroutines that are running any of the given functions.
+func countGoroutines(functions ...string) int {
+	buf := make([]byte, 1<<16)
+	for {
+		n := runtime.Stack(buf, true)
+		if n < len(buf) {
+			buf = buf[:n]
+			break
+		}
+		buf = make([]byte, 2*len(buf))
+	}
+
+	count := 0
+	for _, stack := range strings.Split(string(buf), "\n\n") {
+		for _, function := range functions {
+			if strings.Contains(stack, function) {
+				count++
+				break
+			}
+		}
+	}
+
+	return count
+}
+
+func TestEndedTrackStopsItsGoroutines(t *testing.T) {
+	// There is no ticker that requests the key frames periodically, they are requested on demand by the
+	// publisher's read loop, while the receiver reports (RTCP) and the bitrates are sent by the tickers.
+	perTrack := []string{".sendReceiverReports(", ".reportBitrates(", ".observePublisher(", "publisher.NewPublisher."}
+	before := countGoroutines(perTrack...)
+
+	remoteTrack, receiver := publishVideoTrack(t)
+	published, err := NewPublishedTrack[testSubscriber](
+		"owner",
+		func(*webrtc.TrackRemote) error { return nil },
+		func([]rtcp.Packet) error { return nil },
+		remoteTrack,
+		TrackMetadata{},
+		Config{StallTimeout: time.Hour},
+		logrus.NewEntry(logrus.New()),
+		telemetry.NewTelemetry(context.Background(), "Participant").ChildBuilder(),
+	)
+	if err != nil {
+		t.Fatalf("Failed to publish track: %v", err)
+	}
+
+	if running := countGoroutines(perTrack...) - before; running < len(perTrack) {
+		t.Fatalf("Expected the track to run %d goroutines, found %d", len(perTrack), running)
+	}
+
+	// The track ends once its peer connection is gone, i.e. reading from it fails.
+	receiver.Close()
+
+	select {
+	case <-published.done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Expected the track to stop once it ended")
+	}
+
+	for deadline := time.Now().Add(time.Second); ; time.Sleep(10 * time.Millisecond) {
+		leaked := countGoroutines(perTrack...) - before
+		if leaked <= 0 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("Expected all goroutines of the track to stop, %d are still running", leaked)
+		}
+	}
+}
@@ -10,6 +10,32 @@ import (
 type TrackMetadata struct {
 	MaxWidth, MaxHeight int
 	Muted               bool
+	// The payload of the track is end-to-end encrypted.
+	Encrypted bool
+	// The track is a screen share (`m.screenshare` purpose of the stream) and not a camera.
+	Screenshare bool
+	// The frame rate that the subscribers get at most (unlimited if not set). It's met by dropping
+	// the temporal layers of the video rather than by switching to a lower simulcast layer.
+	MaxFrameRate int
+}
+
+// The resolution of a video (e.g. of a simulcast layer).
+type Resolution struct {
+	Width, Height int
+}
+
+// Returns the resolution of a given layer assuming that the clients scale each lower layer down by 2, i.e. the
+// way `calculateDesiredLayer()` expects them to. Zeros if the full resolution is not known.
+func scaledResolution(fullWidth, fullHeight int, layer webrtc_ext.SimulcastLayer) Resolution {
+	scale := 1
+	switch layer {
+	case webrtc_ext.SimulcastLayerLow:
+		scale = 4
+	case webrtc_ext.SimulcastLayerMedium:
+		scale = 2
+	}
+
+	return Resolution{Width: fullWidth / scale, Height: fullHeight / scale}
 }
 
 // Calculate the layer that we can use based on the requirements passed as parameters and available layers.
@@ -17,6 +43,7 @@ func getOptimalLayer(
 	layers map[webrtc_ext.SimulcastLayer]struct{},
 	metadata TrackMetadata,
 	requestedWidth, requestedHeight int,
+	defaultLayer webrtc_ext.SimulcastLayer,
 ) webrtc_ext.SimulcastLayer {
 	// If we don't have any layers available, then there is no simulcast.
 	if _, found := layers[webrtc_ext.SimulcastLayerNone]; found || len(layers) == 0 {
@@ -24,7 +51,13 @@ func getOptimalLayer(
 	}
 
 	// Video track. Calculate the optimal layer closest to the requested resolution.
-	desiredLayer := calculateDesiredLayer(metadata.MaxWidth, metadata.MaxHeight, requestedWidth, requestedHeight)
+	desiredLayer := calculateDesiredLayer(
+		metadata.MaxWidth,
+		metadata.MaxHeight,
+		requestedWidth,
+		requestedHeight,
+		defaultLayer,
+	)
 
 	// Ideally, here we would need to send an error if the desired layer is not available, but we don't
 	// have a way to do it. So we just return the closest available layer.
@@ -35,6 +68,24 @@ func getOptimalLayer(
 		webrtc_ext.SimulcastLayerHigh,
 	}
 
+	// A downscaled screen share quickly gets unreadable, while its frame rate hardly matters (the content
+	// is mostly static). So the screen shares favour the resolution: a layer higher than a camera would
+	// get and the highest available one if the desired layer is missing.
+	if metadata.Screenshare {
+		if requestedWidth == 0 && requestedHeight == 0 {
+			desiredLayer = webrtc_ext.SimulcastLayerHigh
+		} else {
+			desiredLayer = higherLayer(desiredLayer)
+		}
+
+		priority = []webrtc_ext.SimulcastLayer{
+			desiredLayer,
+			webrtc_ext.SimulcastLayerHigh,
+			webrtc_ext.SimulcastLayerMedium,
+			webrtc_ext.SimulcastLayerLow,
+		}
+	}
+
 	// More Go boilerplate.
 	for _, desiredLayer := range priority {
 		if _, found := layers[desiredLayer]; found {
@@ -47,11 +98,70 @@ func getOptimalLayer(
 	return webrtc_ext.SimulcastLayerLow
 }
 
+// Returns the highest available layer or `SimulcastLayerNone` if there is no simulcast.
+func getHighestLayer(layers map[webrtc_ext.SimulcastLayer]struct{}) webrtc_ext.SimulcastLayer {
+	for _, layer := range []webrtc_ext.SimulcastLayer{
+		webrtc_ext.SimulcastLayerHigh,
+		webrtc_ext.SimulcastLayerMedium,
+		webrtc_ext.SimulcastLayerLow,
+	} {
+		if _, found := layers[layer]; found {
+			return layer
+		}
+	}
+
+	return webrtc_ext.SimulcastLayerNone
+}
+
+// Returns the highest available layer that is not higher than `limit`. If all available layers are higher,
+// the lowest of them is returned, since it's still better than no video at all.
+func getHighestLayerUpTo(
+	layers map[webrtc_ext.SimulcastLayer]struct{},
+	limit webrtc_ext.SimulcastLayer,
+) webrtc_ext.SimulcastLayer {
+	highest := webrtc_ext.SimulcastLayerNone
+	for _, layer := range []webrtc_ext.SimulcastLayer{
+		webrtc_ext.SimulcastLayerLow,
+		webrtc_ext.SimulcastLayerMedium,
+		webrtc_ext.SimulcastLayerHigh,
+	} {
+		if _, found := layers[layer]; found && (highest == webrtc_ext.SimulcastLayerNone || layer <= limit) {
+			highest = layer
+		}
+	}
+
+	return highest
+}
+
 // Calculates the optimal layer closest to the requested resolution. We assume that the full resolution is the
 // maximum resolution that we can get from the user. We assume that a medium quality layer is half the size of
 // the video (**but not half of the resolution**). I.e. medium quality is high quality divided by 4. And low
 // quality is medium quality divided by 4 (which is the same as the high quality dividied by 16).
-func calculateDesiredLayer(fullWidth, fullHeight int, desiredWidth, desiredHeight int) webrtc_ext.SimulcastLayer {
+//
+// Many clients don't specify the desired resolution at all (0x0), in which case we use `defaultLayer`
+// (low if not set), as picking the highest layer for them would waste a lot of bandwidth. If only one
+// dimension is specified, the other one is derived from the aspect ratio of the full resolution.
+func calculateDesiredLayer(
+	fullWidth, fullHeight int,
+	desiredWidth, desiredHeight int,
+	defaultLayer webrtc_ext.SimulcastLayer,
+) webrtc_ext.SimulcastLayer {
+	if desiredWidth == 0 && desiredHeight == 0 {
+		if defaultLayer == webrtc_ext.SimulcastLayerNone {
+			return webrtc_ext.SimulcastLayerLow
+		}
+		return defaultLayer
+	}
+
+	if fullWidth > 0 && fullHeight > 0 {
+		if desiredHeight == 0 {
+			desiredHeight = desiredWidth * fullHeight / fullWidth
+		}
+		if desiredWidth == 0 {
+			desiredWidth = desiredHeight * fullWidth / fullHeight
+		}
+	}
+
 	// Calculate combined length of width and height for the full and desired size videos.
 	fullSize := fullWidth + fullHeight
 	desiredSize := desiredWidth + desiredHeight
@@ -70,6 +180,126 @@ func calculateDesiredLayer(fullWidth, fullHeight int, desiredWidth, desiredHeigh
 	return webrtc_ext.SimulcastLayerLow
 }
 
+// Returns the next higher layer (the high layer stays high).
+func higherLayer(layer webrtc_ext.SimulcastLayer) webrtc_ext.SimulcastLayer {
+	switch layer {
+	case webrtc_ext.SimulcastLayerLow:
+		return webrtc_ext.SimulcastLayerMedium
+	case webrtc_ext.SimulcastLayerMedium:
+		return webrtc_ext.SimulcastLayerHigh
+	default:
+		return layer
+	}
+}
+
+// Calculates the optimal layer for a subscriber among the currently active layers. Pinned tracks always
+// get the highest available layer regardless of the requested resolution. If the simulcast is off,
+// the subscribers always get the fixed layer.
+func (p *PublishedTrack[SubscriberID]) optimalLayer(desiredWidth, desiredHeight int) webrtc_ext.SimulcastLayer {
+	if p.config.SimulcastMode == SimulcastModeOff {
+		return p.fixedLayer()
+	}
+
+	layers := p.video.activeLayers()
+	if p.pinned {
+		return getHighestLayer(layers)
+	}
+
+	return getOptimalLayer(layers, p.metadata, desiredWidth, desiredHeight, p.config.DefaultLayer)
+}
+
+// Calculates the layer for an existing subscription: the optimal one for the resolution that the subscriber
+// has requested, but not higher than the limit of the subscription (if any, see `SwitchLayer`).
+func (p *PublishedTrack[SubscriberID]) subscriptionLayer(
+	sub *trackSubscription[SubscriberID],
+) webrtc_ext.SimulcastLayer {
+	layer := p.optimalLayer(sub.desiredWidth, sub.desiredHeight)
+	if sub.maxLayer == webrtc_ext.SimulcastLayerNone || layer <= sub.maxLayer || !p.canSwitchLayers() {
+		return layer
+	}
+
+	return getHighestLayerUpTo(p.video.activeLayers(), sub.maxLayer)
+}
+
+// Returns the layer to use when the simulcast is off: the configured one if it's published or the
+// highest published one otherwise. Stalled publishers are taken into account as well, since we
+// don't switch the layers when the simulcast is off.
+func (p *PublishedTrack[SubscriberID]) fixedLayer() webrtc_ext.SimulcastLayer {
+	if p.video.publishers[p.config.FixedLayer] != nil {
+		return p.config.FixedLayer
+	}
+
+	layers := make(map[webrtc_ext.SimulcastLayer]struct{}, len(p.video.publishers))
+	for layer := range p.video.publishers {
+		layers[layer] = struct{}{}
+	}
+
+	return getHighestLayer(layers)
+}
+
+// Moves the subscriptions for which a newly added layer is the optimal one to this layer.
+func (p *PublishedTrack[SubscriberID]) upgradeSubscriptions(layer webrtc_ext.SimulcastLayer) {
+	if !p.isSimulcast() {
+		return
+	}
+
+	for _, sub := range p.subscriptions {
+		if p.subscriptionLayer(sub) == layer {
+			p.switchLayer(sub, layer)
+		}
+	}
+}
+
+// Switches the subscription to a given layer (unless it's already subscribed to it).
+func (p *PublishedTrack[SubscriberID]) switchLayer(
+	sub *trackSubscription[SubscriberID],
+	layer webrtc_ext.SimulcastLayer,
+) {
+	if sub.currentLayer == layer {
+		return
+	}
+
+	// It could be that the subscription is subscribed to `LayerNone` (i.e. to no publisher, since all
+	// the available publishers are stalled). In this case `p.video.publishers[LayerNone]` would be nil.
+	if currentPublisher := p.video.publishers[sub.currentLayer]; currentPublisher != nil {
+		currentPublisher.removeSubscription(sub)
+	}
+
+	// If there is no such publisher, the subscription stays orphaned until some publisher recovers.
+	newPublisher := p.video.publishers[layer]
+	if newPublisher == nil {
+		sub.currentLayer = webrtc_ext.SimulcastLayerNone
+		return
+	}
+
+	newPublisher.addSubscription(sub)
+	sub.currentLayer = layer
+	p.reportSSRCMapping(sub)
+	p.hintMaxBitrate(sub)
+}
+
+// Hints the subscriber the maximum bitrate of the layer that it gets, see `Config.MaxLayerBitrates`. The
+// hint is only updated when the layer is chosen for the subscriber, not on the temporary switches caused
+// by the stalled publishers. Must be called with the mutex held.
+func (p *PublishedTrack[SubscriberID]) hintMaxBitrate(sub *trackSubscription[SubscriberID]) {
+	hinted, ok := sub.subscription.(interface{ SetMaxBitrate(bitrate uint64) })
+	if !ok || len(p.config.MaxLayerBitrates) == 0 {
+		return
+	}
+
+	if p.isOrphaned(sub) {
+		return
+	}
+
+	// The video without simulcast is sent in the full quality.
+	layer := sub.currentLayer
+	if layer == webrtc_ext.SimulcastLayerNone {
+		layer = webrtc_ext.SimulcastLayerHigh
+	}
+
+	hinted.SetMaxBitrate(p.config.MaxLayerBitrates[layer])
+}
+
 // Does this published track contain any simulcast tracks or is it a non-simulcast published track.
 func (p *PublishedTrack[SubscriberID]) isSimulcast() bool {
 	// The track is a video track.
@@ -83,3 +313,10 @@ func (p *PublishedTrack[SubscriberID]) isSimulcast() bool {
 
 	return video && hasPublishers && !hasNonSimulcastLayer
 }
+
+// Checks if the subscription is not attached to any publisher (e.g. all layers that it could use are stalled).
+// The subscriptions to the video without simulcast use `SimulcastLayerNone` for the sole publisher (the track
+// without the RID), so they are only orphaned if there is no such publisher.
+func (p *PublishedTrack[SubscriberID]) isOrphaned(sub *trackSubscription[SubscriberID]) bool {
+	return sub.currentLayer == webrtc_ext.SimulcastLayerNone && p.video.publishers[webrtc_ext.SimulcastLayerNone] == nil
+}
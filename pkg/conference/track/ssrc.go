@@ -0,0 +1,73 @@
+package track
+
+import (
+	"github.com/matrix-org/waterfall/pkg/webrtc_ext"
+	"github.com/pion/webrtc/v3"
+	"go.opentelemetry.io/otel/attribute"
+)
+
+// Describes where the packets that a subscriber receives come from. All packets of a subscription
+// are sent with a single outgoing SSRC regardless of the layer, so this is the only way to correlate
+// the captured packets with the original publisher and layer when debugging.
+type SSRCMapping struct {
+	// The SSRC of the packets sent to the subscriber.
+	OutgoingSSRC webrtc.SSRC
+	// The SSRC of the packets that we receive from the publisher (0 for audio tracks).
+	IncomingSSRC webrtc.SSRC
+	// The participant that receives the packets.
+	Subscriber string
+	// The participant that publishes the track.
+	Publisher string
+	// The published track.
+	TrackID TrackID
+	// The simulcast layer that is currently forwarded to the subscriber.
+	Layer webrtc_ext.SimulcastLayer
+}
+
+// Returns the SSRC mappings of all subscriptions of this track.
+func (p *PublishedTrack[SubscriberID]) SSRCMappings() []SSRCMapping {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	mappings := make([]SSRCMapping, 0, len(p.subscriptions))
+	for _, sub := range p.subscriptions {
+		mappings = append(mappings, p.ssrcMapping(sub))
+	}
+
+	return mappings
+}
+
+// Records the current SSRC mapping of a subscription. Must be called with the mutex held.
+func (p *PublishedTrack[SubscriberID]) reportSSRCMapping(sub *trackSubscription[SubscriberID]) {
+	mapping := p.ssrcMapping(sub)
+
+	p.logger.WithField("subscriber", mapping.Subscriber).
+		WithField("outgoing_ssrc", mapping.OutgoingSSRC).
+		WithField("incoming_ssrc", mapping.IncomingSSRC).
+		WithField("layer", mapping.Layer).
+		Debug("SSRC mapping changed")
+
+	p.telemetry.AddEvent("ssrc mapping changed",
+		attribute.String("subscriber", mapping.Subscriber),
+		attribute.Int64("outgoing_ssrc", int64(mapping.OutgoingSSRC)),
+		attribute.Int64("incoming_ssrc", int64(mapping.IncomingSSRC)),
+		attribute.String("layer", mapping.Layer.String()),
+	)
+}
+
+// Must be called with the mutex held.
+func (p *PublishedTrack[SubscriberID]) ssrcMapping(sub *trackSubscription[SubscriberID]) SSRCMapping {
+	var incomingSSRC webrtc.SSRC
+	if publisher := p.video.publishers[sub.currentLayer]; publisher != nil {
+		incomingSSRC = publisher.ssrc()
+	}
+
+	return SSRCMapping{
+		OutgoingSSRC: sub.OutgoingSSRC(),
+		IncomingSSRC: incomingSSRC,
+		Subscriber:   sub.subscriberID.String(),
+		Publisher:    p.owner.owner.String(),
+		TrackID:      p.info.TrackID,
+		Layer:        sub.currentLayer,
+	}
+}
@@ -2,10 +2,12 @@ package track
 
 import (
 	"fmt"
+	"time"
 
 	"github.com/matrix-org/waterfall/pkg/conference/subscription"
 	"github.com/matrix-org/waterfall/pkg/webrtc_ext"
 	"github.com/pion/rtp"
+	"github.com/pion/webrtc/v3"
 )
 
 // A composite type that wraps the `subscription` along with its related data, such as
@@ -15,6 +17,10 @@ type trackSubscription[SubscriberID SubscriberIdentifier] struct {
 	subscription subscription.Subscription
 	currentLayer webrtc_ext.SimulcastLayer
 	subscriberID SubscriberID
+	// The resolution that the subscriber has requested.
+	desiredWidth, desiredHeight int
+	// The highest layer that the subscription may use (`SimulcastLayerNone` if it's not limited).
+	maxLayer webrtc_ext.SimulcastLayer
 }
 
 // Implementation of `subscription.Subscription`.
@@ -22,11 +28,21 @@ func (s *trackSubscription[SubscriberID]) Unsubscribe() error {
 	return s.subscription.Unsubscribe()
 }
 
+// Implementation of `subscription.Subscription`.
+func (s *trackSubscription[SubscriberID]) Detach() error {
+	return s.subscription.Detach()
+}
+
 // Implementation of `subscription.Subscription`.
 func (s *trackSubscription[SubscriberID]) WriteRTP(packet rtp.Packet) error {
 	return s.subscription.WriteRTP(packet)
 }
 
+// Implementation of `subscription.Subscription`.
+func (s *trackSubscription[SubscriberID]) OutgoingSSRC() webrtc.SSRC {
+	return s.subscription.OutgoingSSRC()
+}
+
 func (p *PublishedTrack[SubscriberID]) processSubscriptionEvents(
 	sub *trackSubscription[SubscriberID],
 	events <-chan subscription.KeyFrameRequest,
@@ -58,5 +74,12 @@ func (p *PublishedTrack[SubscriberID]) processKeyFrameRequest(sub *trackSubscrip
 		return fmt.Errorf("publisher with simulcast %s not found", sub.currentLayer)
 	}
 
+	// The subscribers may request the key frames way more often than the publisher can produce them.
+	now := time.Now()
+	if now.Sub(publisher.lastKeyFrameRequest) < p.config.keyFrameRequestInterval(p.metadata) {
+		return nil
+	}
+	publisher.lastKeyFrameRequest = now
+
 	return publisher.requestKeyFrame()
 }
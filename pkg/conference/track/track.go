@@ -1,17 +1,28 @@
 package track
 
 import (
+	"errors"
 	"fmt"
 	"sync"
+	"time"
 
 	"github.com/matrix-org/waterfall/pkg/conference/subscription"
+	"github.com/matrix-org/waterfall/pkg/metrics"
 	"github.com/matrix-org/waterfall/pkg/telemetry"
 	"github.com/matrix-org/waterfall/pkg/webrtc_ext"
+	"github.com/pion/rtcp"
 	"github.com/pion/webrtc/v3"
 	"github.com/sirupsen/logrus"
 	"go.opentelemetry.io/otel/attribute"
 )
 
+var (
+	ErrNoPublisher        = errors.New("no publisher available")
+	ErrTooManySubscribers = errors.New("too many subscribers")
+	ErrFixedLayer         = errors.New("layers of the track can't be switched")
+	ErrIncompatibleCodec  = errors.New("codec of the track is not supported by the subscriber")
+)
+
 // A subscruber identifier is something that is comparable and convertable to a String.
 type SubscriberIdentifier interface {
 	comparable
@@ -30,6 +41,8 @@ type PublishedTrack[SubscriberID SubscriberIdentifier] struct {
 	info webrtc_ext.TrackInfo
 	// Owner of a published track.
 	owner trackOwner[SubscriberID]
+	// Configuration of the track.
+	config Config
 
 	// We must protect the data with a mutex since we want the `PublishedTrack` to remain thread-safe.
 	mutex sync.Mutex
@@ -41,6 +54,8 @@ type PublishedTrack[SubscriberID SubscriberIdentifier] struct {
 	video *videoTrack
 	// Track metadata.
 	metadata TrackMetadata
+	// Pinned tracks are always forwarded in the highest available quality.
+	pinned bool
 
 	// Wait group for all active publishers.
 	activePublishers *sync.WaitGroup
@@ -53,8 +68,10 @@ type PublishedTrack[SubscriberID SubscriberIdentifier] struct {
 func NewPublishedTrack[SubscriberID SubscriberIdentifier](
 	ownerID SubscriberID,
 	requestKeyFrame func(track *webrtc.TrackRemote) error,
+	writeRTCP func(packets []rtcp.Packet) error,
 	track *webrtc.TrackRemote,
 	metadata TrackMetadata,
+	config Config,
 	logger *logrus.Entry,
 	telemetryBuilder *telemetry.ChildBuilder,
 ) (*PublishedTrack[SubscriberID], error) {
@@ -68,7 +85,8 @@ func NewPublishedTrack[SubscriberID SubscriberIdentifier](
 		logger:           logger.WithField("track", track.ID()),
 		info:             webrtc_ext.TrackInfoFromTrack(track),
 		telemetry:        telemetry,
-		owner:            trackOwner[SubscriberID]{ownerID, requestKeyFrame},
+		owner:            trackOwner[SubscriberID]{ownerID, requestKeyFrame, writeRTCP},
+		config:           config,
 		subscriptions:    make(map[SubscriberID]*trackSubscription[SubscriberID]),
 		audio:            &audioTrack{outputTrack: nil},
 		video:            &videoTrack{publishers: make(map[webrtc_ext.SimulcastLayer]*trackPublisher)},
@@ -99,7 +117,8 @@ func NewPublishedTrack[SubscriberID SubscriberIdentifier](
 		published.activePublishers.Add(1)
 		go func() {
 			defer published.activePublishers.Done()
-			if err := forward(track, localTrack, published.stopPublishers); err != nil {
+			err := forward(track, localTrack, published.audio, config.Impairment, published.stopPublishers, logger)
+			if err != nil {
 				logger.Infof("audio publisher stopped: %v", err)
 			}
 		}()
@@ -107,6 +126,12 @@ func NewPublishedTrack[SubscriberID SubscriberIdentifier](
 	case webrtc.RTPCodecTypeVideo:
 		// Start video publisher.
 		published.addVideoPublisher(track)
+
+		// Let the publisher know how its video is received by the subscribers.
+		go published.sendReceiverReports()
+
+		// Keep track of the incoming bitrate of the layers.
+		go published.reportBitrates()
 	}
 
 	// Wait for all publishers to stop.
@@ -156,8 +181,13 @@ func (p *PublishedTrack[SubscriberID]) AddPublisher(track *webrtc.TrackRemote) e
 		return nil
 	}
 
-	// Add a publisher and start polling it.
-	p.addVideoPublisher(track)
+	// Add a publisher and start polling it (unless there are too many layers already).
+	if !p.addVideoPublisher(track) {
+		return nil
+	}
+
+	// The subscribers that were waiting for this layer may get it now.
+	p.upgradeSubscriptions(simulcast)
 	return nil
 }
 
@@ -191,30 +221,31 @@ func (p *PublishedTrack[SubscriberID]) Subscribe(
 	// change the existing subscription (e.g. if a different simulcast track is desired for a given
 	// subscription).
 	if sub := p.subscriptions[subscriberID]; sub != nil {
+		sub.desiredWidth, sub.desiredHeight = desiredWidth, desiredHeight
+
 		// Non-simulcast tracks can't be updated, so if the subscription exists already, no need to do anything.
 		if !p.isSimulcast() {
 			return nil
 		}
 
-		// We're dealing with a simulcast track if we're here, so let's calculate the optimal layer.
-		layer := getOptimalLayer(p.video.activeLayers(), p.metadata, desiredWidth, desiredHeight)
-
-		// Let's see if the current layer matches what the subscriber wants.
-		if sub.currentLayer != layer {
-			// It could be that all subscriptions are subscribed to `LayerNone` (i.e. to no publisher,
-			// since all the available publishers are stalled). In this case `p.video.publishers[LayerNone]`
-			// would be nil.
-			if currentPublisher := p.video.publishers[sub.currentLayer]; currentPublisher != nil {
-				currentPublisher.removeSubscription(sub)
-			}
-
-			newPublisher := p.video.publishers[layer]
-			newPublisher.addSubscription(sub)
+		// We're dealing with a simulcast track if we're here, so let's switch to the optimal layer.
+		p.switchLayer(sub, p.subscriptionLayer(sub))
+		return nil
+	}
 
-			sub.currentLayer = layer
-		}
+	// Protect the publisher (and the SFU) from too many subscribers of a single track.
+	if limit := p.config.MaxSubscribers; limit > 0 && len(p.subscriptions) >= limit {
+		return fmt.Errorf("%w for track %s (limit %d)", ErrTooManySubscribers, p.info.TrackID, limit)
+	}
 
-		return nil
+	// The media is forwarded as is, so the subscriber would not be able to play it in any other codec.
+	if !controller.SupportsCodec(p.info.Codec) {
+		metrics.IncompatibleCodec.Add(p.info.Codec.MimeType, 1)
+		p.telemetry.AddEvent("incompatible codec",
+			attribute.String("subscriber", subscriberID.String()),
+			attribute.String("codec", p.info.Codec.MimeType),
+		)
+		return fmt.Errorf("%w: %s for track %s", ErrIncompatibleCodec, p.info.Codec.MimeType, p.info.TrackID)
 	}
 
 	// If we got here, then we need to create a new subscription.
@@ -223,13 +254,17 @@ func (p *PublishedTrack[SubscriberID]) Subscribe(
 		// Subscription does not exist, so let's create it.
 		switch p.info.Kind {
 		case webrtc.RTPCodecTypeVideo:
+			config := p.config.Subscription
+			config.Encrypted = p.metadata.Encrypted
+
 			sub, ch, err := subscription.NewVideoSubscription(
 				p.info,
 				controller,
+				config,
 				logger.WithField("track", p.info.TrackID),
 				p.telemetry.ChildBuilder(attribute.String("id", subscriberID.String())),
 			)
-			layer = getOptimalLayer(p.video.activeLayers(), p.metadata, desiredWidth, desiredHeight)
+			layer = p.optimalLayer(desiredWidth, desiredHeight)
 			return sub, ch, err
 		case webrtc.RTPCodecTypeAudio:
 			sub, err := subscription.NewAudioSubscription(p.audio.outputTrack, controller)
@@ -243,17 +278,40 @@ func (p *PublishedTrack[SubscriberID]) Subscribe(
 		return err
 	}
 
-	// Add the subscription to the list of subscriptions.
-	subscription := &trackSubscription[SubscriberID]{sub, layer, subscriberID}
-	p.subscriptions[subscriberID] = subscription
+	subscription := &trackSubscription[SubscriberID]{
+		sub, layer, subscriberID, desiredWidth, desiredHeight, webrtc_ext.SimulcastLayerNone,
+	}
 
-	// And if it's a video subscription, add it to the list of subscriptions that get the feed from the publisher.
+	// If it's a video subscription, add it to the list of subscriptions that get the feed from the publisher.
 	if p.info.Kind == webrtc.RTPCodecTypeVideo {
-		p.video.publishers[layer].addSubscription(subscription)
+		switch publisher := p.video.publishers[layer]; {
+		case publisher != nil:
+			publisher.addSubscription(subscription)
+		case len(p.video.publishers) > 0:
+			// All publishers are stalled, the subscription stays orphaned until some publisher recovers.
+			subscription.currentLayer = webrtc_ext.SimulcastLayerNone
+		default:
+			// There are no publishers at all, so the subscription can't work. Roll it back, otherwise
+			// the sender that has been added to the subscriber's peer connection would leak.
+			err := fmt.Errorf("%w for track %s", ErrNoPublisher, p.info.TrackID)
+			if unsubscribeErr := sub.Unsubscribe(); unsubscribeErr != nil {
+				err = errors.Join(err, unsubscribeErr)
+			}
+
+			p.telemetry.AddError(fmt.Errorf("failed to create subscription: %w", err))
+			return err
+		}
+
+		p.limitFrameRate(sub)
 		go p.processSubscriptionEvents(subscription, ch)
 	}
 
+	// Add the subscription to the list of subscriptions.
+	p.subscriptions[subscriberID] = subscription
+
 	p.logger.WithField("subscriber", subscriberID).WithField("layer", layer).Info("New subscription")
+	p.reportSSRCMapping(subscription)
+	p.hintMaxBitrate(subscription)
 	return nil
 }
 
@@ -262,16 +320,48 @@ func (p *PublishedTrack[SubscriberID]) Unsubscribe(subscriberID SubscriberID) {
 	p.mutex.Lock()
 	defer p.mutex.Unlock()
 
+	p.unsubscribe(subscriberID, false)
+}
+
+// Ends all subscriptions to the track right away, without waiting for the publishers to stop.
+// Whether the subscribers renegotiate right away depends on the `PublisherLeftPolicy`.
+func (p *PublishedTrack[SubscriberID]) UnsubscribeAll() {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	detach := p.config.PublisherLeftPolicy == PublisherLeftPolicyFreeze
+	for subscriberID := range p.subscriptions {
+		p.unsubscribe(subscriberID, detach)
+	}
+}
+
+func (p *PublishedTrack[SubscriberID]) unsubscribe(subscriberID SubscriberID, detach bool) {
 	if sub := p.subscriptions[subscriberID]; sub != nil {
-		sub.Unsubscribe()
+		if detach {
+			sub.Detach()
+		} else {
+			sub.Unsubscribe()
+		}
 		delete(p.subscriptions, subscriberID)
 
+		// Orphaned subscriptions (`SimulcastLayerNone`) are not attached to any publisher.
 		if p.info.Kind == webrtc.RTPCodecTypeVideo {
-			p.video.publishers[sub.currentLayer].removeSubscription(sub)
+			if pub := p.video.publishers[sub.currentLayer]; pub != nil {
+				pub.removeSubscription(sub)
+			}
 		}
 	}
 }
 
+// Checks if a given subscriber is subscribed to this track.
+func (p *PublishedTrack[SubscriberID]) IsSubscribed(subscriberID SubscriberID) bool {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	_, found := p.subscriptions[subscriberID]
+	return found
+}
+
 func (p *PublishedTrack[SubscriberID]) Owner() SubscriberID {
 	return p.owner.owner
 }
@@ -294,7 +384,277 @@ func (p *PublishedTrack[SubscriberID]) SetMetadata(metadata TrackMetadata) {
 	p.mutex.Lock()
 	defer p.mutex.Unlock()
 
+	frameRateChanged := p.metadata.MaxFrameRate != metadata.MaxFrameRate
 	p.metadata = metadata
+
+	if frameRateChanged {
+		for _, sub := range p.subscriptions {
+			p.limitFrameRate(sub.subscription)
+		}
+	}
+}
+
+// Limits the frame rate of a subscription to the maximum frame rate of the track (if the subscription supports it).
+func (p *PublishedTrack[SubscriberID]) limitFrameRate(sub subscription.Subscription) {
+	if limited, ok := sub.(interface{ SetMaxFrameRate(frameRate int) }); ok {
+		limited.SetMaxFrameRate(p.metadata.MaxFrameRate)
+	}
+}
+
+// Stops (or resumes) forwarding the audio track to all subscribers regardless of whether the publisher has
+// muted it, e.g. when a moderator mutes the participant. Returns `false` if the track is not an audio track.
+func (p *PublishedTrack[SubscriberID]) SetForceMuted(muted bool) bool {
+	if p.info.Kind != webrtc.RTPCodecTypeAudio {
+		return false
+	}
+
+	if p.audio.forceMuted.Swap(muted) != muted {
+		p.telemetry.AddEvent("force muted changed", attribute.Bool("muted", muted))
+	}
+
+	return true
+}
+
+// Stops (or resumes) forwarding the audio track to all subscribers since others are speaking, see
+// `participant.Tracker.SelectSpeakers()`. Returns `false` if the track is not an audio track.
+func (p *PublishedTrack[SubscriberID]) SetSuppressed(suppressed bool) bool {
+	if p.info.Kind != webrtc.RTPCodecTypeAudio {
+		return false
+	}
+
+	if p.audio.suppressed.Swap(suppressed) != suppressed {
+		p.telemetry.AddEvent("suppressed changed", attribute.Bool("suppressed", suppressed))
+	}
+
+	return true
+}
+
+// Sets the ID of the audio level header extension that the publisher has negotiated, so that the SFU knows
+// when the publisher is speaking (0 if the extension is not negotiated).
+func (p *PublishedTrack[SubscriberID]) SetAudioLevelExtension(id uint8) {
+	p.audio.levelExtension.Store(uint32(id))
+}
+
+// Returns when the publisher of the audio track has been speaking for the last time (zero if never or if
+// the audio level is not known).
+func (p *PublishedTrack[SubscriberID]) LastSpoke() time.Time {
+	lastSpoke := p.audio.lastSpoke.Load()
+	if lastSpoke == 0 {
+		return time.Time{}
+	}
+
+	return time.Unix(0, lastSpoke)
+}
+
+// Pins (or unpins) the track. Subscribers of a pinned track always get the highest available layer.
+func (p *PublishedTrack[SubscriberID]) SetPinned(pinned bool) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	if p.pinned == pinned {
+		return
+	}
+
+	p.pinned = pinned
+	p.telemetry.AddEvent("pinned changed", attribute.Bool("pinned", pinned))
+
+	if !p.isSimulcast() {
+		return
+	}
+
+	// Re-evaluate the layers of the existing subscriptions.
+	for _, sub := range p.subscriptions {
+		p.switchLayer(sub, p.subscriptionLayer(sub))
+	}
+}
+
+// Informs the track which simulcast layers the client has paused (all other layers are considered
+// resumed). The paused layers are not used for the subscribers and their stalls are not a failure.
+func (p *PublishedTrack[SubscriberID]) SetPausedLayers(layers []webrtc_ext.SimulcastLayer) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	paused := make(map[webrtc_ext.SimulcastLayer]struct{}, len(layers))
+	for _, layer := range layers {
+		paused[layer] = struct{}{}
+	}
+
+	p.video.paused = paused
+	p.telemetry.AddEvent("paused layers changed", attribute.Int("paused", len(paused)))
+
+	if !p.isSimulcast() {
+		return
+	}
+
+	// Move the subscribers away from the paused layers and back to the resumed ones.
+	for _, sub := range p.subscriptions {
+		p.switchLayer(sub, p.subscriptionLayer(sub))
+	}
+}
+
+// The layer that a subscriber currently receives and the highest layer that it may receive.
+type SubscriptionLayers struct {
+	Current webrtc_ext.SimulcastLayer
+	// `SimulcastLayerNone` if the subscription is not limited.
+	Max webrtc_ext.SimulcastLayer
+}
+
+// Returns the layers of all subscriptions of the track.
+func (p *PublishedTrack[SubscriberID]) SubscriptionLayers() map[SubscriberID]SubscriptionLayers {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	layers := make(map[SubscriberID]SubscriptionLayers, len(p.subscriptions))
+	for subscriberID, sub := range p.subscriptions {
+		layers[subscriberID] = SubscriptionLayers{sub.currentLayer, sub.maxLayer}
+	}
+
+	return layers
+}
+
+// Checks if the subscriptions of the track can be switched between the layers (see `SwitchLayer`).
+// That's not the case for the non-simulcast tracks, the fixed layer (simulcast is off) and pinned tracks.
+func (p *PublishedTrack[SubscriberID]) CanSwitchLayers() bool {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	return p.canSwitchLayers()
+}
+
+func (p *PublishedTrack[SubscriberID]) canSwitchLayers() bool {
+	return p.isSimulcast() && p.config.SimulcastMode != SimulcastModeOff && !p.pinned
+}
+
+// Limits the subscription of a given subscriber to a given layer (or lower ones) and switches it to
+// the best layer within the limit. The limit is kept when the layers are re-evaluated later on (e.g.
+// when a layer gets paused), until it's changed again. `SimulcastLayerNone` lifts the limit.
+func (p *PublishedTrack[SubscriberID]) SwitchLayer(
+	subscriberID SubscriberID,
+	maxLayer webrtc_ext.SimulcastLayer,
+) error {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	sub := p.subscriptions[subscriberID]
+	if sub == nil {
+		return fmt.Errorf("%s is not subscribed to %s", subscriberID, p.info.TrackID)
+	}
+
+	if !p.canSwitchLayers() {
+		return fmt.Errorf("%w: %s", ErrFixedLayer, p.info.TrackID)
+	}
+
+	sub.maxLayer = maxLayer
+	p.switchLayer(sub, p.subscriptionLayer(sub))
+	return nil
+}
+
+// Requests a key frame from each publisher (simulcast layer) of the video track. Returns how many
+// key frames were requested.
+func (p *PublishedTrack[SubscriberID]) RequestKeyFrames() int {
+	if p.info.Kind != webrtc.RTPCodecTypeVideo {
+		return 0
+	}
+
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	requested := 0
+	for layer, pub := range p.video.publishers {
+		if err := pub.requestKeyFrame(); err != nil {
+			p.logger.WithError(err).Warnf("Failed to request a key frame from the %s layer", layer)
+			continue
+		}
+
+		requested++
+	}
+
+	return requested
+}
+
+// Returns the incoming bitrate (in bits per second) of each publisher (simulcast layer) of the video track.
+func (p *PublishedTrack[SubscriberID]) LayerBitrates() map[webrtc_ext.SimulcastLayer]uint64 {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	bitrates := make(map[webrtc_ext.SimulcastLayer]uint64, len(p.video.publishers))
+	for layer, pub := range p.video.publishers {
+		bitrates[layer] = pub.bitrate()
+	}
+
+	return bitrates
+}
+
+// Returns the resolutions of the active simulcast layers of the video track. The resolution of a layer is the one
+// of its last key frame or, if not known (e.g. for the codecs other than VP8), the one derived from the resolution
+// that the owner has advertised in the metadata. The layers whose resolution is not known at all are left out.
+func (p *PublishedTrack[SubscriberID]) LayerResolutions() map[webrtc_ext.SimulcastLayer]Resolution {
+	if p.info.Kind != webrtc.RTPCodecTypeVideo {
+		return nil
+	}
+
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	resolutions := make(map[webrtc_ext.SimulcastLayer]Resolution)
+	for layer := range p.video.activeLayers() {
+		resolution := scaledResolution(p.metadata.MaxWidth, p.metadata.MaxHeight, layer)
+		if width, height := p.video.publishers[layer].frameSize(); width != 0 && height != 0 {
+			resolution = Resolution{Width: width, Height: height}
+		}
+
+		if resolution.Width != 0 && resolution.Height != 0 {
+			resolutions[layer] = resolution
+		}
+	}
+
+	return resolutions
+}
+
+// Returns the simulcast layers of the video track whose publishers have stalled unexpectedly, i.e. not
+// because the track is muted or the layer is paused.
+func (p *PublishedTrack[SubscriberID]) StalledLayers() []webrtc_ext.SimulcastLayer {
+	if p.info.Kind != webrtc.RTPCodecTypeVideo {
+		return nil
+	}
+
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	if p.metadata.Muted {
+		return nil
+	}
+
+	stalled := []webrtc_ext.SimulcastLayer{}
+	for layer, pub := range p.video.publishers {
+		if pub.isStalled() && !p.video.isPaused(layer) {
+			stalled = append(stalled, layer)
+		}
+	}
+
+	return stalled
+}
+
+// Starts dumping the RTP packets forwarded to a given subscriber (debugging aid, video only).
+func (p *PublishedTrack[SubscriberID]) StartCapture(subscriberID SubscriberID, config subscription.CaptureConfig) error {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	sub := p.subscriptions[subscriberID]
+	if sub == nil {
+		config.Output.Close()
+		return fmt.Errorf("%v is not subscribed to track %s", subscriberID, p.info.TrackID)
+	}
+
+	capturable, ok := sub.subscription.(interface {
+		StartCapture(config subscription.CaptureConfig) error
+	})
+	if !ok {
+		config.Output.Close()
+		return fmt.Errorf("can't capture %s track %s", p.info.Kind, p.info.TrackID)
+	}
+
+	return capturable.StartCapture(config)
 }
 
 func (p *PublishedTrack[SubscriberID]) isClosed() bool {
@@ -2,34 +2,78 @@ package track
 
 import (
 	"fmt"
+	"sync/atomic"
 	"time"
 
 	"github.com/matrix-org/waterfall/pkg/conference/publisher"
 	"github.com/matrix-org/waterfall/pkg/webrtc_ext"
+	"github.com/pion/interceptor"
+	"github.com/pion/rtcp"
+	"github.com/pion/rtp"
 	"github.com/pion/webrtc/v3"
+	"github.com/sirupsen/logrus"
 	"go.opentelemetry.io/otel/attribute"
 )
 
 type trackOwner[SubscriberID comparable] struct {
 	owner           SubscriberID
 	requestKeyFrame func(track *webrtc.TrackRemote) error
+	// Sends RTCP packets to the owner (publisher) of the track.
+	writeRTCP func(packets []rtcp.Packet) error
 }
 
 type audioTrack struct {
-	// The sink of this audio track packets.
+	// The sink of this audio track packets. Each published audio track has its own output track, so the
+	// audio tracks of a participant (e.g. the microphone and the screen share audio) never get mixed up.
 	outputTrack *webrtc.TrackLocalStaticRTP
+	// The packets are not forwarded to anyone while the track is muted by a moderator, see `SetForceMuted()`.
+	forceMuted atomic.Bool
+	// The packets are not forwarded while other participants are speaking in a big conference, see
+	// `SetSuppressed()`.
+	suppressed atomic.Bool
+	// The ID of the audio level header extension negotiated with the publisher (0 if none).
+	levelExtension atomic.Uint32
+	// When the publisher has been speaking for the last time (in Unix nanoseconds, 0 if never).
+	lastSpoke atomic.Int64
+}
+
+// The audio level (in -dBov) below which the publisher is considered to be speaking.
+const speakingLevel = 50
+
+// Remembers when the publisher has been speaking according to the audio level of a given packet.
+func (t *audioTrack) observeLevel(packet *rtp.Packet) {
+	id := t.levelExtension.Load()
+	if id == 0 {
+		return
+	}
+
+	payload := packet.GetExtension(uint8(id))
+	if payload == nil {
+		return
+	}
+
+	var level rtp.AudioLevelExtension
+	if err := level.Unmarshal(payload); err != nil {
+		return
+	}
+
+	if level.Voice || level.Level < speakingLevel {
+		t.lastSpoke.Store(time.Now().UnixNano())
+	}
 }
 
 type videoTrack struct {
 	// Publishers of each video layer.
 	publishers map[webrtc_ext.SimulcastLayer]*trackPublisher
+	// Layers that the client has paused on purpose, i.e. they don't send packets, but they're not broken.
+	paused map[webrtc_ext.SimulcastLayer]struct{}
 }
 
 // Get the set of active layers (the tricky return type is a simulation of a `HashSet` in Golang).
 func (t *videoTrack) activeLayers() map[webrtc_ext.SimulcastLayer]struct{} {
 	layers := make(map[webrtc_ext.SimulcastLayer]struct{}, len(t.publishers))
 	for layer, publisher := range t.publishers {
-		if !publisher.isStalled() {
+		if !publisher.isStalled() && !t.isPaused(layer) {
 			layers[layer] = struct{}{}
 		}
 	}
@@ -37,8 +81,59 @@ func (t *videoTrack) activeLayers() map[webrtc_ext.SimulcastLayer]struct{} {
 	return layers
 }
 
-// Forward audio packets from the source track to the destination track.
-func forward(sender *webrtc.TrackRemote, receiver *webrtc.TrackLocalStaticRTP, stop <-chan struct{}) error {
+func (t *videoTrack) isPaused(layer webrtc_ext.SimulcastLayer) bool {
+	_, paused := t.paused[layer]
+	return paused
+}
+
+type rtpReader interface {
+	ReadRTP() (*rtp.Packet, interceptor.Attributes, error)
+}
+
+type rtpWriter interface {
+	WriteRTP(packet *rtp.Packet) error
+}
+
+// Forward audio packets from the source track to the destination track (unless the track is force-muted or
+// suppressed). The simulated network impairment (if any) applies to the audio too. Only the errors of the
+// source track stop the forwarding.
+func forward(
+	sender rtpReader,
+	receiver rtpWriter,
+	audio *audioTrack,
+	impairment publisher.Impairment,
+	stop <-chan struct{},
+	logger *logrus.Entry,
+) error {
+	// Whether the last write has failed, so that we only log once per failure.
+	failing := false
+
+	// Write the data to the local track. The packets of a muted track are still read, so that
+	// the publisher doesn't notice anything.
+	write := func(packets []*rtp.Packet) {
+		for _, packet := range packets {
+			audio.observeLevel(packet)
+			if audio.forceMuted.Load() || audio.suppressed.Load() {
+				continue
+			}
+
+			// The destination track is shared by all subscribers, so the write fails if the packet could not
+			// be sent to any of them (e.g. to the one whose connection is gone), but the others still get it.
+			// Such a subscriber is removed along with its participant, the publisher must go on.
+			writeErr := receiver.WriteRTP(packet)
+			switch {
+			case writeErr != nil && !failing:
+				logger.Warnf("Failed to forward audio to some subscribers: %v", writeErr)
+			case writeErr == nil && failing:
+				logger.Info("Forwarding audio to all subscribers again")
+			}
+			failing = writeErr != nil
+		}
+	}
+
+	impairer := publisher.NewImpairer(impairment, write)
+	defer impairer.Stop()
+
 	for {
 		// Read the data from the remote track.
 		packet, _, readErr := sender.ReadRTP()
@@ -46,10 +141,7 @@ func forward(sender *webrtc.TrackRemote, receiver *webrtc.TrackLocalStaticRTP, s
 			return readErr
 		}
 
-		// Write the data to the local track.
-		if writeErr := receiver.WriteRTP(packet); writeErr != nil {
-			return writeErr
-		}
+		impairer.Process(packet)
 
 		// Check if we need to stop processing packets.
 		select {
@@ -60,16 +152,26 @@ func forward(sender *webrtc.TrackRemote, receiver *webrtc.TrackLocalStaticRTP, s
 	}
 }
 
-func (p *PublishedTrack[SubscriberID]) addVideoPublisher(track *webrtc.TrackRemote) {
+// Starts a publisher for a given layer of the video track. Returns `false` if the layer is ignored.
+func (p *PublishedTrack[SubscriberID]) addVideoPublisher(track *webrtc.TrackRemote) bool {
 	// Detect simulcast layer of a publisher and create loggers and scoped telemetry.
 	simulcast := webrtc_ext.RIDToSimulcastLayer(track.RID())
 
+	if !p.acceptsLayer(simulcast) {
+		p.logger.WithField("layer", simulcast.String()).Info("Ignoring the layer beyond the configured limit")
+		p.telemetry.AddEvent("layer ignored", attribute.String("layer", simulcast.String()))
+		return false
+	}
+
 	// Create a publisher.
 	trackPublisher := newTrackPublisher(
 		track,
 		p.owner.requestKeyFrame,
 		p.stopPublishers,
-		2*time.Second, // We consider publisher as stalled if there are no packets within 2 seconds.
+		p.config.stallTimeout(p.metadata),
+		p.config.Impairment,
+		p.config.ClockRateValidation,
+		p.config.DetectFrameSize && !p.metadata.Encrypted,
 		simulcast,
 		p.logger.WithField("layer", simulcast.String()),
 		p.telemetry.CreateChild("layer", attribute.String("layer", simulcast.String())),
@@ -82,55 +184,86 @@ func (p *PublishedTrack[SubscriberID]) addVideoPublisher(track *webrtc.TrackRemo
 	go func() {
 		// Once this go-routine is done, inform that this publisher is stopped.
 		defer p.activePublishers.Done()
-		defer trackPublisher.telemetry.End()
-
-		// Observe publisher's status events.
-		for status := range trackPublisher.eventsChannel {
-			switch status {
-			case publisher.StatusStalled:
-				// Publisher is not active (no packets received for a while).
-				p.handleStalledPublisher(trackPublisher)
-
-			case publisher.StatusRecovered:
-				// Publisher is active again (new packets received).
-				trackPublisher.logger.Info("Publisher is recovered")
-				trackPublisher.telemetry.AddEvent("recovered")
-
-				// Iterate over active subscriptions that don't have any active publisher
-				// and assign them to this publisher.
-				p.recoverOrphanedSubscriptions(trackPublisher)
-			}
+		p.observePublisher(trackPublisher)
+	}()
+
+	return true
+}
+
+// Reacts to the status changes of a publisher until it's stopped, then moves its subscriptions elsewhere.
+func (p *PublishedTrack[SubscriberID]) observePublisher(trackPublisher *trackPublisher) {
+	defer trackPublisher.telemetry.End()
+
+	// Observe publisher's status events.
+	for status := range trackPublisher.eventsChannel {
+		switch status {
+		case publisher.StatusStalled:
+			// Publisher is not active (no packets received for a while).
+			p.handleStalledPublisher(trackPublisher)
+
+			// If it does not recover for a long time, check if it's a ghost.
+			time.AfterFunc(p.config.stalePublisherTimeout(), func() {
+				p.removeStalePublisher(trackPublisher)
+			})
+
+		case publisher.StatusRecovered:
+			// Publisher is active again (new packets received).
+			trackPublisher.logger.Info("Publisher is recovered")
+			trackPublisher.telemetry.AddEvent("recovered")
+
+			p.mutex.Lock()
+			trackPublisher.stalledSince = time.Time{}
+			p.mutex.Unlock()
+
+			// Iterate over active subscriptions that don't have any active publisher
+			// and assign them to this publisher.
+			p.recoverOrphanedSubscriptions(trackPublisher)
 		}
+	}
 
-		trackPublisher.telemetry.AddEvent("stopped, removing dependent subscriptions")
+	trackPublisher.telemetry.AddEvent("stopped, removing dependent subscriptions")
 
-		// If we got there, then the publisher is stopped.
-		p.mutex.Lock()
-		defer p.mutex.Unlock()
+	// If we got there, then the publisher is stopped.
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
 
-		// Remove the publisher once it's gone.
+	// Remove the publisher once it's gone (unless it has already been replaced by a newer one).
+	if p.video.publishers[trackPublisher.layer] == trackPublisher {
 		delete(p.video.publishers, trackPublisher.layer)
+	}
 
-		// Now iterate over all subscriptions and find those that are now lost due to the publisher being stopped.
-		// Try to find any other available publisher for this subscription (since these are all publishers/layers
-		// of the same track). We do iteration over the publishers map to get a single (random) available publisher.
-		// Golang does not have a function to get a random or "first" element of the map.
-		//
-		// TODO: Do we need to do it? Can publishers **fail** during the call and get created by Pion automatically?
-		for layer, pub := range p.video.publishers {
-			for _, sub := range pub.removeSubscriptions() {
-				sub.(*trackSubscription[SubscriberID]).currentLayer = layer //nolint:forcetypeassert
-				pub.addSubscription(sub)
-			}
-			break
+	// Now iterate over all subscriptions and find those that are now lost due to the publisher being stopped.
+	// Try to find any other available publisher for this subscription (since these are all publishers/layers
+	// of the same track). We do iteration over the publishers map to get a single (random) available publisher.
+	// Golang does not have a function to get a random or "first" element of the map.
+	//
+	// TODO: Do we need to do it? Can publishers **fail** during the call and get created by Pion automatically?
+	for layer, pub := range p.video.publishers {
+		for _, sub := range pub.removeSubscriptions() {
+			sub.(*trackSubscription[SubscriberID]).currentLayer = layer //nolint:forcetypeassert
+			pub.addSubscription(sub)
 		}
-	}()
+		break
+	}
+}
+
+// Checks if the publisher of a given layer may be added without exceeding the configured limit of layers.
+// Only the lowest layers are accepted, so that the limit does not depend on the order in which they arrive.
+func (p *PublishedTrack[SubscriberID]) acceptsLayer(layer webrtc_ext.SimulcastLayer) bool {
+	maxLayers := p.config.MaxLayers
+	if maxLayers <= 0 {
+		return true
+	}
+
+	return len(p.video.publishers) < maxLayers && int(layer) <= maxLayers
 }
 
 func (p *PublishedTrack[SubscriberID]) handleStalledPublisher(pub *trackPublisher) {
 	p.mutex.Lock()
 	defer p.mutex.Unlock()
 
+	pub.stalledSince = time.Now()
+
 	// Let's check if we're muted. If we are, it's ok to not receive packets.
 	//
 	// FIXME: What if the track gets unmuted? We won't get a "stalled" notification if
@@ -142,6 +275,29 @@ func (p *PublishedTrack[SubscriberID]) handleStalledPublisher(pub *trackPublishe
 		return
 	}
 
+	// Same if the client has paused the layer. The subscribers have already been moved to other layers.
+	if p.video.isPaused(pub.layer) {
+		pub.logger.Info("No RTPs (paused)")
+		pub.telemetry.AddEvent("No RTPs (paused)")
+		return
+	}
+
+	// If the simulcast is off, we never switch the layers. The subscribers stay with the
+	// stalled publisher and get the packets again once it recovers.
+	if p.config.SimulcastMode == SimulcastModeOff {
+		pub.logger.Info("Publisher is stalled, not switching the layers since the simulcast is off")
+		pub.telemetry.AddEvent("stalled, not switching since the simulcast is off")
+		return
+	}
+
+	// The video without simulcast has no other layer to switch to, so the subscribers stay with its sole
+	// publisher instead of being orphaned and get the packets again once it recovers.
+	if pub.layer == webrtc_ext.SimulcastLayerNone {
+		pub.logger.Warn("Publisher is stalled, not switching the layers since there is no simulcast")
+		pub.telemetry.AddEvent("stalled, not switching since there is no simulcast")
+		return
+	}
+
 	// Otherwise, remove all subscriptions and switch them to the lowest layer if available.
 	// We assume that the lowest layer is the latest to fail (normally, lowest layer always
 	// receive packets even if other layers are stalled).
@@ -181,6 +337,10 @@ func (p *PublishedTrack[SubscriberID]) handleStalledPublisher(pub *trackPublishe
 // track will be observed by the participant either as a grey frame (if it's a
 // start of a call) or as a freeze (if it's in the middle of a call). We call
 // this function to switch stalled subscriptions to use the given publisher.
+// The subscriptions to the video without simulcast stay with its sole publisher
+// while it's stalled, so they are recovered without switching anything.
+// A key frame is requested right away, so that the subscribers don't have to
+// wait for the next natural key frame to decode the recovered layer.
 func (p *PublishedTrack[SubscriberID]) recoverOrphanedSubscriptions(
 	trackPublisher *trackPublisher,
 ) error {
@@ -191,12 +351,82 @@ func (p *PublishedTrack[SubscriberID]) recoverOrphanedSubscriptions(
 	p.mutex.Lock()
 	defer p.mutex.Unlock()
 
+	// The publisher may have been removed as a stale one.
+	if p.video.publishers[trackPublisher.layer] != trackPublisher {
+		return fmt.Errorf("publisher has been removed, can't use it to reactivate stalled subscriptions")
+	}
+
+	recovered := 0
 	for _, subscription := range p.subscriptions {
-		if subscription.currentLayer == webrtc_ext.SimulcastLayerNone {
+		switch {
+		case trackPublisher.layer == webrtc_ext.SimulcastLayerNone:
+			recovered++
+		case p.isOrphaned(subscription):
 			subscription.currentLayer = trackPublisher.layer
-			trackPublisher.addSubscription(subscription)
+			trackPublisher.publisher.AddSubscription(subscription)
+			p.reportSSRCMapping(subscription)
+			recovered++
 		}
 	}
 
+	if recovered == 0 {
+		return nil
+	}
+
+	// A single key frame serves all the recovered subscriptions.
+	trackPublisher.telemetry.AddEvent("subscriptions recovered", attribute.Int("count", recovered))
+	if err := trackPublisher.requestKeyFrame(); err != nil {
+		trackPublisher.logger.WithError(err).Warn("Failed to request a key frame for the recovered subscriptions")
+	}
+
 	return nil
 }
+
+// Removes a publisher that has been stalled for longer than the configured timeout if it has been
+// superseded, i.e. if some other publisher appeared after it stalled and that one is active. This
+// happens when the SSRC changes mid-call and Pion fires a brand new track (with a new RID) while
+// the old one just goes silent. We don't touch the publishers that stall for other reasons (e.g.
+// the publisher temporarily stops sending a layer), since they may recover later.
+func (p *PublishedTrack[SubscriberID]) removeStalePublisher(pub *trackPublisher) {
+	if p.isClosed() {
+		return
+	}
+
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	// The publisher has recovered or has already been removed in the meantime.
+	if p.video.publishers[pub.layer] != pub || !pub.isStalled() || pub.stalledSince.IsZero() {
+		return
+	}
+
+	if p.metadata.Muted || p.video.isPaused(pub.layer) || time.Since(pub.stalledSince) < p.config.stalePublisherTimeout() {
+		return
+	}
+
+	superseded := false
+	for _, other := range p.video.publishers {
+		if other != pub && !other.isStalled() && other.created.After(pub.stalledSince) {
+			superseded = true
+			break
+		}
+	}
+
+	if !superseded {
+		return
+	}
+
+	pub.logger.Info("Removing the stale publisher that has been superseded by a newer one")
+	pub.telemetry.AddEvent("stale, removed")
+	delete(p.video.publishers, pub.layer)
+	pub.removeSubscriptions()
+
+	// Move the subscriptions that stayed with the removed publisher (the simulcast is off) and the
+	// orphaned ones (the new publisher has never recovered from anything, so nobody picked them up).
+	for _, sub := range p.subscriptions {
+		if sub.currentLayer == pub.layer || p.isOrphaned(sub) {
+			sub.currentLayer = webrtc_ext.SimulcastLayerNone
+			p.switchLayer(sub, p.subscriptionLayer(sub))
+		}
+	}
+}
@@ -1,9 +1,23 @@
 package track //nolint:testpackage
 
 import (
+	"context"
+	"errors"
+	"expvar"
+	"io"
+	"reflect"
+	"sync"
 	"testing"
+	"time"
 
+	"github.com/matrix-org/waterfall/pkg/conference/publisher"
+	"github.com/matrix-org/waterfall/pkg/metrics"
+	"github.com/matrix-org/waterfall/pkg/telemetry"
 	"github.com/matrix-org/waterfall/pkg/webrtc_ext"
+	"github.com/pion/interceptor"
+	"github.com/pion/rtp"
+	"github.com/pion/webrtc/v3"
+	"github.com/sirupsen/logrus"
 )
 
 func TestGetOptimalLayer(t *testing.T) {
@@ -52,7 +66,7 @@ func TestGetOptimalLayer(t *testing.T) {
 			layers[layer] = struct{}{}
 		}
 
-		optimalLayer := getOptimalLayer(layers, metadata, c.desiredWidth, c.desiredHeight)
+		optimalLayer := getOptimalLayer(layers, metadata, c.desiredWidth, c.desiredHeight, webrtc_ext.SimulcastLayerNone)
 		if optimalLayer != c.expectedOptimalLayer {
 			t.Errorf("Expected optimal layer %s, got %s", c.expectedOptimalLayer, optimalLayer)
 		}
@@ -63,7 +77,1443 @@ func TestGetOptimalLayerNone(t *testing.T) {
 	layers := make(map[webrtc_ext.SimulcastLayer]struct{})
 	metadata := TrackMetadata{}
 
-	if getOptimalLayer(layers, metadata, 100, 100) != webrtc_ext.SimulcastLayerNone {
+	if getOptimalLayer(layers, metadata, 100, 100, webrtc_ext.SimulcastLayerNone) != webrtc_ext.SimulcastLayerNone {
 		t.Fatal("Expected no simulcast layer for audio")
 	}
 }
+
+func TestGetOptimalLayerUnknownSize(t *testing.T) {
+	low, mid, high := webrtc_ext.SimulcastLayerLow, webrtc_ext.SimulcastLayerMedium, webrtc_ext.SimulcastLayerHigh
+	none := webrtc_ext.SimulcastLayerNone
+
+	layers := map[webrtc_ext.SimulcastLayer]struct{}{low: {}, mid: {}, high: {}}
+	metadata := TrackMetadata{MaxWidth: 1920, MaxHeight: 1080}
+
+	cases := []struct {
+		desiredWidth, desiredHeight int
+		defaultLayer                webrtc_ext.SimulcastLayer
+		expected                    webrtc_ext.SimulcastLayer
+	}{
+		{0, 0, none, low},       // Unknown size, no default configured.
+		{0, 0, mid, mid},        // Unknown size, configured default.
+		{0, 0, high, high},      // Unknown size, configured default.
+		{960, 0, none, mid},     // Width only, the height is derived from the aspect ratio.
+		{320, 0, mid, low},      // Width only, the default is not used.
+		{0, 1080, none, high},   // Height only, the width is derived from the aspect ratio.
+		{960, 540, high, mid},   // Full dimensions, the default is not used.
+		{1920, 1080, low, high}, // Full dimensions, the default is not used.
+	}
+
+	for _, c := range cases {
+		layer := getOptimalLayer(layers, metadata, c.desiredWidth, c.desiredHeight, c.defaultLayer)
+		if layer != c.expected {
+			t.Errorf("%dx%d (default %s): expected %s, got %s",
+				c.desiredWidth, c.desiredHeight, c.defaultLayer, c.expected, layer)
+		}
+	}
+}
+
+func TestGetOptimalLayerScreenshare(t *testing.T) {
+	low, mid, high := webrtc_ext.SimulcastLayerLow, webrtc_ext.SimulcastLayerMedium, webrtc_ext.SimulcastLayerHigh
+	all := map[webrtc_ext.SimulcastLayer]struct{}{low: {}, mid: {}, high: {}}
+	lowAndHigh := map[webrtc_ext.SimulcastLayer]struct{}{low: {}, high: {}}
+
+	cases := []struct {
+		layers                      map[webrtc_ext.SimulcastLayer]struct{}
+		desiredWidth, desiredHeight int
+		camera, screenshare         webrtc_ext.SimulcastLayer
+	}{
+		{all, 0, 0, low, high},            // Unknown size: the default layer vs. the full resolution.
+		{all, 320, 240, low, mid},         // Thumbnail.
+		{all, 960, 540, mid, high},        // Half of the resolution.
+		{all, 1920, 1080, high, high},     // Full resolution.
+		{lowAndHigh, 960, 540, low, high}, // The desired layer is missing: closest vs. the highest one.
+	}
+
+	for _, c := range cases {
+		camera := TrackMetadata{MaxWidth: 1920, MaxHeight: 1080}
+		screenshare := TrackMetadata{MaxWidth: 1920, MaxHeight: 1080, Screenshare: true}
+
+		if layer := getOptimalLayer(c.layers, camera, c.desiredWidth, c.desiredHeight, low); layer != c.camera {
+			t.Errorf("%dx%d camera: expected %s, got %s", c.desiredWidth, c.desiredHeight, c.camera, layer)
+		}
+
+		if layer := getOptimalLayer(c.layers, screenshare, c.desiredWidth, c.desiredHeight, low); layer != c.screenshare {
+			t.Errorf("%dx%d screenshare: expected %s, got %s", c.desiredWidth, c.desiredHeight, c.screenshare, layer)
+		}
+	}
+}
+
+func TestGetHighestLayer(t *testing.T) {
+	low, mid, high := webrtc_ext.SimulcastLayerLow, webrtc_ext.SimulcastLayerMedium, webrtc_ext.SimulcastLayerHigh
+
+	cases := []struct {
+		availableLayers []webrtc_ext.SimulcastLayer
+		expected        webrtc_ext.SimulcastLayer
+	}{
+		{[]webrtc_ext.SimulcastLayer{low, mid, high}, high},
+		{[]webrtc_ext.SimulcastLayer{low, mid}, mid},
+		{[]webrtc_ext.SimulcastLayer{low}, low},
+		{[]webrtc_ext.SimulcastLayer{webrtc_ext.SimulcastLayerNone}, webrtc_ext.SimulcastLayerNone},
+		{[]webrtc_ext.SimulcastLayer{}, webrtc_ext.SimulcastLayerNone},
+	}
+
+	for _, c := range cases {
+		layers := make(map[webrtc_ext.SimulcastLayer]struct{}, len(c.availableLayers))
+		for _, layer := range c.availableLayers {
+			layers[layer] = struct{}{}
+		}
+
+		if layer := getHighestLayer(layers); layer != c.expected {
+			t.Errorf("Expected highest layer %s, got %s", c.expected, layer)
+		}
+	}
+}
+
+type testSubscriber string
+
+func (s testSubscriber) String() string {
+	return string(s)
+}
+
+// A subscription controller backed by a real peer connection that can be told to fail.
+type failingController struct {
+	peerConnection *webrtc.PeerConnection
+	addErr         error
+	removeErr      error
+	// The MIME type of the codec that the controller refuses (if any).
+	unsupportedCodec string
+
+	mutex    sync.Mutex
+	added    int
+	removed  int
+	detached int
+}
+
+func (c *failingController) AddTrack(track *webrtc.TrackLocalStaticRTP) (*webrtc.RTPSender, error) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if c.addErr != nil {
+		return nil, c.addErr
+	}
+
+	c.added++
+	return c.peerConnection.AddTrack(track)
+}
+
+func (c *failingController) RemoveTrack(sender *webrtc.RTPSender) error {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	c.removed++
+	if err := c.peerConnection.RemoveTrack(sender); err != nil {
+		return err
+	}
+
+	return c.removeErr
+}
+
+func (c *failingController) DetachTrack(sender *webrtc.RTPSender) error {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	c.detached++
+	return nil
+}
+
+func (c *failingController) SetMaxBitrate(sender *webrtc.RTPSender, bitrate uint64) {}
+
+func (c *failingController) SupportsCodec(codec webrtc.RTPCodecCapability) bool {
+	return codec.MimeType != c.unsupportedCodec
+}
+
+func TestPublisherLeftPolicy(t *testing.T) {
+	cases := []struct {
+		policy                            PublisherLeftPolicy
+		expectedRemoved, expectedDetached int
+	}{
+		{"", 1, 0},
+		{PublisherLeftPolicyUnsubscribe, 1, 0},
+		{PublisherLeftPolicyFreeze, 0, 1},
+	}
+
+	for _, c := range cases {
+		peerConnection, err := webrtc.NewPeerConnection(webrtc.Configuration{})
+		if err != nil {
+			t.Fatalf("Failed to create peer connection: %v", err)
+		}
+		defer peerConnection.Close()
+
+		outputTrack, err := webrtc.NewTrackLocalStaticRTP(webrtc.RTPCodecCapability{MimeType: webrtc.MimeTypeOpus}, "track", "stream")
+		if err != nil {
+			t.Fatalf("Failed to create track: %v", err)
+		}
+
+		controller := &failingController{peerConnection: peerConnection}
+		published := &PublishedTrack[testSubscriber]{
+			logger:    logrus.NewEntry(logrus.New()),
+			telemetry: telemetry.NewTelemetry(context.Background(), "PublishedTrack"),
+			info: webrtc_ext.TrackInfo{
+				TrackID:  "track",
+				StreamID: "stream",
+				Kind:     webrtc.RTPCodecTypeAudio,
+				Codec:    outputTrack.Codec(),
+			},
+			config:        Config{PublisherLeftPolicy: c.policy},
+			subscriptions: make(map[testSubscriber]*trackSubscription[testSubscriber]),
+			audio:         &audioTrack{outputTrack: outputTrack},
+			video:         &videoTrack{publishers: make(map[webrtc_ext.SimulcastLayer]*trackPublisher)},
+			done:          make(chan struct{}),
+		}
+
+		if err := published.Subscribe("subscriber", controller, 0, 0, published.logger); err != nil {
+			t.Fatalf("%q: failed to subscribe: %v", c.policy, err)
+		}
+
+		published.UnsubscribeAll()
+
+		if controller.removed != c.expectedRemoved || controller.detached != c.expectedDetached {
+			t.Errorf("%q: expected %d removed and %d detached tracks, got %d and %d",
+				c.policy, c.expectedRemoved, c.expectedDetached, controller.removed, controller.detached)
+		}
+
+		if published.IsSubscribed("subscriber") {
+			t.Errorf("%q: expected the subscription to end", c.policy)
+		}
+
+		// An explicit unsubscribe always removes the track.
+		if err := published.Subscribe("other", controller, 0, 0, published.logger); err != nil {
+			t.Fatalf("%q: failed to subscribe: %v", c.policy, err)
+		}
+
+		published.Unsubscribe("other")
+		if controller.removed != c.expectedRemoved+1 {
+			t.Errorf("%q: expected an explicit unsubscribe to remove the track", c.policy)
+		}
+	}
+}
+
+func TestSubscribeRollback(t *testing.T) {
+	errAdd := errors.New("add failed")
+	errRemove := errors.New("remove failed")
+
+	cases := []struct {
+		name            string
+		addErr          error
+		removeErr       error
+		expectedErr     error
+		expectedRemoved int
+	}{
+		// Adding the track fails, there is nothing to roll back.
+		{"add track fails", errAdd, nil, errAdd, 0},
+		// The track is added, but there is no publisher, so the sender must be removed.
+		{"no publisher", nil, nil, nil, 1},
+		// The rollback itself fails, the error must still be reported.
+		{"rollback fails", nil, errRemove, errRemove, 1},
+	}
+
+	for _, c := range cases {
+		peerConnection, err := webrtc.NewPeerConnection(webrtc.Configuration{})
+		if err != nil {
+			t.Fatalf("Failed to create peer connection: %v", err)
+		}
+		defer peerConnection.Close()
+
+		controller := &failingController{peerConnection: peerConnection, addErr: c.addErr, removeErr: c.removeErr}
+
+		// A video track that has no publishers (e.g. all of them have just been removed).
+		published := &PublishedTrack[testSubscriber]{
+			logger:    logrus.NewEntry(logrus.New()),
+			telemetry: telemetry.NewTelemetry(context.Background(), "PublishedTrack"),
+			info: webrtc_ext.TrackInfo{
+				TrackID:  "track",
+				StreamID: "stream",
+				Kind:     webrtc.RTPCodecTypeVideo,
+				Codec:    webrtc.RTPCodecCapability{MimeType: webrtc.MimeTypeVP8, ClockRate: 90000},
+			},
+			subscriptions: make(map[testSubscriber]*trackSubscription[testSubscriber]),
+			video:         &videoTrack{publishers: make(map[webrtc_ext.SimulcastLayer]*trackPublisher)},
+			done:          make(chan struct{}),
+		}
+
+		err = published.Subscribe("subscriber", controller, 640, 480, published.logger)
+		if err == nil {
+			t.Errorf("%s: expected subscription to fail", c.name)
+			continue
+		}
+
+		if c.expectedErr != nil && !errors.Is(err, c.expectedErr) {
+			t.Errorf("%s: expected error %v, got %v", c.name, c.expectedErr, err)
+		}
+
+		if controller.removed != c.expectedRemoved {
+			t.Errorf("%s: expected %d removed tracks, got %d", c.name, c.expectedRemoved, controller.removed)
+		}
+
+		if len(published.subscriptions) != 0 {
+			t.Errorf("%s: expected no subscriptions, got %d", c.name, len(published.subscriptions))
+		}
+	}
+}
+
+func TestSubscribeCodecCompatibility(t *testing.T) {
+	cases := []struct {
+		name             string
+		unsupportedCodec string
+		expectedErr      error
+	}{
+		{"compatible", webrtc.MimeTypePCMU, nil},
+		{"incompatible", webrtc.MimeTypeOpus, ErrIncompatibleCodec},
+	}
+
+	for _, c := range cases {
+		peerConnection, err := webrtc.NewPeerConnection(webrtc.Configuration{})
+		if err != nil {
+			t.Fatalf("Failed to create peer connection: %v", err)
+		}
+		defer peerConnection.Close()
+
+		outputTrack, err := webrtc.NewTrackLocalStaticRTP(webrtc.RTPCodecCapability{MimeType: webrtc.MimeTypeOpus}, "track", "stream")
+		if err != nil {
+			t.Fatalf("Failed to create track: %v", err)
+		}
+
+		controller := &failingController{peerConnection: peerConnection, unsupportedCodec: c.unsupportedCodec}
+		published := &PublishedTrack[testSubscriber]{
+			logger:    logrus.NewEntry(logrus.New()),
+			telemetry: telemetry.NewTelemetry(context.Background(), "PublishedTrack"),
+			info: webrtc_ext.TrackInfo{
+				TrackID:  "track",
+				StreamID: "stream",
+				Kind:     webrtc.RTPCodecTypeAudio,
+				Codec:    outputTrack.Codec(),
+			},
+			subscriptions: make(map[testSubscriber]*trackSubscription[testSubscriber]),
+			audio:         &audioTrack{outputTrack: outputTrack},
+			video:         &videoTrack{publishers: make(map[webrtc_ext.SimulcastLayer]*trackPublisher)},
+			done:          make(chan struct{}),
+		}
+
+		refusedBefore := incompatibleCodecSubscriptions(webrtc.MimeTypeOpus)
+		err = published.Subscribe("subscriber", controller, 0, 0, published.logger)
+		if c.expectedErr == nil && err != nil {
+			t.Errorf("%s: failed to subscribe: %v", c.name, err)
+		}
+		if c.expectedErr != nil && !errors.Is(err, c.expectedErr) {
+			t.Errorf("%s: expected error %v, got %v", c.name, c.expectedErr, err)
+		}
+
+		// The incompatible subscription must not even reach the subscriber's peer connection.
+		subscribed := c.expectedErr == nil
+		if published.IsSubscribed("subscriber") != subscribed || (controller.added == 1) != subscribed {
+			t.Errorf("%s: expected subscribed to be %v, got %d added tracks", c.name, subscribed, controller.added)
+		}
+
+		// The operators learn about the subscriptions that would need transcoding.
+		expectedRefused := int64(0)
+		if !subscribed {
+			expectedRefused = 1
+		}
+		if refused := incompatibleCodecSubscriptions(webrtc.MimeTypeOpus) - refusedBefore; refused != expectedRefused {
+			t.Errorf("%s: expected %d counted incompatible subscriptions, got %d", c.name, expectedRefused, refused)
+		}
+	}
+}
+
+// Returns the number of the subscriptions that have been refused due to a given codec so far.
+func incompatibleCodecSubscriptions(mimeType string) int64 {
+	if counter, ok := metrics.IncompatibleCodec.Get(mimeType).(*expvar.Int); ok {
+		return counter.Value()
+	}
+
+	return 0
+}
+
+// A published track that blocks until it's closed.
+type idleTrack struct {
+	closed chan struct{}
+}
+
+func (t *idleTrack) ReadPacket() (*rtp.Packet, error) {
+	<-t.closed
+	return nil, io.EOF
+}
+
+// A subscription that drops all packets.
+type nopSubscription struct{}
+
+func (nopSubscription) Unsubscribe() error               { return nil }
+func (nopSubscription) Detach() error                    { return nil }
+func (nopSubscription) WriteRTP(packet rtp.Packet) error { return nil }
+func (nopSubscription) OutgoingSSRC() webrtc.SSRC        { return 0 }
+
+// Wraps a publisher of a given layer like `newTrackPublisher()` does, but without processing its events, so
+// that the tests could drive the track themselves.
+func newTestTrackPublisher(
+	pub *publisher.Publisher,
+	events <-chan publisher.Status,
+	requestKeyFrame func(*webrtc.TrackRemote) error,
+	layer webrtc_ext.SimulcastLayer,
+	logger *logrus.Entry,
+	tel *telemetry.Telemetry,
+) *trackPublisher {
+	return &trackPublisher{
+		publisher:         pub,
+		eventsChannel:     events,
+		requestKeyFrameFn: requestKeyFrame,
+		layer:             layer,
+		logger:            logger,
+		telemetry:         tel,
+		created:           time.Now(),
+	}
+}
+
+func TestNoLayerSwitchWhenSimulcastIsOff(t *testing.T) {
+	low, high := webrtc_ext.SimulcastLayerLow, webrtc_ext.SimulcastLayerHigh
+	logger := logrus.NewEntry(logrus.New())
+	tel := telemetry.NewTelemetry(context.Background(), "PublishedTrack")
+
+	track := &idleTrack{closed: make(chan struct{})}
+	defer close(track.closed)
+
+	newPublisher := func(layer webrtc_ext.SimulcastLayer) *trackPublisher {
+		pub, events := publisher.NewPublisher(track, make(chan struct{}), time.Hour, publisher.Impairment{}, logger)
+		return newTestTrackPublisher(pub, events, nil, layer, logger, tel)
+	}
+
+	published := &PublishedTrack[testSubscriber]{
+		logger:        logger,
+		telemetry:     tel,
+		info:          webrtc_ext.TrackInfo{TrackID: "track", Kind: webrtc.RTPCodecTypeVideo},
+		config:        Config{SimulcastMode: SimulcastModeOff, FixedLayer: high},
+		subscriptions: make(map[testSubscriber]*trackSubscription[testSubscriber]),
+		video: &videoTrack{publishers: map[webrtc_ext.SimulcastLayer]*trackPublisher{
+			low:  newPublisher(low),
+			high: newPublisher(high),
+		}},
+		done: make(chan struct{}),
+	}
+
+	// The fixed layer is used regardless of the requested resolution.
+	if layer := published.optimalLayer(320, 240); layer != high {
+		t.Fatalf("Expected the fixed layer %s, got %s", high, layer)
+	}
+
+	sub := &trackSubscription[testSubscriber]{nopSubscription{}, high, "subscriber", 320, 240, webrtc_ext.SimulcastLayerNone}
+	published.subscriptions[sub.subscriberID] = sub
+	published.video.publishers[high].publisher.AddSubscription(sub)
+
+	// The publisher of the fixed layer stalls, but the subscription must stay with it.
+	published.handleStalledPublisher(published.video.publishers[high])
+
+	if sub.currentLayer != high {
+		t.Errorf("Expected the subscription to stay on %s, got %s", high, sub.currentLayer)
+	}
+
+	if remaining := published.video.publishers[high].removeSubscriptions(); len(remaining) != 1 {
+		t.Errorf("Expected the subscription to stay attached to the stalled publisher")
+	}
+
+	if removed := published.video.publishers[low].removeSubscriptions(); len(removed) != 0 {
+		t.Errorf("Expected no subscriptions on the %s layer, got %d", low, len(removed))
+	}
+}
+
+func TestNonSimulcastVideo(t *testing.T) {
+	none := webrtc_ext.SimulcastLayerNone
+	logger := logrus.NewEntry(logrus.New())
+	tel := telemetry.NewTelemetry(context.Background(), "PublishedTrack")
+
+	// The publisher is stopped right away, so that it never reads from the (fake) remote track.
+	stopped := make(chan struct{})
+	close(stopped)
+	pub, events := publisher.NewPublisher(
+		&publisher.RemoteTrack{Track: &webrtc.TrackRemote{}},
+		stopped,
+		time.Hour,
+		publisher.Impairment{},
+		logger,
+	)
+
+	requested := 0
+	requestKeyFrame := func(*webrtc.TrackRemote) error {
+		requested++
+		return nil
+	}
+
+	// The track without the RID is the sole publisher of the video.
+	sole := newTestTrackPublisher(pub, events, requestKeyFrame, none, logger, tel)
+
+	published := &PublishedTrack[testSubscriber]{
+		logger:    logger,
+		telemetry: tel,
+		info: webrtc_ext.TrackInfo{
+			TrackID:  "track",
+			StreamID: "stream",
+			Kind:     webrtc.RTPCodecTypeVideo,
+			Codec:    webrtc.RTPCodecCapability{MimeType: webrtc.MimeTypeVP8, ClockRate: 90000},
+		},
+		metadata:      TrackMetadata{MaxWidth: 1280, MaxHeight: 720},
+		subscriptions: make(map[testSubscriber]*trackSubscription[testSubscriber]),
+		video:         &videoTrack{publishers: map[webrtc_ext.SimulcastLayer]*trackPublisher{none: sole}},
+		done:          make(chan struct{}),
+	}
+
+	subscribe := func(subscriberID testSubscriber) {
+		peerConnection, err := webrtc.NewPeerConnection(webrtc.Configuration{})
+		if err != nil {
+			t.Fatalf("Failed to create peer connection: %v", err)
+		}
+		t.Cleanup(func() { peerConnection.Close() })
+
+		controller := &failingController{peerConnection: peerConnection}
+		if err := published.Subscribe(subscriberID, controller, 320, 180, logger); err != nil {
+			t.Fatalf("Failed to subscribe %s: %v", subscriberID, err)
+		}
+	}
+
+	// Checks that all subscriptions are attached to the sole publisher.
+	expectAttached := func(when string) {
+		for subscriberID, layers := range published.SubscriptionLayers() {
+			if layers.Current != none {
+				t.Errorf("%s: expected %s to have no layer, got %s", when, subscriberID, layers.Current)
+			}
+			if published.isOrphaned(published.subscriptions[subscriberID]) {
+				t.Errorf("%s: expected %s to be attached to the sole publisher", when, subscriberID)
+			}
+		}
+	}
+
+	// A small resolution does not matter, there is nothing else to subscribe to.
+	subscribe("first")
+	expectAttached("subscribed")
+
+	// Neither limiting the layer, nor pinning or pausing the layers switch anything.
+	if err := published.SwitchLayer("first", webrtc_ext.SimulcastLayerLow); !errors.Is(err, ErrFixedLayer) {
+		t.Errorf("Expected the layer of the video without simulcast to be fixed, got %v", err)
+	}
+	published.SetPinned(true)
+	published.SetPausedLayers([]webrtc_ext.SimulcastLayer{webrtc_ext.SimulcastLayerLow})
+	expectAttached("layers re-evaluated")
+
+	// The sole publisher stalls, but the subscriptions must not lose it, including the new ones.
+	published.handleStalledPublisher(sole)
+	subscribe("second")
+	expectAttached("stalled")
+
+	// The publisher recovers. Nothing is switched, but the subscribers get a key frame.
+	requested = 0
+	if err := published.recoverOrphanedSubscriptions(sole); err != nil {
+		t.Fatalf("Failed to recover the subscriptions: %v", err)
+	}
+	expectAttached("recovered")
+
+	if requested != 1 {
+		t.Errorf("Expected a single key frame request for the recovered publisher, got %d", requested)
+	}
+
+	if attached := sole.removeSubscriptions(); len(attached) != 2 {
+		t.Errorf("Expected both subscriptions to be attached to the sole publisher, got %d", len(attached))
+	}
+}
+
+func TestRemoveStalePublisher(t *testing.T) {
+	low, mid := webrtc_ext.SimulcastLayerLow, webrtc_ext.SimulcastLayerMedium
+	logger := logrus.NewEntry(logrus.New())
+	tel := telemetry.NewTelemetry(context.Background(), "PublishedTrack")
+
+	cases := []struct {
+		name string
+		// For how long the stalled publisher is kept before it's checked for being superseded.
+		stalePublisherTimeout time.Duration
+		// Whether the new publisher appears after the old one stalled.
+		newAfterStall bool
+		expectRemoved bool
+	}{
+		{"superseded after an SSRC change", 50 * time.Millisecond, true, true},
+		{"still within the grace period", time.Hour, true, false},
+		{"regular simulcast layer", 50 * time.Millisecond, false, false},
+	}
+
+	for _, c := range cases {
+		track := &idleTrack{closed: make(chan struct{})}
+		stop := make(chan struct{})
+
+		published := &PublishedTrack[testSubscriber]{
+			logger:    logger,
+			telemetry: tel,
+			info:      webrtc_ext.TrackInfo{TrackID: "track", Kind: webrtc.RTPCodecTypeVideo},
+			config: Config{
+				SimulcastMode:         SimulcastModeOff,
+				StalePublisherTimeout: c.stalePublisherTimeout,
+			},
+			subscriptions:    make(map[testSubscriber]*trackSubscription[testSubscriber]),
+			video:            &videoTrack{publishers: make(map[webrtc_ext.SimulcastLayer]*trackPublisher)},
+			activePublishers: &sync.WaitGroup{},
+			done:             make(chan struct{}),
+		}
+
+		// Starts a publisher that stalls once it gets no packets for a given time (the track never sends any).
+		start := func(layer webrtc_ext.SimulcastLayer, stallTimeout time.Duration) *trackPublisher {
+			pub, events := publisher.NewPublisher(track, stop, stallTimeout, publisher.Impairment{}, logger)
+			trackPublisher := newTestTrackPublisher(pub, events, nil, layer, logger, tel)
+
+			published.mutex.Lock()
+			published.video.publishers[layer] = trackPublisher
+			published.mutex.Unlock()
+
+			published.activePublishers.Add(1)
+			go func() {
+				defer published.activePublishers.Done()
+				published.observePublisher(trackPublisher)
+			}()
+
+			return trackPublisher
+		}
+
+		// The new publisher (with a new RID) keeps working.
+		var fresh *trackPublisher
+		if !c.newAfterStall {
+			fresh = start(mid, time.Hour)
+		}
+
+		// With the simulcast off, the subscription stays with the old publisher once it stalls.
+		sub := &trackSubscription[testSubscriber]{nopSubscription{}, low, "subscriber", 0, 0, webrtc_ext.SimulcastLayerNone}
+		published.mutex.Lock()
+		published.subscriptions[sub.subscriberID] = sub
+		published.mutex.Unlock()
+
+		// The old publisher stalls right away (its track went silent after the SSRC change).
+		old := start(low, time.Millisecond)
+		old.addSubscription(sub)
+
+		stalled := func() bool {
+			published.mutex.Lock()
+			defer published.mutex.Unlock()
+			return !old.stalledSince.IsZero()
+		}
+		for deadline := time.Now().Add(time.Second); !stalled(); time.Sleep(time.Millisecond) {
+			if time.Now().After(deadline) {
+				t.Fatalf("%s: expected the old publisher to stall", c.name)
+			}
+		}
+
+		if c.newAfterStall {
+			fresh = start(mid, time.Hour)
+		}
+
+		removed := func() bool {
+			published.mutex.Lock()
+			defer published.mutex.Unlock()
+			_, kept := published.video.publishers[low]
+			return !kept
+		}
+
+		// Give the stale publisher enough time to be reaped (if it's going to be).
+		for deadline := time.Now().Add(500 * time.Millisecond); !removed() && time.Now().Before(deadline); {
+			time.Sleep(time.Millisecond)
+		}
+
+		published.mutex.Lock()
+		currentLayer := sub.currentLayer
+		published.mutex.Unlock()
+
+		switch {
+		case removed() != c.expectRemoved:
+			t.Errorf("%s: expected the old publisher to be removed: %v", c.name, c.expectRemoved)
+		case c.expectRemoved && currentLayer != mid:
+			t.Errorf("%s: expected the subscription to move to %s, got %s", c.name, mid, currentLayer)
+		case c.expectRemoved && len(fresh.removeSubscriptions()) != 1:
+			t.Errorf("%s: expected the subscription to be attached to the new publisher", c.name)
+		case !c.expectRemoved && currentLayer != low:
+			t.Errorf("%s: expected the subscription to stay on %s, got %s", c.name, low, currentLayer)
+		}
+
+		close(stop)
+		close(track.closed)
+		published.activePublishers.Wait()
+	}
+}
+
+func TestPausedLayer(t *testing.T) {
+	low, high := webrtc_ext.SimulcastLayerLow, webrtc_ext.SimulcastLayerHigh
+	logger := logrus.NewEntry(logrus.New())
+	tel := telemetry.NewTelemetry(context.Background(), "PublishedTrack")
+
+	track := &idleTrack{closed: make(chan struct{})}
+	defer close(track.closed)
+
+	newPublisher := func(layer webrtc_ext.SimulcastLayer) *trackPublisher {
+		pub, events := publisher.NewPublisher(track, make(chan struct{}), time.Hour, publisher.Impairment{}, logger)
+		return newTestTrackPublisher(pub, events, nil, layer, logger, tel)
+	}
+
+	published := &PublishedTrack[testSubscriber]{
+		logger:        logger,
+		telemetry:     tel,
+		info:          webrtc_ext.TrackInfo{TrackID: "track", Kind: webrtc.RTPCodecTypeVideo},
+		subscriptions: make(map[testSubscriber]*trackSubscription[testSubscriber]),
+		video: &videoTrack{publishers: map[webrtc_ext.SimulcastLayer]*trackPublisher{
+			low:  newPublisher(low),
+			high: newPublisher(high),
+		}},
+		metadata: TrackMetadata{MaxWidth: 1280, MaxHeight: 720},
+		done:     make(chan struct{}),
+	}
+
+	// The subscriber wants the full resolution.
+	sub := &trackSubscription[testSubscriber]{nopSubscription{}, high, "subscriber", 1280, 720, webrtc_ext.SimulcastLayerNone}
+	published.subscriptions[sub.subscriberID] = sub
+	published.video.publishers[high].publisher.AddSubscription(sub)
+
+	// The client pauses the high layer, so the subscriber gets the best of the remaining layers.
+	published.SetPausedLayers([]webrtc_ext.SimulcastLayer{high})
+	if sub.currentLayer != low {
+		t.Fatalf("Expected the subscription to move to %s, got %s", low, sub.currentLayer)
+	}
+
+	// The paused layer stops sending, which is not a failure: the subscribers stay where they are.
+	published.handleStalledPublisher(published.video.publishers[high])
+	if sub.currentLayer != low {
+		t.Errorf("Expected the subscription to stay on %s, got %s", low, sub.currentLayer)
+	}
+
+	if removed := published.video.publishers[low].removeSubscriptions(); len(removed) != 1 {
+		t.Errorf("Expected the subscription to stay attached to the %s layer", low)
+	}
+	published.video.publishers[low].addSubscription(sub)
+
+	// New subscribers don't get the paused layer either.
+	if layer := published.optimalLayer(1280, 720); layer != low {
+		t.Errorf("Expected the paused layer not to be used, got %s", layer)
+	}
+
+	// Once the layer is resumed, the subscriber gets it back.
+	published.SetPausedLayers(nil)
+	if sub.currentLayer != high {
+		t.Errorf("Expected the subscription to move back to %s, got %s", high, sub.currentLayer)
+	}
+}
+
+func TestUpgradeSubscriptionsOnNewLayer(t *testing.T) {
+	low, high := webrtc_ext.SimulcastLayerLow, webrtc_ext.SimulcastLayerHigh
+	logger := logrus.NewEntry(logrus.New())
+	tel := telemetry.NewTelemetry(context.Background(), "PublishedTrack")
+
+	track := &idleTrack{closed: make(chan struct{})}
+	defer close(track.closed)
+
+	newPublisher := func(layer webrtc_ext.SimulcastLayer) *trackPublisher {
+		pub, events := publisher.NewPublisher(track, make(chan struct{}), time.Hour, publisher.Impairment{}, logger)
+		return newTestTrackPublisher(pub, events, nil, layer, logger, tel)
+	}
+
+	// Only the low layer is published when the subscribers attach.
+	published := &PublishedTrack[testSubscriber]{
+		logger:        logger,
+		telemetry:     tel,
+		info:          webrtc_ext.TrackInfo{TrackID: "track", Kind: webrtc.RTPCodecTypeVideo},
+		subscriptions: make(map[testSubscriber]*trackSubscription[testSubscriber]),
+		video:         &videoTrack{publishers: map[webrtc_ext.SimulcastLayer]*trackPublisher{low: newPublisher(low)}},
+		metadata:      TrackMetadata{MaxWidth: 1280, MaxHeight: 720},
+		done:          make(chan struct{}),
+	}
+
+	large := &trackSubscription[testSubscriber]{nopSubscription{}, low, "large", 1280, 720, webrtc_ext.SimulcastLayerNone}
+	small := &trackSubscription[testSubscriber]{nopSubscription{}, low, "small", 320, 180, webrtc_ext.SimulcastLayerNone}
+	for _, sub := range []*trackSubscription[testSubscriber]{large, small} {
+		published.subscriptions[sub.subscriberID] = sub
+		published.video.publishers[low].addSubscription(sub)
+	}
+
+	// The high layer appears later on.
+	published.video.publishers[high] = newPublisher(high)
+	published.upgradeSubscriptions(high)
+
+	if large.currentLayer != high {
+		t.Errorf("Expected the subscription to be upgraded to %s, got %s", high, large.currentLayer)
+	}
+
+	if small.currentLayer != low {
+		t.Errorf("Expected the subscription to stay on %s, got %s", low, small.currentLayer)
+	}
+
+	if removed := published.video.publishers[high].removeSubscriptions(); len(removed) != 1 {
+		t.Errorf("Expected a single subscription on the %s layer, got %d", high, len(removed))
+	}
+}
+
+func TestSwitchLayerLimit(t *testing.T) {
+	low, high := webrtc_ext.SimulcastLayerLow, webrtc_ext.SimulcastLayerHigh
+	logger := logrus.NewEntry(logrus.New())
+	tel := telemetry.NewTelemetry(context.Background(), "PublishedTrack")
+
+	track := &idleTrack{closed: make(chan struct{})}
+	defer close(track.closed)
+
+	newPublisher := func(layer webrtc_ext.SimulcastLayer) *trackPublisher {
+		pub, events := publisher.NewPublisher(track, make(chan struct{}), time.Hour, publisher.Impairment{}, logger)
+		return newTestTrackPublisher(pub, events, nil, layer, logger, tel)
+	}
+
+	published := &PublishedTrack[testSubscriber]{
+		logger:        logger,
+		telemetry:     tel,
+		info:          webrtc_ext.TrackInfo{TrackID: "track", Kind: webrtc.RTPCodecTypeVideo},
+		subscriptions: make(map[testSubscriber]*trackSubscription[testSubscriber]),
+		video: &videoTrack{publishers: map[webrtc_ext.SimulcastLayer]*trackPublisher{
+			low:  newPublisher(low),
+			high: newPublisher(high),
+		}},
+		metadata: TrackMetadata{MaxWidth: 1280, MaxHeight: 720},
+		done:     make(chan struct{}),
+	}
+
+	// The subscriber wants the full resolution.
+	sub := &trackSubscription[testSubscriber]{nopSubscription{}, high, "subscriber", 1280, 720, webrtc_ext.SimulcastLayerNone}
+	published.subscriptions[sub.subscriberID] = sub
+	published.video.publishers[high].addSubscription(sub)
+
+	if err := published.SwitchLayer("unknown", low); err == nil {
+		t.Errorf("Expected an error when switching the layer of an unknown subscriber")
+	}
+
+	// There is no medium layer, so the subscription gets the best layer below the limit.
+	if err := published.SwitchLayer(sub.subscriberID, webrtc_ext.SimulcastLayerMedium); err != nil {
+		t.Fatalf("Failed to switch the layer: %v", err)
+	}
+	if sub.currentLayer != low {
+		t.Fatalf("Expected the subscription to move to %s, got %s", low, sub.currentLayer)
+	}
+
+	// The limit survives the re-evaluation of the layers.
+	published.SetPausedLayers(nil)
+	if sub.currentLayer != low {
+		t.Errorf("Expected the subscription to stay on %s, got %s", low, sub.currentLayer)
+	}
+
+	if layers := published.SubscriptionLayers()[sub.subscriberID]; layers.Max != webrtc_ext.SimulcastLayerMedium {
+		t.Errorf("Expected the subscription to be limited to %s, got %s", webrtc_ext.SimulcastLayerMedium, layers.Max)
+	}
+
+	// Once the limit is lifted, the subscriber gets the desired layer back.
+	if err := published.SwitchLayer(sub.subscriberID, webrtc_ext.SimulcastLayerNone); err != nil {
+		t.Fatalf("Failed to lift the limit: %v", err)
+	}
+	if sub.currentLayer != high {
+		t.Errorf("Expected the subscription to move back to %s, got %s", high, sub.currentLayer)
+	}
+
+	// The layers of pinned tracks are never switched.
+	published.SetPinned(true)
+	if err := published.SwitchLayer(sub.subscriberID, low); !errors.Is(err, ErrFixedLayer) {
+		t.Errorf("Expected %v for a pinned track, got %v", ErrFixedLayer, err)
+	}
+}
+
+// A subscription that drops all packets and remembers the hinted maximum bitrate.
+type hintedSubscription struct {
+	nopSubscription
+	maxBitrate uint64
+}
+
+func (s *hintedSubscription) SetMaxBitrate(bitrate uint64) {
+	s.maxBitrate = bitrate
+}
+
+func TestMaxLayerBitrateHints(t *testing.T) {
+	low, medium, high := webrtc_ext.SimulcastLayerLow, webrtc_ext.SimulcastLayerMedium, webrtc_ext.SimulcastLayerHigh
+	logger := logrus.NewEntry(logrus.New())
+	tel := telemetry.NewTelemetry(context.Background(), "PublishedTrack")
+
+	track := &idleTrack{closed: make(chan struct{})}
+	defer close(track.closed)
+
+	newPublisher := func(layer webrtc_ext.SimulcastLayer) *trackPublisher {
+		pub, events := publisher.NewPublisher(track, make(chan struct{}), time.Hour, publisher.Impairment{}, logger)
+		return newTestTrackPublisher(pub, events, nil, layer, logger, tel)
+	}
+
+	config := Config{MaxLayerBitrates: map[webrtc_ext.SimulcastLayer]uint64{
+		low:    150_000,
+		medium: 500_000,
+		high:   1_500_000,
+	}}
+
+	published := &PublishedTrack[testSubscriber]{
+		logger:        logger,
+		telemetry:     tel,
+		config:        config,
+		info:          webrtc_ext.TrackInfo{TrackID: "track", Kind: webrtc.RTPCodecTypeVideo},
+		subscriptions: make(map[testSubscriber]*trackSubscription[testSubscriber]),
+		video: &videoTrack{publishers: map[webrtc_ext.SimulcastLayer]*trackPublisher{
+			low:    newPublisher(low),
+			medium: newPublisher(medium),
+			high:   newPublisher(high),
+		}},
+		done: make(chan struct{}),
+	}
+
+	hinted := &hintedSubscription{}
+	sub := &trackSubscription[testSubscriber]{hinted, high, "subscriber", 1280, 720, webrtc_ext.SimulcastLayerNone}
+	published.subscriptions[sub.subscriberID] = sub
+	published.video.publishers[high].addSubscription(sub)
+
+	// Every layer is hinted its own bitrate.
+	for _, layer := range []webrtc_ext.SimulcastLayer{low, medium, high} {
+		published.switchLayer(sub, layer)
+		if hinted.maxBitrate != config.MaxLayerBitrates[layer] {
+			t.Errorf("Expected %s to be hinted %d bps, got %d", layer, config.MaxLayerBitrates[layer], hinted.maxBitrate)
+		}
+	}
+
+	// The orphaned subscription keeps the hint of its layer until it gets a new one.
+	delete(published.video.publishers, low)
+	published.switchLayer(sub, low)
+	if hinted.maxBitrate != config.MaxLayerBitrates[high] {
+		t.Errorf("Expected the orphaned subscription to keep its hint, got %d", hinted.maxBitrate)
+	}
+
+	// The video without simulcast is hinted the bitrate of the high layer.
+	published.video.publishers = map[webrtc_ext.SimulcastLayer]*trackPublisher{
+		webrtc_ext.SimulcastLayerNone: newPublisher(webrtc_ext.SimulcastLayerNone),
+	}
+	hinted.maxBitrate = 0
+	published.hintMaxBitrate(sub)
+	if hinted.maxBitrate != config.MaxLayerBitrates[high] {
+		t.Errorf("Expected the video without simulcast to be hinted %d bps, got %d", config.MaxLayerBitrates[high], hinted.maxBitrate)
+	}
+}
+
+func TestRecoveryRequestsKeyFrame(t *testing.T) {
+	low := webrtc_ext.SimulcastLayerLow
+	logger := logrus.NewEntry(logrus.New())
+	tel := telemetry.NewTelemetry(context.Background(), "PublishedTrack")
+
+	// The publisher is stopped right away, so that it never reads from the (fake) remote track.
+	stopped := make(chan struct{})
+	close(stopped)
+	pub, events := publisher.NewPublisher(
+		&publisher.RemoteTrack{Track: &webrtc.TrackRemote{}},
+		stopped,
+		time.Hour,
+		publisher.Impairment{},
+		logger,
+	)
+
+	requested := 0
+	requestKeyFrame := func(*webrtc.TrackRemote) error {
+		requested++
+		return nil
+	}
+	recovered := newTestTrackPublisher(pub, events, requestKeyFrame, low, logger, tel)
+
+	published := &PublishedTrack[testSubscriber]{
+		logger:        logger,
+		telemetry:     tel,
+		info:          webrtc_ext.TrackInfo{TrackID: "track", Kind: webrtc.RTPCodecTypeVideo},
+		subscriptions: make(map[testSubscriber]*trackSubscription[testSubscriber]),
+		video:         &videoTrack{publishers: map[webrtc_ext.SimulcastLayer]*trackPublisher{low: recovered}},
+		done:          make(chan struct{}),
+	}
+
+	// Nothing to recover, so no key frame is needed.
+	if err := published.recoverOrphanedSubscriptions(recovered); err != nil {
+		t.Fatalf("Failed to recover the subscriptions: %v", err)
+	}
+	if requested != 0 {
+		t.Fatalf("Expected no key frame requests without orphaned subscriptions, got %d", requested)
+	}
+
+	// The orphaned subscriptions lost their publisher when it stalled.
+	for _, id := range []testSubscriber{"first", "second"} {
+		sub := &trackSubscription[testSubscriber]{nopSubscription{}, webrtc_ext.SimulcastLayerNone, id, 0, 0, webrtc_ext.SimulcastLayerNone}
+		published.subscriptions[id] = sub
+	}
+
+	if err := published.recoverOrphanedSubscriptions(recovered); err != nil {
+		t.Fatalf("Failed to recover the subscriptions: %v", err)
+	}
+
+	for id, sub := range published.subscriptions {
+		if sub.currentLayer != low {
+			t.Errorf("Expected %s to be recovered on %s, got %s", id, low, sub.currentLayer)
+		}
+	}
+
+	if attached := recovered.removeSubscriptions(); len(attached) != 2 {
+		t.Errorf("Expected the subscriptions to be attached to the recovered publisher, got %d", len(attached))
+	}
+
+	if requested != 1 {
+		t.Errorf("Expected a single key frame request for the recovered subscriptions, got %d", requested)
+	}
+}
+
+func TestKeyFrameRequestInterval(t *testing.T) {
+	low := webrtc_ext.SimulcastLayerLow
+	logger := logrus.NewEntry(logrus.New())
+	tel := telemetry.NewTelemetry(context.Background(), "PublishedTrack")
+
+	config := Config{
+		StallTimeout:                       time.Second,
+		ScreenshareStallTimeout:            5 * time.Second,
+		KeyFrameRequestInterval:            time.Hour,
+		ScreenshareKeyFrameRequestInterval: time.Nanosecond,
+	}
+
+	if timeout := config.stallTimeout(TrackMetadata{}); timeout != time.Second {
+		t.Errorf("Expected the camera stall timeout to be %s, got %s", time.Second, timeout)
+	}
+	if timeout := config.stallTimeout(TrackMetadata{Screenshare: true}); timeout != 5*time.Second {
+		t.Errorf("Expected the screen share stall timeout to be %s, got %s", 5*time.Second, timeout)
+	}
+	fallback := Config{StallTimeout: time.Second}
+	if timeout := fallback.stallTimeout(TrackMetadata{Screenshare: true}); timeout != time.Second {
+		t.Errorf("Expected the screen share stall timeout to fall back to %s, got %s", time.Second, timeout)
+	}
+
+	// Sends the given number of key frame requests and returns how many of them reached the publisher.
+	request := func(metadata TrackMetadata, count int) int {
+		stopped := make(chan struct{})
+		close(stopped)
+		pub, events := publisher.NewPublisher(
+			&publisher.RemoteTrack{Track: &webrtc.TrackRemote{}},
+			stopped,
+			time.Hour,
+			publisher.Impairment{},
+			logger,
+		)
+
+		requested := 0
+		requestKeyFrame := func(*webrtc.TrackRemote) error {
+			requested++
+			return nil
+		}
+
+		published := &PublishedTrack[testSubscriber]{
+			logger:    logger,
+			telemetry: tel,
+			config:    config,
+			metadata:  metadata,
+			video: &videoTrack{publishers: map[webrtc_ext.SimulcastLayer]*trackPublisher{
+				low: {pub, events, requestKeyFrame, low, logger, tel, time.Now(), time.Time{}, time.Time{}},
+			}},
+		}
+
+		sub := &trackSubscription[testSubscriber]{nopSubscription{}, low, "subscriber", 0, 0, webrtc_ext.SimulcastLayerNone}
+		for i := 0; i < count; i++ {
+			if err := published.processKeyFrameRequest(sub); err != nil {
+				t.Fatalf("Failed to process the key frame request: %v", err)
+			}
+			time.Sleep(time.Millisecond)
+		}
+
+		return requested
+	}
+
+	if requested := request(TrackMetadata{}, 3); requested != 1 {
+		t.Errorf("Expected the camera key frame requests to be limited to 1, got %d", requested)
+	}
+	if requested := request(TrackMetadata{Screenshare: true}, 3); requested != 3 {
+		t.Errorf("Expected all 3 screen share key frame requests to be forwarded, got %d", requested)
+	}
+}
+
+func TestMaxLayers(t *testing.T) {
+	logger := logrus.NewEntry(logrus.New())
+	tel := telemetry.NewTelemetry(context.Background(), "PublishedTrack")
+
+	track := &idleTrack{closed: make(chan struct{})}
+	defer close(track.closed)
+
+	published := &PublishedTrack[testSubscriber]{
+		logger:        logger,
+		telemetry:     tel,
+		info:          webrtc_ext.TrackInfo{TrackID: "track", Kind: webrtc.RTPCodecTypeVideo},
+		config:        Config{MaxLayers: 2},
+		subscriptions: make(map[testSubscriber]*trackSubscription[testSubscriber]),
+		video:         &videoTrack{publishers: make(map[webrtc_ext.SimulcastLayer]*trackPublisher)},
+		done:          make(chan struct{}),
+	}
+
+	// The publisher sends 4 layers (the one with an unknown RID has no simulcast layer), the highest first.
+	layers := []webrtc_ext.SimulcastLayer{
+		webrtc_ext.SimulcastLayerHigh,
+		webrtc_ext.SimulcastLayerNone,
+		webrtc_ext.SimulcastLayerMedium,
+		webrtc_ext.SimulcastLayerLow,
+	}
+
+	for _, layer := range layers {
+		if published.acceptsLayer(layer) {
+			pub, events := publisher.NewPublisher(track, make(chan struct{}), time.Hour, publisher.Impairment{}, logger)
+			published.video.publishers[layer] = newTestTrackPublisher(pub, events, nil, layer, logger, tel)
+		}
+	}
+
+	if len(published.video.publishers) != 2 {
+		t.Fatalf("Expected 2 layers to be tracked, got %d", len(published.video.publishers))
+	}
+
+	if published.video.publishers[webrtc_ext.SimulcastLayerHigh] != nil {
+		t.Errorf("Expected the highest layer to be ignored")
+	}
+
+	// Without a limit, all layers are accepted.
+	published.config.MaxLayers = 0
+	if !published.acceptsLayer(webrtc_ext.SimulcastLayerHigh) {
+		t.Errorf("Expected the layers to be accepted without a limit")
+	}
+}
+
+// An audio track that the publisher keeps sending the packets to.
+type speakingTrack struct {
+	packets chan *rtp.Packet
+}
+
+func (t *speakingTrack) ReadRTP() (*rtp.Packet, interceptor.Attributes, error) {
+	packet, ok := <-t.packets
+	if !ok {
+		return nil, nil, io.EOF
+	}
+
+	return packet, nil, nil
+}
+
+// The output track of the audio that counts the forwarded packets.
+type countingOutput struct {
+	forwarded int
+}
+
+func (o *countingOutput) WriteRTP(packet *rtp.Packet) error {
+	o.forwarded++
+	return nil
+}
+
+// The output track of the audio that is shared by several subscribers, some of which are gone. Like the
+// Pion's track, it sends each packet to all subscribers and fails if it could not send it to any of them.
+type sharedOutput struct {
+	forwarded []int
+	gone      map[int]bool
+}
+
+func (o *sharedOutput) WriteRTP(packet *rtp.Packet) error {
+	errs := []error{}
+	for subscriber := range o.forwarded {
+		if o.gone[subscriber] {
+			errs = append(errs, io.ErrClosedPipe)
+			continue
+		}
+		o.forwarded[subscriber]++
+	}
+
+	return errors.Join(errs...)
+}
+
+func TestAudioIsForwardedDespiteGoneSubscriber(t *testing.T) {
+	track := &speakingTrack{packets: make(chan *rtp.Packet)}
+	output := &sharedOutput{forwarded: make([]int, 3), gone: map[int]bool{1: true}}
+
+	stopped := make(chan error)
+	go func() {
+		stopped <- forward(track, output, &audioTrack{}, publisher.Impairment{}, make(chan struct{}), logrus.NewEntry(logrus.New()))
+	}()
+
+	for i := 0; i < 10; i++ {
+		select {
+		case track.packets <- &rtp.Packet{Header: rtp.Header{SequenceNumber: uint16(i)}}:
+		case err := <-stopped:
+			t.Fatalf("Expected the forwarding to go on, it stopped with %v", err)
+		}
+	}
+	close(track.packets)
+
+	// Only the end of the published track stops the forwarding.
+	if err := <-stopped; !errors.Is(err, io.EOF) {
+		t.Fatalf("Expected the forwarding to stop with the track, got %v", err)
+	}
+
+	if output.forwarded[0] != 10 || output.forwarded[2] != 10 {
+		t.Errorf("Expected the other subscribers to get all 10 packets, got %v", output.forwarded)
+	}
+}
+
+func TestImpairmentAppliesToAudio(t *testing.T) {
+	track := &speakingTrack{packets: make(chan *rtp.Packet)}
+	output := &countingOutput{}
+
+	stopped := make(chan error)
+	go func() {
+		impairment := publisher.Impairment{DropRate: 1}
+		stopped <- forward(track, output, &audioTrack{}, impairment, make(chan struct{}), logrus.NewEntry(logrus.New()))
+	}()
+
+	for i := 0; i < 10; i++ {
+		track.packets <- &rtp.Packet{Header: rtp.Header{SequenceNumber: uint16(i)}}
+	}
+	close(track.packets)
+
+	if err := <-stopped; !errors.Is(err, io.EOF) {
+		t.Fatalf("Expected the forwarding to stop with the track, got %v", err)
+	}
+
+	if output.forwarded != 0 {
+		t.Errorf("Expected all audio packets to be dropped, got %d forwarded", output.forwarded)
+	}
+}
+
+func TestForceMutedAudioIsNotForwarded(t *testing.T) {
+	published := &PublishedTrack[testSubscriber]{
+		logger:    logrus.NewEntry(logrus.New()),
+		telemetry: telemetry.NewTelemetry(context.Background(), "PublishedTrack"),
+		info:      webrtc_ext.TrackInfo{TrackID: "mic", Kind: webrtc.RTPCodecTypeAudio},
+		audio:     &audioTrack{},
+	}
+
+	// Lets the publisher send a few packets and returns how many of them have been forwarded.
+	speak := func() int {
+		track := &speakingTrack{packets: make(chan *rtp.Packet)}
+		output := &countingOutput{}
+
+		stopped := make(chan error)
+		go func() {
+			stopped <- forward(track, output, published.audio, publisher.Impairment{}, make(chan struct{}), published.logger)
+		}()
+
+		for i := 0; i < 10; i++ {
+			track.packets <- &rtp.Packet{Header: rtp.Header{SequenceNumber: uint16(i)}}
+		}
+		close(track.packets)
+
+		if err := <-stopped; !errors.Is(err, io.EOF) {
+			t.Fatalf("Expected the forwarding to stop with the track, got %v", err)
+		}
+
+		return output.forwarded
+	}
+
+	if forwarded := speak(); forwarded != 10 {
+		t.Fatalf("Expected all 10 packets to be forwarded, got %d", forwarded)
+	}
+
+	if !published.SetForceMuted(true) {
+		t.Fatal("Expected the audio track to be muted")
+	}
+	if forwarded := speak(); forwarded != 0 {
+		t.Errorf("Expected no packets to be forwarded while muted, got %d", forwarded)
+	}
+
+	published.SetForceMuted(false)
+	if forwarded := speak(); forwarded != 10 {
+		t.Errorf("Expected all 10 packets to be forwarded once unmuted, got %d", forwarded)
+	}
+
+	video := &PublishedTrack[testSubscriber]{info: webrtc_ext.TrackInfo{TrackID: "camera", Kind: webrtc.RTPCodecTypeVideo}}
+	if video.SetForceMuted(true) {
+		t.Error("Expected the video track not to be muted")
+	}
+}
+
+func TestSuppressedAudioIsNotForwarded(t *testing.T) {
+	published := &PublishedTrack[testSubscriber]{
+		logger:    logrus.NewEntry(logrus.New()),
+		telemetry: telemetry.NewTelemetry(context.Background(), "PublishedTrack"),
+		info:      webrtc_ext.TrackInfo{TrackID: "mic", Kind: webrtc.RTPCodecTypeAudio},
+		audio:     &audioTrack{},
+	}
+	published.SetAudioLevelExtension(1)
+
+	// Lets the publisher send a few packets at a given level and returns how many of them have been forwarded.
+	speak := func(level uint8) int {
+		track := &speakingTrack{packets: make(chan *rtp.Packet)}
+		output := &countingOutput{}
+
+		stopped := make(chan error)
+		go func() {
+			stopped <- forward(track, output, published.audio, publisher.Impairment{}, make(chan struct{}), published.logger)
+		}()
+
+		payload, err := rtp.AudioLevelExtension{Level: level}.Marshal()
+		if err != nil {
+			t.Fatalf("Failed to marshal the audio level: %v", err)
+		}
+
+		for i := 0; i < 10; i++ {
+			packet := &rtp.Packet{Header: rtp.Header{SequenceNumber: uint16(i)}}
+			if err := packet.SetExtension(1, payload); err != nil {
+				t.Fatalf("Failed to set the audio level: %v", err)
+			}
+			track.packets <- packet
+		}
+		close(track.packets)
+
+		if err := <-stopped; !errors.Is(err, io.EOF) {
+			t.Fatalf("Expected the forwarding to stop with the track, got %v", err)
+		}
+
+		return output.forwarded
+	}
+
+	// Silence is forwarded, but does not count as speaking.
+	if forwarded := speak(127); forwarded != 10 || !published.LastSpoke().IsZero() {
+		t.Fatalf("Expected all 10 packets to be forwarded without speaking, got %d (%v)", forwarded, published.LastSpoke())
+	}
+
+	if !published.SetSuppressed(true) {
+		t.Fatal("Expected the audio track to be suppressed")
+	}
+	if forwarded := speak(20); forwarded != 0 {
+		t.Errorf("Expected no packets to be forwarded while suppressed, got %d", forwarded)
+	}
+
+	// The level is still observed, so that the publisher could become one of the speakers again.
+	if published.LastSpoke().IsZero() {
+		t.Error("Expected the suppressed publisher to be speaking")
+	}
+
+	published.SetSuppressed(false)
+	if forwarded := speak(20); forwarded != 10 {
+		t.Errorf("Expected all 10 packets to be forwarded once no longer suppressed, got %d", forwarded)
+	}
+}
+
+// A subscription that drops all packets and remembers its maximum frame rate.
+type frameRateSubscription struct {
+	nopSubscription
+	maxFrameRate int
+}
+
+func (s *frameRateSubscription) SetMaxFrameRate(frameRate int) {
+	s.maxFrameRate = frameRate
+}
+
+func TestMaxFrameRateKeepsLayer(t *testing.T) {
+	low, high := webrtc_ext.SimulcastLayerLow, webrtc_ext.SimulcastLayerHigh
+	logger := logrus.NewEntry(logrus.New())
+	tel := telemetry.NewTelemetry(context.Background(), "PublishedTrack")
+
+	track := &idleTrack{closed: make(chan struct{})}
+	defer close(track.closed)
+
+	newPublisher := func(layer webrtc_ext.SimulcastLayer) *trackPublisher {
+		pub, events := publisher.NewPublisher(track, make(chan struct{}), time.Hour, publisher.Impairment{}, logger)
+		return newTestTrackPublisher(pub, events, nil, layer, logger, tel)
+	}
+
+	published := &PublishedTrack[testSubscriber]{
+		logger:        logger,
+		telemetry:     tel,
+		info:          webrtc_ext.TrackInfo{TrackID: "track", Kind: webrtc.RTPCodecTypeVideo},
+		metadata:      TrackMetadata{MaxWidth: 1280, MaxHeight: 720},
+		subscriptions: make(map[testSubscriber]*trackSubscription[testSubscriber]),
+		video: &videoTrack{publishers: map[webrtc_ext.SimulcastLayer]*trackPublisher{
+			low:  newPublisher(low),
+			high: newPublisher(high),
+		}},
+		done: make(chan struct{}),
+	}
+
+	limited := &frameRateSubscription{}
+	sub := &trackSubscription[testSubscriber]{limited, high, "subscriber", 1280, 720, webrtc_ext.SimulcastLayerNone}
+	published.subscriptions[sub.subscriberID] = sub
+	published.video.publishers[high].addSubscription(sub)
+
+	// The frame rate is limited by dropping the temporal layers, not by switching to a lower resolution.
+	published.SetMetadata(TrackMetadata{MaxWidth: 1280, MaxHeight: 720, MaxFrameRate: 15})
+	if limited.maxFrameRate != 15 {
+		t.Errorf("Expected the subscription to be limited to 15 fps, got %d", limited.maxFrameRate)
+	}
+	if sub.currentLayer != high || published.subscriptionLayer(sub) != high {
+		t.Errorf("Expected the subscription to stay on the high layer, got %s", sub.currentLayer)
+	}
+
+	// The limit is lifted along with the metadata.
+	published.SetMetadata(TrackMetadata{MaxWidth: 1280, MaxHeight: 720})
+	if limited.maxFrameRate != 0 {
+		t.Errorf("Expected the limit to be lifted, got %d", limited.maxFrameRate)
+	}
+}
+
+// A published track that sends a single packet and then blocks until it's closed.
+type singlePacketTrack struct {
+	packet *rtp.Packet
+	closed chan struct{}
+}
+
+func (t *singlePacketTrack) ReadPacket() (*rtp.Packet, error) {
+	if packet := t.packet; packet != nil {
+		t.packet = nil
+		return packet, nil
+	}
+
+	<-t.closed
+	return nil, io.EOF
+}
+
+func TestLayerResolutions(t *testing.T) {
+	low, mid, high := webrtc_ext.SimulcastLayerLow, webrtc_ext.SimulcastLayerMedium, webrtc_ext.SimulcastLayerHigh
+	logger := logrus.NewEntry(logrus.New())
+	tel := telemetry.NewTelemetry(context.Background(), "PublishedTrack")
+
+	closed := make(chan struct{})
+	defer close(closed)
+
+	newPublisher := func(layer webrtc_ext.SimulcastLayer, packet *rtp.Packet) *trackPublisher {
+		track := &singlePacketTrack{packet: packet, closed: closed}
+		pub, events := publisher.NewPublisher(track, make(chan struct{}), time.Hour, publisher.Impairment{}, logger)
+		pub.DetectFrameSize(webrtc.MimeTypeVP8)
+		return newTestTrackPublisher(pub, events, nil, layer, logger, tel)
+	}
+
+	// The high layer sends a key frame that is smaller than advertised (e.g. the encoder adapts to the
+	// bandwidth), the lower layers have not sent any yet.
+	keyFrame := &rtp.Packet{Payload: []byte{0x10, 0x50, 0x2a, 0x00, 0x9d, 0x01, 0x2a, 0xc0, 0x03, 0x1c, 0x02}}
+	published := &PublishedTrack[testSubscriber]{
+		logger:        logger,
+		telemetry:     tel,
+		info:          webrtc_ext.TrackInfo{TrackID: "track", Kind: webrtc.RTPCodecTypeVideo},
+		subscriptions: make(map[testSubscriber]*trackSubscription[testSubscriber]),
+		video: &videoTrack{publishers: map[webrtc_ext.SimulcastLayer]*trackPublisher{
+			low:  newPublisher(low, nil),
+			mid:  newPublisher(mid, nil),
+			high: newPublisher(high, keyFrame),
+		}},
+		metadata: TrackMetadata{MaxWidth: 1280, MaxHeight: 720},
+		done:     make(chan struct{}),
+	}
+
+	for deadline := time.Now().Add(5 * time.Second); ; time.Sleep(time.Millisecond) {
+		if width, _ := published.video.publishers[high].frameSize(); width != 0 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("Timed out waiting for the key frame")
+		}
+	}
+
+	// The observed resolution takes precedence, the others are derived from the advertised one.
+	expected := map[webrtc_ext.SimulcastLayer]Resolution{
+		low:  {Width: 320, Height: 180},
+		mid:  {Width: 640, Height: 360},
+		high: {Width: 960, Height: 540},
+	}
+	if resolutions := published.LayerResolutions(); !reflect.DeepEqual(resolutions, expected) {
+		t.Errorf("Expected the layer resolutions %v, got %v", expected, resolutions)
+	}
+
+	// The paused layers are not available, so their resolution does not matter.
+	published.SetPausedLayers([]webrtc_ext.SimulcastLayer{mid})
+	delete(expected, mid)
+	if resolutions := published.LayerResolutions(); !reflect.DeepEqual(resolutions, expected) {
+		t.Errorf("Expected the resolutions of the active layers %v, got %v", expected, resolutions)
+	}
+
+	// Without the advertised resolution, only the observed one is known.
+	published.SetMetadata(TrackMetadata{})
+	delete(expected, low)
+	if resolutions := published.LayerResolutions(); !reflect.DeepEqual(resolutions, expected) {
+		t.Errorf("Expected only the observed resolution %v, got %v", expected, resolutions)
+	}
+}
@@ -0,0 +1,37 @@
+package conference
+
+import (
+	"encoding/json"
+
+	"github.com/matrix-org/waterfall/pkg/conference/participant"
+	published "github.com/matrix-org/waterfall/pkg/conference/track"
+	"maunium.net/go/mautrix/event"
+)
+
+// Sent by the participant over the data channel once it stops publishing some of its tracks, so that the
+// subscribers get rid of them right away instead of waiting for the participant's next offer.
+var FocusCallUnpublish = event.Type{Type: "m.call.unpublish", Class: event.FocusEventType}
+
+// Content of the `m.call.unpublish` event.
+type UnpublishEventContent struct {
+	// The tracks that the participant does not publish anymore. The tracks of others are ignored.
+	TrackIDs []published.TrackID `json:"track_ids"`
+}
+
+func (c *Conference) processUnpublishMessage(p *participant.Participant, raw json.RawMessage) {
+	var content UnpublishEventContent
+	if err := json.Unmarshal(raw, &content); err != nil {
+		p.Logger.Errorf("Failed to unmarshal unpublish request: %v", err)
+		return
+	}
+
+	unpublished := make(map[published.TrackID]bool, len(content.TrackIDs))
+	for _, trackID := range content.TrackIDs {
+		unpublished[trackID] = true
+	}
+
+	// The participant still has to renegotiate to stop sending the tracks, its next offer won't publish them.
+	c.removePublishedTracks(p.ID, func(trackID published.TrackID) bool {
+		return unpublished[trackID]
+	})
+}
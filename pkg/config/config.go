@@ -5,9 +5,13 @@ import (
 	"fmt"
 	"os"
 
+	"github.com/matrix-org/waterfall/pkg/admin"
 	"github.com/matrix-org/waterfall/pkg/conference"
+	"github.com/matrix-org/waterfall/pkg/metrics"
+	"github.com/matrix-org/waterfall/pkg/profiling"
 	"github.com/matrix-org/waterfall/pkg/signaling"
 	"github.com/matrix-org/waterfall/pkg/telemetry"
+	"github.com/matrix-org/waterfall/pkg/webhook"
 	"github.com/matrix-org/waterfall/pkg/webrtc_ext"
 	"github.com/sirupsen/logrus"
 	"gopkg.in/yaml.v3"
@@ -25,6 +29,14 @@ type Config struct {
 	WebRTC webrtc_ext.Config `yaml:"webrtc"`
 	// Telemetry configuration.
 	Telemetry telemetry.Config `yaml:"telemetry"`
+	// Metrics configuration.
+	Metrics metrics.Config `yaml:"metrics"`
+	// Webhook configuration.
+	Webhook webhook.Config `yaml:"webhook"`
+	// Profiling configuration.
+	Profiling profiling.Config `yaml:"profiling"`
+	// Admin API configuration.
+	Admin admin.Config `yaml:"admin"`
 }
 
 // Tries to load a config from the `CONFIG` environment variable.
@@ -97,6 +109,15 @@ func validateConfig(config Config) error {
 	if config.Matrix.AccessToken == "" {
 		return fmt.Errorf("you must set matrix.accessToken")
 	}
+	if err := config.WebRTC.CandidateFilter.Validate(); err != nil {
+		return fmt.Errorf("invalid webrtc.candidateFilter: %w", err)
+	}
+	if err := config.Conference.AccessControl.Validate(); err != nil {
+		return fmt.Errorf("invalid conference.accessControl: %w", err)
+	}
+	if err := config.Conference.Impairment.Validate(); err != nil {
+		return fmt.Errorf("invalid conference.impairment: %w", err)
+	}
 	if config.Conference.HeartbeatConfig.Timeout == 0 {
 		return fmt.Errorf("you must set heartbeat.timeout")
 	}
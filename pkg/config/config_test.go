@@ -0,0 +1,112 @@
+package config_test
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/matrix-org/waterfall/pkg/config"
+	"github.com/sirupsen/logrus"
+)
+
+const configTemplate = `
+matrix:
+  homeserverUrl: "http://localhost:8008"
+  userId: "@sfu:localhost"
+  accessToken: "%s"
+conference:
+  heartbeat:
+    timeout: 30
+    interval: 30
+log: "%s"
+`
+
+func writeConfig(t *testing.T, path, accessToken, logLevel string) {
+	t.Helper()
+
+	content := []byte(fmt.Sprintf(configTemplate, accessToken, logLevel))
+	if err := os.WriteFile(path, content, 0o600); err != nil {
+		t.Fatalf("Failed to write config: %v", err)
+	}
+}
+
+func TestReloadLogLevel(t *testing.T) {
+	defer logrus.SetLevel(logrus.GetLevel())
+
+	// Make sure that the config is loaded from the file.
+	t.Setenv("CONFIG", "")
+
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	writeConfig(t, path, "token", "info")
+
+	current, err := config.LoadConfigFromPath(path)
+	if err != nil {
+		t.Fatalf("Failed to load config: %v", err)
+	}
+
+	if err := current.ApplyLogLevel(); err != nil {
+		t.Fatalf("Failed to apply log level: %v", err)
+	}
+
+	if level := logrus.GetLevel(); level != logrus.InfoLevel {
+		t.Fatalf("Expected log level info, got %s", level)
+	}
+
+	// Change the log level along with a setting that can't be reloaded.
+	writeConfig(t, path, "another token", "debug")
+
+	reloaded, err := current.Reload(path)
+	if err != nil {
+		t.Fatalf("Failed to reload config: %v", err)
+	}
+
+	if level := logrus.GetLevel(); level != logrus.DebugLevel {
+		t.Errorf("Expected log level debug after reload, got %s", level)
+	}
+
+	if reloaded.Matrix.AccessToken != "token" {
+		t.Errorf("Matrix settings must not be reloaded, got access token %q", reloaded.Matrix.AccessToken)
+	}
+}
+
+func TestInvalidCandidateFilter(t *testing.T) {
+	content := fmt.Sprintf(configTemplate, "token", "info") + `
+webrtc:
+  candidateFilter:
+    excludeTypes: ["local"]
+`
+
+	if _, err := config.LoadConfigFromString(content); err == nil {
+		t.Error("Expected the config with an invalid candidate filter to be rejected")
+	}
+}
+
+func TestInvalidAccessControl(t *testing.T) {
+	withPattern := func(pattern string) string {
+		accessControl := fmt.Sprintf("conference:\n  accessControl:\n    allow: [%q]\n", pattern)
+		return strings.Replace(fmt.Sprintf(configTemplate, "token", "info"), "conference:\n", accessControl, 1)
+	}
+
+	if _, err := config.LoadConfigFromString(withPattern("@*:example.org")); err != nil {
+		t.Fatalf("Expected the config with a valid access control pattern to be loaded: %v", err)
+	}
+
+	if _, err := config.LoadConfigFromString(withPattern("@[a-:example.org")); err == nil {
+		t.Error("Expected the config with an invalid access control pattern to be rejected")
+	}
+}
+
+func TestInvalidImpairment(t *testing.T) {
+	content := strings.Replace(
+		fmt.Sprintf(configTemplate, "token", "info"),
+		"conference:\n",
+		"conference:\n  impairment:\n    dropRate: 1.5\n",
+		1,
+	)
+
+	if _, err := config.LoadConfigFromString(content); err == nil {
+		t.Error("Expected the config with a drop rate above 1 to be rejected")
+	}
+}
@@ -0,0 +1,74 @@
+package config
+
+import (
+	"fmt"
+	"reflect"
+
+	"github.com/sirupsen/logrus"
+)
+
+// Sets the global log level according to the `log` field of the config.
+func (c *Config) ApplyLogLevel() error {
+	switch c.LogLevel {
+	case "trace":
+		logrus.SetLevel(logrus.TraceLevel)
+	case "debug":
+		logrus.SetLevel(logrus.DebugLevel)
+	case "info", "": // default to info level if unset
+		logrus.SetLevel(logrus.InfoLevel)
+	case "warn":
+		logrus.SetLevel(logrus.WarnLevel)
+	case "error":
+		logrus.SetLevel(logrus.ErrorLevel)
+	case "fatal":
+		logrus.SetLevel(logrus.FatalLevel)
+	case "panic":
+		logrus.SetLevel(logrus.PanicLevel)
+	default:
+		return fmt.Errorf("unrecognised log level: %s", c.LogLevel)
+	}
+
+	return nil
+}
+
+// Loads the config again (from the same source as `LoadConfig`) and returns a copy of the current
+// config with the reloadable fields updated. Only a subset of fields can be changed at runtime:
+//
+//   - `log`: applied immediately.
+//   - `conference`: applied to new conferences. Running conferences re-read the profile they were
+//     started with: the access control, the participant and bandwidth limits, the idle timeout, the
+//     negotiation interval, the audio mixing threshold, the presenters, the SDP logging and all
+//     policies take effect right away. The heartbeat and the peer settings (trickle ICE, publisher
+//     bitrate, data channel limits, renegotiation and codec preferences) apply to the participants
+//     that join after the reload, the track settings (stall timeouts, key frame intervals, simulcast
+//     and subscription settings) to the tracks that are published after the reload. The telemetry
+//     sampling and the event log size never change for a running conference.
+//
+// All other fields (Matrix credentials, WebRTC, telemetry, metrics, webhook, profiling and admin settings)
+// are only read on startup, the changes to them are ignored until the SFU is restarted.
+func (c *Config) Reload(path string) (*Config, error) {
+	loaded, err := LoadConfig(path)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := loaded.ApplyLogLevel(); err != nil {
+		return nil, err
+	}
+
+	if !reflect.DeepEqual(loaded.Matrix, c.Matrix) ||
+		!reflect.DeepEqual(loaded.WebRTC, c.WebRTC) ||
+		!reflect.DeepEqual(loaded.Telemetry, c.Telemetry) ||
+		!reflect.DeepEqual(loaded.Metrics, c.Metrics) ||
+		!reflect.DeepEqual(loaded.Webhook, c.Webhook) ||
+		!reflect.DeepEqual(loaded.Profiling, c.Profiling) ||
+		!reflect.DeepEqual(loaded.Admin, c.Admin) {
+		logrus.Warn("only log and conference settings can be reloaded, restart the SFU to apply the other ones")
+	}
+
+	reloaded := *c
+	reloaded.LogLevel = loaded.LogLevel
+	reloaded.Conference = loaded.Conference
+
+	return &reloaded, nil
+}
@@ -0,0 +1,7 @@
+package metrics
+
+type Config struct {
+	// The address (e.g. `localhost:9090`) to serve the metrics on. The metrics are
+	// served in the `expvar` format at `/debug/vars`. Disabled if empty.
+	Address string `yaml:"address"`
+}
@@ -0,0 +1,74 @@
+package metrics
+
+import (
+	"expvar"
+	"sort"
+	"sync"
+)
+
+// How many of the latest samples are kept to calculate the quantiles.
+const histogramWindow = 1024
+
+// A histogram that calculates the quantiles over a sliding window of the latest samples.
+type Histogram struct {
+	mutex   sync.Mutex
+	samples []float64
+	next    int
+	count   uint64
+}
+
+// Creates a new histogram and publishes it under a given name (pass an empty
+// name to create a histogram that is not published).
+func NewHistogram(name string) *Histogram {
+	histogram := &Histogram{samples: make([]float64, 0, histogramWindow)}
+
+	if name != "" {
+		expvar.Publish(name, expvar.Func(func() any {
+			return map[string]any{
+				"count": histogram.Count(),
+				"p50":   histogram.Quantile(0.5),
+				"p95":   histogram.Quantile(0.95),
+			}
+		}))
+	}
+
+	return histogram
+}
+
+// Records a new sample.
+func (h *Histogram) Observe(value float64) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	if len(h.samples) < histogramWindow {
+		h.samples = append(h.samples, value)
+	} else {
+		h.samples[h.next] = value
+	}
+
+	h.next = (h.next + 1) % histogramWindow
+	h.count++
+}
+
+// Returns the total amount of recorded samples.
+func (h *Histogram) Count() uint64 {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	return h.count
+}
+
+// Returns the value below which a given fraction (0..1) of the latest samples fall.
+// Returns 0 if there are no samples.
+func (h *Histogram) Quantile(q float64) float64 {
+	h.mutex.Lock()
+	sorted := append([]float64(nil), h.samples...)
+	h.mutex.Unlock()
+
+	if len(sorted) == 0 {
+		return 0
+	}
+
+	sort.Float64s(sorted)
+	return sorted[int(q*float64(len(sorted)-1))]
+}
@@ -0,0 +1,49 @@
+package metrics
+
+import (
+	"expvar"
+	"net/http"
+
+	"github.com/sirupsen/logrus"
+)
+
+// Time between a key frame request sent by the subscriber and the reception of the
+// key frame by the subscriber (in milliseconds).
+var KeyFrameLatency = NewHistogram("keyframe_latency_ms")
+
+// Amount of to-device messages that were successfully sent to the homeserver (by event type).
+var ToDeviceSent = expvar.NewMap("matrix_to_device_sent")
+
+// Amount of to-device messages that the homeserver failed to accept (by event type).
+var ToDeviceFailed = expvar.NewMap("matrix_to_device_failed")
+
+// Time it takes to send a to-device message to the homeserver, including the failed attempts (in milliseconds).
+var ToDeviceLatency = NewHistogram("matrix_to_device_latency_ms")
+
+// Amount of messages for the participants that are not in the conference (by the reason, i.e. whether
+// the participant has just been removed or is not known at all).
+var ParticipantNotFound = expvar.NewMap("participant_not_found")
+
+// Amount of subscriptions that were refused since the subscriber can't receive the codec of the track (by codec),
+// i.e. the ones that would need transcoding.
+var IncompatibleCodec = expvar.NewMap("incompatible_codec_subscriptions")
+
+// Amount of events that were not sent since the data channel of the participant was congested (by event type).
+var DataChannelDropped = expvar.NewMap("data_channel_dropped")
+
+// Starts serving the metrics if the address is configured.
+func Serve(config Config) {
+	if config.Address == "" {
+		return
+	}
+
+	mux := http.NewServeMux()
+	mux.Handle("/debug/vars", expvar.Handler())
+
+	go func() {
+		logrus.WithField("address", config.Address).Info("serving metrics")
+		if err := http.ListenAndServe(config.Address, mux); err != nil { //nolint:gosec
+			logrus.WithError(err).Error("metrics server stopped")
+		}
+	}()
+}
@@ -0,0 +1,56 @@
+package peer
+
+import (
+	"time"
+
+	"github.com/pion/rtcp"
+	"github.com/pion/webrtc/v3"
+)
+
+// How often we inform the remote peer about the maximum bitrate it's allowed to send.
+const bitrateLimitInterval = 2 * time.Second
+
+// Periodically sends REMB to the remote peer capping the bitrate of the video it sends to us.
+// The function returns once the `stop` channel is closed.
+func (p *Peer[ID]) limitIncomingBitrate(bitrate uint64, stop <-chan struct{}) {
+	ticker := time.NewTicker(bitrateLimitInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+			ssrcs := p.incomingVideoSSRCs()
+			if len(ssrcs) == 0 {
+				continue
+			}
+
+			if err := p.peerConnection.WriteRTCP([]rtcp.Packet{newBitrateLimit(bitrate, ssrcs)}); err != nil {
+				p.logger.WithError(err).Debug("failed to send bitrate limit")
+			}
+		}
+	}
+}
+
+// Returns the SSRCs of all video tracks (including all simulcast layers) that the remote peer sends to us.
+func (p *Peer[ID]) incomingVideoSSRCs() []uint32 {
+	ssrcs := []uint32{}
+	for _, receiver := range p.peerConnection.GetReceivers() {
+		for _, track := range receiver.Tracks() {
+			if track.Kind() == webrtc.RTPCodecTypeVideo && track.SSRC() != 0 {
+				ssrcs = append(ssrcs, uint32(track.SSRC()))
+			}
+		}
+	}
+
+	return ssrcs
+}
+
+// Creates a REMB packet that caps the bitrate of the given media streams.
+func newBitrateLimit(bitrate uint64, ssrcs []uint32) *rtcp.ReceiverEstimatedMaximumBitrate {
+	return &rtcp.ReceiverEstimatedMaximumBitrate{
+		Bitrate: float32(bitrate),
+		SSRCs:   ssrcs,
+	}
+}
@@ -0,0 +1,30 @@
+package peer //nolint:testpackage
+
+import (
+	"testing"
+
+	"github.com/pion/rtcp"
+)
+
+func TestBitrateLimitPacket(t *testing.T) {
+	ssrcs := []uint32{1111, 2222, 3333}
+
+	raw, err := newBitrateLimit(2_000_000, ssrcs).Marshal()
+	if err != nil {
+		t.Fatalf("Failed to marshal REMB: %v", err)
+	}
+
+	var remb rtcp.ReceiverEstimatedMaximumBitrate
+	if err := remb.Unmarshal(raw); err != nil {
+		t.Fatalf("Failed to unmarshal REMB: %v", err)
+	}
+
+	// REMB encodes the bitrate with a mantissa and exponent, so small rounding errors are expected.
+	if remb.Bitrate < 1_999_000 || remb.Bitrate > 2_001_000 {
+		t.Errorf("Expected bitrate of 2 Mbps, got %f", remb.Bitrate)
+	}
+
+	if len(remb.SSRCs) != len(ssrcs) {
+		t.Fatalf("Expected %d SSRCs, got %d", len(ssrcs), len(remb.SSRCs))
+	}
+}
@@ -0,0 +1,42 @@
+package peer
+
+import "time"
+
+// Configuration of the peer.
+type Config struct {
+	// If set, the ICE candidates are not trickled. Instead, we wait until all local candidates are
+	// gathered and send them as part of the SDP answer (or offer). Some restrictive clients connect
+	// faster and more reliably this way.
+	DisableTrickleICE bool
+	// How long to wait for the ICE gathering to complete when trickle ICE is disabled. If the gathering
+	// does not complete in time, the SDP contains the candidates that have been gathered so far.
+	ICEGatheringTimeout time.Duration
+	// Maximum bitrate (in bits per second) that the remote peer is allowed to send to us. It's enforced
+	// by periodically sending REMB to the remote peer. 0 means unlimited.
+	MaxIncomingBitrate uint64
+	// The largest message (in bytes) that may be sent over the data channels. Pion does not expose the
+	// size negotiated with the remote peer (`a=max-message-size`) and assumes 64 KiB for every peer, so
+	// that's the default. Larger messages must be split by the caller.
+	MaxDataChannelMessageSize int
+	// How many bytes may wait in the send buffer of a data channel (e.g. of a stalled remote peer) before the new
+	// messages are refused with `ErrDataChannelCongested` (unlimited if not set). The low-priority messages are
+	// refused once half of it is used, so that there is still room for the important ones.
+	MaxDataChannelBufferedAmount uint64
+	// If set, the changes that require a renegotiation (e.g. the subscriptions that are made while the
+	// initial offer/answer is still in progress) don't trigger it until the peer connection is connected
+	// for the first time. A single renegotiation covering all of them takes place afterwards.
+	DeferRenegotiationUntilConnected bool
+	// If set, the directions of the transceivers are negotiated explicitly instead of relying on the defaults
+	// of Pion: the media that the remote peer sends is answered as `recvonly` and the forwarded tracks get their
+	// own `sendonly` transceivers, so that the remote peer never expects us to send on its publish transceivers.
+	ExplicitTransceiverDirections bool
+	// MIME types of the codecs (e.g. `video/VP9`) that the remote peer is asked to publish in, the most
+	// preferred first. The codecs that are not listed may still be used, but are answered after these.
+	CodecPreferences []string
+}
+
+// The default ICE gathering timeout that is used if none is configured.
+const defaultICEGatheringTimeout = 5 * time.Second
+
+// The default maximum size of the data channel messages, matches the assumption of Pion.
+const defaultMaxDataChannelMessageSize = 65536
@@ -9,7 +9,29 @@ import (
 // type of the message on runtime. The underlying types do not necessary need to be structures.
 type MessageContent = interface{}
 
-type JoinedTheCall struct{}
+type JoinedTheCall struct {
+	// The ICE candidates that the peer connection has selected (`nil` if they're not known).
+	CandidatePair *CandidatePair
+}
+
+// A local or remote ICE candidate of the selected candidate pair.
+type Candidate struct {
+	// Either `host`, `srflx`, `prflx` or `relay`.
+	Type    string `json:"type"`
+	Address string `json:"address"`
+}
+
+// The ICE candidates that the peer connection uses to exchange the media.
+type CandidatePair struct {
+	Local  Candidate `json:"local"`
+	Remote Candidate `json:"remote"`
+}
+
+// Checks if the media goes through a TURN server, i.e. with a higher latency and cost.
+func (p CandidatePair) Relayed() bool {
+	relay := webrtc.ICECandidateTypeRelay.String()
+	return p.Local.Type == relay || p.Remote.Type == relay
+}
 
 type LeftTheCall struct {
 	Reason event.CallHangupReason
@@ -18,6 +40,8 @@ type LeftTheCall struct {
 type NewTrackPublished struct {
 	// Remote track that has been published.
 	RemoteTrack *webrtc.TrackRemote
+	// The ID of the audio level header extension of the track (0 if not negotiated).
+	AudioLevelExtension uint8
 }
 
 type NewICECandidate struct {
@@ -30,8 +54,31 @@ type RenegotiationRequired struct {
 	Offer *webrtc.SessionDescription
 }
 
+type RenegotiationFailed struct {
+	// The tracks that have been added since the last offer and that the remote peer won't get.
+	TrackIDs []string
+	Err      error
+}
+
 type DataChannelMessage struct {
+	// Label of the data channel that the message has been received on.
+	Label   string
 	Message string
 }
 
-type DataChannelAvailable struct{}
+type DataChannelAvailable struct {
+	// Label of the data channel that became available.
+	Label string
+	// Whether it's the default data channel (the first one opened by the remote peer or the one that took
+	// over once the default one got closed).
+	Default bool
+}
+
+type DataChannelClosed struct {
+	// Label of the data channel that has been closed.
+	Label string
+	// Whether it was the default data channel.
+	Default bool
+	// Whether another data channel has become the default one instead, it's announced as available.
+	Replaced bool
+}
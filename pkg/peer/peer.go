@@ -3,6 +3,11 @@ package peer
 import (
 	"errors"
 	"fmt"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/matrix-org/waterfall/pkg/channel"
 	"github.com/matrix-org/waterfall/pkg/peer/state"
@@ -16,11 +21,14 @@ var (
 	ErrCantCreatePeerConnection   = errors.New("can't create peer connection")
 	ErrCantSetRemoteDescription   = errors.New("can't set remote description")
 	ErrCantCreateAnswer           = errors.New("can't create answer")
+	ErrOfferIgnored               = errors.New("offer ignored due to glare")
 	ErrCantSetLocalDescription    = errors.New("can't set local description")
 	ErrCantCreateLocalDescription = errors.New("can't create local description")
 	ErrDataChannelNotAvailable    = errors.New("data channel is not available")
 	ErrDataChannelNotReady        = errors.New("data channel is not ready")
+	ErrDataChannelCongested       = errors.New("data channel is congested")
 	ErrCantSubscribeToTrack       = errors.New("can't subscribe to track")
+	ErrIncompatibleCodec          = errors.New("codec is not supported by the remote peer")
 )
 
 // A wrapped representation of the peer connection (single peer in the call).
@@ -32,6 +40,15 @@ type Peer[ID comparable] struct {
 	peerConnection *webrtc.PeerConnection
 	sink           *channel.SinkWithSender[ID, MessageContent]
 	state          *state.PeerState
+	config         Config
+	// Filter of the local candidates that are advertised to the remote peer (`nil` if none).
+	candidateFilter *webrtc_ext.CandidateFilter
+	// The remote candidates that have been added since the last ICE restart (identified by the
+	// username fragment of the remote peer), so that the duplicates could be skipped.
+	remoteCandidates map[string]struct{}
+	remoteUfrag      string
+	// Closed once the peer is terminated.
+	done chan struct{}
 }
 
 // Instantiates a new peer with a given SDP offer and returns a peer and the SDP answer if everything is ok.
@@ -39,6 +56,7 @@ func NewPeer[ID comparable](
 	connectionFactory *webrtc_ext.PeerConnectionFactory,
 	sdpOffer string,
 	sink *channel.SinkWithSender[ID, MessageContent],
+	config Config,
 	logger *logrus.Entry,
 ) (*Peer[ID], *webrtc.SessionDescription, error) {
 	peerConnection, err := connectionFactory.CreatePeerConnection()
@@ -48,10 +66,14 @@ func NewPeer[ID comparable](
 	}
 
 	peer := &Peer[ID]{
-		logger:         logger,
-		peerConnection: peerConnection,
-		sink:           sink,
-		state:          state.NewPeerState(),
+		logger:           logger,
+		peerConnection:   peerConnection,
+		sink:             sink,
+		state:            state.NewPeerState(),
+		config:           config,
+		candidateFilter:  connectionFactory.CandidateFilter(),
+		remoteCandidates: make(map[string]struct{}),
+		done:             make(chan struct{}),
 	}
 
 	peerConnection.OnTrack(peer.onRtpTrackReceived)
@@ -63,15 +85,26 @@ func NewPeer[ID comparable](
 	peerConnection.OnConnectionStateChange(peer.onConnectionStateChanged)
 	peerConnection.OnSignalingStateChange(peer.onSignalingStateChanged)
 
-	if sdpAnswer, err := peer.ProcessSDPOffer(sdpOffer); err != nil {
+	sdpAnswer, err := peer.ProcessSDPOffer(sdpOffer)
+	if err != nil {
 		return nil, nil, err
-	} else {
-		return peer, sdpAnswer, nil
 	}
+
+	if config.MaxIncomingBitrate > 0 {
+		go peer.limitIncomingBitrate(config.MaxIncomingBitrate, peer.done)
+	}
+
+	return peer, sdpAnswer, nil
 }
 
 // Closes peer connection. From this moment on, no new messages will be sent from the peer.
 func (p *Peer[ID]) Terminate() {
+	select {
+	case <-p.done:
+	default:
+		close(p.done)
+	}
+
 	if err := p.peerConnection.Close(); err != nil {
 		p.logger.WithError(err).Error("failed to close peer connection")
 	}
@@ -88,19 +121,279 @@ func (p *Peer[ID]) RequestKeyFrame(track *webrtc.TrackRemote) error {
 	return p.peerConnection.WriteRTCP(rtcps)
 }
 
-// Implementation of the `SubscriptionController` interface.
+// Sends RTCP packets to the remote peer.
+func (p *Peer[ID]) WriteRTCP(packets []rtcp.Packet) error {
+	return p.peerConnection.WriteRTCP(packets)
+}
+
+// How many times we try to get a sender with a unique SSRC before giving up.
+const maxSSRCAllocationAttempts = 5
+
+var ErrSSRCCollision = errors.New("can't allocate a unique SSRC")
+
+// Implementation of the `SubscriptionController` interface. Guarantees that the SSRC of the
+// new sender does not collide with the SSRCs of other senders and receivers of the peer and
+// that the audio is only sent in a format that the remote peer has declared to support.
 func (p *Peer[ID]) AddTrack(track *webrtc.TrackLocalStaticRTP) (*webrtc.RTPSender, error) {
-	return p.peerConnection.AddTrack(track)
+	var codec *webrtc.RTPCodecParameters
+	if track.Kind() == webrtc.RTPCodecTypeAudio {
+		var err error
+		if codec, err = p.outgoingAudioCodec(track); err != nil {
+			return nil, err
+		}
+	}
+
+	// Pion picks a random SSRC for each new sender and does not let us choose it, so in the
+	// (unlikely) case of a collision we discard the sender and try again with a new one.
+	for attempt := 0; attempt < maxSSRCAllocationAttempts; attempt++ {
+		sender, err := p.addSender(track)
+		if err != nil {
+			return nil, err
+		}
+
+		ssrc := sender.GetParameters().Encodings[0].SSRC
+		if !p.isReceivingSSRC(ssrc) && p.state.AddSender(sender, ssrc) {
+			p.setCodecPreferences(sender, codec)
+			return sender, nil
+		}
+
+		p.logger.WithField("ssrc", ssrc).Warn("SSRC collision, allocating a new sender")
+		if err := p.peerConnection.RemoveTrack(sender); err != nil {
+			return nil, err
+		}
+	}
+
+	return nil, ErrSSRCCollision
+}
+
+// Adds a sender for a given track. Pion reuses the free transceivers of the same kind (e.g. the ones that
+// the remote peer publishes on) for the new tracks, unless the directions are negotiated explicitly, in
+// which case each track gets a new `sendonly` transceiver.
+func (p *Peer[ID]) addSender(track *webrtc.TrackLocalStaticRTP) (*webrtc.RTPSender, error) {
+	if !p.config.ExplicitTransceiverDirections {
+		return p.peerConnection.AddTrack(track)
+	}
+
+	transceiver, err := p.peerConnection.AddTransceiverFromTrack(
+		track,
+		webrtc.RTPTransceiverInit{Direction: webrtc.RTPTransceiverDirectionSendonly},
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	return transceiver.Sender(), nil
+}
+
+// Implementation of the `SubscriptionController` interface. The codecs are checked against the ones that the
+// remote peer has declared in its latest description. If it has not declared any codecs of a given kind so
+// far, the codec is assumed to be supported, since it will be negotiated along with the track.
+func (p *Peer[ID]) SupportsCodec(codec webrtc.RTPCodecCapability) bool {
+	remoteDescription := p.peerConnection.RemoteDescription()
+	if remoteDescription == nil {
+		return true
+	}
+
+	kind := webrtc.NewRTPCodecType(strings.Split(codec.MimeType, "/")[0])
+	remoteCodecs := webrtc_ext.Codecs(remoteDescription.SDP, kind)
+	if len(remoteCodecs) == 0 {
+		return true
+	}
+
+	for _, remoteCodec := range remoteCodecs {
+		if strings.EqualFold(remoteCodec.MimeType, codec.MimeType) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Picks the codec parameters of an outgoing audio track that match what the remote peer has declared. Since
+// the RTP is forwarded as is, the codec must be the one of the publisher, only its parameters (such as the
+// Opus channels) may be adjusted. Returns `nil` if the remote peer has not declared any audio codecs so far,
+// in which case the default parameters are negotiated.
+func (p *Peer[ID]) outgoingAudioCodec(track *webrtc.TrackLocalStaticRTP) (*webrtc.RTPCodecParameters, error) {
+	remoteDescription := p.peerConnection.RemoteDescription()
+	if remoteDescription == nil {
+		return nil, nil
+	}
+
+	remoteCodecs := webrtc_ext.Codecs(remoteDescription.SDP, webrtc.RTPCodecTypeAudio)
+	if len(remoteCodecs) == 0 {
+		return nil, nil
+	}
+
+	codec := track.Codec()
+	for _, remoteCodec := range remoteCodecs {
+		if !strings.EqualFold(remoteCodec.MimeType, codec.MimeType) {
+			continue
+		}
+
+		if !webrtc_ext.IsOpus(codec) {
+			return &remoteCodec, nil
+		}
+
+		// A stereo track is announced as such only to the remote peers that accept stereo.
+		sendsStereo := webrtc_ext.OpusSendsStereo(codec.SDPFmtpLine)
+		stereo := sendsStereo && webrtc_ext.OpusReceivesStereo(remoteCodec.SDPFmtpLine)
+		if sendsStereo && !stereo {
+			p.logger.WithField("track", track.ID()).Info("Remote peer does not accept stereo, negotiating mono Opus")
+		}
+
+		remoteCodec.SDPFmtpLine = webrtc_ext.WithOpusStereo(remoteCodec.SDPFmtpLine, stereo)
+		return &remoteCodec, nil
+	}
+
+	p.logger.WithField("track", track.ID()).Warnf("Remote peer does not support %s", codec.MimeType)
+	return nil, fmt.Errorf("%w: %s", ErrIncompatibleCodec, codec.MimeType)
+}
+
+// Makes the transceiver of a given sender offer a given codec only (unless it's `nil`).
+func (p *Peer[ID]) setCodecPreferences(sender *webrtc.RTPSender, codec *webrtc.RTPCodecParameters) {
+	if codec == nil {
+		return
+	}
+
+	for _, transceiver := range p.peerConnection.GetTransceivers() {
+		if transceiver.Sender() != sender {
+			continue
+		}
+
+		if err := transceiver.SetCodecPreferences([]webrtc.RTPCodecParameters{*codec}); err != nil {
+			p.logger.WithError(err).Warn("failed to set codec preferences")
+		}
+	}
+}
+
+// Orders the codecs of the media that the remote peer publishes by the configured preferences, so that the
+// remote peer favours the preferred ones when it's answered. The transceivers of our forwarded tracks keep
+// the codec of their track.
+func (p *Peer[ID]) applyCodecPreferences() {
+	if len(p.config.CodecPreferences) == 0 {
+		return
+	}
+
+	for _, transceiver := range p.peerConnection.GetTransceivers() {
+		receiver := transceiver.Receiver()
+		if receiver == nil || (transceiver.Sender() != nil && transceiver.Sender().Track() != nil) {
+			continue
+		}
+
+		codecs := preferredCodecs(receiver.GetParameters().Codecs, p.config.CodecPreferences)
+		if err := transceiver.SetCodecPreferences(codecs); err != nil {
+			p.logger.WithError(err).Warn("failed to set codec preferences")
+		}
+	}
+}
+
+// Returns the codecs ordered by the given MIME types, the codecs that are not mentioned follow in their order.
+func preferredCodecs(codecs []webrtc.RTPCodecParameters, preferences []string) []webrtc.RTPCodecParameters {
+	rank := func(codec webrtc.RTPCodecParameters) int {
+		for index, mimeType := range preferences {
+			if strings.EqualFold(codec.MimeType, mimeType) {
+				return index
+			}
+		}
+
+		return len(preferences)
+	}
+
+	ordered := append([]webrtc.RTPCodecParameters{}, codecs...)
+	sort.SliceStable(ordered, func(i, j int) bool { return rank(ordered[i]) < rank(ordered[j]) })
+
+	return ordered
+}
+
+// Checks if the remote peer uses a given SSRC for any of the tracks that we receive.
+func (p *Peer[ID]) isReceivingSSRC(ssrc webrtc.SSRC) bool {
+	for _, receiver := range p.peerConnection.GetReceivers() {
+		for _, track := range receiver.Tracks() {
+			if track.SSRC() == ssrc {
+				return true
+			}
+		}
+	}
+
+	return false
 }
 
 // Implementation of the `SubscriptionController` interface.
 func (p *Peer[ID]) RemoveTrack(sender *webrtc.RTPSender) error {
+	p.state.RemoveSender(sender)
 	return p.peerConnection.RemoveTrack(sender)
 }
 
-// Tries to send the given message to the remote counterpart of our peer.
-func (p *Peer[ID]) SendOverDataChannel(json string) error {
-	dataChannel := p.state.GetDataChannel()
+// Stops tracking the sender without removing it from the peer connection, so that the remote peer keeps
+// the (now silent) track until the next renegotiation, at which point the sender is pruned.
+func (p *Peer[ID]) DetachTrack(sender *webrtc.RTPSender) error {
+	p.state.RemoveSender(sender)
+	return nil
+}
+
+// Returns the amount of active senders, i.e. the tracks that we currently send to the remote peer.
+func (p *Peer[ID]) ActiveSenders() int {
+	return p.state.SenderCount()
+}
+
+// Removes the senders that still have a track attached while not being used by any subscription
+// anymore. This keeps the SDP tidy, so that it does not grow unbounded over a long call.
+func (p *Peer[ID]) pruneSenders() {
+	for _, sender := range p.peerConnection.GetSenders() {
+		if sender.Track() == nil || p.state.HasSender(sender) {
+			continue
+		}
+
+		p.logger.WithField("track", sender.Track().ID()).Debug("Removing stale sender")
+		if err := p.peerConnection.RemoveTrack(sender); err != nil {
+			p.logger.WithError(err).Warn("failed to remove stale sender")
+		}
+	}
+}
+
+// Returns the label of the default data channel, i.e. the first one opened by the remote peer (or the oldest
+// one that is still open once it's closed). Returns `false` if there are no data channels.
+func (p *Peer[ID]) DefaultDataChannelLabel() (string, bool) {
+	dataChannel := p.state.GetDefaultDataChannel()
+	if dataChannel == nil {
+		return "", false
+	}
+
+	return dataChannel.Label(), true
+}
+
+// Returns the largest message (in bytes) that may be sent over the data channels of this peer.
+func (p *Peer[ID]) MaxDataChannelMessageSize() int {
+	if p.config.MaxDataChannelMessageSize <= 0 {
+		return defaultMaxDataChannelMessageSize
+	}
+
+	return p.config.MaxDataChannelMessageSize
+}
+
+// Tries to send the given messages to the remote counterpart of our peer over the data channel
+// with a given label. See `DefaultDataChannelLabel()` for the label of the default data channel.
+// The messages (e.g. the chunks of a large event) are either all sent or, if congested, none of them.
+func (p *Peer[ID]) SendOverDataChannel(label string, messages ...string) error {
+	return p.sendOverDataChannel(label, messages, p.config.MaxDataChannelBufferedAmount)
+}
+
+// Same as `SendOverDataChannel()`, but for the messages that may be dropped when the data channel can't keep
+// up (e.g. the periodic updates that are superseded by the next ones anyway).
+func (p *Peer[ID]) SendLowPriorityOverDataChannel(label string, messages ...string) error {
+	maxBufferedAmount := p.config.MaxDataChannelBufferedAmount / 2
+	if maxBufferedAmount == 0 && p.config.MaxDataChannelBufferedAmount > 0 {
+		// Halving the smallest cap must not lift it, since 0 means no limit.
+		maxBufferedAmount = 1
+	}
+
+	return p.sendOverDataChannel(label, messages, maxBufferedAmount)
+}
+
+// Sends the messages unless the data channel would have more than `maxBufferedAmount` bytes buffered
+// once all of them are queued (0 means no limit).
+func (p *Peer[ID]) sendOverDataChannel(label string, messages []string, maxBufferedAmount uint64) error {
+	dataChannel := p.state.GetDataChannel(label)
 	if dataChannel == nil {
 		return ErrDataChannelNotAvailable
 	}
@@ -109,20 +402,100 @@ func (p *Peer[ID]) SendOverDataChannel(json string) error {
 		return ErrDataChannelNotReady
 	}
 
-	if err := dataChannel.SendText(json); err != nil {
-		return fmt.Errorf("failed to send data over data channel: %w", err)
+	size := 0
+	for _, json := range messages {
+		size += len(json)
+	}
+
+	if maxBufferedAmount > 0 && dataChannel.BufferedAmount()+uint64(size) > maxBufferedAmount {
+		return ErrDataChannelCongested
+	}
+
+	for _, json := range messages {
+		if err := dataChannel.SendText(json); err != nil {
+			return fmt.Errorf("failed to send data over data channel: %w", err)
+		}
 	}
 
 	return nil
 }
 
+// The outcome of processing a batch of remote ICE candidates.
+type RemoteCandidatesResult struct {
+	Added      int
+	Duplicates int
+	Malformed  int
+	// The remote peer has signaled that it has gathered all of its candidates.
+	EndOfCandidates bool
+}
+
 // Processes the remote ICE candidates.
-func (p *Peer[ID]) ProcessNewRemoteCandidates(candidates []webrtc.ICECandidateInit) {
+func (p *Peer[ID]) ProcessNewRemoteCandidates(candidates []webrtc.ICECandidateInit) RemoteCandidatesResult {
+	var result RemoteCandidatesResult
+
 	for _, candidate := range candidates {
+		value := strings.TrimSpace(strings.TrimPrefix(candidate.Candidate, "candidate:"))
+
+		switch {
+		case value == "":
+			// An empty candidate is not an error, it marks the end of the candidates. It's not passed to
+			// `AddICECandidate()`: Pion ignores it anyway, but fails if there is no remote description yet.
+			result.EndOfCandidates = true
+			continue
+		case !isWellFormedCandidate(value):
+			p.logger.WithField("candidate", candidate.Candidate).Debug("skipping malformed ICE candidate")
+			result.Malformed++
+			continue
+		}
+
+		if _, found := p.remoteCandidates[value]; found {
+			result.Duplicates++
+			continue
+		}
+
 		if err := p.peerConnection.AddICECandidate(candidate); err != nil {
 			p.logger.WithError(err).Error("failed to add ICE candidate")
+			continue
+		}
+
+		p.remoteCandidates[value] = struct{}{}
+		result.Added++
+	}
+
+	if result.EndOfCandidates {
+		p.logger.Debug("remote ICE candidate gathering finished")
+	}
+	if result.Malformed > 0 {
+		p.logger.Warnf("skipped %d malformed ICE candidates", result.Malformed)
+	}
+
+	return result
+}
+
+// Checks that the candidate (without the `candidate:` prefix) follows the grammar of RFC 8839, i.e.
+// `<foundation> <component> <transport> <priority> <address> <port> typ <type> ...`.
+func isWellFormedCandidate(value string) bool {
+	fields := strings.Fields(value)
+	if len(fields) < 8 || fields[6] != "typ" {
+		return false
+	}
+
+	for _, index := range []int{1, 3, 5} {
+		if _, err := strconv.ParseUint(fields[index], 10, 32); err != nil {
+			return false
 		}
 	}
+
+	return true
+}
+
+// Forgets the remote candidates that have been added so far if the remote peer has restarted ICE, since
+// the same candidates are valid again (and must be added again) for the new ICE session.
+func (p *Peer[ID]) onRemoteDescriptionSet(sdp string) {
+	if ufrag := webrtc_ext.ICEUsernameFragment(sdp); ufrag != p.remoteUfrag {
+		p.remoteUfrag = ufrag
+		p.remoteCandidates = make(map[string]struct{})
+	}
 }
 
 // Processes the SDP answer received from the remote peer.
@@ -135,12 +508,20 @@ func (p *Peer[ID]) ProcessSDPAnswer(sdpAnswer string) error {
 		p.logger.WithError(err).Error("failed to set remote description")
 		return ErrCantSetRemoteDescription
 	}
+	p.onRemoteDescriptionSet(sdpAnswer)
 
 	return nil
 }
 
-// Applies the sdp offer received from the remote peer and generates an SDP answer.
+// Applies the sdp offer received from the remote peer and generates an SDP answer. Returns `ErrOfferIgnored`
+// if the offer collides with our own pending offer (glare): we are always the impolite peer of the "perfect
+// negotiation" pattern, i.e. the remote peer is expected to roll back its offer and to answer ours.
 func (p *Peer[ID]) ProcessSDPOffer(sdpOffer string) (*webrtc.SessionDescription, error) {
+	if p.peerConnection.SignalingState() == webrtc.SignalingStateHaveLocalOffer {
+		p.logger.Info("ignoring the remote offer, our offer is pending (glare)")
+		return nil, ErrOfferIgnored
+	}
+
 	err := p.peerConnection.SetRemoteDescription(webrtc.SessionDescription{
 		Type: webrtc.SDPTypeOffer,
 		SDP:  sdpOffer,
@@ -149,6 +530,8 @@ func (p *Peer[ID]) ProcessSDPOffer(sdpOffer string) (*webrtc.SessionDescription,
 		p.logger.WithError(err).Error("failed to set remote description")
 		return nil, ErrCantSetRemoteDescription
 	}
+	p.onRemoteDescriptionSet(sdpOffer)
+	p.applyCodecPreferences()
 
 	answer, err := p.peerConnection.CreateAnswer(nil)
 	if err != nil {
@@ -156,10 +539,130 @@ func (p *Peer[ID]) ProcessSDPOffer(sdpOffer string) (*webrtc.SessionDescription,
 		return nil, ErrCantCreateAnswer
 	}
 
-	if err := p.peerConnection.SetLocalDescription(answer); err != nil {
+	if p.config.ExplicitTransceiverDirections {
+		answer.SDP = webrtc_ext.SetMediaDirections(answer.SDP, p.answeredDirections(sdpOffer))
+	}
+
+	if err := p.setLocalDescription(answer); err != nil {
+		return nil, err
+	}
+
+	return p.localDescription(), nil
+}
+
+// Returns the directions in which the media sections of a given offer are answered: we only receive the
+// media that the remote peer sends and only send our forwarded tracks to it.
+func (p *Peer[ID]) answeredDirections(sdpOffer string) map[string]webrtc.RTPTransceiverDirection {
+	sending := make(map[string]bool)
+	for _, transceiver := range p.peerConnection.GetTransceivers() {
+		if sender := transceiver.Sender(); sender != nil && sender.Track() != nil && transceiver.Mid() != "" {
+			sending[transceiver.Mid()] = true
+		}
+	}
+
+	directions := make(map[string]webrtc.RTPTransceiverDirection)
+	for mid, offered := range webrtc_ext.MediaDirections(sdpOffer) {
+		switch {
+		case offered == webrtc.RTPTransceiverDirectionSendrecv || offered == webrtc.RTPTransceiverDirectionSendonly:
+			directions[mid] = webrtc.RTPTransceiverDirectionRecvonly
+		case offered == webrtc.RTPTransceiverDirectionRecvonly && sending[mid]:
+			directions[mid] = webrtc.RTPTransceiverDirectionSendonly
+		default:
+			directions[mid] = webrtc.RTPTransceiverDirectionInactive
+		}
+	}
+
+	return directions
+}
+
+// Returns the local description that is sent to the remote peer. When trickle ICE is disabled, it
+// contains the local candidates, so the candidate filter is applied to it. Pion refuses to set a
+// modified local description, so the bitrate hints are only added to the one that is sent.
+func (p *Peer[ID]) localDescription() *webrtc.SessionDescription {
+	description := p.peerConnection.LocalDescription()
+	if description == nil {
+		return nil
+	}
+
+	sent := *description
+	if p.candidateFilter != nil {
+		sent.SDP = p.candidateFilter.ApplyToSDP(sent.SDP)
+	}
+
+	sent.SDP = webrtc_ext.SetMaxBitrates(sent.SDP, p.maxBitrates())
+	return &sent
+}
+
+// Returns the ICE candidates that the peer connection has selected (the pair is known once it's connected).
+func (p *Peer[ID]) selectedCandidatePair() (*CandidatePair, error) {
+	pair, err := p.peerConnection.SCTP().Transport().ICETransport().GetSelectedCandidatePair()
+	if err != nil {
+		return nil, err
+	}
+
+	if pair == nil || pair.Local == nil || pair.Remote == nil {
+		return nil, errors.New("no candidate pair has been selected")
+	}
+
+	return &CandidatePair{Local: newCandidate(pair.Local), Remote: newCandidate(pair.Remote)}, nil
+}
+
+func newCandidate(candidate *webrtc.ICECandidate) Candidate {
+	return Candidate{
+		Type:    candidate.Typ.String(),
+		Address: net.JoinHostPort(candidate.Address, strconv.Itoa(int(candidate.Port))),
+	}
+}
+
+// Implementation of the `SubscriptionController` interface. The bitrate is hinted to the remote peer in the
+// SDP (see `webrtc_ext.SetMaxBitrates()`) on the next negotiation.
+func (p *Peer[ID]) SetMaxBitrate(sender *webrtc.RTPSender, bitrate uint64) {
+	p.state.SetMaxBitrate(sender, bitrate)
+}
+
+// Returns the maximum bitrates of the senders by the `mid`s of their media sections.
+func (p *Peer[ID]) maxBitrates() map[string]uint64 {
+	bitrates := make(map[string]uint64)
+	for _, transceiver := range p.peerConnection.GetTransceivers() {
+		if sender := transceiver.Sender(); sender != nil && transceiver.Mid() != "" {
+			if bitrate := p.state.MaxBitrate(sender); bitrate > 0 {
+				bitrates[transceiver.Mid()] = bitrate
+			}
+		}
+	}
+
+	return bitrates
+}
+
+// Sets the local description. If trickle ICE is disabled, waits until the ICE gathering is complete,
+// so that the local description contains all local candidates.
+func (p *Peer[ID]) setLocalDescription(description webrtc.SessionDescription) error {
+	// The promise must be created before the gathering starts, i.e. before setting the local description.
+	var gatheringComplete <-chan struct{}
+	if p.config.DisableTrickleICE {
+		gatheringComplete = webrtc.GatheringCompletePromise(p.peerConnection)
+	}
+
+	if err := p.peerConnection.SetLocalDescription(description); err != nil {
 		p.logger.WithError(err).Error("failed to set local description")
-		return nil, ErrCantSetLocalDescription
+		return ErrCantSetLocalDescription
+	}
+
+	if gatheringComplete == nil {
+		return nil
 	}
 
-	return &answer, nil
+	timeout := p.config.ICEGatheringTimeout
+	if timeout == 0 {
+		timeout = defaultICEGatheringTimeout
+	}
+
+	select {
+	case <-gatheringComplete:
+	case <-time.After(timeout):
+		p.logger.Warn("ICE gathering timed out, sending the candidates gathered so far")
+	case <-p.done:
+	}
+
+	return nil
 }
@@ -0,0 +1,1086 @@
+package peer_test
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/matrix-org/waterfall/pkg/channel"
+	"github.com/matrix-org/waterfall/pkg/peer"
+	"github.com/matrix-org/waterfall/pkg/webrtc_ext"
+	"github.com/pion/rtcp"
+	"github.com/pion/rtp"
+	"github.com/pion/webrtc/v3"
+	"github.com/sirupsen/logrus"
+)
+
+// Creates a new peer for an offer of a remote peer and returns the SDP answer and the messages of the peer.
+func newTestPeer(
+	t *testing.T,
+	config peer.Config,
+) (*peer.Peer[string], *webrtc.SessionDescription, <-chan channel.Message[string, peer.MessageContent]) {
+	t.Helper()
+	return newTestPeerWithWebRTC(t, webrtc_ext.Config{}, config)
+}
+
+// Same as `newTestPeer`, but with a given configuration of the WebRTC API.
+func newTestPeerWithWebRTC(
+	t *testing.T,
+	webrtcConfig webrtc_ext.Config,
+	config peer.Config,
+) (*peer.Peer[string], *webrtc.SessionDescription, <-chan channel.Message[string, peer.MessageContent]) {
+	t.Helper()
+
+	remote, err := webrtc.NewPeerConnection(webrtc.Configuration{})
+	if err != nil {
+		t.Fatalf("Failed to create remote peer connection: %v", err)
+	}
+	t.Cleanup(func() { remote.Close() })
+
+	return newTestPeerForRemote(t, remote, webrtcConfig, config)
+}
+
+// Same as `newTestPeerWithWebRTC`, but for the offer of a given remote peer connection.
+func newTestPeerForRemote(
+	t *testing.T,
+	remote *webrtc.PeerConnection,
+	webrtcConfig webrtc_ext.Config,
+	config peer.Config,
+) (*peer.Peer[string], *webrtc.SessionDescription, <-chan channel.Message[string, peer.MessageContent]) {
+	t.Helper()
+
+	if _, err := remote.CreateDataChannel("data", nil); err != nil {
+		t.Fatalf("Failed to create data channel: %v", err)
+	}
+
+	offer, err := remote.CreateOffer(nil)
+	if err != nil {
+		t.Fatalf("Failed to create offer: %v", err)
+	}
+
+	factory, err := webrtc_ext.NewPeerConnectionFactory(webrtcConfig)
+	if err != nil {
+		t.Fatalf("Failed to create peer connection factory: %v", err)
+	}
+
+	messages := make(chan channel.Message[string, peer.MessageContent], 100)
+	sink := channel.NewSink("remote", messages)
+
+	p, answer, err := peer.NewPeer(factory, offer.SDP, sink, config, logrus.NewEntry(logrus.New()))
+	if err != nil {
+		t.Fatalf("Failed to create peer: %v", err)
+	}
+	t.Cleanup(p.Terminate)
+
+	return p, answer, messages
+}
+
+// Same as `newTestPeerForRemote`, but also connects the remote peer to the peer. Pion does not renegotiate
+// until the initial negotiation is complete, i.e. until the peer connection is connected.
+func newConnectedTestPeer(
+	t *testing.T,
+	remote *webrtc.PeerConnection,
+	config peer.Config,
+) (*peer.Peer[string], *webrtc.SessionDescription, <-chan channel.Message[string, peer.MessageContent]) {
+	t.Helper()
+
+	if _, err := remote.CreateDataChannel("data", nil); err != nil {
+		t.Fatalf("Failed to create data channel: %v", err)
+	}
+
+	return connectTestPeer(t, remote, config)
+}
+
+// Same as `newConnectedTestPeer`, but for a remote peer that has already created its data channels.
+func connectTestPeer(
+	t *testing.T,
+	remote *webrtc.PeerConnection,
+	config peer.Config,
+) (*peer.Peer[string], *webrtc.SessionDescription, <-chan channel.Message[string, peer.MessageContent]) {
+	t.Helper()
+
+	offer, err := remote.CreateOffer(nil)
+	if err != nil {
+		t.Fatalf("Failed to create offer: %v", err)
+	}
+	gathered := webrtc.GatheringCompletePromise(remote)
+	if err := remote.SetLocalDescription(offer); err != nil {
+		t.Fatalf("Failed to set local description: %v", err)
+	}
+	<-gathered
+
+	factory, err := webrtc_ext.NewPeerConnectionFactory(webrtc_ext.Config{})
+	if err != nil {
+		t.Fatalf("Failed to create peer connection factory: %v", err)
+	}
+
+	messages := make(chan channel.Message[string, peer.MessageContent], 100)
+	p, answer, err := peer.NewPeer(
+		factory,
+		remote.LocalDescription().SDP,
+		channel.NewSink("remote", messages),
+		config,
+		logrus.NewEntry(logrus.New()),
+	)
+	if err != nil {
+		t.Fatalf("Failed to create peer: %v", err)
+	}
+	t.Cleanup(p.Terminate)
+
+	if err := remote.SetRemoteDescription(*answer); err != nil {
+		t.Fatalf("Failed to set remote description: %v", err)
+	}
+
+	for {
+		select {
+		case msg := <-messages:
+			switch content := msg.Content.(type) {
+			case peer.NewICECandidate:
+				if err := remote.AddICECandidate(content.Candidate.ToJSON()); err != nil {
+					t.Fatalf("Failed to add ICE candidate: %v", err)
+				}
+			case peer.JoinedTheCall:
+				return p, answer, messages
+			}
+		case <-time.After(10 * time.Second):
+			t.Fatal("Expected the peer connection to get connected")
+		}
+	}
+}
+
+// Collects the messages of the peer until the ICE gathering is complete.
+func waitForGatheringComplete(
+	t *testing.T,
+	messages <-chan channel.Message[string, peer.MessageContent],
+) []peer.NewICECandidate {
+	t.Helper()
+
+	candidates := []peer.NewICECandidate{}
+	for {
+		select {
+		case msg := <-messages:
+			switch content := msg.Content.(type) {
+			case peer.NewICECandidate:
+				candidates = append(candidates, content)
+			case peer.ICEGatheringComplete:
+				return candidates
+			}
+		case <-time.After(10 * time.Second):
+			t.Fatal("ICE gathering did not complete")
+		}
+	}
+}
+
+func TestTrickleICE(t *testing.T) {
+	_, answer, messages := newTestPeer(t, peer.Config{})
+
+	if strings.Contains(answer.SDP, "a=candidate:") {
+		t.Error("Expected no candidates in the SDP answer")
+	}
+
+	if candidates := waitForGatheringComplete(t, messages); len(candidates) == 0 {
+		t.Error("Expected the candidates to be trickled")
+	}
+}
+
+func TestNonTrickleICE(t *testing.T) {
+	_, answer, messages := newTestPeer(t, peer.Config{DisableTrickleICE: true})
+
+	if !strings.Contains(answer.SDP, "a=candidate:") {
+		t.Error("Expected the candidates to be part of the SDP answer")
+	}
+
+	if candidates := waitForGatheringComplete(t, messages); len(candidates) != 0 {
+		t.Errorf("Expected no trickled candidates, got %d", len(candidates))
+	}
+}
+
+func TestFilteredCandidatesAreNotSent(t *testing.T) {
+	filter := webrtc_ext.Config{CandidateFilter: webrtc_ext.CandidateFilterConfig{ExcludeTypes: []string{"host"}}}
+
+	_, _, messages := newTestPeerWithWebRTC(t, filter, peer.Config{})
+	for _, candidate := range waitForGatheringComplete(t, messages) {
+		if candidate.Candidate.Typ == webrtc.ICECandidateTypeHost {
+			t.Errorf("Expected host candidates to be filtered, got %s", candidate.Candidate)
+		}
+	}
+
+	_, answer, _ := newTestPeerWithWebRTC(t, filter, peer.Config{DisableTrickleICE: true})
+	if strings.Contains(answer.SDP, "typ host") {
+		t.Errorf("Expected no host candidates in the SDP answer:\n%s", answer.SDP)
+	}
+}
+
+func TestUniqueSenderSSRCs(t *testing.T) {
+	p, _, _ := newTestPeer(t, peer.Config{})
+
+	ssrcs := make(map[webrtc.SSRC]bool)
+	for i := 0; i < 50; i++ {
+		track, err := webrtc.NewTrackLocalStaticRTP(
+			webrtc.RTPCodecCapability{MimeType: webrtc.MimeTypeVP8, ClockRate: 90000},
+			"track",
+			"stream",
+		)
+		if err != nil {
+			t.Fatalf("Failed to create track: %v", err)
+		}
+
+		sender, err := p.AddTrack(track)
+		if err != nil {
+			t.Fatalf("Failed to add track: %v", err)
+		}
+
+		ssrc := sender.GetParameters().Encodings[0].SSRC
+		if ssrcs[ssrc] {
+			t.Fatalf("SSRC %d has been allocated twice", ssrc)
+		}
+		ssrcs[ssrc] = true
+	}
+}
+
+func TestProcessRemoteCandidates(t *testing.T) {
+	p, _, _ := newTestPeer(t, peer.Config{})
+
+	candidate := func(value string) webrtc.ICECandidateInit {
+		mid, index := "0", uint16(0)
+		return webrtc.ICECandidateInit{Candidate: value, SDPMid: &mid, SDPMLineIndex: &index}
+	}
+
+	result := p.ProcessNewRemoteCandidates([]webrtc.ICECandidateInit{
+		candidate("candidate:1 1 udp 2130706431 127.0.0.1 5000 typ host"),
+		candidate("candidate:1 1 udp 2130706431 127.0.0.1 5000 typ host"),
+		candidate("candidate:2 1 udp 2130706431 127.0.0.1 5001 typ host generation 0"),
+		candidate("candidate:garbage"),
+		candidate("candidate:3 1 udp high 127.0.0.1 5002 typ host"),
+		candidate(""),
+	})
+
+	expected := peer.RemoteCandidatesResult{Added: 2, Duplicates: 1, Malformed: 2, EndOfCandidates: true}
+	if result != expected {
+		t.Errorf("Expected %+v, got %+v", expected, result)
+	}
+
+	// The candidates that have been added in the previous batches are duplicates as well.
+	result = p.ProcessNewRemoteCandidates([]webrtc.ICECandidateInit{
+		candidate("candidate:2 1 udp 2130706431 127.0.0.1 5001 typ host generation 0"),
+	})
+	if result.Duplicates != 1 || result.Added != 0 || result.EndOfCandidates {
+		t.Errorf("Expected a single duplicate, got %+v", result)
+	}
+}
+
+func TestEndOfCandidates(t *testing.T) {
+	p, _, _ := newTestPeer(t, peer.Config{})
+
+	// The marker comes alone, the way it's sent once the gathering has finished. The clients don't always
+	// send the media line of the marker, so it may be missing as well.
+	mid, index := "0", uint16(0)
+	markers := []webrtc.ICECandidateInit{
+		{Candidate: "", SDPMid: &mid, SDPMLineIndex: &index},
+		{Candidate: ""},
+		{Candidate: "candidate:"},
+		{Candidate: "  "},
+	}
+
+	for _, marker := range markers {
+		result := p.ProcessNewRemoteCandidates([]webrtc.ICECandidateInit{marker})
+
+		expected := peer.RemoteCandidatesResult{EndOfCandidates: true}
+		if result != expected {
+			t.Errorf("Expected %q to only mark the end of the candidates, got %+v", marker.Candidate, result)
+		}
+	}
+
+	// The candidates that come after the marker (e.g. after an ICE restart) are still added.
+	result := p.ProcessNewRemoteCandidates([]webrtc.ICECandidateInit{
+		{Candidate: "candidate:1 1 udp 2130706431 127.0.0.1 5000 typ host", SDPMid: &mid, SDPMLineIndex: &index},
+	})
+	if result.Added != 1 || result.EndOfCandidates {
+		t.Errorf("Expected the candidate after the marker to be added, got %+v", result)
+	}
+}
+
+func TestRenegotiationDeferredUntilConnected(t *testing.T) {
+	remote, err := webrtc.NewPeerConnection(webrtc.Configuration{})
+	if err != nil {
+		t.Fatalf("Failed to create remote peer connection: %v", err)
+	}
+	t.Cleanup(func() { remote.Close() })
+
+	if _, err := remote.CreateDataChannel("data", nil); err != nil {
+		t.Fatalf("Failed to create data channel: %v", err)
+	}
+
+	// Neither side trickles, so that the connection gets established with a single offer/answer.
+	offer, err := remote.CreateOffer(nil)
+	if err != nil {
+		t.Fatalf("Failed to create offer: %v", err)
+	}
+	gathered := webrtc.GatheringCompletePromise(remote)
+	if err := remote.SetLocalDescription(offer); err != nil {
+		t.Fatalf("Failed to set local description: %v", err)
+	}
+	<-gathered
+
+	factory, err := webrtc_ext.NewPeerConnectionFactory(webrtc_ext.Config{})
+	if err != nil {
+		t.Fatalf("Failed to create peer connection factory: %v", err)
+	}
+
+	messages := make(chan channel.Message[string, peer.MessageContent], 100)
+	config := peer.Config{DisableTrickleICE: true, DeferRenegotiationUntilConnected: true}
+	p, answer, err := peer.NewPeer(
+		factory,
+		remote.LocalDescription().SDP,
+		channel.NewSink("remote", messages),
+		config,
+		logrus.NewEntry(logrus.New()),
+	)
+	if err != nil {
+		t.Fatalf("Failed to create peer: %v", err)
+	}
+	t.Cleanup(p.Terminate)
+
+	// The subscriptions that are made before the answer arrives would normally trigger a renegotiation each.
+	for _, id := range []string{"first", "second"} {
+		track, err := webrtc.NewTrackLocalStaticRTP(webrtc.RTPCodecCapability{MimeType: webrtc.MimeTypeOpus}, id, "stream")
+		if err != nil {
+			t.Fatalf("Failed to create track: %v", err)
+		}
+		if _, err := p.AddTrack(track); err != nil {
+			t.Fatalf("Failed to add track: %v", err)
+		}
+	}
+
+	renegotiations := 0
+	joined := false
+	collect := func(timeout time.Duration) {
+		deadline := time.After(timeout)
+		for {
+			select {
+			case msg := <-messages:
+				switch msg.Content.(type) {
+				case peer.RenegotiationRequired:
+					if !joined {
+						t.Fatal("Expected no renegotiation before the peer connection is connected")
+					}
+					renegotiations++
+				case peer.JoinedTheCall:
+					joined = true
+				}
+			case <-deadline:
+				return
+			}
+		}
+	}
+
+	collect(500 * time.Millisecond)
+
+	if err := remote.SetRemoteDescription(*answer); err != nil {
+		t.Fatalf("Failed to set remote description: %v", err)
+	}
+
+	collect(5 * time.Second)
+
+	if !joined {
+		t.Fatal("Expected the peer connection to get connected")
+	}
+	if renegotiations != 1 {
+		t.Errorf("Expected a single renegotiation once connected, got %d", renegotiations)
+	}
+}
+
+func TestOutgoingAudioCodec(t *testing.T) {
+	const mono, stereo = "minptime=10;useinbandfec=1", "minptime=10;useinbandfec=1;stereo=1;sprop-stereo=1"
+
+	// Creates a peer for a remote peer that receives Opus with given format parameters only.
+	newPeer := func(fmtp string) (*peer.Peer[string], <-chan channel.Message[string, peer.MessageContent]) {
+		mediaEngine := &webrtc.MediaEngine{}
+		if err := mediaEngine.RegisterCodec(webrtc.RTPCodecParameters{
+			RTPCodecCapability: webrtc.RTPCodecCapability{MimeType: webrtc.MimeTypeOpus, ClockRate: 48000, Channels: 2, SDPFmtpLine: fmtp},
+			PayloadType:        111,
+		}, webrtc.RTPCodecTypeAudio); err != nil {
+			t.Fatalf("Failed to register codec: %v", err)
+		}
+
+		remote, err := webrtc.NewAPI(webrtc.WithMediaEngine(mediaEngine)).NewPeerConnection(webrtc.Configuration{})
+		if err != nil {
+			t.Fatalf("Failed to create remote peer connection: %v", err)
+		}
+		t.Cleanup(func() { remote.Close() })
+
+		if _, err := remote.AddTransceiverFromKind(
+			webrtc.RTPCodecTypeAudio,
+			webrtc.RTPTransceiverInit{Direction: webrtc.RTPTransceiverDirectionRecvonly},
+		); err != nil {
+			t.Fatalf("Failed to add transceiver: %v", err)
+		}
+
+		p, _, messages := newConnectedTestPeer(t, remote, peer.Config{})
+		return p, messages
+	}
+
+	newTrack := func(capability webrtc.RTPCodecCapability) *webrtc.TrackLocalStaticRTP {
+		track, err := webrtc.NewTrackLocalStaticRTP(capability, "audio", "stream")
+		if err != nil {
+			t.Fatalf("Failed to create track: %v", err)
+		}
+
+		return track
+	}
+
+	// Returns the Opus format parameters of the next offer of the peer.
+	offeredOpus := func(messages <-chan channel.Message[string, peer.MessageContent]) string {
+		for {
+			select {
+			case msg := <-messages:
+				if renegotiation, ok := msg.Content.(peer.RenegotiationRequired); ok {
+					for _, codec := range webrtc_ext.Codecs(renegotiation.Offer.SDP, webrtc.RTPCodecTypeAudio) {
+						if webrtc_ext.IsOpus(codec.RTPCodecCapability) {
+							return codec.SDPFmtpLine
+						}
+					}
+					t.Fatal("Expected Opus to be offered")
+				}
+			case <-time.After(5 * time.Second):
+				t.Fatal("Expected a renegotiation")
+			}
+		}
+	}
+
+	stereoTrack := webrtc.RTPCodecCapability{MimeType: webrtc.MimeTypeOpus, ClockRate: 48000, Channels: 2, SDPFmtpLine: stereo}
+
+	// The stereo track is announced as stereo to the remote peer that accepts stereo.
+	p, messages := newPeer(stereo)
+	if _, err := p.AddTrack(newTrack(stereoTrack)); err != nil {
+		t.Fatalf("Failed to add track: %v", err)
+	}
+	if fmtp := offeredOpus(messages); !webrtc_ext.OpusSendsStereo(fmtp) {
+		t.Errorf("Expected stereo to be offered, got %q", fmtp)
+	}
+
+	// The remote peer that only accepts mono gets mono offered.
+	p, messages = newPeer(mono)
+	if _, err := p.AddTrack(newTrack(stereoTrack)); err != nil {
+		t.Fatalf("Failed to add track: %v", err)
+	}
+	if fmtp := offeredOpus(messages); webrtc_ext.OpusSendsStereo(fmtp) {
+		t.Errorf("Expected mono to be offered, got %q", fmtp)
+	}
+
+	// The codecs that the remote peer does not support are never sent.
+	pcmu := newTrack(webrtc.RTPCodecCapability{MimeType: webrtc.MimeTypePCMU, ClockRate: 8000})
+	if _, err := p.AddTrack(pcmu); !errors.Is(err, peer.ErrIncompatibleCodec) {
+		t.Errorf("Expected %v, got %v", peer.ErrIncompatibleCodec, err)
+	}
+	if senders := p.ActiveSenders(); senders != 1 {
+		t.Errorf("Expected 1 active sender, got %d", senders)
+	}
+}
+
+func TestExplicitTransceiverDirections(t *testing.T) {
+	// Creates a peer for a remote peer that publishes audio and video and returns the mids of the published media.
+	newPeer := func(config peer.Config) (
+		*peer.Peer[string],
+		*webrtc.SessionDescription,
+		<-chan channel.Message[string, peer.MessageContent],
+		[]string,
+	) {
+		remote, err := webrtc.NewPeerConnection(webrtc.Configuration{})
+		if err != nil {
+			t.Fatalf("Failed to create remote peer connection: %v", err)
+		}
+		t.Cleanup(func() { remote.Close() })
+
+		for _, kind := range []webrtc.RTPCodecType{webrtc.RTPCodecTypeAudio, webrtc.RTPCodecTypeVideo} {
+			if _, err := remote.AddTransceiverFromKind(
+				kind,
+				webrtc.RTPTransceiverInit{Direction: webrtc.RTPTransceiverDirectionSendrecv},
+			); err != nil {
+				t.Fatalf("Failed to add transceiver: %v", err)
+			}
+		}
+
+		p, answer, messages := newConnectedTestPeer(t, remote, config)
+		return p, answer, messages, []string{"0", "1"}
+	}
+
+	// Adds an audio track to the peer and returns the directions of the offer that it triggers.
+	offeredDirections := func(
+		p *peer.Peer[string],
+		messages <-chan channel.Message[string, peer.MessageContent],
+	) map[string]webrtc.RTPTransceiverDirection {
+		track, err := webrtc.NewTrackLocalStaticRTP(webrtc.RTPCodecCapability{MimeType: webrtc.MimeTypeOpus}, "audio", "stream")
+		if err != nil {
+			t.Fatalf("Failed to create track: %v", err)
+		}
+		if _, err := p.AddTrack(track); err != nil {
+			t.Fatalf("Failed to add track: %v", err)
+		}
+
+		for {
+			select {
+			case msg := <-messages:
+				if renegotiation, ok := msg.Content.(peer.RenegotiationRequired); ok {
+					return webrtc_ext.MediaDirections(renegotiation.Offer.SDP)
+				}
+			case <-time.After(5 * time.Second):
+				t.Fatal("Expected a renegotiation")
+			}
+		}
+	}
+
+	p, answer, messages, published := newPeer(peer.Config{ExplicitTransceiverDirections: true})
+
+	// The published media is only received.
+	answered := webrtc_ext.MediaDirections(answer.SDP)
+	for _, mid := range published {
+		if answered[mid] != webrtc.RTPTransceiverDirectionRecvonly {
+			t.Errorf("Expected the published media %s to be answered as recvonly, got %s", mid, answered[mid])
+		}
+	}
+
+	// The forwarded track gets its own transceiver instead of the one that the remote peer publishes on.
+	offered := offeredDirections(p, messages)
+	for _, mid := range published {
+		if offered[mid] != webrtc.RTPTransceiverDirectionRecvonly {
+			t.Errorf("Expected the published media %s to stay recvonly, got %s", mid, offered[mid])
+		}
+	}
+
+	sendonly := 0
+	for _, direction := range offered {
+		if direction == webrtc.RTPTransceiverDirectionSendonly {
+			sendonly++
+		}
+	}
+	if len(offered) != len(published)+1 || sendonly != 1 {
+		t.Errorf("Expected the forwarded track to be offered on a new sendonly transceiver, got %v", offered)
+	}
+
+	// Pion reuses the publish transceiver for the forwarded track by default.
+	p, _, messages, published = newPeer(peer.Config{})
+	if offered := offeredDirections(p, messages); offered[published[0]] != webrtc.RTPTransceiverDirectionSendrecv {
+		t.Errorf("Expected the forwarded track to reuse the publish transceiver by default, got %v", offered)
+	}
+}
+
+func TestGlare(t *testing.T) {
+	remote, err := webrtc.NewPeerConnection(webrtc.Configuration{})
+	if err != nil {
+		t.Fatalf("Failed to create remote peer connection: %v", err)
+	}
+	t.Cleanup(func() { remote.Close() })
+
+	p, _, messages := newConnectedTestPeer(t, remote, peer.Config{})
+
+	// Our offer is sent, but not answered yet.
+	track, err := webrtc.NewTrackLocalStaticRTP(webrtc.RTPCodecCapability{MimeType: webrtc.MimeTypeOpus}, "audio", "stream")
+	if err != nil {
+		t.Fatalf("Failed to create track: %v", err)
+	}
+	if _, err := p.AddTrack(track); err != nil {
+		t.Fatalf("Failed to add track: %v", err)
+	}
+
+	var offer *webrtc.SessionDescription
+	for offer == nil {
+		select {
+		case msg := <-messages:
+			if renegotiation, ok := msg.Content.(peer.RenegotiationRequired); ok {
+				offer = renegotiation.Offer
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatal("Expected a renegotiation")
+		}
+	}
+
+	// Meanwhile, the remote peer starts publishing.
+	if _, err := remote.AddTransceiverFromKind(webrtc.RTPCodecTypeVideo); err != nil {
+		t.Fatalf("Failed to add transceiver: %v", err)
+	}
+	remoteOffer, err := remote.CreateOffer(nil)
+	if err != nil {
+		t.Fatalf("Failed to create offer: %v", err)
+	}
+
+	// We are the impolite peer, so the remote offer is ignored.
+	if answer, err := p.ProcessSDPOffer(remoteOffer.SDP); !errors.Is(err, peer.ErrOfferIgnored) || answer != nil {
+		t.Errorf("Expected the remote offer to be ignored, got %v (%v)", answer, err)
+	}
+
+	// The negotiation is not stuck: the remote peer answers our offer instead.
+	if err := remote.SetRemoteDescription(*offer); err != nil {
+		t.Fatalf("Failed to set remote description: %v", err)
+	}
+	answer, err := remote.CreateAnswer(nil)
+	if err != nil {
+		t.Fatalf("Failed to create answer: %v", err)
+	}
+	if err := remote.SetLocalDescription(answer); err != nil {
+		t.Fatalf("Failed to set local description: %v", err)
+	}
+
+	if err := p.ProcessSDPAnswer(answer.SDP); err != nil {
+		t.Errorf("Expected our offer to be answered, got %v", err)
+	}
+}
+
+func TestDataChannelBufferedAmountIsBounded(t *testing.T) {
+	const maxBufferedAmount = 256 * 1024
+
+	remote, err := webrtc.NewPeerConnection(webrtc.Configuration{})
+	if err != nil {
+		t.Fatalf("Failed to create remote peer connection: %v", err)
+	}
+	t.Cleanup(func() { remote.Close() })
+
+	p, _, messages := newConnectedTestPeer(t, remote, peer.Config{MaxDataChannelBufferedAmount: maxBufferedAmount})
+
+	for available := false; !available; {
+		select {
+		case msg := <-messages:
+			_, available = msg.Content.(peer.DataChannelAvailable)
+		case <-time.After(5 * time.Second):
+			t.Fatal("Expected the data channel to open")
+		}
+	}
+
+	// The messages are sent way faster than the remote peer can receive them, as if it was stalled.
+	message := strings.Repeat("x", 32*1024)
+	sent, congested, lowPriorityCongested := 0, 0, 0
+	for i := 0; i < 256; i++ {
+		err := p.SendLowPriorityOverDataChannel("data", message)
+		if errors.Is(err, peer.ErrDataChannelCongested) {
+			lowPriorityCongested++
+		} else if err != nil {
+			t.Fatalf("Failed to send low-priority message: %v", err)
+		}
+
+		switch err := p.SendOverDataChannel("data", message); {
+		case errors.Is(err, peer.ErrDataChannelCongested):
+			congested++
+		case err != nil:
+			t.Fatalf("Failed to send message: %v", err)
+		default:
+			sent++
+		}
+	}
+
+	// At most the cap is buffered, the rest is refused.
+	if congested == 0 || sent == 0 {
+		t.Errorf("Expected the messages to be refused once congested, sent %d, refused %d", sent, congested)
+	}
+
+	// The low-priority messages are refused first.
+	if lowPriorityCongested <= congested {
+		t.Errorf("Expected more low-priority messages to be refused, got %d and %d", lowPriorityCongested, congested)
+	}
+}
+
+func TestSmallestBufferedAmountCapIsKeptForLowPriorityMessages(t *testing.T) {
+	remote, err := webrtc.NewPeerConnection(webrtc.Configuration{})
+	if err != nil {
+		t.Fatalf("Failed to create remote peer connection: %v", err)
+	}
+	t.Cleanup(func() { remote.Close() })
+
+	p, _, messages := newConnectedTestPeer(t, remote, peer.Config{MaxDataChannelBufferedAmount: 1})
+	waitForMessage(t, messages, func(msg peer.DataChannelAvailable) bool { return msg.Label == "data" })
+
+	// Half of the cap rounds down to 0, which must not mean that the low-priority messages are unlimited.
+	if err := p.SendLowPriorityOverDataChannel("data", "update"); !errors.Is(err, peer.ErrDataChannelCongested) {
+		t.Errorf("Expected the low-priority message to be refused, got %v", err)
+	}
+}
+
+func TestChunksAreNotSentPartially(t *testing.T) {
+	const maxBufferedAmount = 64 * 1024
+
+	remote, err := webrtc.NewPeerConnection(webrtc.Configuration{})
+	if err != nil {
+		t.Fatalf("Failed to create remote peer connection: %v", err)
+	}
+	t.Cleanup(func() { remote.Close() })
+
+	p, _, messages := newConnectedTestPeer(t, remote, peer.Config{MaxDataChannelBufferedAmount: maxBufferedAmount})
+	waitForMessage(t, messages, func(msg peer.DataChannelAvailable) bool { return msg.Label == "data" })
+
+	// Each chunk fits, but all of them together don't, so none of them is sent.
+	chunk := strings.Repeat("x", 32*1024)
+	if err := p.SendOverDataChannel("data", chunk, chunk, chunk); !errors.Is(err, peer.ErrDataChannelCongested) {
+		t.Errorf("Expected the chunks to be refused as a whole, got %v", err)
+	}
+
+	if err := p.SendOverDataChannel("data", chunk[:16*1024], chunk[:16*1024]); err != nil {
+		t.Errorf("Expected the chunks that fit to be sent, got %v", err)
+	}
+}
+
+func TestSupportsCodec(t *testing.T) {
+	// The remote peer only receives VP8 video.
+	mediaEngine := &webrtc.MediaEngine{}
+	if err := mediaEngine.RegisterCodec(webrtc.RTPCodecParameters{
+		RTPCodecCapability: webrtc.RTPCodecCapability{MimeType: webrtc.MimeTypeVP8, ClockRate: 90000},
+		PayloadType:        96,
+	}, webrtc.RTPCodecTypeVideo); err != nil {
+		t.Fatalf("Failed to register codec: %v", err)
+	}
+
+	remote, err := webrtc.NewAPI(webrtc.WithMediaEngine(mediaEngine)).NewPeerConnection(webrtc.Configuration{})
+	if err != nil {
+		t.Fatalf("Failed to create remote peer connection: %v", err)
+	}
+	t.Cleanup(func() { remote.Close() })
+
+	if _, err := remote.AddTransceiverFromKind(
+		webrtc.RTPCodecTypeVideo,
+		webrtc.RTPTransceiverInit{Direction: webrtc.RTPTransceiverDirectionRecvonly},
+	); err != nil {
+		t.Fatalf("Failed to add transceiver: %v", err)
+	}
+
+	p, _, _ := newConnectedTestPeer(t, remote, peer.Config{})
+
+	cases := []struct {
+		codec    webrtc.RTPCodecCapability
+		expected bool
+	}{
+		{webrtc.RTPCodecCapability{MimeType: webrtc.MimeTypeVP8, ClockRate: 90000}, true},
+		{webrtc.RTPCodecCapability{MimeType: "video/vp8", ClockRate: 90000}, true},
+		{webrtc.RTPCodecCapability{MimeType: webrtc.MimeTypeVP9, ClockRate: 90000}, false},
+		// The remote peer has not declared any audio codecs, so they will be negotiated along with the track.
+		{webrtc.RTPCodecCapability{MimeType: webrtc.MimeTypeOpus, ClockRate: 48000}, true},
+	}
+
+	for _, c := range cases {
+		if supported := p.SupportsCodec(c.codec); supported != c.expected {
+			t.Errorf("%s: expected supported to be %v, got %v", c.codec.MimeType, c.expected, supported)
+		}
+	}
+}
+
+func TestAnswerBundlesAllMediaSections(t *testing.T) {
+	remote, err := webrtc.NewPeerConnection(webrtc.Configuration{})
+	if err != nil {
+		t.Fatalf("Failed to create remote peer connection: %v", err)
+	}
+	t.Cleanup(func() { remote.Close() })
+
+	for _, kind := range []webrtc.RTPCodecType{webrtc.RTPCodecTypeAudio, webrtc.RTPCodecTypeVideo} {
+		if _, err := remote.AddTransceiverFromKind(kind); err != nil {
+			t.Fatalf("Failed to add %s transceiver: %v", kind, err)
+		}
+	}
+
+	_, answer, _ := newTestPeerForRemote(t, remote, webrtc_ext.Config{}, peer.Config{})
+
+	parsed, err := answer.Unmarshal()
+	if err != nil {
+		t.Fatalf("Failed to parse answer: %v", err)
+	}
+
+	// Pion has a single transport for all media sections, so all of them must be bundled.
+	group, found := parsed.Attribute("group")
+	if !found {
+		t.Fatalf("Expected the answer to contain the BUNDLE group")
+	}
+
+	bundled := strings.Fields(group)
+	if len(bundled) == 0 || bundled[0] != "BUNDLE" {
+		t.Fatalf("Expected the BUNDLE group, got %q", group)
+	}
+
+	for _, media := range parsed.MediaDescriptions {
+		mid, _ := media.Attribute("mid")
+		found := false
+		for _, bundledMid := range bundled[1:] {
+			found = found || bundledMid == mid
+		}
+
+		if !found {
+			t.Errorf("Expected the %s section (mid %q) to be bundled, got %q", media.MediaName.Media, mid, group)
+		}
+	}
+
+	if len(parsed.MediaDescriptions) != 3 {
+		t.Errorf("Expected audio, video and data sections, got %d", len(parsed.MediaDescriptions))
+	}
+}
+
+func TestMaxBitrateHints(t *testing.T) {
+	remote, err := webrtc.NewPeerConnection(webrtc.Configuration{})
+	if err != nil {
+		t.Fatalf("Failed to create remote peer connection: %v", err)
+	}
+	t.Cleanup(func() { remote.Close() })
+
+	p, _, messages := newConnectedTestPeer(t, remote, peer.Config{})
+
+	// Forwards the video of two subscriptions that get different layers.
+	bitrates := map[string]uint64{"low": 150_000, "high": 1_500_000}
+	senders := make(map[string]*webrtc.RTPSender)
+	for trackID := range bitrates {
+		track, err := webrtc.NewTrackLocalStaticRTP(webrtc.RTPCodecCapability{MimeType: webrtc.MimeTypeVP8}, trackID, trackID)
+		if err != nil {
+			t.Fatalf("Failed to create track: %v", err)
+		}
+
+		if senders[trackID], err = p.AddTrack(track); err != nil {
+			t.Fatalf("Failed to add track: %v", err)
+		}
+	}
+
+	// The remote peer accepts the forwarded tracks, they may take more than one renegotiation.
+	for offered := map[string]bool{}; len(offered) < len(bitrates); {
+		select {
+		case msg := <-messages:
+			renegotiation, ok := msg.Content.(peer.RenegotiationRequired)
+			if !ok {
+				continue
+			}
+
+			if err := remote.SetRemoteDescription(*renegotiation.Offer); err != nil {
+				t.Fatalf("Failed to set remote description: %v", err)
+			}
+			answer, err := remote.CreateAnswer(nil)
+			if err != nil {
+				t.Fatalf("Failed to create answer: %v", err)
+			}
+			if err := remote.SetLocalDescription(answer); err != nil {
+				t.Fatalf("Failed to set local description: %v", err)
+			}
+			if err := p.ProcessSDPAnswer(answer.SDP); err != nil {
+				t.Fatalf("Failed to process answer: %v", err)
+			}
+
+			offered = webrtc_ext.PublishedTrackIDs(renegotiation.Offer.SDP)
+		case <-time.After(5 * time.Second):
+			t.Fatal("Expected the forwarded tracks to be offered")
+		}
+	}
+
+	for trackID, bitrate := range bitrates {
+		p.SetMaxBitrate(senders[trackID], bitrate)
+	}
+
+	// The hints are sent on the next negotiation, e.g. when the remote peer renegotiates.
+	offer, err := remote.CreateOffer(nil)
+	if err != nil {
+		t.Fatalf("Failed to create offer: %v", err)
+	}
+	if err := remote.SetLocalDescription(offer); err != nil {
+		t.Fatalf("Failed to set local description: %v", err)
+	}
+	answer, err := p.ProcessSDPOffer(offer.SDP)
+	if err != nil {
+		t.Fatalf("Failed to process offer: %v", err)
+	}
+
+	for _, section := range strings.Split(answer.SDP, "\r\nm=")[1:] {
+		for trackID, bitrate := range bitrates {
+			if !strings.Contains(section, "a=msid:"+trackID+" "+trackID) {
+				continue
+			}
+
+			expected := fmt.Sprintf("\r\nb=TIAS:%d\r\n", bitrate)
+			if !strings.Contains(section, expected) {
+				t.Errorf("Expected the section of %s to contain %q, got %q", trackID, expected, section)
+			}
+
+			delete(bitrates, trackID)
+		}
+	}
+
+	if len(bitrates) != 0 {
+		t.Errorf("Expected the answer to contain the forwarded tracks %v", bitrates)
+	}
+}
+
+func TestCodecPreferences(t *testing.T) {
+	remote, err := webrtc.NewPeerConnection(webrtc.Configuration{})
+	if err != nil {
+		t.Fatalf("Failed to create remote peer connection: %v", err)
+	}
+	t.Cleanup(func() { remote.Close() })
+
+	if _, err := remote.AddTransceiverFromKind(webrtc.RTPCodecTypeVideo); err != nil {
+		t.Fatalf("Failed to add video transceiver: %v", err)
+	}
+
+	_, answer, _ := newTestPeerForRemote(t, remote, webrtc_ext.Config{}, peer.Config{
+		CodecPreferences: []string{webrtc.MimeTypeVP9},
+	})
+
+	// The remote peer offers VP8 first, but the answer must put the preferred codec first.
+	codecs := webrtc_ext.Codecs(answer.SDP, webrtc.RTPCodecTypeVideo)
+	if len(codecs) == 0 || !strings.EqualFold(codecs[0].MimeType, webrtc.MimeTypeVP9) {
+		t.Fatalf("Expected VP9 to be the first answered codec, got %+v", codecs)
+	}
+
+	found := false
+	for _, codec := range codecs {
+		found = found || strings.EqualFold(codec.MimeType, webrtc.MimeTypeVP8)
+	}
+	if !found {
+		t.Errorf("Expected VP8 to still be answered, got %+v", codecs)
+	}
+}
+
+// Waits for a message of a given type that matches a given condition, skipping all other messages.
+func waitForMessage[T peer.MessageContent](
+	t *testing.T,
+	messages <-chan channel.Message[string, peer.MessageContent],
+	matches func(T) bool,
+) T {
+	t.Helper()
+
+	for {
+		select {
+		case msg := <-messages:
+			if content, ok := msg.Content.(T); ok && matches(content) {
+				return content
+			}
+		case <-time.After(5 * time.Second):
+			var expected T
+			t.Fatalf("Expected %T", expected)
+		}
+	}
+}
+
+func TestLabeledDataChannels(t *testing.T) {
+	remote, err := webrtc.NewPeerConnection(webrtc.Configuration{})
+	if err != nil {
+		t.Fatalf("Failed to create remote peer connection: %v", err)
+	}
+	t.Cleanup(func() { remote.Close() })
+
+	// The first data channel ("data") becomes the default one.
+	data, err := remote.CreateDataChannel("data", nil)
+	if err != nil {
+		t.Fatalf("Failed to create data channel: %v", err)
+	}
+
+	p, _, messages := connectTestPeer(t, remote, peer.Config{})
+	waitForMessage(t, messages, func(msg peer.DataChannelAvailable) bool { return msg.Label == "data" && msg.Default })
+
+	// An empty label is a label like any other, it must not be mistaken for the default data channel.
+	for _, label := range []string{"control", ""} {
+		dc, err := remote.CreateDataChannel(label, nil)
+		if err != nil {
+			t.Fatalf("Failed to create %q data channel: %v", label, err)
+		}
+		waitForMessage(t, messages, func(msg peer.DataChannelAvailable) bool { return msg.Label == label && !msg.Default })
+
+		received := make(chan string, 1)
+		dc.OnMessage(func(msg webrtc.DataChannelMessage) { received <- string(msg.Data) })
+
+		if err := p.SendOverDataChannel(label, "hello "+label); err != nil {
+			t.Fatalf("Failed to send over %q data channel: %v", label, err)
+		}
+		select {
+		case msg := <-received:
+			if msg != "hello "+label {
+				t.Errorf("Expected the message for %q, got %q", label, msg)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("Expected the message to arrive over %q data channel", label)
+		}
+	}
+
+	if label, found := p.DefaultDataChannelLabel(); !found || label != "data" {
+		t.Fatalf("Expected %q to stay the default data channel, got %q", "data", label)
+	}
+
+	// Once the default data channel is closed, the oldest remaining one takes over.
+	if err := data.Close(); err != nil {
+		t.Fatalf("Failed to close the default data channel: %v", err)
+	}
+
+	closed := waitForMessage(t, messages, func(msg peer.DataChannelClosed) bool { return msg.Label == "data" })
+	if !closed.Default || !closed.Replaced {
+		t.Errorf("Expected the default data channel to be replaced, got %+v", closed)
+	}
+
+	waitForMessage(t, messages, func(msg peer.DataChannelAvailable) bool { return msg.Label == "control" && msg.Default })
+	if label, found := p.DefaultDataChannelLabel(); !found || label != "control" {
+		t.Errorf("Expected %q to become the default data channel, got %q", "control", label)
+	}
+}
+
+func TestIncomingBitrateIsLimited(t *testing.T) {
+	const maxBitrate = 300_000
+
+	remote, err := webrtc.NewPeerConnection(webrtc.Configuration{})
+	if err != nil {
+		t.Fatalf("Failed to create remote peer connection: %v", err)
+	}
+	t.Cleanup(func() { remote.Close() })
+
+	track, err := webrtc.NewTrackLocalStaticRTP(webrtc.RTPCodecCapability{MimeType: webrtc.MimeTypeVP8}, "camera", "stream")
+	if err != nil {
+		t.Fatalf("Failed to create track: %v", err)
+	}
+
+	sender, err := remote.AddTrack(track)
+	if err != nil {
+		t.Fatalf("Failed to add track: %v", err)
+	}
+
+	// Collect the bitrate limits that the remote peer is told about.
+	limits := make(chan float32, 10)
+	go func() {
+		for {
+			packets, _, err := sender.ReadRTCP()
+			if err != nil {
+				return
+			}
+
+			for _, packet := range packets {
+				if remb, ok := packet.(*rtcp.ReceiverEstimatedMaximumBitrate); ok {
+					select {
+					case limits <- remb.Bitrate:
+					default:
+					}
+				}
+			}
+		}
+	}()
+
+	newConnectedTestPeer(t, remote, peer.Config{MaxIncomingBitrate: maxBitrate})
+
+	// The remote peer publishes the video, so that there is something to limit.
+	stop := make(chan struct{})
+	defer close(stop)
+	go func() {
+		ticker := time.NewTicker(20 * time.Millisecond)
+		defer ticker.Stop()
+
+		for sequence := uint16(0); ; sequence++ {
+			select {
+			case <-stop:
+				return
+			case <-ticker.C:
+				packet := &rtp.Packet{
+					Header:  rtp.Header{Version: 2, SequenceNumber: sequence, Timestamp: uint32(sequence) * 1800},
+					Payload: []byte{0x10, 0x00, 0x00, 0x00},
+				}
+				if err := track.WriteRTP(packet); err != nil {
+					return
+				}
+			}
+		}
+	}()
+
+	select {
+	case bitrate := <-limits:
+		// REMB encodes the bitrate with a mantissa and exponent, so small rounding errors are expected.
+		if bitrate < maxBitrate*0.99 || bitrate > maxBitrate*1.01 {
+			t.Errorf("Expected the bitrate to be limited to %d bps, got %f", maxBitrate, bitrate)
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatal("Expected the peer to send REMB to the remote peer")
+	}
+}
@@ -0,0 +1,99 @@
+package peer //nolint:testpackage
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/matrix-org/waterfall/pkg/peer/state"
+	"github.com/pion/webrtc/v3"
+	"github.com/sirupsen/logrus"
+)
+
+// Creates a peer whose connection is not connected to anything, which is enough to manage its senders.
+func newSendersTestPeer(t *testing.T) *Peer[string] {
+	t.Helper()
+
+	peerConnection, err := webrtc.NewPeerConnection(webrtc.Configuration{})
+	if err != nil {
+		t.Fatalf("Failed to create peer connection: %v", err)
+	}
+	t.Cleanup(func() { peerConnection.Close() })
+
+	return &Peer[string]{
+		logger:         logrus.NewEntry(logrus.New()),
+		peerConnection: peerConnection,
+		state:          state.NewPeerState(),
+	}
+}
+
+// Counts the senders that still have a track attached, i.e. the ones that are advertised in the SDP.
+func attachedSenders(p *Peer[string]) int {
+	attached := 0
+	for _, sender := range p.peerConnection.GetSenders() {
+		if sender.Track() != nil {
+			attached++
+		}
+	}
+
+	return attached
+}
+
+func TestSendersAfterChurn(t *testing.T) {
+	p := newSendersTestPeer(t)
+
+	addTrack := func(id string) *webrtc.RTPSender {
+		track, err := webrtc.NewTrackLocalStaticRTP(webrtc.RTPCodecCapability{MimeType: webrtc.MimeTypeVP8}, id, "stream")
+		if err != nil {
+			t.Fatalf("Failed to create track: %v", err)
+		}
+
+		sender, err := p.AddTrack(track)
+		if err != nil {
+			t.Fatalf("Failed to add track: %v", err)
+		}
+
+		return sender
+	}
+
+	// Each round subscribes to 3 tracks, then unsubscribes from one of them and detaches another one.
+	active := []*webrtc.RTPSender{}
+	detached := []*webrtc.RTPSender{}
+	for round := 0; round < 10; round++ {
+		removedSender := addTrack(fmt.Sprintf("removed-%d", round))
+		detachedSender := addTrack(fmt.Sprintf("detached-%d", round))
+		active = append(active, addTrack(fmt.Sprintf("active-%d", round)))
+
+		if err := p.RemoveTrack(removedSender); err != nil {
+			t.Fatalf("Failed to remove track: %v", err)
+		}
+		if err := p.DetachTrack(detachedSender); err != nil {
+			t.Fatalf("Failed to detach track: %v", err)
+		}
+		detached = append(detached, detachedSender)
+	}
+
+	if senders := p.ActiveSenders(); senders != len(active) {
+		t.Errorf("Expected %d active senders, got %d", len(active), senders)
+	}
+
+	// The detached senders stay in the SDP until the next renegotiation.
+	if attached := attachedSenders(p); attached != len(active)+len(detached) {
+		t.Errorf("Expected %d attached senders before pruning, got %d", len(active)+len(detached), attached)
+	}
+
+	p.pruneSenders()
+
+	if attached := attachedSenders(p); attached != len(active) {
+		t.Errorf("Expected %d attached senders after pruning, got %d", len(active), attached)
+	}
+	for _, sender := range detached {
+		if sender.Track() != nil {
+			t.Errorf("Expected the detached sender of %s to be pruned", sender.Track().ID())
+		}
+	}
+	for _, sender := range active {
+		if sender.Track() == nil {
+			t.Error("Expected the active sender to keep its track")
+		}
+	}
+}
@@ -7,24 +7,227 @@ import (
 )
 
 type PeerState struct {
-	mutex       sync.Mutex
-	dataChannel *webrtc.DataChannel
+	mutex sync.Mutex
+	// Data channels in the order in which the remote peer has opened them, their labels are unique.
+	dataChannels []*webrtc.DataChannel
+	// The data channel that is used when no label is specified: the first one that has been opened by the
+	// remote peer or, once it's closed, the oldest one that is still open (`nil` if there are none).
+	defaultDataChannel *webrtc.DataChannel
+	// Senders that we've added and that have not been removed yet along with their SSRCs.
+	senders map[*webrtc.RTPSender]webrtc.SSRC
+	// SSRCs of the senders, used to guarantee that each sender has a unique SSRC.
+	ssrcs map[webrtc.SSRC]*webrtc.RTPSender
+	// The maximum bitrates (in bits per second) of the senders that are hinted to the remote peer.
+	maxBitrates map[*webrtc.RTPSender]uint64
+	// Senders that have been added since the last offer, i.e. the ones that the remote peer does not know about.
+	unnegotiated map[*webrtc.RTPSender]struct{}
+	// Whether the peer connection has been connected at least once.
+	connected bool
+	// Whether a renegotiation has been deferred until the peer connection gets connected.
+	renegotiationDeferred bool
 }
 
 func NewPeerState() *PeerState {
-	return &PeerState{}
+	return &PeerState{
+		dataChannels: []*webrtc.DataChannel{},
+		senders:      make(map[*webrtc.RTPSender]webrtc.SSRC),
+		ssrcs:        make(map[webrtc.SSRC]*webrtc.RTPSender),
+		maxBitrates:  make(map[*webrtc.RTPSender]uint64),
+		unnegotiated: make(map[*webrtc.RTPSender]struct{}),
+	}
 }
 
-func (p *PeerState) SetDataChannel(dc *webrtc.DataChannel) {
+// Remembers a sender that has been added to the peer connection. Returns `false` if
+// the SSRC of the sender is already used by another sender.
+func (p *PeerState) AddSender(sender *webrtc.RTPSender, ssrc webrtc.SSRC) bool {
 	p.mutex.Lock()
 	defer p.mutex.Unlock()
 
-	p.dataChannel = dc
+	if existing, found := p.ssrcs[ssrc]; found && existing != sender {
+		return false
+	}
+
+	p.senders[sender] = ssrc
+	p.ssrcs[ssrc] = sender
+	p.unnegotiated[sender] = struct{}{}
+	return true
+}
+
+// Forgets a sender that has been removed from the peer connection.
+func (p *PeerState) RemoveSender(sender *webrtc.RTPSender) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	if ssrc, found := p.senders[sender]; found {
+		delete(p.ssrcs, ssrc)
+		delete(p.senders, sender)
+		delete(p.maxBitrates, sender)
+		delete(p.unnegotiated, sender)
+	}
+}
+
+// Returns the senders that have been added since the last call and forgets them, i.e. the ones that
+// are not part of any offer yet. Called each time an offer is created (or fails to be created).
+func (p *PeerState) TakeUnnegotiatedSenders() []*webrtc.RTPSender {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	senders := make([]*webrtc.RTPSender, 0, len(p.unnegotiated))
+	for sender := range p.unnegotiated {
+		senders = append(senders, sender)
+	}
+
+	p.unnegotiated = make(map[*webrtc.RTPSender]struct{})
+	return senders
+}
+
+// Sets the maximum bitrate of a sender that is still in use (0 removes it).
+func (p *PeerState) SetMaxBitrate(sender *webrtc.RTPSender, bitrate uint64) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	if _, found := p.senders[sender]; !found {
+		return
+	}
+
+	if bitrate == 0 {
+		delete(p.maxBitrates, sender)
+	} else {
+		p.maxBitrates[sender] = bitrate
+	}
+}
+
+// Returns the maximum bitrate of a sender (0 if none).
+func (p *PeerState) MaxBitrate(sender *webrtc.RTPSender) uint64 {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	return p.maxBitrates[sender]
+}
+
+// Checks if a given sender is still in use.
+func (p *PeerState) HasSender(sender *webrtc.RTPSender) bool {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	_, found := p.senders[sender]
+	return found
+}
+
+// Returns the amount of senders that are still in use.
+func (p *PeerState) SenderCount() int {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	return len(p.senders)
+}
+
+// Adds a data channel with a given label. Returns `false` if the channel with such label already exists.
+func (p *PeerState) AddDataChannel(dc *webrtc.DataChannel) bool {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	if p.findDataChannel(dc.Label()) != nil {
+		return false
+	}
+
+	// The first data channel (or the first one after all others got closed) becomes the default one.
+	if p.defaultDataChannel == nil {
+		p.defaultDataChannel = dc
+	}
+
+	p.dataChannels = append(p.dataChannels, dc)
+	return true
+}
+
+// Removes a data channel with a given label. If it was the default data channel, the oldest remaining
+// data channel becomes the default one. Returns whether the removed channel was the default one and the
+// newly elected default data channel (`nil` if none has been elected).
+func (p *PeerState) RemoveDataChannel(label string) (bool, *webrtc.DataChannel) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	removed := p.findDataChannel(label)
+	if removed == nil {
+		return false, nil
+	}
+
+	remaining := []*webrtc.DataChannel{}
+	for _, dc := range p.dataChannels {
+		if dc != removed {
+			remaining = append(remaining, dc)
+		}
+	}
+	p.dataChannels = remaining
+
+	if removed != p.defaultDataChannel {
+		return false, nil
+	}
+
+	p.defaultDataChannel = nil
+	if len(remaining) != 0 {
+		p.defaultDataChannel = remaining[0]
+	}
+
+	return true, p.defaultDataChannel
+}
+
+// Gets a data channel by its label (`nil` if there is no such channel).
+func (p *PeerState) GetDataChannel(label string) *webrtc.DataChannel {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	return p.findDataChannel(label)
+}
+
+// Gets the default data channel (`nil` if there are no data channels).
+func (p *PeerState) GetDefaultDataChannel() *webrtc.DataChannel {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	return p.defaultDataChannel
+}
+
+// Checks if a given label belongs to the default data channel.
+func (p *PeerState) IsDefaultDataChannel(label string) bool {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	return p.defaultDataChannel != nil && p.defaultDataChannel.Label() == label
+}
+
+func (p *PeerState) findDataChannel(label string) *webrtc.DataChannel {
+	for _, dc := range p.dataChannels {
+		if dc.Label() == label {
+			return dc
+		}
+	}
+
+	return nil
+}
+
+// Defers the renegotiation if the peer connection has never been connected so far.
+// Returns `false` if the renegotiation may take place right away.
+func (p *PeerState) DeferRenegotiation() bool {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	if p.connected {
+		return false
+	}
+
+	p.renegotiationDeferred = true
+	return true
 }
 
-func (p *PeerState) GetDataChannel() *webrtc.DataChannel {
+// Marks the peer connection as connected. Returns `true` if this is the first time that the
+// peer connection got connected and a renegotiation has been deferred until then.
+func (p *PeerState) SetConnected() bool {
 	p.mutex.Lock()
 	defer p.mutex.Unlock()
 
-	return p.dataChannel
+	deferred := !p.connected && p.renegotiationDeferred
+	p.connected = true
+	p.renegotiationDeferred = false
+	return deferred
 }
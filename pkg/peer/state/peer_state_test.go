@@ -0,0 +1,118 @@
+package state_test
+
+import (
+	"testing"
+
+	"github.com/matrix-org/waterfall/pkg/peer/state"
+	"github.com/pion/webrtc/v3"
+)
+
+func TestSenderSSRCCollision(t *testing.T) {
+	peerConnection, err := webrtc.NewPeerConnection(webrtc.Configuration{})
+	if err != nil {
+		t.Fatalf("Failed to create peer connection: %v", err)
+	}
+	defer peerConnection.Close()
+
+	newSender := func() *webrtc.RTPSender {
+		track, err := webrtc.NewTrackLocalStaticRTP(webrtc.RTPCodecCapability{MimeType: webrtc.MimeTypeVP8}, "track", "stream")
+		if err != nil {
+			t.Fatalf("Failed to create track: %v", err)
+		}
+
+		sender, err := peerConnection.AddTrack(track)
+		if err != nil {
+			t.Fatalf("Failed to add track: %v", err)
+		}
+
+		return sender
+	}
+
+	peerState := state.NewPeerState()
+	first, second := newSender(), newSender()
+
+	if !peerState.AddSender(first, 1234) {
+		t.Fatal("Expected the first sender to be added")
+	}
+
+	if peerState.AddSender(second, 1234) {
+		t.Fatal("Expected the sender with a colliding SSRC to be rejected")
+	}
+
+	// Once the first sender is gone, its SSRC can be used again.
+	peerState.RemoveSender(first)
+	if !peerState.AddSender(second, 1234) {
+		t.Fatal("Expected the SSRC to be available after removing the sender")
+	}
+
+	if peerState.SenderCount() != 1 {
+		t.Errorf("Expected 1 sender, got %d", peerState.SenderCount())
+	}
+
+	// The removed sender does not need to be negotiated anymore.
+	if unnegotiated := peerState.TakeUnnegotiatedSenders(); len(unnegotiated) != 1 || unnegotiated[0] != second {
+		t.Errorf("Expected only the second sender to be unnegotiated, got %v", unnegotiated)
+	}
+
+	if unnegotiated := peerState.TakeUnnegotiatedSenders(); len(unnegotiated) != 0 {
+		t.Errorf("Expected the unnegotiated senders to be forgotten, got %v", unnegotiated)
+	}
+}
+
+func TestDefaultDataChannel(t *testing.T) {
+	peerConnection, err := webrtc.NewPeerConnection(webrtc.Configuration{})
+	if err != nil {
+		t.Fatalf("Failed to create peer connection: %v", err)
+	}
+	defer peerConnection.Close()
+
+	newDataChannel := func(label string) *webrtc.DataChannel {
+		dc, err := peerConnection.CreateDataChannel(label, nil)
+		if err != nil {
+			t.Fatalf("Failed to create data channel: %v", err)
+		}
+
+		return dc
+	}
+
+	peerState := state.NewPeerState()
+	control, unlabeled, custom := newDataChannel("control"), newDataChannel(""), newDataChannel("custom")
+	for _, dc := range []*webrtc.DataChannel{control, unlabeled, custom} {
+		if !peerState.AddDataChannel(dc) {
+			t.Fatalf("Expected %q data channel to be added", dc.Label())
+		}
+	}
+
+	if peerState.AddDataChannel(newDataChannel("control")) {
+		t.Error("Expected the data channel with a duplicate label to be rejected")
+	}
+
+	// The empty label is not special, the first data channel is the default one.
+	if peerState.GetDefaultDataChannel() != control || !peerState.IsDefaultDataChannel("control") {
+		t.Errorf("Expected the first data channel to be the default one")
+	}
+	if peerState.GetDataChannel("") != unlabeled || peerState.IsDefaultDataChannel("") {
+		t.Errorf("Expected the data channel with an empty label not to be the default one")
+	}
+
+	// Closing another data channel does not change the default one.
+	if wasDefault, elected := peerState.RemoveDataChannel("custom"); wasDefault || elected != nil {
+		t.Errorf("Expected the default data channel to stay, got %v and %v", wasDefault, elected)
+	}
+
+	// Once the default data channel is gone, the oldest remaining one takes over.
+	if wasDefault, elected := peerState.RemoveDataChannel("control"); !wasDefault || elected != unlabeled {
+		t.Errorf("Expected the unlabeled data channel to take over, got %v and %v", wasDefault, elected)
+	}
+	if peerState.GetDefaultDataChannel() != unlabeled || !peerState.IsDefaultDataChannel("") {
+		t.Errorf("Expected the unlabeled data channel to be the default one")
+	}
+
+	// Without data channels, there is no default one.
+	if wasDefault, elected := peerState.RemoveDataChannel(""); !wasDefault || elected != nil {
+		t.Errorf("Expected no data channel to take over, got %v and %v", wasDefault, elected)
+	}
+	if peerState.GetDefaultDataChannel() != nil || peerState.IsDefaultDataChannel("") {
+		t.Errorf("Expected no default data channel")
+	}
+}
@@ -1,6 +1,7 @@
 package peer
 
 import (
+	"github.com/matrix-org/waterfall/pkg/webrtc_ext"
 	"github.com/pion/webrtc/v3"
 	"maunium.net/go/mautrix/event"
 )
@@ -9,7 +10,15 @@ import (
 // we call this function each time a new track is received.
 func (p *Peer[ID]) onRtpTrackReceived(remoteTrack *webrtc.TrackRemote, receiver *webrtc.RTPReceiver) {
 	p.logger.WithField("track", remoteTrack).Debug("RTP track received")
-	p.sink.Send(NewTrackPublished{remoteTrack})
+
+	audioLevelExtension := uint8(0)
+	for _, extension := range receiver.GetParameters().HeaderExtensions {
+		if extension.URI == webrtc_ext.AudioLevelURI {
+			audioLevelExtension = uint8(extension.ID)
+		}
+	}
+
+	p.sink.Send(NewTrackPublished{RemoteTrack: remoteTrack, AudioLevelExtension: audioLevelExtension})
 }
 
 // A callback that is called once we receive an ICE candidate for this peer connection.
@@ -21,24 +30,65 @@ func (p *Peer[ID]) onICECandidateGathered(candidate *webrtc.ICECandidate) {
 	}
 
 	p.logger.WithField("candidate", candidate).Debug("ICE candidate gathered")
-	p.sink.Send(NewICECandidate{Candidate: candidate})
+
+	// Without trickle ICE, the candidates are sent as part of the SDP.
+	if p.config.DisableTrickleICE {
+		return
+	}
+
+	filtered, ok := p.candidateFilter.Apply(*candidate)
+	if !ok {
+		p.logger.WithField("candidate", candidate).Debug("ICE candidate filtered out")
+		return
+	}
+
+	p.sink.Send(NewICECandidate{Candidate: &filtered})
 }
 
 // A callback that is called when a change has been made that requires renegotiation.
 func (p *Peer[ID]) onNegotiationNeeded() {
 	p.logger.Debug("negotiation needed")
+
+	// The clients are confused by the offers that arrive before the connection is established.
+	if p.config.DeferRenegotiationUntilConnected && p.state.DeferRenegotiation() {
+		p.logger.Debug("renegotiation deferred until connected")
+		return
+	}
+
+	// Make sure that we don't advertise the tracks that are not used anymore.
+	p.pruneSenders()
+
+	// The senders added since the last offer are either carried by this one or never reach the remote peer.
+	unnegotiated := p.state.TakeUnnegotiatedSenders()
+
 	offer, err := p.peerConnection.CreateOffer(nil)
 	if err != nil {
 		p.logger.WithError(err).Error("failed to create offer")
+		p.renegotiationFailed(unnegotiated, err)
 		return
 	}
 
-	if err := p.peerConnection.SetLocalDescription(offer); err != nil {
-		p.logger.WithError(err).Error("failed to set local description")
+	if err := p.setLocalDescription(offer); err != nil {
+		p.renegotiationFailed(unnegotiated, err)
 		return
 	}
 
-	p.sink.Send(RenegotiationRequired{Offer: &offer})
+	p.sink.Send(RenegotiationRequired{Offer: p.localDescription()})
+}
+
+// Informs the conference about the tracks that could not be negotiated, so that their subscriptions
+// are rolled back. Otherwise, their senders would stay attached without the remote peer ever getting them.
+func (p *Peer[ID]) renegotiationFailed(unnegotiated []*webrtc.RTPSender, err error) {
+	trackIDs := []string{}
+	for _, sender := range unnegotiated {
+		if track := sender.Track(); track != nil && p.state.HasSender(sender) {
+			trackIDs = append(trackIDs, track.ID())
+		}
+	}
+
+	if len(trackIDs) > 0 {
+		p.sink.Send(RenegotiationFailed{TrackIDs: trackIDs, Err: err})
+	}
 }
 
 // A callback that is called once we receive an ICE connection state change for this peer connection.
@@ -71,40 +121,70 @@ func (p *Peer[ID]) onConnectionStateChanged(state webrtc.PeerConnectionState) {
 	case webrtc.PeerConnectionStateFailed, webrtc.PeerConnectionStateClosed:
 		p.sink.Send(LeftTheCall{event.CallHangupUserHangup})
 	case webrtc.PeerConnectionStateConnected:
-		p.sink.Send(JoinedTheCall{})
+		pair, err := p.selectedCandidatePair()
+		if err != nil {
+			p.logger.WithError(err).Warn("failed to get the selected candidate pair")
+		}
+		p.sink.Send(JoinedTheCall{CandidatePair: pair})
+
+		if p.state.SetConnected() {
+			p.logger.Debug("performing the deferred renegotiation")
+			p.onNegotiationNeeded()
+		}
 	}
 }
 
 // A callback that is called once the data channel is ready to be used.
+// The remote peer may open multiple data channels, they are distinguished by their labels.
 func (p *Peer[ID]) onDataChannelReady(dc *webrtc.DataChannel) {
-	if dataChannel := p.state.GetDataChannel(); dataChannel != nil {
-		p.logger.Error("Data channel already exists")
-		dataChannel.Close()
-		p.state.SetDataChannel(nil)
+	label := dc.Label()
+	logger := p.logger.WithField("label", label)
+
+	if !p.state.AddDataChannel(dc) {
+		logger.Error("Data channel with such label already exists")
+		dc.Close()
 		return
 	}
 
-	p.state.SetDataChannel(dc)
-	p.logger.WithField("label", dc.Label()).Debug("Data channel ready")
+	logger.Debug("Data channel ready")
+
+	// The data channels are created by the remote peer, so we can't choose how they're negotiated. Our
+	// messages (e.g. the metadata) are only consistent if they're delivered reliably and in order.
+	if !isOrderedAndReliable(dc) {
+		logger.Warn("Data channel is unordered or unreliable, the messages may be lost or arrive out of order")
+	}
 
 	dc.OnOpen(func() {
-		p.logger.Debug("Data channel opened")
-		p.sink.Send(DataChannelAvailable{})
+		logger.Debug("Data channel opened")
+		p.sink.Send(DataChannelAvailable{Label: label, Default: p.state.IsDefaultDataChannel(label)})
 	})
 
 	dc.OnMessage(func(msg webrtc.DataChannelMessage) {
 		if msg.IsString {
-			p.sink.Send(DataChannelMessage{Message: string(msg.Data)})
+			p.sink.Send(DataChannelMessage{Label: label, Message: string(msg.Data)})
 		} else {
-			p.logger.Warn("Data channel message is not a string, ignoring")
+			logger.Warn("Data channel message is not a string, ignoring")
 		}
 	})
 
 	dc.OnError(func(err error) {
-		p.logger.WithError(err).Error("Data channel error")
+		logger.WithError(err).Error("Data channel error")
 	})
 
 	dc.OnClose(func() {
-		p.logger.Info("Data channel closed")
+		logger.Info("Data channel closed")
+		isDefault, elected := p.state.RemoveDataChannel(label)
+		p.sink.Send(DataChannelClosed{Label: label, Default: isDefault, Replaced: elected != nil})
+
+		// The channel that took over is announced as the default one right away if it's already open,
+		// otherwise it's announced once it opens.
+		if elected != nil && elected.ReadyState() == webrtc.DataChannelStateOpen {
+			logger.WithField("default", elected.Label()).Info("Another data channel became the default one")
+			p.sink.Send(DataChannelAvailable{Label: elected.Label(), Default: true})
+		}
 	})
 }
+
+func isOrderedAndReliable(dc *webrtc.DataChannel) bool {
+	return dc.Ordered() && dc.MaxRetransmits() == nil && dc.MaxPacketLifeTime() == nil
+}
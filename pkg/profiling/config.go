@@ -0,0 +1,8 @@
+package profiling
+
+type Config struct {
+	// The address (e.g. `localhost:6060`) to serve the `net/http/pprof` endpoints on
+	// (at `/debug/pprof/`). Disabled if empty. The endpoints expose the internals of
+	// the SFU, so they must never be reachable from the outside.
+	Address string `yaml:"pprofAddress"`
+}
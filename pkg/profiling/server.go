@@ -0,0 +1,31 @@
+package profiling
+
+import (
+	"net/http"
+	"net/http/pprof"
+
+	"github.com/sirupsen/logrus"
+)
+
+// Starts serving the pprof endpoints if the address is configured.
+func ServePprof(config Config) {
+	if config.Address == "" {
+		return
+	}
+
+	// Don't use the `http.DefaultServeMux` (that `net/http/pprof` registers itself on), so
+	// that the endpoints are only available on the configured address.
+	mux := http.NewServeMux()
+	mux.HandleFunc("/debug/pprof/", pprof.Index)
+	mux.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+	mux.HandleFunc("/debug/pprof/profile", pprof.Profile)
+	mux.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+	mux.HandleFunc("/debug/pprof/trace", pprof.Trace)
+
+	go func() {
+		logrus.WithField("address", config.Address).Warn("serving pprof endpoints")
+		if err := http.ListenAndServe(config.Address, mux); err != nil { //nolint:gosec
+			logrus.WithError(err).Error("pprof server stopped")
+		}
+	}()
+}
@@ -0,0 +1,43 @@
+package routing
+
+import (
+	"github.com/matrix-org/waterfall/pkg/signaling"
+	"github.com/sirupsen/logrus"
+	"maunium.net/go/mautrix/event"
+)
+
+// Sent to the participants that try to start a new conference on a draining SFU, so that they retry elsewhere.
+const hangupDraining event.CallHangupReason = "draining"
+
+// Sent by the admin API to stop (or resume) accepting new conferences, e.g. before a rolling deploy.
+// The running conferences are not affected and still accept new participants.
+type DrainRequested struct {
+	// Whether the SFU must drain, the current state is only queried if `nil`.
+	Drain *bool
+	// Receives the outcome of the request, must be buffered.
+	Result chan<- DrainResult
+}
+
+type DrainResult struct {
+	Draining bool
+	Err      error
+}
+
+func (r DrainRequested) Fail(err error) {
+	r.Result <- DrainResult{Err: err}
+}
+
+func (r *Router) handleDrainRequest(request DrainRequested) {
+	if request.Drain != nil && *request.Drain != r.draining {
+		r.draining = *request.Drain
+		logrus.WithField("draining", r.draining).Warn("drain mode changed")
+	}
+
+	request.Result <- DrainResult{Draining: r.draining}
+}
+
+// Hangs up on the participant that tried to start a new conference while the SFU is draining.
+func (r *Router) rejectWhileDraining(conferenceID string, recipient signaling.MatrixRecipient, logger *logrus.Entry) {
+	logger.Warnf("rejecting new conference %s since the SFU is draining", conferenceID)
+	r.hangUp(conferenceID, recipient, hangupDraining, logger)
+}
@@ -17,9 +17,12 @@ limitations under the License.
 package routing
 
 import (
+	"fmt"
+
 	conf "github.com/matrix-org/waterfall/pkg/conference"
 	"github.com/matrix-org/waterfall/pkg/conference/participant"
 	"github.com/matrix-org/waterfall/pkg/signaling"
+	"github.com/matrix-org/waterfall/pkg/webhook"
 	"github.com/matrix-org/waterfall/pkg/webrtc_ext"
 	"github.com/sirupsen/logrus"
 	"maunium.net/go/mautrix/event"
@@ -29,13 +32,21 @@ import (
 // The top-level state of the Router.
 type Router struct {
 	// Matrix matrix.
-	matrix *signaling.MatrixClient
+	matrix signaling.MatrixSignaling
 	// Sinks of all conferences (all calls that are currently forwarded by this SFU).
 	conferenceSinks map[string]*conferenceStage
 	// Configuration for the calls.
 	config conf.Config
 	// Channel for reading incoming Matrix SDK To-Device events and distributing them to the conferences.
 	matrixEvents <-chan *event.Event
+	// Channel for reading the reloaded configuration.
+	configUpdates <-chan conf.Config
+	// Channel for reading the requests of the admin API.
+	adminRequests <-chan AdminRequest
+	// Notifier for the conference events.
+	webhooks *webhook.Notifier
+	// Whether the new conferences are refused (the running ones are kept), see `DrainRequested`.
+	draining bool
 	// Channel for handling conference ended events.
 	// Peer connection factory that can be used to create pre-configured peer connections.
 	connectionFactory *webrtc_ext.PeerConnectionFactory
@@ -43,9 +54,12 @@ type Router struct {
 
 // Creates a new instance of the SFU with the given configuration.
 func StartRouter(
-	matrix *signaling.MatrixClient,
+	matrix signaling.MatrixSignaling,
 	connectionFactory *webrtc_ext.PeerConnectionFactory,
 	matrixEvents <-chan *event.Event,
+	configUpdates <-chan conf.Config,
+	adminRequests <-chan AdminRequest,
+	webhooks *webhook.Notifier,
 	config conf.Config,
 ) {
 	router := &Router{
@@ -53,18 +67,79 @@ func StartRouter(
 		conferenceSinks:   make(map[string]*conferenceStage),
 		config:            config,
 		matrixEvents:      matrixEvents,
+		configUpdates:     configUpdates,
+		adminRequests:     adminRequests,
+		webhooks:          webhooks,
 		connectionFactory: connectionFactory,
 	}
 
 	// Start the main loop of the Router.
 	go func() {
-		for msg := range router.matrixEvents {
-			// To-Device message received from the remote peer.
-			router.handleMatrixEvent(msg)
+		for {
+			select {
+			case msg, ok := <-router.matrixEvents:
+				if !ok {
+					return
+				}
+				// To-Device message received from the remote peer.
+				router.handleMatrixEvent(msg)
+			case config, ok := <-router.configUpdates:
+				if !ok {
+					return
+				}
+				router.handleConfigUpdate(config)
+			case request, ok := <-router.adminRequests:
+				if !ok {
+					return
+				}
+				router.handleAdminRequest(request)
+			}
 		}
 	}()
 }
 
+// Stores the reloaded configuration for the new conferences and forwards it to the running ones.
+func (r *Router) handleConfigUpdate(config conf.Config) {
+	r.config = config
+
+	for conferenceID, conference := range r.conferenceSinks {
+		select {
+		case <-conference.done:
+			delete(r.conferenceSinks, conferenceID)
+			close(conference.sink)
+		case conference.sink <- conf.MatrixMessage{Content: conf.ConfigUpdated{Config: config}}:
+		}
+	}
+}
+
+// A request of the admin API that concerns a given conference (or the router itself, see `DrainRequested`).
+type AdminRequest struct {
+	ConferenceID string
+	Request      conf.AdminRequest
+}
+
+// Forwards the admin request to the conference or fails it if the conference is not running.
+func (r *Router) handleAdminRequest(request AdminRequest) {
+	// The drain requests concern the router itself rather than a particular conference.
+	if drain, ok := request.Request.(DrainRequested); ok {
+		r.handleDrainRequest(drain)
+		return
+	}
+
+	conference := r.conferenceSinks[request.ConferenceID]
+	if conference != nil {
+		select {
+		case <-conference.done:
+			delete(r.conferenceSinks, request.ConferenceID)
+			close(conference.sink)
+		case conference.sink <- conf.MatrixMessage{Content: request.Request}:
+			return
+		}
+	}
+
+	request.Request.Fail(fmt.Errorf("conference %s not found", request.ConferenceID))
+}
+
 // Handles incoming To-Device events that the SFU receives from clients.
 func (r *Router) handleMatrixEvent(evt *event.Event) {
 	var (
@@ -103,20 +178,47 @@ func (r *Router) handleMatrixEvent(evt *event.Event) {
 	// Only ToDeviceCallInvite events are allowed to create a new conference, others
 	// are expected to operate on an existing conference that is running on the SFU.
 	if conference == nil && evt.Type.Type == event.ToDeviceCallInvite.Type {
+		recipient := signaling.MatrixRecipient{
+			UserID:          userID,
+			DeviceID:        id.DeviceID(deviceID),
+			CallID:          callID,
+			RemoteSessionID: evt.Content.AsCallInvite().SenderSessionID,
+		}
+
+		if r.draining {
+			r.rejectWhileDraining(conferenceID, recipient, logger)
+			return
+		}
+
+		// The users that may not join don't get to start a conference either.
+		if !r.config.AccessControl.Allows(userID) {
+			logger.Warnf("rejecting new conference %s since the user is not allowed to join", conferenceID)
+			r.hangUp(conferenceID, recipient, conf.HangupNotAllowed, logger)
+			return
+		}
+
 		logger.Infof("creating new conference %s", conferenceID)
 
 		matrixEvents := make(chan conf.MatrixMessage)
 
+		// The invite may select a conference profile, otherwise the default one is used.
+		profileName, ok := evt.Content.Raw["profile"].(string)
+		if !ok || profileName == "" {
+			profileName = conf.DefaultProfileName
+		}
+
 		conferenceDone, err := conf.StartConference(
 			conferenceID,
 			r.config,
+			profileName,
 			r.connectionFactory,
 			r.matrix.CreateForConference(conferenceID),
+			r.webhooks,
 			matrixEvents,
 			userID,
 			evt.Content.AsCallInvite(),
 		)
-		if err != nil {
+		if err != nil || conferenceDone == nil {
 			logger.WithError(err).Errorf("failed to start conference %s", conferenceID)
 			return
 		}
@@ -146,6 +248,9 @@ func (r *Router) handleMatrixEvent(evt *event.Event) {
 	case event.ToDeviceCallSelectAnswer.Type:
 		// Someone informs us about them accepting our (SFU's) SDP answer for an existing call.
 		content = evt.Content.AsCallSelectAnswer()
+	case conf.ToDeviceCallAnswerRequest.Type:
+		// Someone has not received our (SFU's) SDP answer and asks for it again.
+		content = conf.AnswerRequested{}
 	case event.ToDeviceCallHangup.Type:
 		// Someone tries to inform us about leaving an existing call.
 		content = evt.Content.AsCallHangup()
@@ -169,6 +274,22 @@ func (r *Router) handleMatrixEvent(evt *event.Event) {
 	}
 }
 
+// Hangs up on the participant whose invite did not start a new conference.
+func (r *Router) hangUp(
+	conferenceID string,
+	recipient signaling.MatrixRecipient,
+	reason event.CallHangupReason,
+	logger *logrus.Entry,
+) {
+	signaler := r.matrix.CreateForConference(conferenceID)
+	go func() {
+		message := signaling.MatrixMessage{Recipient: recipient, Message: signaling.Hangup{Reason: reason}}
+		if err := signaler.SendMessage(message); err != nil {
+			logger.WithError(err).Error("failed to send hangup")
+		}
+	}()
+}
+
 type conferenceStage struct {
 	sink chan<- conf.MatrixMessage
 	done <-chan struct{}
@@ -0,0 +1,259 @@
+package routing_test
+
+import (
+	"testing"
+	"time"
+
+	conf "github.com/matrix-org/waterfall/pkg/conference"
+	"github.com/matrix-org/waterfall/pkg/routing"
+	"github.com/matrix-org/waterfall/pkg/signaling"
+	"github.com/matrix-org/waterfall/pkg/webrtc_ext"
+	"github.com/pion/webrtc/v3"
+	"maunium.net/go/mautrix/event"
+	"maunium.net/go/mautrix/id"
+)
+
+const conferenceID = "conference"
+
+// Starts a router that talks to the simulated clients over the in-memory signaling. Returns the loopback
+// signaling along with the channel for the requests of the admin API.
+func startLoopbackRouter(t *testing.T) (*signaling.Loopback, chan<- routing.AdminRequest) {
+	t.Helper()
+
+	return startLoopbackRouterWithConfig(t, conf.Config{HeartbeatConfig: conf.Heartbeat{Interval: 5, Timeout: 30}})
+}
+
+// Same as `startLoopbackRouter`, but with a given configuration.
+func startLoopbackRouterWithConfig(
+	t *testing.T,
+	config conf.Config,
+) (*signaling.Loopback, chan<- routing.AdminRequest) {
+	t.Helper()
+
+	factory, err := webrtc_ext.NewPeerConnectionFactory(webrtc_ext.Config{})
+	if err != nil {
+		t.Fatalf("Failed to create peer connection factory: %v", err)
+	}
+
+	matrixEvents := make(chan *event.Event)
+	configUpdates := make(chan conf.Config)
+	adminRequests := make(chan routing.AdminRequest)
+	t.Cleanup(func() { close(matrixEvents) })
+
+	loopback := signaling.NewLoopback("@sfu:example.org", "SFU", matrixEvents)
+	routing.StartRouter(loopback, factory, matrixEvents, configUpdates, adminRequests, nil, config)
+
+	return loopback, adminRequests
+}
+
+// Joins the conference with a simulated client and waits until its peer connection to the SFU is established.
+func join(t *testing.T, client *signaling.LoopbackClient) *webrtc.PeerConnection {
+	t.Helper()
+
+	peerConnection, err := webrtc.NewPeerConnection(webrtc.Configuration{})
+	if err != nil {
+		t.Fatalf("Failed to create peer connection: %v", err)
+	}
+	t.Cleanup(func() { peerConnection.Close() })
+
+	connected := make(chan struct{}, 1)
+	peerConnection.OnConnectionStateChange(func(state webrtc.PeerConnectionState) {
+		if state == webrtc.PeerConnectionStateConnected {
+			select {
+			case connected <- struct{}{}:
+			default:
+			}
+		}
+	})
+
+	if _, err := peerConnection.CreateDataChannel("data", nil); err != nil {
+		t.Fatalf("Failed to create data channel: %v", err)
+	}
+
+	// The client does not trickle, so the offer carries all of its candidates.
+	offer, err := peerConnection.CreateOffer(nil)
+	if err != nil {
+		t.Fatalf("Failed to create offer: %v", err)
+	}
+	gathered := webrtc.GatheringCompletePromise(peerConnection)
+	if err := peerConnection.SetLocalDescription(offer); err != nil {
+		t.Fatalf("Failed to set local description: %v", err)
+	}
+	<-gathered
+
+	base := event.BaseCallEventContent{
+		CallID:          "call",
+		ConfID:          conferenceID,
+		PartyID:         string(client.DeviceID),
+		Version:         event.CallVersion("1"),
+		DeviceID:        client.DeviceID,
+		DestSessionID:   signaling.LocalSessionID,
+		SenderSessionID: id.SessionID(client.DeviceID),
+	}
+
+	invite := &event.CallInviteEventContent{
+		BaseCallEventContent: base,
+		Lifetime:             30000,
+		Offer:                event.CallData{Type: "offer", SDP: peerConnection.LocalDescription().SDP},
+	}
+	if err := client.Send(event.ToDeviceCallInvite, invite); err != nil {
+		t.Fatalf("Failed to send invite: %v", err)
+	}
+
+	timeout := time.After(10 * time.Second)
+	for {
+		select {
+		case evt := <-client.Events():
+			switch evt.Type.Type {
+			case event.ToDeviceCallAnswer.Type:
+				answer := evt.Content.AsCallAnswer().Answer
+				if err := peerConnection.SetRemoteDescription(webrtc.SessionDescription{
+					Type: webrtc.SDPTypeAnswer,
+					SDP:  answer.SDP,
+				}); err != nil {
+					t.Fatalf("Failed to set remote description: %v", err)
+				}
+			case event.ToDeviceCallCandidates.Type:
+				for _, candidate := range evt.Content.AsCallCandidates().Candidates {
+					// The empty candidate marks the end of the candidates.
+					if candidate.Candidate == "" {
+						continue
+					}
+
+					mLineIndex := uint16(candidate.SDPMLineIndex)
+					if err := peerConnection.AddICECandidate(webrtc.ICECandidateInit{
+						Candidate:     candidate.Candidate,
+						SDPMid:        &candidate.SDPMID,
+						SDPMLineIndex: &mLineIndex,
+					}); err != nil {
+						t.Fatalf("Failed to add ICE candidate: %v", err)
+					}
+				}
+			case event.ToDeviceCallHangup.Type:
+				t.Fatalf("%s has been hung up on", client.UserID)
+			}
+		case <-connected:
+			return peerConnection
+		case <-timeout:
+			t.Fatalf("%s could not connect to the SFU", client.UserID)
+		}
+	}
+}
+
+func TestTwoParticipantsOverLoopback(t *testing.T) {
+	loopback, _ := startLoopbackRouter(t)
+
+	alice := loopback.NewClient("@alice:example.org", "ALICE")
+	bob := loopback.NewClient("@bob:example.org", "BOB")
+
+	// The first invite starts the conference, the second one joins the running conference.
+	join(t, alice)
+	join(t, bob)
+}
+
+func TestDrain(t *testing.T) {
+	loopback, adminRequests := startLoopbackRouter(t)
+
+	alice := loopback.NewClient("@alice:example.org", "ALICE")
+	bob := loopback.NewClient("@bob:example.org", "BOB")
+	carol := loopback.NewClient("@carol:example.org", "CAROL")
+
+	// The conference is started before the SFU drains.
+	join(t, alice)
+
+	drain := true
+	result := make(chan routing.DrainResult, 1)
+	adminRequests <- routing.AdminRequest{Request: routing.DrainRequested{Drain: &drain, Result: result}}
+	if outcome := <-result; outcome.Err != nil || !outcome.Draining {
+		t.Fatalf("Expected the SFU to drain, got %+v", outcome)
+	}
+
+	// The invite that would start a new conference is hung up on, so that the client retries elsewhere.
+	invite := &event.CallInviteEventContent{
+		BaseCallEventContent: event.BaseCallEventContent{
+			CallID:          "call",
+			ConfID:          "another conference",
+			PartyID:         string(carol.DeviceID),
+			Version:         event.CallVersion("1"),
+			DeviceID:        carol.DeviceID,
+			DestSessionID:   signaling.LocalSessionID,
+			SenderSessionID: id.SessionID(carol.DeviceID),
+		},
+		Lifetime: 30000,
+		Offer:    event.CallData{Type: "offer", SDP: "v=0"},
+	}
+	if err := carol.Send(event.ToDeviceCallInvite, invite); err != nil {
+		t.Fatalf("Failed to send invite: %v", err)
+	}
+
+	select {
+	case evt := <-carol.Events():
+		if evt.Type.Type != event.ToDeviceCallHangup.Type || evt.Content.AsCallHangup().Reason != "draining" {
+			t.Errorf("Expected a hangup because of draining, got %s", evt.Type.Type)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Expected the new conference to be rejected")
+	}
+
+	// The running conference still accepts new participants.
+	join(t, bob)
+}
+
+func TestDeniedUserDoesNotStartConference(t *testing.T) {
+	loopback, adminRequests := startLoopbackRouterWithConfig(t, conf.Config{
+		HeartbeatConfig: conf.Heartbeat{Interval: 5, Timeout: 30},
+		AccessControl:   conf.AccessControl{Deny: []string{"@mallory:*"}},
+	})
+
+	mallory := loopback.NewClient("@mallory:example.org", "MALLORY")
+	alice := loopback.NewClient("@alice:example.org", "ALICE")
+
+	base := event.BaseCallEventContent{
+		CallID:          "call",
+		ConfID:          conferenceID,
+		PartyID:         string(mallory.DeviceID),
+		Version:         event.CallVersion("1"),
+		DeviceID:        mallory.DeviceID,
+		DestSessionID:   signaling.LocalSessionID,
+		SenderSessionID: id.SessionID(mallory.DeviceID),
+	}
+
+	// The denied user is the first one to join, so the invite would start the conference.
+	invite := &event.CallInviteEventContent{
+		BaseCallEventContent: base,
+		Lifetime:             30000,
+		Offer:                event.CallData{Type: "offer", SDP: "v=0"},
+	}
+	if err := mallory.Send(event.ToDeviceCallInvite, invite); err != nil {
+		t.Fatalf("Failed to send invite: %v", err)
+	}
+
+	select {
+	case evt := <-mallory.Events():
+		if evt.Type.Type != event.ToDeviceCallHangup.Type || evt.Content.AsCallHangup().Reason != conf.HangupNotAllowed {
+			t.Errorf("Expected a hangup because the user is not allowed, got %s", evt.Type.Type)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Expected the denied user to be hung up on")
+	}
+
+	// The follow-up messages of the denied user must not block the router.
+	candidates := &event.CallCandidatesEventContent{
+		BaseCallEventContent: base,
+		Candidates:           []event.CallCandidate{{Candidate: "candidate:1 1 udp 1 127.0.0.1 1234 typ host"}},
+	}
+	if err := mallory.Send(event.ToDeviceCallCandidates, candidates); err != nil {
+		t.Fatalf("Failed to send candidates: %v", err)
+	}
+
+	result := make(chan routing.DrainResult, 1)
+	select {
+	case adminRequests <- routing.AdminRequest{Request: routing.DrainRequested{Result: result}}:
+		<-result
+	case <-time.After(5 * time.Second):
+		t.Fatal("Expected the router to keep processing the requests")
+	}
+
+	// Others can still start the conference.
+	join(t, alice)
+}
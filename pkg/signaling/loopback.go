@@ -0,0 +1,154 @@
+package signaling
+
+import (
+	"encoding/json"
+	"fmt"
+	"sync"
+
+	"maunium.net/go/mautrix/event"
+	"maunium.net/go/mautrix/id"
+)
+
+// How many to-device messages of the SFU may wait for a simulated client before the new ones get rejected.
+const loopbackClientQueueSize = 256
+
+// An in-memory replacement of the homeserver, so that the whole flow (router, conferences and peers) could be
+// tested without one. The to-device messages that the SFU sends are delivered to the simulated clients and
+// the messages of the clients are delivered to the SFU's event channel (the one that `RunSync` would feed).
+type Loopback struct {
+	userID   id.UserID
+	deviceID id.DeviceID
+	// The channel of the events that the SFU receives.
+	events chan<- *event.Event
+
+	mutex   sync.Mutex
+	clients map[loopbackClientKey]*LoopbackClient
+}
+
+type loopbackClientKey struct {
+	userID   id.UserID
+	deviceID id.DeviceID
+}
+
+// Creates a loopback for the SFU with a given user and device ID that delivers the messages
+// of the simulated clients to a given channel.
+func NewLoopback(userID id.UserID, deviceID id.DeviceID, events chan<- *event.Event) *Loopback {
+	return &Loopback{
+		userID:   userID,
+		deviceID: deviceID,
+		events:   events,
+		clients:  make(map[loopbackClientKey]*LoopbackClient),
+	}
+}
+
+// Implementation of `MatrixSignaling`.
+func (l *Loopback) CreateForConference(conferenceID string) MatrixSignaler {
+	return &loopbackForConference{loopback: l, conferenceID: conferenceID}
+}
+
+// Registers a simulated client (a device of a user) that exchanges to-device messages with the SFU.
+func (l *Loopback) NewClient(userID id.UserID, deviceID id.DeviceID) *LoopbackClient {
+	client := &LoopbackClient{
+		UserID:   userID,
+		DeviceID: deviceID,
+		loopback: l,
+		events:   make(chan *event.Event, loopbackClientQueueSize),
+	}
+
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	l.clients[loopbackClientKey{userID, deviceID}] = client
+
+	return client
+}
+
+func (l *Loopback) client(userID id.UserID, deviceID id.DeviceID) *LoopbackClient {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	return l.clients[loopbackClientKey{userID, deviceID}]
+}
+
+// A simulated client that is connected to the SFU over the `Loopback`.
+type LoopbackClient struct {
+	UserID   id.UserID
+	DeviceID id.DeviceID
+
+	loopback *Loopback
+	events   chan *event.Event
+}
+
+// Sends a to-device message to the SFU. Blocks until the SFU takes the message.
+func (c *LoopbackClient) Send(eventType event.Type, content interface{}) error {
+	evt, err := newToDeviceEvent(c.UserID, eventType, &event.Content{Parsed: content})
+	if err != nil {
+		return err
+	}
+
+	c.loopback.events <- evt
+	return nil
+}
+
+// Returns the channel of the to-device messages that the SFU sends to the client.
+func (c *LoopbackClient) Events() <-chan *event.Event {
+	return c.events
+}
+
+// The signaler of a particular conference that sends the messages over the `Loopback`.
+type loopbackForConference struct {
+	loopback     *Loopback
+	conferenceID string
+}
+
+// Implementation of `MatrixSignaler`.
+func (l *loopbackForConference) SendMessage(message MatrixMessage) error {
+	eventType, eventContent, err := newEventContent(l.loopback.deviceID, l.conferenceID, message)
+	if err != nil {
+		return err
+	}
+
+	recipient := message.Recipient
+	client := l.loopback.client(recipient.UserID, recipient.DeviceID)
+	if client == nil {
+		return fmt.Errorf("unknown recipient %s (%s)", recipient.UserID, recipient.DeviceID)
+	}
+
+	evt, err := newToDeviceEvent(l.loopback.userID, eventType, eventContent)
+	if err != nil {
+		return err
+	}
+
+	select {
+	case client.events <- evt:
+		return nil
+	default:
+		return fmt.Errorf("the queue of %s (%s) is full", recipient.UserID, recipient.DeviceID)
+	}
+}
+
+// Implementation of `MatrixSignaler`.
+func (l *loopbackForConference) DeviceID() id.DeviceID {
+	return l.loopback.deviceID
+}
+
+// Creates the to-device event the way it's received from the homeserver, i.e. with both the raw and the
+// parsed content, so that the receiver could not tell it from the one that went through the homeserver.
+func newToDeviceEvent(sender id.UserID, eventType event.Type, content *event.Content) (*event.Event, error) {
+	eventType.Class = event.ToDeviceEventType
+
+	data, err := json.Marshal(content)
+	if err != nil {
+		return nil, fmt.Errorf("failed to serialize %s: %w", eventType.Type, err)
+	}
+
+	evt := &event.Event{Sender: sender, Type: eventType}
+	if err := json.Unmarshal(data, &evt.Content); err != nil {
+		return nil, fmt.Errorf("failed to deserialize %s: %w", eventType.Type, err)
+	}
+
+	if err := evt.Content.ParseRaw(eventType); err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %w", eventType.Type, err)
+	}
+
+	return evt, nil
+}
@@ -18,7 +18,9 @@ package signaling
 
 import (
 	"fmt"
+	"time"
 
+	"github.com/matrix-org/waterfall/pkg/metrics"
 	"maunium.net/go/mautrix"
 	"maunium.net/go/mautrix/event"
 	"maunium.net/go/mautrix/id"
@@ -32,6 +34,11 @@ type MatrixSignaler interface {
 	DeviceID() id.DeviceID
 }
 
+// Interface that abstracts the Matrix client, i.e. creates the signalers for the conferences.
+type MatrixSignaling interface {
+	CreateForConference(conferenceID string) MatrixSignaler
+}
+
 // Defines the data that identifies a receiver of Matrix's to-device message.
 type MatrixRecipient struct {
 	UserID          id.UserID
@@ -67,7 +74,7 @@ type MatrixForConference struct {
 }
 
 // Create a new Matrix client that abstracts outgoing Matrix messages from a given conference.
-func (m *MatrixClient) CreateForConference(conferenceID string) *MatrixForConference {
+func (m *MatrixClient) CreateForConference(conferenceID string) MatrixSignaler {
 	return &MatrixForConference{
 		client:       m.client,
 		conferenceID: conferenceID,
@@ -75,89 +82,67 @@ func (m *MatrixClient) CreateForConference(conferenceID string) *MatrixForConfer
 }
 
 func (m *MatrixForConference) SendMessage(message MatrixMessage) error {
-	switch msg := message.Message.(type) {
-	case SdpAnswer:
-		return m.sendSdpAnswer(message.Recipient, msg.StreamMetadata, msg.SDP)
-	case IceCandidates:
-		return m.sendICECandidates(message.Recipient, msg.Candidates)
-	case CandidatesGatheringFinished:
-		return m.sendCandidatesGatheringFinished(message.Recipient)
-	case Hangup:
-		return m.sendHangup(message.Recipient, msg.Reason)
-	default:
-		return fmt.Errorf("unknown message type: %T", msg)
+	eventType, eventContent, err := newEventContent(m.client.DeviceID, m.conferenceID, message)
+	if err != nil {
+		return err
 	}
+
+	return m.sendToDevice(message.Recipient, eventType, eventContent)
 }
 
 func (m *MatrixForConference) DeviceID() id.DeviceID {
 	return m.client.DeviceID
 }
 
-func (m *MatrixForConference) sendSdpAnswer(
-	recipient MatrixRecipient,
-	streamMetadata event.CallSDPStreamMetadata,
-	sdp string,
-) error {
-	eventContent := &event.Content{
-		Parsed: event.CallAnswerEventContent{
-			BaseCallEventContent: m.createBaseEventContent(recipient.CallID, recipient.RemoteSessionID),
-			Answer: event.CallData{
-				Type: "answer",
-				SDP:  sdp,
-			},
-			SDPStreamMetadata: streamMetadata,
-		},
-	}
-
-	return m.sendToDevice(recipient, event.CallAnswer, eventContent)
-}
-
-func (m *MatrixForConference) sendICECandidates(recipient MatrixRecipient, candidates []event.CallCandidate) error {
-	eventContent := &event.Content{
-		Parsed: event.CallCandidatesEventContent{
-			BaseCallEventContent: m.createBaseEventContent(recipient.CallID, recipient.RemoteSessionID),
-			Candidates:           candidates,
-		},
-	}
-
-	return m.sendToDevice(recipient, event.CallCandidates, eventContent)
-}
-
-func (m *MatrixForConference) sendCandidatesGatheringFinished(recipient MatrixRecipient) error {
-	eventContent := &event.Content{
-		Parsed: event.CallCandidatesEventContent{
-			BaseCallEventContent: m.createBaseEventContent(recipient.CallID, recipient.RemoteSessionID),
-			Candidates:           []event.CallCandidate{{Candidate: ""}},
-		},
-	}
-
-	return m.sendToDevice(recipient, event.CallCandidates, eventContent)
-}
-
-func (m *MatrixForConference) sendHangup(recipient MatrixRecipient, reason event.CallHangupReason) error {
-	eventContent := &event.Content{
-		Parsed: event.CallHangupEventContent{
-			BaseCallEventContent: m.createBaseEventContent(recipient.CallID, recipient.RemoteSessionID),
-			Reason:               reason,
-		},
-	}
-
-	return m.sendToDevice(recipient, event.CallHangup, eventContent)
-}
-
-func (m *MatrixForConference) createBaseEventContent(
-	callID string,
-	destSessionID id.SessionID,
-) event.BaseCallEventContent {
-	return event.BaseCallEventContent{
-		CallID:          callID,
-		ConfID:          m.conferenceID,
-		DeviceID:        m.client.DeviceID,
+// Creates the type and the content of the to-device event that carries a given message
+// of the SFU's device in a given conference.
+func newEventContent(deviceID id.DeviceID, conferenceID string, message MatrixMessage) (event.Type, *event.Content, error) {
+	base := event.BaseCallEventContent{
+		CallID:          message.Recipient.CallID,
+		ConfID:          conferenceID,
+		DeviceID:        deviceID,
 		SenderSessionID: LocalSessionID,
-		DestSessionID:   destSessionID,
-		PartyID:         string(m.client.DeviceID),
+		DestSessionID:   message.Recipient.RemoteSessionID,
+		PartyID:         string(deviceID),
 		Version:         event.CallVersion("1"),
 	}
+
+	switch msg := message.Message.(type) {
+	case SdpAnswer:
+		return event.CallAnswer, &event.Content{
+			Parsed: event.CallAnswerEventContent{
+				BaseCallEventContent: base,
+				Answer: event.CallData{
+					Type: "answer",
+					SDP:  msg.SDP,
+				},
+				SDPStreamMetadata: msg.StreamMetadata,
+			},
+		}, nil
+	case IceCandidates:
+		return event.CallCandidates, &event.Content{
+			Parsed: event.CallCandidatesEventContent{
+				BaseCallEventContent: base,
+				Candidates:           msg.Candidates,
+			},
+		}, nil
+	case CandidatesGatheringFinished:
+		return event.CallCandidates, &event.Content{
+			Parsed: event.CallCandidatesEventContent{
+				BaseCallEventContent: base,
+				Candidates:           []event.CallCandidate{{Candidate: ""}},
+			},
+		}, nil
+	case Hangup:
+		return event.CallHangup, &event.Content{
+			Parsed: event.CallHangupEventContent{
+				BaseCallEventContent: base,
+				Reason:               msg.Reason,
+			},
+		}, nil
+	default:
+		return event.Type{}, nil, fmt.Errorf("unknown message type: %T", msg)
+	}
 }
 
 // Sends a to-device event to the given user.
@@ -174,6 +159,17 @@ func (m *MatrixForConference) sendToDevice(
 		},
 	}
 
+	start := time.Now()
 	_, err := m.client.SendToDevice(eventType, sendRequest)
+	metrics.ToDeviceLatency.Observe(float64(time.Since(start).Milliseconds()))
+
+	// Failed signaling shows up as the connection problems on the client side,
+	// so we keep track of them to be able to tell one from another.
+	if err != nil {
+		metrics.ToDeviceFailed.Add(eventType.Type, 1)
+	} else {
+		metrics.ToDeviceSent.Add(eventType.Type, 1)
+	}
+
 	return err
 }
@@ -0,0 +1,73 @@
+package signaling //nolint:testpackage
+
+import (
+	"expvar"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/matrix-org/waterfall/pkg/metrics"
+	"maunium.net/go/mautrix"
+	"maunium.net/go/mautrix/event"
+)
+
+// Creates a conference client that talks to a fake homeserver which responds with a given status.
+func newTestMatrix(t *testing.T, status int) *MatrixForConference {
+	t.Helper()
+
+	homeserver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(status)
+		if status == http.StatusOK {
+			w.Write([]byte(`{}`)) //nolint:errcheck
+		} else {
+			w.Write([]byte(`{"errcode":"M_UNKNOWN","error":"nope"}`)) //nolint:errcheck
+		}
+	}))
+	t.Cleanup(homeserver.Close)
+
+	client, err := mautrix.NewClient(homeserver.URL, "@sfu:example.com", "token")
+	if err != nil {
+		t.Fatalf("Failed to create a client: %v", err)
+	}
+	client.DefaultHTTPRetries = 0
+
+	return &MatrixForConference{client: client, conferenceID: "conference"}
+}
+
+func counter(m *expvar.Map, key string) int64 {
+	if value, ok := m.Get(key).(*expvar.Int); ok {
+		return value.Value()
+	}
+
+	return 0
+}
+
+func TestSendToDeviceMetrics(t *testing.T) {
+	recipient := MatrixRecipient{UserID: "@alice:example.com", DeviceID: "ALICE", CallID: "call"}
+	hangup := MatrixMessage{Recipient: recipient, Message: Hangup{Reason: event.CallHangupUserHangup}}
+	eventType := event.CallHangup.Type
+
+	failedBefore, sentBefore := counter(metrics.ToDeviceFailed, eventType), counter(metrics.ToDeviceSent, eventType)
+	latenciesBefore := metrics.ToDeviceLatency.Count()
+
+	if err := newTestMatrix(t, http.StatusForbidden).SendMessage(hangup); err == nil {
+		t.Fatal("Expected the send to fail")
+	}
+
+	if failed := counter(metrics.ToDeviceFailed, eventType); failed != failedBefore+1 {
+		t.Errorf("Expected %d failed sends, got %d", failedBefore+1, failed)
+	}
+
+	if err := newTestMatrix(t, http.StatusOK).SendMessage(hangup); err != nil {
+		t.Fatalf("Failed to send: %v", err)
+	}
+
+	if sent := counter(metrics.ToDeviceSent, eventType); sent != sentBefore+1 {
+		t.Errorf("Expected %d successful sends, got %d", sentBefore+1, sent)
+	}
+
+	if latencies := metrics.ToDeviceLatency.Count(); latencies != latenciesBefore+2 {
+		t.Errorf("Expected %d latency samples, got %d", latenciesBefore+2, latencies)
+	}
+}
@@ -2,6 +2,7 @@ package telemetry
 
 import (
 	"context"
+	"math/rand"
 
 	"go.opentelemetry.io/otel"
 	"go.opentelemetry.io/otel/attribute"
@@ -12,26 +13,57 @@ import (
 type Telemetry struct {
 	span    trace.Span
 	context context.Context //nolint:containedctx
+	// Whether the children of the span are traced. If not, they are no-ops, so that
+	// only the (minimal) trace of this span is recorded.
+	sampled bool
 }
 
 func NewTelemetry(ctx context.Context, name string, attributes ...attribute.KeyValue) *Telemetry {
+	return NewSampledTelemetry(ctx, name, true, attributes...)
+}
+
+// Like `NewTelemetry`, but the children of the span (and their children) are only traced if `sampled` is set.
+func NewSampledTelemetry(
+	ctx context.Context,
+	name string,
+	sampled bool,
+	attributes ...attribute.KeyValue,
+) *Telemetry {
 	// If the name is an empty string then provider uses default name.
 	// If the telemetry has been set up with the set up with `SetupTelemetry` function,
 	// then it will be set to the name of the resources that has been passed to the function.
-	tracer := otel.Tracer("")
+	return newTelemetry(ctx, otel.Tracer(""), name, sampled, attributes...)
+}
 
+func newTelemetry(
+	ctx context.Context,
+	tracer trace.Tracer,
+	name string,
+	sampled bool,
+	attributes ...attribute.KeyValue,
+) *Telemetry {
 	ctx, span := tracer.Start(ctx, name, trace.WithAttributes(attributes...))
 
 	return &Telemetry{
 		span:    span,
 		context: ctx,
+		sampled: sampled,
 	}
 }
 
 func (t *Telemetry) CreateChild(name string, attributes ...attribute.KeyValue) *Telemetry {
+	if !t.sampled {
+		return newTelemetry(t.context, trace.NewNoopTracerProvider().Tracer(""), name, false, attributes...)
+	}
+
 	return NewTelemetry(t.context, name, attributes...)
 }
 
+// Decides whether a given trace is sampled when 1 in `oneIn` traces are sampled (all of them if `oneIn` <= 1).
+func Sample(oneIn int) bool {
+	return oneIn <= 1 || rand.Intn(oneIn) == 0 //nolint:gosec
+}
+
 func (t *Telemetry) AddEvent(text string, attributes ...attribute.KeyValue) {
 	traceAttributes := trace.WithAttributes(attributes...)
 	t.span.AddEvent(text, traceAttributes)
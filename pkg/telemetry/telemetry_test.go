@@ -0,0 +1,67 @@
+package telemetry_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/matrix-org/waterfall/pkg/telemetry"
+	"go.opentelemetry.io/otel"
+	tracesdk "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+func TestSampledOutConferenceHasNoChildSpans(t *testing.T) {
+	previous := otel.GetTracerProvider()
+	defer otel.SetTracerProvider(previous)
+
+	// Creates a conference with a participant that publishes a track and returns the names of the spans.
+	trace := func(sampled bool) []string {
+		recorder := tracetest.NewSpanRecorder()
+		otel.SetTracerProvider(tracesdk.NewTracerProvider(tracesdk.WithSpanProcessor(recorder)))
+
+		conference := telemetry.NewSampledTelemetry(context.Background(), "Conference", sampled)
+		participant := conference.CreateChild("Participant")
+		track := participant.ChildBuilder().Create("PublishedTrack")
+		track.AddEvent("published")
+
+		track.End()
+		participant.End()
+		conference.End()
+
+		names := []string{}
+		for _, span := range recorder.Ended() {
+			names = append(names, span.Name())
+		}
+
+		return names
+	}
+
+	if names := trace(true); len(names) != 3 {
+		t.Errorf("Expected the sampled conference to have 3 spans, got %v", names)
+	}
+
+	if names := trace(false); len(names) != 1 || names[0] != "Conference" {
+		t.Errorf("Expected the sampled out conference to only have its own span, got %v", names)
+	}
+}
+
+func TestSample(t *testing.T) {
+	for _, oneIn := range []int{0, 1} {
+		for i := 0; i < 100; i++ {
+			if !telemetry.Sample(oneIn) {
+				t.Fatalf("Expected all traces to be sampled for %d", oneIn)
+			}
+		}
+	}
+
+	sampled := 0
+	for i := 0; i < 1000; i++ {
+		if telemetry.Sample(10) {
+			sampled++
+		}
+	}
+
+	if sampled == 0 || sampled == 1000 {
+		t.Errorf("Expected some traces to be sampled out, got %d out of 1000 sampled", sampled)
+	}
+}
@@ -0,0 +1,8 @@
+package webhook
+
+type Config struct {
+	// The URL to POST the conference events to. Webhooks are disabled if empty.
+	URL string `yaml:"url"`
+	// The secret to sign the payloads with (HMAC-SHA256). Payloads are not signed if empty.
+	Secret string `yaml:"secret"`
+}
@@ -0,0 +1,146 @@
+package webhook
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/matrix-org/waterfall/pkg/worker"
+	"github.com/sirupsen/logrus"
+)
+
+// The header that contains the HMAC-SHA256 signature of the payload (if the secret is configured).
+const SignatureHeader = "X-Waterfall-Signature"
+
+type EventType string
+
+const (
+	ConferenceStarted EventType = "conference_started"
+	ConferenceEnded   EventType = "conference_ended"
+	ParticipantJoined EventType = "participant_joined"
+	ParticipantLeft   EventType = "participant_left"
+)
+
+// The payload of the webhook.
+type Event struct {
+	Type         EventType `json:"type"`
+	ConferenceID string    `json:"conf_id"`
+	UserID       string    `json:"user_id,omitempty"`
+	DeviceID     string    `json:"device_id,omitempty"`
+	Timestamp    int64     `json:"timestamp"`
+}
+
+const (
+	// How many events may wait for the delivery before the new ones get dropped.
+	queueSize = 256
+	// How many times the delivery of a single event is attempted.
+	maxAttempts = 3
+	// Delay before the first retry, doubled on each subsequent one.
+	retryDelay = 500 * time.Millisecond
+	// Timeout of a single delivery attempt.
+	requestTimeout = 5 * time.Second
+)
+
+// Delivers the conference events to the configured URL. The delivery is best-effort: the events
+// are queued and sent by a separate goroutine, so that a slow endpoint never blocks the conferences.
+type Notifier struct {
+	worker *worker.Worker[Event]
+}
+
+// Creates a new notifier. Returns `nil` (which is a valid notifier that does nothing) if the webhooks
+// are not configured.
+func NewNotifier(config Config) *Notifier {
+	if config.URL == "" {
+		return nil
+	}
+
+	client := &http.Client{Timeout: requestTimeout}
+
+	workerConfig := worker.Config[Event]{
+		ChannelSize: queueSize,
+		Timeout:     time.Hour,
+		OnTimeout:   func() {},
+		OnTask: func(event Event) {
+			delay := retryDelay
+			for attempt := 1; ; attempt++ {
+				err := deliver(client, config, event)
+				if err == nil {
+					return
+				}
+
+				if attempt == maxAttempts {
+					logrus.WithError(err).WithField("type", event.Type).Error("Failed to deliver webhook")
+					return
+				}
+
+				time.Sleep(delay)
+				delay *= 2
+			}
+		},
+	}
+
+	return &Notifier{worker.StartWorker(workerConfig)}
+}
+
+// Queues the event for the delivery.
+func (n *Notifier) Notify(event Event) {
+	if n == nil {
+		return
+	}
+
+	if event.Timestamp == 0 {
+		event.Timestamp = time.Now().UnixMilli()
+	}
+
+	if err := n.worker.Send(event); err != nil {
+		logrus.WithError(err).WithField("type", event.Type).Warn("Dropping webhook")
+	}
+}
+
+// Stops the delivery of the events.
+func (n *Notifier) Stop() {
+	if n != nil {
+		n.worker.Stop()
+	}
+}
+
+// Calculates the signature of the payload.
+func Sign(secret string, payload []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(payload)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+func deliver(client *http.Client, config Config, event Event) error {
+	payload, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+
+	request, err := http.NewRequest(http.MethodPost, config.URL, bytes.NewReader(payload))
+	if err != nil {
+		return err
+	}
+
+	request.Header.Set("Content-Type", "application/json")
+	if config.Secret != "" {
+		request.Header.Set(SignatureHeader, Sign(config.Secret, payload))
+	}
+
+	response, err := client.Do(request)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode < 200 || response.StatusCode >= 300 {
+		return fmt.Errorf("unexpected status: %s", response.Status)
+	}
+
+	return nil
+}
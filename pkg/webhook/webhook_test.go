@@ -0,0 +1,89 @@
+package webhook_test
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/matrix-org/waterfall/pkg/webhook"
+)
+
+func TestWebhookPayload(t *testing.T) {
+	const secret = "secret"
+
+	type request struct {
+		body      []byte
+		signature string
+	}
+
+	requests := make(chan request, 1)
+	attempts := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// The first attempt fails, so that the retry is exercised.
+		attempts++
+		if attempts == 1 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+
+		body, _ := io.ReadAll(r.Body)
+		requests <- request{body, r.Header.Get(webhook.SignatureHeader)}
+	}))
+	defer server.Close()
+
+	notifier := webhook.NewNotifier(webhook.Config{URL: server.URL, Secret: secret})
+	defer notifier.Stop()
+
+	notifier.Notify(webhook.Event{
+		Type:         webhook.ParticipantJoined,
+		ConferenceID: "conference",
+		UserID:       "@alice:example.org",
+		DeviceID:     "ALICE",
+	})
+
+	var received request
+	select {
+	case received = <-requests:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Webhook was not delivered")
+	}
+
+	if signature := webhook.Sign(secret, received.body); received.signature != signature {
+		t.Errorf("Expected signature %s, got %s", signature, received.signature)
+	}
+
+	var payload map[string]any
+	if err := json.Unmarshal(received.body, &payload); err != nil {
+		t.Fatalf("Failed to unmarshal payload: %v", err)
+	}
+
+	expected := map[string]string{
+		"type":      "participant_joined",
+		"conf_id":   "conference",
+		"user_id":   "@alice:example.org",
+		"device_id": "ALICE",
+	}
+	for key, value := range expected {
+		if payload[key] != value {
+			t.Errorf("Expected %s to be %q, got %v", key, value, payload[key])
+		}
+	}
+
+	if _, ok := payload["timestamp"].(float64); !ok {
+		t.Errorf("Expected a numeric timestamp, got %v", payload["timestamp"])
+	}
+}
+
+func TestDisabledWebhook(t *testing.T) {
+	notifier := webhook.NewNotifier(webhook.Config{})
+	if notifier != nil {
+		t.Fatal("Expected no notifier without URL")
+	}
+
+	// Must be a no-op.
+	notifier.Notify(webhook.Event{Type: webhook.ConferenceStarted})
+	notifier.Stop()
+}
@@ -0,0 +1,165 @@
+package webrtc_ext
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+
+	"github.com/pion/webrtc/v3"
+)
+
+// Configuration of the filter that is applied to the local ICE candidates before they're sent to the clients.
+type CandidateFilterConfig struct {
+	// Candidate types (`host`, `srflx`, `prflx` or `relay`) that are never sent to the clients.
+	ExcludeTypes []string `yaml:"excludeTypes"`
+	// Networks (in CIDR notation) whose candidates are never sent to the clients. Use `0.0.0.0/0`
+	// or `::/0` to only advertise IPv6 or IPv4 candidates respectively.
+	ExcludeNetworks []string `yaml:"excludeNetworks"`
+	// Networks (in CIDR notation) whose candidates are preferred over the other candidates of the same
+	// type, e.g. to make the clients use a specific interface of the SFU if they can reach it.
+	PreferredNetworks []string `yaml:"preferredNetworks"`
+}
+
+// Checks that the candidate types and the networks of the filter are valid.
+func (c CandidateFilterConfig) Validate() error {
+	_, err := NewCandidateFilter(c)
+	return err
+}
+
+// Decides which local ICE candidates are advertised to the clients and with what priority.
+// A `nil` filter advertises all candidates unchanged.
+type CandidateFilter struct {
+	excludedTypes     map[webrtc.ICECandidateType]bool
+	excludedNetworks  []*net.IPNet
+	preferredNetworks []*net.IPNet
+}
+
+// Creates a filter from its configuration. Returns `nil` if the configuration does not filter anything.
+func NewCandidateFilter(config CandidateFilterConfig) (*CandidateFilter, error) {
+	if len(config.ExcludeTypes) == 0 && len(config.ExcludeNetworks) == 0 && len(config.PreferredNetworks) == 0 {
+		return nil, nil
+	}
+
+	filter := &CandidateFilter{excludedTypes: make(map[webrtc.ICECandidateType]bool)}
+	for _, typ := range config.ExcludeTypes {
+		candidateType, err := webrtc.NewICECandidateType(typ)
+		if err != nil {
+			return nil, fmt.Errorf("invalid candidate type %q: %w", typ, err)
+		}
+		filter.excludedTypes[candidateType] = true
+	}
+
+	var err error
+	if filter.excludedNetworks, err = parseNetworks(config.ExcludeNetworks); err != nil {
+		return nil, err
+	}
+	if filter.preferredNetworks, err = parseNetworks(config.PreferredNetworks); err != nil {
+		return nil, err
+	}
+
+	return filter, nil
+}
+
+func parseNetworks(cidrs []string) ([]*net.IPNet, error) {
+	networks := make([]*net.IPNet, 0, len(cidrs))
+	for _, cidr := range cidrs {
+		_, network, err := net.ParseCIDR(cidr)
+		if err != nil {
+			return nil, fmt.Errorf("invalid network %q: %w", cidr, err)
+		}
+		networks = append(networks, network)
+	}
+
+	return networks, nil
+}
+
+// Applies the filter to a gathered candidate. Returns the candidate that should be sent to the client
+// (with the adjusted priority if it's preferred) and `false` if the candidate must not be sent at all.
+func (f *CandidateFilter) Apply(candidate webrtc.ICECandidate) (webrtc.ICECandidate, bool) {
+	priority, ok := f.apply(candidate.Typ, candidate.Address, candidate.Priority)
+	candidate.Priority = priority
+	return candidate, ok
+}
+
+// Applies the filter to the `a=candidate` lines of the SDP, i.e. for the SDP that carries the
+// candidates when trickle ICE is disabled.
+func (f *CandidateFilter) ApplyToSDP(sdp string) string {
+	if f == nil {
+		return sdp
+	}
+
+	lines := strings.Split(sdp, "\r\n")
+	filtered := make([]string, 0, len(lines))
+	for _, line := range lines {
+		if !strings.HasPrefix(line, "a=candidate:") {
+			filtered = append(filtered, line)
+			continue
+		}
+
+		// a=candidate:<foundation> <component> <transport> <priority> <address> <port> typ <type> ...
+		fields := strings.Fields(strings.TrimPrefix(line, "a=candidate:"))
+		if len(fields) < 8 || fields[6] != "typ" {
+			filtered = append(filtered, line)
+			continue
+		}
+
+		candidateType, err := webrtc.NewICECandidateType(fields[7])
+		if err != nil {
+			filtered = append(filtered, line)
+			continue
+		}
+
+		priority, err := strconv.ParseUint(fields[3], 10, 32)
+		if err != nil {
+			filtered = append(filtered, line)
+			continue
+		}
+
+		adjusted, ok := f.apply(candidateType, fields[4], uint32(priority))
+		if !ok {
+			continue
+		}
+
+		fields[3] = strconv.FormatUint(uint64(adjusted), 10)
+		filtered = append(filtered, "a=candidate:"+strings.Join(fields, " "))
+	}
+
+	return strings.Join(filtered, "\r\n")
+}
+
+// The bits of the candidate priority that hold the local preference (RFC 8445, section 5.1.2.1).
+const localPreferenceMask = 0xFFFF << 8
+
+func (f *CandidateFilter) apply(typ webrtc.ICECandidateType, address string, priority uint32) (uint32, bool) {
+	if f == nil {
+		return priority, true
+	}
+
+	if f.excludedTypes[typ] {
+		return priority, false
+	}
+
+	// The addresses that are not IPs (e.g. mDNS host names) never match any network.
+	ip := net.ParseIP(address)
+	if ip != nil && containsIP(f.excludedNetworks, ip) {
+		return priority, false
+	}
+
+	// The type preference stays intact, so the preferred candidates only win over the ones of the same type.
+	if ip != nil && containsIP(f.preferredNetworks, ip) {
+		priority |= localPreferenceMask
+	}
+
+	return priority, true
+}
+
+func containsIP(networks []*net.IPNet, ip net.IP) bool {
+	for _, network := range networks {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,96 @@
+package webrtc_ext_test
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/matrix-org/waterfall/pkg/webrtc_ext"
+	"github.com/pion/webrtc/v3"
+)
+
+func TestCandidateFilter(t *testing.T) {
+	filter, err := webrtc_ext.NewCandidateFilter(webrtc_ext.CandidateFilterConfig{
+		ExcludeTypes:      []string{"relay"},
+		ExcludeNetworks:   []string{"::/0", "192.168.0.0/16"},
+		PreferredNetworks: []string{"10.1.0.0/16"},
+	})
+	if err != nil {
+		t.Fatalf("Failed to create filter: %v", err)
+	}
+
+	host := webrtc.ICECandidateTypeHost
+	priority := uint32(2130706431 &^ (0xFFFF << 8))
+
+	cases := []struct {
+		typ              webrtc.ICECandidateType
+		address          string
+		expectedForward  bool
+		expectedPriority uint32
+	}{
+		{host, "10.0.0.1", true, priority},
+		{host, "10.1.0.1", true, priority | 0xFFFF<<8},
+		{host, "192.168.1.1", false, 0},
+		{host, "2001:db8::1", false, 0},
+		{host, "4c3f2a85-local.local", true, priority},
+		{webrtc.ICECandidateTypeSrflx, "203.0.113.1", true, priority},
+		{webrtc.ICECandidateTypeRelay, "203.0.113.1", false, 0},
+	}
+
+	for _, c := range cases {
+		candidate, ok := filter.Apply(webrtc.ICECandidate{Typ: c.typ, Address: c.address, Priority: priority})
+		if ok != c.expectedForward {
+			t.Errorf("%s %s: expected forwarded=%v, got %v", c.typ, c.address, c.expectedForward, ok)
+			continue
+		}
+
+		if ok && candidate.Priority != c.expectedPriority {
+			t.Errorf("%s %s: expected priority %d, got %d", c.typ, c.address, c.expectedPriority, candidate.Priority)
+		}
+	}
+}
+
+func TestCandidateFilterSDP(t *testing.T) {
+	filter, err := webrtc_ext.NewCandidateFilter(webrtc_ext.CandidateFilterConfig{ExcludeTypes: []string{"host"}})
+	if err != nil {
+		t.Fatalf("Failed to create filter: %v", err)
+	}
+
+	sdp := strings.Join([]string{
+		"v=0",
+		"m=audio 9 UDP/TLS/RTP/SAVPF 111",
+		"a=candidate:1 1 udp 2130706431 10.0.0.1 5000 typ host",
+		"a=candidate:2 1 udp 1694498815 203.0.113.1 5000 typ srflx raddr 10.0.0.1 rport 5000",
+		"a=end-of-candidates",
+		"",
+	}, "\r\n")
+
+	expected := strings.Join([]string{
+		"v=0",
+		"m=audio 9 UDP/TLS/RTP/SAVPF 111",
+		"a=candidate:2 1 udp 1694498815 203.0.113.1 5000 typ srflx raddr 10.0.0.1 rport 5000",
+		"a=end-of-candidates",
+		"",
+	}, "\r\n")
+
+	if filtered := filter.ApplyToSDP(sdp); filtered != expected {
+		t.Errorf("Unexpected SDP:\n%s", filtered)
+	}
+}
+
+func TestCandidateFilterValidation(t *testing.T) {
+	invalid := []webrtc_ext.CandidateFilterConfig{
+		{ExcludeTypes: []string{"local"}},
+		{ExcludeNetworks: []string{"10.0.0.1"}},
+		{PreferredNetworks: []string{"not a network"}},
+	}
+
+	for _, config := range invalid {
+		if err := config.Validate(); err == nil {
+			t.Errorf("Expected %+v to be invalid", config)
+		}
+	}
+
+	if filter, err := webrtc_ext.NewCandidateFilter(webrtc_ext.CandidateFilterConfig{}); err != nil || filter != nil {
+		t.Errorf("Expected no filter for an empty config, got %v (%v)", filter, err)
+	}
+}
@@ -6,4 +6,23 @@ type Config struct {
 	EnableSimulcast bool `yaml:"simulcast"`
 	// Pulibc IP address of the SFU.
 	PublicIPs []string `yaml:"ipAddresses"`
+	// The size (in bytes) of the buffers that the incoming packets are read into. Larger
+	// packets are truncated, so it must not be smaller than the MTU of the network.
+	MTU uint `yaml:"mtu"`
+	// Filter of the local ICE candidates that are advertised to the clients (none by default).
+	CandidateFilter CandidateFilterConfig `yaml:"candidateFilter"`
+	// The self-test of the peer connections on startup (disabled by default).
+	SelfTest SelfTestConfig `yaml:"selfTest"`
+}
+
+// Pion reads into 1460-byte buffers by default, which truncates the packets of up to the
+// common Ethernet MTU, so we use the latter by default.
+const DefaultMTU = 1500
+
+func (c Config) mtu() uint {
+	if c.MTU == 0 {
+		return DefaultMTU
+	}
+
+	return c.MTU
 }
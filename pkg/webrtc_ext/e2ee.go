@@ -0,0 +1,39 @@
+package webrtc_ext
+
+import (
+	"strings"
+)
+
+// The media-level SDP attribute that signals that the media of the section is end-to-end encrypted
+// with SFrame (draft-ietf-sframe-enc). Note that the encryption done via the insertable streams is
+// not visible in the SDP at all, so it can only be configured.
+const sframeAttribute = "a=sframe"
+
+// Returns the IDs of the tracks that are end-to-end encrypted according to the SDP. The track IDs
+// are taken from the `msid` attributes of the media sections.
+func EncryptedTrackIDs(sdp string) map[string]bool {
+	encrypted := make(map[string]bool)
+
+	for _, section := range mediaSections(sdp) {
+		var trackID string
+		var sframe bool
+
+		for _, line := range strings.Split(section, "\n") {
+			switch {
+			case line == sframeAttribute || strings.HasPrefix(line, sframeAttribute+":"):
+				sframe = true
+			case strings.HasPrefix(line, "a=msid:"):
+				// a=msid:<stream id> <track id>
+				if fields := strings.Fields(strings.TrimPrefix(line, "a=msid:")); len(fields) == 2 {
+					trackID = fields[1]
+				}
+			}
+		}
+
+		if sframe && trackID != "" {
+			encrypted[trackID] = true
+		}
+	}
+
+	return encrypted
+}
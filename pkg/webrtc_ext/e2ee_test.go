@@ -0,0 +1,33 @@
+package webrtc_ext_test
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/matrix-org/waterfall/pkg/webrtc_ext"
+)
+
+func TestEncryptedTrackIDs(t *testing.T) {
+	sdp := strings.Join([]string{
+		"v=0",
+		"s=-",
+		"m=audio 9 UDP/TLS/RTP/SAVPF 111",
+		"a=mid:0",
+		"a=msid:stream audio-track",
+		"a=sframe",
+		"m=video 9 UDP/TLS/RTP/SAVPF 96",
+		"a=mid:1",
+		"a=msid:stream video-track",
+		"m=video 9 UDP/TLS/RTP/SAVPF 96",
+		"a=mid:2",
+		"a=sframe",
+		"a=msid:screen screen-track",
+		"",
+	}, "\r\n")
+
+	expected := map[string]bool{"audio-track": true, "screen-track": true}
+	if encrypted := webrtc_ext.EncryptedTrackIDs(sdp); !reflect.DeepEqual(encrypted, expected) {
+		t.Errorf("Expected %v, got %v", expected, encrypted)
+	}
+}
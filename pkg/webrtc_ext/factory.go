@@ -8,7 +8,8 @@ import (
 
 // Peer connection factory is used to construct new (pre-configured) peer connections.
 type PeerConnectionFactory struct {
-	api *webrtc.API
+	api             *webrtc.API
+	candidateFilter *CandidateFilter
 }
 
 func NewPeerConnectionFactory(config Config) (*PeerConnectionFactory, error) {
@@ -17,7 +18,17 @@ func NewPeerConnectionFactory(config Config) (*PeerConnectionFactory, error) {
 		return nil, fmt.Errorf("failed to create WebRTC API: %w", err)
 	}
 
-	return &PeerConnectionFactory{api}, nil
+	candidateFilter, err := NewCandidateFilter(config.CandidateFilter)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create candidate filter: %w", err)
+	}
+
+	return &PeerConnectionFactory{api, candidateFilter}, nil
+}
+
+// Returns the filter that must be applied to the local candidates of the created peer connections.
+func (f *PeerConnectionFactory) CandidateFilter() *CandidateFilter {
+	return f.candidateFilter
 }
 
 // Creates a peer connection with a specifically configured API (with simulcast etc).
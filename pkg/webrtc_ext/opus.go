@@ -0,0 +1,65 @@
+package webrtc_ext
+
+import (
+	"strings"
+
+	"github.com/pion/webrtc/v3"
+)
+
+// Opus is always declared with 2 channels in the `a=rtpmap` (RFC 7587), whether the stream is stereo or
+// not is signaled with the format parameters instead: `sprop-stereo=1` tells that the sender is likely
+// to send stereo and `stereo=1` tells that the receiver prefers to receive stereo. Any Opus decoder is
+// able to decode both mono and stereo streams though, a stereo stream is just downmixed if needed.
+const (
+	opusStereo       = "stereo"
+	opusSpropStereo  = "sprop-stereo"
+	opusStereoEnable = "1"
+)
+
+// Checks if a given codec is Opus.
+func IsOpus(codec webrtc.RTPCodecCapability) bool {
+	return strings.EqualFold(codec.MimeType, webrtc.MimeTypeOpus)
+}
+
+// Checks if the Opus format parameters of a sender announce a stereo stream. Not all clients set
+// `sprop-stereo`, so `stereo=1` (that they set when they do stereo at all) counts as well.
+func OpusSendsStereo(fmtp string) bool {
+	parameters := parseFmtp(fmtp)
+	return parameters[opusSpropStereo] == opusStereoEnable || parameters[opusStereo] == opusStereoEnable
+}
+
+// Checks if the Opus format parameters of a receiver accept a stereo stream.
+func OpusReceivesStereo(fmtp string) bool {
+	return parseFmtp(fmtp)[opusStereo] == opusStereoEnable
+}
+
+// Returns the Opus format parameters with the stereo parameters set (or removed for mono, which is the
+// default), all other parameters are kept in place.
+func WithOpusStereo(fmtp string, stereo bool) string {
+	parameters := []string{}
+	for _, parameter := range strings.Split(fmtp, ";") {
+		key := strings.ToLower(strings.TrimSpace(strings.SplitN(parameter, "=", 2)[0]))
+		if key == "" || key == opusStereo || key == opusSpropStereo {
+			continue
+		}
+		parameters = append(parameters, strings.TrimSpace(parameter))
+	}
+
+	if stereo {
+		parameters = append(parameters, opusStereo+"="+opusStereoEnable, opusSpropStereo+"="+opusStereoEnable)
+	}
+
+	return strings.Join(parameters, ";")
+}
+
+func parseFmtp(fmtp string) map[string]string {
+	parameters := make(map[string]string)
+	for _, parameter := range strings.Split(fmtp, ";") {
+		keyValue := strings.SplitN(strings.TrimSpace(parameter), "=", 2)
+		if len(keyValue) == 2 {
+			parameters[strings.ToLower(keyValue[0])] = strings.TrimSpace(keyValue[1])
+		}
+	}
+
+	return parameters
+}
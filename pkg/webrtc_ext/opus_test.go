@@ -0,0 +1,51 @@
+package webrtc_ext_test
+
+import (
+	"testing"
+
+	"github.com/matrix-org/waterfall/pkg/webrtc_ext"
+)
+
+func TestOpusStereo(t *testing.T) {
+	cases := []struct {
+		fmtp           string
+		sendsStereo    bool
+		receivesStereo bool
+		withStereo     string
+		withoutStereo  string
+	}{
+		{
+			fmtp:          "minptime=10;useinbandfec=1",
+			withStereo:    "minptime=10;useinbandfec=1;stereo=1;sprop-stereo=1",
+			withoutStereo: "minptime=10;useinbandfec=1",
+		},
+		{
+			fmtp:           "minptime=10;stereo=1;useinbandfec=1",
+			sendsStereo:    true,
+			receivesStereo: true,
+			withStereo:     "minptime=10;useinbandfec=1;stereo=1;sprop-stereo=1",
+			withoutStereo:  "minptime=10;useinbandfec=1",
+		},
+		{
+			fmtp:          "sprop-stereo=1; stereo=0",
+			sendsStereo:   true,
+			withStereo:    "stereo=1;sprop-stereo=1",
+			withoutStereo: "",
+		},
+	}
+
+	for _, c := range cases {
+		if sends := webrtc_ext.OpusSendsStereo(c.fmtp); sends != c.sendsStereo {
+			t.Errorf("%q: expected sends stereo %v, got %v", c.fmtp, c.sendsStereo, sends)
+		}
+		if receives := webrtc_ext.OpusReceivesStereo(c.fmtp); receives != c.receivesStereo {
+			t.Errorf("%q: expected receives stereo %v, got %v", c.fmtp, c.receivesStereo, receives)
+		}
+		if fmtp := webrtc_ext.WithOpusStereo(c.fmtp, true); fmtp != c.withStereo {
+			t.Errorf("%q: expected %q with stereo, got %q", c.fmtp, c.withStereo, fmtp)
+		}
+		if fmtp := webrtc_ext.WithOpusStereo(c.fmtp, false); fmtp != c.withoutStereo {
+			t.Errorf("%q: expected %q without stereo, got %q", c.fmtp, c.withoutStereo, fmtp)
+		}
+	}
+}
@@ -0,0 +1,41 @@
+package webrtc_ext
+
+import (
+	"strings"
+)
+
+// Replaces the values of the redacted SDP attributes.
+const redacted = "<redacted>"
+
+// The SDP attributes that carry the credentials of the connection and must not end up in the logs.
+var secretAttributes = []string{"a=ice-ufrag:", "a=ice-pwd:", "a=fingerprint:", "a=crypto:"}
+
+// Returns a copy of the SDP with the ICE credentials and DTLS fingerprints redacted, so that it can be
+// logged. The structure of the SDP (and the hash function of the fingerprints) is kept intact.
+func RedactSDP(sdp string) string {
+	lines := strings.Split(sdp, "\n")
+	for i, line := range lines {
+		for _, prefix := range secretAttributes {
+			if !strings.HasPrefix(line, prefix) {
+				continue
+			}
+
+			value := strings.TrimPrefix(line, prefix)
+			ending := ""
+			if strings.HasSuffix(value, "\r") {
+				value, ending = strings.TrimSuffix(value, "\r"), "\r"
+			}
+
+			// a=fingerprint:<hash function> <fingerprint>
+			if hash, _, found := strings.Cut(value, " "); found && prefix == "a=fingerprint:" {
+				lines[i] = prefix + hash + " " + redacted + ending
+			} else {
+				lines[i] = prefix + redacted + ending
+			}
+
+			break
+		}
+	}
+
+	return strings.Join(lines, "\n")
+}
@@ -0,0 +1,50 @@
+package webrtc_ext_test
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/matrix-org/waterfall/pkg/webrtc_ext"
+)
+
+func TestRedactSDP(t *testing.T) {
+	sdp := strings.Join([]string{
+		"v=0",
+		"s=-",
+		"a=fingerprint:sha-256 0F:74:31:25:CB:A2:13:EC:28:6F:6D:2C:61:FF:5D:C2",
+		"m=audio 9 UDP/TLS/RTP/SAVPF 111",
+		"a=mid:0",
+		"a=ice-ufrag:ZsPqOGVWcNNPlNrD",
+		"a=ice-pwd:gzsyLbekrlYhtuVoMBsnzHuJxgtJeajw",
+		"a=candidate:1 1 udp 2130706431 10.0.0.1 5000 typ host",
+		"",
+	}, "\r\n")
+
+	expected := strings.Join([]string{
+		"v=0",
+		"s=-",
+		"a=fingerprint:sha-256 <redacted>",
+		"m=audio 9 UDP/TLS/RTP/SAVPF 111",
+		"a=mid:0",
+		"a=ice-ufrag:<redacted>",
+		"a=ice-pwd:<redacted>",
+		"a=candidate:1 1 udp 2130706431 10.0.0.1 5000 typ host",
+		"",
+	}, "\r\n")
+
+	redacted := webrtc_ext.RedactSDP(sdp)
+	if redacted != expected {
+		t.Errorf("Expected\n%q\ngot\n%q", expected, redacted)
+	}
+
+	for _, secret := range []string{"ZsPqOGVWcNNPlNrD", "gzsyLbekrlYhtuVoMBsnzHuJxgtJeajw", "0F:74:31"} {
+		if strings.Contains(redacted, secret) {
+			t.Errorf("The redacted SDP still contains %s", secret)
+		}
+	}
+
+	// SDPs with the bare `\n` line endings are redacted as well.
+	if redacted := webrtc_ext.RedactSDP("a=ice-pwd:secret\n"); redacted != "a=ice-pwd:<redacted>\n" {
+		t.Errorf("Unexpected redaction: %q", redacted)
+	}
+}
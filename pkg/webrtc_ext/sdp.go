@@ -0,0 +1,273 @@
+package webrtc_ext
+
+import (
+	"strconv"
+	"strings"
+
+	"github.com/pion/webrtc/v3"
+)
+
+// Splits the SDP into the media sections (without the leading `m=`), the session section is skipped.
+func mediaSections(sdp string) []string {
+	sections := strings.Split(strings.ReplaceAll(sdp, "\r\n", "\n"), "\nm=")
+	return sections[1:]
+}
+
+// Returns the IDs of the tracks that the SDP offer of a participant publishes, i.e. the tracks of the
+// media sections that are not rejected and that send the media. The track IDs are taken from the `msid`
+// attributes of the media sections.
+func PublishedTrackIDs(sdp string) map[string]bool {
+	published := make(map[string]bool)
+
+	for _, section := range mediaSections(sdp) {
+		// m=<media> <port> <proto> <fmt> ..., the port 0 means that the section is rejected.
+		if fields := strings.Fields(strings.SplitN(section, "\n", 2)[0]); len(fields) > 1 && fields[1] == "0" {
+			continue
+		}
+
+		var trackID string
+		sending := true
+
+		for _, line := range strings.Split(section, "\n") {
+			switch {
+			case line == "a=recvonly" || line == "a=inactive":
+				sending = false
+			case strings.HasPrefix(line, "a=msid:"):
+				// a=msid:<stream id> <track id>
+				if fields := strings.Fields(strings.TrimPrefix(line, "a=msid:")); len(fields) == 2 {
+					trackID = fields[1]
+				}
+			}
+		}
+
+		if sending && trackID != "" {
+			published[trackID] = true
+		}
+	}
+
+	return published
+}
+
+// Returns the ICE username fragment of the SDP (the first one if the media sections use different ones).
+// The fragment changes on every ICE restart.
+func ICEUsernameFragment(sdp string) string {
+	for _, line := range strings.Split(strings.ReplaceAll(sdp, "\r\n", "\n"), "\n") {
+		if strings.HasPrefix(line, "a=ice-ufrag:") {
+			return strings.TrimPrefix(line, "a=ice-ufrag:")
+		}
+	}
+
+	return ""
+}
+
+// Returns the codecs that the media sections of a given kind declare (`a=rtpmap` along with `a=fmtp`), in the
+// order of their first appearance. The payload types are shared by all media sections of a bundle, so each
+// payload type is returned once.
+func Codecs(sdp string, kind webrtc.RTPCodecType) []webrtc.RTPCodecParameters {
+	codecs := []webrtc.RTPCodecParameters{}
+	found := make(map[webrtc.PayloadType]int)
+
+	for _, section := range mediaSections(sdp) {
+		if !strings.HasPrefix(section, kind.String()+" ") {
+			continue
+		}
+
+		for _, line := range strings.Split(section, "\n") {
+			line = strings.TrimSpace(line)
+
+			switch {
+			case strings.HasPrefix(line, "a=rtpmap:"):
+				// a=rtpmap:<payload type> <encoding name>/<clock rate>[/<channels>]
+				fields := strings.Fields(strings.TrimPrefix(line, "a=rtpmap:"))
+				if len(fields) != 2 {
+					continue
+				}
+
+				payloadType, err := strconv.ParseUint(fields[0], 10, 8)
+				if err != nil {
+					continue
+				}
+				if _, exists := found[webrtc.PayloadType(payloadType)]; exists {
+					continue
+				}
+
+				encoding := strings.Split(fields[1], "/")
+				codec := webrtc.RTPCodecParameters{PayloadType: webrtc.PayloadType(payloadType)}
+				codec.MimeType = kind.String() + "/" + encoding[0]
+				if len(encoding) > 1 {
+					clockRate, _ := strconv.ParseUint(encoding[1], 10, 32)
+					codec.ClockRate = uint32(clockRate)
+				}
+				if len(encoding) > 2 {
+					channels, _ := strconv.ParseUint(encoding[2], 10, 16)
+					codec.Channels = uint16(channels)
+				}
+
+				found[codec.PayloadType] = len(codecs)
+				codecs = append(codecs, codec)
+			case strings.HasPrefix(line, "a=fmtp:"):
+				// a=fmtp:<payload type> <format parameters>
+				fields := strings.SplitN(strings.TrimPrefix(line, "a=fmtp:"), " ", 2)
+				if len(fields) != 2 {
+					continue
+				}
+
+				payloadType, err := strconv.ParseUint(fields[0], 10, 8)
+				if err != nil {
+					continue
+				}
+				if index, exists := found[webrtc.PayloadType(payloadType)]; exists && codecs[index].SDPFmtpLine == "" {
+					codecs[index].SDPFmtpLine = fields[1]
+				}
+			}
+		}
+	}
+
+	return codecs
+}
+
+// Returns the direction of each audio and video section of the SDP by its `mid`. The sections
+// without a direction attribute are `sendrecv` (the default one).
+func MediaDirections(sdp string) map[string]webrtc.RTPTransceiverDirection {
+	directions := make(map[string]webrtc.RTPTransceiverDirection)
+
+	for _, section := range mediaSections(sdp) {
+		if !strings.HasPrefix(section, "audio ") && !strings.HasPrefix(section, "video ") {
+			continue
+		}
+
+		var mid string
+		direction := webrtc.RTPTransceiverDirectionSendrecv
+
+		for _, line := range strings.Split(section, "\n") {
+			if strings.HasPrefix(line, "a=mid:") {
+				mid = strings.TrimPrefix(line, "a=mid:")
+			} else if d := mediaDirection(line); d != webrtc.RTPTransceiverDirection(webrtc.Unknown) {
+				direction = d
+			}
+		}
+
+		if mid != "" {
+			directions[mid] = direction
+		}
+	}
+
+	return directions
+}
+
+// Replaces the directions of the media sections with given `mid`s, the other sections are left as they are.
+// Only the existing direction attributes are replaced (Pion always adds one to the sections it generates).
+func SetMediaDirections(sdp string, directions map[string]webrtc.RTPTransceiverDirection) string {
+	newline := "\n"
+	if strings.Contains(sdp, "\r\n") {
+		newline = "\r\n"
+	}
+
+	lines := strings.Split(strings.ReplaceAll(sdp, "\r\n", "\n"), "\n")
+
+	// The line of the direction attribute of the current section (if any) and the `mid` of it.
+	directionLine, mid := -1, ""
+	apply := func() {
+		if direction, found := directions[mid]; found && directionLine >= 0 {
+			lines[directionLine] = "a=" + direction.String()
+		}
+	}
+
+	for i, line := range lines {
+		switch {
+		case strings.HasPrefix(line, "m="):
+			apply()
+			directionLine, mid = -1, ""
+		case strings.HasPrefix(line, "a=mid:"):
+			mid = strings.TrimPrefix(line, "a=mid:")
+		case mediaDirection(line) != webrtc.RTPTransceiverDirection(webrtc.Unknown):
+			directionLine = i
+		}
+	}
+	apply()
+
+	return strings.Join(lines, newline)
+}
+
+// Returns the direction of the direction attribute or `Unknown` if it's not one.
+func mediaDirection(line string) webrtc.RTPTransceiverDirection {
+	if !strings.HasPrefix(line, "a=") {
+		return webrtc.RTPTransceiverDirection(webrtc.Unknown)
+	}
+
+	return webrtc.NewRTPTransceiverDirection(strings.TrimPrefix(line, "a="))
+}
+
+// Sets the bandwidth of the media sections with given `mid`s (in bits per second), so that the remote peer knows
+// the maximum bitrate of the media that we send: `b=TIAS` (RFC 3890) along with `b=AS` (in kbps) for the peers
+// that don't support it. The existing bandwidth of these sections is replaced, the other sections are left as is.
+func SetMaxBitrates(sdp string, bitrates map[string]uint64) string {
+	if len(bitrates) == 0 {
+		return sdp
+	}
+
+	newline := "\n"
+	if strings.Contains(sdp, "\r\n") {
+		newline = "\r\n"
+	}
+
+	lines := strings.Split(strings.ReplaceAll(sdp, "\r\n", "\n"), "\n")
+	result := make([]string, 0, len(lines)+2*len(bitrates))
+
+	// The lines of the current section, the session section comes first.
+	section := []string{}
+	flush := func() {
+		result = append(result, withMaxBitrate(section, bitrates)...)
+		section = []string{}
+	}
+
+	for _, line := range lines {
+		if strings.HasPrefix(line, "m=") {
+			flush()
+		}
+		section = append(section, line)
+	}
+	flush()
+
+	return strings.Join(result, newline)
+}
+
+// Returns the lines of a media section with the bandwidth lines for its `mid` (if any). The bandwidth
+// lines must follow the connection line (if any) and precede the attributes.
+func withMaxBitrate(section []string, bitrates map[string]uint64) []string {
+	if len(section) == 0 || !strings.HasPrefix(section[0], "m=") {
+		return section
+	}
+
+	var bitrate uint64
+	found := false
+	for _, line := range section {
+		if strings.HasPrefix(line, "a=mid:") {
+			bitrate, found = bitrates[strings.TrimPrefix(line, "a=mid:")]
+		}
+	}
+
+	if !found {
+		return section
+	}
+
+	result := make([]string, 0, len(section)+2)
+	insertAt := 1
+	for _, line := range section {
+		if strings.HasPrefix(line, "b=") {
+			continue
+		}
+
+		result = append(result, line)
+		if strings.HasPrefix(line, "c=") {
+			insertAt = len(result)
+		}
+	}
+
+	bandwidth := []string{
+		"b=AS:" + strconv.FormatUint((bitrate+999)/1000, 10),
+		"b=TIAS:" + strconv.FormatUint(bitrate, 10),
+	}
+
+	return append(result[:insertAt], append(bandwidth, result[insertAt:]...)...)
+}
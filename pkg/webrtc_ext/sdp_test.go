@@ -0,0 +1,188 @@
+package webrtc_ext_test
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/matrix-org/waterfall/pkg/webrtc_ext"
+	"github.com/pion/webrtc/v3"
+)
+
+func TestPublishedTrackIDs(t *testing.T) {
+	sdp := strings.Join([]string{
+		"v=0",
+		"s=-",
+		"m=audio 9 UDP/TLS/RTP/SAVPF 111",
+		"a=mid:0",
+		"a=msid:stream audio-track",
+		"a=sendrecv",
+		"m=video 9 UDP/TLS/RTP/SAVPF 96",
+		"a=mid:1",
+		"a=msid:stream video-track",
+		"a=recvonly",
+		"m=video 0 UDP/TLS/RTP/SAVPF 96",
+		"a=mid:2",
+		"a=msid:screen screen-track",
+		"m=video 9 UDP/TLS/RTP/SAVPF 96",
+		"a=mid:3",
+		"a=msid:camera camera-track",
+		"a=sendonly",
+		"",
+	}, "\r\n")
+
+	expected := map[string]bool{"audio-track": true, "camera-track": true}
+	if published := webrtc_ext.PublishedTrackIDs(sdp); !reflect.DeepEqual(published, expected) {
+		t.Errorf("Expected %v, got %v", expected, published)
+	}
+}
+
+func TestMediaDirections(t *testing.T) {
+	sdp := strings.Join([]string{
+		"v=0",
+		"s=-",
+		"m=audio 9 UDP/TLS/RTP/SAVPF 111",
+		"a=mid:0",
+		"a=sendrecv",
+		"m=video 9 UDP/TLS/RTP/SAVPF 96",
+		"a=mid:1",
+		"a=recvonly",
+		"m=video 9 UDP/TLS/RTP/SAVPF 96",
+		"a=mid:2",
+		"m=application 9 UDP/DTLS/SCTP webrtc-datachannel",
+		"a=mid:3",
+		"",
+	}, "\r\n")
+
+	expected := map[string]webrtc.RTPTransceiverDirection{
+		"0": webrtc.RTPTransceiverDirectionSendrecv,
+		"1": webrtc.RTPTransceiverDirectionRecvonly,
+		"2": webrtc.RTPTransceiverDirectionSendrecv,
+	}
+	if directions := webrtc_ext.MediaDirections(sdp); !reflect.DeepEqual(directions, expected) {
+		t.Errorf("Expected %v, got %v", expected, directions)
+	}
+
+	updated := webrtc_ext.SetMediaDirections(sdp, map[string]webrtc.RTPTransceiverDirection{
+		"0": webrtc.RTPTransceiverDirectionRecvonly,
+		"1": webrtc.RTPTransceiverDirectionSendonly,
+	})
+
+	expected["0"] = webrtc.RTPTransceiverDirectionRecvonly
+	expected["1"] = webrtc.RTPTransceiverDirectionSendonly
+	if directions := webrtc_ext.MediaDirections(updated); !reflect.DeepEqual(directions, expected) {
+		t.Errorf("Expected %v after the update, got %v", expected, directions)
+	}
+
+	if !strings.HasSuffix(updated, "a=mid:3\r\n") || strings.Count(updated, "\r\n") != strings.Count(sdp, "\r\n") {
+		t.Errorf("Expected the rest of the SDP to stay intact, got %q", updated)
+	}
+}
+
+func TestICEUsernameFragment(t *testing.T) {
+	sdp := strings.Join([]string{
+		"v=0",
+		"m=audio 9 UDP/TLS/RTP/SAVPF 111",
+		"a=ice-ufrag:first",
+		"m=video 9 UDP/TLS/RTP/SAVPF 96",
+		"a=ice-ufrag:second",
+		"",
+	}, "\r\n")
+
+	if ufrag := webrtc_ext.ICEUsernameFragment(sdp); ufrag != "first" {
+		t.Errorf("Expected the first ufrag, got %q", ufrag)
+	}
+
+	if ufrag := webrtc_ext.ICEUsernameFragment("v=0\r\n"); ufrag != "" {
+		t.Errorf("Expected no ufrag, got %q", ufrag)
+	}
+}
+
+func TestCodecs(t *testing.T) {
+	sdp := strings.Join([]string{
+		"v=0",
+		"s=-",
+		"m=audio 9 UDP/TLS/RTP/SAVPF 111 0",
+		"a=mid:0",
+		"a=rtpmap:111 opus/48000/2",
+		"a=fmtp:111 minptime=10;useinbandfec=1;stereo=1",
+		"a=rtpmap:0 PCMU/8000",
+		"m=video 9 UDP/TLS/RTP/SAVPF 96",
+		"a=mid:1",
+		"a=rtpmap:96 VP8/90000",
+		"m=audio 9 UDP/TLS/RTP/SAVPF 111",
+		"a=mid:2",
+		"a=rtpmap:111 opus/48000/2",
+		"a=fmtp:111 minptime=10",
+		"",
+	}, "\r\n")
+
+	expected := []webrtc.RTPCodecParameters{
+		{
+			RTPCodecCapability: webrtc.RTPCodecCapability{
+				MimeType:    "audio/opus",
+				ClockRate:   48000,
+				Channels:    2,
+				SDPFmtpLine: "minptime=10;useinbandfec=1;stereo=1",
+			},
+			PayloadType: 111,
+		},
+		{
+			RTPCodecCapability: webrtc.RTPCodecCapability{MimeType: "audio/PCMU", ClockRate: 8000},
+			PayloadType:        0,
+		},
+	}
+
+	if codecs := webrtc_ext.Codecs(sdp, webrtc.RTPCodecTypeAudio); !reflect.DeepEqual(codecs, expected) {
+		t.Errorf("Expected %v, got %v", expected, codecs)
+	}
+}
+
+func TestSetMaxBitrates(t *testing.T) {
+	sdp := strings.Join([]string{
+		"v=0",
+		"s=-",
+		"m=audio 9 UDP/TLS/RTP/SAVPF 111",
+		"c=IN IP4 0.0.0.0",
+		"a=mid:0",
+		"m=video 9 UDP/TLS/RTP/SAVPF 96",
+		"c=IN IP4 0.0.0.0",
+		"b=AS:5000",
+		"a=mid:1",
+		"a=sendonly",
+		"m=video 9 UDP/TLS/RTP/SAVPF 96",
+		"a=mid:2",
+		"a=sendonly",
+		"",
+	}, "\r\n")
+
+	updated := webrtc_ext.SetMaxBitrates(sdp, map[string]uint64{"1": 150_000, "2": 1_500_500})
+
+	expected := strings.Join([]string{
+		"v=0",
+		"s=-",
+		"m=audio 9 UDP/TLS/RTP/SAVPF 111",
+		"c=IN IP4 0.0.0.0",
+		"a=mid:0",
+		"m=video 9 UDP/TLS/RTP/SAVPF 96",
+		"c=IN IP4 0.0.0.0",
+		"b=AS:150",
+		"b=TIAS:150000",
+		"a=mid:1",
+		"a=sendonly",
+		"m=video 9 UDP/TLS/RTP/SAVPF 96",
+		"b=AS:1501",
+		"b=TIAS:1500500",
+		"a=mid:2",
+		"a=sendonly",
+		"",
+	}, "\r\n")
+
+	if updated != expected {
+		t.Errorf("Expected %q, got %q", expected, updated)
+	}
+
+	if unchanged := webrtc_ext.SetMaxBitrates(sdp, nil); unchanged != sdp {
+		t.Errorf("Expected the SDP to stay intact without bitrates, got %q", unchanged)
+	}
+}
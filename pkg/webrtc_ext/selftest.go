@@ -0,0 +1,188 @@
+package webrtc_ext
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pion/rtp"
+	"github.com/pion/webrtc/v3"
+)
+
+// Configuration of the self-test that checks if the peer connections work in the environment, see `SelfTest()`.
+type SelfTestConfig struct {
+	// Run the self-test on startup and refuse to start if it fails.
+	Enabled bool `yaml:"enabled"`
+	// How long (in seconds) the self-test may take at most.
+	Timeout int `yaml:"timeout"`
+}
+
+// Enough to gather the candidates and connect even if the checks of some candidates time out.
+const defaultSelfTestTimeout = 10 * time.Second
+
+func (c SelfTestConfig) timeout() time.Duration {
+	if c.Timeout <= 0 {
+		return defaultSelfTestTimeout
+	}
+
+	return time.Duration(c.Timeout) * time.Second
+}
+
+// The self-test has failed, i.e. the clients would not be able to connect (or to receive media) either.
+var ErrSelfTestFailed = errors.New("WebRTC self-test failed")
+
+// Connects two peer connections created by the factory to each other and sends audio from one to the other.
+// They only learn about the candidates that the clients would get (i.e. the filtered ones with the public IPs),
+// so the test fails if none of them is usable, e.g. due to an overly strict candidate filter or a firewall.
+// Since both ends are the SFU itself, ICE may still connect over the peer-reflexive candidates though,
+// so a wrong public IP is not necessarily detected.
+func (f *PeerConnectionFactory) SelfTest(config SelfTestConfig) error {
+	ctx, cancel := context.WithTimeout(context.Background(), config.timeout())
+	defer cancel()
+
+	sender, err := f.CreatePeerConnection()
+	if err != nil {
+		return fmt.Errorf("%w: failed to create peer connection: %v", ErrSelfTestFailed, err)
+	}
+	defer sender.Close()
+
+	receiver, err := f.CreatePeerConnection()
+	if err != nil {
+		return fmt.Errorf("%w: failed to create peer connection: %v", ErrSelfTestFailed, err)
+	}
+	defer receiver.Close()
+
+	track, err := webrtc.NewTrackLocalStaticRTP(
+		webrtc.RTPCodecCapability{MimeType: webrtc.MimeTypeOpus, ClockRate: 48000, Channels: 2},
+		"self-test",
+		"self-test",
+	)
+	if err != nil {
+		return fmt.Errorf("%w: failed to create track: %v", ErrSelfTestFailed, err)
+	}
+
+	if _, err := sender.AddTrack(track); err != nil {
+		return fmt.Errorf("%w: failed to add track: %v", ErrSelfTestFailed, err)
+	}
+
+	received := make(chan struct{})
+	receiver.OnTrack(func(remote *webrtc.TrackRemote, _ *webrtc.RTPReceiver) {
+		if _, _, err := remote.ReadRTP(); err == nil {
+			close(received)
+		}
+	})
+
+	var once sync.Once
+	connected, failed := make(chan struct{}), make(chan struct{})
+	sender.OnConnectionStateChange(func(state webrtc.PeerConnectionState) {
+		switch state {
+		case webrtc.PeerConnectionStateConnected:
+			once.Do(func() { close(connected) })
+		case webrtc.PeerConnectionStateFailed:
+			once.Do(func() { close(failed) })
+		}
+	})
+
+	candidates, err := f.negotiateLoopback(ctx, sender, receiver)
+	if err != nil {
+		return fmt.Errorf("%w: failed to negotiate: %v", ErrSelfTestFailed, err)
+	}
+
+	if candidates == 0 {
+		return fmt.Errorf("%w: no ICE candidates to connect over, check the public IPs and the candidate filter",
+			ErrSelfTestFailed)
+	}
+
+	select {
+	case <-connected:
+	case <-failed:
+		return fmt.Errorf("%w: failed to connect over %d ICE candidates, check that the public IPs are reachable "+
+			"and the UDP ports are open", ErrSelfTestFailed, candidates)
+	case <-ctx.Done():
+		return fmt.Errorf("%w: timed out connecting over %d ICE candidates, check that the public IPs are reachable "+
+			"and the UDP ports are open", ErrSelfTestFailed, candidates)
+	}
+
+	// The packets are sent until one of them arrives, since the first ones may be sent before the receiver is ready.
+	ticker := time.NewTicker(20 * time.Millisecond)
+	defer ticker.Stop()
+
+	for sequenceNumber := uint16(0); ; sequenceNumber++ {
+		select {
+		case <-received:
+			return nil
+		case <-ctx.Done():
+			return fmt.Errorf("%w: connected, but no media has been received, check that the firewall does not drop "+
+				"the RTP packets", ErrSelfTestFailed)
+		case <-ticker.C:
+			packet := &rtp.Packet{
+				Header:  rtp.Header{Version: 2, SequenceNumber: sequenceNumber, Timestamp: uint32(sequenceNumber) * 960},
+				Payload: []byte{0xf8, 0xff, 0xfe},
+			}
+			if err := track.WriteRTP(packet); err != nil {
+				return fmt.Errorf("%w: failed to send media: %v", ErrSelfTestFailed, err)
+			}
+		}
+	}
+}
+
+// Negotiates the connection without trickling the candidates, with the SDPs carrying the candidates that the
+// clients would get. Returns the number of the candidates that the sender advertises to the receiver.
+func (f *PeerConnectionFactory) negotiateLoopback(
+	ctx context.Context,
+	sender, receiver *webrtc.PeerConnection,
+) (int, error) {
+	offer, err := sender.CreateOffer(nil)
+	if err != nil {
+		return 0, fmt.Errorf("failed to create offer: %w", err)
+	}
+
+	offer.SDP, err = f.setLocalDescription(ctx, sender, offer)
+	if err != nil {
+		return 0, err
+	}
+
+	if err := receiver.SetRemoteDescription(offer); err != nil {
+		return 0, fmt.Errorf("failed to set offer: %w", err)
+	}
+
+	answer, err := receiver.CreateAnswer(nil)
+	if err != nil {
+		return 0, fmt.Errorf("failed to create answer: %w", err)
+	}
+
+	answer.SDP, err = f.setLocalDescription(ctx, receiver, answer)
+	if err != nil {
+		return 0, err
+	}
+
+	if err := sender.SetRemoteDescription(answer); err != nil {
+		return 0, fmt.Errorf("failed to set answer: %w", err)
+	}
+
+	return strings.Count(offer.SDP, "a=candidate:"), nil
+}
+
+// Sets the local description and waits for the candidates to be gathered. Returns the SDP with the
+// candidates that the clients would get.
+func (f *PeerConnectionFactory) setLocalDescription(
+	ctx context.Context,
+	peerConnection *webrtc.PeerConnection,
+	description webrtc.SessionDescription,
+) (string, error) {
+	gathered := webrtc.GatheringCompletePromise(peerConnection)
+	if err := peerConnection.SetLocalDescription(description); err != nil {
+		return "", fmt.Errorf("failed to set local description: %w", err)
+	}
+
+	select {
+	case <-gathered:
+	case <-ctx.Done():
+		return "", fmt.Errorf("timed out gathering ICE candidates")
+	}
+
+	return f.candidateFilter.ApplyToSDP(peerConnection.LocalDescription().SDP), nil
+}
@@ -0,0 +1,33 @@
+package webrtc_ext_test
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/matrix-org/waterfall/pkg/webrtc_ext"
+)
+
+func TestSelfTest(t *testing.T) {
+	factory, err := webrtc_ext.NewPeerConnectionFactory(webrtc_ext.Config{})
+	if err != nil {
+		t.Fatalf("Failed to create factory: %v", err)
+	}
+
+	if err := factory.SelfTest(webrtc_ext.SelfTestConfig{Enabled: true}); err != nil {
+		t.Errorf("Expected the loopback connection to work, got %v", err)
+	}
+
+	// The clients would not get any candidates to connect to.
+	factory, err = webrtc_ext.NewPeerConnectionFactory(webrtc_ext.Config{
+		CandidateFilter: webrtc_ext.CandidateFilterConfig{ExcludeTypes: []string{"host", "srflx", "prflx", "relay"}},
+	})
+	if err != nil {
+		t.Fatalf("Failed to create factory: %v", err)
+	}
+
+	err = factory.SelfTest(webrtc_ext.SelfTestConfig{Enabled: true, Timeout: 1})
+	if !errors.Is(err, webrtc_ext.ErrSelfTestFailed) || !strings.Contains(err.Error(), "candidate filter") {
+		t.Errorf("Expected the self-test to point at the candidate filter, got %v", err)
+	}
+}
@@ -59,6 +59,18 @@ func (s SimulcastLayer) String() string {
 	}
 }
 
+// Parses the layer from its string representation (as returned by `String()`).
+// Returns `SimulcastLayerNone` if the name is unknown.
+func SimulcastLayerFromString(name string) SimulcastLayer {
+	for _, layer := range []SimulcastLayer{SimulcastLayerLow, SimulcastLayerMedium, SimulcastLayerHigh} {
+		if layer.String() == name {
+			return layer
+		}
+	}
+
+	return SimulcastLayerNone
+}
+
 // Basic information about a track.
 type TrackInfo struct {
 	TrackID  string
@@ -14,20 +14,9 @@ func createWebRTCAPI(config Config) (*webrtc.API, error) {
 		return nil, fmt.Errorf("failed to register default codecs: %w", err)
 	}
 
-	// Enable extension headers needed for simulcast (if enabled).
-	if config.EnableSimulcast {
-		for _, extension := range []string{
-			"urn:ietf:params:rtp-hdrext:sdes:mid",
-			"urn:ietf:params:rtp-hdrext:sdes:rtp-stream-id",
-			"urn:ietf:params:rtp-hdrext:sdes:repaired-rtp-stream-id",
-		} {
-			if err := mediaEngine.RegisterHeaderExtension(
-				webrtc.RTPHeaderExtensionCapability{URI: extension},
-				webrtc.RTPCodecTypeVideo,
-			); err != nil {
-				return nil, fmt.Errorf("failed to register simulcast extension: %w", err)
-			}
-		}
+	// Enable extension headers needed to identify the streams (including simulcast layers, if enabled).
+	if err := registerHeaderExtensions(mediaEngine, config.EnableSimulcast); err != nil {
+		return nil, err
 	}
 
 	// Configure the custom IP address of the SFU (if set).
@@ -36,6 +25,9 @@ func createWebRTCAPI(config Config) (*webrtc.API, error) {
 		settingsEngine.SetNAT1To1IPs(config.PublicIPs, webrtc.ICECandidateTypeHost)
 	}
 
+	// All read buffers (for both RTP and RTCP) are sized according to the MTU.
+	settingsEngine.SetReceiveMTU(config.mtu())
+
 	// Create a InterceptorRegistry. This is the user configurable RTP/RTCP
 	// Pipeline. This provides NACKs, RTCP Reports and other features. If
 	// `webrtc.NewPeerConnection` is used, then it is enabled by default. If
@@ -55,3 +47,65 @@ func createWebRTCAPI(config Config) (*webrtc.API, error) {
 
 	return api, nil
 }
+
+// Header extensions that carry the identifiers of the media streams.
+const (
+	sdesMidURI               = "urn:ietf:params:rtp-hdrext:sdes:mid"
+	sdesRTPStreamIDURI       = "urn:ietf:params:rtp-hdrext:sdes:rtp-stream-id"
+	sdesRepairRTPStreamIDURI = "urn:ietf:params:rtp-hdrext:sdes:repaired-rtp-stream-id"
+)
+
+// Header extension that tells the receiver how large its jitter buffer should be.
+const PlayoutDelayURI = "http://www.webrtc.org/experiments/rtp-hdrext/playout-delay"
+
+// Header extension that carries the audio level of the packets, which tells us who is speaking.
+const AudioLevelURI = "urn:ietf:params:rtp-hdrext:ssrc-audio-level"
+
+// Registers the header extensions that we support. Most of them allow to identify the incoming streams: some
+// clients don't declare the SSRCs of the simulcast layers in the SDP and only put the MID and the RID into
+// the header extensions of the packets, in which case Pion relies on these extensions to find out to which
+// track and layer the packets belong. Without them, the simulcast collapses to a single layer without RID.
+func registerHeaderExtensions(mediaEngine *webrtc.MediaEngine, enableSimulcast bool) error {
+	// The MID is used to match the streams to the transceivers for any kind of media.
+	for _, kind := range []webrtc.RTPCodecType{webrtc.RTPCodecTypeAudio, webrtc.RTPCodecTypeVideo} {
+		if err := mediaEngine.RegisterHeaderExtension(
+			webrtc.RTPHeaderExtensionCapability{URI: sdesMidURI},
+			kind,
+		); err != nil {
+			return fmt.Errorf("failed to register MID extension: %w", err)
+		}
+	}
+
+	// The audio level is only read from the incoming audio to find out who is speaking.
+	if err := mediaEngine.RegisterHeaderExtension(
+		webrtc.RTPHeaderExtensionCapability{URI: AudioLevelURI},
+		webrtc.RTPCodecTypeAudio,
+	); err != nil {
+		return fmt.Errorf("failed to register audio level extension: %w", err)
+	}
+
+	// The playout delay is only added to the outgoing video (if enabled for the conference).
+	if err := mediaEngine.RegisterHeaderExtension(
+		webrtc.RTPHeaderExtensionCapability{URI: PlayoutDelayURI},
+		webrtc.RTPCodecTypeVideo,
+		webrtc.RTPTransceiverDirectionSendonly,
+	); err != nil {
+		return fmt.Errorf("failed to register playout delay extension: %w", err)
+	}
+
+	if !enableSimulcast {
+		return nil
+	}
+
+	// The RID identifies the simulcast layer and the repaired RID identifies the retransmissions (RTX) of it.
+	for _, extension := range []string{sdesRTPStreamIDURI, sdesRepairRTPStreamIDURI} {
+		if err := mediaEngine.RegisterHeaderExtension(
+			webrtc.RTPHeaderExtensionCapability{URI: extension},
+			webrtc.RTPCodecTypeVideo,
+		); err != nil {
+			return fmt.Errorf("failed to register simulcast extension: %w", err)
+		}
+	}
+
+	return nil
+}
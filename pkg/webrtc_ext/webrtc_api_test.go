@@ -0,0 +1,173 @@
+package webrtc_ext //nolint:testpackage
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/pion/rtp"
+	"github.com/pion/webrtc/v3"
+)
+
+// An offer of a client that declares the simulcast layers only by their RIDs (no SSRCs).
+const simulcastOffer = `v=0
+o=- 4215775240449105457 2 IN IP4 127.0.0.1
+s=-
+t=0 0
+a=group:BUNDLE 0
+a=msid-semantic: WMS stream
+m=video 9 UDP/TLS/RTP/SAVPF 96
+c=IN IP4 0.0.0.0
+a=rtcp:9 IN IP4 0.0.0.0
+a=ice-ufrag:hCpd
+a=ice-pwd:M7gd3ngZ5lR8Sb0EHKjETYqf
+a=ice-options:trickle
+a=fingerprint:sha-256 0F:74:31:25:CB:A2:13:EC:28:6F:6D:2C:61:FF:5D:C2:BC:B9:DB:3D:98:14:8D:1A:BB:EA:33:0C:A4:60:A8:8E
+a=setup:actpass
+a=mid:0
+a=extmap:4 urn:ietf:params:rtp-hdrext:sdes:mid
+a=extmap:10 urn:ietf:params:rtp-hdrext:sdes:rtp-stream-id
+a=extmap:11 urn:ietf:params:rtp-hdrext:sdes:repaired-rtp-stream-id
+a=sendonly
+a=msid:stream track
+a=rtcp-mux
+a=rtpmap:96 VP8/90000
+a=rid:q send
+a=rid:h send
+a=rid:f send
+a=simulcast:send q;h;f
+`
+
+func TestSimulcastOfferWithRIDExtensions(t *testing.T) {
+	api, err := createWebRTCAPI(Config{EnableSimulcast: true})
+	if err != nil {
+		t.Fatalf("Failed to create API: %v", err)
+	}
+
+	peerConnection, err := api.NewPeerConnection(webrtc.Configuration{})
+	if err != nil {
+		t.Fatalf("Failed to create peer connection: %v", err)
+	}
+	defer peerConnection.Close()
+
+	offer := webrtc.SessionDescription{Type: webrtc.SDPTypeOffer, SDP: strings.ReplaceAll(simulcastOffer, "\n", "\r\n")}
+	if err := peerConnection.SetRemoteDescription(offer); err != nil {
+		t.Fatalf("Failed to set remote description: %v", err)
+	}
+
+	answer, err := peerConnection.CreateAnswer(nil)
+	if err != nil {
+		t.Fatalf("Failed to create answer: %v", err)
+	}
+
+	// The answer must accept the extensions, otherwise the client won't send the RIDs.
+	for _, expected := range []string{
+		sdesMidURI,
+		sdesRTPStreamIDURI,
+		sdesRepairRTPStreamIDURI,
+		"a=rid:q recv",
+		"a=rid:h recv",
+		"a=rid:f recv",
+	} {
+		if !strings.Contains(answer.SDP, expected) {
+			t.Errorf("Expected answer to contain %q:\n%s", expected, answer.SDP)
+		}
+	}
+
+	for _, rid := range []string{"q", "h", "f"} {
+		if layer := RIDToSimulcastLayer(rid); layer == SimulcastLayerNone {
+			t.Errorf("Expected RID %s to map to a simulcast layer", rid)
+		}
+	}
+}
+
+func TestLargePacketsAreNotTruncated(t *testing.T) {
+	// Larger than the default read buffers of Pion, but still fits into the default MTU with the SRTP overhead.
+	const payloadSize = 1450
+
+	sender, err := webrtc.NewPeerConnection(webrtc.Configuration{})
+	if err != nil {
+		t.Fatalf("Failed to create sender: %v", err)
+	}
+	defer sender.Close()
+
+	api, err := createWebRTCAPI(Config{})
+	if err != nil {
+		t.Fatalf("Failed to create API: %v", err)
+	}
+
+	receiver, err := api.NewPeerConnection(webrtc.Configuration{})
+	if err != nil {
+		t.Fatalf("Failed to create receiver: %v", err)
+	}
+	defer receiver.Close()
+
+	track, err := webrtc.NewTrackLocalStaticRTP(webrtc.RTPCodecCapability{MimeType: webrtc.MimeTypeVP8}, "video", "stream")
+	if err != nil {
+		t.Fatalf("Failed to create track: %v", err)
+	}
+
+	if _, err := sender.AddTrack(track); err != nil {
+		t.Fatalf("Failed to add track: %v", err)
+	}
+
+	received := make(chan *rtp.Packet, 1)
+	receiver.OnTrack(func(remote *webrtc.TrackRemote, _ *webrtc.RTPReceiver) {
+		packet, _, err := remote.ReadRTP()
+		if err != nil {
+			t.Errorf("Failed to read packet: %v", err)
+			return
+		}
+		received <- packet
+	})
+
+	// Negotiate without trickling the candidates.
+	offer, err := sender.CreateOffer(nil)
+	if err != nil {
+		t.Fatalf("Failed to create offer: %v", err)
+	}
+	gathered := webrtc.GatheringCompletePromise(sender)
+	if err := sender.SetLocalDescription(offer); err != nil {
+		t.Fatalf("Failed to set local description: %v", err)
+	}
+	<-gathered
+
+	if err := receiver.SetRemoteDescription(*sender.LocalDescription()); err != nil {
+		t.Fatalf("Failed to set remote description: %v", err)
+	}
+	answer, err := receiver.CreateAnswer(nil)
+	if err != nil {
+		t.Fatalf("Failed to create answer: %v", err)
+	}
+	gathered = webrtc.GatheringCompletePromise(receiver)
+	if err := receiver.SetLocalDescription(answer); err != nil {
+		t.Fatalf("Failed to set local description: %v", err)
+	}
+	<-gathered
+
+	if err := sender.SetRemoteDescription(*receiver.LocalDescription()); err != nil {
+		t.Fatalf("Failed to set remote description: %v", err)
+	}
+
+	// Keep sending until the connection is established and the packet gets through.
+	ticker := time.NewTicker(20 * time.Millisecond)
+	defer ticker.Stop()
+	timeout := time.After(10 * time.Second)
+
+	for sequenceNumber := uint16(0); ; sequenceNumber++ {
+		select {
+		case packet := <-received:
+			if len(packet.Payload) != payloadSize {
+				t.Errorf("Expected a payload of %d bytes, got %d", payloadSize, len(packet.Payload))
+			}
+			return
+		case <-ticker.C:
+			track.WriteRTP(&rtp.Packet{ //nolint:errcheck
+				Header:  rtp.Header{Version: 2, SequenceNumber: sequenceNumber},
+				Payload: make([]byte, payloadSize),
+			})
+		case <-timeout:
+			t.Fatal("Timed out waiting for the packet")
+		}
+	}
+}
@@ -16,6 +16,10 @@ var (
 type Config[T any] struct {
 	// The size of the bounded channel.
 	ChannelSize int
+	// The size of the bounded channel for the high-priority tasks (see `SendPriority()`). The high-priority
+	// tasks are processed before the queued regular ones. If zero, there is no separate queue and the
+	// high-priority tasks are queued along with the regular ones (FIFO).
+	PriorityChannelSize int
 	// Timeout after which `OnTimeout` is called.
 	Timeout time.Duration
 	// A closure that is called once `Timeout` is reached.
@@ -28,8 +32,10 @@ type Config[T any] struct {
 // check by the sender if the channel is closed (there is no elegant way to do it in Go).
 type Worker[T any] struct {
 	channel chan<- T
-	mutex   sync.Mutex
-	closed  bool
+	// The high-priority queue, `nil` if the worker is FIFO-only.
+	priority chan<- T
+	mutex    sync.Mutex
+	closed   bool
 }
 
 // Stop the channel unless already closed.
@@ -39,6 +45,9 @@ func (c *Worker[T]) Stop() {
 
 	if !c.closed {
 		close(c.channel)
+		if c.priority != nil {
+			close(c.priority)
+		}
 		c.closed = true
 	}
 }
@@ -46,6 +55,20 @@ func (c *Worker[T]) Stop() {
 // Send a task to the worker. Returns `true` if the task
 // has been sent, `false` if the channel is already closed.
 func (c *Worker[T]) Send(task T) error {
+	return c.send(c.channel, task)
+}
+
+// Send a high-priority task to the worker, so that it's processed before the regular tasks that are already
+// queued. Falls back to `Send()` if the worker has no high-priority queue (see `Config.PriorityChannelSize`).
+func (c *Worker[T]) SendPriority(task T) error {
+	if c.priority == nil {
+		return c.Send(task)
+	}
+
+	return c.send(c.priority, task)
+}
+
+func (c *Worker[T]) send(channel chan<- T, task T) error {
 	c.mutex.Lock()
 	defer c.mutex.Unlock()
 
@@ -54,7 +77,7 @@ func (c *Worker[T]) Send(task T) error {
 		// We don't want to block here since it's the whole point of this
 		// component (that the CPU bound tasks are handled by the worker).
 		select {
-		case c.channel <- task:
+		case channel <- task:
 			return nil
 		default:
 			return ErrWorkerTooBusy
@@ -67,25 +90,81 @@ func (c *Worker[T]) Send(task T) error {
 
 // Starts a worker that periodically (specified by the configuration) executes a `c.OnTimeout` closure if
 // no tasks have been received on a channel for a `c.Timeout`. The worker will stop once the channel is closed,
-// i.e. once the user calls `Stop` explicitly.
+// i.e. once the user calls `Stop` explicitly, after processing the tasks that are still queued.
 func StartWorker[T any](c Config[T]) *Worker[T] {
 	// The channel that will be used to inform the worker about the reception of a task.
 	// The worker will be stopped once the channel is closed.
 	incoming := make(chan T, c.ChannelSize)
 
+	// The high-priority tasks are queued separately (if enabled). A `nil` channel is never ready, so
+	// the FIFO-only worker never selects it.
+	var priority chan T
+	if c.PriorityChannelSize > 0 {
+		priority = make(chan T, c.PriorityChannelSize)
+	}
+
 	go func() {
+		// We use a single timer for the whole lifetime of the worker and stop it once the worker
+		// is done. Using `time.After()` in the loop would create a new timer on each iteration that
+		// is not garbage collected until it fires, which is a lot of timers given our timeouts.
+		timer := time.NewTimer(c.Timeout)
+		defer timer.Stop()
+
+		// Once the worker is stopped, the tasks that have already been accepted are still processed
+		// (the high-priority ones first). Both channels are closed by `Stop()`, so this does not block.
+		drain := func() {
+			if priority != nil {
+				for task := range priority {
+					c.OnTask(task)
+				}
+			}
+
+			for task := range incoming {
+				c.OnTask(task)
+			}
+		}
+
 		for {
 			select {
-			case task, ok := <-incoming:
+			// Take the high-priority tasks first, so that they don't wait behind the queued regular ones.
+			case task, ok := <-priority:
 				if !ok {
+					drain()
 					return
 				}
 				c.OnTask(task)
-			case <-time.After(c.Timeout):
-				c.OnTimeout()
+			default:
+				select {
+				case task, ok := <-priority:
+					if !ok {
+						drain()
+						return
+					}
+					c.OnTask(task)
+				case task, ok := <-incoming:
+					if !ok {
+						drain()
+						return
+					}
+					c.OnTask(task)
+				case <-timer.C:
+					c.OnTimeout()
+					timer.Reset(c.Timeout)
+					continue
+				}
 			}
+
+			// Restart the timer, draining it if it fired while we were processing the task.
+			if !timer.Stop() {
+				select {
+				case <-timer.C:
+				default:
+				}
+			}
+
+			timer.Reset(c.Timeout)
 		}
 	}()
 
-	return &Worker[T]{incoming, sync.Mutex{}, false}
+	return &Worker[T]{channel: incoming, priority: priority}
 }
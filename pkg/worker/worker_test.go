@@ -22,3 +22,141 @@ func BenchmarkWorker(b *testing.B) {
 
 	w.Stop()
 }
+
+func TestWorkerPriority(t *testing.T) {
+	processed := make(chan string, 4)
+	started, release := make(chan struct{}), make(chan struct{})
+
+	w := worker.StartWorker(worker.Config[string]{
+		ChannelSize:         4,
+		PriorityChannelSize: 1,
+		Timeout:             time.Minute,
+		OnTimeout:           func() {},
+		OnTask: func(task string) {
+			// Hold the worker busy with the first task until all other tasks are queued.
+			if task == "busy" {
+				close(started)
+				<-release
+			}
+			processed <- task
+		},
+	})
+	defer w.Stop()
+
+	if err := w.Send("busy"); err != nil {
+		t.Fatalf("Failed to send a task: %v", err)
+	}
+	<-started
+
+	for _, task := range []string{"low 1", "low 2"} {
+		if err := w.Send(task); err != nil {
+			t.Fatalf("Failed to send a task: %v", err)
+		}
+	}
+
+	if err := w.SendPriority("high"); err != nil {
+		t.Fatalf("Failed to send a high-priority task: %v", err)
+	}
+
+	close(release)
+
+	expected := []string{"busy", "high", "low 1", "low 2"}
+	for _, task := range expected {
+		select {
+		case got := <-processed:
+			if got != task {
+				t.Fatalf("Expected %q to be processed, got %q", task, got)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("Timed out waiting for %q to be processed", task)
+		}
+	}
+}
+
+func TestWorkerPriorityFallsBackToFIFO(t *testing.T) {
+	processed := make(chan string, 2)
+	release := make(chan struct{})
+
+	w := worker.StartWorker(worker.Config[string]{
+		ChannelSize: 3,
+		Timeout:     time.Minute,
+		OnTimeout:   func() {},
+		OnTask: func(task string) {
+			if task == "busy" {
+				<-release
+				return
+			}
+			processed <- task
+		},
+	})
+	defer w.Stop()
+
+	if err := w.Send("busy"); err != nil {
+		t.Fatalf("Failed to send a task: %v", err)
+	}
+	if err := w.Send("low"); err != nil {
+		t.Fatalf("Failed to send a task: %v", err)
+	}
+	if err := w.SendPriority("high"); err != nil {
+		t.Fatalf("Failed to send a high-priority task: %v", err)
+	}
+
+	close(release)
+
+	for _, task := range []string{"low", "high"} {
+		if got := <-processed; got != task {
+			t.Fatalf("Expected %q to be processed, got %q", task, got)
+		}
+	}
+}
+
+func TestWorkerProcessesQueuedTasksAfterStop(t *testing.T) {
+	processed := make(chan string, 4)
+	started, release := make(chan struct{}), make(chan struct{})
+
+	w := worker.StartWorker(worker.Config[string]{
+		ChannelSize:         4,
+		PriorityChannelSize: 1,
+		Timeout:             time.Minute,
+		OnTimeout:           func() {},
+		OnTask: func(task string) {
+			if task == "busy" {
+				close(started)
+				<-release
+			}
+			processed <- task
+		},
+	})
+
+	if err := w.Send("busy"); err != nil {
+		t.Fatalf("Failed to send a task: %v", err)
+	}
+	<-started
+
+	for _, task := range []string{"low 1", "low 2"} {
+		if err := w.Send(task); err != nil {
+			t.Fatalf("Failed to send a task: %v", err)
+		}
+	}
+	if err := w.SendPriority("high"); err != nil {
+		t.Fatalf("Failed to send a high-priority task: %v", err)
+	}
+
+	// The worker is stopped while the tasks are still queued.
+	w.Stop()
+	if err := w.Send("late"); err == nil {
+		t.Error("Expected no tasks to be accepted once the worker is stopped")
+	}
+	close(release)
+
+	for _, task := range []string{"busy", "high", "low 1", "low 2"} {
+		select {
+		case got := <-processed:
+			if got != task {
+				t.Fatalf("Expected %q to be processed, got %q", task, got)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("Expected the queued %q to be processed after the worker was stopped", task)
+		}
+	}
+}